@@ -0,0 +1,175 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"testing"
+)
+
+// benchBlockBytes returns the serialized bytes of a large block made up of the
+// coinbase of block one followed by many copies of a multi-input and
+// multi-output transaction and a witness transaction.
+func benchBlockBytes(b *testing.B) []byte {
+	block := btcwire.NewMsgBlock(&blockOne.Header)
+	block.AddTransaction(blockOne.Transactions[0])
+	for i := 0; i < 1000; i++ {
+		block.AddTransaction(multiTx)
+		block.AddTransaction(witnessTx)
+	}
+
+	var buf bytes.Buffer
+	err := block.BtcEncode(&buf, btcwire.ProtocolVersion)
+	if err != nil {
+		b.Fatalf("BtcEncode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// BenchmarkDecodeBlock performs a benchmark on how long it takes to decode a
+// large block.
+func BenchmarkDecodeBlock(b *testing.B) {
+	buf := benchBlockBytes(b)
+	b.SetBytes(int64(len(buf)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	r := bytes.NewReader(buf)
+	for i := 0; i < b.N; i++ {
+		r.Reset(buf)
+		var block btcwire.MsgBlock
+		err := block.BtcDecode(r, btcwire.ProtocolVersion)
+		if err != nil {
+			b.Fatalf("BtcDecode: %v", err)
+		}
+	}
+}
+
+// BenchmarkDecodeTx performs a benchmark on how long it takes to decode a
+// transaction.
+func BenchmarkDecodeTx(b *testing.B) {
+	var buf bytes.Buffer
+	err := multiTx.BtcEncode(&buf, btcwire.ProtocolVersion)
+	if err != nil {
+		b.Fatalf("BtcEncode: %v", err)
+	}
+	encoded := buf.Bytes()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	r := bytes.NewReader(encoded)
+	for i := 0; i < b.N; i++ {
+		r.Reset(encoded)
+		var tx btcwire.MsgTx
+		err := tx.BtcDecode(r, btcwire.ProtocolVersion)
+		if err != nil {
+			b.Fatalf("BtcDecode: %v", err)
+		}
+	}
+}
+
+// BenchmarkEncodeBlock performs a benchmark on how long it takes to encode a
+// large block.
+func BenchmarkEncodeBlock(b *testing.B) {
+	var block btcwire.MsgBlock
+	err := block.BtcDecode(bytes.NewReader(benchBlockBytes(b)),
+		btcwire.ProtocolVersion)
+	if err != nil {
+		b.Fatalf("BtcDecode: %v", err)
+	}
+	b.SetBytes(int64(block.SerializeSize()))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		err := block.BtcEncode(&buf, btcwire.ProtocolVersion)
+		if err != nil {
+			b.Fatalf("BtcEncode: %v", err)
+		}
+	}
+}
+
+// BenchmarkEncodeTx performs a benchmark on how long it takes to encode a
+// transaction.
+func BenchmarkEncodeTx(b *testing.B) {
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		err := multiTx.BtcEncode(&buf, btcwire.ProtocolVersion)
+		if err != nil {
+			b.Fatalf("BtcEncode: %v", err)
+		}
+	}
+}
+
+// BenchmarkReadBlockHeader performs a benchmark on how long it takes to read a
+// block header from a reader which does not carry a decode context, so the
+// element readers can't use its scratch buffer.
+func BenchmarkReadBlockHeader(b *testing.B) {
+	buf := blockOneBytes[:81]
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	r := bytes.NewReader(buf)
+	for i := 0; i < b.N; i++ {
+		r.Reset(buf)
+		var bh btcwire.BlockHeader
+		err := btcwire.TstReadBlockHeader(r, btcwire.ProtocolVersion, &bh)
+		if err != nil {
+			b.Fatalf("readBlockHeader: %v", err)
+		}
+	}
+}
+
+// BenchmarkTxSha performs a benchmark on how long it takes to hash a
+// transaction.
+func BenchmarkTxSha(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		multiTx.TxSha(btcwire.ProtocolVersion)
+	}
+}
+
+// BenchmarkWTxSha performs a benchmark on how long it takes to hash a
+// transaction including its witness data.
+func BenchmarkWTxSha(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		witnessTx.WTxSha()
+	}
+}
+
+// BenchmarkBlockSha performs a benchmark on how long it takes to hash a block
+// header.
+func BenchmarkBlockSha(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		blockOne.Header.BlockSha(btcwire.ProtocolVersion)
+	}
+}
+
+// BenchmarkWriteVerAck performs a benchmark on how long it takes to write a
+// verack message, which is representative of messages without a payload.
+func BenchmarkWriteVerAck(b *testing.B) {
+	msg := btcwire.NewMsgVerAck()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var buf bytes.Buffer
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		err := btcwire.WriteMessage(&buf, msg, btcwire.ProtocolVersion,
+			btcwire.MainNet)
+		if err != nil {
+			b.Fatalf("WriteMessage: %v", err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+// BlockBuilder builds up a block by adding transactions for as long as the
+// block stays within the limits of MaxBlockPayload, which only applies to its
+// size without witness data, and MaxBlockWeight.  It is
+// mainly intended for mining code which adds candidate transactions to a block
+// until it is full.
+//
+// The serialized size and weight of the block are tracked as transactions are
+// added so only the size of each candidate transaction needs to be computed
+// rather than the size of the whole block.
+//
+// NOTE: The transactions must not be modified while the builder is in use
+// since the tracked sizes would no longer match the block.
+type BlockBuilder struct {
+	block        MsgBlock
+	size         int // Serialized size with witness data
+	strippedSize int // Serialized size without witness data
+}
+
+// NewBlockBuilder returns a new BlockBuilder for a block with the passed header
+// and coinbase transaction.  The coinbase is always the first transaction of
+// the block, so it is included in the budget before any other transaction can
+// be added.  Any transactions in the passed header are ignored.
+func NewBlockBuilder(header *BlockHeader, coinbase *MsgTx) *BlockBuilder {
+	b := BlockBuilder{
+		block: MsgBlock{Header: *header},
+		// Block header without the tx count varint.
+		size:         blockHashLen,
+		strippedSize: blockHashLen,
+	}
+	b.block.Header.TxnCount = 0
+	b.addTx(coinbase, VarIntSerializeSize(1))
+	return &b
+}
+
+// TryAddTx adds the passed transaction to the block if doing so does not make
+// the size of the block without witness data exceed MaxBlockPayload or its
+// weight exceed MaxBlockWeight.  Since witness data is not counted against
+// MaxBlockPayload, the serialized size of a block with witness data may exceed
+// it.  It returns whether or not the transaction was added.
+func (b *BlockBuilder) TryAddTx(tx *MsgTx) bool {
+	// Account for the tx count varint growing in size.
+	count := uint64(len(b.block.Transactions))
+	countDelta := VarIntSerializeSize(count+1) - VarIntSerializeSize(count)
+
+	size := b.size + countDelta + tx.SerializeSize()
+	strippedSize := b.strippedSize + countDelta + tx.SerializeSizeStripped()
+	if strippedSize > MaxBlockPayload ||
+		blockWeight(size, strippedSize) > MaxBlockWeight {
+
+		return false
+	}
+
+	b.addTx(tx, countDelta)
+	return true
+}
+
+// addTx adds the passed transaction to the block and updates the tracked sizes
+// using countDelta as the growth of the tx count varint.
+func (b *BlockBuilder) addTx(tx *MsgTx, countDelta int) {
+	b.block.AddTransaction(tx)
+	b.size += countDelta + tx.SerializeSize()
+	b.strippedSize += countDelta + tx.SerializeSizeStripped()
+}
+
+// Block returns the block built so far.  The returned block shares its
+// transactions with the builder.
+func (b *BlockBuilder) Block() *MsgBlock {
+	block := b.block
+	return &block
+}
+
+// SerializeSize returns the number of bytes it would take to serialize the
+// block built so far with MsgBlock.Serialize.
+func (b *BlockBuilder) SerializeSize() int {
+	return b.size
+}
+
+// Weight returns the weight as defined by BIP0141 of the block built so far.
+func (b *BlockBuilder) Weight() int {
+	return blockWeight(b.size, b.strippedSize)
+}
+
+// blockWeight returns the weight of a block with the passed serialized sizes
+// with and without witness data.
+func blockWeight(size, strippedSize int) int {
+	return strippedSize*(WitnessScaleFactor-1) + size
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"reflect"
+	"testing"
+)
+
+// TestBlockBuilder tests the BlockBuilder API.
+func TestBlockBuilder(t *testing.T) {
+	coinbase := blockOne.Transactions[0]
+	builder := btcwire.NewBlockBuilder(&blockOne.Header, coinbase)
+
+	// Ensure the coinbase is included in the initial budget.
+	block := builder.Block()
+	if !reflect.DeepEqual(block, &blockOne) {
+		t.Errorf("Block: wrong block - got %v, want %v",
+			spew.Sdump(block), spew.Sdump(&blockOne))
+	}
+	if size := builder.SerializeSize(); size != len(blockOneBytes) {
+		t.Errorf("SerializeSize: wrong size - got %d, want %d", size,
+			len(blockOneBytes))
+	}
+
+	// Ensure witness transactions are added and weighed properly.
+	if !builder.TryAddTx(witnessTx) {
+		t.Errorf("TryAddTx: witness transaction was not added")
+	}
+	serialized, err := builder.Block().Bytes()
+	if err != nil {
+		t.Errorf("Bytes: %v", err)
+	}
+	if size := builder.SerializeSize(); size != len(serialized) {
+		t.Errorf("SerializeSize: wrong size - got %d, want %d", size,
+			len(serialized))
+	}
+	wantWeight := 3*(len(serialized)-9) + len(serialized)
+	if weight := builder.Weight(); weight != wantWeight {
+		t.Errorf("Weight: wrong weight - got %d, want %d", weight,
+			wantWeight)
+	}
+
+	// Add large transactions until the block is full.  Since they don't
+	// have witness data, the weight limit is reached before the size
+	// limit.  The tx count varint grows by 2 bytes once there are more
+	// than 252 transactions, which adds 8 to the weight.
+	bigTx := btcwire.NewMsgTx()
+	bigTx.AddTxIn(btcwire.NewTxIn(btcwire.NewOutPoint(
+		&btcwire.ShaHash{0x01}, 0), make([]byte, 3000)))
+	bigTx.AddTxOut(btcwire.NewTxOut(1000, []byte{0x51}))
+	wantAdded := (btcwire.MaxBlockWeight - builder.Weight() - 8) /
+		(btcwire.WitnessScaleFactor * bigTx.SerializeSize())
+	numAdded := 0
+	for builder.TryAddTx(bigTx) {
+		numAdded++
+	}
+	if numAdded != wantAdded {
+		t.Errorf("TryAddTx: wrong number of transactions added - got "+
+			"%d, want %d", numAdded, wantAdded)
+	}
+
+	// Ensure the tracked size matches the serialized block, including the
+	// larger tx count varint, and is within the limits.
+	block = builder.Block()
+	if len(block.Transactions) != numAdded+2 ||
+		block.Header.TxnCount != uint64(numAdded+2) {
+
+		t.Errorf("Block: wrong number of transactions - got %d (count "+
+			"%d), want %d", len(block.Transactions),
+			block.Header.TxnCount, numAdded+2)
+	}
+	serialized, err = block.Bytes()
+	if err != nil {
+		t.Errorf("Bytes: %v", err)
+	}
+	if size := builder.SerializeSize(); size != len(serialized) {
+		t.Errorf("SerializeSize: wrong size - got %d, want %d", size,
+			len(serialized))
+	}
+	if size := builder.SerializeSize(); size > btcwire.MaxBlockPayload {
+		t.Errorf("SerializeSize: size %d exceeds max block payload %d",
+			size, btcwire.MaxBlockPayload)
+	}
+	if weight := builder.Weight(); weight > btcwire.MaxBlockWeight {
+		t.Errorf("Weight: weight %d exceeds max block weight %d",
+			weight, btcwire.MaxBlockWeight)
+	}
+
+	// Ensure a smaller transaction which still fits is added.
+	if !builder.TryAddTx(witnessTx) {
+		t.Errorf("TryAddTx: transaction which fits was not added")
+	}
+}
+
+// TestBlockBuilderWitness ensures blocks with large witnesses are filled up to
+// the weight limit even when their serialized size exceeds MaxBlockPayload.
+func TestBlockBuilderWitness(t *testing.T) {
+	builder := btcwire.NewBlockBuilder(&blockOne.Header,
+		blockOne.Transactions[0])
+
+	witnessHeavyTx := btcwire.NewMsgTx()
+	txIn := btcwire.NewTxIn(btcwire.NewOutPoint(&btcwire.ShaHash{0x01}, 0),
+		nil)
+	txIn.Witness = btcwire.TxWitness{make([]byte, 100000)}
+	witnessHeavyTx.AddTxIn(txIn)
+	witnessHeavyTx.AddTxOut(btcwire.NewTxOut(1000, []byte{0x51}))
+	txWeight := (btcwire.WitnessScaleFactor-1)*
+		witnessHeavyTx.SerializeSizeStripped() + witnessHeavyTx.SerializeSize()
+
+	numAdded := 0
+	for builder.TryAddTx(witnessHeavyTx) {
+		numAdded++
+	}
+
+	// Ensure the weight budget is used up rather than stopping at the
+	// size limit, which only applies to the size without witness data.
+	if size := builder.SerializeSize(); size <= btcwire.MaxBlockPayload {
+		t.Errorf("SerializeSize: size %d does not exceed max block "+
+			"payload %d after adding %d transactions", size,
+			btcwire.MaxBlockPayload, numAdded)
+	}
+	weight := builder.Weight()
+	if weight > btcwire.MaxBlockWeight {
+		t.Errorf("Weight: weight %d exceeds max block weight %d",
+			weight, btcwire.MaxBlockWeight)
+	}
+	if weight+txWeight <= btcwire.MaxBlockWeight {
+		t.Errorf("Weight: weight %d leaves room for another "+
+			"transaction of weight %d", weight, txWeight)
+	}
+
+	// Ensure the tracked size matches the serialized block.
+	serialized, err := builder.Block().Bytes()
+	if err != nil {
+		t.Errorf("Bytes: %v", err)
+	}
+	if size := builder.SerializeSize(); size != len(serialized) {
+		t.Errorf("SerializeSize: wrong size - got %d, want %d", size,
+			len(serialized))
+	}
+}
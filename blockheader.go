@@ -5,7 +5,7 @@
 package btcwire
 
 import (
-	"bytes"
+	"fmt"
 	"io"
 	"time"
 )
@@ -51,16 +51,14 @@ const blockHashLen = 80
 
 // BlockSha computes the block identifier hash for the given block header.
 func (h *BlockHeader) BlockSha(pver uint32) (ShaHash, error) {
-	// Encode the header and run double sha256 everything prior to the
-	// number of transactions.  Ignore the error returns since there is no
-	// way the encode could fail except being out of memory which would
-	// cause a run-time panic.  Also, SetBytes can't fail here due to the
-	// fact DoubleSha256 always returns a []byte of the right size
-	// regardless of input.
-	var buf bytes.Buffer
-	var sha ShaHash
-	_ = writeBlockHeader(&buf, pver, h)
-	_ = sha.SetBytes(DoubleSha256(buf.Bytes()[0:blockHashLen]))
+	// Encode the header directly into the hasher and run double sha256
+	// everything prior to the number of transactions.  Ignore the error
+	// returns since there is no way the encode could fail except being out
+	// of memory which would cause a run-time panic.
+	hasher := borrowShaHasher()
+	_ = writeBlockHeaderFields(hasher, pver, h)
+	sha := hasher.doubleSum()
+	returnShaHasher(hasher)
 
 	// Even though this function can't currently fail, it still returns
 	// a potential error to help future proof the API should a failure
@@ -68,6 +66,34 @@ func (h *BlockHeader) BlockSha(pver uint32) (ShaHash, error) {
 	return sha, nil
 }
 
+// String returns the block header in human-readable form.
+func (h *BlockHeader) String() string {
+	hash, _ := h.BlockSha(ProtocolVersion)
+	return fmt.Sprintf("hash=%v prev=%v merkle=%v time=%v bits=%08x "+
+		"nonce=%d", hash, h.PrevBlock, h.MerkleRoot,
+		h.Timestamp.UTC().Format(time.RFC3339), h.Bits, h.Nonce)
+}
+
+// Serialize encodes the block header to w using the canonical format used for
+// long-term storage such as a database, as opposed to the wire encoding used
+// by the block and headers messages which may depend on the protocol version.
+// The encoding includes the variable length transaction count.
+func (h *BlockHeader) Serialize(w io.Writer) error {
+	return writeBlockHeader(w, storageVersion, h)
+}
+
+// Deserialize decodes a block header from r into the receiver using the
+// canonical format produced by Serialize.
+func (h *BlockHeader) Deserialize(r io.Reader) error {
+	return readBlockHeader(r, storageVersion, h)
+}
+
+// SerializeSize returns the number of bytes it would take to serialize the
+// block header, including the variable length transaction count.
+func (h *BlockHeader) SerializeSize() int {
+	return blockHashLen + VarIntSerializeSize(h.TxnCount)
+}
+
 // NewBlockHeader returns a new BlockHeader using the provided previous block
 // hash, merkle root hash, difficulty bits, and nonce used to generate the
 // block with defaults for the remaining fields.
@@ -85,15 +111,37 @@ func NewBlockHeader(prevHash *ShaHash, merkleRootHash *ShaHash, bits uint32,
 	}
 }
 
+// readBlockHeaderFields reads the fields of a bitcoin block header which are
+// used when computing the block sha, which is every field except the number of
+// transactions, from r.
+func readBlockHeaderFields(r io.Reader, pver uint32, bh *BlockHeader) error {
+	err := readElements(r, &bh.Version, &bh.PrevBlock, &bh.MerkleRoot)
+	if err != nil {
+		return err
+	}
+
+	// The timestamp is read into a local integer without going through
+	// readElement, which would require it to be allocated.
+	sec, err := readUint(r, 4)
+	if err != nil {
+		return err
+	}
+	bh.Timestamp = time.Unix(int64(sec), 0)
+
+	err = readElements(r, &bh.Bits, &bh.Nonce)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // readBlockHeader reads a bitcoin block header from r.
 func readBlockHeader(r io.Reader, pver uint32, bh *BlockHeader) error {
-	var sec uint32
-	err := readElements(r, &bh.Version, &bh.PrevBlock, &bh.MerkleRoot, &sec,
-		&bh.Bits, &bh.Nonce)
+	err := readBlockHeaderFields(r, pver, bh)
 	if err != nil {
 		return err
 	}
-	bh.Timestamp = time.Unix(int64(sec), 0)
 
 	count, err := readVarInt(r, pver)
 	if err != nil {
@@ -104,11 +152,28 @@ func readBlockHeader(r io.Reader, pver uint32, bh *BlockHeader) error {
 	return nil
 }
 
+// writeBlockHeaderFields writes the fields of a bitcoin block header which are
+// used when computing the block sha, which is every field except the number of
+// transactions, to w.
+func writeBlockHeaderFields(w io.Writer, pver uint32, bh *BlockHeader) error {
+	err := writeElements(w, &bh.Version, &bh.PrevBlock, &bh.MerkleRoot)
+	if err != nil {
+		return err
+	}
+
+	// The timestamp is written from a local integer without going through
+	// writeElement, which would require it to be allocated.
+	err = writeUint(w, uint64(uint32(bh.Timestamp.Unix())), 4)
+	if err != nil {
+		return err
+	}
+
+	return writeElements(w, &bh.Bits, &bh.Nonce)
+}
+
 // writeBlockHeader writes a bitcoin block header to w.
 func writeBlockHeader(w io.Writer, pver uint32, bh *BlockHeader) error {
-	sec := uint32(bh.Timestamp.Unix())
-	err := writeElements(w, bh.Version, bh.PrevBlock, bh.MerkleRoot,
-		sec, bh.Bits, bh.Nonce)
+	err := writeBlockHeaderFields(w, pver, bh)
 	if err != nil {
 		return err
 	}
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"github.com/conformal/btcwire"
 	"github.com/davecgh/go-spew/spew"
+	"io"
 	"reflect"
 	"testing"
 	"time"
@@ -45,6 +46,19 @@ func TestBlockHeader(t *testing.T) {
 	}
 }
 
+// TestBlockHeaderStringer tests the stringized output for block headers.
+func TestBlockHeaderStringer(t *testing.T) {
+	want := "hash=00000000839a8e6886ab5951d76f411475428afc90947ee320161bbf18eb6048 " +
+		"prev=000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f " +
+		"merkle=0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098 " +
+		"time=2009-01-09T02:54:25Z bits=1d00ffff nonce=2573394689"
+
+	result := blockOne.Header.String()
+	if result != want {
+		t.Errorf("String\n got: %s want: %s", result, want)
+	}
+}
+
 // TestBlockHeaderWire tests the BlockHeader wire encode and decode for various
 // protocol versions.
 func TestBlockHeaderWire(t *testing.T) {
@@ -142,6 +156,11 @@ func TestBlockHeaderWire(t *testing.T) {
 				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
 			continue
 		}
+		if size := test.in.SerializeSize(); size != len(test.buf) {
+			t.Errorf("SerializeSize #%d: wrong size - got %d, want %d",
+				i, size, len(test.buf))
+			continue
+		}
 
 		// Decode the block header from wire format.
 		var bh btcwire.BlockHeader
@@ -158,3 +177,137 @@ func TestBlockHeaderWire(t *testing.T) {
 		}
 	}
 }
+
+// TestBlockHeaderSerialize tests BlockHeader serialize and deserialize.
+func TestBlockHeaderSerialize(t *testing.T) {
+	nonce := uint32(123123) // 0x1e0f3
+
+	// baseBlockHdr is used in the various tests as a baseline BlockHeader.
+	hash := btcwire.GenesisHash
+	merkleHash := btcwire.GenesisMerkleRoot
+	bits := uint32(0x1d00ffff)
+	baseBlockHdr := &btcwire.BlockHeader{
+		Version:    1,
+		PrevBlock:  hash,
+		MerkleRoot: merkleHash,
+		Timestamp:  time.Unix(0x495fab29, 0), // 2009-01-03 12:15:05 -0600 CST
+		Bits:       bits,
+		Nonce:      nonce,
+		TxnCount:   0,
+	}
+
+	// baseBlockHdrEncoded is the serialized bytes of baseBlockHdr.
+	baseBlockHdrEncoded := []byte{
+		0x01, 0x00, 0x00, 0x00, // Version 1
+		0x6f, 0xe2, 0x8c, 0x0a, 0xb6, 0xf1, 0xb3, 0x72,
+		0xc1, 0xa6, 0xa2, 0x46, 0xae, 0x63, 0xf7, 0x4f,
+		0x93, 0x1e, 0x83, 0x65, 0xe1, 0x5a, 0x08, 0x9c,
+		0x68, 0xd6, 0x19, 0x00, 0x00, 0x00, 0x00, 0x00, // PrevBlock
+		0x3b, 0xa3, 0xed, 0xfd, 0x7a, 0x7b, 0x12, 0xb2,
+		0x7a, 0xc7, 0x2c, 0x3e, 0x67, 0x76, 0x8f, 0x61,
+		0x7f, 0xc8, 0x1b, 0xc3, 0x88, 0x8a, 0x51, 0x32,
+		0x3a, 0x9f, 0xb8, 0xaa, 0x4b, 0x1e, 0x5e, 0x4a, // MerkleRoot
+		0x29, 0xab, 0x5f, 0x49, // Timestamp
+		0xff, 0xff, 0x00, 0x1d, // Bits
+		0xf3, 0xe0, 0x01, 0x00, // Nonce
+		0x00, // TxnCount Varint
+	}
+
+	// countBlockHdr has a transaction count which needs a 3 byte varint.
+	countBlockHdr := *baseBlockHdr
+	countBlockHdr.TxnCount = 0xfd
+	countBlockHdrEncoded := append(baseBlockHdrEncoded[:80:80],
+		0xfd, 0xfd, 0x00)
+
+	tests := []struct {
+		in  *btcwire.BlockHeader // Data to encode
+		out *btcwire.BlockHeader // Expected decoded data
+		buf []byte               // Serialized data
+	}{
+		{baseBlockHdr, baseBlockHdr, baseBlockHdrEncoded},
+		{&countBlockHdr, &countBlockHdr, countBlockHdrEncoded},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Serialize the block header.
+		var buf bytes.Buffer
+		err := test.in.Serialize(&buf)
+		if err != nil {
+			t.Errorf("Serialize #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("Serialize #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+		if size := test.in.SerializeSize(); size != len(test.buf) {
+			t.Errorf("SerializeSize #%d: wrong size - got %d, want %d",
+				i, size, len(test.buf))
+			continue
+		}
+
+		// Deserialize the block header.
+		var bh btcwire.BlockHeader
+		rbuf := bytes.NewReader(test.buf)
+		err = bh.Deserialize(rbuf)
+		if err != nil {
+			t.Errorf("Deserialize #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&bh, test.out) {
+			t.Errorf("Deserialize #%d\n got: %s want: %s", i,
+				spew.Sdump(&bh), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestBlockHeaderSerializeErrors performs negative tests against BlockHeader
+// serialize and deserialize to confirm error paths work correctly.
+func TestBlockHeaderSerializeErrors(t *testing.T) {
+	bh := &btcwire.BlockHeader{TxnCount: 0xfd}
+	var encoded bytes.Buffer
+	err := bh.Serialize(&encoded)
+	if err != nil {
+		t.Fatalf("Serialize error %v", err)
+	}
+
+	tests := []struct {
+		max      int   // Max size of fixed buffer to induce errors
+		writeErr error // Expected write error
+		readErr  error // Expected read error
+	}{
+		// Force error in version.
+		{0, io.ErrShortWrite, io.EOF},
+		// Force error in prev block hash.
+		{4, io.ErrShortWrite, io.EOF},
+		// Force error in nonce.
+		{76, io.ErrShortWrite, io.EOF},
+		// Force error in transaction count.
+		{82, io.ErrShortWrite, io.ErrUnexpectedEOF},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Serialize the block header.
+		w := newFixedWriter(test.max)
+		err := bh.Serialize(w)
+		if err != test.writeErr {
+			t.Errorf("Serialize #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// Deserialize the block header.
+		var header btcwire.BlockHeader
+		r := newFixedReader(test.max, encoded.Bytes())
+		err = header.Deserialize(r)
+		if err != test.readErr {
+			t.Errorf("Deserialize #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+	}
+}
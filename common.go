@@ -8,17 +8,243 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
+	"fmt"
+	"hash"
 	"io"
 	"math"
+	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
 // Maximum payload size for a variable length integer.
 const maxVarIntPayload = 9
 
+// maxPreallocBytes is the maximum number of bytes allocated up front for a
+// collection or byte array whose length is read from the wire.  Larger ones
+// grow as their contents are actually read, so a peer can't force a large
+// allocation by sending a huge count followed by little or no data.
+const maxPreallocBytes = 1 << 16
+
+// preallocCount returns the number of elements of elemSize bytes to allocate
+// up front for a collection whose count was read from the wire.  It is the
+// count itself when the elements fit within maxPreallocBytes and the number
+// which does otherwise.
+func preallocCount(count uint64, elemSize uintptr) int {
+	if limit := uint64(maxPreallocBytes / elemSize); count > limit {
+		return int(limit)
+	}
+	return int(count)
+}
+
+// scratchPool houses scratch buffers for the element readers and writers to
+// use when they are not passed a decode context.  A buffer handed to an
+// io.Reader or io.Writer escapes to the heap, so reusing them avoids allocating
+// a new one for every field.
+var scratchPool = sync.Pool{
+	New: func() interface{} { return new([maxVarIntPayload]byte) },
+}
+
 // readElement reads the next sequence of bytes from r using little endian
 // depending on the concrete type of element pointed to.
+//
+// The common types are handled directly, without the reflection and
+// allocations of binary.Read, using the scratch buffer of the decode context
+// when r is a decodeReader or a pooled one otherwise.  Any other types fall
+// back to binary.Read.
 func readElement(r io.Reader, element interface{}) error {
-	return binary.Read(r, binary.LittleEndian, element)
+	src := r
+	if dr, ok := r.(*decodeReader); ok {
+		src = dr.Reader
+	}
+
+	switch e := element.(type) {
+	case *uint8:
+		rv, err := readUint(r, 1)
+		if err != nil {
+			return err
+		}
+		*e = uint8(rv)
+		return nil
+
+	case *bool:
+		rv, err := readUint(r, 1)
+		if err != nil {
+			return err
+		}
+		*e = rv != 0
+		return nil
+
+	case *uint16:
+		rv, err := readUint(r, 2)
+		if err != nil {
+			return err
+		}
+		*e = uint16(rv)
+		return nil
+
+	case *int32:
+		rv, err := readUint(r, 4)
+		if err != nil {
+			return err
+		}
+		*e = int32(rv)
+		return nil
+
+	case *uint32:
+		rv, err := readUint(r, 4)
+		if err != nil {
+			return err
+		}
+		*e = uint32(rv)
+		return nil
+
+	case *int64:
+		rv, err := readUint(r, 8)
+		if err != nil {
+			return err
+		}
+		*e = int64(rv)
+		return nil
+
+	case *uint64:
+		rv, err := readUint(r, 8)
+		if err != nil {
+			return err
+		}
+		*e = rv
+		return nil
+
+	case *BitcoinNet:
+		rv, err := readUint(r, 4)
+		if err != nil {
+			return err
+		}
+		*e = BitcoinNet(rv)
+		return nil
+
+	case *InvType:
+		rv, err := readUint(r, 4)
+		if err != nil {
+			return err
+		}
+		*e = InvType(rv)
+		return nil
+
+	case *ServiceFlag:
+		rv, err := readUint(r, 8)
+		if err != nil {
+			return err
+		}
+		*e = ServiceFlag(rv)
+		return nil
+
+	case *RejectCode:
+		rv, err := readUint(r, 1)
+		if err != nil {
+			return err
+		}
+		*e = RejectCode(rv)
+		return nil
+
+	case *BloomUpdateType:
+		rv, err := readUint(r, 1)
+		if err != nil {
+			return err
+		}
+		*e = BloomUpdateType(rv)
+		return nil
+
+	case *FilterType:
+		rv, err := readUint(r, 1)
+		if err != nil {
+			return err
+		}
+		*e = FilterType(rv)
+		return nil
+
+	case *AddrNetworkID:
+		rv, err := readUint(r, 1)
+		if err != nil {
+			return err
+		}
+		*e = AddrNetworkID(rv)
+		return nil
+
+	case *ShaHash:
+		_, err := io.ReadFull(src, e[:])
+		return err
+
+	case *OutPoint:
+		_, err := io.ReadFull(src, e.Hash[:])
+		if err != nil {
+			return err
+		}
+		rv, err := readUint(r, 4)
+		if err != nil {
+			return err
+		}
+		e.Index = uint32(rv)
+		return nil
+
+	case *[4]byte:
+		_, err := io.ReadFull(src, e[:])
+		return err
+
+	case *[commandSize]uint8:
+		_, err := io.ReadFull(src, e[:])
+		return err
+
+	case *[16]byte:
+		_, err := io.ReadFull(src, e[:])
+		return err
+
+	case []byte:
+		_, err := io.ReadFull(src, e)
+		return err
+	}
+
+	return binary.Read(src, binary.LittleEndian, element)
+}
+
+// readScratch reads the next n bytes, which must not exceed the size of the
+// scratch buffer, into the scratch buffer of the decode context and returns
+// them.  The returned bytes are only valid until the next read.
+func (dr *decodeReader) readScratch(n int) ([]byte, error) {
+	b := dr.scratch[:n]
+	_, err := io.ReadFull(dr.Reader, b)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readUint reads the next n byte little endian unsigned integer from r.  The
+// scratch buffer of the decode context is used when r is a decodeReader and a
+// pooled one otherwise.
+func readUint(r io.Reader, n int) (uint64, error) {
+	var b []byte
+	if dr, ok := r.(*decodeReader); ok {
+		var err error
+		b, err = dr.readScratch(n)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		buf := scratchPool.Get().(*[maxVarIntPayload]byte)
+		defer scratchPool.Put(buf)
+		b = buf[:n]
+		_, err := io.ReadFull(r, b)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var rv uint64
+	for i := n - 1; i >= 0; i-- {
+		rv = rv<<8 | uint64(b[i])
+	}
+	return rv, nil
 }
 
 // readElements reads multiple items from r.  It is equivalent to multiple
@@ -34,10 +260,113 @@ func readElements(r io.Reader, elements ...interface{}) error {
 }
 
 // writeElement writes the little endian representation of element to w.
+//
+// The common types are handled directly, without the reflection and
+// allocations of binary.Write, using a pooled scratch buffer.  Any other types
+// fall back to binary.Write.  Hashes and integers in hot paths are best passed
+// by pointer since copying them into the interface may require an allocation.
 func writeElement(w io.Writer, element interface{}) error {
+	switch e := element.(type) {
+	case uint8:
+		return writeUint(w, uint64(e), 1)
+
+	case bool:
+		var b uint64
+		if e {
+			b = 1
+		}
+		return writeUint(w, b, 1)
+
+	case uint16:
+		return writeUint(w, uint64(e), 2)
+
+	case int32:
+		return writeUint(w, uint64(uint32(e)), 4)
+
+	case *int32:
+		return writeUint(w, uint64(uint32(*e)), 4)
+
+	case uint32:
+		return writeUint(w, uint64(e), 4)
+
+	case *uint32:
+		return writeUint(w, uint64(*e), 4)
+
+	case int64:
+		return writeUint(w, uint64(e), 8)
+
+	case *int64:
+		return writeUint(w, uint64(*e), 8)
+
+	case uint64:
+		return writeUint(w, e, 8)
+
+	case BitcoinNet:
+		return writeUint(w, uint64(e), 4)
+
+	case InvType:
+		return writeUint(w, uint64(e), 4)
+
+	case ServiceFlag:
+		return writeUint(w, uint64(e), 8)
+
+	case RejectCode:
+		return writeUint(w, uint64(e), 1)
+
+	case BloomUpdateType:
+		return writeUint(w, uint64(e), 1)
+
+	case FilterType:
+		return writeUint(w, uint64(e), 1)
+
+	case AddrNetworkID:
+		return writeUint(w, uint64(e), 1)
+
+	case *ShaHash:
+		_, err := w.Write(e[:])
+		return err
+
+	case ShaHash:
+		_, err := w.Write(e[:])
+		return err
+
+	case *OutPoint:
+		_, err := w.Write(e.Hash[:])
+		if err != nil {
+			return err
+		}
+		return writeUint(w, uint64(e.Index), 4)
+
+	case [4]byte:
+		_, err := w.Write(e[:])
+		return err
+
+	case [commandSize]uint8:
+		_, err := w.Write(e[:])
+		return err
+
+	case [16]byte:
+		_, err := w.Write(e[:])
+		return err
+
+	case []byte:
+		_, err := w.Write(e)
+		return err
+	}
+
 	return binary.Write(w, binary.LittleEndian, element)
 }
 
+// writeUint writes the low n bytes of val as a little endian unsigned integer
+// to w using a pooled scratch buffer.
+func writeUint(w io.Writer, val uint64, n int) error {
+	buf := scratchPool.Get().(*[maxVarIntPayload]byte)
+	binary.LittleEndian.PutUint64(buf[:8], val)
+	_, err := w.Write(buf[:n])
+	scratchPool.Put(buf)
+	return err
+}
+
 // writeElements writes multiple items to w.  It is equivalent to multiple
 // calls to writeElement.
 func writeElements(w io.Writer, elements ...interface{}) error {
@@ -50,91 +379,212 @@ func writeElements(w io.Writer, elements ...interface{}) error {
 	return nil
 }
 
+// lenReader is implemented by readers such as bytes.Buffer and bytes.Reader
+// which are able to report the number of unread bytes.
+type lenReader interface {
+	Len() int
+}
+
+// hasRemaining returns whether or not there are bytes left to be read from r.
+// Readers which are unable to report their remaining length are always
+// assumed to have more data so that decoding them remains strict.
+func hasRemaining(r io.Reader) bool {
+	if dr, ok := r.(*decodeReader); ok {
+		r = dr.Reader
+	}
+	lr, ok := r.(lenReader)
+	if !ok {
+		return true
+	}
+	return lr.Len() > 0
+}
+
 // readVarInt reads a variable length integer from r and returns it as a uint64.
 func readVarInt(r io.Reader, pver uint32) (uint64, error) {
-	b := make([]byte, 1)
-	_, err := r.Read(b)
+	discriminant, err := readUint(r, 1)
 	if err != nil {
 		return 0, err
 	}
 
+	return readVarIntPayload(r, pver, uint8(discriminant))
+}
+
+// readVarIntPayload reads the remainder of a variable length integer from r
+// given its already read first byte, discriminant, and returns it as a uint64.
+func readVarIntPayload(r io.Reader, pver uint32, discriminant uint8) (uint64, error) {
 	var rv uint64
-	discriminant := uint8(b[0])
+	var min uint64
 	switch discriminant {
 	case 0xff:
-		var u uint64
-		err = binary.Read(r, binary.LittleEndian, &u)
+		u, err := readUint(r, 8)
 		if err != nil {
 			return 0, err
 		}
 		rv = u
+		min = math.MaxUint32 + 1
 
 	case 0xfe:
-		var u uint32
-		err = binary.Read(r, binary.LittleEndian, &u)
+		u, err := readUint(r, 4)
 		if err != nil {
 			return 0, err
 		}
-		rv = uint64(u)
+		rv = u
+		min = math.MaxUint16 + 1
 
 	case 0xfd:
-		var u uint16
-		err = binary.Read(r, binary.LittleEndian, &u)
+		u, err := readUint(r, 2)
 		if err != nil {
 			return 0, err
 		}
-		rv = uint64(u)
+		rv = u
+		min = 0xfd
 
 	default:
 		rv = uint64(discriminant)
 	}
 
+	// Reject values which could have been encoded with fewer bytes when
+	// the decode options require canonical encodings.
+	if rv < min && decodeOptions(r).CanonicalVarInts {
+		str := fmt.Sprintf("non-canonical varint %x - discriminant %x "+
+			"must encode a value greater than or equal to %x", rv,
+			discriminant, min)
+		return 0, messageError("readVarInt", ErrNonCanonicalVarInt, str)
+	}
+
 	return rv, nil
 }
 
 // writeVarInt serializes val to w using a variable number of bytes depending
-// on its value.
+// on its value.  The discriminant and value are written with a single write.
 func writeVarInt(w io.Writer, pver uint32, val uint64) error {
-	if val > math.MaxUint32 {
-		err := writeElements(w, []byte{0xff}, uint64(val))
-		if err != nil {
-			return err
-		}
-		return nil
+	buf := scratchPool.Get().(*[maxVarIntPayload]byte)
+	defer scratchPool.Put(buf)
+
+	var n int
+	switch {
+	case val > math.MaxUint32:
+		buf[0] = 0xff
+		binary.LittleEndian.PutUint64(buf[1:], val)
+		n = 9
+
+	case val > math.MaxUint16:
+		buf[0] = 0xfe
+		binary.LittleEndian.PutUint32(buf[1:], uint32(val))
+		n = 5
+
+	case val >= 0xfd:
+		buf[0] = 0xfd
+		binary.LittleEndian.PutUint16(buf[1:], uint16(val))
+		n = 3
+
+	default:
+		buf[0] = uint8(val)
+		n = 1
 	}
-	if val > math.MaxUint16 {
-		err := writeElements(w, []byte{0xfe}, uint32(val))
-		if err != nil {
-			return err
+
+	_, err := w.Write(buf[:n])
+	return err
+}
+
+// VarIntSerializeSize returns the number of bytes it would take to serialize
+// val as a variable length integer.
+func VarIntSerializeSize(val uint64) int {
+	// The value is small enough to be represented by itself, so it's
+	// just 1 byte.
+	if val < 0xfd {
+		return 1
+	}
+
+	// Discriminant 1 byte plus 2 bytes for the uint16.
+	if val <= math.MaxUint16 {
+		return 3
+	}
+
+	// Discriminant 1 byte plus 4 bytes for the uint32.
+	if val <= math.MaxUint32 {
+		return 5
+	}
+
+	// Discriminant 1 byte plus 8 bytes for the uint64.
+	return 9
+}
+
+// firstDiff returns the offset of the first byte which differs between a and
+// b.  When one is a prefix of the other, that is the length of the shorter
+// one.  It is -1 when they are equal.
+func firstDiff(a, b []byte) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return i
 		}
-		return nil
 	}
-	if val >= 0xfd {
-		err := writeElements(w, []byte{0xfd}, uint16(val))
-		if err != nil {
-			return err
+	if len(a) != len(b) {
+		if len(a) < len(b) {
+			return len(a)
 		}
-		return nil
+		return len(b)
 	}
-	return writeElement(w, uint8(val))
+	return -1
 }
 
 // readVarString reads a variable length string from r and returns it as a Go
 // string.  A varString is encoded as a varInt containing the length of the
 // string, and the bytes that represent the string itself.
 func readVarString(r io.Reader, pver uint32) (string, error) {
+	return readLimitedVarString(r, pver, maxMessagePayload,
+		"variable length string")
+}
+
+// readLimitedVarString reads a variable length string from r like
+// readVarString, but rejects strings longer than maxAllowed before allocating
+// memory for them.  The field name is used in the error when it is exceeded.
+func readLimitedVarString(r io.Reader, pver uint32, maxAllowed uint32,
+	fieldName string) (string, error) {
+
 	slen, err := readVarInt(r, pver)
 	if err != nil {
 		return "", err
 	}
-	buf := make([]byte, slen)
-	err = readElement(r, buf)
+
+	// Prevent variable length strings that are larger than the maximum
+	// allowed size.  It would be possible to cause memory exhaustion and
+	// panics without a sane upper bound on this count.
+	if slen > uint64(maxAllowed) {
+		str := fmt.Sprintf("%s is too long [count %d, max %d]",
+			fieldName, slen, maxAllowed)
+		return "", messageError("readVarString", ErrPayloadTooLarge, str)
+	}
+
+	buf, err := readBytes(r, slen)
 	if err != nil {
 		return "", err
 	}
 	return string(buf), nil
 }
 
+// readVarStringUTF8 reads a variable length string from r like
+// readLimitedVarString and additionally ensures it is valid UTF-8 without any
+// embedded NUL characters.  It is intended for fields which hold text.
+func readVarStringUTF8(r io.Reader, pver uint32, maxAllowed uint32,
+	fieldName string) (string, error) {
+
+	str, err := readLimitedVarString(r, pver, maxAllowed, fieldName)
+	if err != nil {
+		return "", err
+	}
+
+	if !utf8.ValidString(str) {
+		return "", messageError("readVarStringUTF8", ErrMalformed,
+			"variable length string is not valid UTF-8")
+	}
+	if strings.IndexByte(str, 0) != -1 {
+		return "", messageError("readVarStringUTF8", ErrMalformed,
+			"variable length string contains a NUL character")
+	}
+	return str, nil
+}
+
 // writeVarString serializes str to w as a varInt containing the length of the
 // string followed by the bytes that represent the string itself.
 func writeVarString(w io.Writer, pver uint32, str string) error {
@@ -149,6 +599,72 @@ func writeVarString(w io.Writer, pver uint32, str string) error {
 	return nil
 }
 
+// readVarBytes reads a variable length byte array from r.  A byte array is
+// encoded as a varInt containing the length of the array followed by the bytes
+// themselves.  An error is returned if the length is greater than the passed
+// maxAllowed parameter which helps protect against memory exhaustion attacks
+// and forced panics through malformed messages.  The fieldName parameter is
+// only used for the error message so it provides more context in the error.
+func readVarBytes(r io.Reader, pver uint32, maxAllowed uint32,
+	fieldName string) ([]byte, error) {
+
+	count, err := readVarInt(r, pver)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prevent byte array larger than the max message size.  It would
+	// be possible to cause memory exhaustion and panics without a sane
+	// upper bound on this count.
+	if count > uint64(maxAllowed) {
+		str := fmt.Sprintf("%s is larger than the max allowed size "+
+			"[count %d, max %d]", fieldName, count, maxAllowed)
+		return nil, messageError("readVarBytes", ErrPayloadTooLarge, str)
+	}
+
+	return readBytes(r, count)
+}
+
+// readBytes reads exactly count bytes from r.  The bytes are read in chunks
+// of at most maxPreallocBytes so the memory allocated never gets far ahead of
+// the bytes actually received.
+func readBytes(r io.Reader, count uint64) ([]byte, error) {
+	b := make([]byte, 0, preallocCount(count, 1))
+	for uint64(len(b)) < count {
+		chunk := count - uint64(len(b))
+		if chunk > maxPreallocBytes {
+			chunk = maxPreallocBytes
+		}
+
+		start := len(b)
+		b = append(b, make([]byte, chunk)...)
+		_, err := io.ReadFull(r, b[start:])
+		if err == io.EOF && start > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// writeVarBytes serializes a variable length byte array to w as a varInt
+// containing the number of bytes, followed by the bytes themselves.
+func writeVarBytes(w io.Writer, pver uint32, bytes []byte) error {
+	slen := uint64(len(bytes))
+	err := writeVarInt(w, pver, slen)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(bytes)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 // randomUint64 returns a cryptographically random uint64 value.  This
 // unexported version takes a reader primarily to ensure the error paths
 // can be properly tested by passing a fake reader in the tests.
@@ -179,3 +695,45 @@ func DoubleSha256(b []byte) []byte {
 	sum = hasher.Sum(nil)
 	return sum
 }
+
+// DoubleSha256SH calculates sha256(sha256(b)) and returns the resulting bytes
+// as a ShaHash.
+func DoubleSha256SH(b []byte) ShaHash {
+	first := sha256.Sum256(b)
+	return ShaHash(sha256.Sum256(first[:]))
+}
+
+// shaHasher is an io.Writer which computes the double sha256 of the bytes
+// written to it.  Serializing data such as a transaction directly to it avoids
+// serializing the data into a buffer before hashing it.
+type shaHasher struct {
+	hash.Hash
+	sum [HashSize]byte
+}
+
+// shaHasherPool houses hashers for reuse by the functions which hash
+// serialized data.
+var shaHasherPool = sync.Pool{
+	New: func() interface{} { return &shaHasher{Hash: sha256.New()} },
+}
+
+// borrowShaHasher returns a reset hasher from the pool.  It must be returned
+// with returnShaHasher once the hash has been computed.
+func borrowShaHasher() *shaHasher {
+	h := shaHasherPool.Get().(*shaHasher)
+	h.Reset()
+	return h
+}
+
+// returnShaHasher returns a hasher taken from the pool by borrowShaHasher to
+// the pool.
+func returnShaHasher(h *shaHasher) {
+	shaHasherPool.Put(h)
+}
+
+// doubleSum returns sha256(sha256(b)) of the bytes b written to the hasher so
+// far.
+func (h *shaHasher) doubleSum() ShaHash {
+	first := h.Sum(h.sum[:0])
+	return ShaHash(sha256.Sum256(first))
+}
@@ -6,10 +6,14 @@ package btcwire_test
 
 import (
 	"bytes"
+	"encoding/hex"
 	"fmt"
 	"github.com/conformal/btcwire"
 	"github.com/davecgh/go-spew/spew"
 	"io"
+	"io/ioutil"
+	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -31,6 +35,151 @@ func (r *fakeRandReader) Read(p []byte) (int, error) {
 	return n, r.err
 }
 
+// TestElementWire tests wire encode and decode for the various element types
+// both with and without a decode context.
+func TestElementWire(t *testing.T) {
+	hash := btcwire.ShaHash{
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10,
+		0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18,
+		0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20,
+	}
+
+	tests := []struct {
+		in  interface{} // Value to encode
+		buf []byte      // Wire encoding
+	}{
+		{uint8(0x12), []byte{0x12}},
+		{true, []byte{0x01}},
+		{false, []byte{0x00}},
+		{uint16(0x1234), []byte{0x34, 0x12}},
+		{int32(-2), []byte{0xfe, 0xff, 0xff, 0xff}},
+		{uint32(0x12345678), []byte{0x78, 0x56, 0x34, 0x12}},
+		{int64(-2), []byte{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{
+			uint64(0x123456789abcdef0),
+			[]byte{0xf0, 0xde, 0xbc, 0x9a, 0x78, 0x56, 0x34, 0x12},
+		},
+		{btcwire.MainNet, []byte{0xf9, 0xbe, 0xb4, 0xd9}},
+		{btcwire.InvVect_Tx, []byte{0x01, 0x00, 0x00, 0x00}},
+		{
+			btcwire.SFNodeNetwork,
+			[]byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		},
+		{btcwire.RejectDuplicate, []byte{0x12}},
+		{btcwire.BloomUpdateAll, []byte{0x01}},
+		{btcwire.GCSFilterRegular, []byte{0x00}},
+		{btcwire.AddrNetworkID(0x04), []byte{0x04}},
+		{[4]byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02, 0x03, 0x04}},
+		{
+			[16]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+				0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10},
+			[]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+				0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10},
+		},
+		{hash, hash[:]},
+		{&hash, hash[:]},
+		{
+			btcwire.NewOutPoint(&hash, 0x01020304),
+			append(hash[:len(hash):len(hash)], 0x04, 0x03, 0x02, 0x01),
+		},
+		// Falls back to binary.Read and binary.Write.
+		{int16(-2), []byte{0xfe, 0xff}},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		var buf bytes.Buffer
+		err := btcwire.TstWriteElement(&buf, test.in)
+		if err != nil {
+			t.Errorf("writeElement #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("writeElement #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode from wire format both with and without a decode
+		// context.
+		readers := []io.Reader{
+			bytes.NewReader(test.buf),
+			btcwire.NewDecodeReader(bytes.NewReader(test.buf),
+				&btcwire.DecodeOptions{}),
+		}
+		for _, r := range readers {
+			want := test.in
+			typ := reflect.TypeOf(test.in)
+			if typ.Kind() == reflect.Ptr {
+				typ = typ.Elem()
+				want = reflect.ValueOf(test.in).Elem().Interface()
+			}
+			val := reflect.New(typ)
+			err := btcwire.TstReadElement(r, val.Interface())
+			if err != nil {
+				t.Errorf("readElement #%d error %v", i, err)
+				continue
+			}
+			if got := val.Elem().Interface(); !reflect.DeepEqual(got, want) {
+				t.Errorf("readElement #%d\n got: %s want: %s", i,
+					spew.Sdump(got), spew.Sdump(want))
+				continue
+			}
+		}
+	}
+}
+
+// TestElementWireErrors performs negative tests against wire encode and
+// decode of elements to confirm error paths work correctly.
+func TestElementWireErrors(t *testing.T) {
+	hash := btcwire.ShaHash{0x01}
+
+	tests := []struct {
+		in       interface{} // Value to encode
+		max      int         // Max size of fixed buffer to induce errors
+		writeErr error       // Expected write error
+		readErr  error       // Expected read error
+	}{
+		{uint8(0x12), 0, io.ErrShortWrite, io.EOF},
+		{uint32(0x12345678), 2, io.ErrShortWrite, io.ErrUnexpectedEOF},
+		{uint64(0x12345678), 4, io.ErrShortWrite, io.ErrUnexpectedEOF},
+		{&hash, 8, io.ErrShortWrite, io.ErrUnexpectedEOF},
+		// Force errors on the hash and index of an outpoint.
+		{btcwire.NewOutPoint(&hash, 1), 0, io.ErrShortWrite, io.EOF},
+		{btcwire.NewOutPoint(&hash, 1), 34, io.ErrShortWrite,
+			io.ErrUnexpectedEOF},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := btcwire.TstWriteElement(w, test.in)
+		if err != test.writeErr {
+			t.Errorf("writeElement #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// Decode from wire format.
+		var buf bytes.Buffer
+		btcwire.TstWriteElement(&buf, test.in)
+		r := newFixedReader(test.max, buf.Bytes())
+		typ := reflect.TypeOf(test.in)
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		err = btcwire.TstReadElement(r, reflect.New(typ).Interface())
+		if err != test.readErr {
+			t.Errorf("readElement #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+	}
+}
+
 // TestVarIntWire tests wire encode and decode for variable length integers.
 func TestVarIntWire(t *testing.T) {
 	pver := btcwire.ProtocolVersion
@@ -82,6 +231,12 @@ func TestVarIntWire(t *testing.T) {
 				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
 			continue
 		}
+		size := btcwire.VarIntSerializeSize(test.in)
+		if size != len(test.buf) {
+			t.Errorf("VarIntSerializeSize #%d: wrong size - got %d, "+
+				"want %d", i, size, len(test.buf))
+			continue
+		}
 
 		// Decode from wire format.
 		rbuf := bytes.NewBuffer(test.buf)
@@ -151,6 +306,10 @@ func TestVarStringWire(t *testing.T) {
 	// str256 is a string that takes a 2-byte varint to encode.
 	str256 := strings.Repeat("test", 64)
 
+	// str160k is a string that takes a 4-byte varint to encode and is read
+	// in more than one chunk.
+	str160k := strings.Repeat("test", 40000)
+
 	tests := []struct {
 		in   string // String to encode
 		out  string // String to decoded value
@@ -164,6 +323,9 @@ func TestVarStringWire(t *testing.T) {
 		{"Test", "Test", append([]byte{0x04}, []byte("Test")...), pver},
 		// 2-byte varint + string
 		{str256, str256, append([]byte{0xfd, 0x00, 0x01}, []byte(str256)...), pver},
+		// 4-byte varint + string
+		{str160k, str160k, append([]byte{0xfe, 0x00, 0x71, 0x02, 0x00},
+			[]byte(str160k)...), pver},
 	}
 
 	t.Logf("Running %d tests", len(tests))
@@ -189,7 +351,7 @@ func TestVarStringWire(t *testing.T) {
 			continue
 		}
 		if val != test.out {
-			t.Errorf("readVarString #%d\n got: %d want: %d", i,
+			t.Errorf("readVarString #%d\n got: %s want: %s", i,
 				val, test.out)
 			continue
 		}
@@ -243,6 +405,274 @@ func TestVarStringWireErrors(t *testing.T) {
 	}
 }
 
+// TestHugeCounts ensures counts and lengths which are far larger than could
+// fit into a message, up to the largest a varint can encode, are rejected with
+// an error before anything is allocated for them.  Converting such a count to
+// an int must never wrap or panic, including on 32-bit platforms.
+func TestHugeCounts(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	hugeCounts := [][]byte{
+		{0xfe, 0xff, 0xff, 0xff, 0xff},                         // 2^32 - 1
+		{0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80}, // 2^63
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, // 2^64 - 1
+	}
+
+	// Fields of transactions and blocks which precede the counts.
+	txVersion := []byte{0x01, 0x00, 0x00, 0x00}
+	outPoint := make([]byte, 36)
+	txIn := joinBytes(outPoint, []byte{0x00, 0xff, 0xff, 0xff, 0xff})
+	txOutValue := make([]byte, 8)
+	blockHeader := blockOneBytes[:80]
+
+	decodeTx := func(b []byte) error {
+		var msg btcwire.MsgTx
+		return msg.BtcDecode(bytes.NewReader(b), pver)
+	}
+	decodeBlock := func(b []byte) error {
+		var msg btcwire.MsgBlock
+		return msg.BtcDecode(bytes.NewReader(b), pver)
+	}
+	deserializeBlock := func(b []byte) error {
+		var msg btcwire.MsgBlock
+		return msg.Deserialize(bytes.NewReader(b))
+	}
+	decodeBlockTxLoc := func(b []byte) error {
+		var msg btcwire.MsgBlock
+		_, err := msg.BtcDecodeTxLoc(bytes.NewBuffer(b), pver)
+		return err
+	}
+	decodeMessage := func(msg btcwire.Message, pver uint32) func([]byte) error {
+		return func(b []byte) error {
+			return msg.BtcDecode(bytes.NewReader(b), pver)
+		}
+	}
+
+	tests := []struct {
+		name   string             // Field with the count
+		prefix []byte             // Encoded fields before the count
+		decode func([]byte) error // Decoder to run
+	}{
+		{"tx inputs", txVersion, decodeTx},
+		{"witness tx inputs", joinBytes(txVersion, []byte{0x00, 0x01}),
+			decodeTx},
+		{"tx signature script", joinBytes(txVersion, []byte{0x01},
+			outPoint), decodeTx},
+		{"tx outputs", joinBytes(txVersion, []byte{0x01}, txIn),
+			decodeTx},
+		{"tx public key script", joinBytes(txVersion, []byte{0x01},
+			txIn, []byte{0x01}, txOutValue), decodeTx},
+		{"witness items", joinBytes(txVersion, []byte{0x00, 0x01, 0x01},
+			txIn, []byte{0x00}), decodeTx},
+		{"block transactions", blockHeader, decodeBlock},
+		{"stored block transactions", blockHeader, deserializeBlock},
+		{"block transaction locations", blockHeader, decodeBlockTxLoc},
+		{"headers", nil, decodeMessage(&btcwire.MsgHeaders{}, pver)},
+		{"inv", nil, decodeMessage(&btcwire.MsgInv{}, pver)},
+		{"addr", nil, decodeMessage(&btcwire.MsgAddr{}, pver)},
+		{"getblocks locators", txVersion,
+			decodeMessage(&btcwire.MsgGetBlocks{}, pver)},
+		{"version user agent", baseVersionEncoded[:80],
+			decodeMessage(&btcwire.MsgVersion{}, 60002)},
+		{"reject command", nil, decodeMessage(&btcwire.MsgReject{}, pver)},
+		{"cmpctblock short ids", joinBytes(blockHeader, make([]byte, 8)),
+			decodeMessage(&btcwire.MsgCmpctBlock{},
+				btcwire.SendCmpctVersion)},
+	}
+
+	t.Logf("Running %d tests", len(tests)*len(hugeCounts))
+	for i, test := range tests {
+		for _, count := range hugeCounts {
+			err := test.decode(joinBytes(test.prefix, count))
+			if _, ok := err.(*btcwire.MessageError); !ok {
+				t.Errorf("#%d (%s) count %x: wrong error got: %v "+
+					"<%T>, want: <*btcwire.MessageError>", i,
+					test.name, count, err, err)
+			}
+		}
+	}
+}
+
+// TestHugeCountAllocations ensures decoding a count near its maximum without
+// the data it promises fails without allocating memory for all of it up front.
+func TestHugeCountAllocations(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	// maxAlloc is the most memory decoding any of the crafted payloads may
+	// allocate.  It is far less than any of the counts would need.
+	const maxAlloc = 1 << 19
+
+	// varInt32 returns the 5 byte variable length integer encoding of n.
+	varInt32 := func(n uint32) []byte {
+		return []byte{0xfe, byte(n), byte(n >> 8), byte(n >> 16),
+			byte(n >> 24)}
+	}
+
+	// Fields of transactions and other messages which precede the counts.
+	txVersion := []byte{0x01, 0x00, 0x00, 0x00}
+	outPoint := make([]byte, 36)
+	txIn := joinBytes(outPoint, []byte{0x00, 0xff, 0xff, 0xff, 0xff})
+	txOutValue := make([]byte, 8)
+	blockHeader := blockOneBytes[:80]
+	alertFields := make([]byte, 28)
+	alertVersions := make([]byte, 8)
+
+	decodeTx := func(b []byte) error {
+		var msg btcwire.MsgTx
+		return msg.BtcDecode(bytes.NewReader(b), pver)
+	}
+	deserializeAlert := func(b []byte) error {
+		var alert btcwire.Alert
+		return alert.Deserialize(bytes.NewReader(b), pver)
+	}
+	decodeMessage := func(msg btcwire.Message, pver uint32) func([]byte) error {
+		return func(b []byte) error {
+			return msg.BtcDecode(bytes.NewReader(b), pver)
+		}
+	}
+
+	tests := []struct {
+		name   string             // Field with the count
+		prefix []byte             // Encoded fields before the count
+		count  []byte             // Encoded count
+		decode func([]byte) error // Decoder to run
+	}{
+		{"tx signature script", joinBytes(txVersion, []byte{0x01},
+			outPoint), varInt32(btcwire.MaxMessagePayload), decodeTx},
+		{"tx public key script", joinBytes(txVersion, []byte{0x01},
+			txIn, []byte{0x01}, txOutValue),
+			varInt32(btcwire.MaxMessagePayload), decodeTx},
+		{"witness items", joinBytes(txVersion, []byte{0x00, 0x01, 0x01},
+			txIn, []byte{0x00}),
+			varInt32(btcwire.MaxWitnessItemsPerInput), decodeTx},
+		{"alert cancel ids", alertFields,
+			varInt32(btcwire.MaxMessagePayload / 4), deserializeAlert},
+		{"alert user agents", joinBytes(alertFields, []byte{0x00},
+			alertVersions), varInt32(btcwire.MaxMessagePayload),
+			deserializeAlert},
+		{"cmpctblock short ids", joinBytes(blockHeader, make([]byte, 8)),
+			varInt32(btcwire.MaxBlockPayload / 10),
+			decodeMessage(&btcwire.MsgCmpctBlock{},
+				btcwire.SendCmpctVersion)},
+		{"blocktxn transactions", make([]byte, 32),
+			varInt32(btcwire.MaxBlockPayload / 10),
+			decodeMessage(&btcwire.MsgBlockTxn{},
+				btcwire.SendCmpctVersion)},
+		{"cfcheckpt filter headers", make([]byte, 33), varInt32(100000),
+			decodeMessage(&btcwire.MsgCFCheckpt{},
+				btcwire.CFilterVersion)},
+		{"merkleblock hashes", joinBytes(blockHeader,
+			[]byte{0xa0, 0x86, 0x01, 0x00}), varInt32(100000),
+			decodeMessage(&btcwire.MsgMerkleBlock{},
+				btcwire.BIP0037Version)},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		payload := joinBytes(test.prefix, test.count)
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		err := test.decode(payload)
+		runtime.ReadMemStats(&after)
+
+		if err != io.ErrUnexpectedEOF && err != io.EOF {
+			t.Errorf("#%d (%s): wrong error got: %v, want: %v", i,
+				test.name, err, io.ErrUnexpectedEOF)
+			continue
+		}
+		if n := after.TotalAlloc - before.TotalAlloc; n > maxAlloc {
+			t.Errorf("#%d (%s): decoding %d bytes allocated %d "+
+				"bytes, want no more than %d", i, test.name,
+				len(payload), n, maxAlloc)
+		}
+	}
+}
+
+// TestOverfilledEncode ensures messages with more list entries than the
+// protocol allows, such as from appending to the lists directly, are rejected
+// on encode with a MessageError instead of producing an invalid encoding.
+func TestOverfilledEncode(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	maxTxPerBlock := btcwire.MaxBlockPayload/10 + 1
+	maxTxInPerMessage := int(btcwire.MaxMessagePayload/41) + 1
+	maxTxOutPerMessage := int(btcwire.MaxMessagePayload/9) + 1
+
+	// Determining whether a transaction has witness data inspects every
+	// input, so the inputs can't be nil.
+	txIns := make([]*btcwire.TxIn, maxTxInPerMessage+1)
+	for i := range txIns {
+		txIns[i] = &btcwire.TxIn{}
+	}
+
+	tests := []struct {
+		name string          // Name of the overfilled list
+		msg  btcwire.Message // Overfilled message to encode
+	}{
+		{"addr", &btcwire.MsgAddr{
+			AddrList: make([]*btcwire.NetAddress, btcwire.MaxAddrPerMsg+1),
+		}},
+		{"addrv2", &btcwire.MsgAddrV2{
+			AddrList: make([]*btcwire.NetAddressV2, btcwire.MaxAddrPerMsg+1),
+		}},
+		{"headers", &btcwire.MsgHeaders{
+			Headers: make([]*btcwire.BlockHeader,
+				btcwire.MaxBlockHeadersPerMsg+1),
+		}},
+		{"getblocks", &btcwire.MsgGetBlocks{
+			BlockLocatorHashes: make([]*btcwire.ShaHash,
+				btcwire.MaxBlockLocatorsPerMsg+1),
+		}},
+		{"getheaders", &btcwire.MsgGetHeaders{
+			BlockLocatorHashes: make([]*btcwire.ShaHash,
+				btcwire.MaxBlockLocatorsPerMsg+1),
+		}},
+		{"getdata", &btcwire.MsgGetData{
+			InvList: make([]*btcwire.InvVect, btcwire.MaxInvPerMsg+1),
+		}},
+		{"inv", &btcwire.MsgInv{
+			InvList: make([]*btcwire.InvVect, btcwire.MaxInvPerMsg+1),
+		}},
+		{"notfound", &btcwire.MsgNotFound{
+			InvList: make([]*btcwire.InvVect, btcwire.MaxInvPerMsg+1),
+		}},
+		{"getutxos", &btcwire.MsgGetUTXOs{
+			OutPoints: make([]*btcwire.OutPoint,
+				btcwire.MaxOutPointsPerGetUTXOs+1),
+		}},
+		{"block", &btcwire.MsgBlock{
+			Transactions: make([]*btcwire.MsgTx, maxTxPerBlock+1),
+		}},
+		{"tx inputs", &btcwire.MsgTx{
+			TxIn: txIns,
+		}},
+		{"tx outputs", &btcwire.MsgTx{
+			TxOut: make([]*btcwire.TxOut, maxTxOutPerMessage+1),
+		}},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		err := test.msg.BtcEncode(ioutil.Discard, pver)
+		msgErr, ok := err.(*btcwire.MessageError)
+		if !ok || msgErr.Code != btcwire.ErrTooManyItems {
+			t.Errorf("#%d (%s): wrong error got: %v, want: %v", i,
+				test.name, err, btcwire.ErrTooManyItems)
+			continue
+		}
+	}
+
+	// Ensure blocks are also checked when serialized for storage.
+	block := btcwire.MsgBlock{
+		Transactions: make([]*btcwire.MsgTx, maxTxPerBlock+1),
+	}
+	err := block.Serialize(ioutil.Discard)
+	if _, ok := err.(*btcwire.MessageError); !ok {
+		t.Errorf("Serialize: wrong error got: %v, want: "+
+			"<*btcwire.MessageError>", err)
+	}
+}
+
 // TestRandomUint64 exercises the randomness of the random number generator on
 // the system by ensuring the probability of the generated numbers.  If the RNG
 // is evenly distributed as a proper cryptographic RNG should be, there really
@@ -302,3 +732,44 @@ func TestRandomUint64Errors(t *testing.T) {
 		t.Errorf("TestRandomUint64Fails: nonce is not 0 [%v]", nonce)
 	}
 }
+
+// TestDoubleSha256 tests the DoubleSha256 and DoubleSha256SH functions against
+// known values.
+func TestDoubleSha256(t *testing.T) {
+	tests := []struct {
+		in   []byte // Data to hash
+		want string // Expected hash bytes as hex
+	}{
+		{
+			[]byte{},
+			"5df6e0e2761359d30a8275058e299fcc0381534545f55cf43e41983f5d4c9456",
+		},
+		{
+			[]byte("hello"),
+			"9595c9df90075148eb06860365df33584b75bff782a510c6cd4883a419833d50",
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		want, err := hex.DecodeString(test.want)
+		if err != nil {
+			t.Errorf("DecodeString #%d unexpected error: %v", i, err)
+			continue
+		}
+
+		result := btcwire.DoubleSha256(test.in)
+		if !bytes.Equal(result, want) {
+			t.Errorf("DoubleSha256 #%d\n got: %x want: %x", i,
+				result, want)
+			continue
+		}
+
+		sha := btcwire.DoubleSha256SH(test.in)
+		if !bytes.Equal(sha[:], want) {
+			t.Errorf("DoubleSha256SH #%d\n got: %x want: %x", i,
+				sha[:], want)
+			continue
+		}
+	}
+}
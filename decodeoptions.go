@@ -0,0 +1,156 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// DecodeOptions houses the options which control how strictly messages are
+// decoded.  Different callers want different strictness, for example a node
+// relaying live traffic may want to reject anything unusual while an archival
+// tool replaying historical captures wants to accept as much as possible.
+//
+// The zero value, as well as a nil *DecodeOptions, provides the standard
+// behavior used by ReadMessage and the BtcDecode methods.
+type DecodeOptions struct {
+	// CanonicalVarInts rejects variable length integers, including the
+	// lengths of variable length strings and byte slices, which are not
+	// encoded using the minimum number of bytes with ErrNonCanonicalVarInt.
+	CanonicalVarInts bool
+
+	// KnownServices rejects version messages and addresses which
+	// advertise service bits that are not known to this package with
+	// ErrUnknownService.
+	KnownServices bool
+
+	// AllowHeaderTxCount accepts block headers in a headers message
+	// (MsgHeaders) with a nonzero transaction count.  By default, the
+	// transaction count which follows each header must be zero.
+	AllowHeaderTxCount bool
+
+	// KnownInvTypes rejects inventory vectors with a type that is not
+	// known to this package with ErrUnknownInvType.
+	KnownInvTypes bool
+
+	// StandardWitnessItems rejects witness items of loose transactions,
+	// such as those of a tx message (MsgTx), which are larger than
+	// MaxWitnessItemSize with ErrPayloadTooLarge.  It is a relay policy
+	// for nodes which only accept standard transactions.  By default,
+	// witness items up to MaxConsensusWitnessItemSize are accepted.  The
+	// transactions of blocks, including those of cmpctblock and blocktxn
+	// messages, are always decoded with the consensus limit since they
+	// may contain witness items which are valid but not standard.
+	StandardWitnessItems bool
+
+	// StrictText rejects text fields, such as the user agent of a version
+	// message (MsgVersion) and the reason of a reject message (MsgReject),
+	// which are not valid UTF-8 or contain a NUL character with
+	// ErrMalformed.  By default, text fields are decoded as arbitrary
+	// bytes.
+	StrictText bool
+}
+
+// defaultDecodeOptions houses the standard decode options which are used when
+// none are provided.
+var defaultDecodeOptions DecodeOptions
+
+// decodeReader is an io.Reader which carries the decode options so they are
+// available to the decoding functions without changing the signatures of the
+// BtcDecode methods of the Message interface.
+//
+// It also serves as the decode context of a message by carrying a scratch
+// buffer which the element readers use for fixed-size reads.  Reading into a
+// buffer which is already on the heap avoids allocating a new one for every
+// field.
+type decodeReader struct {
+	io.Reader
+	opts    *DecodeOptions
+	scratch [8]byte
+}
+
+// decodeReaderPool houses decode contexts for reuse by messages which are
+// decoded from readers which are not already a decodeReader.
+var decodeReaderPool = sync.Pool{
+	New: func() interface{} { return new(decodeReader) },
+}
+
+// borrowDecodeReader returns a decode context which reads from r using the
+// passed decode options along with whether it was taken from the pool.  When r
+// is already a decodeReader, it is returned as is and its own options are
+// kept.  Contexts taken from the pool must be returned with
+// returnDecodeReader once decoding is finished.
+func borrowDecodeReader(r io.Reader, opts *DecodeOptions) (*decodeReader, bool) {
+	if dr, ok := r.(*decodeReader); ok {
+		return dr, false
+	}
+
+	dr := decodeReaderPool.Get().(*decodeReader)
+	dr.Reader = r
+	dr.opts = opts
+	return dr, true
+}
+
+// returnDecodeReader returns a decode context taken from the pool by
+// borrowDecodeReader to the pool.
+func returnDecodeReader(dr *decodeReader) {
+	dr.Reader = nil
+	dr.opts = nil
+	decodeReaderPool.Put(dr)
+}
+
+// NewDecodeReader returns a reader which reads from r and applies the passed
+// decode options to the BtcDecode method of any message it is passed to.  It
+// is not needed with ReadMessageWithOptions, which applies the decode options
+// of its MessageOptions itself.  See DecodeOptions for details.
+func NewDecodeReader(r io.Reader, opts *DecodeOptions) io.Reader {
+	if opts == nil {
+		return r
+	}
+	return &decodeReader{Reader: r, opts: opts}
+}
+
+// decodeOptions returns the decode options carried by r, or the standard
+// options when r does not carry any.
+func decodeOptions(r io.Reader) *DecodeOptions {
+	if dr, ok := r.(*decodeReader); ok {
+		return dr.opts
+	}
+	return &defaultDecodeOptions
+}
+
+// checkServices returns an error when the decode options carried by r require
+// known service bits and the passed services include unknown ones.
+func checkServices(r io.Reader, services ServiceFlag, f string) error {
+	if !decodeOptions(r).KnownServices {
+		return nil
+	}
+
+	var known ServiceFlag
+	for flag := range sfStrings {
+		known |= flag
+	}
+	if unknown := services &^ known; unknown != 0 {
+		str := fmt.Sprintf("unknown service bits %#x in services %v",
+			uint64(unknown), services)
+		return messageError(f, ErrUnknownService, str)
+	}
+	return nil
+}
+
+// readTextVarString reads a variable length string which holds text from r
+// and is limited to maxAllowed bytes.  It is validated with readVarStringUTF8
+// when the decode options carried by r require strict text and read with
+// readLimitedVarString otherwise.
+func readTextVarString(r io.Reader, pver uint32, maxAllowed uint32,
+	fieldName string) (string, error) {
+
+	if decodeOptions(r).StrictText {
+		return readVarStringUTF8(r, pver, maxAllowed, fieldName)
+	}
+	return readLimitedVarString(r, pver, maxAllowed, fieldName)
+}
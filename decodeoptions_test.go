@@ -0,0 +1,266 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/conformal/btcwire"
+	"testing"
+)
+
+// makeMessage is a convenience function to make a complete message with a valid
+// header for the passed command and payload in the form of a byte slice.
+func makeMessage(btcnet btcwire.BitcoinNet, command string, payload []byte) []byte {
+	checksum := btcwire.DoubleSha256Checksum(payload)
+	hdr := makeHeader(btcnet, command, uint32(len(payload)),
+		binary.LittleEndian.Uint32(checksum[:]))
+	return append(hdr, payload...)
+}
+
+// TestDecodeOptionsVarInt ensures non-canonical variable length integers are
+// only rejected when the CanonicalVarInts decode option is set.
+func TestDecodeOptionsVarInt(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	strict := &btcwire.DecodeOptions{CanonicalVarInts: true}
+
+	tests := []struct {
+		buf       []byte // Encoded varint
+		want      uint64 // Expected decoded value
+		canonical bool   // Whether the encoding is canonical
+	}{
+		// Single byte.
+		{[]byte{0xfc}, 0xfc, true},
+		// Discriminant 0xfd.
+		{[]byte{0xfd, 0xfc, 0x00}, 0xfc, false},
+		{[]byte{0xfd, 0xfd, 0x00}, 0xfd, true},
+		// Discriminant 0xfe.
+		{[]byte{0xfe, 0xff, 0xff, 0x00, 0x00}, 0xffff, false},
+		{[]byte{0xfe, 0x00, 0x00, 0x01, 0x00}, 0x10000, true},
+		// Discriminant 0xff.
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00},
+			0xffffffff, false},
+		{[]byte{0xff, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00},
+			0x100000000, true},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Ensure the standard behavior accepts all encodings.
+		val, err := btcwire.TstReadVarInt(bytes.NewReader(test.buf), pver)
+		if err != nil {
+			t.Errorf("readVarInt #%d error %v", i, err)
+			continue
+		}
+		if val != test.want {
+			t.Errorf("readVarInt #%d\n got: %d want: %d", i, val,
+				test.want)
+			continue
+		}
+
+		// Ensure the strict options only accept canonical encodings.
+		r := btcwire.NewDecodeReader(bytes.NewReader(test.buf), strict)
+		val, err = btcwire.TstReadVarInt(r, pver)
+		if test.canonical {
+			if err != nil || val != test.want {
+				t.Errorf("readVarInt #%d (strict) got: %d (%v) "+
+					"want: %d", i, val, err, test.want)
+			}
+			continue
+		}
+		var msgErr *btcwire.MessageError
+		if !errors.As(err, &msgErr) ||
+			msgErr.Code != btcwire.ErrNonCanonicalVarInt {
+
+			t.Errorf("readVarInt #%d (strict) wrong error - got %v, "+
+				"want %v", i, err, btcwire.ErrNonCanonicalVarInt)
+			continue
+		}
+	}
+}
+
+// TestReadMessageDecodeOptions ensures the decode options passed to
+// ReadMessageWithOptions control how strictly message payloads are decoded and
+// that the standard behavior is unchanged.
+func TestReadMessageDecodeOptions(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcnet := btcwire.MainNet
+
+	// Inventory vector with a known type.
+	invVect := append([]byte{0x01, 0x00, 0x00, 0x00}, make([]byte, 32)...)
+
+	// Inv message with a non-canonical count.
+	nonCanonicalInv := append([]byte{0xfd, 0x01, 0x00}, invVect...)
+
+	// Inv message with an unknown inventory vector type.
+	unknownInv := append([]byte{0x01, 0x99, 0x00, 0x00, 0x00},
+		make([]byte, 32)...)
+
+	// Version message which advertises unknown service bits.
+	unknownSvcVersion := append([]byte{}, baseVersionEncoded...)
+	unknownSvcVersion[5] = 0x04
+
+	// Addr message with an address which advertises unknown service bits.
+	unknownSvcAddr := []byte{
+		0x01,                   // Varint for number of addresses
+		0x29, 0xab, 0x5f, 0x49, // Timestamp
+		0x01, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Services
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0xff, 0xff, 0x7f, 0x00, 0x00, 0x01, // IP 127.0.0.1
+		0x20, 0x8d, // Port 8333 in big-endian
+	}
+
+	// Headers message with a header which has a nonzero transaction count.
+	var hdrBuf bytes.Buffer
+	err := btcwire.TstWriteBlockHeader(&hdrBuf, pver, &blockOne.Header)
+	if err != nil {
+		t.Errorf("writeBlockHeader: %v", err)
+		return
+	}
+	txCountHeaders := append([]byte{0x01}, hdrBuf.Bytes()...)
+
+	// Version messages with a user agent which is not valid UTF-8 and one
+	// which contains a NUL character.
+	badUTF8Version := append([]byte{}, baseVersionEncoded...)
+	badUTF8Version[81] = 0xff
+	nulVersion := append([]byte{}, baseVersionEncoded...)
+	nulVersion[81] = 0x00
+
+	// Reject message with a reason which is not valid UTF-8.
+	badUTF8Reject := []byte{
+		0x07, 'v', 'e', 'r', 's', 'i', 'o', 'n', // Cmd
+		0x11,             // Code
+		0x02, 0xc3, 0x28, // Reason
+	}
+
+	tests := []struct {
+		name    string                 // Name of the test
+		command string                 // Command of the message
+		payload []byte                 // Payload of the message
+		opts    *btcwire.DecodeOptions // Strict decode options
+		stdErr  bool                   // Error with standard options?
+		wantErr bool                   // Error with strict options?
+		code    btcwire.ErrorCode      // Expected error code
+	}{
+		{
+			"non-canonical varint", "inv", nonCanonicalInv,
+			&btcwire.DecodeOptions{CanonicalVarInts: true},
+			false, true, btcwire.ErrNonCanonicalVarInt,
+		},
+		{
+			"unknown inventory type", "inv", unknownInv,
+			&btcwire.DecodeOptions{KnownInvTypes: true},
+			false, true, btcwire.ErrUnknownInvType,
+		},
+		{
+			"known inventory type", "inv", append([]byte{0x01}, invVect...),
+			&btcwire.DecodeOptions{KnownInvTypes: true},
+			false, false, 0,
+		},
+		{
+			"version unknown services", "version", unknownSvcVersion,
+			&btcwire.DecodeOptions{KnownServices: true},
+			false, true, btcwire.ErrUnknownService,
+		},
+		{
+			"version known services", "version", baseVersionEncoded,
+			&btcwire.DecodeOptions{KnownServices: true},
+			false, false, 0,
+		},
+		{
+			"addr unknown services", "addr", unknownSvcAddr,
+			&btcwire.DecodeOptions{KnownServices: true},
+			false, true, btcwire.ErrUnknownService,
+		},
+		{
+			"version user agent invalid utf-8", "version", badUTF8Version,
+			&btcwire.DecodeOptions{StrictText: true},
+			false, true, btcwire.ErrMalformed,
+		},
+		{
+			"version user agent nul", "version", nulVersion,
+			&btcwire.DecodeOptions{StrictText: true},
+			false, true, btcwire.ErrMalformed,
+		},
+		{
+			"version user agent text", "version", baseVersionEncoded,
+			&btcwire.DecodeOptions{StrictText: true},
+			false, false, 0,
+		},
+		{
+			"reject reason invalid utf-8", "reject", badUTF8Reject,
+			&btcwire.DecodeOptions{StrictText: true},
+			false, true, btcwire.ErrMalformed,
+		},
+		{
+			"headers tx count", "headers", txCountHeaders,
+			&btcwire.DecodeOptions{AllowHeaderTxCount: true},
+			true, false, btcwire.ErrMalformed,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		encoded := makeMessage(btcnet, test.command, test.payload)
+
+		// Ensure the standard behavior is unchanged both with and
+		// without options.
+		for _, opts := range []*btcwire.MessageOptions{nil, {}} {
+			r := bytes.NewReader(encoded)
+			_, _, err := btcwire.ReadMessageWithOptions(r, pver,
+				btcnet, opts)
+			if (err != nil) != test.stdErr {
+				t.Errorf("ReadMessageWithOptions #%d (%s) "+
+					"unexpected standard result - got %v, "+
+					"want error %v", i, test.name, err,
+					test.stdErr)
+			}
+		}
+
+		// Ensure the strict options produce the expected result.
+		r := bytes.NewReader(encoded)
+		opts := &btcwire.MessageOptions{Decode: test.opts}
+		_, _, err := btcwire.ReadMessageWithOptions(r, pver, btcnet, opts)
+		if !test.wantErr {
+			if err != nil {
+				t.Errorf("ReadMessageWithOptions #%d (%s) "+
+					"unexpected error %v", i, test.name, err)
+			}
+			continue
+		}
+		var msgErr *btcwire.MessageError
+		if !errors.As(err, &msgErr) || msgErr.Code != test.code {
+			t.Errorf("ReadMessageWithOptions #%d (%s) wrong error - "+
+				"got %v, want %v", i, test.name, err, test.code)
+			continue
+		}
+	}
+}
+
+// TestNewDecodeReader ensures decode options can be applied to the BtcDecode
+// methods directly.
+func TestNewDecodeReader(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	payload := []byte{0xfd, 0x00, 0x00} // Non-canonical count of zero
+
+	// Ensure the standard behavior accepts the message.
+	var msg btcwire.MsgInv
+	err := msg.BtcDecode(btcwire.NewDecodeReader(bytes.NewReader(payload),
+		nil), pver)
+	if err != nil {
+		t.Errorf("BtcDecode: unexpected error %v", err)
+	}
+
+	// Ensure the strict options reject it.
+	opts := &btcwire.DecodeOptions{CanonicalVarInts: true}
+	err = msg.BtcDecode(btcwire.NewDecodeReader(bytes.NewReader(payload),
+		opts), pver)
+	var msgErr *btcwire.MessageError
+	if !errors.As(err, &msgErr) || msgErr.Code != btcwire.ErrNonCanonicalVarInt {
+		t.Errorf("BtcDecode: wrong error - got %v, want %v", err,
+			btcwire.ErrNonCanonicalVarInt)
+	}
+}
@@ -20,7 +20,8 @@ The bitcoin protocol consists of exchanging messages between peers.  Each
 message is preceded by a header which identifies information about it such as
 which bitcoin network it is a part of, its type, how big it is, and a checksum
 to verify validity.  All encoding and decoding of message headers is handled by
-this package.
+this package.  Tooling which frames messages manually, such as proxies, can use
+MessageHeader to read and write the headers on their own.
 
 To accomplish this, there is a generic interface for bitcoin messages named
 Message which allows messages of any type to be read, written, or passed around
@@ -41,8 +42,10 @@ https://en.bitcoin.it/wiki/Protocol_specification.
 The initial handshake consists of two peers sending each other a version message
 (MsgVersion) followed by responding with a verack message (MsgVerAck).  Both
 peers use the information in the version message (MsgVersion) to negotiate
-things such as protocol version and supported services with each other.  Once
-the initial handshake is complete, the following chart indicates message
+things such as protocol version and supported services with each other.
+NegotiateProtocolVersion returns the protocol version to use from the two
+version messages, and the ServiceFlag HasFlag method tests the services a peer
+advertises.  Once the initial handshake is complete, the following chart indicates message
 interactions in no particular order.
 
 	Peer A Sends                          Peer B Responds
@@ -84,8 +87,20 @@ message and which bitcoin network the message applies to.  This package provides
 the following constants:
 
 	btcwire.MainNet
-	btcwire.TestNet
+	btcwire.TestNet (also available as btcwire.RegTest)
 	btcwire.TestNet3
+	btcwire.SigNet
+	btcwire.SimNet
+
+TestNet is the regression test network bitcoind uses in regtest mode.
+
+The port conventionally used for peer-to-peer connections on each of these
+networks is available via the DefaultPort method.
+
+Messages for any other network, such as a private chain, can be read and
+written by passing its magic number converted to a BitcoinNet.  RegisterNet
+gives such a network a name and default port so it is handled like the
+networks above.
 
 Determining Message Type
 
@@ -120,6 +135,24 @@ a remote node running a bitcoin peer.  Example syntax is:
 		// Log and handle the error
 	}
 
+Messages may be decoded more strictly, for example to reject non-canonical
+variable length integers or unknown service bits, by passing a
+btcwire.DecodeOptions to ReadMessageWithOptions through the Decode field of
+btcwire.MessageOptions.  The zero value decodes the same as ReadMessage.
+
+A message can also be read in two phases to apply a policy based on its header
+before any of its payload is read:
+
+	hdr, err := btcwire.ReadMessageHeader(conn)
+	if err != nil {
+		// Log and handle the error
+	}
+	if hdr.Command == "tx" && hdr.Length > maxTxSize {
+		// Disconnect or ban the peer
+	}
+	msg, rawPayload, err := btcwire.ReadMessagePayload(conn, hdr, pver,
+		btcnet)
+
 Writing Messages
 
 In order to marshall bitcoin messages to the wire, use the WriteMessage
@@ -138,13 +171,37 @@ from a remote peer is:
 		// Log and handle the error
 	}
 
+Long-Term Storage
+
+Transactions, blocks, and block headers which are kept in long-term storage,
+such as a database or wallet, should be encoded with the Serialize and
+Deserialize methods of MsgTx, MsgBlock, and BlockHeader rather than BtcEncode
+and BtcDecode.  Their format does not depend on the protocol version, so
+stored data remains readable as the protocol evolves.
+
+Debugging
+
+Every message, as well as InvVect, NetAddress, BlockHeader, and OutPoint,
+implements fmt.Stringer with a short one-line summary suitable for logging peer
+traffic.  Wrapping a message in a JSONMessage marshals it to JSON along with
+its command, so protocol traces and test fixtures can be stored, diffed, and
+unmarshalled back into the concrete message type:
+
+	b, err := json.Marshal(btcwire.JSONMessage{Message: msg})
+
 Errors
 
 Errors returned by this package are either the raw errors provided by underlying
 calls to read/write from streams such as io.EOF, io.ErrUnexpectedEOF, and
 io.ErrShortWrite, or of type btcwire.MessageError.  This allows the caller to
 differentiate between general IO errors and malformed messages through type
-assertions.
+assertions.  The Code field of a btcwire.MessageError further identifies the
+kind of issue, such as btcwire.ErrBadChecksum, so callers can branch on it
+without inspecting the description.  Errors for messages from the wrong network,
+with an unknown command, or with a bad checksum also wrap the sentinel errors
+btcwire.ErrWrongNetwork, btcwire.ErrUnknownMessage, and
+btcwire.ErrInvalidChecksum respectively, so they may be matched with
+errors.Is.
 
 Bitcoin Improvement Proposals
 
@@ -152,11 +209,76 @@ This package includes spec changes outlined by the following BIPs:
 
 		BIP0031 (https://en.bitcoin.it/wiki/BIP_0031)
 		BIP0035 (https://en.bitcoin.it/wiki/BIP_0035)
+		BIP0037 (https://en.bitcoin.it/wiki/BIP_0037)
+		BIP0061 (https://en.bitcoin.it/wiki/BIP_0061)
+		BIP0064 (https://en.bitcoin.it/wiki/BIP_0064)
+		BIP0130 (https://en.bitcoin.it/wiki/BIP_0130)
+		BIP0133 (https://en.bitcoin.it/wiki/BIP_0133)
+		BIP0143 (https://en.bitcoin.it/wiki/BIP_0143)
+		BIP0144 (https://en.bitcoin.it/wiki/BIP_0144)
+		BIP0152 (https://en.bitcoin.it/wiki/BIP_0152)
+		BIP0155 (https://en.bitcoin.it/wiki/BIP_0155)
+		BIP0157 (https://en.bitcoin.it/wiki/BIP_0157)
+		BIP0339 (https://en.bitcoin.it/wiki/BIP_0339)
 
 Other important information
 
-The package does not yet implement BIP0037 (https://en.bitcoin.it/wiki/BIP_0037)
-and therefore does not recognize filterload, filteradd, filterclear, or
-merkleblock messages.
+Bloom filtering as defined by BIP0037 is supported by the relay flag of the
+version message and the filterload (MsgFilterLoad), filteradd (MsgFilterAdd),
+filterclear (MsgFilterClear) and merkleblock (MsgMerkleBlock) messages, which
+were added in protocol version BIP0037Version.  Peers which support bloom
+filtering advertise the SFNodeBloom service flag.  NewMsgMerkleBlockForBlock
+builds the partial merkle tree of a merkleblock for the transactions of a block
+matching a filter, and ExtractMatches validates a received one and returns the
+matched transaction hashes.
+
+Peers may ask to be announced new blocks with headers messages rather than inv
+messages by sending a sendheaders message (MsgSendHeaders) as defined by
+BIP0130, and to not be announced transactions paying less than a minimum fee
+rate by sending a feefilter message (MsgFeeFilter) as defined by BIP0133.
+They were added in protocol versions SendHeadersVersion and FeeFilterVersion.
+
+Segregated witness serialization as defined by BIP0144 is used for any
+transaction (MsgTx) with witness data, whether it is encoded on its own or as
+part of a block (MsgBlock).  The legacy serialization, which omits witness
+data, is available through SerializeNoWitness and is what TxSha hashes, while
+WTxSha hashes the witness serialization.  Peers which support witness data
+advertise the SFNodeWitness service flag and request it with the
+InvVect_WitnessTx and InvVect_WitnessBlock inventory vector types.
+
+Compact block relay as defined by BIP0152 is supported by the sendcmpct
+(MsgSendCmpct) and cmpctblock (MsgCmpctBlock) messages, which were added in
+protocol version SendCmpctVersion.  A peer which sends a sendcmpct message with
+AnnounceUsingCmpctBlock set asks to be announced new blocks directly with
+cmpctblock messages instead of inv messages.  The ShouldAnnounceCompact function
+reports whether a peer should be announced blocks this way and AnnounceBlock
+builds the announcement for a block accordingly.  Peers which did not ask for
+this mode may still request a compact block with a getdata message for an
+InvVect_CmpctBlock inventory vector.  Transactions of a compact block which are
+not in the memory pool of the receiver are requested with a getblocktxn message
+(MsgGetBlockTxn) and returned with a blocktxn message (MsgBlockTxn).  See
+NewMsgBlockTxnFromBlock.
+
+Addresses of networks such as Tor v3, I2P, and CJDNS are relayed with the
+addrv2 message (MsgAddrV2) as defined by BIP0155, which holds NetAddressV2
+addresses prefixed by their AddrNetworkID.  A peer with a protocol version of
+at least SendAddrV2Version signals that it wants addrv2 messages by sending a
+sendaddrv2 message (MsgSendAddrV2) before the verack message.  Every other peer
+must only be sent addr messages, so FilterAddrsForPeer converts the addresses
+which can be represented as a legacy NetAddress and omits the rest.
+
+Compact block filters for light clients as defined by BIP0157 and BIP0158 are
+served by peers which advertise the SFNodeCF service flag.  Filters are
+requested with getcfilters (MsgGetCFilters) and returned with cfilter
+(MsgCFilter), their headers are requested with getcfheaders (MsgGetCFHeaders)
+and returned with cfheaders (MsgCFHeaders), and the header checkpoints every
+CFCheckptInterval blocks are requested with getcfcheckpt (MsgGetCFCheckpt) and
+returned with cfcheckpt (MsgCFCheckpt).  These messages are only sent with
+protocol versions starting with CFilterVersion.
+
+Messages which are not built into this package, such as those of another
+network or an experimental protocol extension, can be decoded by registering a
+function which creates them with RegisterMessage.  Registered commands are
+reported by KnownCommands and LookupMessage along with the built-in ones.
 */
 package btcwire
@@ -5,19 +5,120 @@
 package btcwire
 
 import (
+	"errors"
 	"fmt"
 )
 
+// Sentinel errors for common message decoding failures.  A MessageError with
+// the corresponding error code wraps these, so callers may use errors.Is to
+// distinguish them, for example to decide whether a peer violated the
+// protocol or the stream was merely corrupted.
+var (
+	// ErrUnknownMessage is wrapped by errors for messages with an invalid
+	// or unsupported command (ErrUnknownCommand).
+	ErrUnknownMessage = errors.New("unknown message")
+
+	// ErrWrongNetwork is wrapped by errors for messages intended for a
+	// different bitcoin network (ErrNetworkMismatch).
+	ErrWrongNetwork = errors.New("message from wrong network")
+
+	// ErrInvalidChecksum is wrapped by errors for messages with a payload
+	// that does not match the header checksum (ErrBadChecksum).
+	ErrInvalidChecksum = errors.New("invalid message checksum")
+)
+
+// ErrorCode identifies a kind of issue with a message.  It is used in
+// MessageError to allow callers to programmatically determine the cause of the
+// error without relying on the description.
+type ErrorCode int
+
+// These constants are used to identify a specific MessageError.
+const (
+	// ErrMalformed indicates a message which is invalid for a reason not
+	// covered by one of the more specific error codes.
+	ErrMalformed ErrorCode = iota
+
+	// ErrTooManyItems indicates a message contains, or would contain,
+	// more of some type of item than is allowed.
+	ErrTooManyItems
+
+	// ErrPayloadTooLarge indicates a message payload, or a variable length
+	// field within it, exceeds the maximum allowed size.
+	ErrPayloadTooLarge
+
+	// ErrNonCanonicalVarInt indicates a variable length integer which was
+	// not encoded using the minimum number of bytes.  It is only returned
+	// when the CanonicalVarInts decode option is set.
+	ErrNonCanonicalVarInt
+
+	// ErrBadChecksum indicates the checksum in a message header does not
+	// match the checksum of the payload.
+	ErrBadChecksum
+
+	// ErrUnknownCommand indicates a message command which is invalid or is
+	// not supported by this package.
+	ErrUnknownCommand
+
+	// ErrNetworkMismatch indicates a message is for a different bitcoin
+	// network than the expected one.
+	ErrNetworkMismatch
+
+	// ErrProtocolVersion indicates a message which is not valid for the
+	// negotiated protocol version.
+	ErrProtocolVersion
+
+	// ErrUnknownService indicates a message which advertises service bits
+	// that are not known to this package.  It is only returned when the
+	// KnownServices decode option is set.
+	ErrUnknownService
+
+	// ErrUnknownInvType indicates an inventory vector with a type that is
+	// not known to this package.  It is only returned when the
+	// KnownInvTypes decode option is set.
+	ErrUnknownInvType
+)
+
+// Map of ErrorCode values to the sentinel errors they wrap.
+var errorCodeSentinels = map[ErrorCode]error{
+	ErrBadChecksum:     ErrInvalidChecksum,
+	ErrUnknownCommand:  ErrUnknownMessage,
+	ErrNetworkMismatch: ErrWrongNetwork,
+}
+
+// Map of ErrorCode values back to their constant names for pretty printing.
+var errorCodeStrings = map[ErrorCode]string{
+	ErrMalformed:          "ErrMalformed",
+	ErrTooManyItems:       "ErrTooManyItems",
+	ErrPayloadTooLarge:    "ErrPayloadTooLarge",
+	ErrNonCanonicalVarInt: "ErrNonCanonicalVarInt",
+	ErrBadChecksum:        "ErrBadChecksum",
+	ErrUnknownCommand:     "ErrUnknownCommand",
+	ErrNetworkMismatch:    "ErrNetworkMismatch",
+	ErrProtocolVersion:    "ErrProtocolVersion",
+	ErrUnknownService:     "ErrUnknownService",
+	ErrUnknownInvType:     "ErrUnknownInvType",
+}
+
+// String returns the ErrorCode as a human-readable name.
+func (e ErrorCode) String() string {
+	if s, ok := errorCodeStrings[e]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown ErrorCode (%d)", int(e))
+}
+
 // MessageError describes an issue with a message.
 // An example of some potential issues are messages from the wrong bitcoin
 // network, invalid commands, mismatched checksums, and exceeding max payloads.
 //
 // This provides a mechanism for the caller to type assert the error to
 // differentiate between general io errors such as io.EOF and issues that
-// resulted from malformed messages.
+// resulted from malformed messages.  The Code field may further be used to
+// determine the kind of issue.
 type MessageError struct {
-	Func        string // Function name
-	Description string // Human readable description of the issue
+	Func        string    // Function name
+	Code        ErrorCode // Kind of issue
+	Description string    // Human readable description of the issue
 }
 
 // Error satisfies the error interface and prints human-readable errors.
@@ -28,7 +129,15 @@ func (e *MessageError) Error() string {
 	return e.Description
 }
 
-// messageError creates an error for the given function and description.
-func messageError(f string, desc string) *MessageError {
-	return &MessageError{Func: f, Description: desc}
+// Unwrap returns the sentinel error which corresponds to the error code, if
+// any, so the error may be matched with errors.Is.  It returns nil for error
+// codes without a sentinel error.
+func (e *MessageError) Unwrap() error {
+	return errorCodeSentinels[e.Code]
+}
+
+// messageError creates an error for the given function, error code, and
+// description.
+func messageError(f string, c ErrorCode, desc string) *MessageError {
+	return &MessageError{Func: f, Code: c, Description: desc}
 }
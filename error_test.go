@@ -0,0 +1,67 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"errors"
+	"github.com/conformal/btcwire"
+	"testing"
+)
+
+// TestErrorCodeStringer tests the stringized output for the ErrorCode type.
+func TestErrorCodeStringer(t *testing.T) {
+	tests := []struct {
+		in   btcwire.ErrorCode
+		want string
+	}{
+		{btcwire.ErrMalformed, "ErrMalformed"},
+		{btcwire.ErrTooManyItems, "ErrTooManyItems"},
+		{btcwire.ErrPayloadTooLarge, "ErrPayloadTooLarge"},
+		{btcwire.ErrNonCanonicalVarInt, "ErrNonCanonicalVarInt"},
+		{btcwire.ErrBadChecksum, "ErrBadChecksum"},
+		{btcwire.ErrUnknownCommand, "ErrUnknownCommand"},
+		{btcwire.ErrNetworkMismatch, "ErrNetworkMismatch"},
+		{btcwire.ErrProtocolVersion, "ErrProtocolVersion"},
+		{btcwire.ErrUnknownService, "ErrUnknownService"},
+		{btcwire.ErrUnknownInvType, "ErrUnknownInvType"},
+		{0xffff, "Unknown ErrorCode (65535)"},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		result := test.in.String()
+		if result != test.want {
+			t.Errorf("String #%d\n got: %s want: %s", i, result,
+				test.want)
+			continue
+		}
+	}
+}
+
+// TestMessageErrorCode ensures the error code of errors returned by the
+// package can be inspected with errors.As.
+func TestMessageErrorCode(t *testing.T) {
+	msg := btcwire.NewMsgInv()
+	for i := 0; i < btcwire.MaxInvPerMsg; i++ {
+		msg.AddInvVect(btcwire.NewInvVect(btcwire.InvVect_Tx,
+			&btcwire.ShaHash{}))
+	}
+	err := msg.AddInvVect(btcwire.NewInvVect(btcwire.InvVect_Tx,
+		&btcwire.ShaHash{}))
+
+	var msgErr *btcwire.MessageError
+	if !errors.As(err, &msgErr) {
+		t.Fatalf("AddInvVect: wrong error type - got %T, want %T",
+			err, msgErr)
+	}
+	if msgErr.Code != btcwire.ErrTooManyItems {
+		t.Errorf("AddInvVect: wrong error code - got %v, want %v",
+			msgErr.Code, btcwire.ErrTooManyItems)
+	}
+	if msgErr.Func != "MsgInv.AddInvVect" {
+		t.Errorf("AddInvVect: wrong function - got %v, want %v",
+			msgErr.Func, "MsgInv.AddInvVect")
+	}
+}
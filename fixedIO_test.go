@@ -39,6 +39,18 @@ func newFixedWriter(max int) *fixedWriter {
 	return &fw
 }
 
+// countingWriter implements the io.Writer interface and counts the number of
+// bytes written to it while discarding them.
+type countingWriter struct {
+	n int
+}
+
+// Write ...
+func (w *countingWriter) Write(p []byte) (n int, err error) {
+	w.n += len(p)
+	return len(p), nil
+}
+
 // fixedReader implements the io.Reader interface and intentially allows
 // testing of error paths by forcing short reads.
 type fixedReader struct {
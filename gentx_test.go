@@ -0,0 +1,115 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"github.com/conformal/btcwire"
+	"math/rand"
+)
+
+// Limits used by GenTx when generating transactions.
+const (
+	// genTxMaxInputs is the maximum number of inputs in a generated
+	// transaction.
+	genTxMaxInputs = 8
+
+	// genTxMaxOutputs is the maximum number of outputs in a generated
+	// transaction.
+	genTxMaxOutputs = 8
+
+	// genTxMaxWitnessItems is the maximum number of items in the witness
+	// of a generated transaction input.
+	genTxMaxWitnessItems = 4
+
+	// genTxMaxScriptLen is the maximum length of a generated script.  It
+	// is large enough that both single byte and multi-byte varints are
+	// used for script lengths.
+	genTxMaxScriptLen = 600
+)
+
+// genScript returns a script of pseudo-random length and contents using rng.
+// Most scripts are short like typical signature and public key scripts, but
+// some are long enough to require a multi-byte varint for their length.
+func genScript(rng *rand.Rand) []byte {
+	var scriptLen int
+	switch rng.Intn(4) {
+	case 0:
+		scriptLen = 0
+	case 1:
+		scriptLen = rng.Intn(genTxMaxScriptLen + 1)
+	default:
+		scriptLen = rng.Intn(0x100)
+	}
+
+	// The script is always a non-nil slice since that is what decoding a
+	// zero length script produces.
+	script := make([]byte, scriptLen)
+	rng.Read(script)
+	return script
+}
+
+// genWitness returns a transaction input witness with a pseudo-random number
+// of items using rng.
+func genWitness(rng *rand.Rand) btcwire.TxWitness {
+	witness := make(btcwire.TxWitness, rng.Intn(genTxMaxWitnessItems+1))
+	for i := range witness {
+		witness[i] = genScript(rng)
+	}
+	return witness
+}
+
+// GenTx returns a pseudo-random, but structurally valid, transaction using
+// rng.  The same sequence of transactions is generated for the same seed, so
+// it is suitable for reproducible round-trip tests of the transaction codec.
+// Generated transactions always have at least one input and one output, and
+// roughly half of them have witness data.
+func GenTx(rng *rand.Rand) *btcwire.MsgTx {
+	tx := btcwire.NewMsgTx()
+	switch rng.Intn(3) {
+	case 0:
+		tx.Version = btcwire.TxVersionInitial
+	case 1:
+		tx.Version = btcwire.TxVersionSequenceLock
+	default:
+		tx.Version = rng.Uint32()
+	}
+
+	numInputs := rng.Intn(genTxMaxInputs) + 1
+	for i := 0; i < numInputs; i++ {
+		var hash btcwire.ShaHash
+		rng.Read(hash[:])
+		prevOut := btcwire.NewOutPoint(&hash, rng.Uint32())
+		txIn := btcwire.NewTxIn(prevOut, genScript(rng))
+		if rng.Intn(2) == 0 {
+			txIn.Sequence = rng.Uint32()
+		}
+		tx.AddTxIn(txIn)
+	}
+
+	// Give roughly half of the transactions witness data.  Every input of
+	// a witness transaction has a non-nil witness, since that is what
+	// decoding the witness serialization produces, and at least one of the
+	// witnesses is not empty.
+	if rng.Intn(2) == 0 {
+		for _, txIn := range tx.TxIn {
+			txIn.Witness = genWitness(rng)
+		}
+		if !tx.HasWitness() {
+			tx.TxIn[0].Witness = append(tx.TxIn[0].Witness,
+				genScript(rng))
+		}
+	}
+
+	numOutputs := rng.Intn(genTxMaxOutputs) + 1
+	for i := 0; i < numOutputs; i++ {
+		// Values up to the maximum number of satoshi which will ever
+		// exist.
+		value := rng.Int63n(21e14 + 1)
+		tx.AddTxOut(btcwire.NewTxOut(value, genScript(rng)))
+	}
+
+	tx.LockTime = rng.Uint32()
+	return tx
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"sync"
+)
+
+// HashedTx wraps a transaction (MsgTx) and caches its hash and witness hash the
+// first time each of them is requested.  Code which needs the hashes of the
+// same transactions repeatedly, such as when building inventory vectors for
+// many peers, then only serializes and hashes each transaction once.
+//
+// The cached hashes are not invalidated, so the wrapped transaction must not be
+// modified once it is wrapped.  A HashedTx is safe for concurrent use by
+// multiple goroutines.
+type HashedTx struct {
+	tx *MsgTx
+
+	txShaOnce sync.Once
+	txSha     ShaHash
+
+	wtxShaOnce sync.Once
+	wtxSha     ShaHash
+}
+
+// MsgTx returns the wrapped transaction.  It must not be modified.
+func (t *HashedTx) MsgTx() *MsgTx {
+	return t.tx
+}
+
+// TxSha returns the hash of the wrapped transaction, computing it with
+// MsgTx.TxSha the first time it is called.
+func (t *HashedTx) TxSha() ShaHash {
+	t.txShaOnce.Do(func() {
+		// Ignore the error since TxSha can't currently fail.
+		t.txSha, _ = t.tx.TxSha(ProtocolVersion)
+	})
+	return t.txSha
+}
+
+// WTxSha returns the witness hash of the wrapped transaction, computing it with
+// MsgTx.WTxSha the first time it is called.  For transactions without witness
+// data, it is the same as TxSha and is only computed once for both.
+func (t *HashedTx) WTxSha() ShaHash {
+	t.wtxShaOnce.Do(func() {
+		if !t.tx.HasWitness() {
+			t.wtxSha = t.TxSha()
+			return
+		}
+
+		// Ignore the error since WTxSha can't currently fail.
+		t.wtxSha, _ = t.tx.WTxSha()
+	})
+	return t.wtxSha
+}
+
+// NewHashedTx returns a new HashedTx which wraps the passed transaction.  See
+// HashedTx for details.
+func NewHashedTx(tx *MsgTx) *HashedTx {
+	return &HashedTx{tx: tx}
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"github.com/conformal/btcwire"
+	"sync"
+	"testing"
+)
+
+// TestHashedTx tests the HashedTx API returns the hashes of the wrapped
+// transaction and only computes them once.
+func TestHashedTx(t *testing.T) {
+	tests := []*btcwire.MsgTx{multiTx, witnessTx}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		wantTxSha, _ := test.TxSha(btcwire.ProtocolVersion)
+		wantWTxSha, _ := test.WTxSha()
+
+		tx := btcwire.NewHashedTx(test.Copy())
+		if got := tx.TxSha(); got != wantTxSha {
+			t.Errorf("TxSha #%d: wrong hash - got %v, want %v", i,
+				got, wantTxSha)
+			continue
+		}
+		if got := tx.WTxSha(); got != wantWTxSha {
+			t.Errorf("WTxSha #%d: wrong hash - got %v, want %v", i,
+				got, wantWTxSha)
+			continue
+		}
+
+		// Ensure the hashes are cached rather than recomputed, so
+		// they are unchanged after modifying the wrapped transaction
+		// against the usage contract.
+		tx.MsgTx().LockTime++
+		tx.MsgTx().TxIn[0].Witness = btcwire.TxWitness{{0x01}}
+		if got := tx.TxSha(); got != wantTxSha {
+			t.Errorf("TxSha #%d: hash was recomputed - got %v, "+
+				"want %v", i, got, wantTxSha)
+			continue
+		}
+		if got := tx.WTxSha(); got != wantWTxSha {
+			t.Errorf("WTxSha #%d: hash was recomputed - got %v, "+
+				"want %v", i, got, wantWTxSha)
+			continue
+		}
+	}
+
+	// Ensure the hashes may be requested concurrently.
+	tx := btcwire.NewHashedTx(witnessTx)
+	wantWTxSha, _ := witnessTx.WTxSha()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := tx.WTxSha(); got != wantWTxSha {
+				t.Errorf("WTxSha: wrong hash - got %v, want %v",
+					got, wantWTxSha)
+			}
+		}()
+	}
+	wg.Wait()
+}
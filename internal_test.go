@@ -13,6 +13,7 @@ package btcwire
 
 import (
 	"io"
+	"time"
 )
 
 // MaxMessagePayload makes the internal maxMessagePayload constant available to
@@ -25,6 +26,18 @@ func TstRandomUint64(r io.Reader) (uint64, error) {
 	return randomUint64(r)
 }
 
+// TstReadElement makes the internal readElement function available to the
+// test package.
+func TstReadElement(r io.Reader, element interface{}) error {
+	return readElement(r, element)
+}
+
+// TstWriteElement makes the internal writeElement function available to the
+// test package.
+func TstWriteElement(w io.Writer, element interface{}) error {
+	return writeElement(w, element)
+}
+
 // TstReadVarInt makes the internal readVarInt function available to the
 // test package.
 func TstReadVarInt(r io.Reader, pver uint32) (uint64, error) {
@@ -61,10 +74,22 @@ func TstWriteNetAddress(w io.Writer, pver uint32, na *NetAddress, ts bool) error
 	return writeNetAddress(w, pver, na, ts)
 }
 
+// TstReadNetAddressV2 makes the internal readNetAddressV2 function available to
+// the test package.
+func TstReadNetAddressV2(r io.Reader, pver uint32, na *NetAddressV2) error {
+	return readNetAddressV2(r, pver, na)
+}
+
+// TstWriteNetAddressV2 makes the internal writeNetAddressV2 function available
+// to the test package.
+func TstWriteNetAddressV2(w io.Writer, pver uint32, na *NetAddressV2) error {
+	return writeNetAddressV2(w, pver, na)
+}
+
 // TstMaxNetAddressPayload makes the internal maxNetAddressPayload function
 // available to the test package.
 func TstMaxNetAddressPayload(pver uint32) uint32 {
-	return maxNetAddressPayload(pver)
+	return maxNetAddressPayload(pver, true)
 }
 
 // TstReadInvVect makes the internal readInvVect function available to the test
@@ -91,8 +116,19 @@ func TstWriteBlockHeader(w io.Writer, pver uint32, bh *BlockHeader) error {
 	return writeBlockHeader(w, pver, bh)
 }
 
-// TstReadMessageHeader makes the internal readMessageHeader function available
-// to the test package.
-func TstReadMessageHeader(r io.Reader) (*messageHeader, error) {
-	return readMessageHeader(r)
+// TstSetNonceRegistryClock replaces the function used by the registry to get
+// the current time so expiration can be tested deterministically.
+func TstSetNonceRegistryClock(r *NonceRegistry, now func() time.Time) {
+	r.mtx.Lock()
+	r.now = now
+	r.mtx.Unlock()
+}
+
+// TstSetPingTrackerClock replaces the function used by the tracker to get the
+// current time so round-trip times and expiration can be tested
+// deterministically.
+func TstSetPingTrackerClock(pt *PingTracker, now func() time.Time) {
+	pt.mtx.Lock()
+	pt.now = now
+	pt.mtx.Unlock()
 }
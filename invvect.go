@@ -11,7 +11,9 @@ import (
 
 const (
 	// MaxInvPerMsg is the maximum number of inventory vectors that can be in a
-	// single bitcoin inv message.
+	// single bitcoin inv message.  The same limit applies to the getdata
+	// (MsgGetData) and notfound (MsgNotFound) messages.  See
+	// MaxItemsForCommand to query the limits of all messages by command.
 	MaxInvPerMsg = 50000
 
 	// Maximum payload size for an inventory vector.
@@ -21,17 +23,31 @@ const (
 // InvType represents the allowed types of inventory vectors.  See InvVect.
 type InvType uint32
 
+// InvWitnessFlag denotes that the inventory vector type is requesting, or
+// sending, a version which includes witness data.
+const InvWitnessFlag = 1 << 30
+
 const (
-	InvVect_Error InvType = 0
-	InvVect_Tx    InvType = 1
-	InvVect_Block InvType = 2
+	InvVect_Error                InvType = 0
+	InvVect_Tx                   InvType = 1
+	InvVect_Block                InvType = 2
+	InvVect_FilteredBlock        InvType = 3
+	InvVect_CmpctBlock           InvType = 4
+	InvVect_WitnessTx            InvType = InvVect_Tx | InvWitnessFlag
+	InvVect_WitnessBlock         InvType = InvVect_Block | InvWitnessFlag
+	InvVect_FilteredWitnessBlock InvType = InvVect_FilteredBlock | InvWitnessFlag
 )
 
 // Map of service flags back to their constant names for pretty printing.
 var ivStrings = map[InvType]string{
-	InvVect_Error: "ERROR",
-	InvVect_Tx:    "MSG_TX",
-	InvVect_Block: "MSG_BLOCK",
+	InvVect_Error:                "ERROR",
+	InvVect_Tx:                   "MSG_TX",
+	InvVect_Block:                "MSG_BLOCK",
+	InvVect_FilteredBlock:        "MSG_FILTERED_BLOCK",
+	InvVect_CmpctBlock:           "MSG_CMPCT_BLOCK",
+	InvVect_WitnessTx:            "MSG_WITNESS_TX",
+	InvVect_WitnessBlock:         "MSG_WITNESS_BLOCK",
+	InvVect_FilteredWitnessBlock: "MSG_FILTERED_WITNESS_BLOCK",
 }
 
 // String returns the InvType in human-readable form.
@@ -43,6 +59,35 @@ func (invtype InvType) String() string {
 	return fmt.Sprintf("Unknown InvType (%d)", uint32(invtype))
 }
 
+// Witness returns the inventory type which requests the same data along with
+// its witness data.  Transactions and blocks map to InvVect_WitnessTx and
+// InvVect_WitnessBlock respectively.  Every other type, including those which
+// already request witness data, filtered blocks, and unknown types, is
+// returned unchanged, so it is idempotent.
+func (invtype InvType) Witness() InvType {
+	switch invtype {
+	case InvVect_Tx:
+		return InvVect_WitnessTx
+	case InvVect_Block:
+		return InvVect_WitnessBlock
+	}
+	return invtype
+}
+
+// NonWitness returns the inventory type which requests the same data without
+// its witness data.  It is the reverse of Witness, so witness transactions and
+// blocks map to InvVect_Tx and InvVect_Block respectively and every other type
+// is returned unchanged.
+func (invtype InvType) NonWitness() InvType {
+	switch invtype {
+	case InvVect_WitnessTx:
+		return InvVect_Tx
+	case InvVect_WitnessBlock:
+		return InvVect_Block
+	}
+	return invtype
+}
+
 // InvVect defines a bitcoin inventory vector which is used to describe data,
 // as specified by the Type field, that a peer wants, has, or does not have to
 // another peer.
@@ -51,7 +96,8 @@ type InvVect struct {
 	Hash ShaHash // Hash of the data
 }
 
-// NewInvVect returns a new InvVect using the provided type and hash.
+// NewInvVect returns a new InvVect using the provided type and hash.  The
+// hash is copied, so the caller is free to modify it afterwards.
 func NewInvVect(typ InvType, hash *ShaHash) *InvVect {
 	return &InvVect{
 		Type: typ,
@@ -59,21 +105,128 @@ func NewInvVect(typ InvType, hash *ShaHash) *InvVect {
 	}
 }
 
+// Equal returns whether or not the inventory vector has the same type and
+// hash as other.
+func (iv *InvVect) Equal(other *InvVect) bool {
+	return iv.Type == other.Type && iv.Hash.IsEqual(&other.Hash)
+}
+
+// String returns the inventory vector in human-readable form.
+func (iv InvVect) String() string {
+	return iv.Type.String() + " " + iv.Hash.String()
+}
+
 // readInvVect reads an encoded InvVect from r depending on the protocol
-// version.
+// version.  The type is read as a plain 32-bit value without any masking, so
+// flags such as InvWitnessFlag and unknown types are preserved unless the
+// KnownInvTypes decode option is set.
 func readInvVect(r io.Reader, pver uint32, iv *InvVect) error {
 	err := readElements(r, &iv.Type, &iv.Hash)
 	if err != nil {
 		return err
 	}
+
+	// Reject unknown types when the decode options require known types.
+	if _, ok := ivStrings[iv.Type]; !ok && decodeOptions(r).KnownInvTypes {
+		str := fmt.Sprintf("unknown inventory vector type %v", iv.Type)
+		return messageError("readInvVect", ErrUnknownInvType, str)
+	}
 	return nil
 }
 
 // writeInvVect serializes an InvVect to w depending on the protocol version.
 func writeInvVect(w io.Writer, pver uint32, iv *InvVect) error {
-	err := writeElements(w, iv.Type, iv.Hash)
+	err := writeElements(w, iv.Type, &iv.Hash)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// addInvVect appends iv to invList unless doing so would exceed the maximum
+// number of inventory vectors allowed per message.  This is shared by the
+// messages which consist of a list of inventory vectors.  The fn parameter is
+// only used for the error.
+func addInvVect(invList []*InvVect, iv *InvVect, fn string) ([]*InvVect, error) {
+	if len(invList)+1 > MaxInvPerMsg {
+		str := fmt.Sprintf("too many invvect in message [max %v]",
+			MaxInvPerMsg)
+		return invList, messageError(fn, ErrTooManyItems, str)
+	}
+
+	return append(invList, iv), nil
+}
+
+// readInvList reads a count prefixed list of inventory vectors from r and
+// appends them to invList.  The fn parameter is only used for the error.
+func readInvList(r io.Reader, pver uint32, invList []*InvVect,
+	fn string) ([]*InvVect, error) {
+
+	count, err := readVarInt(r, pver)
+	if err != nil {
+		return invList, err
+	}
+
+	// Limit to max inventory vectors per message.
+	if count > MaxInvPerMsg {
+		str := fmt.Sprintf("too many invvect in message [%v]", count)
+		return invList, messageError(fn, ErrTooManyItems, str)
+	}
+
+	for i := uint64(0); i < count; i++ {
+		iv := InvVect{}
+		err := readInvVect(r, pver, &iv)
+		if err != nil {
+			return invList, err
+		}
+		invList, _ = addInvVect(invList, &iv, fn)
+	}
+
+	return invList, nil
+}
+
+// writeInvList writes invList to w as a count prefixed list of inventory
+// vectors.  The fn parameter is only used for the error.
+func writeInvList(w io.Writer, pver uint32, invList []*InvVect, fn string) error {
+	// Limit to max inventory vectors per message.
+	count := len(invList)
+	if count > MaxInvPerMsg {
+		str := fmt.Sprintf("too many invvect in message [%v]", count)
+		return messageError(fn, ErrTooManyItems, str)
+	}
+
+	err := writeVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+
+	for _, iv := range invList {
+		err := writeInvVect(w, pver, iv)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dedupInvList removes all but the first of any inventory vectors in invList
+// which are equal to one another in place and returns the updated list.  The
+// order of the remaining inventory vectors is preserved.
+func dedupInvList(invList []*InvVect) []*InvVect {
+	seen := make(map[InvVect]struct{}, len(invList))
+	deduped := invList[:0]
+	for _, iv := range invList {
+		if _, ok := seen[*iv]; ok {
+			continue
+		}
+		seen[*iv] = struct{}{}
+		deduped = append(deduped, iv)
+	}
+
+	// Clear the now unused tail so the removed vectors can be collected.
+	for i := len(deduped); i < len(invList); i++ {
+		invList[i] = nil
+	}
+	return deduped
+}
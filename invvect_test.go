@@ -21,6 +21,11 @@ func TestInvTypeStringer(t *testing.T) {
 		{btcwire.InvVect_Error, "ERROR"},
 		{btcwire.InvVect_Tx, "MSG_TX"},
 		{btcwire.InvVect_Block, "MSG_BLOCK"},
+		{btcwire.InvVect_FilteredBlock, "MSG_FILTERED_BLOCK"},
+		{btcwire.InvVect_CmpctBlock, "MSG_CMPCT_BLOCK"},
+		{btcwire.InvVect_WitnessTx, "MSG_WITNESS_TX"},
+		{btcwire.InvVect_WitnessBlock, "MSG_WITNESS_BLOCK"},
+		{btcwire.InvVect_FilteredWitnessBlock, "MSG_FILTERED_WITNESS_BLOCK"},
 		{0xffffffff, "Unknown InvType (4294967295)"},
 	}
 
@@ -36,6 +41,83 @@ func TestInvTypeStringer(t *testing.T) {
 
 }
 
+// TestInvVectStringer tests the stringized output for inventory vectors.
+func TestInvVectStringer(t *testing.T) {
+	hashStr := "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+	tests := []struct {
+		in   *btcwire.InvVect
+		want string
+	}{
+		{btcwire.NewInvVect(btcwire.InvVect_Tx, &btcwire.GenesisHash),
+			"MSG_TX " + hashStr},
+		{btcwire.NewInvVect(0xffffffff, &btcwire.GenesisHash),
+			"Unknown InvType (4294967295) " + hashStr},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		result := test.in.String()
+		if result != test.want {
+			t.Errorf("String #%d\n got: %s want: %s", i, result,
+				test.want)
+			continue
+		}
+	}
+}
+
+// TestInvTypeWitness tests mapping inventory vector types to and from their
+// witness counterparts.
+func TestInvTypeWitness(t *testing.T) {
+	tests := []struct {
+		in         btcwire.InvType // Inventory vector type
+		witness    btcwire.InvType // Expected witness type
+		nonWitness btcwire.InvType // Expected non-witness type
+	}{
+		{btcwire.InvVect_Error, btcwire.InvVect_Error, btcwire.InvVect_Error},
+		{btcwire.InvVect_Tx, btcwire.InvVect_WitnessTx, btcwire.InvVect_Tx},
+		{btcwire.InvVect_Block, btcwire.InvVect_WitnessBlock,
+			btcwire.InvVect_Block},
+		{btcwire.InvVect_FilteredBlock, btcwire.InvVect_FilteredBlock,
+			btcwire.InvVect_FilteredBlock},
+		{btcwire.InvVect_CmpctBlock, btcwire.InvVect_CmpctBlock,
+			btcwire.InvVect_CmpctBlock},
+		{btcwire.InvVect_WitnessTx, btcwire.InvVect_WitnessTx,
+			btcwire.InvVect_Tx},
+		{btcwire.InvVect_WitnessBlock, btcwire.InvVect_WitnessBlock,
+			btcwire.InvVect_Block},
+		{btcwire.InvVect_FilteredWitnessBlock,
+			btcwire.InvVect_FilteredWitnessBlock,
+			btcwire.InvVect_FilteredWitnessBlock},
+		{0xffffffff, 0xffffffff, 0xffffffff},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		if got := test.in.Witness(); got != test.witness {
+			t.Errorf("Witness #%d (%v): got %v, want %v", i, test.in,
+				got, test.witness)
+			continue
+		}
+		if got := test.in.NonWitness(); got != test.nonWitness {
+			t.Errorf("NonWitness #%d (%v): got %v, want %v", i,
+				test.in, got, test.nonWitness)
+			continue
+		}
+
+		// Ensure both mappings are idempotent.
+		if got := test.witness.Witness(); got != test.witness {
+			t.Errorf("Witness #%d (%v): not idempotent - got %v", i,
+				test.in, got)
+			continue
+		}
+		if got := test.nonWitness.NonWitness(); got != test.nonWitness {
+			t.Errorf("NonWitness #%d (%v): not idempotent - got %v",
+				i, test.in, got)
+			continue
+		}
+	}
+}
+
 // TestInvVect tests the InvVect API.
 func TestInvVect(t *testing.T) {
 	ivType := btcwire.InvVect_Block
@@ -52,6 +134,25 @@ func TestInvVect(t *testing.T) {
 			spew.Sdump(iv.Hash), spew.Sdump(hash))
 	}
 
+	// Ensure the hash was copied.
+	hash[0] = 0x01
+	if iv.Hash.IsEqual(&hash) {
+		t.Errorf("NewInvVect: hash is shared with the caller")
+	}
+
+	// Ensure equality depends on both the type and the hash.
+	if !iv.Equal(btcwire.NewInvVect(ivType, &btcwire.ShaHash{})) {
+		t.Errorf("Equal: inventory vectors with same type and hash " +
+			"are not equal")
+	}
+	if iv.Equal(btcwire.NewInvVect(btcwire.InvVect_Tx, &btcwire.ShaHash{})) {
+		t.Errorf("Equal: inventory vectors with different types are " +
+			"equal")
+	}
+	if iv.Equal(btcwire.NewInvVect(ivType, &hash)) {
+		t.Errorf("Equal: inventory vectors with different hashes are " +
+			"equal")
+	}
 }
 
 // TestInvVectWire tests the InvVect wire encode and decode for various
@@ -0,0 +1,80 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"encoding/json"
+)
+
+// JSONMessage wraps a Message so it can be marshalled to and unmarshalled from
+// JSON along with its command, such as to log protocol traces or to store test
+// fixtures in a human-readable form.  The message is encoded as an object
+// holding the command and the fields of the message:
+//
+//	{"command":"ping","message":{"Nonce":1}}
+//
+// Hashes are encoded as strings like ShaHash.String and byte slices such as
+// scripts are base64 encoded as usual for JSON.  Unmarshalling creates a new
+// message for the command like ReadMessage does, so the commands built into
+// this package and those registered with RegisterMessage are supported.
+type JSONMessage struct {
+	Message Message
+}
+
+// jsonMessage is the JSON encoding of a JSONMessage.
+type jsonMessage struct {
+	Command string          `json:"command"`
+	Message json.RawMessage `json:"message"`
+}
+
+// MarshalJSON encodes the wrapped message and its command as JSON.  A nil
+// message is encoded as null.  This is part of the json.Marshaler interface
+// implementation.
+func (m JSONMessage) MarshalJSON() ([]byte, error) {
+	if m.Message == nil {
+		return []byte("null"), nil
+	}
+
+	payload, err := json.Marshal(m.Message)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonMessage{
+		Command: m.Message.Command(),
+		Message: payload,
+	})
+}
+
+// UnmarshalJSON decodes a message and its command encoded by MarshalJSON into
+// the receiver.  A MessageError with ErrUnknownCommand is returned for
+// commands which are not known.  This is part of the json.Unmarshaler
+// interface implementation.
+func (m *JSONMessage) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		m.Message = nil
+		return nil
+	}
+
+	var jm jsonMessage
+	err := json.Unmarshal(b, &jm)
+	if err != nil {
+		return err
+	}
+
+	msg, err := makeEmptyMessage(jm.Command)
+	if err != nil {
+		return messageError("JSONMessage.UnmarshalJSON",
+			ErrUnknownCommand, err.Error())
+	}
+	if len(jm.Message) != 0 {
+		err = json.Unmarshal(jm.Message, msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.Message = msg
+	return nil
+}
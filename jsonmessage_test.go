@@ -0,0 +1,177 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestJSONMessage tests that messages wrapped in a JSONMessage round trip
+// through JSON unchanged.
+func TestJSONMessage(t *testing.T) {
+	pver := btcwire.CFilterVersion
+
+	addr := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 8333}
+	na, err := btcwire.NewNetAddress(addr, btcwire.SFNodeNetwork)
+	if err != nil {
+		t.Errorf("NewNetAddress: %v", err)
+		return
+	}
+	na.Timestamp = time.Unix(0x495fab29, 0)
+	msgAddr := btcwire.NewMsgAddr()
+	msgAddr.AddAddress(na)
+
+	msgVersion := btcwire.NewMsgVersion(na, na, 123123, "/test:0.0.1/", 0)
+	msgVersion.Timestamp = time.Unix(0x495fab29, 0)
+
+	msgInv := btcwire.NewMsgInv()
+	msgInv.AddInvVect(btcwire.NewInvVect(btcwire.InvVect_Block,
+		&blockOne.Header.PrevBlock))
+
+	// Alert with a payload and signature which are not valid UTF-8.
+	msgAlert := btcwire.NewMsgAlert("\xff\x00payload", "\xfe\x01signature")
+
+	tests := []btcwire.Message{
+		&blockOne,
+		multiTx,
+		witnessTx,
+		msgVersion,
+		btcwire.NewMsgVerAck(),
+		msgAddr,
+		msgInv,
+		msgAlert,
+		btcwire.NewMsgPing(123123),
+		btcwire.NewMsgReject("block", btcwire.RejectDuplicate,
+			"duplicate block"),
+		&cmpctBlockOne,
+		&merkleBlockOne,
+		&blockTxnOne,
+		&getBlockTxnOne,
+		baseCFCheckpt,
+		baseCFHeaders,
+		baseCFilter,
+		baseFilterAdd,
+		baseFilterLoad,
+		feeFilter,
+		sendCmpct,
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		b, err := json.Marshal(btcwire.JSONMessage{Message: test})
+		if err != nil {
+			t.Errorf("Marshal #%d (%s) error %v", i, test.Command(),
+				err)
+			continue
+		}
+
+		var msg btcwire.JSONMessage
+		err = json.Unmarshal(b, &msg)
+		if err != nil {
+			t.Errorf("Unmarshal #%d (%s) error %v", i, test.Command(),
+				err)
+			continue
+		}
+		if equal, desc := btcwire.EqualMessage(msg.Message, test,
+			pver); !equal {
+
+			t.Errorf("Unmarshal #%d (%s) wrong message - %s\n got: %s "+
+				"want: %s", i, test.Command(), desc,
+				spew.Sdump(msg.Message), spew.Sdump(test))
+			continue
+		}
+	}
+}
+
+// TestJSONMessageEncoding tests the JSON encoding of messages wrapped in a
+// JSONMessage.
+func TestJSONMessageEncoding(t *testing.T) {
+	getCFCheckpt := btcwire.NewMsgGetCFCheckpt(btcwire.GCSFilterRegular,
+		&btcwire.GenesisHash)
+
+	tests := []struct {
+		in   btcwire.JSONMessage // Message to encode
+		want string              // Expected JSON encoding
+	}{
+		{
+			btcwire.JSONMessage{Message: btcwire.NewMsgPing(1)},
+			`{"command":"ping","message":{"Nonce":1}}`,
+		},
+		{
+			btcwire.JSONMessage{Message: btcwire.NewMsgVerAck()},
+			`{"command":"verack","message":{}}`,
+		},
+		{
+			btcwire.JSONMessage{Message: getCFCheckpt},
+			`{"command":"getcfcheckpt","message":{"FilterType":0,` +
+				`"StopHash":"000000000019d6689c085ae165831e934ff7` +
+				`63ae46a2a6c172b3f1b60a8ce26f"}}`,
+		},
+		{btcwire.JSONMessage{}, `null`},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		b, err := json.Marshal(test.in)
+		if err != nil {
+			t.Errorf("Marshal #%d error %v", i, err)
+			continue
+		}
+		if string(b) != test.want {
+			t.Errorf("Marshal #%d\n got: %s want: %s", i, b,
+				test.want)
+			continue
+		}
+
+		var msg btcwire.JSONMessage
+		err = json.Unmarshal(b, &msg)
+		if err != nil {
+			t.Errorf("Unmarshal #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(msg, test.in) {
+			t.Errorf("Unmarshal #%d\n got: %s want: %s", i,
+				spew.Sdump(msg), spew.Sdump(test.in))
+			continue
+		}
+	}
+}
+
+// TestJSONMessageErrors tests that unmarshalling a JSONMessage fails for
+// malformed JSON and unknown commands.
+func TestJSONMessageErrors(t *testing.T) {
+	tests := []struct {
+		in      string // JSON to unmarshal
+		wantErr error  // Expected wrapped error, if any
+	}{
+		{`{"command":"bogus","message":{}}`, btcwire.ErrUnknownMessage},
+		{`{"command":"","message":{}}`, btcwire.ErrUnknownMessage},
+		{`{"command":"ping","message":{"Nonce":"one"}}`, nil},
+		{`{"command":"getcfcheckpt","message":{"StopHash":"xyz"}}`, nil},
+		{`[]`, nil},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		var msg btcwire.JSONMessage
+		err := json.Unmarshal([]byte(test.in), &msg)
+		if err == nil {
+			t.Errorf("Unmarshal #%d: no error for %s", i, test.in)
+			continue
+		}
+		if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+			t.Errorf("Unmarshal #%d wrong error got: %v, want: %v",
+				i, err, test.wantErr)
+			continue
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+// hashMerkleBranches takes two hashes, treated as the left and right tree
+// nodes, and returns the hash of their concatenation.  This is a helper
+// function used to aid in the generation of a merkle tree.
+func hashMerkleBranches(left *ShaHash, right *ShaHash) ShaHash {
+	// Concatenate the left and right nodes.
+	var sha [HashSize * 2]byte
+	copy(sha[:HashSize], left[:])
+	copy(sha[HashSize:], right[:])
+
+	return DoubleSha256SH(sha[:])
+}
+
+// CheckMerkleBranch returns whether or not the provided merkle branch proves
+// the inclusion of the transaction identified by txHash at position index in
+// the merkle tree with the provided root.
+//
+// The branch consists of the sibling hash at each level of the tree starting
+// with the leaf level.  Matching bitcoind, the low bit of the index at each
+// level determines whether the sibling is on the left (set) or the right
+// (unset) of the running hash before both are hashed together.
+func CheckMerkleBranch(txHash ShaHash, branch []*ShaHash, index uint32,
+	root ShaHash) bool {
+
+	hash := txHash
+	for _, sibling := range branch {
+		if index&1 == 1 {
+			hash = hashMerkleBranches(sibling, &hash)
+		} else {
+			hash = hashMerkleBranches(&hash, sibling)
+		}
+		index >>= 1
+	}
+
+	return hash.IsEqual(&root)
+}
+
+// nextPowerOfTwo returns the next highest power of two from a given number if
+// it is not already a power of two.  This is a helper function used during the
+// calculation of a merkle tree.
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// BuildMerkleTreeStore creates a merkle tree from the passed transaction
+// hashes, stores it using a linear array, and returns a slice of the backing
+// array.  A linear array was chosen as opposed to an actual tree structure
+// since it uses about half as much memory.
+//
+// The leaves of the tree, padded with nil to the next power of two, come first
+// followed by each level of interior nodes, so the root is the last entry.
+// For example, the store of a tree with 3 transactions is:
+//
+//	[h1 h2 h3 nil h12 h33 root]
+//
+// As with the merkle root of a block (see MsgBlock.CalcMerkleRoot), the last
+// node of each level with an odd number of nodes is paired with itself.  Nodes
+// which are only padding are nil.  The store of an empty list of hashes is
+// a single nil root.
+func BuildMerkleTreeStore(txHashes []*ShaHash) []*ShaHash {
+	// The number of nodes in a tree with the leaves padded to the next
+	// power of two is one less than twice the number of leaves.
+	width := nextPowerOfTwo(len(txHashes))
+	merkles := make([]*ShaHash, width*2-1)
+	copy(merkles, txHashes)
+
+	// Start the array offset after the last leaf and compute each parent
+	// from the pair of nodes in the level below it.
+	offset := width
+	for i := 0; i < len(merkles)-1; i += 2 {
+		switch {
+		// When there is no left child node, the parent is nil too.
+		case merkles[i] == nil:
+			merkles[offset] = nil
+
+		// When there is no right child, the parent is the hash of the
+		// left child with itself.
+		case merkles[i+1] == nil:
+			hash := hashMerkleBranches(merkles[i], merkles[i])
+			merkles[offset] = &hash
+
+		default:
+			hash := hashMerkleBranches(merkles[i], merkles[i+1])
+			merkles[offset] = &hash
+		}
+		offset++
+	}
+
+	return merkles
+}
+
+// MerkleProof returns the merkle branch from the passed merkle tree store, as
+// returned by BuildMerkleTreeStore, which proves the inclusion of the
+// transaction at position txIndex.  The branch consists of the sibling hash at
+// each level of the tree starting with the leaf level, which is the form
+// expected by CheckMerkleBranch.  The sibling of the last node of a level with
+// an odd number of nodes is the node itself.  Nil is returned when there is no
+// transaction at the passed position or the store is malformed.
+func MerkleProof(store []*ShaHash, txIndex uint32) []*ShaHash {
+	// A valid store has one less than twice a power of two entries.
+	width := (len(store) + 1) / 2
+	if width == 0 || width != nextPowerOfTwo(width) ||
+		len(store) != width*2-1 {
+
+		return nil
+	}
+	if uint64(txIndex) >= uint64(width) || store[txIndex] == nil {
+		return nil
+	}
+
+	// The branch of a tree with a single transaction is empty but not nil
+	// to distinguish it from a missing transaction.
+	branch := make([]*ShaHash, 0, 32)
+	index := int(txIndex)
+	offset := 0
+	for ; width > 1; width /= 2 {
+		sibling := store[offset+(index^1)]
+		if sibling == nil {
+			sibling = store[offset+index]
+		}
+		branch = append(branch, sibling)
+
+		offset += width
+		index >>= 1
+	}
+
+	return branch
+}
+
+// merkleRoot returns the root of the merkle tree of the passed hashes.  As in
+// bitcoind, the last hash of each level with an odd number of hashes is paired
+// with itself.  The root of an empty tree is the zero hash.
+func merkleRoot(hashes []ShaHash) ShaHash {
+	if len(hashes) == 0 {
+		return ShaHash{}
+	}
+
+	level := make([]ShaHash, len(hashes))
+	copy(level, hashes)
+	for len(level) > 1 {
+		next := level[:0]
+		for i := 0; i < len(level); i += 2 {
+			right := &level[i]
+			if i+1 < len(level) {
+				right = &level[i+1]
+			}
+			next = append(next, hashMerkleBranches(&level[i], right))
+		}
+		level = next
+	}
+
+	return level[0]
+}
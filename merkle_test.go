@@ -0,0 +1,167 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"github.com/conformal/btcwire"
+	"testing"
+)
+
+// newShaHashFromStr converts the passed big-endian hex string into a
+// btcwire.ShaHash.  It only differs from the one available in btcwire in that
+// it panics on an error since it will only (and must only) be called with
+// hard-coded, and therefore known good, hashes.
+func newShaHashFromStr(hexStr string) *btcwire.ShaHash {
+	sha, err := btcwire.NewShaHashFromStr(hexStr)
+	if err != nil {
+		panic(err)
+	}
+	return sha
+}
+
+// block100000TxHashes are the hashes of the transactions in block 100000 in
+// block order.
+var block100000TxHashes = []*btcwire.ShaHash{
+	newShaHashFromStr("8c14f0db3df150123e6f3dbbf30f8b955a8249b62ac1d1ff16284aefa3d06d87"),
+	newShaHashFromStr("fff2525b8931402dd09222c50775608f75787bd2b87e56995a7bdd30f79702c4"),
+	newShaHashFromStr("6359f0868171b1d194cbee1af2f16ea598ae8fad666d9b012c8ed2b79a236ec4"),
+	newShaHashFromStr("e9a66845e05d5abc0ad04ec80f774a7e585c6e8db975962d069a522137b80c1d"),
+}
+
+// block100000MerkleRoot is the merkle root of block 100000.
+var block100000MerkleRoot = newShaHashFromStr("f3e94742aca4b5ef85488dc37c06c3282295ffec960994b2c0d5ac2a25a95766")
+
+// TestCheckMerkleBranch tests the CheckMerkleBranch function against the
+// transactions of block 100000.
+func TestCheckMerkleBranch(t *testing.T) {
+	// Interior nodes of the merkle tree of block 100000.
+	left := newShaHashFromStr("ccdafb73d8dcd0173d5d5c3c9a0770d0b3953db889dab99ef05b1907518cb815")
+	right := newShaHashFromStr("8e30899078ca1813be036a073bbf80b86cdddde1c96e9e9c99e9e3782df4ae49")
+
+	txHashes := block100000TxHashes
+	tests := []struct {
+		txHash *btcwire.ShaHash   // Hash of the transaction to prove
+		branch []*btcwire.ShaHash // Merkle branch
+		index  uint32             // Position of the transaction
+		root   *btcwire.ShaHash   // Merkle root to check against
+		want   bool               // Expected result
+	}{
+		// Valid branches for every transaction in the block.
+		{txHashes[0], []*btcwire.ShaHash{txHashes[1], right}, 0,
+			block100000MerkleRoot, true},
+		{txHashes[1], []*btcwire.ShaHash{txHashes[0], right}, 1,
+			block100000MerkleRoot, true},
+		{txHashes[2], []*btcwire.ShaHash{txHashes[3], left}, 2,
+			block100000MerkleRoot, true},
+		{txHashes[3], []*btcwire.ShaHash{txHashes[2], left}, 3,
+			block100000MerkleRoot, true},
+
+		// Wrong index.
+		{txHashes[0], []*btcwire.ShaHash{txHashes[1], right}, 1,
+			block100000MerkleRoot, false},
+
+		// Wrong transaction.
+		{txHashes[2], []*btcwire.ShaHash{txHashes[1], right}, 0,
+			block100000MerkleRoot, false},
+
+		// Wrong root.
+		{txHashes[0], []*btcwire.ShaHash{txHashes[1], right}, 0,
+			&btcwire.GenesisMerkleRoot, false},
+
+		// Single transaction block with no branch.
+		{&btcwire.GenesisMerkleRoot, nil, 0,
+			&btcwire.GenesisMerkleRoot, true},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		result := btcwire.CheckMerkleBranch(*test.txHash, test.branch,
+			test.index, *test.root)
+		if result != test.want {
+			t.Errorf("CheckMerkleBranch #%d: got %v want %v", i,
+				result, test.want)
+			continue
+		}
+	}
+}
+
+// TestBuildMerkleTreeStore tests the BuildMerkleTreeStore and MerkleProof
+// functions produce trees and branches which are consistent with the merkle
+// root of a block and CheckMerkleBranch.
+func TestBuildMerkleTreeStore(t *testing.T) {
+	// Ensure the root of the store for block 100000 is its merkle root.
+	store := btcwire.BuildMerkleTreeStore(block100000TxHashes)
+	if len(store) != 7 {
+		t.Fatalf("BuildMerkleTreeStore: wrong store size - got %d, "+
+			"want %d", len(store), 7)
+	}
+	if root := store[len(store)-1]; !root.IsEqual(block100000MerkleRoot) {
+		t.Errorf("BuildMerkleTreeStore: wrong root - got %v, want %v",
+			root, block100000MerkleRoot)
+	}
+
+	// Ensure the store and branches are consistent with the merkle root of
+	// blocks with various numbers of transactions, including odd numbers
+	// which require duplicating the last node of a level.
+	for numTxns := 1; numTxns <= 9; numTxns++ {
+		block := btcwire.NewMsgBlock(&blockOne.Header)
+		for i := 0; i < numTxns; i++ {
+			tx := btcwire.NewMsgTx()
+			tx.LockTime = uint32(i)
+			block.AddTransaction(tx)
+		}
+		txHashes := make([]*btcwire.ShaHash, 0, numTxns)
+		for _, hash := range block.TxHashes() {
+			hash := hash
+			txHashes = append(txHashes, &hash)
+		}
+
+		store := btcwire.BuildMerkleTreeStore(txHashes)
+		root := block.CalcMerkleRoot()
+		if !store[len(store)-1].IsEqual(&root) {
+			t.Errorf("BuildMerkleTreeStore (%d txns): wrong root - "+
+				"got %v, want %v", numTxns, store[len(store)-1],
+				root)
+			continue
+		}
+
+		for i, txHash := range txHashes {
+			branch := btcwire.MerkleProof(store, uint32(i))
+			if branch == nil {
+				t.Errorf("MerkleProof (%d txns) #%d: no branch",
+					numTxns, i)
+				continue
+			}
+			if !btcwire.CheckMerkleBranch(*txHash, branch, uint32(i),
+				root) {
+
+				t.Errorf("MerkleProof (%d txns) #%d: branch does "+
+					"not prove inclusion", numTxns, i)
+				continue
+			}
+		}
+
+		// Ensure positions without a transaction have no branch.
+		if branch := btcwire.MerkleProof(store, uint32(numTxns)); branch != nil {
+			t.Errorf("MerkleProof (%d txns): unexpected branch for "+
+				"missing transaction", numTxns)
+		}
+	}
+
+	// Ensure the store of no hashes is a single nil root and malformed
+	// stores have no branches.
+	store = btcwire.BuildMerkleTreeStore(nil)
+	if len(store) != 1 || store[0] != nil {
+		t.Errorf("BuildMerkleTreeStore: wrong store for no hashes - "+
+			"got %v", store)
+	}
+	if branch := btcwire.MerkleProof(store, 0); branch != nil {
+		t.Errorf("MerkleProof: unexpected branch for empty store")
+	}
+	badStore := btcwire.BuildMerkleTreeStore(block100000TxHashes)[:6]
+	if branch := btcwire.MerkleProof(badStore, 0); branch != nil {
+		t.Errorf("MerkleProof: unexpected branch for malformed store")
+	}
+}
@@ -5,38 +5,76 @@
 package btcwire
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
-	"unicode/utf8"
+	"io/ioutil"
+	"net"
+	"sort"
+	"time"
 )
 
 // commandSize is the fixed size of all commands in the common bitcoin message
 // header.  Shorter commands must be zero padded.
 const commandSize = 12
 
+// MessageHeaderSize is the number of bytes in the common bitcoin message
+// header.  Magic 4 bytes + command 12 bytes + payload length 4 bytes +
+// checksum 4 bytes.
+const MessageHeaderSize = 24
+
+// emptyPayloadChecksum is the standard checksum of an empty payload, which is
+// the first 4 bytes of the double sha256 of no data.
+var emptyPayloadChecksum = [4]byte{0x5d, 0xf6, 0xe0, 0xe2}
+
 // maxMessagePayload is the maximum bytes a message can be regardless of other
 // individual limits imposed by messages themselves.
 const maxMessagePayload = (1024 * 1024 * 32) // 32MB
 
 // Commands used in bitcoin message headers which describe the type of message.
 const (
-	cmdVersion    = "version"
-	cmdVerAck     = "verack"
-	cmdGetAddr    = "getaddr"
-	cmdAddr       = "addr"
-	cmdGetBlocks  = "getblocks"
-	cmdInv        = "inv"
-	cmdGetData    = "getdata"
-	cmdNotFound   = "notfound"
-	cmdBlock      = "block"
-	cmdTx         = "tx"
-	cmdGetHeaders = "getheaders"
-	cmdHeaders    = "headers"
-	cmdPing       = "ping"
-	cmdPong       = "pong"
-	cmdAlert      = "alert"
-	cmdMemPool    = "mempool"
+	cmdVersion      = "version"
+	cmdVerAck       = "verack"
+	cmdGetAddr      = "getaddr"
+	cmdAddr         = "addr"
+	cmdGetBlocks    = "getblocks"
+	cmdInv          = "inv"
+	cmdGetData      = "getdata"
+	cmdNotFound     = "notfound"
+	cmdBlock        = "block"
+	cmdTx           = "tx"
+	cmdGetHeaders   = "getheaders"
+	cmdHeaders      = "headers"
+	cmdPing         = "ping"
+	cmdPong         = "pong"
+	cmdAlert        = "alert"
+	cmdMemPool      = "mempool"
+	cmdReject       = "reject"
+	cmdGetUTXOs     = "getutxos"
+	cmdUTXOs        = "utxos"
+	cmdWTxIDRelay   = "wtxidrelay"
+	cmdSendHeaders  = "sendheaders"
+	cmdFeeFilter    = "feefilter"
+	cmdFilterLoad   = "filterload"
+	cmdFilterAdd    = "filteradd"
+	cmdFilterClear  = "filterclear"
+	cmdMerkleBlock  = "merkleblock"
+	cmdSendCmpct    = "sendcmpct"
+	cmdCmpctBlock   = "cmpctblock"
+	cmdGetBlockTxn  = "getblocktxn"
+	cmdBlockTxn     = "blocktxn"
+	cmdAddrV2       = "addrv2"
+	cmdSendAddrV2   = "sendaddrv2"
+	cmdGetCFilters  = "getcfilters"
+	cmdCFilter      = "cfilter"
+	cmdGetCFHeaders = "getcfheaders"
+	cmdCFHeaders    = "cfheaders"
+	cmdGetCFCheckpt = "getcfcheckpt"
+	cmdCFCheckpt    = "cfcheckpt"
 )
 
 // Message is an interface that describes a bitcoin message.  A type that
@@ -53,86 +91,198 @@ type Message interface {
 // makeEmptyMessage creates a message of the appropriate concrete type based
 // on the command.
 func makeEmptyMessage(command string) (Message, error) {
-	var msg Message
-	switch command {
-	case cmdVersion:
-		msg = &MsgVersion{}
-
-	case cmdVerAck:
-		msg = &MsgVerAck{}
-
-	case cmdGetAddr:
-		msg = &MsgGetAddr{}
-
-	case cmdAddr:
-		msg = &MsgAddr{}
-
-	case cmdGetBlocks:
-		msg = &MsgGetBlocks{}
-
-	case cmdBlock:
-		msg = &MsgBlock{}
-
-	case cmdInv:
-		msg = &MsgInv{}
-
-	case cmdGetData:
-		msg = &MsgGetData{}
+	makeMsg, ok := LookupMessage(command)
+	if !ok {
+		return nil, fmt.Errorf("unhandled command [%s]", command)
+	}
+	return makeMsg(), nil
+}
 
-	case cmdNotFound:
-		msg = &MsgNotFound{}
+// messageMakers houses a function which returns a new empty message of the
+// concrete type for each command built into this package.  Together with the
+// commands registered with RegisterMessage, it is the single source of the
+// commands handled by makeEmptyMessage and KnownCommands.
+var messageMakers = map[string]func() Message{
+	cmdVersion:      func() Message { return &MsgVersion{} },
+	cmdVerAck:       func() Message { return &MsgVerAck{} },
+	cmdGetAddr:      func() Message { return &MsgGetAddr{} },
+	cmdAddr:         func() Message { return &MsgAddr{} },
+	cmdGetBlocks:    func() Message { return &MsgGetBlocks{} },
+	cmdBlock:        func() Message { return &MsgBlock{} },
+	cmdInv:          func() Message { return &MsgInv{} },
+	cmdGetData:      func() Message { return &MsgGetData{} },
+	cmdNotFound:     func() Message { return &MsgNotFound{} },
+	cmdTx:           func() Message { return &MsgTx{} },
+	cmdPing:         func() Message { return &MsgPing{} },
+	cmdPong:         func() Message { return &MsgPong{} },
+	cmdGetHeaders:   func() Message { return &MsgGetHeaders{} },
+	cmdHeaders:      func() Message { return &MsgHeaders{} },
+	cmdAlert:        func() Message { return &MsgAlert{} },
+	cmdMemPool:      func() Message { return &MsgMemPool{} },
+	cmdReject:       func() Message { return &MsgReject{} },
+	cmdGetUTXOs:     func() Message { return &MsgGetUTXOs{} },
+	cmdUTXOs:        func() Message { return &MsgUTXOs{} },
+	cmdWTxIDRelay:   func() Message { return &MsgWTxIDRelay{} },
+	cmdSendHeaders:  func() Message { return &MsgSendHeaders{} },
+	cmdFeeFilter:    func() Message { return &MsgFeeFilter{} },
+	cmdFilterLoad:   func() Message { return &MsgFilterLoad{} },
+	cmdFilterAdd:    func() Message { return &MsgFilterAdd{} },
+	cmdFilterClear:  func() Message { return &MsgFilterClear{} },
+	cmdMerkleBlock:  func() Message { return &MsgMerkleBlock{} },
+	cmdSendCmpct:    func() Message { return &MsgSendCmpct{} },
+	cmdCmpctBlock:   func() Message { return &MsgCmpctBlock{} },
+	cmdGetBlockTxn:  func() Message { return &MsgGetBlockTxn{} },
+	cmdBlockTxn:     func() Message { return &MsgBlockTxn{} },
+	cmdAddrV2:       func() Message { return &MsgAddrV2{} },
+	cmdSendAddrV2:   func() Message { return &MsgSendAddrV2{} },
+	cmdGetCFilters:  func() Message { return &MsgGetCFilters{} },
+	cmdCFilter:      func() Message { return &MsgCFilter{} },
+	cmdGetCFHeaders: func() Message { return &MsgGetCFHeaders{} },
+	cmdCFHeaders:    func() Message { return &MsgCFHeaders{} },
+	cmdGetCFCheckpt: func() Message { return &MsgGetCFCheckpt{} },
+	cmdCFCheckpt:    func() Message { return &MsgCFCheckpt{} },
+}
 
-	case cmdTx:
-		msg = &MsgTx{}
+// KnownCommands returns all commands known to this package, including those
+// registered with RegisterMessage, in sorted order.  ReadMessage decodes
+// messages with any of these commands and rejects all others with
+// ErrUnknownCommand.  This is useful to pre-register metrics per message type
+// or to validate a configured list of commands.
+func KnownCommands() []string {
+	registeredMakers.RLock()
+	commands := make([]string, 0,
+		len(messageMakers)+len(registeredMakers.makers))
+	for command := range messageMakers {
+		commands = append(commands, command)
+	}
+	for command := range registeredMakers.makers {
+		commands = append(commands, command)
+	}
+	registeredMakers.RUnlock()
+	sort.Strings(commands)
+	return commands
+}
 
-	case cmdPing:
-		msg = &MsgPing{}
+// maxItemsPerCommand houses the maximum number of items in the list of the
+// message for each command which has one.
+var maxItemsPerCommand = map[string]int{
+	cmdInv:         MaxInvPerMsg,
+	cmdGetData:     MaxInvPerMsg,
+	cmdNotFound:    MaxInvPerMsg,
+	cmdHeaders:     MaxBlockHeadersPerMsg,
+	cmdAddr:        MaxAddrPerMsg,
+	cmdAddrV2:      MaxAddrPerMsg,
+	cmdGetBlocks:   MaxBlockLocatorsPerMsg,
+	cmdGetHeaders:  MaxBlockLocatorsPerMsg,
+	cmdGetUTXOs:    MaxOutPointsPerGetUTXOs,
+	cmdMerkleBlock: maxTxPerBlock,
+	cmdCmpctBlock:  maxTxPerBlock,
+}
 
-	case cmdPong:
-		msg = &MsgPong{}
+// MaxItemsForCommand returns the maximum number of items allowed in the list of
+// a single message with the passed command, such as the inventory vectors of
+// an inv message or the block locator hashes of a getblocks message.  This
+// allows generic relay code to respect the limits without knowing the concrete
+// message types.  It returns 0 for commands whose messages do not have a list
+// and for unknown commands.
+func MaxItemsForCommand(command string) int {
+	return maxItemsPerCommand[command]
+}
 
-	case cmdGetHeaders:
-		msg = &MsgGetHeaders{}
+// MessageHeader defines the header structure for all bitcoin protocol
+// messages.  It is MessageHeaderSize bytes on the wire and precedes the
+// payload of every message.  It is exposed for tooling which frames messages
+// manually, such as proxies and packet captures, while ReadMessage and
+// WriteMessage handle it transparently.
+type MessageHeader struct {
+	Magic    BitcoinNet // 4 bytes
+	Command  string     // 12 bytes
+	Length   uint32     // 4 bytes
+	Checksum [4]byte    // 4 bytes
+}
 
-	case cmdHeaders:
-		msg = &MsgHeaders{}
+// Read reads a bitcoin message header from r into the receiver.  The command
+// is stripped of the zero bytes it is padded with.  The header is only framing,
+// so the fields are not validated against each other or any payload.
+func (hdr *MessageHeader) Read(r io.Reader) error {
+	var command [commandSize]byte
+	err := readElements(r, &hdr.Magic, &command, &hdr.Length, &hdr.Checksum)
+	if err != nil {
+		return err
+	}
 
-	case cmdAlert:
-		msg = &MsgAlert{}
+	// Strip trailing zeros from command string.
+	hdr.Command = string(bytes.TrimRight(command[:], "\x00"))
 
-	case cmdMemPool:
-		msg = &MsgMemPool{}
+	return nil
+}
 
-	default:
-		return nil, fmt.Errorf("unhandled command [%s]", command)
+// Write writes the receiver to w as a bitcoin message header with a single
+// call to w.Write.  The command must not be longer than the 12 bytes of the
+// command field.
+func (hdr *MessageHeader) Write(w io.Writer) error {
+	if len(hdr.Command) > commandSize {
+		str := fmt.Sprintf("command [%s] is too long [max %v]",
+			hdr.Command, commandSize)
+		return messageError("MessageHeader.Write", ErrUnknownCommand, str)
 	}
-	return msg, nil
+
+	b := hdr.bytes()
+	_, err := w.Write(b[:])
+	return err
 }
 
-// messageHeader defines the header structure for all bitcoin protocol messages.
-type messageHeader struct {
-	magic    BitcoinNet // 4 bytes
-	command  string     // 12 bytes
-	length   uint32     // 4 bytes
-	checksum [4]byte    // 4 bytes
+// bytes returns the wire encoding of the header.  The command must already be
+// known to fit in the command field.
+func (hdr *MessageHeader) bytes() [MessageHeaderSize]byte {
+	var b [MessageHeaderSize]byte
+	binary.LittleEndian.PutUint32(b[0:4], uint32(hdr.Magic))
+	copy(b[4:4+commandSize], hdr.Command)
+	binary.LittleEndian.PutUint32(b[4+commandSize:MessageHeaderSize-4],
+		hdr.Length)
+	copy(b[MessageHeaderSize-4:], hdr.Checksum[:])
+	return b
 }
 
-// readMessageHeader reads a bitcoin message header from r.
-func readMessageHeader(r io.Reader) (*messageHeader, error) {
-	var command [commandSize]byte
+// isValidCommand returns whether or not command, as read from a message header
+// with the zero padding stripped, is well formed.  Matching bitcoind, a command
+// must consist of printable ASCII characters followed only by zero padding, so
+// a command with any other byte or with data after a zero byte is rejected.
+func isValidCommand(command string) bool {
+	for i := 0; i < len(command); i++ {
+		if command[i] < 0x20 || command[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
 
-	hdr := messageHeader{}
-	err := readElements(r, &hdr.magic, &command, &hdr.length, &hdr.checksum)
+// ReadMessageHeader reads the next bitcoin message header from r without
+// reading any of its payload.  Together with ReadMessagePayload or
+// DiscardMessagePayload, it splits ReadMessage into two phases, so the command
+// and length of a message can be inspected to apply a policy, such as banning
+// a peer which announces a huge transaction, before any of the payload is
+// read.  The header is not validated until it is passed to ReadMessagePayload.
+func ReadMessageHeader(r io.Reader) (*MessageHeader, error) {
+	var hdr MessageHeader
+	err := hdr.Read(r)
 	if err != nil {
 		return nil, err
 	}
-
-	// Strip trailing zeros from command string.
-	hdr.command = string(bytes.TrimRight(command[:], string(0)))
-
 	return &hdr, nil
 }
 
+// DiscardMessagePayload reads the payload of the message with the passed
+// header from r and discards it, so the next message can be read.  An error is
+// returned when r ends before the whole payload was read.
+func DiscardMessagePayload(r io.Reader, hdr *MessageHeader) error {
+	n, err := io.CopyN(ioutil.Discard, r, int64(hdr.Length))
+	if err == io.EOF && n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
 // discardInput reads n bytes from reader r in chunks and discards the read
 // bytes.  This is used to skip payloads when various errors occur and helps
 // prevent rogue nodes from causing massive memory allocation through forging
@@ -153,25 +303,128 @@ func discardInput(r io.Reader, n uint32) {
 	}
 }
 
+// ChecksumFunc computes the checksum stored in a message header for the
+// provided message payload.
+type ChecksumFunc func(payload []byte) [4]byte
+
+// DoubleSha256Checksum is the standard ChecksumFunc used by the bitcoin
+// protocol.  It returns the first four bytes of the double sha256 of the
+// payload.
+func DoubleSha256Checksum(payload []byte) [4]byte {
+	var checksum [4]byte
+	copy(checksum[:], DoubleSha256(payload)[0:4])
+	return checksum
+}
+
+// PayloadChecksum returns the checksum WriteMessage stores in the header of a
+// message with the provided payload.  It is useful for crafting valid headers
+// for hand-edited payloads.
+func PayloadChecksum(payload []byte) [4]byte {
+	return DoubleSha256Checksum(payload)
+}
+
+// MessageOptions houses optional behavior for reading and writing messages
+// with ReadMessageWithOptions and WriteMessageWithOptions.  The zero value,
+// as well as a nil *MessageOptions, provides the standard behavior used by
+// ReadMessage and WriteMessage.
+type MessageOptions struct {
+	// Checksum is used to compute the header checksum of message
+	// payloads.  DoubleSha256Checksum is used when it is nil.  It is
+	// primarily intended for testing against peers which compute
+	// checksums differently.
+	Checksum ChecksumFunc
+
+	// Decode controls how strictly message payloads are decoded by
+	// ReadMessageWithOptions.  The standard behavior is used when it is
+	// nil.  See DecodeOptions for details.
+	Decode *DecodeOptions
+}
+
+// decode returns the configured decode options, or nil if none are
+// configured.
+func (opts *MessageOptions) decode() *DecodeOptions {
+	if opts == nil {
+		return nil
+	}
+	return opts.Decode
+}
+
+// checksum returns the header checksum for payload using the configured
+// ChecksumFunc, or the standard checksum if none is configured.
+func (opts *MessageOptions) checksum(payload []byte) [4]byte {
+	if opts == nil || opts.Checksum == nil {
+		return DoubleSha256Checksum(payload)
+	}
+	return opts.Checksum(payload)
+}
+
 // WriteMessage writes a bitcoin Message to w including the necessary header
 // information.
 func WriteMessage(w io.Writer, msg Message, pver uint32, btcnet BitcoinNet) error {
-	var command [commandSize]byte
+	_, err := writeMessageN(w, msg, pver, btcnet, nil)
+	return err
+}
+
+// WriteMessageN is identical to WriteMessage except it also returns the number
+// of bytes written to w, including the header.  The count is returned on error
+// as well.  A nonzero count on error means only part of the message was
+// written, so the framing of the stream is broken and the connection must be
+// torn down.  No bytes are written when the message fails to encode.
+func WriteMessageN(w io.Writer, msg Message, pver uint32, btcnet BitcoinNet) (int, error) {
+	return writeMessageN(w, msg, pver, btcnet, nil)
+}
+
+// WriteMessageWithOptions writes a bitcoin Message to w including the
+// necessary header information using the provided options.  See
+// MessageOptions for details.
+func WriteMessageWithOptions(w io.Writer, msg Message, pver uint32,
+	btcnet BitcoinNet, opts *MessageOptions) error {
+
+	_, err := writeMessageN(w, msg, pver, btcnet, opts)
+	return err
+}
+
+// WriteMessageWithOptionsN is identical to WriteMessageWithOptions except it
+// also returns the number of bytes written to w like WriteMessageN.
+func WriteMessageWithOptionsN(w io.Writer, msg Message, pver uint32,
+	btcnet BitcoinNet, opts *MessageOptions) (int, error) {
+
+	return writeMessageN(w, msg, pver, btcnet, opts)
+}
+
+// writeMessageN writes a bitcoin Message to w including the necessary header
+// information using the provided options and returns the number of bytes
+// written.
+func writeMessageN(w io.Writer, msg Message, pver uint32, btcnet BitcoinNet,
+	opts *MessageOptions) (int, error) {
 
 	// Enforce max command size.
 	cmd := msg.Command()
 	if len(cmd) > commandSize {
 		str := fmt.Sprintf("command [%s] is too long [max %v]",
 			cmd, commandSize)
-		return messageError("WriteMessage", str)
+		return 0, messageError("WriteMessage", ErrUnknownCommand, str)
+	}
+
+	// Messages which never have a payload, such as verack, are by far the
+	// most frequent control messages, so write their header directly
+	// without buffering the payload or hashing it unless a custom checksum
+	// is configured.
+	if msg.MaxPayloadLength(pver) == 0 && (opts == nil || opts.Checksum == nil) {
+		n, err := writeEmptyMessage(w, msg, pver, btcnet)
+		if err != errNonEmptyPayload {
+			return n, err
+		}
+
+		// The message wrote a payload anyway, so fall back to the
+		// general path which rejects it.
 	}
-	copy(command[:], []byte(cmd))
 
 	// Encode the message payload.
 	var bw bytes.Buffer
 	err := msg.BtcEncode(&bw, pver)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	payload := bw.Bytes()
 	lenp := len(payload)
@@ -181,7 +434,7 @@ func WriteMessage(w io.Writer, msg Message, pver uint32, btcnet BitcoinNet) erro
 		str := fmt.Sprintf("message payload is too large - encoded "+
 			"%d bytes, but maximum message payload is %d bytes",
 			lenp, maxMessagePayload)
-		return messageError("WriteMessage", str)
+		return 0, messageError("WriteMessage", ErrPayloadTooLarge, str)
 	}
 
 	// Enforce maximum message payload based on the message type.
@@ -190,99 +443,283 @@ func WriteMessage(w io.Writer, msg Message, pver uint32, btcnet BitcoinNet) erro
 		str := fmt.Sprintf("message payload is too large - encoded "+
 			"%d bytes, but maximum message payload size for "+
 			"messages of type [%s] is %d.", lenp, cmd, mpl)
-		return messageError("WriteMessage", str)
+		return 0, messageError("WriteMessage", ErrPayloadTooLarge, str)
 	}
 
 	// Create header for the message.
-	hdr := messageHeader{}
-	hdr.magic = btcnet
-	hdr.command = cmd
-	hdr.length = uint32(lenp)
-	copy(hdr.checksum[:], DoubleSha256(payload)[0:4])
+	hdr := MessageHeader{
+		Magic:    btcnet,
+		Command:  cmd,
+		Length:   uint32(lenp),
+		Checksum: opts.checksum(payload),
+	}
 
 	// Write header.
-	err = writeElements(w, hdr.magic, command, hdr.length, hdr.checksum)
+	hb := hdr.bytes()
+	n, err := w.Write(hb[:])
 	if err != nil {
-		return err
+		return n, err
 	}
 
 	// Write payload.
-	_, err = w.Write(payload)
+	np, err := w.Write(payload)
+	n += np
 	if err != nil {
-		return err
+		return n, err
 	}
-	return nil
+	return n, nil
+}
+
+// errNonEmptyPayload is returned by emptyPayloadWriter when a message writes a
+// payload.
+var errNonEmptyPayload = errors.New("message payload is not empty")
+
+// emptyPayloadWriter is an io.Writer which fails any write of data.  It is used
+// to run the encoder of messages without a payload so they can still reject
+// protocol versions they are invalid for.
+type emptyPayloadWriter struct{}
+
+// Write returns errNonEmptyPayload if p is not empty.
+func (emptyPayloadWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		return 0, errNonEmptyPayload
+	}
+	return 0, nil
+}
+
+// writeEmptyMessage writes the header of a message without a payload to w
+// using the standard checksum of an empty payload and returns the number of
+// bytes written.  It returns errNonEmptyPayload without writing anything to w
+// if the message encodes a payload.
+func writeEmptyMessage(w io.Writer, msg Message, pver uint32,
+	btcnet BitcoinNet) (int, error) {
+
+	err := msg.BtcEncode(emptyPayloadWriter{}, pver)
+	if err != nil {
+		return 0, err
+	}
+
+	hdr := MessageHeader{
+		Magic:    btcnet,
+		Command:  msg.Command(),
+		Checksum: emptyPayloadChecksum,
+	}
+	hb := hdr.bytes()
+	return w.Write(hb[:])
 }
 
 // ReadMessage reads, validates, and parses the next bitcoin Message from r for
 // the provided protocol version and bitcoin network.
 func ReadMessage(r io.Reader, pver uint32, btcnet BitcoinNet) (Message, []byte, error) {
-	hdr, err := readMessageHeader(r)
+	return ReadMessageWithOptions(r, pver, btcnet, nil)
+}
+
+// ReadMessageN is identical to ReadMessage except it also returns the number
+// of bytes read from r, including the header.  The count is returned on error
+// as well and includes any payload which was read and discarded, so it
+// accounts for all of the traffic a message consumed.  See MessageSizeBucket.
+func ReadMessageN(r io.Reader, pver uint32, btcnet BitcoinNet) (int, Message, []byte, error) {
+	return ReadMessageWithOptionsN(r, pver, btcnet, nil)
+}
+
+// ReadMessageWithOptionsN is identical to ReadMessageWithOptions except it also
+// returns the number of bytes read from r like ReadMessageN.
+func ReadMessageWithOptionsN(r io.Reader, pver uint32, btcnet BitcoinNet,
+	opts *MessageOptions) (int, Message, []byte, error) {
+
+	cr := countingReader{r: r}
+	msg, payload, err := ReadMessageWithOptions(&cr, pver, btcnet, opts)
+	return cr.n, msg, payload, err
+}
+
+// countingReader is an io.Reader which counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+// Read reads from the underlying reader and adds the number of bytes read to
+// the count.
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += n
+	return n, err
+}
+
+// MessageSizeBucket returns a human readable label for the bucket the passed
+// message size in bytes falls into.  The buckets are "<1KB", "1-64KB",
+// "64KB-1MB", "1-4MB", and ">=4MB".  Combined with the counts returned by
+// ReadMessageN and WriteMessageN, it lets callers build histograms of message
+// sizes for diagnostics without reimplementing the bucketing.
+func MessageSizeBucket(n int) string {
+	switch {
+	case n < 1024:
+		return "<1KB"
+	case n < 64*1024:
+		return "1-64KB"
+	case n < 1024*1024:
+		return "64KB-1MB"
+	case n < 4*1024*1024:
+		return "1-4MB"
+	default:
+		return ">=4MB"
+	}
+}
+
+// RoundTrip reads a message from raw like ReadMessage, re-encodes it with
+// WriteMessage, and ensures the encoding is exactly raw.  This is useful for
+// conformance testing, such as checking captured messages are decoded and
+// encoded symmetrically.  The decoded message is returned along with an error
+// describing the offset of the first byte which differs when the re-encoding
+// does not match, including when raw has bytes after the message.
+func RoundTrip(raw []byte, pver uint32, btcnet BitcoinNet) (Message, error) {
+	msg, _, err := ReadMessage(bytes.NewReader(raw), pver, btcnet)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(raw))
+	err = WriteMessage(&buf, msg, pver, btcnet)
+	if err != nil {
+		return msg, err
+	}
+
+	if offset := firstDiff(buf.Bytes(), raw); offset != -1 {
+		str := fmt.Sprintf("re-encoded %s message differs from the "+
+			"input at offset %d [encoded len %d, input len %d]",
+			msg.Command(), offset, buf.Len(), len(raw))
+		return msg, messageError("RoundTrip", ErrMalformed, str)
+	}
+	return msg, nil
+}
+
+// ReadMessageWithOptions reads, validates, and parses the next bitcoin Message
+// from r for the provided protocol version and bitcoin network using the
+// provided options.  See MessageOptions for details.
+func ReadMessageWithOptions(r io.Reader, pver uint32, btcnet BitcoinNet,
+	opts *MessageOptions) (Message, []byte, error) {
+
+	hdr, err := ReadMessageHeader(r)
 	if err != nil {
 		return nil, nil, err
 	}
+	return readMessagePayload(r, hdr, pver, btcnet, opts, "ReadMessage")
+}
+
+// ReadMessagePayload reads, validates, and parses the payload of the message
+// with the passed header, as returned by ReadMessageHeader, from r for the
+// provided protocol version and bitcoin network.  The header is validated the
+// same way ReadMessage validates it: the network must match, the command must
+// be known, and the length may not exceed the MaxPayloadLength of the message
+// for the protocol version.  The payload is discarded from r when the network
+// or command is wrong, or the length exceeds the MaxPayloadLength of the
+// command, so the next message can still be read.
+//
+// The payload is not read when the header claims a nonzero length for a
+// command which never has a payload at any protocol version, such as verack,
+// or a length larger than any message may be.  Commands which only lack a
+// payload at older protocol versions, such as ping before BIP0031Version and
+// reject before RejectVersion, are discarded like other oversized payloads.  Such a header is malformed framing, so the stream is
+// left unsynchronized and the connection should be dropped.
+//
+// The payload is read in chunks while its checksum is computed, so no more
+// memory than the bytes actually received is allocated for a header which
+// claims a large payload.
+func ReadMessagePayload(r io.Reader, hdr *MessageHeader, pver uint32,
+	btcnet BitcoinNet) (Message, []byte, error) {
+
+	return readMessagePayload(r, hdr, pver, btcnet, nil,
+		"ReadMessagePayload")
+}
+
+// ReadMessagePayloadWithOptions is identical to ReadMessagePayload except it
+// uses the provided options.  See MessageOptions for details.
+func ReadMessagePayloadWithOptions(r io.Reader, hdr *MessageHeader,
+	pver uint32, btcnet BitcoinNet, opts *MessageOptions) (Message, []byte, error) {
+
+	return readMessagePayload(r, hdr, pver, btcnet, opts,
+		"ReadMessagePayload")
+}
+
+// readMessagePayload reads, validates, and parses the payload of the message
+// with the passed header from r.  The fn parameter is only used for errors.
+func readMessagePayload(r io.Reader, hdr *MessageHeader, pver uint32,
+	btcnet BitcoinNet, opts *MessageOptions, fn string) (Message, []byte, error) {
 
 	// Enforce maximum message payload.
-	if hdr.length > maxMessagePayload {
+	if hdr.Length > maxMessagePayload {
 		str := fmt.Sprintf("message payload is too large - header "+
 			"indicates %d bytes, but max message payload is %d "+
-			"bytes.", hdr.length, maxMessagePayload)
-		return nil, nil, messageError("ReadMessage", str)
+			"bytes.", hdr.Length, maxMessagePayload)
+		return nil, nil, messageError(fn, ErrPayloadTooLarge, str)
 
 	}
 
 	// Check for messages from the wrong bitcoin network.
-	if hdr.magic != btcnet {
-		discardInput(r, hdr.length)
-		str := fmt.Sprintf("message from other network [%v]", hdr.magic)
-		return nil, nil, messageError("ReadMessage", str)
+	if hdr.Magic != btcnet {
+		discardInput(r, hdr.Length)
+		str := fmt.Sprintf("message from other network [%v]", hdr.Magic)
+		return nil, nil, messageError(fn, ErrNetworkMismatch, str)
 	}
 
 	// Check for malformed commands.
-	command := hdr.command
-	if !utf8.ValidString(command) {
-		discardInput(r, hdr.length)
+	command := hdr.Command
+	if !isValidCommand(command) {
+		discardInput(r, hdr.Length)
 		str := fmt.Sprintf("invalid command %v", []byte(command))
-		return nil, nil, messageError("ReadMessage", str)
+		return nil, nil, messageError(fn, ErrUnknownCommand, str)
 	}
 
 	// Create struct of appropriate message type based on the command.
 	msg, err := makeEmptyMessage(command)
 	if err != nil {
-		discardInput(r, hdr.length)
-		return nil, nil, messageError("ReadMessage", err.Error())
+		discardInput(r, hdr.Length)
+		return nil, nil, messageError(fn, ErrUnknownCommand, err.Error())
 	}
 
 	// Check for maximum length based on the message type as a malicious client
 	// could otherwise create a well-formed header and set the length to max
 	// numbers in order to exhaust the machine's memory.
 	mpl := msg.MaxPayloadLength(pver)
-	if hdr.length > mpl {
-		discardInput(r, hdr.length)
+
+	// Messages such as verack never have a payload at any protocol
+	// version, so a header which claims one is malformed framing.  Reject
+	// it without reading any of the claimed payload.  Messages which only
+	// lack a payload at older protocol versions, such as ping before
+	// BIP0031Version, are discarded below like any other oversized
+	// payload.
+	if hdr.Length != 0 && msg.MaxPayloadLength(ProtocolVersion) == 0 {
+		str := fmt.Sprintf("payload must be empty - header indicates "+
+			"%v bytes for message of type [%v]", hdr.Length, command)
+		return nil, nil, messageError(fn, ErrPayloadTooLarge, str)
+	}
+
+	if hdr.Length > mpl {
+		discardInput(r, hdr.Length)
 		str := fmt.Sprintf("payload exceeds max length - header "+
 			"indicates %v bytes, but max payload size for "+
-			"messages of type [%v] is %v.", hdr.length, command, mpl)
-		return nil, nil, messageError("ReadMessage", str)
+			"messages of type [%v] is %v.", hdr.Length, command, mpl)
+		return nil, nil, messageError(fn, ErrPayloadTooLarge, str)
 	}
 
-	// Read payload.
-	payload := make([]byte, hdr.length)
-	_, err = io.ReadFull(r, payload)
+	// Read payload and test checksum.
+	payload, checksum, err := readPayload(r, hdr.Length, opts)
 	if err != nil {
 		return nil, nil, err
 	}
-
-	// Test checksum.
-	checksum := DoubleSha256(payload)[0:4]
-	if !bytes.Equal(checksum[:], hdr.checksum[:]) {
+	if checksum != hdr.Checksum {
 		str := fmt.Sprintf("payload checksum failed - header "+
 			"indicates %v, but actual checksum is %v.",
-			hdr.checksum, checksum)
-		return nil, nil, messageError("ReadMessage", str)
+			hdr.Checksum, checksum)
+		return nil, nil, messageError(fn, ErrBadChecksum, str)
 	}
 
-	// Unmarshal message.
-	pr := bytes.NewBuffer(payload)
+	// Unmarshal message.  The payload has already been read in its
+	// entirety according to the length in the header, so decoding from a
+	// buffer of it ensures a message with lying internal length fields can
+	// never read past its own boundary and into the next message on r.
+	pr := NewDecodeReader(bytes.NewBuffer(payload), opts.decode())
 	err = msg.BtcDecode(pr, pver)
 	if err != nil {
 		return nil, nil, err
@@ -290,3 +727,472 @@ func ReadMessage(r io.Reader, pver uint32, btcnet BitcoinNet) (Message, []byte,
 
 	return msg, payload, nil
 }
+
+// readPayload reads a message payload of the passed length from r and returns
+// it along with its checksum.  The standard checksum is computed as the
+// payload is read in chunks, while a ChecksumFunc from the options is applied
+// to the whole payload once it has been read.
+func readPayload(r io.Reader, length uint32, opts *MessageOptions) ([]byte,
+	[4]byte, error) {
+
+	var checksum [4]byte
+	if opts != nil && opts.Checksum != nil {
+		payload, err := readBytes(r, uint64(length))
+		if err != nil {
+			return nil, checksum, err
+		}
+		return payload, opts.Checksum(payload), nil
+	}
+
+	hasher := borrowShaHasher()
+	defer returnShaHasher(hasher)
+	payload, err := readBytes(io.TeeReader(r, hasher), uint64(length))
+	if err != nil {
+		return nil, checksum, err
+	}
+	sum := hasher.doubleSum()
+	copy(checksum[:], sum[:4])
+	return payload, checksum, nil
+}
+
+// ReadRawMessage reads and validates the framing of the next bitcoin message
+// from r for the provided bitcoin network and returns its command and raw
+// payload without decoding it.  The network magic, overall maximum payload
+// size, and payload checksum are validated, but the command does not need to
+// be known to this package.  This allows proxies to inspect the commands of
+// messages while relaying them, including ones added by future protocol
+// versions, byte for byte with WriteRawMessage.
+func ReadRawMessage(r io.Reader, btcnet BitcoinNet) (string, []byte, error) {
+	hdr, err := ReadMessageHeader(r)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Enforce maximum message payload.
+	if hdr.Length > maxMessagePayload {
+		str := fmt.Sprintf("message payload is too large - header "+
+			"indicates %d bytes, but max message payload is %d "+
+			"bytes.", hdr.Length, maxMessagePayload)
+		return "", nil, messageError("ReadRawMessage", ErrPayloadTooLarge, str)
+	}
+
+	// Check for messages from the wrong bitcoin network.
+	if hdr.Magic != btcnet {
+		discardInput(r, hdr.Length)
+		str := fmt.Sprintf("message from other network [%v]", hdr.Magic)
+		return "", nil, messageError("ReadRawMessage", ErrNetworkMismatch, str)
+	}
+
+	// Check for malformed commands.
+	if !isValidCommand(hdr.Command) {
+		discardInput(r, hdr.Length)
+		str := fmt.Sprintf("invalid command %v", []byte(hdr.Command))
+		return "", nil, messageError("ReadRawMessage", ErrUnknownCommand, str)
+	}
+
+	// Read payload and test checksum.
+	payload, checksum, err := readPayload(r, hdr.Length, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	if checksum != hdr.Checksum {
+		str := fmt.Sprintf("payload checksum failed - header "+
+			"indicates %v, but actual checksum is %v.",
+			hdr.Checksum, checksum)
+		return "", nil, messageError("ReadRawMessage", ErrBadChecksum, str)
+	}
+
+	return hdr.Command, payload, nil
+}
+
+// WriteRawMessage writes a bitcoin message with the provided command and raw
+// payload to w for the provided bitcoin network including the necessary header
+// information.  The payload is written as is without being validated against
+// the command, so any command which fits in the header may be used.  See
+// ReadRawMessage.
+func WriteRawMessage(w io.Writer, command string, payload []byte,
+	btcnet BitcoinNet) error {
+
+	// Enforce max command size.
+	if len(command) > commandSize {
+		str := fmt.Sprintf("command [%s] is too long [max %v]",
+			command, commandSize)
+		return messageError("WriteRawMessage", ErrUnknownCommand, str)
+	}
+	// Enforce maximum overall message payload.
+	lenp := len(payload)
+	if lenp > maxMessagePayload {
+		str := fmt.Sprintf("message payload is too large - %d bytes, "+
+			"but maximum message payload is %d bytes", lenp,
+			maxMessagePayload)
+		return messageError("WriteRawMessage", ErrPayloadTooLarge, str)
+	}
+
+	// Write header.
+	hdr := MessageHeader{
+		Magic:    btcnet,
+		Command:  command,
+		Length:   uint32(lenp),
+		Checksum: DoubleSha256Checksum(payload),
+	}
+	err := hdr.Write(w)
+	if err != nil {
+		return err
+	}
+
+	// Write payload.
+	_, err = w.Write(payload)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// readDeadliner is implemented by readers such as net.Conn which support
+// setting a deadline for future Read calls.
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// readMessageResult houses the return values of ReadMessage so they can be
+// passed over a channel by ReadMessageContext.
+type readMessageResult struct {
+	msg     Message
+	payload []byte
+	err     error
+}
+
+// ReadMessageContext is identical to ReadMessage except it aborts the read and
+// returns ctx.Err() when the provided context is cancelled or its deadline
+// expires before a complete message has been read.
+//
+// When r supports SetReadDeadline, as is the case for a net.Conn, the
+// deadline of the context is applied to r and cancellation forces any pending
+// read to return immediately.  The read deadline is cleared before returning,
+// so any read deadline the caller set on r beforehand is discarded and must be
+// set again if still needed.  Network timeouts caused by the context are
+// reported as ctx.Err(), while protocol errors are returned as is.
+//
+// Otherwise, since an io.Reader provides no means of interrupting a blocked
+// Read, the read is performed in a separate goroutine.  In that case the
+// goroutine will remain blocked until the underlying read returns, so the
+// caller should close the reader after a cancellation to avoid leaking it.
+// Either way, the stream must be considered unusable after an aborted read
+// since the framing of the next message is unknown.
+func ReadMessageContext(ctx context.Context, r io.Reader, pver uint32,
+	btcnet BitcoinNet) (Message, []byte, error) {
+
+	// Don't bother reading anything if the context is already done.
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if rd, ok := r.(readDeadliner); ok {
+		return readMessageDeadline(ctx, rd, r, pver, btcnet)
+	}
+
+	resultChan := make(chan readMessageResult, 1)
+	go func() {
+		msg, payload, err := ReadMessage(r, pver, btcnet)
+		resultChan <- readMessageResult{msg, payload, err}
+	}()
+
+	select {
+	case result := <-resultChan:
+		return result.msg, result.payload, result.err
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+// readMessageDeadline reads the next message from r while using the read
+// deadline support of rd to honor the deadline and cancellation of ctx.
+func readMessageDeadline(ctx context.Context, rd readDeadliner, r io.Reader,
+	pver uint32, btcnet BitcoinNet) (Message, []byte, error) {
+
+	if deadline, ok := ctx.Deadline(); ok {
+		err := rd.SetReadDeadline(deadline)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Force any pending read to return as soon as the context is done.
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			rd.SetReadDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+		close(exited)
+	}()
+
+	msg, payload, err := ReadMessage(r, pver, btcnet)
+
+	// Wait for the watcher to exit before clearing the deadline so it
+	// can't be set again afterwards.
+	close(done)
+	<-exited
+	rd.SetReadDeadline(time.Time{})
+
+	// Prefer the context error.  See deadlineErr.
+	return msg, payload, deadlineErr(ctx, err)
+}
+
+// writeDeadliner is implemented by writers such as net.Conn which support
+// setting a deadline for future Write calls.
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// writeMessageResult houses the return values of WriteMessageN so they can be
+// passed over a channel by WriteMessageContext.
+type writeMessageResult struct {
+	n   int
+	err error
+}
+
+// WriteMessageContext is identical to WriteMessageN except it aborts the write
+// and returns ctx.Err() when the provided context is cancelled or its deadline
+// expires before the complete message has been written.
+//
+// When w supports SetWriteDeadline, as is the case for a net.Conn, the
+// deadline of the context is applied to w and cancellation forces any pending
+// write to return immediately.  The write deadline is cleared before
+// returning, so any write deadline the caller set on w beforehand is discarded
+// and must be set again if still needed.  The number of bytes written before
+// the write was aborted is returned along with the error.
+//
+// Otherwise, since an io.Writer provides no means of interrupting a blocked
+// Write, the write is performed in a separate goroutine.  In that case the
+// number of bytes written is unknown when the write is aborted, so 0 is
+// returned, and the goroutine will remain blocked until the underlying write
+// returns, so the caller should close the writer after a cancellation to avoid
+// leaking it.  Either way, the stream must be considered unusable after an
+// aborted write since the remote peer will have received a partial message.
+func WriteMessageContext(ctx context.Context, w io.Writer, msg Message,
+	pver uint32, btcnet BitcoinNet) (int, error) {
+
+	// Don't bother writing anything if the context is already done.
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if wd, ok := w.(writeDeadliner); ok {
+		return writeMessageDeadline(ctx, wd, w, msg, pver, btcnet)
+	}
+
+	resultChan := make(chan writeMessageResult, 1)
+	go func() {
+		n, err := WriteMessageN(w, msg, pver, btcnet)
+		resultChan <- writeMessageResult{n, err}
+	}()
+
+	select {
+	case result := <-resultChan:
+		return result.n, result.err
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// writeMessageDeadline writes msg to w while using the write deadline support
+// of wd to honor the deadline and cancellation of ctx.
+func writeMessageDeadline(ctx context.Context, wd writeDeadliner, w io.Writer,
+	msg Message, pver uint32, btcnet BitcoinNet) (int, error) {
+
+	if deadline, ok := ctx.Deadline(); ok {
+		err := wd.SetWriteDeadline(deadline)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Force any pending write to return as soon as the context is done.
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			wd.SetWriteDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+		close(exited)
+	}()
+
+	n, err := WriteMessageN(w, msg, pver, btcnet)
+
+	// Wait for the watcher to exit before clearing the deadline so it
+	// can't be set again afterwards.
+	close(done)
+	<-exited
+	wd.SetWriteDeadline(time.Time{})
+
+	// Prefer the context error.  See deadlineErr.
+	return n, deadlineErr(ctx, err)
+}
+
+// deadlineErr returns the error to report for the error err returned by an
+// operation bounded by the deadline and cancellation of ctx.  A network timeout
+// caused by the deadline or cancellation is reported as the context error since
+// it is a direct result of it.  Any other error, such as a bad checksum, is
+// returned as is.  The deadline of a connection can expire slightly before the
+// timer of the context fires, so a timeout once the deadline of the context
+// has passed is reported as context.DeadlineExceeded even when the context is
+// not done yet.
+func deadlineErr(ctx context.Context, err error) error {
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		return err
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// MessageWriter wraps an io.Writer to write multiple bitcoin messages
+// back-to-back for a fixed protocol version and bitcoin network.  Each message
+// is framed with the necessary header information exactly as WriteMessage
+// would do.
+type MessageWriter struct {
+	bw     *bufio.Writer
+	pver   uint32
+	btcnet BitcoinNet
+}
+
+// NewMessageWriter returns a new MessageWriter which writes messages to w
+// using the protocol version pver and the bitcoin network btcnet.
+func NewMessageWriter(w io.Writer, pver uint32, btcnet BitcoinNet) *MessageWriter {
+	return &MessageWriter{
+		bw:     bufio.NewWriter(w),
+		pver:   pver,
+		btcnet: btcnet,
+	}
+}
+
+// SetProtocolVersion sets the protocol version used to encode subsequent
+// messages.  This is typically used once a lower protocol version has been
+// negotiated with the remote peer.
+func (mw *MessageWriter) SetProtocolVersion(pver uint32) {
+	mw.pver = pver
+}
+
+// Write writes msg to the underlying writer including the necessary header
+// information and flushes it.
+func (mw *MessageWriter) Write(msg Message) error {
+	return mw.WriteBatch(msg)
+}
+
+// WriteN is identical to Write except it also returns the number of bytes of
+// the message, including the header, like WriteMessageN.  The count is zero
+// when the message fails to encode.  When flushing fails, the message is
+// counted even though it may only have been partially written.
+func (mw *MessageWriter) WriteN(msg Message) (int, error) {
+	n, err := writeMessageN(mw.bw, msg, mw.pver, mw.btcnet, nil)
+	if err != nil {
+		mw.bw.Flush()
+		return n, err
+	}
+	return n, mw.bw.Flush()
+}
+
+// WriteBatch writes all of the passed messages back-to-back to the underlying
+// writer including the necessary header information for each and then
+// flushes them all at once.  Messages prior to the first one which fails to
+// encode are still flushed.
+func (mw *MessageWriter) WriteBatch(msgs ...Message) error {
+	for _, msg := range msgs {
+		err := WriteMessage(mw.bw, msg, mw.pver, mw.btcnet)
+		if err != nil {
+			mw.bw.Flush()
+			return err
+		}
+	}
+	return mw.bw.Flush()
+}
+
+// MessageReader wraps an io.Reader to read multiple bitcoin messages
+// back-to-back for a fixed protocol version and bitcoin network.  It pairs
+// with MessageWriter.
+type MessageReader struct {
+	r      io.Reader
+	pver   uint32
+	btcnet BitcoinNet
+}
+
+// NewMessageReader returns a new MessageReader which reads messages from r
+// using the protocol version pver and the bitcoin network btcnet.
+func NewMessageReader(r io.Reader, pver uint32, btcnet BitcoinNet) *MessageReader {
+	return &MessageReader{
+		r:      r,
+		pver:   pver,
+		btcnet: btcnet,
+	}
+}
+
+// SetProtocolVersion sets the protocol version used to decode subsequent
+// messages.  This is typically used once a lower protocol version has been
+// negotiated with the remote peer.
+func (mr *MessageReader) SetProtocolVersion(pver uint32) {
+	mr.pver = pver
+}
+
+// Read reads, validates, and parses the next bitcoin Message from the
+// underlying reader.  See ReadMessage for details.
+func (mr *MessageReader) Read() (Message, error) {
+	msg, _, err := ReadMessage(mr.r, mr.pver, mr.btcnet)
+	return msg, err
+}
+
+// ReadN is identical to Read except it also returns the number of bytes read
+// from the underlying reader like ReadMessageN.
+func (mr *MessageReader) ReadN() (int, Message, error) {
+	n, msg, _, err := ReadMessageN(mr.r, mr.pver, mr.btcnet)
+	return n, msg, err
+}
+
+// EqualMessage returns whether a and b have the same command and encode to the
+// same bytes for the provided protocol version.  Comparing the encodings
+// avoids the need to walk nested pointers and slices when checking that a
+// decoded message matches an expected one.
+//
+// When the messages differ, the returned string describes the first
+// difference, such as the offset of the first differing byte, so it can be
+// used directly in test failure output.  It is empty when the messages are
+// equal.
+func EqualMessage(a, b Message, pver uint32) (bool, string) {
+	if a.Command() != b.Command() {
+		return false, fmt.Sprintf("command %q differs from %q",
+			a.Command(), b.Command())
+	}
+
+	var bufA, bufB bytes.Buffer
+	if err := a.BtcEncode(&bufA, pver); err != nil {
+		return false, fmt.Sprintf("failed to encode first message: %v",
+			err)
+	}
+	if err := b.BtcEncode(&bufB, pver); err != nil {
+		return false, fmt.Sprintf("failed to encode second message: %v",
+			err)
+	}
+
+	encA, encB := bufA.Bytes(), bufB.Bytes()
+	for i := 0; i < len(encA) && i < len(encB); i++ {
+		if encA[i] != encB[i] {
+			return false, fmt.Sprintf("first difference at offset "+
+				"%d: 0x%02x != 0x%02x", i, encA[i], encB[i])
+		}
+	}
+	if len(encA) != len(encB) {
+		return false, fmt.Sprintf("encoded length %d differs from %d "+
+			"after common prefix", len(encA), len(encB))
+	}
+
+	return true, ""
+}
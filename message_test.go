@@ -6,12 +6,18 @@ package btcwire_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"github.com/conformal/btcwire"
 	"github.com/davecgh/go-spew/spew"
 	"io"
 	"net"
 	"reflect"
+	"runtime"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 )
@@ -32,6 +38,256 @@ func makeHeader(btcnet btcwire.BitcoinNet, command string,
 	return buf
 }
 
+// TestMessageHeader tests the MessageHeader Read and Write functions.
+func TestMessageHeader(t *testing.T) {
+	tests := []struct {
+		in  btcwire.MessageHeader // Header to write
+		buf []byte                // Wire encoding
+	}{
+		{
+			btcwire.MessageHeader{
+				Magic:    btcwire.MainNet,
+				Command:  "verack",
+				Checksum: [4]byte{0x5d, 0xf6, 0xe0, 0xe2},
+			},
+			makeHeader(btcwire.MainNet, "verack", 0, 0xe2e0f65d),
+		},
+		{
+			btcwire.MessageHeader{
+				Magic:    btcwire.TestNet3,
+				Command:  "twelve_chars",
+				Length:   0x01020304,
+				Checksum: [4]byte{0x01, 0x02, 0x03, 0x04},
+			},
+			makeHeader(btcwire.TestNet3, "twelve_chars", 0x01020304,
+				0x04030201),
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		var buf bytes.Buffer
+		err := test.in.Write(&buf)
+		if err != nil {
+			t.Errorf("Write #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) ||
+			buf.Len() != btcwire.MessageHeaderSize {
+
+			t.Errorf("Write #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		var hdr btcwire.MessageHeader
+		err = hdr.Read(bytes.NewReader(test.buf))
+		if err != nil {
+			t.Errorf("Read #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(hdr, test.in) {
+			t.Errorf("Read #%d\n got: %s want: %s", i,
+				spew.Sdump(hdr), spew.Sdump(test.in))
+			continue
+		}
+	}
+
+	// Ensure commands which don't fit are rejected without writing
+	// anything.
+	hdr := btcwire.MessageHeader{Command: "thirteen_char"}
+	var buf bytes.Buffer
+	err := hdr.Write(&buf)
+	msgErr, ok := err.(*btcwire.MessageError)
+	if !ok || msgErr.Code != btcwire.ErrUnknownCommand || buf.Len() != 0 {
+		t.Errorf("Write: wrong error got: %v, want: %v", err,
+			btcwire.ErrUnknownCommand)
+	}
+
+	// Ensure short headers are rejected.
+	err = hdr.Read(bytes.NewReader(make([]byte, btcwire.MessageHeaderSize-1)))
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("Read: wrong error got: %v, want: %v", err,
+			io.ErrUnexpectedEOF)
+	}
+}
+
+// TestReadMessageHeaderPayload tests reading messages in two phases with
+// ReadMessageHeader followed by ReadMessagePayload or DiscardMessagePayload.
+func TestReadMessageHeaderPayload(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcnet := btcwire.MainNet
+
+	// Stream of a block message followed by a ping message.
+	var buf bytes.Buffer
+	err := btcwire.WriteMessage(&buf, &blockOne, pver, btcnet)
+	if err != nil {
+		t.Errorf("WriteMessage: %v", err)
+		return
+	}
+	ping := btcwire.NewMsgPing(123123)
+	err = btcwire.WriteMessage(&buf, ping, pver, btcnet)
+	if err != nil {
+		t.Errorf("WriteMessage: %v", err)
+		return
+	}
+	stream := buf.Bytes()
+
+	// Ensure the header of the block is read on its own and its payload is
+	// read after it.
+	r := bytes.NewReader(stream)
+	hdr, err := btcwire.ReadMessageHeader(r)
+	if err != nil {
+		t.Errorf("ReadMessageHeader: %v", err)
+		return
+	}
+	if hdr.Command != "block" || hdr.Length != uint32(len(blockOneBytes)) {
+		t.Errorf("ReadMessageHeader: wrong header - got %v", spew.Sdump(hdr))
+	}
+	if n := len(stream) - r.Len(); n != btcwire.MessageHeaderSize {
+		t.Errorf("ReadMessageHeader: read %d bytes, want %d", n,
+			btcwire.MessageHeaderSize)
+	}
+	msg, payload, err := btcwire.ReadMessagePayload(r, hdr, pver, btcnet)
+	if err != nil {
+		t.Errorf("ReadMessagePayload: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(msg, &blockOne) {
+		t.Errorf("ReadMessagePayload: wrong message - got %v, want %v",
+			spew.Sdump(msg), spew.Sdump(&blockOne))
+	}
+	if !bytes.Equal(payload, blockOneBytes) {
+		t.Errorf("ReadMessagePayload: wrong payload - got %v, want %v",
+			spew.Sdump(payload), spew.Sdump(blockOneBytes))
+	}
+
+	// Ensure the payload of the block can be discarded to read the ping
+	// which follows it.
+	r = bytes.NewReader(stream)
+	hdr, err = btcwire.ReadMessageHeader(r)
+	if err != nil {
+		t.Errorf("ReadMessageHeader: %v", err)
+		return
+	}
+	err = btcwire.DiscardMessagePayload(r, hdr)
+	if err != nil {
+		t.Errorf("DiscardMessagePayload: %v", err)
+		return
+	}
+	msg, _, err = btcwire.ReadMessage(r, pver, btcnet)
+	if err != nil {
+		t.Errorf("ReadMessage: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(msg, ping) {
+		t.Errorf("ReadMessage: wrong message - got %v, want %v",
+			spew.Sdump(msg), spew.Sdump(ping))
+	}
+
+	// Ensure discarding a truncated payload fails.
+	truncated := stream[:btcwire.MessageHeaderSize+10]
+	r = bytes.NewReader(truncated)
+	hdr, err = btcwire.ReadMessageHeader(r)
+	if err != nil {
+		t.Errorf("ReadMessageHeader: %v", err)
+		return
+	}
+	err = btcwire.DiscardMessagePayload(r, hdr)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("DiscardMessagePayload: wrong error got: %v, want: %v",
+			err, io.ErrUnexpectedEOF)
+	}
+}
+
+// TestReadMessagePayloadErrors performs negative tests against
+// ReadMessagePayload to confirm the header is validated and the payload read
+// with it is checked.
+func TestReadMessagePayloadErrors(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcnet := btcwire.MainNet
+	pingPayload := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+	pingChecksum := btcwire.DoubleSha256Checksum(pingPayload)
+
+	tests := []struct {
+		hdr     btcwire.MessageHeader // Header of the message
+		payload []byte                // Payload following the header
+		err     error                 // Expected error
+	}{
+		// Message from another network.
+		{
+			btcwire.MessageHeader{Magic: btcwire.TestNet3,
+				Command: "ping", Length: 8, Checksum: pingChecksum},
+			pingPayload, btcwire.ErrWrongNetwork,
+		},
+		// Unknown command.
+		{
+			btcwire.MessageHeader{Magic: btcnet, Command: "bogus",
+				Length: 8, Checksum: pingChecksum},
+			pingPayload, btcwire.ErrUnknownMessage,
+		},
+		// Payload larger than allowed for the command.
+		{
+			btcwire.MessageHeader{Magic: btcnet, Command: "ping",
+				Length: 9, Checksum: pingChecksum},
+			append(pingPayload, 0x09), nil,
+		},
+		// Checksum which doesn't match the payload.
+		{
+			btcwire.MessageHeader{Magic: btcnet, Command: "ping",
+				Length: 8},
+			pingPayload, btcwire.ErrInvalidChecksum,
+		},
+		// Payload shorter than the header claims.
+		{
+			btcwire.MessageHeader{Magic: btcnet, Command: "ping",
+				Length: 8, Checksum: pingChecksum},
+			pingPayload[:4], io.ErrUnexpectedEOF,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		r := bytes.NewReader(test.payload)
+		_, _, err := btcwire.ReadMessagePayload(r, &test.hdr, pver, btcnet)
+		if err == nil {
+			t.Errorf("ReadMessagePayload #%d: no error", i)
+			continue
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("ReadMessagePayload #%d: wrong error got: %v, "+
+				"want: %v", i, err, test.err)
+			continue
+		}
+
+		// Ensure the payload was consumed so the next message can be
+		// read.
+		if r.Len() != 0 {
+			t.Errorf("ReadMessagePayload #%d: %d bytes of payload "+
+				"left unread", i, r.Len())
+			continue
+		}
+	}
+
+	// Ensure a header claiming a huge payload which never arrives does not
+	// force an allocation of its claimed size.
+	hdr := btcwire.MessageHeader{Magic: btcnet, Command: "tx",
+		Length: btcwire.MaxMessagePayload}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, _, err := btcwire.ReadMessagePayload(bytes.NewReader(make([]byte, 10)),
+		&hdr, pver, btcnet)
+	runtime.ReadMemStats(&after)
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadMessagePayload: wrong error got: %v, want: %v",
+			err, io.ErrUnexpectedEOF)
+	}
+	if n := after.TotalAlloc - before.TotalAlloc; n > 1<<19 {
+		t.Errorf("ReadMessagePayload: allocated %d bytes for a "+
+			"truncated payload", n)
+	}
+}
+
 // TestMessage tests the Read/WriteMessage API.
 func TestMessage(t *testing.T) {
 	pver := btcwire.ProtocolVersion
@@ -68,6 +324,30 @@ func TestMessage(t *testing.T) {
 	msgHeaders := btcwire.NewMsgHeaders()
 	msgAlert := btcwire.NewMsgAlert("payload", "signature")
 	msgMemPool := btcwire.NewMsgMemPool()
+	msgReject := btcwire.NewMsgReject("block", btcwire.RejectDuplicate,
+		"duplicate block")
+	msgGetUTXOs := btcwire.NewMsgGetUTXOs(true)
+	msgUTXOs := btcwire.NewMsgUTXOs(0, &btcwire.GenesisHash)
+	msgUTXOs.HitsBitmap = []byte{}
+	msgWTxIDRelay := btcwire.NewMsgWTxIDRelay()
+	msgSendHeaders := btcwire.NewMsgSendHeaders()
+	msgFeeFilter := btcwire.NewMsgFeeFilter(1000)
+	msgFilterLoad := &btcwire.MsgFilterLoad{
+		Filter:    []byte{0x01},
+		HashFuncs: 10,
+		Tweak:     0,
+		Flags:     btcwire.BloomUpdateNone,
+	}
+	msgAddrV2 := btcwire.NewMsgAddrV2()
+	msgMerkleBlock := btcwire.NewMsgMerkleBlock(&btcwire.BlockHeader{})
+	msgMerkleBlock.Header.Timestamp = time.Unix(0, 0)
+	msgMerkleBlock.Transactions = 1
+	msgMerkleBlock.AddTxHash(&btcwire.ShaHash{})
+	msgMerkleBlock.Flags = []byte{0x80}
+	msgSendCmpct := btcwire.NewMsgSendCmpct(true, btcwire.CmpctBlockVersion)
+	msgCmpctBlock := btcwire.NewMsgCmpctBlock(&btcwire.BlockHeader{}, 0)
+	msgCmpctBlock.Header.Timestamp = time.Unix(0, 0)
+	msgCmpctBlock.AddShortID(0x010203040506)
 
 	tests := []struct {
 		in     btcwire.Message    // Value to encode
@@ -76,6 +356,7 @@ func TestMessage(t *testing.T) {
 		btcnet btcwire.BitcoinNet // Network to use for wire encoding
 	}{
 		{msgVersion, msgVersion, pver, btcwire.MainNet},
+		{msgVersion, msgVersion, pver, btcwire.RegTest},
 		{msgVerack, msgVerack, pver, btcwire.MainNet},
 		{msgGetAddr, msgGetAddr, pver, btcwire.MainNet},
 		{msgAddr, msgAddr, pver, btcwire.MainNet},
@@ -91,6 +372,22 @@ func TestMessage(t *testing.T) {
 		{msgHeaders, msgHeaders, pver, btcwire.MainNet},
 		{msgAlert, msgAlert, pver, btcwire.MainNet},
 		{msgMemPool, msgMemPool, pver, btcwire.MainNet},
+		{msgReject, msgReject, pver, btcwire.MainNet},
+		{msgGetUTXOs, msgGetUTXOs, pver, btcwire.MainNet},
+		{msgUTXOs, msgUTXOs, pver, btcwire.MainNet},
+		{msgWTxIDRelay, msgWTxIDRelay, btcwire.WTxIDRelayVersion,
+			btcwire.MainNet},
+		{msgSendHeaders, msgSendHeaders, btcwire.SendHeadersVersion,
+			btcwire.MainNet},
+		{msgFeeFilter, msgFeeFilter, btcwire.FeeFilterVersion,
+			btcwire.MainNet},
+		{msgFilterLoad, msgFilterLoad, pver, btcwire.MainNet},
+		{msgAddrV2, msgAddrV2, pver, btcwire.MainNet},
+		{msgMerkleBlock, msgMerkleBlock, pver, btcwire.MainNet},
+		{msgSendCmpct, msgSendCmpct, btcwire.SendCmpctVersion,
+			btcwire.MainNet},
+		{msgCmpctBlock, msgCmpctBlock, btcwire.SendCmpctVersion,
+			btcwire.MainNet},
 	}
 
 	t.Logf("Running %d tests", len(tests))
@@ -119,6 +416,126 @@ func TestMessage(t *testing.T) {
 	}
 }
 
+// TestMessageOptionsChecksum tests reading and writing messages with a custom
+// checksum function.
+func TestMessageOptionsChecksum(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcnet := btcwire.MainNet
+
+	// Checksum function which always produces the same checksum
+	// regardless of the payload.
+	fixedChecksum := func(payload []byte) [4]byte {
+		return [4]byte{0xde, 0xad, 0xbe, 0xef}
+	}
+	opts := &btcwire.MessageOptions{Checksum: fixedChecksum}
+	msgPing := btcwire.NewMsgPing(123123)
+
+	// Ensure the custom checksum is written to the header.
+	var buf bytes.Buffer
+	err := btcwire.WriteMessageWithOptions(&buf, msgPing, pver, btcnet,
+		opts)
+	if err != nil {
+		t.Errorf("WriteMessageWithOptions: %v", err)
+		return
+	}
+	encoded := buf.Bytes()
+	if !bytes.Equal(encoded[20:24], []byte{0xde, 0xad, 0xbe, 0xef}) {
+		t.Errorf("WriteMessageWithOptions: wrong checksum - got %x, "+
+			"want %x", encoded[20:24], []byte{0xde, 0xad, 0xbe, 0xef})
+	}
+
+	// Ensure the message is read back with the same checksum function.
+	msg, _, err := btcwire.ReadMessageWithOptions(bytes.NewBuffer(encoded),
+		pver, btcnet, opts)
+	if err != nil {
+		t.Errorf("ReadMessageWithOptions: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(msg, msgPing) {
+		t.Errorf("ReadMessageWithOptions\n got: %v want: %v",
+			spew.Sdump(msg), spew.Sdump(msgPing))
+	}
+
+	// Ensure the standard checksum rejects the message.
+	_, _, err = btcwire.ReadMessage(bytes.NewBuffer(encoded), pver, btcnet)
+	if _, ok := err.(*btcwire.MessageError); !ok {
+		t.Errorf("ReadMessage: wrong error - got %v <%T>, want "+
+			"<*btcwire.MessageError>", err, err)
+	}
+
+	// Ensure nil options and the default checksum function produce the
+	// same bytes as WriteMessage.
+	var want, got bytes.Buffer
+	err = btcwire.WriteMessage(&want, msgPing, pver, btcnet)
+	if err != nil {
+		t.Errorf("WriteMessage: %v", err)
+		return
+	}
+	for _, opts := range []*btcwire.MessageOptions{nil, {},
+		{Checksum: btcwire.DoubleSha256Checksum}} {
+
+		got.Reset()
+		err = btcwire.WriteMessageWithOptions(&got, msgPing, pver,
+			btcnet, opts)
+		if err != nil {
+			t.Errorf("WriteMessageWithOptions: %v", err)
+			continue
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("WriteMessageWithOptions\n got: %v want: %v",
+				spew.Sdump(got.Bytes()), spew.Sdump(want.Bytes()))
+			continue
+		}
+	}
+}
+
+// TestPayloadChecksum ensures PayloadChecksum produces the checksum written to
+// message headers so hand-edited payloads can be given valid headers.
+func TestPayloadChecksum(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcnet := btcwire.MainNet
+
+	// Ensure the checksum matches the one written by WriteMessage.
+	msgPing := btcwire.NewMsgPing(123123)
+	var buf bytes.Buffer
+	err := btcwire.WriteMessage(&buf, msgPing, pver, btcnet)
+	if err != nil {
+		t.Errorf("WriteMessage: %v", err)
+		return
+	}
+	encoded := buf.Bytes()
+	checksum := btcwire.PayloadChecksum(encoded[24:])
+	if !bytes.Equal(checksum[:], encoded[20:24]) {
+		t.Errorf("PayloadChecksum: wrong checksum - got %x, want %x",
+			checksum, encoded[20:24])
+	}
+
+	// Ensure the checksum of an empty payload is the well known value.
+	wantEmpty := [4]byte{0x5d, 0xf6, 0xe0, 0xe2}
+	if checksum := btcwire.PayloadChecksum(nil); checksum != wantEmpty {
+		t.Errorf("PayloadChecksum: wrong checksum for empty payload - "+
+			"got %x, want %x", checksum, wantEmpty)
+	}
+
+	// Ensure a hand-edited payload with a recomputed checksum is read
+	// back.
+	payload := append([]byte{}, encoded[24:]...)
+	payload[0] ^= 0xff
+	checksum = btcwire.PayloadChecksum(payload)
+	edited := append(append([]byte{}, encoded[:20]...), checksum[:]...)
+	edited = append(edited, payload...)
+	msg, _, err := btcwire.ReadMessage(bytes.NewReader(edited), pver, btcnet)
+	if err != nil {
+		t.Errorf("ReadMessage: %v", err)
+		return
+	}
+	want := btcwire.NewMsgPing(123123 ^ 0xff)
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("ReadMessage\n got: %v want: %v", spew.Sdump(msg),
+			spew.Sdump(want))
+	}
+}
+
 // TestReadMessageWireErrors performs negative tests against wire decoding into
 // concrete messages to confirm error paths work correctly.
 func TestReadMessageWireErrors(t *testing.T) {
@@ -149,10 +566,17 @@ func TestReadMessageWireErrors(t *testing.T) {
 	mpl := btcwire.MaxMessagePayload
 	exceedMaxPayloadBytes := makeHeader(btcnet, "getaddr", mpl+1, 0)
 
-	// Wire encoded bytes for a command which is invalid utf-8.
+	// Wire encoded bytes for a command which is not ASCII.
 	badCommandBytes := makeHeader(btcnet, "bogus", 0, 0)
 	badCommandBytes[4] = 0x81
 
+	// Wire encoded bytes for a command with data after the zero byte which
+	// terminates it.
+	embeddedNullBytes := makeHeader(btcnet, "verack\x00x", 0, 0xe2e0f65d)
+
+	// Wire encoded bytes for a command with a non-printable character.
+	unprintableBytes := makeHeader(btcnet, "verack\x7f", 0, 0xe2e0f65d)
+
 	// Wire encoded bytes for a command which is valid, but not supported.
 	unsupportedCommandBytes := makeHeader(btcnet, "bogus", 0, 0)
 
@@ -204,7 +628,7 @@ func TestReadMessageWireErrors(t *testing.T) {
 			pver,
 			btcnet,
 			len(testNet3Bytes),
-			&btcwire.MessageError{},
+			&btcwire.MessageError{Code: btcwire.ErrNetworkMismatch},
 		},
 
 		// Exceed max overall message payload length.
@@ -213,16 +637,34 @@ func TestReadMessageWireErrors(t *testing.T) {
 			pver,
 			btcnet,
 			len(exceedMaxPayloadBytes),
-			&btcwire.MessageError{},
+			&btcwire.MessageError{Code: btcwire.ErrPayloadTooLarge},
 		},
 
-		// Invalid UTF-8 command.
+		// Invalid command.
 		{
 			badCommandBytes,
 			pver,
 			btcnet,
 			len(badCommandBytes),
-			&btcwire.MessageError{},
+			&btcwire.MessageError{Code: btcwire.ErrUnknownCommand},
+		},
+
+		// Command with data after its terminating zero byte.
+		{
+			embeddedNullBytes,
+			pver,
+			btcnet,
+			len(embeddedNullBytes),
+			&btcwire.MessageError{Code: btcwire.ErrUnknownCommand},
+		},
+
+		// Command with a non-printable character.
+		{
+			unprintableBytes,
+			pver,
+			btcnet,
+			len(unprintableBytes),
+			&btcwire.MessageError{Code: btcwire.ErrUnknownCommand},
 		},
 
 		// Valid, but unsupported command.
@@ -231,7 +673,7 @@ func TestReadMessageWireErrors(t *testing.T) {
 			pver,
 			btcnet,
 			len(unsupportedCommandBytes),
-			&btcwire.MessageError{},
+			&btcwire.MessageError{Code: btcwire.ErrUnknownCommand},
 		},
 
 		// Exceed max allowed payload for a message of a specific type.
@@ -240,7 +682,7 @@ func TestReadMessageWireErrors(t *testing.T) {
 			pver,
 			btcnet,
 			len(exceedTypePayloadBytes),
-			&btcwire.MessageError{},
+			&btcwire.MessageError{Code: btcwire.ErrPayloadTooLarge},
 		},
 
 		// Message with a payload shorter than the header indicates.
@@ -258,7 +700,7 @@ func TestReadMessageWireErrors(t *testing.T) {
 			pver,
 			btcnet,
 			len(badChecksumBytes),
-			&btcwire.MessageError{},
+			&btcwire.MessageError{Code: btcwire.ErrBadChecksum},
 		},
 
 		// Message with a valid header, but wrong format.
@@ -276,7 +718,7 @@ func TestReadMessageWireErrors(t *testing.T) {
 			pver,
 			btcnet,
 			len(discardBytes),
-			&btcwire.MessageError{},
+			&btcwire.MessageError{Code: btcwire.ErrUnknownCommand},
 		},
 	}
 
@@ -292,85 +734,1494 @@ func TestReadMessageWireErrors(t *testing.T) {
 		}
 
 		// For errors which are not of type btcwire.MessageError, check
-		// them for equality.
-		if _, ok := err.(*btcwire.MessageError); !ok {
+		// them for equality.  Otherwise, ensure the error code is the
+		// expected one.
+		msgErr, ok := err.(*btcwire.MessageError)
+		if !ok {
 			if err != test.readErr {
 				t.Errorf("ReadMessage #%d wrong error got: %v <%T>, "+
 					"want: %v <%T>", i, err, err,
 					test.readErr, test.readErr)
-				continue
+			}
+			continue
+		}
+		wantCode := test.readErr.(*btcwire.MessageError).Code
+		if msgErr.Code != wantCode {
+			t.Errorf("ReadMessage #%d wrong error code got: %v, "+
+				"want: %v", i, msgErr.Code, wantCode)
+			continue
+		}
+
+		// Ensure the sentinel error which corresponds to the error code
+		// is wrapped.
+		sentinels := []struct {
+			code btcwire.ErrorCode
+			err  error
+		}{
+			{btcwire.ErrUnknownCommand, btcwire.ErrUnknownMessage},
+			{btcwire.ErrNetworkMismatch, btcwire.ErrWrongNetwork},
+			{btcwire.ErrBadChecksum, btcwire.ErrInvalidChecksum},
+		}
+		for _, sentinel := range sentinels {
+			want := sentinel.code == wantCode
+			if errors.Is(err, sentinel.err) != want {
+				t.Errorf("ReadMessage #%d errors.Is(%v) got: %v, "+
+					"want: %v", i, sentinel.err, !want, want)
 			}
 		}
 	}
 }
 
-// TestWriteMessageWireErrors performs negative tests against wire encoding from
-// concrete messages to confirm error paths work correctly.
-func TestWriteMessageWireErrors(t *testing.T) {
+// TestReadMessageEmptyPayload ensures a header for a message which never has a
+// payload, such as verack, is rejected when it claims a nonzero payload length
+// without consuming the claimed payload.
+func TestReadMessageEmptyPayload(t *testing.T) {
 	pver := btcwire.ProtocolVersion
 	btcnet := btcwire.MainNet
-	btcwireErr := &btcwire.MessageError{}
 
-	// Fake message with a command that is too long.
-	badCommandMsg := &fakeMessage{command: "somethingtoolong"}
+	payload := []byte{0x01, 0x02, 0x03, 0x04, 0x05}
+	checksum := btcwire.PayloadChecksum(payload)
+	buf := makeHeader(btcnet, "verack", uint32(len(payload)),
+		binary.LittleEndian.Uint32(checksum[:]))
+	buf = append(buf, payload...)
 
-	// Fake message with a problem during encoding
-	encodeErrMsg := &fakeMessage{forceEncodeErr: true}
+	r := bytes.NewBuffer(buf)
+	_, _, err := btcwire.ReadMessage(r, pver, btcnet)
+	msgErr, ok := err.(*btcwire.MessageError)
+	if !ok {
+		t.Errorf("ReadMessage: wrong error - got %v <%T>, want "+
+			"<*btcwire.MessageError>", err, err)
+		return
+	}
+	if msgErr.Code != btcwire.ErrPayloadTooLarge {
+		t.Errorf("ReadMessage: wrong error code - got %v, want %v",
+			msgErr.Code, btcwire.ErrPayloadTooLarge)
+	}
 
-	// Fake message that has payload which exceeds max overall message size.
-	exceedOverallPayload := make([]byte, btcwire.MaxMessagePayload+1)
-	exceedOverallPayloadErrMsg := &fakeMessage{payload: exceedOverallPayload}
+	// Ensure the claimed payload was not read.
+	if !bytes.Equal(r.Bytes(), payload) {
+		t.Errorf("ReadMessage: unexpected bytes consumed - remaining "+
+			"%x, want %x", r.Bytes(), payload)
+	}
 
-	// Fake message that has payload which exceeds max allowed per message.
-	exceedPayload := make([]byte, 1)
-	exceedPayloadErrMsg := &fakeMessage{payload: exceedPayload, forceLenErr: true}
+	// Ensure a verack with an empty payload is still read.
+	buf = makeHeader(btcnet, "verack", 0, 0xe2e0f65d)
+	msg, _, err := btcwire.ReadMessage(bytes.NewBuffer(buf), pver, btcnet)
+	if err != nil {
+		t.Errorf("ReadMessage: %v", err)
+		return
+	}
+	if _, ok := msg.(*btcwire.MsgVerAck); !ok {
+		t.Errorf("ReadMessage: wrong message type - got %T, want "+
+			"*btcwire.MsgVerAck", msg)
+	}
 
-	// Fake message that is used to force errors in the header and payload
-	// writes.
-	bogusPayload := []byte{0x01, 0x02, 0x03, 0x04}
-	bogusMsg := &fakeMessage{command: "bogus", payload: bogusPayload}
+	// Ensure a message which only lacks a payload at older protocol
+	// versions, such as ping before BIP0031Version, has its payload
+	// discarded so the stream stays synchronized.
+	nonce := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+	checksum = btcwire.PayloadChecksum(nonce)
+	buf = makeHeader(btcnet, "ping", uint32(len(nonce)),
+		binary.LittleEndian.Uint32(checksum[:]))
+	buf = append(buf, nonce...)
+	r = bytes.NewBuffer(buf)
+	_, _, err = btcwire.ReadMessage(r, btcwire.BIP0031Version, btcnet)
+	msgErr, ok = err.(*btcwire.MessageError)
+	if !ok || msgErr.Code != btcwire.ErrPayloadTooLarge {
+		t.Errorf("ReadMessage: wrong error for old ping - got %v, "+
+			"want code %v", err, btcwire.ErrPayloadTooLarge)
+	}
+	if r.Len() != 0 {
+		t.Errorf("ReadMessage: old ping payload not discarded - "+
+			"remaining %x", r.Bytes())
+	}
+}
+
+// TestWriteMessageEmptyPayload ensures messages without a payload are written
+// with exactly the same bytes as the general path produces and that their
+// encoders still reject invalid protocol versions.
+func TestWriteMessageEmptyPayload(t *testing.T) {
+	btcnet := btcwire.MainNet
+
+	// A custom checksum function forces the general path.
+	generalOpts := &btcwire.MessageOptions{
+		Checksum: btcwire.DoubleSha256Checksum,
+	}
 
 	tests := []struct {
-		msg    btcwire.Message    // Message to encode
-		pver   uint32             // Protocol version for wire encoding
-		btcnet btcwire.BitcoinNet // Bitcoin network for wire encoding
-		max    int                // Max size of fixed buffer to induce errors
-		err    error              // Expected error
+		msg     btcwire.Message // Message to write
+		pver    uint32          // Protocol version for wire encoding
+		command string          // Expected command
+		err     bool            // Expect an error?
 	}{
-		// Command too long.
-		{badCommandMsg, pver, btcnet, 0, btcwireErr},
-		// Force error in payload encode.
-		{encodeErrMsg, pver, btcnet, 0, btcwireErr},
-		// Force error due to exceeding max overall message payload size.
-		{exceedOverallPayloadErrMsg, pver, btcnet, 0, btcwireErr},
-		// Force error due to exceeding max payload for message type.
-		{exceedPayloadErrMsg, pver, btcnet, 0, btcwireErr},
-		// Force error in header write.
-		{bogusMsg, pver, btcnet, 0, io.ErrShortWrite},
-		// Force error in payload write.
-		{bogusMsg, pver, btcnet, 24, io.ErrShortWrite},
+		{btcwire.NewMsgVerAck(), btcwire.ProtocolVersion, "verack", false},
+		{btcwire.NewMsgGetAddr(), btcwire.ProtocolVersion, "getaddr", false},
+		{btcwire.NewMsgMemPool(), btcwire.ProtocolVersion, "mempool", false},
+		{btcwire.NewMsgMemPool(), btcwire.BIP0035Version - 1, "mempool", true},
+		{btcwire.NewMsgWTxIDRelay(), btcwire.WTxIDRelayVersion,
+			"wtxidrelay", false},
+		{btcwire.NewMsgWTxIDRelay(), btcwire.WTxIDRelayVersion - 1,
+			"wtxidrelay", true},
+		{btcwire.NewMsgSendHeaders(), btcwire.SendHeadersVersion,
+			"sendheaders", false},
+		{btcwire.NewMsgSendHeaders(), btcwire.SendHeadersVersion - 1,
+			"sendheaders", true},
+
+		// Messages which claim not to have a payload but write one or
+		// fail to encode.
+		{&fakeMessage{command: "fake", payload: []byte{0x01},
+			forceLenErr: true}, btcwire.ProtocolVersion, "fake", true},
+		{&fakeMessage{command: "fake", forceEncodeErr: true},
+			btcwire.ProtocolVersion, "fake", true},
 	}
 
 	t.Logf("Running %d tests", len(tests))
 	for i, test := range tests {
-		// Encode wire format.
-		w := newFixedWriter(test.max)
-		err := btcwire.WriteMessage(w, test.msg, test.pver, test.btcnet)
-		if reflect.TypeOf(err) != reflect.TypeOf(test.err) {
-			t.Errorf("WriteMessage #%d wrong error got: %v <%T>, "+
-				"want: %T", i, err, err, test.err)
+		var buf bytes.Buffer
+		n, err := btcwire.WriteMessageN(&buf, test.msg, test.pver, btcnet)
+		if test.err {
+			if err == nil {
+				t.Errorf("WriteMessageN #%d expected error", i)
+			}
+			if n != 0 || buf.Len() != 0 {
+				t.Errorf("WriteMessageN #%d wrote %d bytes on error",
+					i, buf.Len())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("WriteMessageN #%d error %v", i, err)
 			continue
 		}
 
-		// For errors which are not of type btcwire.MessageError, check
-		// them for equality.
-		if _, ok := err.(*btcwire.MessageError); !ok {
-			if err != test.err {
-				t.Errorf("ReadMessage #%d wrong error got: %v <%T>, "+
-					"want: %v <%T>", i, err, err,
-					test.err, test.err)
-				continue
-			}
+		want := makeHeader(btcnet, test.command, 0, 0xe2e0f65d)
+		if !bytes.Equal(buf.Bytes(), want) || n != len(want) {
+			t.Errorf("WriteMessageN #%d (%d bytes)\n got: %s want: %s",
+				i, n, spew.Sdump(buf.Bytes()), spew.Sdump(want))
+			continue
+		}
+
+		var general bytes.Buffer
+		err = btcwire.WriteMessageWithOptions(&general, test.msg,
+			test.pver, btcnet, generalOpts)
+		if err != nil {
+			t.Errorf("WriteMessageWithOptions #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), general.Bytes()) {
+			t.Errorf("WriteMessageN #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(general.Bytes()))
+			continue
+		}
+	}
+}
+
+// TestReadMessageBoundary ensures a message whose internal length fields claim
+// more data than its payload contains fails to decode without consuming any
+// of the following message on the stream.
+func TestReadMessageBoundary(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcnet := btcwire.MainNet
+
+	// Transaction payload with a single input whose signature script
+	// length claims 4096 bytes while only 4 bytes follow.
+	badTxPayload := []byte{
+		0x01, 0x00, 0x00, 0x00, // Version
+		0x01, // Varint for number of input transactions
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Previous output hash
+		0xff, 0xff, 0xff, 0xff, // Previous output index
+		0xfd, 0x00, 0x10, // Varint for length of signature script (4096)
+		0x04, 0x31, 0xdc, 0x00, // Truncated signature script
+	}
+	checksum := btcwire.DoubleSha256(badTxPayload)[0:4]
+	badTxBytes := makeHeader(btcnet, "tx", uint32(len(badTxPayload)),
+		binary.LittleEndian.Uint32(checksum))
+	badTxBytes = append(badTxBytes, badTxPayload...)
+
+	// Follow the bad transaction with a valid ping on the same stream.
+	buf := bytes.NewBuffer(badTxBytes)
+	msgPing := btcwire.NewMsgPing(123123)
+	err := btcwire.WriteMessage(buf, msgPing, pver, btcnet)
+	if err != nil {
+		t.Errorf("WriteMessage: %v", err)
+		return
+	}
+
+	// Ensure the bad transaction fails to decode.
+	_, _, err = btcwire.ReadMessage(buf, pver, btcnet)
+	if err == nil {
+		t.Errorf("ReadMessage: did not receive expected error for " +
+			"transaction with oversized script length")
+		return
+	}
+
+	// Ensure the ping which follows is read intact.
+	msg, _, err := btcwire.ReadMessage(buf, pver, btcnet)
+	if err != nil {
+		t.Errorf("ReadMessage: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(msg, msgPing) {
+		t.Errorf("ReadMessage\n got: %v want: %v", spew.Sdump(msg),
+			spew.Sdump(msgPing))
+	}
+}
+
+// TestRawMessage tests the ReadRawMessage and WriteRawMessage API for both
+// known and unknown commands.
+func TestRawMessage(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcnet := btcwire.MainNet
+
+	// Ensure a raw message is framed identically to the same message
+	// written with WriteMessage.
+	msgPing := btcwire.NewMsgPing(123123)
+	var want bytes.Buffer
+	err := btcwire.WriteMessage(&want, msgPing, pver, btcnet)
+	if err != nil {
+		t.Errorf("WriteMessage: %v", err)
+		return
+	}
+	var buf bytes.Buffer
+	err = btcwire.WriteRawMessage(&buf, "ping", want.Bytes()[24:], btcnet)
+	if err != nil {
+		t.Errorf("WriteRawMessage: %v", err)
+		return
+	}
+	if !bytes.Equal(buf.Bytes(), want.Bytes()) {
+		t.Errorf("WriteRawMessage\n got: %s want: %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(want.Bytes()))
+	}
+
+	tests := []struct {
+		command string // Command of the message
+		payload []byte // Raw payload of the message
+	}{
+		{"ping", want.Bytes()[24:]},
+		{"verack", []byte{}},
+
+		// Commands which are not known to this package, including ones
+		// with payloads which would not decode as any message.
+		{"futurecmd", []byte{0xde, 0xad, 0xbe, 0xef}},
+		{"twelvebytes!", []byte{0x00}},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		var buf bytes.Buffer
+		err := btcwire.WriteRawMessage(&buf, test.command, test.payload,
+			btcnet)
+		if err != nil {
+			t.Errorf("WriteRawMessage #%d error %v", i, err)
+			continue
+		}
+		encoded := append([]byte{}, buf.Bytes()...)
+
+		command, payload, err := btcwire.ReadRawMessage(&buf, btcnet)
+		if err != nil {
+			t.Errorf("ReadRawMessage #%d error %v", i, err)
+			continue
+		}
+		if command != test.command {
+			t.Errorf("ReadRawMessage #%d: wrong command - got %q, "+
+				"want %q", i, command, test.command)
+			continue
+		}
+		if !bytes.Equal(payload, test.payload) {
+			t.Errorf("ReadRawMessage #%d\n got: %s want: %s", i,
+				spew.Sdump(payload), spew.Sdump(test.payload))
+			continue
+		}
+
+		// Ensure relaying the message reproduces it byte for byte.
+		buf.Reset()
+		err = btcwire.WriteRawMessage(&buf, command, payload, btcnet)
+		if err != nil {
+			t.Errorf("WriteRawMessage #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), encoded) {
+			t.Errorf("WriteRawMessage #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(encoded))
+			continue
+		}
+	}
+}
+
+// TestRawMessageErrors performs negative tests against ReadRawMessage and
+// WriteRawMessage to confirm the framing is validated.
+func TestRawMessageErrors(t *testing.T) {
+	btcnet := btcwire.MainNet
+	payload := []byte{0x01, 0x02, 0x03, 0x04}
+	checksum := btcwire.PayloadChecksum(payload)
+	sum := binary.LittleEndian.Uint32(checksum[:])
+
+	readTests := []struct {
+		buf  []byte // Wire encoding
+		err  error  // Expected read error
+		code btcwire.ErrorCode
+	}{
+		// Wrong network.
+		{
+			joinBytes(makeHeader(btcwire.TestNet3, "futurecmd", 4, sum),
+				payload),
+			&btcwire.MessageError{}, btcwire.ErrNetworkMismatch,
+		},
+		// Bad checksum.
+		{
+			joinBytes(makeHeader(btcnet, "futurecmd", 4, sum+1),
+				payload),
+			&btcwire.MessageError{}, btcwire.ErrBadChecksum,
+		},
+		// Payload larger than any message.
+		{
+			makeHeader(btcnet, "futurecmd", 0xffffffff, sum),
+			&btcwire.MessageError{}, btcwire.ErrPayloadTooLarge,
+		},
+		// Invalid UTF-8 command.
+		{
+			joinBytes(makeHeader(btcnet, "bogus\xff", 4, sum), payload),
+			&btcwire.MessageError{}, btcwire.ErrUnknownCommand,
+		},
+		// Command with data after its terminating zero byte.
+		{
+			joinBytes(makeHeader(btcnet, "future\x00cmd", 4, sum),
+				payload),
+			&btcwire.MessageError{}, btcwire.ErrUnknownCommand,
+		},
+		// Short header.
+		{makeHeader(btcnet, "futurecmd", 4, sum)[:20], io.EOF, 0},
+		// Short payload.
+		{
+			joinBytes(makeHeader(btcnet, "futurecmd", 4, sum),
+				payload[:2]),
+			io.ErrUnexpectedEOF, 0,
+		},
+	}
+
+	t.Logf("Running %d tests", len(readTests))
+	for i, test := range readTests {
+		_, _, err := btcwire.ReadRawMessage(bytes.NewReader(test.buf),
+			btcnet)
+		if _, ok := test.err.(*btcwire.MessageError); !ok {
+			if err != test.err {
+				t.Errorf("ReadRawMessage #%d wrong error got: %v, "+
+					"want: %v", i, err, test.err)
+			}
+			continue
+		}
+		msgErr, ok := err.(*btcwire.MessageError)
+		if !ok {
+			t.Errorf("ReadRawMessage #%d wrong error got: %v <%T>, "+
+				"want: <*btcwire.MessageError>", i, err, err)
+			continue
+		}
+		if msgErr.Code != test.code {
+			t.Errorf("ReadRawMessage #%d wrong error code got: %v, "+
+				"want: %v", i, msgErr.Code, test.code)
+			continue
+		}
+	}
+
+	// Ensure commands which don't fit in the header are rejected.
+	var buf bytes.Buffer
+	err := btcwire.WriteRawMessage(&buf, "thirteenbytes", payload, btcnet)
+	msgErr, ok := err.(*btcwire.MessageError)
+	if !ok || msgErr.Code != btcwire.ErrUnknownCommand {
+		t.Errorf("WriteRawMessage: wrong error for long command - got "+
+			"%v <%T>", err, err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("WriteRawMessage: wrote %d bytes for long command",
+			buf.Len())
+	}
+
+	// Ensure write errors are returned for both the header and payload.
+	for _, max := range []int{0, 24} {
+		w := newFixedWriter(max)
+		err := btcwire.WriteRawMessage(w, "futurecmd", payload, btcnet)
+		if err != io.ErrShortWrite {
+			t.Errorf("WriteRawMessage (max %d) wrong error got: %v, "+
+				"want: %v", max, err, io.ErrShortWrite)
+		}
+	}
+}
+
+// TestEqualMessage tests the EqualMessage API.
+func TestEqualMessage(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	// Message which decodes to a separate, but equal, copy of blockOne.
+	var blockCopy btcwire.MsgBlock
+	err := blockCopy.BtcDecode(bytes.NewBuffer(blockOneBytes), pver)
+	if err != nil {
+		t.Errorf("BtcDecode: %v", err)
+		return
+	}
+
+	// Message which differs from blockOne only in the block nonce.
+	blockNonce := blockCopy
+	blockNonce.Header.Nonce++
+
+	// Message which differs from blockOne only in the number of
+	// transactions.
+	blockNoTxns := btcwire.NewMsgBlock(&blockOne.Header)
+
+	tests := []struct {
+		a     btcwire.Message // First message to compare
+		b     btcwire.Message // Second message to compare
+		equal bool            // Expected result
+		desc  string          // Expected description
+	}{
+		{&blockOne, &blockCopy, true, ""},
+		{btcwire.NewMsgPing(1), btcwire.NewMsgPing(1), true, ""},
+		{
+			btcwire.NewMsgPing(1),
+			btcwire.NewMsgPong(1),
+			false,
+			`command "ping" differs from "pong"`,
+		},
+		{
+			btcwire.NewMsgPing(1),
+			btcwire.NewMsgPing(2),
+			false,
+			"first difference at offset 0: 0x01 != 0x02",
+		},
+		{
+			&blockOne,
+			&blockNonce,
+			false,
+			"first difference at offset 76: 0x01 != 0x02",
+		},
+		{
+			&blockOne,
+			blockNoTxns,
+			false,
+			"first difference at offset 80: 0x01 != 0x00",
+		},
+		{
+			btcwire.NewMsgAlert("payload", ""),
+			btcwire.NewMsgAlert("payload", "signature"),
+			false,
+			"first difference at offset 8: 0x00 != 0x09",
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		equal, desc := btcwire.EqualMessage(test.a, test.b, pver)
+		if equal != test.equal {
+			t.Errorf("EqualMessage #%d: got %v, want %v (%s)", i,
+				equal, test.equal, desc)
+			continue
+		}
+		if desc != test.desc {
+			t.Errorf("EqualMessage #%d: wrong description - got %q, "+
+				"want %q", i, desc, test.desc)
+			continue
+		}
+	}
+}
+
+// TestWriteMessageWireErrors performs negative tests against wire encoding from
+// concrete messages to confirm error paths work correctly.
+func TestWriteMessageWireErrors(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcnet := btcwire.MainNet
+	btcwireErr := &btcwire.MessageError{}
+
+	// Fake message with a command that is too long.
+	badCommandMsg := &fakeMessage{command: "somethingtoolong"}
+
+	// Fake message with a problem during encoding
+	encodeErrMsg := &fakeMessage{forceEncodeErr: true}
+
+	// Fake message that has payload which exceeds max overall message size.
+	exceedOverallPayload := make([]byte, btcwire.MaxMessagePayload+1)
+	exceedOverallPayloadErrMsg := &fakeMessage{payload: exceedOverallPayload}
+
+	// Fake message that has payload which exceeds max allowed per message.
+	exceedPayload := make([]byte, 1)
+	exceedPayloadErrMsg := &fakeMessage{payload: exceedPayload, forceLenErr: true}
+
+	// Fake message that is used to force errors in the header and payload
+	// writes.
+	bogusPayload := []byte{0x01, 0x02, 0x03, 0x04}
+	bogusMsg := &fakeMessage{command: "bogus", payload: bogusPayload}
+
+	tests := []struct {
+		msg    btcwire.Message    // Message to encode
+		pver   uint32             // Protocol version for wire encoding
+		btcnet btcwire.BitcoinNet // Bitcoin network for wire encoding
+		max    int                // Max size of fixed buffer to induce errors
+		err    error              // Expected error
+	}{
+		// Command too long.
+		{badCommandMsg, pver, btcnet, 0, btcwireErr},
+		// Force error in payload encode.
+		{encodeErrMsg, pver, btcnet, 0, btcwireErr},
+		// Force error due to exceeding max overall message payload size.
+		{exceedOverallPayloadErrMsg, pver, btcnet, 0, btcwireErr},
+		// Force error due to exceeding max payload for message type.
+		{exceedPayloadErrMsg, pver, btcnet, 0, btcwireErr},
+		// Force error in header write.
+		{bogusMsg, pver, btcnet, 0, io.ErrShortWrite},
+		// Force error in payload write.
+		{bogusMsg, pver, btcnet, 24, io.ErrShortWrite},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode wire format.
+		w := newFixedWriter(test.max)
+		err := btcwire.WriteMessage(w, test.msg, test.pver, test.btcnet)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.err) {
+			t.Errorf("WriteMessage #%d wrong error got: %v <%T>, "+
+				"want: %T", i, err, err, test.err)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.err {
+				t.Errorf("ReadMessage #%d wrong error got: %v <%T>, "+
+					"want: %v <%T>", i, err, err,
+					test.err, test.err)
+				continue
+			}
+		}
+	}
+}
+
+// TestReadMessageContext tests the ReadMessageContext API for both readers
+// which support read deadlines and those which do not.
+func TestReadMessageContext(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcnet := btcwire.MainNet
+
+	// Ensure a message is read normally when the context is not done.
+	var buf bytes.Buffer
+	msgPing := btcwire.NewMsgPing(123123)
+	err := btcwire.WriteMessage(&buf, msgPing, pver, btcnet)
+	if err != nil {
+		t.Errorf("WriteMessage: %v", err)
+		return
+	}
+	msg, _, err := btcwire.ReadMessageContext(context.Background(), &buf,
+		pver, btcnet)
+	if err != nil {
+		t.Errorf("ReadMessageContext: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(msg, msgPing) {
+		t.Errorf("ReadMessageContext\n got: %v want: %v",
+			spew.Sdump(msg), spew.Sdump(msgPing))
+	}
+
+	// Ensure an already cancelled context doesn't read anything.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = btcwire.ReadMessageContext(ctx, &buf, pver, btcnet)
+	if err != context.Canceled {
+		t.Errorf("ReadMessageContext: wrong error - got %v, want %v",
+			err, context.Canceled)
+	}
+
+	// Ensure cancelling the context unblocks a reader which does not
+	// support deadlines.
+	pr, pw := io.Pipe()
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, _, err = btcwire.ReadMessageContext(ctx, pr, pver, btcnet)
+	if err != context.Canceled {
+		t.Errorf("ReadMessageContext: wrong error - got %v, want %v",
+			err, context.Canceled)
+	}
+	pw.Close()
+
+	// Ensure the context deadline is applied to readers which support
+	// read deadlines.
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	ctx, cancel = context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	_, _, err = btcwire.ReadMessageContext(ctx, local, pver, btcnet)
+	if err != context.DeadlineExceeded {
+		t.Errorf("ReadMessageContext: wrong error - got %v, want %v",
+			err, context.DeadlineExceeded)
+	}
+
+	// Ensure a protocol error is not hidden behind the context error when
+	// the deadline passes while the message is being read.
+	buf.Reset()
+	err = btcwire.WriteMessage(&buf, msgPing, pver, btcnet)
+	if err != nil {
+		t.Errorf("WriteMessage: %v", err)
+		return
+	}
+	badChecksum := buf.Bytes()
+	badChecksum[20] ^= 0xff
+	slow := &slowDeadlineReader{r: bytes.NewReader(badChecksum),
+		delay: 30 * time.Millisecond}
+	ctx, cancel = context.WithTimeout(context.Background(),
+		10*time.Millisecond)
+	defer cancel()
+	_, _, err = btcwire.ReadMessageContext(ctx, slow, pver, btcnet)
+	msgErr, ok := err.(*btcwire.MessageError)
+	if !ok || msgErr.Code != btcwire.ErrBadChecksum {
+		t.Errorf("ReadMessageContext: wrong error - got %v, want code %v",
+			err, btcwire.ErrBadChecksum)
+	}
+}
+
+// slowDeadlineReader is an io.Reader which supports read deadlines, but
+// ignores them, and delays its first read.  It is used to finish a read after
+// the deadline of a context has passed.
+type slowDeadlineReader struct {
+	r       io.Reader
+	delay   time.Duration
+	delayed bool
+}
+
+// Read reads from the underlying reader after the delay on the first call.
+func (r *slowDeadlineReader) Read(p []byte) (int, error) {
+	if !r.delayed {
+		time.Sleep(r.delay)
+		r.delayed = true
+	}
+	return r.r.Read(p)
+}
+
+// SetReadDeadline ignores the deadline.
+func (r *slowDeadlineReader) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// TestWriteMessageN tests that WriteMessageN reports the number of bytes
+// written, including when only part of the message could be written.
+func TestWriteMessageN(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcnet := btcwire.MainNet
+	msgPing := btcwire.NewMsgPing(123123)
+
+	// Ensure the count matches the bytes written by WriteMessage.
+	var want bytes.Buffer
+	err := btcwire.WriteMessage(&want, msgPing, pver, btcnet)
+	if err != nil {
+		t.Errorf("WriteMessage: %v", err)
+		return
+	}
+	var buf bytes.Buffer
+	n, err := btcwire.WriteMessageN(&buf, msgPing, pver, btcnet)
+	if err != nil {
+		t.Errorf("WriteMessageN: %v", err)
+		return
+	}
+	if n != want.Len() || !bytes.Equal(buf.Bytes(), want.Bytes()) {
+		t.Errorf("WriteMessageN: wrote %d bytes\n got: %s want: %s",
+			n, spew.Sdump(buf.Bytes()), spew.Sdump(want.Bytes()))
+	}
+	buf.Reset()
+	opts := &btcwire.MessageOptions{}
+	n, err = btcwire.WriteMessageWithOptionsN(&buf, msgPing, pver, btcnet,
+		opts)
+	if err != nil {
+		t.Errorf("WriteMessageWithOptionsN: %v", err)
+		return
+	}
+	if n != want.Len() || !bytes.Equal(buf.Bytes(), want.Bytes()) {
+		t.Errorf("WriteMessageWithOptionsN: wrote %d bytes\n got: %s "+
+			"want: %s", n, spew.Sdump(buf.Bytes()),
+			spew.Sdump(want.Bytes()))
+	}
+
+	tests := []struct {
+		max int   // Max size of fixed buffer to induce errors
+		n   int   // Expected number of bytes written
+		err error // Expected write error
+	}{
+		// Force error in header.
+		{0, 0, io.ErrShortWrite},
+		// Force error in payload after the header was written.
+		{24, 24, io.ErrShortWrite},
+		// Enough room for the entire message.
+		{32, 32, nil},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		w := newFixedWriter(test.max)
+		n, err := btcwire.WriteMessageN(w, msgPing, pver, btcnet)
+		if err != test.err {
+			t.Errorf("WriteMessageN #%d wrong error got: %v, want: %v",
+				i, err, test.err)
+			continue
+		}
+		if n != test.n {
+			t.Errorf("WriteMessageN #%d wrong count got: %d, want: %d",
+				i, n, test.n)
+			continue
+		}
+	}
+
+	// Ensure nothing is counted when the message fails to encode.
+	n, err = btcwire.WriteMessageN(&buf, &fakeMessage{command: "toolongcommand"},
+		pver, btcnet)
+	if err == nil || n != 0 {
+		t.Errorf("WriteMessageN: wrong result for bad command - got "+
+			"%d, %v", n, err)
+	}
+}
+
+// TestRoundTrip tests the RoundTrip API accepts messages which re-encode to
+// the exact input and reports where others differ.
+func TestRoundTrip(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcnet := btcwire.MainNet
+
+	// Ensure messages encoded by WriteMessage round trip.
+	msgs := []btcwire.Message{
+		btcwire.NewMsgVerAck(),
+		btcwire.NewMsgPing(123123),
+		baseVersion,
+		&blockOne,
+		witnessTx,
+		blockOneHeaders(),
+	}
+
+	t.Logf("Running %d tests", len(msgs))
+	for i, want := range msgs {
+		var buf bytes.Buffer
+		err := btcwire.WriteMessage(&buf, want, pver, btcnet)
+		if err != nil {
+			t.Errorf("WriteMessage #%d error %v", i, err)
+			continue
+		}
+		msg, err := btcwire.RoundTrip(buf.Bytes(), pver, btcnet)
+		if err != nil {
+			t.Errorf("RoundTrip #%d (%s) error %v", i, want.Command(),
+				err)
+			continue
+		}
+		if msg.Command() != want.Command() {
+			t.Errorf("RoundTrip #%d wrong command - got %v, want %v",
+				i, msg.Command(), want.Command())
+			continue
+		}
+	}
+
+	// Inv message with a non-canonical count, which is normalized when
+	// re-encoded, so the payload length in the header differs first.
+	invVect := append([]byte{0x01, 0x00, 0x00, 0x00}, make([]byte, 32)...)
+	nonCanonicalInv := makeMessage(btcnet, "inv",
+		append([]byte{0xfd, 0x01, 0x00}, invVect...))
+
+	// Ping message followed by unrelated bytes.
+	var pingBuf bytes.Buffer
+	err := btcwire.WriteMessage(&pingBuf, btcwire.NewMsgPing(1), pver, btcnet)
+	if err != nil {
+		t.Fatalf("WriteMessage error %v", err)
+	}
+	trailing := append(pingBuf.Bytes(), 0x00)
+
+	tests := []struct {
+		raw    []byte // Raw message
+		offset string // Expected offset of the first difference
+	}{
+		{nonCanonicalInv, "offset 16 "},
+		{trailing, "offset 32 "},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		msg, err := btcwire.RoundTrip(test.raw, pver, btcnet)
+		msgErr, ok := err.(*btcwire.MessageError)
+		if !ok || msgErr.Code != btcwire.ErrMalformed {
+			t.Errorf("RoundTrip #%d wrong error - got %v, want %v", i,
+				err, btcwire.ErrMalformed)
+			continue
+		}
+		if !strings.Contains(msgErr.Description, test.offset) {
+			t.Errorf("RoundTrip #%d wrong offset - got %q, want %q",
+				i, msgErr.Description, test.offset)
+			continue
+		}
+		if msg == nil {
+			t.Errorf("RoundTrip #%d did not return the decoded "+
+				"message", i)
+			continue
+		}
+	}
+
+	// Ensure read errors are returned as is.
+	_, err = btcwire.RoundTrip(pingBuf.Bytes(), pver, btcwire.TestNet3)
+	if !errors.Is(err, btcwire.ErrWrongNetwork) {
+		t.Errorf("RoundTrip wrong error - got %v, want %v", err,
+			btcwire.ErrWrongNetwork)
+	}
+}
+
+// blockOneHeaders returns a headers message with the header of blockOne.
+func blockOneHeaders() *btcwire.MsgHeaders {
+	hdr := blockOne.Header
+	hdr.TxnCount = 0
+	msg := btcwire.NewMsgHeaders()
+	msg.AddBlockHeader(&hdr)
+	return msg
+}
+
+// TestReadMessageN tests the ReadMessageN API counts every byte read,
+// including on error.
+func TestReadMessageN(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcnet := btcwire.MainNet
+
+	// Ensure the count matches the size of a successfully read message.
+	var buf bytes.Buffer
+	err := btcwire.WriteMessage(&buf, btcwire.NewMsgPing(123123), pver, btcnet)
+	if err != nil {
+		t.Errorf("WriteMessage: %v", err)
+		return
+	}
+	encoded := buf.Bytes()
+
+	// Wrong network with a payload which is read and discarded.
+	wrongNetHdr := makeHeader(0x09bdc3e0, "ping", 10, 0)
+	wrongNet := append(wrongNetHdr, make([]byte, 10)...)
+
+	tests := []struct {
+		buf   []byte // Wire encoding
+		max   int    // Max size of fixed buffer to induce errors
+		n     int    // Expected number of bytes read
+		isErr bool   // Whether or not an error is expected
+	}{
+		// Successful read.
+		{encoded, len(encoded), len(encoded), false},
+		// Short header.
+		{encoded, 10, 10, true},
+		// Short payload.
+		{encoded, 28, 28, true},
+		// Wrong network with the payload discarded.
+		{wrongNet, len(wrongNet), len(wrongNet), true},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		r := newFixedReader(test.max, test.buf)
+		n, _, _, err := btcwire.ReadMessageN(r, pver, btcnet)
+		if (err != nil) != test.isErr {
+			t.Errorf("ReadMessageN #%d wrong error got: %v, want "+
+				"error: %v", i, err, test.isErr)
+			continue
+		}
+		if n != test.n {
+			t.Errorf("ReadMessageN #%d wrong count got: %d, want: %d",
+				i, n, test.n)
+			continue
+		}
+
+		r = newFixedReader(test.max, test.buf)
+		opts := &btcwire.MessageOptions{}
+		n, _, _, err = btcwire.ReadMessageWithOptionsN(r, pver, btcnet,
+			opts)
+		if (err != nil) != test.isErr {
+			t.Errorf("ReadMessageWithOptionsN #%d wrong error got: "+
+				"%v, want error: %v", i, err, test.isErr)
+			continue
+		}
+		if n != test.n {
+			t.Errorf("ReadMessageWithOptionsN #%d wrong count got: "+
+				"%d, want: %d", i, n, test.n)
+			continue
+		}
+	}
+}
+
+// TestMessageSizeBucket tests the MessageSizeBucket API returns the expected
+// labels at the bucket boundaries.
+func TestMessageSizeBucket(t *testing.T) {
+	tests := []struct {
+		n    int    // Message size
+		want string // Expected bucket
+	}{
+		{0, "<1KB"},
+		{1023, "<1KB"},
+		{1024, "1-64KB"},
+		{64*1024 - 1, "1-64KB"},
+		{64 * 1024, "64KB-1MB"},
+		{1024*1024 - 1, "64KB-1MB"},
+		{1024 * 1024, "1-4MB"},
+		{4*1024*1024 - 1, "1-4MB"},
+		{4 * 1024 * 1024, ">=4MB"},
+		{32 * 1024 * 1024, ">=4MB"},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		got := btcwire.MessageSizeBucket(test.n)
+		if got != test.want {
+			t.Errorf("MessageSizeBucket #%d (%d) got: %q, want: %q", i,
+				test.n, got, test.want)
+			continue
+		}
+	}
+}
+
+// TestWriteMessageContext tests the WriteMessageContext API honors the
+// cancellation and deadline of the context.
+func TestWriteMessageContext(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcnet := btcwire.MainNet
+	msgPing := btcwire.NewMsgPing(123123)
+
+	// Ensure a message is written normally when the context is not done.
+	var buf bytes.Buffer
+	n, err := btcwire.WriteMessageContext(context.Background(), &buf,
+		msgPing, pver, btcnet)
+	if err != nil {
+		t.Errorf("WriteMessageContext: %v", err)
+		return
+	}
+	if n != 32 || buf.Len() != 32 {
+		t.Errorf("WriteMessageContext: wrong count - got %d, wrote %d, "+
+			"want %d", n, buf.Len(), 32)
+	}
+
+	// Ensure an already cancelled context doesn't write anything.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	buf.Reset()
+	n, err = btcwire.WriteMessageContext(ctx, &buf, msgPing, pver, btcnet)
+	if err != context.Canceled || n != 0 || buf.Len() != 0 {
+		t.Errorf("WriteMessageContext: wrong result - got %d, %v, "+
+			"want 0, %v", n, err, context.Canceled)
+	}
+
+	// Ensure cancelling the context unblocks a writer which does not
+	// support deadlines.
+	pr, pw := io.Pipe()
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	_, err = btcwire.WriteMessageContext(ctx, pw, msgPing, pver, btcnet)
+	if err != context.Canceled {
+		t.Errorf("WriteMessageContext: wrong error - got %v, want %v",
+			err, context.Canceled)
+	}
+	pr.Close()
+
+	// Ensure the context deadline is applied to writers which support
+	// write deadlines and the count of the partial write is returned.
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	go func() {
+		// Only read the header so the payload write blocks.
+		var hdr [24]byte
+		io.ReadFull(remote, hdr[:])
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(),
+		50*time.Millisecond)
+	defer cancel()
+	n, err = btcwire.WriteMessageContext(ctx, local, msgPing, pver, btcnet)
+	if err != context.DeadlineExceeded {
+		t.Errorf("WriteMessageContext: wrong error - got %v, want %v",
+			err, context.DeadlineExceeded)
+	}
+	if n != 24 {
+		t.Errorf("WriteMessageContext: wrong count - got %d, want %d",
+			n, 24)
+	}
+}
+
+// TestMessageReaderWriter tests the MessageReader and MessageWriter API.
+func TestMessageReaderWriter(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcnet := btcwire.MainNet
+
+	msgs := []btcwire.Message{
+		btcwire.NewMsgVerAck(),
+		btcwire.NewMsgPing(123123),
+		btcwire.NewMsgGetAddr(),
+		&blockOne,
+	}
+
+	// Ensure the writer produces the same bytes as individual calls to
+	// WriteMessage.
+	var want bytes.Buffer
+	for i, msg := range msgs {
+		err := btcwire.WriteMessage(&want, msg, pver, btcnet)
+		if err != nil {
+			t.Errorf("WriteMessage #%d error %v", i, err)
+			return
+		}
+	}
+	var buf bytes.Buffer
+	mw := btcwire.NewMessageWriter(&buf, pver, btcnet)
+	err := mw.Write(msgs[0])
+	if err != nil {
+		t.Errorf("MessageWriter.Write error %v", err)
+		return
+	}
+	err = mw.WriteBatch(msgs[1:]...)
+	if err != nil {
+		t.Errorf("MessageWriter.WriteBatch error %v", err)
+		return
+	}
+	if !bytes.Equal(buf.Bytes(), want.Bytes()) {
+		t.Errorf("MessageWriter\n got: %s want: %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(want.Bytes()))
+		return
+	}
+
+	// Ensure the reader returns all of the messages in order.
+	mr := btcwire.NewMessageReader(&buf, pver, btcnet)
+	for i, want := range msgs {
+		msg, err := mr.Read()
+		if err != nil {
+			t.Errorf("MessageReader.Read #%d error %v", i, err)
+			return
+		}
+		if !reflect.DeepEqual(msg, want) {
+			t.Errorf("MessageReader.Read #%d\n got: %v want: %v", i,
+				spew.Sdump(msg), spew.Sdump(want))
+			return
+		}
+	}
+	if _, err := mr.Read(); err != io.EOF {
+		t.Errorf("MessageReader.Read: wrong error - got %v, want %v",
+			err, io.EOF)
+	}
+
+	// Ensure an updated protocol version is used for subsequent messages.
+	buf.Reset()
+	oldPver := btcwire.BIP0031Version
+	mw.SetProtocolVersion(oldPver)
+	mr.SetProtocolVersion(oldPver)
+	err = mw.Write(btcwire.NewMsgPing(123123))
+	if err != nil {
+		t.Errorf("MessageWriter.Write error %v", err)
+		return
+	}
+	msg, err := mr.Read()
+	if err != nil {
+		t.Errorf("MessageReader.Read error %v", err)
+		return
+	}
+	if ping := msg.(*btcwire.MsgPing); ping.Nonce != 0 {
+		t.Errorf("MessageReader.Read: nonce present for old protocol "+
+			"version %d", oldPver)
+	}
+
+	// Ensure encode errors are returned.
+	err = mw.Write(&fakeMessage{forceEncodeErr: true})
+	if _, ok := err.(*btcwire.MessageError); !ok {
+		t.Errorf("MessageWriter.Write: wrong error - got %v <%T>, "+
+			"want <%T>", err, err, &btcwire.MessageError{})
+	}
+}
+
+// TestMessageReaderWriterN ensures the counting variants of the MessageReader
+// and MessageWriter methods return the size of each message.
+func TestMessageReaderWriterN(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcnet := btcwire.MainNet
+
+	msgs := []btcwire.Message{
+		btcwire.NewMsgVerAck(),
+		btcwire.NewMsgPing(123123),
+		&blockOne,
+	}
+
+	var buf bytes.Buffer
+	mw := btcwire.NewMessageWriter(&buf, pver, btcnet)
+	sizes := make([]int, 0, len(msgs))
+	for i, msg := range msgs {
+		var want bytes.Buffer
+		err := btcwire.WriteMessage(&want, msg, pver, btcnet)
+		if err != nil {
+			t.Errorf("WriteMessage #%d error %v", i, err)
+			return
+		}
+
+		n, err := mw.WriteN(msg)
+		if err != nil {
+			t.Errorf("MessageWriter.WriteN #%d error %v", i, err)
+			return
+		}
+		if n != want.Len() {
+			t.Errorf("MessageWriter.WriteN #%d wrong count got: %d, "+
+				"want: %d", i, n, want.Len())
+			return
+		}
+		sizes = append(sizes, n)
+	}
+
+	mr := btcwire.NewMessageReader(&buf, pver, btcnet)
+	for i, want := range msgs {
+		n, msg, err := mr.ReadN()
+		if err != nil {
+			t.Errorf("MessageReader.ReadN #%d error %v", i, err)
+			return
+		}
+		if n != sizes[i] {
+			t.Errorf("MessageReader.ReadN #%d wrong count got: %d, "+
+				"want: %d", i, n, sizes[i])
+			return
+		}
+		if !reflect.DeepEqual(msg, want) {
+			t.Errorf("MessageReader.ReadN #%d\n got: %v want: %v", i,
+				spew.Sdump(msg), spew.Sdump(want))
+			return
+		}
+	}
+
+	// Ensure encode errors are returned without counting any bytes.
+	n, err := mw.WriteN(&fakeMessage{forceEncodeErr: true})
+	if _, ok := err.(*btcwire.MessageError); !ok || n != 0 {
+		t.Errorf("MessageWriter.WriteN: wrong result - got %d, %v "+
+			"<%T>, want 0, <%T>", n, err, err, &btcwire.MessageError{})
+	}
+}
+
+// TestMessageStringer tests the stringized output for messages.
+func TestMessageStringer(t *testing.T) {
+	blockHash := "00000000839a8e6886ab5951d76f411475428afc90947ee320161bbf18eb6048"
+	genesisHash := btcwire.GenesisHash.String()
+
+	you := &btcwire.NetAddress{IP: net.ParseIP("192.168.0.1"), Port: 8333}
+	me := &btcwire.NetAddress{IP: net.ParseIP("::1"), Port: 18333}
+	msgVersion := btcwire.NewMsgVersion(me, you, 123123, "/test:0.0.1/", 7)
+	msgVersion.Services = btcwire.SFNodeNetwork
+
+	tests := []struct {
+		in   btcwire.Message // Message to stringize
+		want string          // Expected string
+	}{
+		{msgVersion, `version pver=70016 services=SFNodeNetwork ` +
+			`ua="/test:0.0.1/" height=7 me=[::1]:18333 ` +
+			`you=192.168.0.1:8333 norelay=false`},
+		{btcwire.NewMsgVerAck(), "verack"},
+		{btcwire.NewMsgGetAddr(), "getaddr"},
+		{btcwire.NewMsgAddr(), "addr count=0"},
+		{btcwire.NewMsgAddrV2(), "addrv2 count=0"},
+		{btcwire.NewMsgGetBlocks(&btcwire.GenesisHash),
+			"getblocks pver=70016 locators=0 stop=" + genesisHash},
+		{&blockOne, "block hash=" + blockHash + " txns=1"},
+		{multiTx, "tx hash=f051e59b5e2503ac626d03aaeac8ab7be2d72ba4b7e97" +
+			"119c5852d70d52dcb86 in=1 out=1 locktime=0"},
+		{btcwire.NewMsgInv(), "inv count=0"},
+		{btcwire.NewMsgGetData(), "getdata count=0"},
+		{btcwire.NewMsgNotFound(), "notfound count=0"},
+		{btcwire.NewMsgPing(123123), "ping nonce=123123"},
+		{btcwire.NewMsgPong(123123), "pong nonce=123123"},
+		{btcwire.NewMsgGetHeaders(), "getheaders pver=0 locators=0 " +
+			"stop=" + btcwire.ShaHash{}.String()},
+		{btcwire.NewMsgHeaders(), "headers count=0"},
+		{btcwire.NewMsgAlert("payload", "sig"), "alert payload=7 sig=3"},
+		{btcwire.NewMsgMemPool(), "mempool"},
+		{btcwire.NewMsgReject("version", btcwire.RejectObsolete, "old"),
+			`reject cmd=version code=REJECT_OBSOLETE reason="old"`},
+		{btcwire.NewMsgRejectForBlock(&blockOne, btcwire.RejectDuplicate,
+			"duplicate block"), `reject cmd=block ` +
+			`code=REJECT_DUPLICATE reason="duplicate block" hash=` +
+			blockHash},
+		{btcwire.NewMsgGetUTXOs(true), "getutxos mempool=true outpoints=0"},
+		{btcwire.NewMsgUTXOs(5, &btcwire.GenesisHash),
+			"utxos height=5 tip=" + genesisHash + " utxos=0"},
+		{btcwire.NewMsgWTxIDRelay(), "wtxidrelay"},
+		{btcwire.NewMsgSendAddrV2(), "sendaddrv2"},
+		{btcwire.NewMsgSendHeaders(), "sendheaders"},
+		{feeFilter, "feefilter minfee=1000"},
+		{baseFilterLoad, "filterload size=3 hashfuncs=11 tweak=1567574797 " +
+			"flags=BLOOM_UPDATE_P2PUBKEY_ONLY"},
+		{baseFilterAdd, "filteradd size=4"},
+		{btcwire.NewMsgFilterClear(), "filterclear"},
+		{&merkleBlockOne, "merkleblock hash=" + blockHash +
+			" txns=1 hashes=1"},
+		{sendCmpct, "sendcmpct announce=true version=2"},
+		{&cmpctBlockOne, "cmpctblock hash=" + blockHash +
+			" shortids=0 prefilled=1"},
+		{&getBlockTxnOne, "getblocktxn block=" + genesisHash +
+			" indexes=4"},
+		{&blockTxnOne, "blocktxn block=" + genesisHash + " txns=1"},
+		{baseGetCFilters, "getcfilters type=GCSFilterRegular start=1000 " +
+			"stop=" + genesisHash},
+		{baseCFilter, "cfilter type=GCSFilterRegular block=" +
+			genesisHash + " size=4"},
+		{baseGetCFHeaders, "getcfheaders type=GCSFilterRegular " +
+			"start=1000 stop=" + genesisHash},
+		{baseCFHeaders, "cfheaders type=GCSFilterRegular stop=" +
+			genesisHash + " prev=0e3e2357e806b6cdb1f70b54c3a3a17b67" +
+			"14ee1f0e68bebb44a74b1efd512098 hashes=2"},
+		{baseGetCFCheckpt, "getcfcheckpt type=GCSFilterRegular stop=" +
+			genesisHash},
+		{baseCFCheckpt, "cfcheckpt type=GCSFilterRegular stop=" +
+			genesisHash + " headers=2"},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		result := test.in.(fmt.Stringer).String()
+		if result != test.want {
+			t.Errorf("String #%d (%s)\n got: %s want: %s", i,
+				test.in.Command(), result, test.want)
+			continue
+		}
+	}
+}
+
+// TestKnownCommands ensures KnownCommands returns every command ReadMessage
+// decodes in sorted order.
+func TestKnownCommands(t *testing.T) {
+	pver := btcwire.SendCmpctVersion
+	btcnet := btcwire.MainNet
+
+	commands := btcwire.KnownCommands()
+	if !sort.StringsAreSorted(commands) {
+		t.Errorf("KnownCommands: commands are not sorted - got %v",
+			commands)
+	}
+	if len(commands) != 38 {
+		t.Errorf("KnownCommands: wrong number of commands - got %d, "+
+			"want %d", len(commands), 38)
+	}
+
+	t.Logf("Running %d tests", len(commands))
+	for i, command := range commands {
+		// Ensure a message with the command is not rejected as unknown.
+		// Its empty payload may still fail to decode.
+		buf := makeHeader(btcnet, command, 0, 0xe2e0f65d)
+		msg, _, err := btcwire.ReadMessage(bytes.NewReader(buf), pver,
+			btcnet)
+		if msgErr, ok := err.(*btcwire.MessageError); ok &&
+			msgErr.Code == btcwire.ErrUnknownCommand {
+
+			t.Errorf("ReadMessage #%d: command %q is unknown", i,
+				command)
+			continue
+		}
+		if err == nil && msg.Command() != command {
+			t.Errorf("ReadMessage #%d: wrong command - got %v, "+
+				"want %v", i, msg.Command(), command)
+			continue
+		}
+	}
+
+	// Ensure modifying the returned slice doesn't affect later calls.
+	commands[0] = "bogus"
+	if btcwire.KnownCommands()[0] == "bogus" {
+		t.Errorf("KnownCommands: returned slice is shared")
+	}
+}
+
+// TestMaxItemsForCommand tests the MaxItemsForCommand function for various
+// commands.
+func TestMaxItemsForCommand(t *testing.T) {
+	tests := []struct {
+		command string // Command to query
+		want    int    // Expected max items
+	}{
+		{btcwire.NewMsgInv().Command(), 50000},
+		{btcwire.NewMsgGetData().Command(), 50000},
+		{btcwire.NewMsgNotFound().Command(), 50000},
+		{btcwire.NewMsgHeaders().Command(), 2000},
+		{btcwire.NewMsgAddr().Command(), 1000},
+		{btcwire.NewMsgAddrV2().Command(), 1000},
+		{btcwire.NewMsgGetBlocks(&btcwire.ShaHash{}).Command(), 500},
+		{btcwire.NewMsgGetHeaders().Command(), 500},
+		{"getutxos", 100},
+		{btcwire.NewMsgMerkleBlock(&btcwire.BlockHeader{}).Command(), 104858},
+		{btcwire.NewMsgCmpctBlock(&btcwire.BlockHeader{}, 0).Command(), 104858},
+
+		// Commands without a list and unknown commands.
+		{btcwire.NewMsgVerAck().Command(), 0},
+		{btcwire.NewMsgTx().Command(), 0},
+		{"bogus", 0},
+		{"", 0},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		got := btcwire.MaxItemsForCommand(test.command)
+		if got != test.want {
+			t.Errorf("MaxItemsForCommand #%d (%q): got %d, want %d", i,
+				test.command, got, test.want)
+			continue
+		}
+	}
+}
+
+// TestMaxPayloadLengthBounds ensures the max payload length of each message
+// type bounds the encoded size of a maximally-full message of that type.
+// Messages whose max payload length is the overall max message payload, such
+// as tx and reject, are not tested since they are bounded by definition.
+func TestMaxPayloadLengthBounds(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	hash := &btcwire.ShaHash{}
+	ts := time.Unix(0x495fab29, 0)
+	maxServices := btcwire.ServiceFlag(0xffffffffffffffff)
+
+	// Version message with the longest allowed user agent.
+	na := btcwire.NetAddress{
+		Timestamp: ts,
+		Services:  maxServices,
+		IP:        net.ParseIP("2001:db8::1"),
+		Port:      8333,
+	}
+	msgVersion := btcwire.NewMsgVersion(&na, &na, 123123,
+		strings.Repeat("x", btcwire.MaxUserAgentLen), 0)
+
+	// Address messages with the max allowed addresses.
+	msgAddr := btcwire.NewMsgAddr()
+	msgAddrSingle := btcwire.NewMsgAddr()
+	msgAddrSingle.AddAddress(&na)
+	na2 := btcwire.NetAddressV2{
+		Timestamp: ts,
+		Services:  maxServices,
+		NetworkID: 0x99,
+		Addr:      make([]byte, 512),
+		Port:      8333,
+	}
+	msgAddrV2 := btcwire.NewMsgAddrV2()
+	for i := 0; i < btcwire.MaxAddrPerMsg; i++ {
+		msgAddr.AddAddress(&na)
+		msgAddrV2.AddAddress(&na2)
+	}
+
+	// Block locator messages with the max allowed locators.
+	msgGetBlocks := btcwire.NewMsgGetBlocks(hash)
+	msgGetHeaders := btcwire.NewMsgGetHeaders()
+	for i := 0; i < btcwire.MaxBlockLocatorsPerMsg; i++ {
+		msgGetBlocks.AddBlockLocatorHash(hash)
+		msgGetHeaders.AddBlockLocatorHash(hash)
+	}
+
+	// Inventory messages with the max allowed inventory vectors.
+	iv := btcwire.NewInvVect(btcwire.InvVect_Block, hash)
+	msgInv := btcwire.NewMsgInv()
+	msgGetData := btcwire.NewMsgGetData()
+	msgNotFound := btcwire.NewMsgNotFound()
+	for i := 0; i < btcwire.MaxInvPerMsg; i++ {
+		msgInv.AddInvVect(iv)
+		msgGetData.AddInvVect(iv)
+		msgNotFound.AddInvVect(iv)
+	}
+
+	// Headers message with the max allowed headers.
+	msgHeaders := btcwire.NewMsgHeaders()
+	for i := 0; i < btcwire.MaxBlockHeadersPerMsg; i++ {
+		msgHeaders.AddBlockHeader(&btcwire.BlockHeader{Timestamp: ts})
+	}
+
+	// Getutxos message with the max allowed outpoints.
+	msgGetUTXOs := btcwire.NewMsgGetUTXOs(true)
+	for i := 0; i < btcwire.MaxOutPointsPerGetUTXOs; i++ {
+		msgGetUTXOs.AddOutPoint(btcwire.NewOutPoint(hash, 0))
+	}
+
+	// Filterload message with the largest allowed filter.
+	msgFilterLoad, err := btcwire.NewMsgFilterLoad(
+		make([]byte, btcwire.MaxFilterLoadFilterSize),
+		btcwire.MaxFilterLoadHashFuncs, 0, btcwire.BloomUpdateAll)
+	if err != nil {
+		t.Errorf("NewMsgFilterLoad: %v", err)
+		return
+	}
+
+	// Merkle block message with the max allowed transaction hashes and
+	// flag bytes.  A merkle tree has just under 2 nodes per transaction,
+	// so start with 2 flag bits per hash and add flag bytes until the
+	// encode fails.
+	msgMerkleBlock := btcwire.NewMsgMerkleBlock(&btcwire.BlockHeader{})
+	for msgMerkleBlock.AddTxHash(hash) == nil {
+		msgMerkleBlock.Transactions++
+	}
+	msgMerkleBlock.Flags = make([]byte, len(msgMerkleBlock.Hashes)/4)
+	for {
+		msgMerkleBlock.Flags = append(msgMerkleBlock.Flags, 0xff)
+		var buf bytes.Buffer
+		if msgMerkleBlock.BtcEncode(&buf, pver) != nil {
+			flags := msgMerkleBlock.Flags
+			msgMerkleBlock.Flags = flags[:len(flags)-1]
+			break
+		}
+	}
+
+	// Block with witness data which is larger than MaxBlockPayload, but
+	// within the max block weight.
+	witnessSpend := btcwire.NewMsgTx()
+	prevOut := btcwire.NewOutPoint(hash, 0)
+	for i := 0; i < 4; i++ {
+		txIn := btcwire.NewTxIn(prevOut, nil)
+		txIn.Witness = btcwire.TxWitness{make([]byte, 900000)}
+		witnessSpend.AddTxIn(txIn)
+	}
+	witnessSpend.AddTxOut(btcwire.NewTxOut(0, nil))
+	if weight := witnessSpend.Weight(); weight > btcwire.MaxBlockWeight {
+		t.Errorf("witness transaction weight %d exceeds max block "+
+			"weight", weight)
+	}
+	msgBlock := btcwire.NewMsgBlock(&btcwire.BlockHeader{Timestamp: ts})
+	msgBlock.AddTransaction(blockOne.Transactions[0])
+	msgBlock.AddTransaction(witnessSpend)
+
+	tests := []struct {
+		in   btcwire.Message // Maximally-full message
+		pver uint32          // Protocol version for wire encoding
+	}{
+		{msgVersion, pver},
+		{msgVersion, btcwire.BIP0037Version - 1},
+		{btcwire.NewMsgVerAck(), pver},
+		{btcwire.NewMsgGetAddr(), pver},
+		{msgAddr, pver},
+		{msgAddr, btcwire.NetAddressTimeVersion - 1},
+		{msgAddrSingle, btcwire.MultipleAddressVersion - 1},
+		{msgAddrV2, pver},
+		{msgGetBlocks, pver},
+		{msgGetHeaders, pver},
+		{msgInv, pver},
+		{msgGetData, pver},
+		{msgNotFound, pver},
+		{msgHeaders, pver},
+		{btcwire.NewMsgPing(123123), pver},
+		{btcwire.NewMsgPing(123123), btcwire.BIP0031Version},
+		{btcwire.NewMsgPong(123123), pver},
+		{btcwire.NewMsgMemPool(), pver},
+		{msgGetUTXOs, pver},
+		{btcwire.NewMsgWTxIDRelay(), btcwire.WTxIDRelayVersion},
+		{msgFilterLoad, pver},
+		{msgMerkleBlock, pver},
+		{msgBlock, pver},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d (%s) error %v", i,
+				test.in.Command(), err)
+			continue
+		}
+		mpl := test.in.MaxPayloadLength(test.pver)
+		if uint32(buf.Len()) > mpl {
+			t.Errorf("MaxPayloadLength #%d (%s) does not bound the "+
+				"encoded size for protocol version %d - got %d, "+
+				"encoded %d", i, test.in.Command(), test.pver,
+				mpl, buf.Len())
+			continue
 		}
 	}
 }
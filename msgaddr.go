@@ -7,6 +7,8 @@ package btcwire
 import (
 	"fmt"
 	"io"
+	"math/rand"
+	"time"
 )
 
 // MaxAddrPerMsg is the maximum number of addresses that can be in a single
@@ -32,13 +34,23 @@ func (msg *MsgAddr) AddAddress(na *NetAddress) error {
 	if len(msg.AddrList)+1 > MaxAddrPerMsg {
 		str := fmt.Sprintf("too many addresses in message [max %v]",
 			MaxAddrPerMsg)
-		return messageError("MsgAddr.AddAddress", str)
+		return messageError("MsgAddr.AddAddress", ErrTooManyItems, str)
 	}
 
 	msg.AddrList = append(msg.AddrList, na)
 	return nil
 }
 
+// AddClampedAddress adds a known active peer to the message like AddAddress
+// after normalizing its timestamp relative to now with ClampTimestamp, so the
+// message doesn't relay bogus timestamps.  The address is copied before it is
+// clamped and added, so the passed address is not modified.
+func (msg *MsgAddr) AddClampedAddress(na *NetAddress, now time.Time) error {
+	clamped := *na
+	clamped.ClampTimestamp(now)
+	return msg.AddAddress(&clamped)
+}
+
 // AddAddresses adds multiple known active peers to the message.
 func (msg *MsgAddr) AddAddresses(netAddrs ...*NetAddress) error {
 	for _, na := range netAddrs {
@@ -57,6 +69,10 @@ func (msg *MsgAddr) ClearAddresses() {
 
 // BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
 // This is part of the Message interface implementation.
+//
+// The addresses of protocol versions before NetAddressTimeVersion do not
+// include a timestamp, so it is not read and the Timestamp of each decoded
+// address is left as the zero value.
 func (msg *MsgAddr) BtcDecode(r io.Reader, pver uint32) error {
 	count, err := readVarInt(r, pver)
 	if err != nil {
@@ -67,7 +83,7 @@ func (msg *MsgAddr) BtcDecode(r io.Reader, pver uint32) error {
 	if count > MaxAddrPerMsg {
 		str := fmt.Sprintf("too many addresses for message "+
 			"[count %v, max %v]", count, MaxAddrPerMsg)
-		return messageError("MsgAddr.BtcDecode", str)
+		return messageError("MsgAddr.BtcDecode", ErrTooManyItems, str)
 	}
 
 	for i := uint64(0); i < count; i++ {
@@ -90,13 +106,13 @@ func (msg *MsgAddr) BtcEncode(w io.Writer, pver uint32) error {
 	if pver < MultipleAddressVersion && count > 1 {
 		str := fmt.Sprintf("too many addresses for message of "+
 			"protocol version %v [count %v, max 1]", pver, count)
-		return messageError("MsgAddr.BtcEncode", str)
+		return messageError("MsgAddr.BtcEncode", ErrTooManyItems, str)
 
 	}
 	if count > MaxAddrPerMsg {
 		str := fmt.Sprintf("too many addresses for message "+
 			"[count %v, max %v]", count, MaxAddrPerMsg)
-		return messageError("MsgAddr.BtcEncode", str)
+		return messageError("MsgAddr.BtcEncode", ErrTooManyItems, str)
 	}
 
 	err := writeVarInt(w, pver, uint64(count))
@@ -120,16 +136,21 @@ func (msg *MsgAddr) Command() string {
 	return cmdAddr
 }
 
+// String returns the message in human-readable form.
+func (msg *MsgAddr) String() string {
+	return fmt.Sprintf("%s count=%d", msg.Command(), len(msg.AddrList))
+}
+
 // MaxPayloadLength returns the maximum length the payload can be for the
 // receiver.  This is part of the Message interface implementation.
 func (msg *MsgAddr) MaxPayloadLength(pver uint32) uint32 {
 	if pver < MultipleAddressVersion {
 		// Num addresses (varInt) + a single net addresses.
-		return maxVarIntPayload + maxNetAddressPayload(pver)
+		return maxVarIntPayload + maxNetAddressPayload(pver, true)
 	}
 
 	// Num addresses (varInt) + max allowed addresses.
-	return maxVarIntPayload + (MaxAddrPerMsg * maxNetAddressPayload(pver))
+	return maxVarIntPayload + (MaxAddrPerMsg * maxNetAddressPayload(pver, true))
 }
 
 // NewMsgAddr returns a new bitcoin addr message that conforms to the
@@ -137,3 +158,43 @@ func (msg *MsgAddr) MaxPayloadLength(pver uint32) uint32 {
 func NewMsgAddr() *MsgAddr {
 	return &MsgAddr{}
 }
+
+// BuildAddrResponse returns a new addr message in response to a getaddr message
+// (MsgGetAddr) with a random sample of up to max of the passed known addresses.
+// Sampling at random rather than sending the most recently seen addresses
+// avoids leaking information about the connections of the node.  The number
+// of addresses is additionally limited to MaxAddrPerMsg so the message is
+// always valid.
+//
+// The sample is drawn using rng, which allows it to be deterministic in tests,
+// or the default source of the math/rand package when rng is nil.  The passed
+// slice is not modified.
+func BuildAddrResponse(addrs []*NetAddress, max int, rng *rand.Rand) *MsgAddr {
+	if max > MaxAddrPerMsg {
+		max = MaxAddrPerMsg
+	}
+	if max > len(addrs) {
+		max = len(addrs)
+	}
+	if max < 0 {
+		max = 0
+	}
+
+	intn := rand.Intn
+	if rng != nil {
+		intn = rng.Intn
+	}
+
+	// Perform a partial Fisher-Yates shuffle of a copy of the addresses so
+	// the first max entries are a uniformly random sample.
+	sample := make([]*NetAddress, len(addrs))
+	copy(sample, addrs)
+	for i := 0; i < max; i++ {
+		j := i + intn(len(sample)-i)
+		sample[i], sample[j] = sample[j], sample[i]
+	}
+
+	msg := NewMsgAddr()
+	msg.AddrList = sample[:max:max]
+	return msg
+}
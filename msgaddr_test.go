@@ -9,6 +9,7 @@ import (
 	"github.com/conformal/btcwire"
 	"github.com/davecgh/go-spew/spew"
 	"io"
+	"math/rand"
 	"net"
 	"reflect"
 	"testing"
@@ -102,6 +103,53 @@ func TestAddr(t *testing.T) {
 	return
 }
 
+// TestAddrAddClampedAddress tests the MsgAddr AddClampedAddress function
+// clamps timestamps without modifying the passed address and respects the max
+// allowed addresses per message.
+func TestAddrAddClampedAddress(t *testing.T) {
+	now := time.Unix(0x5f5e1000, 0)
+	future := now.Add(time.Hour)
+	na := &btcwire.NetAddress{
+		Timestamp: future,
+		Services:  btcwire.SFNodeNetwork,
+		IP:        net.ParseIP("127.0.0.1"),
+		Port:      8333,
+	}
+
+	// Ensure the added address is clamped and the passed one is not.
+	msg := btcwire.NewMsgAddr()
+	err := msg.AddClampedAddress(na, now)
+	if err != nil {
+		t.Fatalf("AddClampedAddress: %v", err)
+	}
+	if got := msg.AddrList[0].Timestamp; !got.Equal(now) {
+		t.Errorf("AddClampedAddress: wrong timestamp - got %v, want %v",
+			got, now)
+	}
+	if !na.Timestamp.Equal(future) {
+		t.Errorf("AddClampedAddress: passed address was modified - got "+
+			"%v, want %v", na.Timestamp, future)
+	}
+	if !msg.AddrList[0].Equal(na) || msg.AddrList[0].Services != na.Services {
+		t.Errorf("AddClampedAddress: wrong address added - got %v, "+
+			"want %v", spew.Sprint(msg.AddrList[0]), spew.Sprint(na))
+	}
+
+	// Ensure adding more than the max allowed addresses per message returns
+	// error.
+	for i := 1; i < btcwire.MaxAddrPerMsg; i++ {
+		err = msg.AddClampedAddress(na, now)
+		if err != nil {
+			t.Fatalf("AddClampedAddress #%d: %v", i, err)
+		}
+	}
+	err = msg.AddClampedAddress(na, now)
+	if err == nil {
+		t.Errorf("AddClampedAddress: expected error on too many " +
+			"addresses not received")
+	}
+}
+
 // TestAddrWire tests the MsgAddr wire encode and decode for various numbers
 // of addreses and protocol versions.
 func TestAddrWire(t *testing.T) {
@@ -143,6 +191,27 @@ func TestAddrWire(t *testing.T) {
 
 	}
 
+	// Address message with multiple addresses as decoded from protocol
+	// versions before NetAddressTimeVersion which have no timestamps.
+	naNoTS, na2NoTS := *na, *na2
+	naNoTS.Timestamp = time.Time{}
+	na2NoTS.Timestamp = time.Time{}
+	multiAddrNoTS := btcwire.NewMsgAddr()
+	multiAddrNoTS.AddAddresses(&naNoTS, &na2NoTS)
+	multiAddrNoTSEncoded := []byte{
+		0x02, // Varint for number of addresses
+		// No timestamp
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // SFNodeNetwork
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0xff, 0xff, 0x7f, 0x00, 0x00, 0x01, // IP 127.0.0.1
+		0x20, 0x8d, // Port 8333 in big-endian
+		// No timestamp
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // SFNodeNetwork
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0xff, 0xff, 0xc0, 0xa8, 0x00, 0x01, // IP 192.168.0.1
+		0x20, 0x8e, // Port 8334 in big-endian
+	}
+
 	tests := []struct {
 		in   *btcwire.MsgAddr // Message to encode
 		out  *btcwire.MsgAddr // Expected decoded message
@@ -165,6 +234,16 @@ func TestAddrWire(t *testing.T) {
 			btcwire.ProtocolVersion,
 		},
 
+		// Protocol version NetAddressTimeVersion-1 with multiple
+		// addresses.  The addresses don't include timestamps, so they
+		// are decoded with zero timestamps.
+		{
+			multiAddr,
+			multiAddrNoTS,
+			multiAddrNoTSEncoded,
+			btcwire.NetAddressTimeVersion - 1,
+		},
+
 		// Protocol version MultipleAddressVersion-1 with no addresses.
 		{
 			noAddr,
@@ -318,3 +397,96 @@ func TestAddrWireErrors(t *testing.T) {
 
 	}
 }
+
+// TestBuildAddrResponse tests the BuildAddrResponse function for various sample
+// sizes.
+func TestBuildAddrResponse(t *testing.T) {
+	// Create a set of known addresses.
+	addrs := make([]*btcwire.NetAddress, 0, btcwire.MaxAddrPerMsg+500)
+	for i := 0; i < cap(addrs); i++ {
+		addrs = append(addrs, &btcwire.NetAddress{
+			Timestamp: time.Unix(0x495fab29, 0), // 2009-01-03 12:15:05 -0600 CST
+			Services:  btcwire.SFNodeNetwork,
+			IP:        net.IPv4(10, 0, byte(i>>8), byte(i)),
+			Port:      8333,
+		})
+	}
+	orig := append([]*btcwire.NetAddress{}, addrs...)
+
+	tests := []struct {
+		addrs []*btcwire.NetAddress // Known addresses
+		max   int                   // Max addresses to sample
+		want  int                   // Expected number of addresses
+	}{
+		{addrs[:10], 5, 5},
+		{addrs[:10], 10, 10},
+		{addrs[:10], 20, 10},
+		{addrs[:10], 0, 0},
+		{addrs[:10], -1, 0},
+		{nil, 5, 0},
+		{addrs, btcwire.MaxAddrPerMsg + 100, btcwire.MaxAddrPerMsg},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		msg := btcwire.BuildAddrResponse(test.addrs, test.max,
+			rand.New(rand.NewSource(int64(i))))
+		if len(msg.AddrList) != test.want {
+			t.Errorf("BuildAddrResponse #%d: wrong number of "+
+				"addresses - got %d, want %d", i,
+				len(msg.AddrList), test.want)
+			continue
+		}
+
+		// Ensure the sample only contains distinct known addresses.
+		known := make(map[*btcwire.NetAddress]bool)
+		for _, na := range test.addrs {
+			known[na] = true
+		}
+		seen := make(map[*btcwire.NetAddress]bool)
+		for _, na := range msg.AddrList {
+			if !known[na] || seen[na] {
+				t.Errorf("BuildAddrResponse #%d: unexpected "+
+					"address %v", i, spew.Sdump(na))
+				break
+			}
+			seen[na] = true
+		}
+
+		// Ensure the same source produces the same sample.
+		again := btcwire.BuildAddrResponse(test.addrs, test.max,
+			rand.New(rand.NewSource(int64(i))))
+		if !reflect.DeepEqual(again, msg) {
+			t.Errorf("BuildAddrResponse #%d: sample is not "+
+				"deterministic - got %v, want %v", i,
+				spew.Sdump(again), spew.Sdump(msg))
+			continue
+		}
+
+		// Ensure the message can be encoded.
+		var buf bytes.Buffer
+		err := msg.BtcEncode(&buf, btcwire.ProtocolVersion)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+	}
+
+	// Ensure the sample is not simply the first addresses.
+	msg := btcwire.BuildAddrResponse(addrs, 10, rand.New(rand.NewSource(1)))
+	if reflect.DeepEqual(msg.AddrList, addrs[:10]) {
+		t.Errorf("BuildAddrResponse: sample is not random")
+	}
+
+	// Ensure a nil source may be used.
+	msg = btcwire.BuildAddrResponse(addrs, 10, nil)
+	if len(msg.AddrList) != 10 {
+		t.Errorf("BuildAddrResponse: wrong number of addresses with "+
+			"nil source - got %d, want %d", len(msg.AddrList), 10)
+	}
+
+	// Ensure the passed addresses are not modified.
+	if !reflect.DeepEqual(addrs, orig) {
+		t.Errorf("BuildAddrResponse: known addresses were modified")
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"fmt"
+	"io"
+)
+
+// MsgAddrV2 implements the Message interface and represents a bitcoin addrv2
+// message as defined by BIP0155.  It is the same as the addr message (MsgAddr)
+// except the addresses are NetAddressV2 which allows addresses of networks
+// such as Tor v3 and I2P to be relayed.  It must only be sent to peers which
+// signalled support for it.  Each message is limited to a maximum number of
+// addresses, which is currently 1000.
+//
+// Use the AddAddress function to build up the list of known addresses when
+// sending an addrv2 message to another peer.
+type MsgAddrV2 struct {
+	AddrList []*NetAddressV2
+}
+
+// AddAddress adds a known active peer to the message.
+func (msg *MsgAddrV2) AddAddress(na *NetAddressV2) error {
+	if len(msg.AddrList)+1 > MaxAddrPerMsg {
+		str := fmt.Sprintf("too many addresses in message [max %v]",
+			MaxAddrPerMsg)
+		return messageError("MsgAddrV2.AddAddress", ErrTooManyItems, str)
+	}
+
+	msg.AddrList = append(msg.AddrList, na)
+	return nil
+}
+
+// AddAddresses adds multiple known active peers to the message.
+func (msg *MsgAddrV2) AddAddresses(netAddrs ...*NetAddressV2) error {
+	for _, na := range netAddrs {
+		err := msg.AddAddress(na)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClearAddresses removes all addresses from the message.
+func (msg *MsgAddrV2) ClearAddresses() {
+	msg.AddrList = []*NetAddressV2{}
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgAddrV2) BtcDecode(r io.Reader, pver uint32) error {
+	count, err := readVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+
+	// Limit to max addresses per message.
+	if count > MaxAddrPerMsg {
+		str := fmt.Sprintf("too many addresses for message "+
+			"[count %v, max %v]", count, MaxAddrPerMsg)
+		return messageError("MsgAddrV2.BtcDecode", ErrTooManyItems, str)
+	}
+
+	for i := uint64(0); i < count; i++ {
+		na := NetAddressV2{}
+		err := readNetAddressV2(r, pver, &na)
+		if err != nil {
+			return err
+		}
+		msg.AddAddress(&na)
+	}
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgAddrV2) BtcEncode(w io.Writer, pver uint32) error {
+	count := len(msg.AddrList)
+	if count > MaxAddrPerMsg {
+		str := fmt.Sprintf("too many addresses for message "+
+			"[count %v, max %v]", count, MaxAddrPerMsg)
+		return messageError("MsgAddrV2.BtcEncode", ErrTooManyItems, str)
+	}
+
+	err := writeVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+
+	for _, na := range msg.AddrList {
+		err = writeNetAddressV2(w, pver, na)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgAddrV2) Command() string {
+	return cmdAddrV2
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgAddrV2) String() string {
+	return fmt.Sprintf("%s count=%d", msg.Command(), len(msg.AddrList))
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgAddrV2) MaxPayloadLength(pver uint32) uint32 {
+	// Num addresses (varInt) + max allowed addresses.
+	return maxVarIntPayload + (MaxAddrPerMsg * maxNetAddressV2Payload)
+}
+
+// NewMsgAddrV2 returns a new bitcoin addrv2 message that conforms to the
+// Message interface.  See MsgAddrV2 for details.
+func NewMsgAddrV2() *MsgAddrV2 {
+	return &MsgAddrV2{}
+}
+
+// FilterAddrsForPeer partitions the passed addresses into the messages used to
+// relay them to a peer depending on whether or not it supports addrv2 as
+// signalled by supportsV2.  Peers which support it are sent all of the
+// addresses in an addrv2 message.  All other peers are sent a legacy addr
+// message with the addresses converted to NetAddress, omitting those which
+// can't be represented, such as Tor v3 addresses.  The number of omitted
+// addresses is returned as well.
+//
+// The addresses are not limited to MaxAddrPerMsg, so the caller must not pass
+// more addresses than that for the messages to be valid.
+func FilterAddrsForPeer(addrs []*NetAddressV2, supportsV2 bool) (MsgAddrV2, MsgAddr, int) {
+	var addrV2Msg MsgAddrV2
+	var addrMsg MsgAddr
+	if supportsV2 {
+		addrV2Msg.AddrList = append(addrV2Msg.AddrList, addrs...)
+		return addrV2Msg, addrMsg, 0
+	}
+
+	var omitted int
+	for _, na := range addrs {
+		legacy, ok := na.ToLegacy()
+		if !ok {
+			omitted++
+			continue
+		}
+		addrMsg.AddrList = append(addrMsg.AddrList, legacy)
+	}
+	return addrV2Msg, addrMsg, omitted
+}
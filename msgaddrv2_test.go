@@ -0,0 +1,297 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestAddrV2 tests the MsgAddrV2 API.
+func TestAddrV2(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "addrv2"
+	msg := btcwire.NewMsgAddrV2()
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgAddrV2: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Num addresses (varInt) + max allowed addresses.
+	wantPayload := uint32(531009)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure NetAddressV2s are added properly.
+	na := &torV3NetAddrV2
+	err := msg.AddAddress(na)
+	if err != nil {
+		t.Errorf("AddAddress: %v", err)
+	}
+	if msg.AddrList[0] != na {
+		t.Errorf("AddAddress: wrong address added - got %v, want %v",
+			spew.Sprint(msg.AddrList[0]), spew.Sprint(na))
+	}
+
+	// Ensure the address list is cleared properly.
+	msg.ClearAddresses()
+	if len(msg.AddrList) != 0 {
+		t.Errorf("ClearAddresses: address list is not empty - "+
+			"got %v [%v], want %v", len(msg.AddrList),
+			spew.Sprint(msg.AddrList[0]), 0)
+	}
+
+	// Ensure adding more than the max allowed addresses per message returns
+	// error.
+	for i := 0; i < btcwire.MaxAddrPerMsg+1; i++ {
+		err = msg.AddAddress(na)
+	}
+	if err == nil {
+		t.Errorf("AddAddress: expected error on too many addresses " +
+			"not received")
+	}
+	err = msg.AddAddresses(na)
+	if err == nil {
+		t.Errorf("AddAddresses: expected error on too many addresses " +
+			"not received")
+	}
+
+	return
+}
+
+// TestAddrV2Wire tests the MsgAddrV2 wire encode and decode for various
+// numbers of addresses.
+func TestAddrV2Wire(t *testing.T) {
+	// Empty address message.
+	noAddr := btcwire.NewMsgAddrV2()
+	noAddrEncoded := []byte{
+		0x00, // Varint for number of addresses
+	}
+
+	// Address message with multiple addresses.
+	multiAddr := btcwire.NewMsgAddrV2()
+	multiAddr.AddAddresses(&baseNetAddrV2, &torV3NetAddrV2)
+	multiAddrEncoded := []byte{0x02} // Varint for number of addresses
+	multiAddrEncoded = append(multiAddrEncoded, baseNetAddrV2Encoded...)
+	multiAddrEncoded = append(multiAddrEncoded, torV3NetAddrV2Encoded...)
+
+	tests := []struct {
+		in   *btcwire.MsgAddrV2 // Message to encode
+		out  *btcwire.MsgAddrV2 // Expected decoded message
+		buf  []byte             // Wire encoding
+		pver uint32             // Protocol version for wire encoding
+	}{
+		// Latest protocol version with no addresses.
+		{
+			noAddr,
+			noAddr,
+			noAddrEncoded,
+			btcwire.ProtocolVersion,
+		},
+
+		// Latest protocol version with multiple addresses.
+		{
+			multiAddr,
+			multiAddr,
+			multiAddrEncoded,
+			btcwire.ProtocolVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg btcwire.MsgAddrV2
+		rbuf := bytes.NewBuffer(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestAddrV2WireErrors performs negative tests against wire encode and decode
+// of MsgAddrV2 to confirm error paths work correctly.
+func TestAddrV2WireErrors(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcwireErr := &btcwire.MessageError{}
+
+	// Address message with multiple addresses.
+	baseAddr := btcwire.NewMsgAddrV2()
+	baseAddr.AddAddresses(&baseNetAddrV2, &torV3NetAddrV2)
+	baseAddrEncoded := []byte{0x02} // Varint for number of addresses
+	baseAddrEncoded = append(baseAddrEncoded, baseNetAddrV2Encoded...)
+	baseAddrEncoded = append(baseAddrEncoded, torV3NetAddrV2Encoded...)
+
+	// Message that forces an error by having more than the max allowed
+	// addresses.
+	maxAddr := btcwire.NewMsgAddrV2()
+	for i := 0; i < btcwire.MaxAddrPerMsg; i++ {
+		maxAddr.AddAddress(&baseNetAddrV2)
+	}
+	maxAddr.AddrList = append(maxAddr.AddrList, &baseNetAddrV2)
+	maxAddrEncoded := []byte{
+		0xfd, 0x03, 0xe9, // Varint for number of addresses (1001)
+	}
+
+	tests := []struct {
+		in       *btcwire.MsgAddrV2 // Value to encode
+		buf      []byte             // Wire encoding
+		pver     uint32             // Protocol version for wire encoding
+		max      int                // Max size of fixed buffer to induce errors
+		writeErr error              // Expected write error
+		readErr  error              // Expected read error
+	}{
+		// Latest protocol version with intentional read/write errors.
+		// Force error in addresses count
+		{baseAddr, baseAddrEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in first address.
+		{baseAddr, baseAddrEncoded, pver, 1, io.ErrShortWrite, io.EOF},
+		// Force error in second address.
+		{baseAddr, baseAddrEncoded, pver, 14, io.ErrShortWrite, io.EOF},
+		// Force error with greater than max addresses.
+		{maxAddr, maxAddrEncoded, pver, 3, btcwireErr, btcwireErr},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg btcwire.MsgAddrV2
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
+
+// TestFilterAddrsForPeer ensures addresses are partitioned into the expected
+// messages depending on whether or not the peer supports addrv2.
+func TestFilterAddrsForPeer(t *testing.T) {
+	ts := time.Unix(0x495fab29, 0) // 2009-01-03 12:15:05 -0600 CST
+	ipv6Addr := &btcwire.NetAddressV2{
+		Timestamp: ts,
+		Services:  btcwire.SFNodeNetwork,
+		NetworkID: btcwire.AddrNetIPv6,
+		Addr:      []byte(net.ParseIP("2001:db8::1")),
+		Port:      8334,
+	}
+	i2pAddr := &btcwire.NetAddressV2{
+		Timestamp: ts,
+		Services:  btcwire.SFNodeNetwork,
+		NetworkID: btcwire.AddrNetI2P,
+		Addr:      make([]byte, 32),
+		Port:      0,
+	}
+	addrs := []*btcwire.NetAddressV2{
+		&baseNetAddrV2, &torV3NetAddrV2, ipv6Addr, i2pAddr,
+	}
+
+	// Ensure all addresses are sent to peers which support addrv2.
+	addrV2Msg, addrMsg, omitted := btcwire.FilterAddrsForPeer(addrs, true)
+	if !reflect.DeepEqual(addrV2Msg.AddrList, addrs) {
+		t.Errorf("FilterAddrsForPeer: wrong addrv2 addresses - got %v, "+
+			"want %v", spew.Sdump(addrV2Msg.AddrList), spew.Sdump(addrs))
+	}
+	if len(addrMsg.AddrList) != 0 || omitted != 0 {
+		t.Errorf("FilterAddrsForPeer: unexpected addr addresses %d "+
+			"and omitted %d for addrv2 peer", len(addrMsg.AddrList),
+			omitted)
+	}
+
+	// Ensure only the addresses which can be represented are sent to
+	// legacy peers.
+	wantLegacy := []*btcwire.NetAddress{
+		{
+			Timestamp: ts,
+			Services:  btcwire.SFNodeNetwork,
+			IP:        net.ParseIP("127.0.0.1"),
+			Port:      8333,
+		},
+		{
+			Timestamp: ts,
+			Services:  btcwire.SFNodeNetwork,
+			IP:        net.ParseIP("2001:db8::1"),
+			Port:      8334,
+		},
+	}
+	addrV2Msg, addrMsg, omitted = btcwire.FilterAddrsForPeer(addrs, false)
+	if len(addrV2Msg.AddrList) != 0 {
+		t.Errorf("FilterAddrsForPeer: unexpected addrv2 addresses %d "+
+			"for legacy peer", len(addrV2Msg.AddrList))
+	}
+	if !reflect.DeepEqual(addrMsg.AddrList, wantLegacy) {
+		t.Errorf("FilterAddrsForPeer: wrong addr addresses - got %v, "+
+			"want %v", spew.Sdump(addrMsg.AddrList),
+			spew.Sdump(wantLegacy))
+	}
+	if omitted != 2 {
+		t.Errorf("FilterAddrsForPeer: wrong number of omitted "+
+			"addresses - got %d, want %d", omitted, 2)
+	}
+}
@@ -5,15 +5,231 @@
 package btcwire
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
 	"io"
+	"unsafe"
 )
 
-// MsgAlert  implements the Message interface and defines a bitcoin alert
+// maxAlertSetCancel is the maximum number of alert IDs which can be cancelled
+// by a single alert.  Each ID is 4 bytes, so this is the most which could fit
+// in a message.
+const maxAlertSetCancel = maxMessagePayload / 4
+
+// maxAlertSetSubVer is the maximum number of user agents an alert can be
+// restricted to.  Each user agent takes at least 1 byte for its length, so
+// this is the most which could fit in a message.
+const maxAlertSetSubVer = maxMessagePayload
+
+// Alert contains the data deserialized from the payload of an alert message
+// (MsgAlert.PayloadBlob).
+type Alert struct {
+	// Alert format version.
+	Version int32
+
+	// Timestamp beyond which nodes should stop relaying the alert.
+	RelayUntil int64
+
+	// Timestamp beyond which the alert is no longer in effect and should be
+	// ignored.
+	Expiration int64
+
+	// Unique ID number for the alert.
+	ID int32
+
+	// All alerts with an ID less than or equal to this number should be
+	// cancelled.
+	Cancel int32
+
+	// All alert IDs contained in this set should be cancelled as above.
+	SetCancel []int32
+
+	// The alert only applies to versions greater than or equal to this
+	// version.
+	MinVer int32
+
+	// The alert only applies to versions less than or equal to this
+	// version.
+	MaxVer int32
+
+	// The alert only applies to the user agents contained in this set.  An
+	// empty set applies to all user agents.
+	SetSubVer []string
+
+	// Relative priority compared to other alerts.
+	Priority int32
+
+	// A comment on the alert that is not displayed.
+	Comment string
+
+	// The alert message that is displayed to the user.
+	StatusBar string
+
+	// Reserved for future use.
+	Reserved string
+}
+
+// Deserialize decodes the alert payload from r into the receiver using the
+// format used by bitcoind for the inner payload of alert messages.
+func (alert *Alert) Deserialize(r io.Reader, pver uint32) error {
+	err := readElements(r, &alert.Version, &alert.RelayUntil,
+		&alert.Expiration, &alert.ID, &alert.Cancel)
+	if err != nil {
+		return err
+	}
+
+	count, err := readVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > maxAlertSetCancel {
+		str := fmt.Sprintf("too many cancel alert IDs [count %v, "+
+			"max %v]", count, maxAlertSetCancel)
+		return messageError("Alert.Deserialize", ErrTooManyItems, str)
+	}
+	alert.SetCancel = make([]int32, 0, preallocCount(count, 4))
+	for i := uint64(0); i < count; i++ {
+		var id int32
+		err := readElement(r, &id)
+		if err != nil {
+			return err
+		}
+		alert.SetCancel = append(alert.SetCancel, id)
+	}
+
+	err = readElements(r, &alert.MinVer, &alert.MaxVer)
+	if err != nil {
+		return err
+	}
+
+	count, err = readVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > maxAlertSetSubVer {
+		str := fmt.Sprintf("too many alert user agents [count %v, "+
+			"max %v]", count, maxAlertSetSubVer)
+		return messageError("Alert.Deserialize", ErrTooManyItems, str)
+	}
+	alert.SetSubVer = make([]string, 0, preallocCount(count,
+		unsafe.Sizeof("")))
+	for i := uint64(0); i < count; i++ {
+		subVer, err := readVarString(r, pver)
+		if err != nil {
+			return err
+		}
+		alert.SetSubVer = append(alert.SetSubVer, subVer)
+	}
+
+	err = readElement(r, &alert.Priority)
+	if err != nil {
+		return err
+	}
+	alert.Comment, err = readVarString(r, pver)
+	if err != nil {
+		return err
+	}
+	alert.StatusBar, err = readVarString(r, pver)
+	if err != nil {
+		return err
+	}
+	alert.Reserved, err = readVarString(r, pver)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Serialize encodes the receiver to w using the format used by bitcoind for
+// the inner payload of alert messages.
+func (alert *Alert) Serialize(w io.Writer, pver uint32) error {
+	if count := len(alert.SetCancel); count > maxAlertSetCancel {
+		str := fmt.Sprintf("too many cancel alert IDs [count %v, "+
+			"max %v]", count, maxAlertSetCancel)
+		return messageError("Alert.Serialize", ErrTooManyItems, str)
+	}
+	if count := len(alert.SetSubVer); count > maxAlertSetSubVer {
+		str := fmt.Sprintf("too many alert user agents [count %v, "+
+			"max %v]", count, maxAlertSetSubVer)
+		return messageError("Alert.Serialize", ErrTooManyItems, str)
+	}
+
+	err := writeElements(w, alert.Version, alert.RelayUntil,
+		alert.Expiration, alert.ID, alert.Cancel)
+	if err != nil {
+		return err
+	}
+
+	err = writeVarInt(w, pver, uint64(len(alert.SetCancel)))
+	if err != nil {
+		return err
+	}
+	for _, id := range alert.SetCancel {
+		err = writeElement(w, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeElements(w, alert.MinVer, alert.MaxVer)
+	if err != nil {
+		return err
+	}
+
+	err = writeVarInt(w, pver, uint64(len(alert.SetSubVer)))
+	if err != nil {
+		return err
+	}
+	for _, subVer := range alert.SetSubVer {
+		err = writeVarString(w, pver, subVer)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeElement(w, alert.Priority)
+	if err != nil {
+		return err
+	}
+	err = writeVarString(w, pver, alert.Comment)
+	if err != nil {
+		return err
+	}
+	err = writeVarString(w, pver, alert.StatusBar)
+	if err != nil {
+		return err
+	}
+	err = writeVarString(w, pver, alert.Reserved)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeserializeAlert decodes the serialized inner payload of an alert message,
+// such as MsgAlert.PayloadBlob, into a new Alert.
+func DeserializeAlert(payload []byte) (*Alert, error) {
+	var alert Alert
+	err := alert.Deserialize(bytes.NewReader(payload), ProtocolVersion)
+	if err != nil {
+		return nil, err
+	}
+	return &alert, nil
+}
+
+// MsgAlert implements the Message interface and defines a bitcoin alert
 // message.
 //
 // This is a signed message that provides notifications that the client should
 // display if the signature matches the key.  bitcoind/bitcoin-qt only checks
 // against a signature from the core developers.
+//
+// The payload is carried as-is so it can be relayed and have its signature
+// verified exactly as received.  Use DeserializeAlert to parse it into an
+// Alert.
 type MsgAlert struct {
 	// PayloadBlob is the alert payload serialized as a string so that the
 	// version can change but the Alert can still be passed on by older
@@ -62,6 +278,42 @@ func (msg *MsgAlert) Command() string {
 	return cmdAlert
 }
 
+// String returns the message in human-readable form.
+func (msg *MsgAlert) String() string {
+	return fmt.Sprintf("%s payload=%d sig=%d", msg.Command(),
+		len(msg.PayloadBlob), len(msg.Signature))
+}
+
+// alertJSON is the JSON encoding of a MsgAlert.  The payload and signature are
+// arbitrary bytes, so they are encoded as byte slices rather than strings which
+// JSON requires to be valid UTF-8.
+type alertJSON struct {
+	PayloadBlob []byte
+	Signature   []byte
+}
+
+// MarshalJSON encodes the alert to JSON with its payload and signature base64
+// encoded.  This is part of the json.Marshaler interface implementation.
+func (msg MsgAlert) MarshalJSON() ([]byte, error) {
+	return json.Marshal(alertJSON{
+		PayloadBlob: []byte(msg.PayloadBlob),
+		Signature:   []byte(msg.Signature),
+	})
+}
+
+// UnmarshalJSON decodes an alert encoded by MarshalJSON into the receiver.
+// This is part of the json.Unmarshaler interface implementation.
+func (msg *MsgAlert) UnmarshalJSON(b []byte) error {
+	var aj alertJSON
+	err := json.Unmarshal(b, &aj)
+	if err != nil {
+		return err
+	}
+	msg.PayloadBlob = string(aj.PayloadBlob)
+	msg.Signature = string(aj.Signature)
+	return nil
+}
+
 // MaxPayloadLength returns the maximum length the payload can be for the
 // receiver.  This is part of the Message interface implementation.
 func (msg *MsgAlert) MaxPayloadLength(pver uint32) uint32 {
@@ -213,3 +213,167 @@ func TestAlertWireErrors(t *testing.T) {
 		}
 	}
 }
+
+// TestAlertPayload tests the Alert serialize and deserialize of the inner
+// payload of an alert message.
+func TestAlertPayload(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	// Ensure the deserialized payload matches the expected alert.
+	alert, err := btcwire.DeserializeAlert(baseAlertPayloadEncoded)
+	if err != nil {
+		t.Errorf("DeserializeAlert: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(alert, baseAlertPayload) {
+		t.Errorf("DeserializeAlert\n got: %s want: %s",
+			spew.Sdump(alert), spew.Sdump(baseAlertPayload))
+	}
+
+	// Ensure the payload of a full alert message can be parsed.
+	msg := btcwire.NewMsgAlert(string(baseAlertPayloadEncoded), "sig")
+	alert, err = btcwire.DeserializeAlert([]byte(msg.PayloadBlob))
+	if err != nil {
+		t.Errorf("DeserializeAlert: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(alert, baseAlertPayload) {
+		t.Errorf("DeserializeAlert\n got: %s want: %s",
+			spew.Sdump(alert), spew.Sdump(baseAlertPayload))
+	}
+
+	// Ensure serializing the alert produces the expected bytes.
+	var buf bytes.Buffer
+	err = baseAlertPayload.Serialize(&buf, pver)
+	if err != nil {
+		t.Errorf("Serialize: %v", err)
+		return
+	}
+	if !bytes.Equal(buf.Bytes(), baseAlertPayloadEncoded) {
+		t.Errorf("Serialize\n got: %s want: %s",
+			spew.Sdump(buf.Bytes()),
+			spew.Sdump(baseAlertPayloadEncoded))
+	}
+}
+
+// TestAlertPayloadErrors performs negative tests against the Alert serialize
+// and deserialize of the inner payload of an alert message to confirm error
+// paths work correctly.
+func TestAlertPayloadErrors(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	tests := []struct {
+		max      int   // Max size of fixed buffer to induce errors
+		writeErr error // Expected write error
+		readErr  error // Expected read error
+	}{
+		// Force error in version.
+		{0, io.ErrShortWrite, io.EOF},
+		// Force error in relay until.
+		{4, io.ErrShortWrite, io.EOF},
+		// Force error in expiration.
+		{12, io.ErrShortWrite, io.EOF},
+		// Force error in ID.
+		{20, io.ErrShortWrite, io.EOF},
+		// Force error in cancel.
+		{24, io.ErrShortWrite, io.EOF},
+		// Force error in number of cancel IDs.
+		{28, io.ErrShortWrite, io.EOF},
+		// Force error in cancel IDs.
+		{29, io.ErrShortWrite, io.EOF},
+		// Force error in min version.
+		{33, io.ErrShortWrite, io.EOF},
+		// Force error in max version.
+		{37, io.ErrShortWrite, io.EOF},
+		// Force error in number of user agents.
+		{41, io.ErrShortWrite, io.EOF},
+		// Force error in user agents.
+		{42, io.ErrShortWrite, io.EOF},
+		// Force error in priority.
+		{58, io.ErrShortWrite, io.EOF},
+		// Force error in comment.
+		{62, io.ErrShortWrite, io.EOF},
+		// Force error in status bar.
+		{63, io.ErrShortWrite, io.EOF},
+		// Force error in reserved.
+		{70, io.ErrShortWrite, io.EOF},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := baseAlertPayload.Serialize(w, pver)
+		if err != test.writeErr {
+			t.Errorf("Serialize #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// Decode from wire format.
+		var alert btcwire.Alert
+		r := newFixedReader(test.max, baseAlertPayloadEncoded)
+		err = alert.Deserialize(r, pver)
+		if err != test.readErr {
+			t.Errorf("Deserialize #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// Ensure the truncated payload fails to deserialize.
+		_, err = btcwire.DeserializeAlert(baseAlertPayloadEncoded[:test.max])
+		if err == nil {
+			t.Errorf("DeserializeAlert #%d did not receive expected "+
+				"error", i)
+			continue
+		}
+	}
+
+	// Ensure a cancel ID count which exceeds what could fit in a message
+	// is rejected.
+	tooManyCancel := make([]byte, 33)
+	copy(tooManyCancel, baseAlertPayloadEncoded[:28])
+	copy(tooManyCancel[28:], []byte{0xfe, 0x01, 0x00, 0x00, 0x02})
+	_, err := btcwire.DeserializeAlert(tooManyCancel)
+	if _, ok := err.(*btcwire.MessageError); !ok {
+		t.Errorf("DeserializeAlert: wrong error got: %v <%T>, want: "+
+			"<*btcwire.MessageError>", err, err)
+	}
+}
+
+// baseAlertPayload is used in the various tests as a baseline Alert.
+var baseAlertPayload = &btcwire.Alert{
+	Version:    1,
+	RelayUntil: 1329620535,
+	Expiration: 1329792435,
+	ID:         1010,
+	Cancel:     1009,
+	SetCancel:  []int32{1008},
+	MinVer:     10000,
+	MaxVer:     61000,
+	SetSubVer:  []string{"/Satoshi:0.7.2/"},
+	Priority:   100,
+	Comment:    "",
+	StatusBar:  "URGENT",
+	Reserved:   "",
+}
+
+// baseAlertPayloadEncoded is the serialized bytes for baseAlertPayload.
+var baseAlertPayloadEncoded = []byte{
+	0x01, 0x00, 0x00, 0x00, // Version
+	0x37, 0x66, 0x40, 0x4f, 0x00, 0x00, 0x00, 0x00, // RelayUntil
+	0xb3, 0x05, 0x43, 0x4f, 0x00, 0x00, 0x00, 0x00, // Expiration
+	0xf2, 0x03, 0x00, 0x00, // ID
+	0xf1, 0x03, 0x00, 0x00, // Cancel
+	0x01,                   // Varint for number of cancel IDs
+	0xf0, 0x03, 0x00, 0x00, // SetCancel
+	0x10, 0x27, 0x00, 0x00, // MinVer
+	0x48, 0xee, 0x00, 0x00, // MaxVer
+	0x01, // Varint for number of user agents
+	0x0f, 0x2f, 0x53, 0x61, 0x74, 0x6f, 0x73, 0x68,
+	0x69, 0x3a, 0x30, 0x2e, 0x37, 0x2e, 0x32, 0x2f, // SetSubVer
+	0x64, 0x00, 0x00, 0x00, // Priority
+	0x00,                                     // Varint for comment length
+	0x06, 0x55, 0x52, 0x47, 0x45, 0x4e, 0x54, // StatusBar
+	0x00, // Varint for reserved length
+}
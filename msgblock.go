@@ -6,7 +6,10 @@ package btcwire
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"unsafe"
 )
 
 // MaxBlocksPerMsg is the maximum number of blocks allowed per message.
@@ -15,6 +18,53 @@ const MaxBlocksPerMsg = 500
 // MaxBlockPayload is the maximum bytes a block message can be.
 const MaxBlockPayload = (1024 * 1024) // 1MB
 
+// MaxBlockWeight is the maximum weight a block can be as defined by BIP0141.
+// The weight of a block is its size without witness data scaled by
+// WitnessScaleFactor-1 plus its size with witness data.
+const MaxBlockWeight = 4000000
+
+// maxTxPerBlock is the maximum number of transactions that could possibly fit
+// into a block.
+const maxTxPerBlock = (MaxBlockPayload / minTxPayload) + 1
+
+// WitnessScaleFactor is the factor by which the size of data which is not
+// witness data is scaled when computing the weight as defined by BIP0141.
+const WitnessScaleFactor = 4
+
+// witnessCommitmentHeader is the prefix of the public key script of the
+// coinbase output which holds the witness commitment as defined by BIP0141.
+// It is OP_RETURN, a push of 36 bytes, and the 4-byte commitment header.
+var witnessCommitmentHeader = []byte{0x6a, 0x24, 0xaa, 0x21, 0xa9, 0xed}
+
+// Errors returned by CheckCoinbaseStructure.  The errors it returns wrap
+// these so they may be matched with errors.Is.
+var (
+	// ErrNoCoinbase indicates a block which does not contain a coinbase
+	// transaction.
+	ErrNoCoinbase = errors.New("block does not contain a coinbase")
+
+	// ErrCoinbaseNotFirst indicates a block which contains a coinbase
+	// transaction that is not the first transaction.
+	ErrCoinbaseNotFirst = errors.New("first transaction is not the coinbase")
+
+	// ErrMultipleCoinbases indicates a block which contains a coinbase
+	// transaction in addition to the first transaction.
+	ErrMultipleCoinbases = errors.New("block contains multiple coinbases")
+)
+
+// Errors returned by CheckMerkleRoot and CheckWitnessCommitment.  The errors
+// they return wrap these so they may be matched with errors.Is.
+var (
+	// ErrBadMerkleRoot indicates a block whose header merkle root does not
+	// match the merkle root of its transactions.
+	ErrBadMerkleRoot = errors.New("block merkle root is invalid")
+
+	// ErrBadWitnessCommitment indicates a block whose witness commitment is
+	// missing, malformed, or does not commit to the witness data of its
+	// transactions.
+	ErrBadWitnessCommitment = errors.New("block witness commitment is invalid")
+)
+
 // TxLoc holds locator data for the offset and length of where a transaction is
 // located within a MsgBlock data buffer.
 type TxLoc struct {
@@ -55,17 +105,100 @@ func (msg *MsgBlock) ClearTransactions() {
 	msg.Header.TxnCount = 0
 }
 
+// Copy creates a deep copy of the block, including deep copies of all of its
+// transactions, so that the original does not get modified when the copy is
+// manipulated.  See MsgTx.Copy.
+func (msg *MsgBlock) Copy() *MsgBlock {
+	newBlock := MsgBlock{
+		Header:       msg.Header,
+		Transactions: copyTransactions(msg.Transactions),
+	}
+	return &newBlock
+}
+
+// copyTransactions returns a slice with deep copies of the passed
+// transactions.  A nil slice is returned as nil.
+func copyTransactions(txs []*MsgTx) []*MsgTx {
+	if txs == nil {
+		return nil
+	}
+
+	newTxs := make([]*MsgTx, 0, len(txs))
+	for _, tx := range txs {
+		newTxs = append(newTxs, tx.Copy())
+	}
+	return newTxs
+}
+
+// checkBlockTxCount returns an error if the passed number of transactions of a
+// block is more than could possibly fit into a block.  It would be possible to
+// cause memory exhaustion and panics without a sane upper bound on this count.
+// The f parameter is only used for the error.
+func checkBlockTxCount(count uint64, f string) error {
+	if count > maxTxPerBlock {
+		str := fmt.Sprintf("too many transactions to fit into a block "+
+			"[count %d, max %d]", count, maxTxPerBlock)
+		return messageError(f, ErrTooManyItems, str)
+	}
+	return nil
+}
+
 // BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
 // This is part of the Message interface implementation.
 func (msg *MsgBlock) BtcDecode(r io.Reader, pver uint32) error {
-	err := readBlockHeader(r, pver, &msg.Header)
+	// Decode all of the transactions with the same decode context.
+	dr, pooled := borrowDecodeReader(r, &defaultDecodeOptions)
+	if pooled {
+		defer returnDecodeReader(dr)
+	}
+
+	err := msg.BtcDecodeHeaderOnly(dr, pver)
 	if err != nil {
 		return err
 	}
+	return msg.decodeTransactions(dr, pver, "MsgBlock.BtcDecode")
+}
 
-	for i := uint64(0); i < msg.Header.TxnCount; i++ {
+// BtcDecodeHeaderOnly decodes the block header from r using the bitcoin
+// protocol encoding into the header of the receiver and leaves r positioned at
+// the number of transactions, which is not read.  This allows the header, for
+// example its hash and previous block, to be checked before committing to
+// reading the potentially large transactions.  BtcDecodeTransactions must be
+// called with the same reader to finish decoding the block.
+func (msg *MsgBlock) BtcDecodeHeaderOnly(r io.Reader, pver uint32) error {
+	return readBlockHeaderFields(r, pver, &msg.Header)
+}
+
+// BtcDecodeTransactions decodes the number of transactions and the
+// transactions of a block from r using the bitcoin protocol encoding into the
+// receiver.  It finishes decoding a block which was started with
+// BtcDecodeHeaderOnly, so r must be positioned at the number of transactions.
+func (msg *MsgBlock) BtcDecodeTransactions(r io.Reader, pver uint32) error {
+	dr, pooled := borrowDecodeReader(r, &defaultDecodeOptions)
+	if pooled {
+		defer returnDecodeReader(dr)
+	}
+
+	return msg.decodeTransactions(dr, pver, "MsgBlock.BtcDecodeTransactions")
+}
+
+// decodeTransactions decodes the number of transactions and the transactions
+// of a block from the passed decode context into the receiver.  The function
+// name is used in the error when there are too many transactions.
+func (msg *MsgBlock) decodeTransactions(dr *decodeReader, pver uint32, f string) error {
+	count, err := readVarInt(dr, pver)
+	if err != nil {
+		return err
+	}
+	err = checkBlockTxCount(count, f)
+	if err != nil {
+		return err
+	}
+	msg.Header.TxnCount = count
+
+	for i := uint64(0); i < count; i++ {
 		tx := MsgTx{}
-		err := tx.BtcDecode(r, pver)
+		err := tx.btcDecode(dr, pver, false)
 		if err != nil {
 			return err
 		}
@@ -86,19 +219,25 @@ func (msg *MsgBlock) BtcDecodeTxLoc(r *bytes.Buffer, pver uint32) ([]TxLoc, erro
 	if err != nil {
 		return nil, err
 	}
+	err = checkBlockTxCount(msg.Header.TxnCount, "MsgBlock.BtcDecodeTxLoc")
+	if err != nil {
+		return nil, err
+	}
 
-	var txLocs []TxLoc
-	txLocs = make([]TxLoc, msg.Header.TxnCount)
-
+	txLocs := make([]TxLoc, 0, preallocCount(msg.Header.TxnCount,
+		unsafe.Sizeof(TxLoc{})))
 	for i := uint64(0); i < msg.Header.TxnCount; i++ {
-		txLocs[i].TxStart = fullLen - r.Len()
+		txStart := fullLen - r.Len()
 		tx := MsgTx{}
-		err := tx.BtcDecode(r, pver)
+		err := tx.btcDecodeBlockTx(r, pver)
 		if err != nil {
 			return nil, err
 		}
 		msg.Transactions = append(msg.Transactions, &tx)
-		txLocs[i].TxLen = (fullLen - r.Len()) - txLocs[i].TxStart
+		txLocs = append(txLocs, TxLoc{
+			TxStart: txStart,
+			TxLen:   (fullLen - r.Len()) - txStart,
+		})
 	}
 
 	return txLocs, nil
@@ -108,8 +247,12 @@ func (msg *MsgBlock) BtcDecodeTxLoc(r *bytes.Buffer, pver uint32) ([]TxLoc, erro
 // This is part of the Message interface implementation.
 func (msg *MsgBlock) BtcEncode(w io.Writer, pver uint32) error {
 	msg.Header.TxnCount = uint64(len(msg.Transactions))
+	err := checkBlockTxCount(msg.Header.TxnCount, "MsgBlock.BtcEncode")
+	if err != nil {
+		return err
+	}
 
-	err := writeBlockHeader(w, pver, &msg.Header)
+	err = writeBlockHeader(w, pver, &msg.Header)
 	if err != nil {
 		return err
 	}
@@ -124,18 +267,224 @@ func (msg *MsgBlock) BtcEncode(w io.Writer, pver uint32) error {
 	return nil
 }
 
+// Serialize encodes the block to w using the canonical format used for
+// long-term storage such as a database, as opposed to the wire encoding used
+// by BtcEncode which may depend on the protocol version.  Each transaction is
+// encoded with MsgTx.Serialize, so transactions with witness data use the
+// witness serialization.
+func (msg *MsgBlock) Serialize(w io.Writer) error {
+	msg.Header.TxnCount = uint64(len(msg.Transactions))
+	err := checkBlockTxCount(msg.Header.TxnCount, "MsgBlock.Serialize")
+	if err != nil {
+		return err
+	}
+
+	err = writeBlockHeader(w, storageVersion, &msg.Header)
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range msg.Transactions {
+		err = tx.Serialize(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Deserialize decodes a block from r into the receiver using the canonical
+// format produced by Serialize.
+func (msg *MsgBlock) Deserialize(r io.Reader) error {
+	// Decode all of the transactions with the same decode context.
+	dr, pooled := borrowDecodeReader(r, &defaultDecodeOptions)
+	if pooled {
+		defer returnDecodeReader(dr)
+	}
+
+	err := readBlockHeader(dr, storageVersion, &msg.Header)
+	if err != nil {
+		return err
+	}
+	err = checkBlockTxCount(msg.Header.TxnCount, "MsgBlock.Deserialize")
+	if err != nil {
+		return err
+	}
+
+	for i := uint64(0); i < msg.Header.TxnCount; i++ {
+		tx := MsgTx{}
+		err := tx.btcDecode(dr, storageVersion, false)
+		if err != nil {
+			return err
+		}
+		msg.Transactions = append(msg.Transactions, &tx)
+	}
+
+	return nil
+}
+
+// DeserializeTxLoc decodes a block from r into the receiver like Deserialize
+// and returns a slice containing the start and length of each transaction
+// within the serialized block.  See TxLoc.
+func (msg *MsgBlock) DeserializeTxLoc(r io.Reader) ([]TxLoc, error) {
+	var txLocs []TxLoc
+	err := msg.DeserializeEachTx(r, func(tx *MsgTx, loc TxLoc) error {
+		if txLocs == nil {
+			txLocs = make([]TxLoc, 0, preallocCount(
+				msg.Header.TxnCount, unsafe.Sizeof(TxLoc{})))
+		}
+		msg.Transactions = append(msg.Transactions, tx)
+		txLocs = append(txLocs, loc)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if txLocs == nil {
+		txLocs = []TxLoc{}
+	}
+	return txLocs, nil
+}
+
+// DeserializeEachTx decodes a block from r using the canonical format produced
+// by Serialize without keeping its transactions in memory.  The block header
+// is decoded into the header of the receiver and fn is then called with each
+// transaction as it is read along with its location within the serialized
+// block.  The transactions of the receiver are left untouched.
+//
+// This allows consumers such as indexers to process large blocks, for example
+// to record the location of each transaction, while only holding one
+// transaction at a time.  Decoding stops at the first error returned by fn,
+// which is returned as is.
+func (msg *MsgBlock) DeserializeEachTx(r io.Reader,
+	fn func(tx *MsgTx, loc TxLoc) error) error {
+
+	// Count the bytes read from the underlying reader to locate the
+	// transactions while keeping any decode options carried by r.
+	cr := countingReader{r: r}
+	opts := &defaultDecodeOptions
+	if dr, ok := r.(*decodeReader); ok {
+		cr.r = dr.Reader
+		opts = dr.opts
+	}
+	dr, _ := borrowDecodeReader(&cr, opts)
+	defer returnDecodeReader(dr)
+
+	err := readBlockHeader(dr, storageVersion, &msg.Header)
+	if err != nil {
+		return err
+	}
+	err = checkBlockTxCount(msg.Header.TxnCount, "MsgBlock.DeserializeEachTx")
+	if err != nil {
+		return err
+	}
+
+	for i := uint64(0); i < msg.Header.TxnCount; i++ {
+		start := cr.n
+		tx := MsgTx{}
+		err := tx.btcDecode(dr, storageVersion, false)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&tx, TxLoc{TxStart: start, TxLen: cr.n - start})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SerializeSize returns the number of bytes it would take to serialize the
+// block with Serialize.
+func (msg *MsgBlock) SerializeSize() int {
+	// Block header 80 bytes + serialized varint size for the number of
+	// transactions.
+	n := blockHashLen + VarIntSerializeSize(uint64(len(msg.Transactions)))
+
+	for _, tx := range msg.Transactions {
+		n += tx.SerializeSize()
+	}
+
+	return n
+}
+
+// Bytes returns the block serialized with Serialize.  The buffer is sized
+// with SerializeSize up front to avoid reallocating it.
+func (msg *MsgBlock) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(msg.SerializeSize())
+	err := msg.Serialize(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SerializeNoWitness encodes the block to w like Serialize except every
+// transaction is encoded with MsgTx.SerializeNoWitness, so any witness data is
+// omitted.  That is the serialization understood by peers which predate
+// BIP0144 and the one the block size limit applies to.
+func (msg *MsgBlock) SerializeNoWitness(w io.Writer) error {
+	msg.Header.TxnCount = uint64(len(msg.Transactions))
+	err := checkBlockTxCount(msg.Header.TxnCount, "MsgBlock.SerializeNoWitness")
+	if err != nil {
+		return err
+	}
+
+	err = writeBlockHeader(w, storageVersion, &msg.Header)
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range msg.Transactions {
+		err = tx.SerializeNoWitness(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SerializeSizeStripped returns the number of bytes it would take to serialize
+// the block with SerializeNoWitness.
+func (msg *MsgBlock) SerializeSizeStripped() int {
+	// Block header 80 bytes + serialized varint size for the number of
+	// transactions.
+	n := blockHashLen + VarIntSerializeSize(uint64(len(msg.Transactions)))
+
+	for _, tx := range msg.Transactions {
+		n += tx.SerializeSizeStripped()
+	}
+
+	return n
+}
+
 // Command returns the protocol command string for the message.  This is part
 // of the Message interface implementation.
 func (msg *MsgBlock) Command() string {
 	return cmdBlock
 }
 
+// String returns the message in human-readable form.
+func (msg *MsgBlock) String() string {
+	hash, _ := msg.BlockSha(ProtocolVersion)
+	return fmt.Sprintf("%s hash=%v txns=%d", msg.Command(), hash,
+		len(msg.Transactions))
+}
+
 // MaxPayloadLength returns the maximum length the payload can be for the
 // receiver.  This is part of the Message interface implementation.
 func (msg *MsgBlock) MaxPayloadLength(pver uint32) uint32 {
 	// Block header at 81 bytes + max transactions which can vary up to the
-	// maxBlockPayload (including the block header).
-	return MaxBlockPayload
+	// max block size (including the block header).  Since the witness data
+	// of transactions is not counted against MaxBlockPayload, blocks with
+	// witness data are bounded by their weight instead, which is never
+	// smaller than their serialized size.
+	return MaxBlockWeight
 }
 
 // BlockSha computes the block identifier hash for this block.
@@ -143,6 +492,52 @@ func (msg *MsgBlock) BlockSha(pver uint32) (ShaHash, error) {
 	return msg.Header.BlockSha(pver)
 }
 
+// byteCounter is an io.Writer which discards everything written to it while
+// keeping track of the total number of bytes.
+type byteCounter int
+
+// Write counts the bytes of p and discards them.  It never fails.
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// TxLoc returns the start and length of each transaction within the block as
+// serialized by Serialize.  The offsets are relative to the start of the
+// serialized block, so they may be used to read an individual transaction from
+// a stored block without deserializing the entire block.
+func (msg *MsgBlock) TxLoc() ([]TxLoc, error) {
+	msg.Header.TxnCount = uint64(len(msg.Transactions))
+
+	var offset byteCounter
+	err := writeBlockHeader(&offset, ProtocolVersion, &msg.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	txLocs := make([]TxLoc, len(msg.Transactions))
+	for i, tx := range msg.Transactions {
+		txLocs[i].TxStart = int(offset)
+		err = tx.Serialize(&offset)
+		if err != nil {
+			return nil, err
+		}
+		txLocs[i].TxLen = int(offset) - txLocs[i].TxStart
+	}
+
+	return txLocs, nil
+}
+
+// BlockHash computes the block identifier hash for this block.  Unlike
+// BlockSha, it does not depend on a protocol version since the block header
+// is always hashed in the same format.
+func (msg *MsgBlock) BlockHash() ShaHash {
+	// Ignore error here since BlockSha can't fail in the current
+	// implementation except due to run-time panics.
+	hash, _ := msg.Header.BlockSha(ProtocolVersion)
+	return hash
+}
+
 // TxShas returns a slice of hashes of all of transactions in this block.
 func (msg *MsgBlock) TxShas(pver uint32) ([]ShaHash, error) {
 	var shaList []ShaHash
@@ -155,6 +550,237 @@ func (msg *MsgBlock) TxShas(pver uint32) ([]ShaHash, error) {
 	return shaList, nil
 }
 
+// TxHashes returns the hashes of all transactions in the block in the same
+// order they appear in the block.  Each hash is only computed once, so the
+// result is suitable for building a merkle tree or a transaction index.
+func (msg *MsgBlock) TxHashes() []ShaHash {
+	hashes := make([]ShaHash, 0, len(msg.Transactions))
+	for _, tx := range msg.Transactions {
+		// Ignore error here since TxSha can't fail in the current
+		// implementation except due to run-time panics.
+		hash, _ := tx.TxSha(ProtocolVersion)
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
+// TxIndexByHash returns the position in the block of the transaction with the
+// passed hash, or -1 if the block doesn't contain it.  The hash of every
+// transaction up to the match is computed, so TxIndex should be preferred for
+// repeated lookups in the same block.
+func (msg *MsgBlock) TxIndexByHash(hash *ShaHash) int {
+	for i, tx := range msg.Transactions {
+		// Ignore error here since TxSha can't fail in the current
+		// implementation except due to run-time panics.
+		txHash, _ := tx.TxSha(ProtocolVersion)
+		if txHash == *hash {
+			return i
+		}
+	}
+	return -1
+}
+
+// TxByHash returns the transaction in the block with the passed hash, or nil
+// if the block doesn't contain it.  See TxIndexByHash.
+func (msg *MsgBlock) TxByHash(hash *ShaHash) *MsgTx {
+	idx := msg.TxIndexByHash(hash)
+	if idx == -1 {
+		return nil
+	}
+	return msg.Transactions[idx]
+}
+
+// TxIndex returns a map of the hash of each transaction in the block to its
+// position in the block.  It is suitable for repeated lookups such as when
+// reconstructing a compact block or validating the transactions of a merkle
+// block.  In the event a block contains the same transaction more than once,
+// which is not valid, the position of the first one is used.
+func (msg *MsgBlock) TxIndex() map[ShaHash]int {
+	index := make(map[ShaHash]int, len(msg.Transactions))
+	for i, hash := range msg.TxHashes() {
+		if _, ok := index[hash]; !ok {
+			index[hash] = i
+		}
+	}
+	return index
+}
+
+// CalcMerkleRoot returns the root of the merkle tree of the hashes of all
+// transactions in the block.  It is expected to match Header.MerkleRoot.
+func (msg *MsgBlock) CalcMerkleRoot() ShaHash {
+	return merkleRoot(msg.TxHashes())
+}
+
+// HasDuplicateTxs returns whether or not any two transactions of the block have
+// the same hash.  Since the last hash of each level of the merkle tree with an
+// odd number of hashes is paired with itself, a block with duplicated
+// transactions can have the same merkle root as a valid block without them
+// (CVE-2012-2459).  Such a block is invalid, so this cheap check should be
+// performed along with CheckMerkleRoot.
+func (msg *MsgBlock) HasDuplicateTxs() bool {
+	seen := make(map[ShaHash]struct{}, len(msg.Transactions))
+	for _, hash := range msg.TxHashes() {
+		if _, ok := seen[hash]; ok {
+			return true
+		}
+		seen[hash] = struct{}{}
+	}
+	return false
+}
+
+// CheckMerkleRoot performs the cheap sanity check that the merkle root in the
+// header of the block commits to the transactions of the block, which catches
+// corrupted or tampered blocks before more expensive validation.  The returned
+// error wraps ErrBadMerkleRoot and shows both hashes on mismatch.
+func (msg *MsgBlock) CheckMerkleRoot() error {
+	calculated := msg.CalcMerkleRoot()
+	if !calculated.IsEqual(&msg.Header.MerkleRoot) {
+		return fmt.Errorf("CheckMerkleRoot: header merkle root %v does "+
+			"not match calculated merkle root %v: %w",
+			msg.Header.MerkleRoot, calculated, ErrBadMerkleRoot)
+	}
+	return nil
+}
+
+// WitnessMerkleRoot returns the root of the merkle tree of the witness hashes
+// (wtxids) of all transactions in the block as defined by BIP0141.  The
+// witness hash of the coinbase transaction is treated as the zero hash.
+func (msg *MsgBlock) WitnessMerkleRoot() ShaHash {
+	hashes := make([]ShaHash, len(msg.Transactions))
+	for i, tx := range msg.Transactions {
+		if i == 0 {
+			// The coinbase witness hash is all zeros.
+			continue
+		}
+
+		// Ignore error here since WTxSha can't fail in the current
+		// implementation except due to run-time panics.
+		hashes[i], _ = tx.WTxSha()
+	}
+	return merkleRoot(hashes)
+}
+
+// WitnessCommitment returns the witness commitment of the block as defined by
+// BIP0141 and whether or not the block has one.  The commitment is held by an
+// output of the coinbase transaction whose public key script starts with
+// OP_RETURN followed by a 36-byte push of 0xaa21a9ed and the commitment.  When
+// several outputs match, the one with the highest index is used.
+//
+// The commitment is expected to be WitnessCommitmentHash of the witness merkle
+// root of the block and the witness reserved value in the coinbase witness.
+func (msg *MsgBlock) WitnessCommitment() (ShaHash, bool) {
+	if len(msg.Transactions) == 0 {
+		return ShaHash{}, false
+	}
+
+	coinbase := msg.Transactions[0]
+	for i := len(coinbase.TxOut) - 1; i >= 0; i-- {
+		pkScript := coinbase.TxOut[i].PkScript
+		if len(pkScript) < len(witnessCommitmentHeader)+HashSize ||
+			!bytes.HasPrefix(pkScript, witnessCommitmentHeader) {
+
+			continue
+		}
+
+		var commitment ShaHash
+		copy(commitment[:], pkScript[len(witnessCommitmentHeader):])
+		return commitment, true
+	}
+
+	return ShaHash{}, false
+}
+
+// WitnessCommitmentHash returns the witness commitment as defined by BIP0141
+// for the passed witness merkle root and witness reserved value.  It is the
+// double sha256 of the root followed by the reserved value.
+func WitnessCommitmentHash(witnessRoot, reservedValue *ShaHash) ShaHash {
+	return hashMerkleBranches(witnessRoot, reservedValue)
+}
+
+// CheckWitnessCommitment is the witness counterpart of CheckMerkleRoot.  It
+// checks that the witness commitment of the block (see WitnessCommitment)
+// commits to the witness data of its transactions as defined by BIP0141.  The
+// coinbase witness must then consist of a single 32-byte witness reserved
+// value.  Blocks without a commitment are only valid when none of their
+// transactions have witness data.  The returned error wraps
+// ErrBadWitnessCommitment.
+func (msg *MsgBlock) CheckWitnessCommitment() error {
+	commitment, ok := msg.WitnessCommitment()
+	if !ok {
+		for i, tx := range msg.Transactions {
+			if tx.HasWitness() {
+				return fmt.Errorf("CheckWitnessCommitment: "+
+					"transaction %d has witness data but the "+
+					"block has no witness commitment: %w", i,
+					ErrBadWitnessCommitment)
+			}
+		}
+		return nil
+	}
+
+	// The block has a commitment, so it must have a coinbase.
+	coinbase := msg.Transactions[0]
+	if len(coinbase.TxIn) != 1 || len(coinbase.TxIn[0].Witness) != 1 ||
+		len(coinbase.TxIn[0].Witness[0]) != HashSize {
+
+		return fmt.Errorf("CheckWitnessCommitment: coinbase witness "+
+			"must be a single %d-byte witness reserved value: %w",
+			HashSize, ErrBadWitnessCommitment)
+	}
+
+	var reserved ShaHash
+	copy(reserved[:], coinbase.TxIn[0].Witness[0])
+	root := msg.WitnessMerkleRoot()
+	calculated := WitnessCommitmentHash(&root, &reserved)
+	if !calculated.IsEqual(&commitment) {
+		return fmt.Errorf("CheckWitnessCommitment: witness commitment "+
+			"%v does not match calculated witness commitment %v: %w",
+			commitment, calculated, ErrBadWitnessCommitment)
+	}
+	return nil
+}
+
+// CoinBase returns the coinbase transaction of the block, which is always the
+// first transaction.  It returns nil when the block has no transactions or the
+// first transaction is not a coinbase.
+func (msg *MsgBlock) CoinBase() *MsgTx {
+	if len(msg.Transactions) == 0 {
+		return nil
+	}
+
+	tx := msg.Transactions[0]
+	if !tx.IsCoinBase() {
+		return nil
+	}
+	return tx
+}
+
+// CheckCoinbaseStructure performs the cheap structural check that the first
+// transaction of the block is a coinbase and that no other transaction is.  The
+// returned error wraps ErrNoCoinbase, ErrCoinbaseNotFirst, or
+// ErrMultipleCoinbases to describe the problem.
+func (msg *MsgBlock) CheckCoinbaseStructure() error {
+	firstIsCoinBase := len(msg.Transactions) > 0 &&
+		msg.Transactions[0].IsCoinBase()
+
+	for i := 1; i < len(msg.Transactions); i++ {
+		if !msg.Transactions[i].IsCoinBase() {
+			continue
+		}
+		if firstIsCoinBase {
+			return fmt.Errorf("CheckCoinbaseStructure: transaction "+
+				"%d is a coinbase: %w", i, ErrMultipleCoinbases)
+		}
+		return fmt.Errorf("CheckCoinbaseStructure: transaction %d is "+
+			"a coinbase: %w", i, ErrCoinbaseNotFirst)
+	}
+
+	if !firstIsCoinBase {
+		return fmt.Errorf("CheckCoinbaseStructure: %w", ErrNoCoinbase)
+	}
+	return nil
+}
+
 // NewMsgBlock returns a new bitcoin block message that conforms to the
 // Message interface.  See MsgBlock for details.
 func NewMsgBlock(blockHeader *BlockHeader) *MsgBlock {
@@ -162,3 +788,22 @@ func NewMsgBlock(blockHeader *BlockHeader) *MsgBlock {
 		Header: *blockHeader,
 	}
 }
+
+// NewMsgBlockFromBytes returns a new bitcoin block message decoded from the
+// passed bytes which must be in the format produced by MsgBlock.Serialize.
+// The bytes must contain exactly one block, so any bytes left over after the
+// block are rejected with ErrMalformed to catch corrupt or concatenated data.
+func NewMsgBlockFromBytes(serializedBlock []byte) (*MsgBlock, error) {
+	var msg MsgBlock
+	r := bytes.NewReader(serializedBlock)
+	err := msg.Deserialize(r)
+	if err != nil {
+		return nil, err
+	}
+	if r.Len() != 0 {
+		str := fmt.Sprintf("%d trailing bytes after serialized block",
+			r.Len())
+		return nil, messageError("NewMsgBlockFromBytes", ErrMalformed, str)
+	}
+	return &msg, nil
+}
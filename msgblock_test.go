@@ -6,6 +6,7 @@ package btcwire_test
 
 import (
 	"bytes"
+	"errors"
 	"github.com/conformal/btcwire"
 	"github.com/davecgh/go-spew/spew"
 	"io"
@@ -34,8 +35,8 @@ func TestBlock(t *testing.T) {
 	}
 
 	// Ensure max payload is expected value for latest protocol version.
-	// Num addresses (varInt) + max allowed addresses.
-	wantPayload := uint32(1024 * 1024)
+	// The max block weight bounds blocks with witness data.
+	wantPayload := uint32(4000000)
 	maxPayload := msg.MaxPayloadLength(pver)
 	if maxPayload != wantPayload {
 		t.Errorf("MaxPayloadLength: wrong max payload length for "+
@@ -68,6 +69,65 @@ func TestBlock(t *testing.T) {
 	return
 }
 
+// TestBlockCopy tests the MsgBlock Copy function produces a deep copy which
+// does not share any memory with the original.
+func TestBlockCopy(t *testing.T) {
+	// Block with a legacy transaction followed by a witness transaction.
+	mixedBlock := btcwire.NewMsgBlock(&blockOne.Header)
+	mixedBlock.AddTransaction(blockOne.Transactions[0])
+	mixedBlock.AddTransaction(witnessTx)
+
+	tests := []*btcwire.MsgBlock{
+		&blockOne,
+		mixedBlock,
+		btcwire.NewMsgBlock(&blockOne.Header),
+		&btcwire.MsgBlock{Header: blockOne.Header},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		want, err := test.Bytes()
+		if err != nil {
+			t.Errorf("Bytes #%d error %v", i, err)
+			continue
+		}
+
+		newBlock := test.Copy()
+		if !reflect.DeepEqual(newBlock, test) {
+			t.Errorf("Copy #%d\n got: %s want: %s", i,
+				spew.Sdump(newBlock), spew.Sdump(test))
+			continue
+		}
+
+		// Ensure modifying every part of the copy leaves the original
+		// untouched.
+		newBlock.Header.Nonce++
+		for _, tx := range newBlock.Transactions {
+			tx.TxIn[0].PreviousOutpoint.Index++
+			if len(tx.TxIn[0].SignatureScript) > 0 {
+				tx.TxIn[0].SignatureScript[0]++
+			}
+			if len(tx.TxIn[0].Witness) > 0 {
+				tx.TxIn[0].Witness[0][0]++
+			}
+			tx.TxOut[0].PkScript[0]++
+		}
+		if len(newBlock.Transactions) > 0 {
+			newBlock.Transactions[0] = witnessTx
+		}
+		got, err := test.Bytes()
+		if err != nil {
+			t.Errorf("Bytes #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("Copy #%d: original modified\n got: %s want: %s",
+				i, spew.Sdump(got), spew.Sdump(want))
+			continue
+		}
+	}
+}
+
 // TestBlockTxShas tests the ability to generate a slice of all transaction
 // hashes from a block accurately.
 func TestBlockTxShas(t *testing.T) {
@@ -95,6 +155,449 @@ func TestBlockTxShas(t *testing.T) {
 	}
 }
 
+// TestBlockTxHashes tests the ability to generate a slice of all transaction
+// hashes from a block accurately and in order.
+func TestBlockTxHashes(t *testing.T) {
+	// Block 1, transaction 1 hash.
+	hashStr := "0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098"
+	wantHash, err := btcwire.NewShaHashFromStr(hashStr)
+	if err != nil {
+		t.Errorf("NewShaHashFromStr: %v", err)
+		return
+	}
+
+	wantHashes := []btcwire.ShaHash{*wantHash}
+	hashes := blockOne.TxHashes()
+	if !reflect.DeepEqual(hashes, wantHashes) {
+		t.Errorf("TxHashes: wrong transaction hashes - got %v, want %v",
+			spew.Sdump(hashes), spew.Sdump(wantHashes))
+	}
+
+	// Ensure the hashes are the same as those returned by TxShas.
+	shas, err := blockOne.TxShas(btcwire.ProtocolVersion)
+	if err != nil {
+		t.Errorf("TxShas: %v", err)
+	}
+	if !reflect.DeepEqual(hashes, shas) {
+		t.Errorf("TxHashes: hashes do not match TxShas - got %v, "+
+			"want %v", spew.Sdump(hashes), spew.Sdump(shas))
+	}
+
+	// Ensure a block without transactions returns an empty slice.
+	emptyBlock := btcwire.NewMsgBlock(&blockOne.Header)
+	if hashes := emptyBlock.TxHashes(); len(hashes) != 0 {
+		t.Errorf("TxHashes: unexpected hashes for empty block - got %v",
+			spew.Sdump(hashes))
+	}
+}
+
+// TestBlockTxByHash tests the ability to look up transactions of a block by
+// their hashes.
+func TestBlockTxByHash(t *testing.T) {
+	// Block with a legacy transaction followed by a witness transaction.
+	block := btcwire.NewMsgBlock(&blockOne.Header)
+	block.AddTransaction(blockOne.Transactions[0])
+	block.AddTransaction(witnessTx)
+
+	coinbaseHash, err := blockOne.Transactions[0].TxSha(btcwire.ProtocolVersion)
+	if err != nil {
+		t.Errorf("TxSha: %v", err)
+	}
+	witnessHash, err := witnessTx.TxSha(btcwire.ProtocolVersion)
+	if err != nil {
+		t.Errorf("TxSha: %v", err)
+	}
+
+	tests := []struct {
+		hash btcwire.ShaHash // Hash to look up
+		idx  int             // Expected index
+		tx   *btcwire.MsgTx  // Expected transaction
+	}{
+		{coinbaseHash, 0, blockOne.Transactions[0]},
+		{witnessHash, 1, witnessTx},
+		{btcwire.ShaHash{0x01}, -1, nil},
+	}
+
+	index := block.TxIndex()
+	if len(index) != len(block.Transactions) {
+		t.Errorf("TxIndex: wrong number of entries - got %d, want %d",
+			len(index), len(block.Transactions))
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		if idx := block.TxIndexByHash(&test.hash); idx != test.idx {
+			t.Errorf("TxIndexByHash #%d: got %d, want %d", i, idx,
+				test.idx)
+			continue
+		}
+		if tx := block.TxByHash(&test.hash); tx != test.tx {
+			t.Errorf("TxByHash #%d: got %v, want %v", i,
+				spew.Sdump(tx), spew.Sdump(test.tx))
+			continue
+		}
+		idx, ok := index[test.hash]
+		if !ok {
+			idx = -1
+		}
+		if idx != test.idx {
+			t.Errorf("TxIndex #%d: got %d, want %d", i, idx, test.idx)
+			continue
+		}
+	}
+
+	// Ensure the first position is used for duplicate transactions.
+	block.AddTransaction(witnessTx)
+	if idx := block.TxIndex()[witnessHash]; idx != 1 {
+		t.Errorf("TxIndex: wrong index for duplicate transaction - "+
+			"got %d, want %d", idx, 1)
+	}
+	if idx := block.TxIndexByHash(&witnessHash); idx != 1 {
+		t.Errorf("TxIndexByHash: wrong index for duplicate transaction "+
+			"- got %d, want %d", idx, 1)
+	}
+}
+
+// TestBlockWitnessCommitment tests the witness merkle root and witness
+// commitment of blocks.
+func TestBlockWitnessCommitment(t *testing.T) {
+	// The witness hashes of the transactions.
+	witnessHash, err := witnessTx.WTxSha()
+	if err != nil {
+		t.Errorf("WTxSha: %v", err)
+	}
+	multiHash, err := multiTx.WTxSha()
+	if err != nil {
+		t.Errorf("WTxSha: %v", err)
+	}
+
+	// Block with three transactions so the last witness hash of the first
+	// level is paired with itself.  The coinbase witness hash is treated
+	// as zero.
+	block := btcwire.NewMsgBlock(&blockOne.Header)
+	coinbase := blockOne.Transactions[0].Copy()
+	block.AddTransaction(coinbase)
+	block.AddTransaction(witnessTx)
+	block.AddTransaction(multiTx)
+	left := btcwire.DoubleSha256SH(joinBytes(make([]byte, 32),
+		witnessHash[:]))
+	right := btcwire.DoubleSha256SH(joinBytes(multiHash[:], multiHash[:]))
+	wantRoot := btcwire.DoubleSha256SH(joinBytes(left[:], right[:]))
+	root := block.WitnessMerkleRoot()
+	if root != wantRoot {
+		t.Errorf("WitnessMerkleRoot: wrong root - got %v, want %v",
+			root, wantRoot)
+	}
+
+	// Ensure the commitment is the double sha256 of the root and the
+	// reserved value.
+	reserved := btcwire.ShaHash{0x01}
+	commitment := btcwire.WitnessCommitmentHash(&root, &reserved)
+	wantCommitment := btcwire.DoubleSha256SH(joinBytes(root[:], reserved[:]))
+	if commitment != wantCommitment {
+		t.Errorf("WitnessCommitmentHash: wrong commitment - got %v, "+
+			"want %v", commitment, wantCommitment)
+	}
+
+	// Ensure blocks without a commitment are detected.
+	if _, ok := block.WitnessCommitment(); ok {
+		t.Errorf("WitnessCommitment: unexpected commitment")
+	}
+	if _, ok := btcwire.NewMsgBlock(&blockOne.Header).WitnessCommitment(); ok {
+		t.Errorf("WitnessCommitment: unexpected commitment for empty " +
+			"block")
+	}
+
+	// Ensure a script with the commitment header which is too short is
+	// ignored.
+	header := []byte{0x6a, 0x24, 0xaa, 0x21, 0xa9, 0xed}
+	coinbase.AddTxOut(btcwire.NewTxOut(0, joinBytes(header, commitment[:31])))
+	if _, ok := block.WitnessCommitment(); ok {
+		t.Errorf("WitnessCommitment: unexpected commitment for short " +
+			"script")
+	}
+
+	// Ensure the commitment is extracted, including when followed by
+	// additional data.
+	coinbase.AddTxOut(btcwire.NewTxOut(0, joinBytes(header, commitment[:],
+		[]byte{0x01})))
+	got, ok := block.WitnessCommitment()
+	if !ok || got != commitment {
+		t.Errorf("WitnessCommitment: got %v (%v), want %v", got, ok,
+			commitment)
+	}
+
+	// Ensure the commitment with the highest output index is used.
+	other := btcwire.ShaHash{0x02}
+	coinbase.AddTxOut(btcwire.NewTxOut(0, joinBytes(header, other[:])))
+	coinbase.AddTxOut(btcwire.NewTxOut(0, []byte{0x51}))
+	got, ok = block.WitnessCommitment()
+	if !ok || got != other {
+		t.Errorf("WitnessCommitment: got %v (%v), want %v", got, ok,
+			other)
+	}
+
+	// Ensure the witness merkle root of a block with only a coinbase is
+	// zero and of an empty block is zero.
+	block = btcwire.NewMsgBlock(&blockOne.Header)
+	if root := block.WitnessMerkleRoot(); root != (btcwire.ShaHash{}) {
+		t.Errorf("WitnessMerkleRoot: wrong root for empty block - "+
+			"got %v", root)
+	}
+	block.AddTransaction(coinbase)
+	if root := block.WitnessMerkleRoot(); root != (btcwire.ShaHash{}) {
+		t.Errorf("WitnessMerkleRoot: wrong root for coinbase only "+
+			"block - got %v", root)
+	}
+}
+
+// TestBlockCoinBase tests the ability to retrieve the coinbase transaction of a
+// block.
+func TestBlockCoinBase(t *testing.T) {
+	// Block 1 starts with its coinbase.
+	coinbase := blockOne.CoinBase()
+	if coinbase != blockOne.Transactions[0] {
+		t.Errorf("CoinBase: wrong transaction - got %v, want %v",
+			spew.Sdump(coinbase), spew.Sdump(blockOne.Transactions[0]))
+	}
+
+	// A block without transactions has no coinbase.
+	emptyBlock := btcwire.NewMsgBlock(&blockOne.Header)
+	if tx := emptyBlock.CoinBase(); tx != nil {
+		t.Errorf("CoinBase: unexpected coinbase for empty block - "+
+			"got %v", spew.Sdump(tx))
+	}
+
+	// A block whose first transaction is not a coinbase has no coinbase.
+	prevOut := btcwire.NewOutPoint(&btcwire.ShaHash{0x01}, 0)
+	tx := btcwire.NewMsgTx()
+	tx.AddTxIn(btcwire.NewTxIn(prevOut, nil))
+	badBlock := btcwire.NewMsgBlock(&blockOne.Header)
+	badBlock.AddTransaction(tx)
+	if tx := badBlock.CoinBase(); tx != nil {
+		t.Errorf("CoinBase: unexpected coinbase for block without "+
+			"coinbase - got %v", spew.Sdump(tx))
+	}
+}
+
+// TestBlockCheckCoinbaseStructure ensures the coinbase structure of blocks is
+// checked as expected.
+func TestBlockCheckCoinbaseStructure(t *testing.T) {
+	coinbase := blockOne.Transactions[0]
+	prevOut := btcwire.NewOutPoint(&btcwire.ShaHash{0x01}, 0)
+	spend := btcwire.NewMsgTx()
+	spend.AddTxIn(btcwire.NewTxIn(prevOut, nil))
+
+	tests := []struct {
+		txns []*btcwire.MsgTx // Transactions of the block
+		err  error            // Expected wrapped error
+	}{
+		// Block with only a coinbase.
+		{[]*btcwire.MsgTx{coinbase}, nil},
+
+		// Block with a coinbase followed by other transactions.
+		{[]*btcwire.MsgTx{coinbase, spend, spend}, nil},
+
+		// Block without transactions.
+		{nil, btcwire.ErrNoCoinbase},
+
+		// Block without any coinbase.
+		{[]*btcwire.MsgTx{spend, spend}, btcwire.ErrNoCoinbase},
+
+		// Block with the coinbase after the first transaction.
+		{[]*btcwire.MsgTx{spend, coinbase}, btcwire.ErrCoinbaseNotFirst},
+
+		// Block with a second coinbase.
+		{[]*btcwire.MsgTx{coinbase, spend, coinbase},
+			btcwire.ErrMultipleCoinbases},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		block := btcwire.NewMsgBlock(&blockOne.Header)
+		for _, tx := range test.txns {
+			block.AddTransaction(tx)
+		}
+
+		err := block.CheckCoinbaseStructure()
+		if test.err == nil {
+			if err != nil {
+				t.Errorf("CheckCoinbaseStructure #%d unexpected "+
+					"error: %v", i, err)
+			}
+			continue
+		}
+		if !errors.Is(err, test.err) {
+			t.Errorf("CheckCoinbaseStructure #%d wrong error - got "+
+				"%v, want %v", i, err, test.err)
+			continue
+		}
+	}
+}
+
+// TestBlockCheckMerkleRoot ensures the merkle root of blocks is calculated and
+// checked against the header as expected.
+func TestBlockCheckMerkleRoot(t *testing.T) {
+	// Block 1 only has a coinbase, so its merkle root is the coinbase hash.
+	if err := blockOne.CheckMerkleRoot(); err != nil {
+		t.Errorf("CheckMerkleRoot: unexpected error: %v", err)
+	}
+
+	// Block with three transactions so the last hash of the first level is
+	// paired with itself.
+	block := btcwire.NewMsgBlock(&blockOne.Header)
+	block.AddTransaction(blockOne.Transactions[0])
+	block.AddTransaction(multiTx)
+	block.AddTransaction(witnessTx)
+	hashes := block.TxHashes()
+	left := btcwire.DoubleSha256SH(joinBytes(hashes[0][:], hashes[1][:]))
+	right := btcwire.DoubleSha256SH(joinBytes(hashes[2][:], hashes[2][:]))
+	wantRoot := btcwire.DoubleSha256SH(joinBytes(left[:], right[:]))
+	if root := block.CalcMerkleRoot(); root != wantRoot {
+		t.Errorf("CalcMerkleRoot: wrong root - got %v, want %v", root,
+			wantRoot)
+	}
+
+	// The header still commits to block 1 only.
+	err := block.CheckMerkleRoot()
+	if !errors.Is(err, btcwire.ErrBadMerkleRoot) {
+		t.Errorf("CheckMerkleRoot: wrong error - got %v, want %v", err,
+			btcwire.ErrBadMerkleRoot)
+	}
+
+	block.Header.MerkleRoot = wantRoot
+	if err := block.CheckMerkleRoot(); err != nil {
+		t.Errorf("CheckMerkleRoot: unexpected error: %v", err)
+	}
+}
+
+// TestBlockHasDuplicateTxs ensures blocks with duplicated transactions are
+// detected, including ones which have the same merkle root as the block
+// without the duplicates.
+func TestBlockHasDuplicateTxs(t *testing.T) {
+	coinbase := blockOne.Transactions[0]
+
+	// The merkle root of a block with three transactions is the same as
+	// the one of the block with the last transaction duplicated.
+	block := btcwire.NewMsgBlock(&blockOne.Header)
+	block.AddTransaction(coinbase)
+	block.AddTransaction(multiTx)
+	block.AddTransaction(witnessTx)
+	dupBlock := btcwire.NewMsgBlock(&blockOne.Header)
+	dupBlock.AddTransaction(coinbase)
+	dupBlock.AddTransaction(multiTx)
+	dupBlock.AddTransaction(witnessTx)
+	dupBlock.AddTransaction(witnessTx)
+	if block.CalcMerkleRoot() != dupBlock.CalcMerkleRoot() {
+		t.Errorf("CalcMerkleRoot: merkle roots differ")
+	}
+
+	// The witness of a transaction does not change its hash, so a block
+	// with a transaction both with and without its witness has duplicates.
+	witnessDupBlock := btcwire.NewMsgBlock(&blockOne.Header)
+	witnessDupBlock.AddTransaction(coinbase)
+	witnessDupBlock.AddTransaction(witnessTx)
+	witnessDupBlock.AddTransaction(multiTx)
+	witnessDupBlock.AddTransaction(witnessTx.WithoutWitness())
+
+	tests := []struct {
+		block *btcwire.MsgBlock // Block to check
+		dup   bool              // Expected result
+	}{
+		{btcwire.NewMsgBlock(&blockOne.Header), false},
+		{&blockOne, false},
+		{block, false},
+		{dupBlock, true},
+		{witnessDupBlock, true},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		if dup := test.block.HasDuplicateTxs(); dup != test.dup {
+			t.Errorf("HasDuplicateTxs #%d: got %v, want %v", i, dup,
+				test.dup)
+			continue
+		}
+	}
+}
+
+// TestBlockCheckWitnessCommitment ensures the witness commitment of blocks is
+// checked as expected.
+func TestBlockCheckWitnessCommitment(t *testing.T) {
+	header := []byte{0x6a, 0x24, 0xaa, 0x21, 0xa9, 0xed}
+	reserved := btcwire.ShaHash{0x01}
+
+	// newBlock returns a block with a coinbase with the passed witness
+	// followed by the passed transactions.  The coinbase commits to the
+	// witness data of the block when commit is set.
+	newBlock := func(witness btcwire.TxWitness, commit bool,
+		txns ...*btcwire.MsgTx) *btcwire.MsgBlock {
+
+		coinbase := blockOne.Transactions[0].Copy()
+		coinbase.TxIn[0].Witness = witness
+		block := btcwire.NewMsgBlock(&blockOne.Header)
+		block.AddTransaction(coinbase)
+		for _, tx := range txns {
+			block.AddTransaction(tx)
+		}
+		if commit {
+			root := block.WitnessMerkleRoot()
+			commitment := btcwire.WitnessCommitmentHash(&root, &reserved)
+			coinbase.AddTxOut(btcwire.NewTxOut(0, joinBytes(header,
+				commitment[:])))
+		}
+		return block
+	}
+	reservedWitness := btcwire.TxWitness{reserved[:]}
+
+	// A block whose commitment doesn't match its transactions.
+	badCommitment := newBlock(reservedWitness, true, witnessTx)
+	badCommitment.AddTransaction(multiTx)
+
+	tests := []struct {
+		block *btcwire.MsgBlock // Block to check
+		err   error             // Expected wrapped error
+	}{
+		// Blocks without witness data or a commitment.
+		{btcwire.NewMsgBlock(&blockOne.Header), nil},
+		{&blockOne, nil},
+		{newBlock(nil, false, multiTx), nil},
+
+		// Block with witness data and a valid commitment.
+		{newBlock(reservedWitness, true, witnessTx, multiTx), nil},
+
+		// Block with witness data but without a commitment.
+		{newBlock(nil, false, witnessTx), btcwire.ErrBadWitnessCommitment},
+
+		// Block with a commitment but without a reserved value.
+		{newBlock(nil, true, witnessTx), btcwire.ErrBadWitnessCommitment},
+
+		// Block with a reserved value of the wrong size.
+		{newBlock(btcwire.TxWitness{reserved[:31]}, true, witnessTx),
+			btcwire.ErrBadWitnessCommitment},
+
+		// Block with a commitment to other transactions.
+		{badCommitment, btcwire.ErrBadWitnessCommitment},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		err := test.block.CheckWitnessCommitment()
+		if test.err == nil {
+			if err != nil {
+				t.Errorf("CheckWitnessCommitment #%d unexpected "+
+					"error: %v", i, err)
+			}
+			continue
+		}
+		if !errors.Is(err, test.err) {
+			t.Errorf("CheckWitnessCommitment #%d wrong error - got "+
+				"%v, want %v", i, err, test.err)
+			continue
+		}
+	}
+}
+
 // TestBlockSha tests the ability to generate the hash of a block accurately.
 func TestBlockSha(t *testing.T) {
 	// Use protocol version 60002 specifically here instead of the latest
@@ -118,6 +621,13 @@ func TestBlockSha(t *testing.T) {
 		t.Errorf("BlockSha: wrong hash - got %v, want %v",
 			spew.Sprint(blockHash), spew.Sprint(wantHash))
 	}
+
+	// Ensure the protocol version independent hash is the same.
+	blockHash = blockOne.BlockHash()
+	if !blockHash.IsEqual(wantHash) {
+		t.Errorf("BlockHash: wrong hash - got %v, want %v",
+			spew.Sprint(blockHash), spew.Sprint(wantHash))
+	}
 }
 
 // TestBlockWire tests the MsgBlock wire encode and decode for various numbers
@@ -225,6 +735,439 @@ func TestBlockWire(t *testing.T) {
 	}
 }
 
+// TestBlockSerialize tests the MsgBlock Serialize, Deserialize, Bytes, and
+// NewMsgBlockFromBytes functions.
+func TestBlockSerialize(t *testing.T) {
+	// Block with a single witness transaction.
+	witnessBlock := btcwire.NewMsgBlock(&blockOne.Header)
+	witnessBlock.AddTransaction(witnessTx)
+	witnessBlockBytes := append([]byte{}, blockOneBytes[:80]...)
+	witnessBlockBytes = append(witnessBlockBytes, 0x01) // Varint for number of transactions
+	witnessBlockBytes = append(witnessBlockBytes, witnessTxEncoded...)
+
+	tests := []struct {
+		in  *btcwire.MsgBlock // Message to encode
+		out *btcwire.MsgBlock // Expected decoded message
+		buf []byte            // Serialized data
+	}{
+		{&blockOne, &blockOne, blockOneBytes},
+		{witnessBlock, witnessBlock, witnessBlockBytes},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Serialize the block.
+		var buf bytes.Buffer
+		err := test.in.Serialize(&buf)
+		if err != nil {
+			t.Errorf("Serialize #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("Serialize #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		serialized, err := test.in.Bytes()
+		if err != nil {
+			t.Errorf("Bytes #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(serialized, test.buf) {
+			t.Errorf("Bytes #%d\n got: %s want: %s", i,
+				spew.Sdump(serialized), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Ensure the serialized size is calculated correctly.
+		if size := test.in.SerializeSize(); size != len(test.buf) {
+			t.Errorf("SerializeSize #%d: wrong size - got %d, "+
+				"want %d", i, size, len(test.buf))
+			continue
+		}
+
+		// Deserialize the block.
+		var block btcwire.MsgBlock
+		rbuf := bytes.NewReader(test.buf)
+		err = block.Deserialize(rbuf)
+		if err != nil {
+			t.Errorf("Deserialize #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&block, test.out) {
+			t.Errorf("Deserialize #%d\n got: %s want: %s", i,
+				spew.Sdump(&block), spew.Sdump(test.out))
+			continue
+		}
+
+		newBlock, err := btcwire.NewMsgBlockFromBytes(test.buf)
+		if err != nil {
+			t.Errorf("NewMsgBlockFromBytes #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(newBlock, test.out) {
+			t.Errorf("NewMsgBlockFromBytes #%d\n got: %s want: %s", i,
+				spew.Sdump(newBlock), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestBlockSerializeNoWitness tests the MsgBlock SerializeNoWitness and
+// SerializeSizeStripped functions omit the witness data of every transaction.
+func TestBlockSerializeNoWitness(t *testing.T) {
+	block := btcwire.NewMsgBlock(&blockOne.Header)
+	block.AddTransaction(blockOne.Transactions[0])
+	block.AddTransaction(witnessTx)
+
+	// Expected serialization is the header, the transaction count, and the
+	// legacy serialization of each transaction.
+	want := append([]byte{}, blockOneBytes[:80]...)
+	want = append(want, 0x02) // Varint for number of transactions
+	for _, tx := range block.Transactions {
+		txBytes, err := tx.BytesNoWitness()
+		if err != nil {
+			t.Errorf("BytesNoWitness: %v", err)
+			return
+		}
+		want = append(want, txBytes...)
+	}
+
+	var buf bytes.Buffer
+	err := block.SerializeNoWitness(&buf)
+	if err != nil {
+		t.Errorf("SerializeNoWitness: %v", err)
+		return
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("SerializeNoWitness\n got: %s want: %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(want))
+	}
+	if size := block.SerializeSizeStripped(); size != len(want) {
+		t.Errorf("SerializeSizeStripped: wrong size - got %d, want %d",
+			size, len(want))
+	}
+	if block.SerializeSizeStripped() >= block.SerializeSize() {
+		t.Errorf("SerializeSizeStripped: stripped size %d is not "+
+			"smaller than witness size %d",
+			block.SerializeSizeStripped(), block.SerializeSize())
+	}
+
+	// Ensure the stripped block deserializes to the same transactions
+	// without their witness data.
+	var stripped btcwire.MsgBlock
+	err = stripped.Deserialize(bytes.NewReader(want))
+	if err != nil {
+		t.Errorf("Deserialize: %v", err)
+		return
+	}
+	for i, tx := range stripped.Transactions {
+		if tx.HasWitness() {
+			t.Errorf("Deserialize: transaction %d has witness data", i)
+		}
+		got, _ := tx.TxSha(btcwire.ProtocolVersion)
+		wantHash, _ := block.Transactions[i].TxSha(btcwire.ProtocolVersion)
+		if !got.IsEqual(&wantHash) {
+			t.Errorf("TxSha: transaction %d hash changed - got %v, "+
+				"want %v", i, got, wantHash)
+		}
+	}
+}
+
+// TestBlockTxLoc tests the MsgBlock TxLoc function returns the correct
+// location of each transaction within the serialized block.
+func TestBlockTxLoc(t *testing.T) {
+	// Block with a legacy transaction followed by a witness transaction.
+	mixedBlock := btcwire.NewMsgBlock(&blockOne.Header)
+	mixedBlock.AddTransaction(blockOne.Transactions[0])
+	mixedBlock.AddTransaction(witnessTx)
+	mixedBlockTxLocs := []btcwire.TxLoc{
+		{TxStart: 81, TxLen: 134},
+		{TxStart: 215, TxLen: len(witnessTxEncoded)},
+	}
+
+	tests := []struct {
+		in     *btcwire.MsgBlock // Block to locate transactions in
+		txLocs []btcwire.TxLoc   // Expected transaction locations
+	}{
+		{&blockOne, blockOneTxLocs},
+		{mixedBlock, mixedBlockTxLocs},
+		{btcwire.NewMsgBlock(&blockOne.Header), []btcwire.TxLoc{}},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		txLocs, err := test.in.TxLoc()
+		if err != nil {
+			t.Errorf("TxLoc #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(txLocs, test.txLocs) {
+			t.Errorf("TxLoc #%d\n got: %s want: %s", i,
+				spew.Sdump(txLocs), spew.Sdump(test.txLocs))
+			continue
+		}
+
+		// Ensure each location refers to the bytes of the transaction
+		// within the serialized block.
+		serialized, err := test.in.Bytes()
+		if err != nil {
+			t.Errorf("Bytes #%d error %v", i, err)
+			continue
+		}
+		for j, txLoc := range txLocs {
+			var buf bytes.Buffer
+			err := test.in.Transactions[j].Serialize(&buf)
+			if err != nil {
+				t.Errorf("Serialize #%d:%d error %v", i, j, err)
+				continue
+			}
+			end := txLoc.TxStart + txLoc.TxLen
+			got := serialized[txLoc.TxStart:end]
+			if !bytes.Equal(got, buf.Bytes()) {
+				t.Errorf("TxLoc #%d:%d\n got: %s want: %s", i, j,
+					spew.Sdump(got), spew.Sdump(buf.Bytes()))
+				continue
+			}
+		}
+	}
+}
+
+// TestBlockDeserializeEachTx tests the MsgBlock DeserializeEachTx and
+// DeserializeTxLoc functions yield each transaction of a serialized block along
+// with its location.
+func TestBlockDeserializeEachTx(t *testing.T) {
+	// Block with a legacy transaction followed by a witness transaction.
+	mixedBlock := btcwire.NewMsgBlock(&blockOne.Header)
+	mixedBlock.AddTransaction(blockOne.Transactions[0])
+	mixedBlock.AddTransaction(witnessTx)
+
+	tests := []*btcwire.MsgBlock{
+		&blockOne,
+		mixedBlock,
+		btcwire.NewMsgBlock(&blockOne.Header),
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		serialized, err := test.Bytes()
+		if err != nil {
+			t.Errorf("Bytes #%d error %v", i, err)
+			continue
+		}
+		wantLocs, err := test.TxLoc()
+		if err != nil {
+			t.Errorf("TxLoc #%d error %v", i, err)
+			continue
+		}
+
+		// Ensure each transaction is yielded in order with its
+		// location and the transactions of the block are untouched.
+		var block btcwire.MsgBlock
+		var txs []*btcwire.MsgTx
+		txLocs := []btcwire.TxLoc{}
+		r := bytes.NewReader(serialized)
+		err = block.DeserializeEachTx(r, func(tx *btcwire.MsgTx,
+			loc btcwire.TxLoc) error {
+
+			txs = append(txs, tx)
+			txLocs = append(txLocs, loc)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("DeserializeEachTx #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&block.Header, &test.Header) {
+			t.Errorf("DeserializeEachTx #%d\n got: %s want: %s", i,
+				spew.Sdump(&block.Header), spew.Sdump(&test.Header))
+			continue
+		}
+		if len(block.Transactions) != 0 {
+			t.Errorf("DeserializeEachTx #%d: kept transactions %s", i,
+				spew.Sdump(block.Transactions))
+			continue
+		}
+		if !reflect.DeepEqual(txs, test.Transactions) {
+			t.Errorf("DeserializeEachTx #%d\n got: %s want: %s", i,
+				spew.Sdump(txs), spew.Sdump(test.Transactions))
+			continue
+		}
+		if !reflect.DeepEqual(txLocs, wantLocs) {
+			t.Errorf("DeserializeEachTx #%d\n got: %s want: %s", i,
+				spew.Sdump(txLocs), spew.Sdump(wantLocs))
+			continue
+		}
+
+		// Ensure DeserializeTxLoc decodes the full block along with
+		// the same locations.
+		var fullBlock btcwire.MsgBlock
+		r = bytes.NewReader(serialized)
+		txLocs, err = fullBlock.DeserializeTxLoc(r)
+		if err != nil {
+			t.Errorf("DeserializeTxLoc #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(txLocs, wantLocs) {
+			t.Errorf("DeserializeTxLoc #%d\n got: %s want: %s", i,
+				spew.Sdump(txLocs), spew.Sdump(wantLocs))
+			continue
+		}
+		reserialized, err := fullBlock.Bytes()
+		if err != nil {
+			t.Errorf("Bytes #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(reserialized, serialized) {
+			t.Errorf("DeserializeTxLoc #%d\n got: %s want: %s", i,
+				spew.Sdump(&fullBlock), spew.Sdump(test))
+			continue
+		}
+	}
+
+	// Ensure an error returned by the callback stops decoding and is
+	// returned as is.
+	errStop := errors.New("stop")
+	calls := 0
+	var block btcwire.MsgBlock
+	err := block.DeserializeEachTx(bytes.NewReader(blockOneBytes),
+		func(tx *btcwire.MsgTx, loc btcwire.TxLoc) error {
+			calls++
+			return errStop
+		})
+	if err != errStop || calls != 1 {
+		t.Errorf("DeserializeEachTx: wrong result - got %v after %d "+
+			"calls, want %v after 1 call", err, calls, errStop)
+	}
+
+	// Ensure errors are returned for a short block and for too many
+	// transactions.
+	noop := func(tx *btcwire.MsgTx, loc btcwire.TxLoc) error {
+		return nil
+	}
+	err = block.DeserializeEachTx(newFixedReader(100, blockOneBytes), noop)
+	if err != io.EOF && err != io.ErrUnexpectedEOF {
+		t.Errorf("DeserializeEachTx: wrong error got: %v, want: EOF",
+			err)
+	}
+	hugeCount := joinBytes(blockOneBytes[:80],
+		[]byte{0xfe, 0xff, 0xff, 0xff, 0xff})
+	err = block.DeserializeEachTx(bytes.NewReader(hugeCount), noop)
+	if _, ok := err.(*btcwire.MessageError); !ok {
+		t.Errorf("DeserializeEachTx: wrong error got: %v <%T>, "+
+			"want: <*btcwire.MessageError>", err, err)
+	}
+	_, err = block.DeserializeTxLoc(bytes.NewReader(hugeCount))
+	if _, ok := err.(*btcwire.MessageError); !ok {
+		t.Errorf("DeserializeTxLoc: wrong error got: %v <%T>, "+
+			"want: <*btcwire.MessageError>", err, err)
+	}
+}
+
+// TestBlockDecodeHeaderOnly tests decoding a block in two steps with the
+// MsgBlock BtcDecodeHeaderOnly and BtcDecodeTransactions functions.
+func TestBlockDecodeHeaderOnly(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	// Ensure only the header is read and the reader is left positioned at
+	// the number of transactions.
+	var block btcwire.MsgBlock
+	r := bytes.NewReader(blockOneBytes)
+	err := block.BtcDecodeHeaderOnly(r, pver)
+	if err != nil {
+		t.Fatalf("BtcDecodeHeaderOnly: %v", err)
+	}
+	if remaining := r.Len(); remaining != len(blockOneBytes)-80 {
+		t.Errorf("BtcDecodeHeaderOnly: wrong number of bytes remaining - "+
+			"got %d, want %d", remaining, len(blockOneBytes)-80)
+	}
+	wantHash, _ := blockOne.Header.BlockSha(pver)
+	if hash, _ := block.Header.BlockSha(pver); hash != wantHash {
+		t.Errorf("BtcDecodeHeaderOnly: wrong block hash - got %v, want %v",
+			hash, wantHash)
+	}
+	if len(block.Transactions) != 0 {
+		t.Errorf("BtcDecodeHeaderOnly: decoded transactions %s",
+			spew.Sdump(block.Transactions))
+	}
+
+	// Ensure finishing the decode produces the full block.
+	err = block.BtcDecodeTransactions(r, pver)
+	if err != nil {
+		t.Fatalf("BtcDecodeTransactions: %v", err)
+	}
+	if !reflect.DeepEqual(&block, &blockOne) {
+		t.Errorf("BtcDecodeTransactions\n got: %s want: %s",
+			spew.Sdump(&block), spew.Sdump(&blockOne))
+	}
+	if r.Len() != 0 {
+		t.Errorf("BtcDecodeTransactions: %d bytes remaining", r.Len())
+	}
+
+	// Ensure errors are returned for a short header and for too many
+	// transactions.
+	err = block.BtcDecodeHeaderOnly(newFixedReader(79, blockOneBytes), pver)
+	if err != io.ErrUnexpectedEOF && err != io.EOF {
+		t.Errorf("BtcDecodeHeaderOnly: wrong error got: %v, want: EOF",
+			err)
+	}
+	hugeCount := []byte{0xfe, 0xff, 0xff, 0xff, 0xff}
+	err = block.BtcDecodeTransactions(bytes.NewReader(hugeCount), pver)
+	if _, ok := err.(*btcwire.MessageError); !ok {
+		t.Errorf("BtcDecodeTransactions: wrong error got: %v <%T>, "+
+			"want: <*btcwire.MessageError>", err, err)
+	}
+}
+
+// TestBlockSerializeErrors performs negative tests against the MsgBlock
+// Serialize and Deserialize functions to confirm error paths work correctly.
+func TestBlockSerializeErrors(t *testing.T) {
+	tests := []struct {
+		in       *btcwire.MsgBlock // Value to encode
+		buf      []byte            // Serialized data
+		max      int               // Max size of fixed buffer to induce errors
+		writeErr error             // Expected write error
+		readErr  error             // Expected read error
+	}{
+		// Force error in version.
+		{&blockOne, blockOneBytes, 0, io.ErrShortWrite, io.EOF},
+		// Force error in transaction count.
+		{&blockOne, blockOneBytes, 80, io.ErrShortWrite, io.EOF},
+		// Force error in transactions.
+		{&blockOne, blockOneBytes, 81, io.ErrShortWrite, io.EOF},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Serialize the block.
+		w := newFixedWriter(test.max)
+		err := test.in.Serialize(w)
+		if err != test.writeErr {
+			t.Errorf("Serialize #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// Deserialize the block.
+		var block btcwire.MsgBlock
+		r := newFixedReader(test.max, test.buf)
+		err = block.Deserialize(r)
+		if err != test.readErr {
+			t.Errorf("Deserialize #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		_, err = btcwire.NewMsgBlockFromBytes(test.buf[:test.max])
+		if err != test.readErr {
+			t.Errorf("NewMsgBlockFromBytes #%d wrong error got: %v, "+
+				"want: %v", i, err, test.readErr)
+			continue
+		}
+	}
+}
+
 // TestBlockWireErrors performs negative tests against wire encode and decode
 // of MsgBlock to confirm error paths work correctly.
 func TestBlockWireErrors(t *testing.T) {
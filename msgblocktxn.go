@@ -0,0 +1,173 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// MsgBlockTxn implements the Message interface and represents a bitcoin
+// blocktxn message as defined by BIP0152.  It is sent in response to a
+// getblocktxn message (MsgGetBlockTxn) and holds the requested transactions of
+// a block in the order they were requested, including their witness data.
+//
+// This message was not added until protocol version SendCmpctVersion.
+type MsgBlockTxn struct {
+	BlockHash    ShaHash
+	Transactions []*MsgTx
+}
+
+// AddTransaction adds a transaction to the message.
+func (msg *MsgBlockTxn) AddTransaction(tx *MsgTx) error {
+	if len(msg.Transactions)+1 > maxTxPerBlock {
+		str := fmt.Sprintf("too many transactions for message [max %v]",
+			maxTxPerBlock)
+		return messageError("MsgBlockTxn.AddTransaction", ErrTooManyItems, str)
+	}
+
+	msg.Transactions = append(msg.Transactions, tx)
+	return nil
+}
+
+// Copy creates a deep copy of the message, including deep copies of all of its
+// transactions, so that the original does not get modified when the copy is
+// manipulated.  See MsgTx.Copy.
+func (msg *MsgBlockTxn) Copy() *MsgBlockTxn {
+	return &MsgBlockTxn{
+		BlockHash:    msg.BlockHash,
+		Transactions: copyTransactions(msg.Transactions),
+	}
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgBlockTxn) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < SendCmpctVersion {
+		str := fmt.Sprintf("blocktxn message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgBlockTxn.BtcDecode", ErrProtocolVersion, str)
+	}
+
+	err := readElement(r, &msg.BlockHash)
+	if err != nil {
+		return err
+	}
+
+	// Read num transactions and limit to max.
+	count, err := readVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > maxTxPerBlock {
+		str := fmt.Sprintf("too many transactions for message "+
+			"[count %v, max %v]", count, maxTxPerBlock)
+		return messageError("MsgBlockTxn.BtcDecode", ErrTooManyItems, str)
+	}
+
+	msg.Transactions = make([]*MsgTx, 0, preallocCount(count,
+		unsafe.Sizeof((*MsgTx)(nil))))
+	for i := uint64(0); i < count; i++ {
+		tx := MsgTx{}
+		err := tx.btcDecodeBlockTx(r, pver)
+		if err != nil {
+			return err
+		}
+		msg.Transactions = append(msg.Transactions, &tx)
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgBlockTxn) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < SendCmpctVersion {
+		str := fmt.Sprintf("blocktxn message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgBlockTxn.BtcEncode", ErrProtocolVersion, str)
+	}
+
+	// Limit to max transactions per block.
+	count := len(msg.Transactions)
+	if count > maxTxPerBlock {
+		str := fmt.Sprintf("too many transactions for message "+
+			"[count %v, max %v]", count, maxTxPerBlock)
+		return messageError("MsgBlockTxn.BtcEncode", ErrTooManyItems, str)
+	}
+
+	err := writeElement(w, msg.BlockHash)
+	if err != nil {
+		return err
+	}
+
+	err = writeVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range msg.Transactions {
+		err = tx.BtcEncode(w, pver)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgBlockTxn) Command() string {
+	return cmdBlockTxn
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgBlockTxn) String() string {
+	return fmt.Sprintf("%s block=%v txns=%d", msg.Command(), msg.BlockHash,
+		len(msg.Transactions))
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgBlockTxn) MaxPayloadLength(pver uint32) uint32 {
+	// The transactions are a subset of those of a block, so the message is
+	// bounded the same way a block is.
+	return MaxBlockWeight
+}
+
+// NewMsgBlockTxn returns a new bitcoin blocktxn message that conforms to the
+// Message interface using the passed block hash.  See MsgBlockTxn for details.
+func NewMsgBlockTxn(blockHash *ShaHash) *MsgBlockTxn {
+	return &MsgBlockTxn{
+		BlockHash:    *blockHash,
+		Transactions: make([]*MsgTx, 0),
+	}
+}
+
+// NewMsgBlockTxnFromBlock returns a new bitcoin blocktxn message which answers
+// the passed getblocktxn message with the requested transactions of the passed
+// block.  An error is returned when any of the requested indexes is not within
+// the block.
+func NewMsgBlockTxnFromBlock(block *MsgBlock, req *MsgGetBlockTxn) (*MsgBlockTxn, error) {
+	msg := NewMsgBlockTxn(&req.BlockHash)
+	for _, index := range req.Indexes {
+		if uint64(index) >= uint64(len(block.Transactions)) {
+			str := fmt.Sprintf("transaction index %d is out of "+
+				"range for block of %v transactions", index,
+				len(block.Transactions))
+			return nil, messageError("NewMsgBlockTxnFromBlock",
+				ErrMalformed, str)
+		}
+
+		err := msg.AddTransaction(block.Transactions[index])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return msg, nil
+}
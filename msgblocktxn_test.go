@@ -0,0 +1,296 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestBlockTxn tests the MsgBlockTxn API.
+func TestBlockTxn(t *testing.T) {
+	pver := btcwire.SendCmpctVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "blocktxn"
+	msg := btcwire.NewMsgBlockTxn(&blockTxnOne.BlockHash)
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgBlockTxn: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	wantPayload := uint32(4000000)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure transactions are added properly.
+	err := msg.AddTransaction(blockTxnOne.Transactions[0])
+	if err != nil {
+		t.Errorf("AddTransaction: %v", err)
+	}
+	if !reflect.DeepEqual(msg, &blockTxnOne) {
+		t.Errorf("AddTransaction: wrong message - got %v, want %v",
+			spew.Sdump(msg), spew.Sdump(&blockTxnOne))
+	}
+
+	// Ensure the copy produces an identical message which does not share
+	// its transactions.
+	newMsg := msg.Copy()
+	if !reflect.DeepEqual(newMsg, msg) {
+		t.Errorf("Copy: mismatched messages - got %v, want %v",
+			spew.Sdump(newMsg), spew.Sdump(msg))
+	}
+	if newMsg.Transactions[0] == msg.Transactions[0] {
+		t.Errorf("Copy: transactions are shared")
+	}
+
+	// Ensure adding more than the max allowed transactions per message
+	// returns an error.
+	for i := 0; i < btcwire.MaxBlockPayload/10+1; i++ {
+		err = msg.AddTransaction(blockTxnOne.Transactions[0])
+	}
+	if err == nil {
+		t.Errorf("AddTransaction: expected error on too many " +
+			"transactions not received")
+	}
+
+	// Older protocol versions should fail encode and decode since the
+	// message didn't exist yet.
+	oldPver := btcwire.SendCmpctVersion - 1
+	var buf bytes.Buffer
+	err = blockTxnOne.BtcEncode(&buf, oldPver)
+	if err == nil {
+		t.Errorf("encode of MsgBlockTxn succeeded when it should " +
+			"have failed")
+	}
+	var readmsg btcwire.MsgBlockTxn
+	err = readmsg.BtcDecode(bytes.NewBuffer(blockTxnOneBytes), oldPver)
+	if err == nil {
+		t.Errorf("decode of MsgBlockTxn succeeded when it should " +
+			"have failed")
+	}
+}
+
+// TestBlockTxnFromBlock tests that blocktxn messages built from a block hold
+// the transactions requested by a getblocktxn message.
+func TestBlockTxnFromBlock(t *testing.T) {
+	block := btcwire.NewMsgBlock(&blockOne.Header)
+	block.AddTransaction(blockOne.Transactions[0])
+	block.AddTransaction(multiTx)
+	block.AddTransaction(witnessTx)
+	hash, err := block.BlockSha(btcwire.ProtocolVersion)
+	if err != nil {
+		t.Errorf("BlockSha: %v", err)
+		return
+	}
+
+	req := btcwire.NewMsgGetBlockTxn(&hash)
+	req.AddIndex(0)
+	req.AddIndex(2)
+	msg, err := btcwire.NewMsgBlockTxnFromBlock(block, req)
+	if err != nil {
+		t.Errorf("NewMsgBlockTxnFromBlock: %v", err)
+		return
+	}
+	want := &btcwire.MsgBlockTxn{
+		BlockHash: hash,
+		Transactions: []*btcwire.MsgTx{
+			blockOne.Transactions[0], witnessTx,
+		},
+	}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("NewMsgBlockTxnFromBlock: wrong message - got %v, "+
+			"want %v", spew.Sdump(msg), spew.Sdump(want))
+	}
+
+	// Ensure requests for indexes past the end of the block are rejected.
+	req.AddIndex(3)
+	_, err = btcwire.NewMsgBlockTxnFromBlock(block, req)
+	if _, ok := err.(*btcwire.MessageError); !ok {
+		t.Errorf("NewMsgBlockTxnFromBlock: wrong error for index "+
+			"past the end of the block - got %v <%T>", err, err)
+	}
+}
+
+// TestBlockTxnWire tests the MsgBlockTxn wire encode and decode.
+func TestBlockTxnWire(t *testing.T) {
+	// Message with no transactions.
+	noTxs := btcwire.NewMsgBlockTxn(&blockTxnOne.BlockHash)
+	noTxsBytes := joinBytes(blockTxnOneBytes[:32], []byte{
+		0x00, // Varint for number of transactions
+	})
+
+	// Message with a transaction which has witness data.
+	witnessBlockTxn := &btcwire.MsgBlockTxn{
+		BlockHash:    blockTxnOne.BlockHash,
+		Transactions: []*btcwire.MsgTx{witnessTx},
+	}
+	witnessBlockTxnBytes := joinBytes(blockTxnOneBytes[:32], []byte{
+		0x01, // Varint for number of transactions
+	}, witnessTxEncoded)
+
+	tests := []struct {
+		in   *btcwire.MsgBlockTxn // Message to encode
+		out  *btcwire.MsgBlockTxn // Expected decoded message
+		buf  []byte               // Wire encoding
+		pver uint32               // Protocol version for wire encoding
+	}{
+		// Protocol version SendCmpctVersion with no transactions.
+		{
+			noTxs,
+			noTxs,
+			noTxsBytes,
+			btcwire.SendCmpctVersion,
+		},
+
+		// Protocol version SendCmpctVersion with a transaction.
+		{
+			&blockTxnOne,
+			&blockTxnOne,
+			blockTxnOneBytes,
+			btcwire.SendCmpctVersion,
+		},
+
+		// Protocol version SendCmpctVersion with a witness transaction.
+		{
+			witnessBlockTxn,
+			witnessBlockTxn,
+			witnessBlockTxnBytes,
+			btcwire.SendCmpctVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg btcwire.MsgBlockTxn
+		rbuf := bytes.NewBuffer(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestBlockTxnWireErrors performs negative tests against wire encode and
+// decode of MsgBlockTxn to confirm error paths work correctly.
+func TestBlockTxnWireErrors(t *testing.T) {
+	pver := btcwire.SendCmpctVersion
+	btcwireErr := &btcwire.MessageError{}
+
+	// Message with more transactions than fit in a block.  The
+	// transactions are not provided since they must not be read.
+	tooManyTxs := &btcwire.MsgBlockTxn{
+		Transactions: make([]*btcwire.MsgTx, btcwire.MaxBlockPayload/10+2),
+	}
+	tooManyTxsBytes := joinBytes(make([]byte, 32), []byte{
+		0xfe, 0x9b, 0x99, 0x01, 0x00, // Varint for number of transactions
+	})
+
+	tests := []struct {
+		in       *btcwire.MsgBlockTxn // Value to encode
+		buf      []byte               // Wire encoding
+		pver     uint32               // Protocol version for wire encoding
+		max      int                  // Max size of fixed buffer to induce errors
+		writeErr error                // Expected write error
+		readErr  error                // Expected read error
+	}{
+		// Force error in block hash.
+		{&blockTxnOne, blockTxnOneBytes, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in num transactions.
+		{&blockTxnOne, blockTxnOneBytes, pver, 32, io.ErrShortWrite, io.EOF},
+		// Force error in transactions.
+		{&blockTxnOne, blockTxnOneBytes, pver, 33, io.ErrShortWrite, io.EOF},
+		// Force error with greater than max transactions per block.
+		{tooManyTxs, tooManyTxsBytes, pver, len(tooManyTxsBytes),
+			btcwireErr, btcwireErr},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg btcwire.MsgBlockTxn
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
+
+// blockTxnOne is a blocktxn message which holds the coinbase of block one of
+// the block chain.
+var blockTxnOne = btcwire.MsgBlockTxn{
+	BlockHash:    blockOne.Header.PrevBlock,
+	Transactions: []*btcwire.MsgTx{blockOne.Transactions[0]},
+}
+
+// blockTxnOneBytes is the serialized bytes for blockTxnOne.
+var blockTxnOneBytes = joinBytes(
+	blockOneBytes[4:36], // Block hash
+	[]byte{
+		0x01, // Varint for number of transactions
+	},
+	blockOneBytes[81:], // Coinbase
+)
@@ -0,0 +1,166 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+const (
+	// CFCheckptInterval is the gap (in number of blocks) between each
+	// filter header checkpoint.
+	CFCheckptInterval = 1000
+
+	// maxCFHeadersLen is the maximum number of filter headers allowed in a
+	// single cfcheckpt message.  Since there is one checkpoint every
+	// CFCheckptInterval blocks, it allows for a block chain of up to 100
+	// million blocks.
+	maxCFHeadersLen = 100000
+)
+
+// MsgCFCheckpt implements the Message interface and represents a bitcoin
+// cfcheckpt message as defined by BIP0157.  It is sent in response to a
+// getcfcheckpt message (MsgGetCFCheckpt) and holds the filter header of every
+// CFCheckptInterval-th block up to the requested block, which allows a client
+// to fetch the filter headers between the checkpoints from several peers in
+// parallel.
+//
+// This message was not added until protocol version CFilterVersion.
+type MsgCFCheckpt struct {
+	FilterType    FilterType
+	StopHash      ShaHash
+	FilterHeaders []*ShaHash
+}
+
+// AddCFHeader adds a new committed filter header to the message.
+func (msg *MsgCFCheckpt) AddCFHeader(header *ShaHash) error {
+	if len(msg.FilterHeaders)+1 > maxCFHeadersLen {
+		str := fmt.Sprintf("too many filter headers in message [max %v]",
+			maxCFHeadersLen)
+		return messageError("MsgCFCheckpt.AddCFHeader", ErrTooManyItems, str)
+	}
+
+	msg.FilterHeaders = append(msg.FilterHeaders, header)
+	return nil
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgCFCheckpt) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < CFilterVersion {
+		str := fmt.Sprintf("cfcheckpt message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCFCheckpt.BtcDecode", ErrProtocolVersion, str)
+	}
+
+	err := readElements(r, &msg.FilterType, &msg.StopHash)
+	if err != nil {
+		return err
+	}
+
+	count, err := readVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+
+	// Refuse to decode an insane number of filter headers.
+	if count > maxCFHeadersLen {
+		str := fmt.Sprintf("too many filter headers for message "+
+			"[count %v, max %v]", count, maxCFHeadersLen)
+		return messageError("MsgCFCheckpt.BtcDecode", ErrTooManyItems, str)
+	}
+
+	// Deserialize into contiguous chunks of hashes in order to reduce the
+	// number of allocations.
+	var headers []ShaHash
+	msg.FilterHeaders = make([]*ShaHash, 0, preallocCount(count,
+		unsafe.Sizeof((*ShaHash)(nil))))
+	for i := uint64(0); i < count; i++ {
+		if len(headers) == 0 {
+			headers = make([]ShaHash, preallocCount(count-i, HashSize))
+		}
+		header := &headers[0]
+		headers = headers[1:]
+		err := readElement(r, header)
+		if err != nil {
+			return err
+		}
+		msg.FilterHeaders = append(msg.FilterHeaders, header)
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgCFCheckpt) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < CFilterVersion {
+		str := fmt.Sprintf("cfcheckpt message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCFCheckpt.BtcEncode", ErrProtocolVersion, str)
+	}
+
+	count := len(msg.FilterHeaders)
+	if count > maxCFHeadersLen {
+		str := fmt.Sprintf("too many filter headers for message "+
+			"[count %v, max %v]", count, maxCFHeadersLen)
+		return messageError("MsgCFCheckpt.BtcEncode", ErrTooManyItems, str)
+	}
+
+	err := writeElements(w, msg.FilterType, msg.StopHash)
+	if err != nil {
+		return err
+	}
+
+	err = writeVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+
+	for _, header := range msg.FilterHeaders {
+		err := writeElement(w, header)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgCFCheckpt) Command() string {
+	return cmdCFCheckpt
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgCFCheckpt) String() string {
+	return fmt.Sprintf("%s type=%v stop=%v headers=%d", msg.Command(),
+		msg.FilterType, msg.StopHash, len(msg.FilterHeaders))
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgCFCheckpt) MaxPayloadLength(pver uint32) uint32 {
+	// Filter type 1 byte + stop hash + num filter headers (varInt) + max
+	// allowed filter headers.
+	return 1 + HashSize + maxVarIntPayload + (maxCFHeadersLen * HashSize)
+}
+
+// NewMsgCFCheckpt returns a new bitcoin cfcheckpt message that conforms to the
+// Message interface using the passed filter type and stop hash.  The
+// headersCount is used as a hint for the number of filter headers which will
+// be added.  See MsgCFCheckpt for details.
+func NewMsgCFCheckpt(filterType FilterType, stopHash *ShaHash,
+	headersCount int) *MsgCFCheckpt {
+
+	return &MsgCFCheckpt{
+		FilterType:    filterType,
+		StopHash:      *stopHash,
+		FilterHeaders: make([]*ShaHash, 0, headersCount),
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestCFCheckpt tests the MsgCFCheckpt API.
+func TestCFCheckpt(t *testing.T) {
+	pver := btcwire.CFilterVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "cfcheckpt"
+	msg := btcwire.NewMsgCFCheckpt(btcwire.GCSFilterRegular,
+		&baseCFCheckpt.StopHash, len(baseCFCheckpt.FilterHeaders))
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgCFCheckpt: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Filter type 1 byte + stop hash 32 bytes + num filter headers
+	// (varInt) + max allowed filter headers.
+	wantPayload := uint32(3200042)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure filter headers are added properly.
+	for _, header := range baseCFCheckpt.FilterHeaders {
+		err := msg.AddCFHeader(header)
+		if err != nil {
+			t.Errorf("AddCFHeader: %v", err)
+		}
+	}
+	if !reflect.DeepEqual(msg, baseCFCheckpt) {
+		t.Errorf("AddCFHeader: wrong message - got %v, want %v",
+			spew.Sdump(msg), spew.Sdump(baseCFCheckpt))
+	}
+
+	// Older protocol versions should fail encode and decode since the
+	// message didn't exist yet.
+	oldPver := btcwire.CFilterVersion - 1
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, oldPver)
+	if err == nil {
+		t.Errorf("encode of MsgCFCheckpt succeeded when it should " +
+			"have failed")
+	}
+	var readmsg btcwire.MsgCFCheckpt
+	err = readmsg.BtcDecode(bytes.NewBuffer(baseCFCheckptEncoded), oldPver)
+	if err == nil {
+		t.Errorf("decode of MsgCFCheckpt succeeded when it should " +
+			"have failed")
+	}
+}
+
+// TestCFCheckptWire tests the MsgCFCheckpt wire encode and decode.
+func TestCFCheckptWire(t *testing.T) {
+	// Message with no filter headers.
+	noHeaders := btcwire.NewMsgCFCheckpt(btcwire.GCSFilterRegular,
+		&baseCFCheckpt.StopHash, 0)
+	noHeadersEncoded := joinBytes(baseCFCheckptEncoded[:33], []byte{
+		0x00, // Varint for number of filter headers
+	})
+
+	tests := []struct {
+		in   *btcwire.MsgCFCheckpt // Message to encode
+		out  *btcwire.MsgCFCheckpt // Expected decoded message
+		buf  []byte                // Wire encoding
+		pver uint32                // Protocol version for wire encoding
+	}{
+		// Protocol version CFilterVersion with no filter headers.
+		{
+			noHeaders,
+			noHeaders,
+			noHeadersEncoded,
+			btcwire.CFilterVersion,
+		},
+
+		// Protocol version CFilterVersion with filter headers.
+		{
+			baseCFCheckpt,
+			baseCFCheckpt,
+			baseCFCheckptEncoded,
+			btcwire.CFilterVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg btcwire.MsgCFCheckpt
+		rbuf := bytes.NewBuffer(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestCFCheckptWireErrors performs negative tests against wire encode and
+// decode of MsgCFCheckpt to confirm error paths work correctly.
+func TestCFCheckptWireErrors(t *testing.T) {
+	pver := btcwire.CFilterVersion
+	btcwireErr := &btcwire.MessageError{}
+
+	// Message with more filter headers than allowed.  The filter headers
+	// are not provided since they must not be read.
+	tooManyHeaders := &btcwire.MsgCFCheckpt{
+		FilterHeaders: make([]*btcwire.ShaHash, 100001),
+	}
+	tooManyHeadersEncoded := joinBytes(make([]byte, 33), []byte{
+		0xfe, 0xa1, 0x86, 0x01, 0x00, // Varint for number of filter headers
+	})
+
+	tests := []struct {
+		in       *btcwire.MsgCFCheckpt // Value to encode
+		buf      []byte                // Wire encoding
+		pver     uint32                // Protocol version for wire encoding
+		max      int                   // Max size of fixed buffer to induce errors
+		writeErr error                 // Expected write error
+		readErr  error                 // Expected read error
+	}{
+		// Force error in filter type.
+		{baseCFCheckpt, baseCFCheckptEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in stop hash.
+		{baseCFCheckpt, baseCFCheckptEncoded, pver, 1, io.ErrShortWrite, io.EOF},
+		// Force error in num filter headers.
+		{baseCFCheckpt, baseCFCheckptEncoded, pver, 33, io.ErrShortWrite, io.EOF},
+		// Force error in filter headers.
+		{baseCFCheckpt, baseCFCheckptEncoded, pver, 34, io.ErrShortWrite, io.EOF},
+		// Force error with greater than max filter headers.
+		{tooManyHeaders, tooManyHeadersEncoded, pver,
+			len(tooManyHeadersEncoded), btcwireErr, btcwireErr},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg btcwire.MsgCFCheckpt
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
+
+// baseCFCheckpt is used in the various tests as a baseline MsgCFCheckpt.
+var baseCFCheckpt = &btcwire.MsgCFCheckpt{
+	FilterType: btcwire.GCSFilterRegular,
+	StopHash:   blockOne.Header.PrevBlock,
+	FilterHeaders: []*btcwire.ShaHash{
+		&blockOne.Header.MerkleRoot,
+		&blockOne.Header.PrevBlock,
+	},
+}
+
+// baseCFCheckptEncoded is the wire encoded bytes for baseCFCheckpt using
+// protocol version CFilterVersion and is used in the various tests.
+var baseCFCheckptEncoded = joinBytes(
+	[]byte{
+		0x00, // Filter type
+	},
+	blockOneBytes[4:36], // Stop hash
+	[]byte{
+		0x02, // Varint for number of filter headers
+	},
+	blockOneBytes[36:68], // Filter header
+	blockOneBytes[4:36],  // Filter header
+)
@@ -0,0 +1,167 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+const (
+	// MaxCFHeaderPayload is the maximum byte size of a committed filter
+	// header.
+	MaxCFHeaderPayload = HashSize
+
+	// MaxCFHeadersPerMsg is the maximum number of committed filter headers
+	// that can be in a single bitcoin cfheaders message.
+	MaxCFHeadersPerMsg = 2000
+)
+
+// MsgCFHeaders implements the Message interface and represents a bitcoin
+// cfheaders message as defined by BIP0157.  It is sent in response to a
+// getcfheaders message (MsgGetCFHeaders) and holds the filter header of the
+// block before the requested range along with the hash of the filter of each
+// block in the range.  The filter headers of the range can be derived from
+// them since each one is the hash of the filter hash and the previous filter
+// header.
+//
+// This message was not added until protocol version CFilterVersion.
+type MsgCFHeaders struct {
+	FilterType       FilterType
+	StopHash         ShaHash
+	PrevFilterHeader ShaHash
+	FilterHashes     []*ShaHash
+}
+
+// AddCFHash adds a new filter hash to the message.
+func (msg *MsgCFHeaders) AddCFHash(hash *ShaHash) error {
+	if len(msg.FilterHashes)+1 > MaxCFHeadersPerMsg {
+		str := fmt.Sprintf("too many filter hashes in message [max %v]",
+			MaxCFHeadersPerMsg)
+		return messageError("MsgCFHeaders.AddCFHash", ErrTooManyItems, str)
+	}
+
+	msg.FilterHashes = append(msg.FilterHashes, hash)
+	return nil
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgCFHeaders) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < CFilterVersion {
+		str := fmt.Sprintf("cfheaders message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCFHeaders.BtcDecode", ErrProtocolVersion, str)
+	}
+
+	err := readElements(r, &msg.FilterType, &msg.StopHash,
+		&msg.PrevFilterHeader)
+	if err != nil {
+		return err
+	}
+
+	count, err := readVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+
+	// Limit to max committed filter headers per message.
+	if count > MaxCFHeadersPerMsg {
+		str := fmt.Sprintf("too many committed filter headers for "+
+			"message [count %v, max %v]", count,
+			MaxCFHeadersPerMsg)
+		return messageError("MsgCFHeaders.BtcDecode", ErrTooManyItems, str)
+	}
+
+	// Deserialize into contiguous chunks of hashes in order to reduce the
+	// number of allocations.
+	var hashes []ShaHash
+	msg.FilterHashes = make([]*ShaHash, 0, preallocCount(count,
+		unsafe.Sizeof((*ShaHash)(nil))))
+	for i := uint64(0); i < count; i++ {
+		if len(hashes) == 0 {
+			hashes = make([]ShaHash, preallocCount(count-i, HashSize))
+		}
+		hash := &hashes[0]
+		hashes = hashes[1:]
+		err := readElement(r, hash)
+		if err != nil {
+			return err
+		}
+		msg.AddCFHash(hash)
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgCFHeaders) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < CFilterVersion {
+		str := fmt.Sprintf("cfheaders message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCFHeaders.BtcEncode", ErrProtocolVersion, str)
+	}
+
+	// Limit to max committed filter headers per message.
+	count := len(msg.FilterHashes)
+	if count > MaxCFHeadersPerMsg {
+		str := fmt.Sprintf("too many committed filter headers for "+
+			"message [count %v, max %v]", count,
+			MaxCFHeadersPerMsg)
+		return messageError("MsgCFHeaders.BtcEncode", ErrTooManyItems, str)
+	}
+
+	err := writeElements(w, msg.FilterType, msg.StopHash,
+		msg.PrevFilterHeader)
+	if err != nil {
+		return err
+	}
+
+	err = writeVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range msg.FilterHashes {
+		err := writeElement(w, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgCFHeaders) Command() string {
+	return cmdCFHeaders
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgCFHeaders) String() string {
+	return fmt.Sprintf("%s type=%v stop=%v prev=%v hashes=%d",
+		msg.Command(), msg.FilterType, msg.StopHash, msg.PrevFilterHeader,
+		len(msg.FilterHashes))
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgCFHeaders) MaxPayloadLength(pver uint32) uint32 {
+	// Filter type 1 byte + stop hash + previous filter header + num filter
+	// hashes (varInt) + max allowed filter hashes.
+	return 1 + HashSize + MaxCFHeaderPayload + maxVarIntPayload +
+		(MaxCFHeadersPerMsg * HashSize)
+}
+
+// NewMsgCFHeaders returns a new bitcoin cfheaders message that conforms to
+// the Message interface.  See MsgCFHeaders for details.
+func NewMsgCFHeaders() *MsgCFHeaders {
+	return &MsgCFHeaders{
+		FilterHashes: make([]*ShaHash, 0, MaxCFHeadersPerMsg),
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestCFHeaders tests the MsgCFHeaders API.
+func TestCFHeaders(t *testing.T) {
+	pver := btcwire.CFilterVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "cfheaders"
+	msg := btcwire.NewMsgCFHeaders()
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgCFHeaders: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Filter type 1 byte + stop hash 32 bytes + previous filter header 32
+	// bytes + num filter hashes (varInt) + max allowed filter hashes.
+	wantPayload := uint32(64074)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure filter hashes are added properly.
+	hash := &blockOne.Header.MerkleRoot
+	err := msg.AddCFHash(hash)
+	if err != nil {
+		t.Errorf("AddCFHash: %v", err)
+	}
+	if msg.FilterHashes[0] != hash {
+		t.Errorf("AddCFHash: wrong filter hash added - got %v, want %v",
+			spew.Sprint(msg.FilterHashes[0]), spew.Sprint(hash))
+	}
+
+	// Ensure adding more than the max allowed filter hashes per message
+	// returns an error.
+	for i := 0; i < btcwire.MaxCFHeadersPerMsg; i++ {
+		err = msg.AddCFHash(hash)
+	}
+	if err == nil {
+		t.Errorf("AddCFHash: expected error on too many filter hashes " +
+			"not received")
+	}
+
+	// Ensure encoding too many filter hashes returns an error.
+	msg.FilterHashes = append(msg.FilterHashes, hash)
+	var buf bytes.Buffer
+	err = msg.BtcEncode(&buf, pver)
+	if _, ok := err.(*btcwire.MessageError); !ok {
+		t.Errorf("BtcEncode: wrong error for too many filter hashes - "+
+			"got %v <%T>", err, err)
+	}
+
+	// Older protocol versions should fail encode and decode since the
+	// message didn't exist yet.
+	oldPver := btcwire.CFilterVersion - 1
+	buf.Reset()
+	err = baseCFHeaders.BtcEncode(&buf, oldPver)
+	if err == nil {
+		t.Errorf("encode of MsgCFHeaders succeeded when it should " +
+			"have failed")
+	}
+	var readmsg btcwire.MsgCFHeaders
+	err = readmsg.BtcDecode(bytes.NewBuffer(baseCFHeadersEncoded), oldPver)
+	if err == nil {
+		t.Errorf("decode of MsgCFHeaders succeeded when it should " +
+			"have failed")
+	}
+}
+
+// TestCFHeadersWire tests the MsgCFHeaders wire encode and decode.
+func TestCFHeadersWire(t *testing.T) {
+	// Message with no filter hashes.
+	noHashes := btcwire.NewMsgCFHeaders()
+	noHashesEncoded := make([]byte, 66)
+
+	tests := []struct {
+		in   *btcwire.MsgCFHeaders // Message to encode
+		out  *btcwire.MsgCFHeaders // Expected decoded message
+		buf  []byte                // Wire encoding
+		pver uint32                // Protocol version for wire encoding
+	}{
+		// Protocol version CFilterVersion with no filter hashes.
+		{
+			noHashes,
+			noHashes,
+			noHashesEncoded,
+			btcwire.CFilterVersion,
+		},
+
+		// Protocol version CFilterVersion with filter hashes.
+		{
+			baseCFHeaders,
+			baseCFHeaders,
+			baseCFHeadersEncoded,
+			btcwire.CFilterVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg btcwire.MsgCFHeaders
+		rbuf := bytes.NewBuffer(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestCFHeadersWireErrors performs negative tests against wire encode and
+// decode of MsgCFHeaders to confirm error paths work correctly.
+func TestCFHeadersWireErrors(t *testing.T) {
+	pver := btcwire.CFilterVersion
+	btcwireErr := &btcwire.MessageError{}
+
+	// Message with more filter hashes than allowed.  The filter hashes are
+	// not provided since they must not be read.
+	tooManyHashes := &btcwire.MsgCFHeaders{
+		FilterHashes: make([]*btcwire.ShaHash, btcwire.MaxCFHeadersPerMsg+1),
+	}
+	tooManyHashesEncoded := joinBytes(make([]byte, 65), []byte{
+		0xfd, 0xd1, 0x07, // Varint for number of filter hashes (2001)
+	})
+
+	tests := []struct {
+		in       *btcwire.MsgCFHeaders // Value to encode
+		buf      []byte                // Wire encoding
+		pver     uint32                // Protocol version for wire encoding
+		max      int                   // Max size of fixed buffer to induce errors
+		writeErr error                 // Expected write error
+		readErr  error                 // Expected read error
+	}{
+		// Force error in filter type.
+		{baseCFHeaders, baseCFHeadersEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in stop hash.
+		{baseCFHeaders, baseCFHeadersEncoded, pver, 1, io.ErrShortWrite, io.EOF},
+		// Force error in previous filter header.
+		{baseCFHeaders, baseCFHeadersEncoded, pver, 33, io.ErrShortWrite, io.EOF},
+		// Force error in num filter hashes.
+		{baseCFHeaders, baseCFHeadersEncoded, pver, 65, io.ErrShortWrite, io.EOF},
+		// Force error in filter hashes.
+		{baseCFHeaders, baseCFHeadersEncoded, pver, 66, io.ErrShortWrite, io.EOF},
+		// Force error with greater than max filter hashes.
+		{tooManyHashes, tooManyHashesEncoded, pver,
+			len(tooManyHashesEncoded), btcwireErr, btcwireErr},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg btcwire.MsgCFHeaders
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
+
+// baseCFHeaders is used in the various tests as a baseline MsgCFHeaders.
+var baseCFHeaders = &btcwire.MsgCFHeaders{
+	FilterType:       btcwire.GCSFilterRegular,
+	StopHash:         blockOne.Header.PrevBlock,
+	PrevFilterHeader: blockOne.Header.MerkleRoot,
+	FilterHashes: []*btcwire.ShaHash{
+		&blockOne.Header.MerkleRoot,
+		&blockOne.Header.PrevBlock,
+	},
+}
+
+// baseCFHeadersEncoded is the wire encoded bytes for baseCFHeaders using
+// protocol version CFilterVersion and is used in the various tests.
+var baseCFHeadersEncoded = joinBytes(
+	[]byte{
+		0x00, // Filter type
+	},
+	blockOneBytes[4:36],  // Stop hash
+	blockOneBytes[36:68], // Previous filter header
+	[]byte{
+		0x02, // Varint for number of filter hashes
+	},
+	blockOneBytes[36:68], // Filter hash
+	blockOneBytes[4:36],  // Filter hash
+)
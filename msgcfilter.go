@@ -0,0 +1,136 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	// MaxCFilterDataSize is the maximum byte size of a committed filter.
+	// The maximum size is currently defined as 256KiB.
+	MaxCFilterDataSize = 256 * 1024
+)
+
+// FilterType is used to represent the type of a compact block filter as
+// defined by BIP0158.
+type FilterType uint8
+
+const (
+	// GCSFilterRegular is the regular filter type which commits to the
+	// output scripts of a block and the previous output scripts it spends.
+	GCSFilterRegular FilterType = 0
+)
+
+// Map of filter types back to their constant names for pretty printing.
+var filterTypeStrings = map[FilterType]string{
+	GCSFilterRegular: "GCSFilterRegular",
+}
+
+// String returns the FilterType in human-readable form.
+func (t FilterType) String() string {
+	if s, ok := filterTypeStrings[t]; ok {
+		return s
+	}
+
+	return fmt.Sprintf("Unknown FilterType (%d)", uint8(t))
+}
+
+// MsgCFilter implements the Message interface and represents a bitcoin cfilter
+// message as defined by BIP0157.  It is sent in response to a getcfilters
+// message (MsgGetCFilters) and holds the compact filter of a single block.
+//
+// This message was not added until protocol version CFilterVersion.
+type MsgCFilter struct {
+	FilterType FilterType
+	BlockHash  ShaHash
+	Data       []byte
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgCFilter) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < CFilterVersion {
+		str := fmt.Sprintf("cfilter message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCFilter.BtcDecode", ErrProtocolVersion, str)
+	}
+
+	err := readElements(r, &msg.FilterType, &msg.BlockHash)
+	if err != nil {
+		return err
+	}
+
+	msg.Data, err = readVarBytes(r, pver, MaxCFilterDataSize,
+		"cfilter data")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgCFilter) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < CFilterVersion {
+		str := fmt.Sprintf("cfilter message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCFilter.BtcEncode", ErrProtocolVersion, str)
+	}
+
+	size := len(msg.Data)
+	if size > MaxCFilterDataSize {
+		str := fmt.Sprintf("cfilter size too large for message "+
+			"[size %v, max %v]", size, MaxCFilterDataSize)
+		return messageError("MsgCFilter.BtcEncode", ErrPayloadTooLarge, str)
+	}
+
+	err := writeElements(w, msg.FilterType, msg.BlockHash)
+	if err != nil {
+		return err
+	}
+
+	err = writeVarBytes(w, pver, msg.Data)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgCFilter) Command() string {
+	return cmdCFilter
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgCFilter) String() string {
+	return fmt.Sprintf("%s type=%v block=%v size=%d", msg.Command(),
+		msg.FilterType, msg.BlockHash, len(msg.Data))
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgCFilter) MaxPayloadLength(pver uint32) uint32 {
+	// Filter type 1 byte + block hash + num filter bytes (varInt) + max
+	// filter size.
+	return 1 + HashSize + maxVarIntPayload + MaxCFilterDataSize
+}
+
+// NewMsgCFilter returns a new bitcoin cfilter message that conforms to the
+// Message interface using the passed filter type, block hash, and filter data.
+// See MsgCFilter for details.
+func NewMsgCFilter(filterType FilterType, blockHash *ShaHash,
+	data []byte) *MsgCFilter {
+
+	return &MsgCFilter{
+		FilterType: filterType,
+		BlockHash:  *blockHash,
+		Data:       data,
+	}
+}
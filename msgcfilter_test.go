@@ -0,0 +1,247 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestFilterTypeStringer tests the stringized output for filter types.
+func TestFilterTypeStringer(t *testing.T) {
+	tests := []struct {
+		in   btcwire.FilterType
+		want string
+	}{
+		{btcwire.GCSFilterRegular, "GCSFilterRegular"},
+		{0xff, "Unknown FilterType (255)"},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		result := test.in.String()
+		if result != test.want {
+			t.Errorf("String #%d\n got: %s want: %s", i, result,
+				test.want)
+			continue
+		}
+	}
+}
+
+// TestCFilter tests the MsgCFilter API.
+func TestCFilter(t *testing.T) {
+	pver := btcwire.CFilterVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "cfilter"
+	msg := btcwire.NewMsgCFilter(btcwire.GCSFilterRegular,
+		&baseCFilter.BlockHash, baseCFilter.Data)
+	if !reflect.DeepEqual(msg, baseCFilter) {
+		t.Errorf("NewMsgCFilter: wrong message - got %v, want %v",
+			spew.Sdump(msg), spew.Sdump(baseCFilter))
+	}
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgCFilter: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Filter type 1 byte + block hash 32 bytes + num filter bytes
+	// (varInt) + max filter size.
+	wantPayload := uint32(262186)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Older protocol versions should fail encode and decode since the
+	// message didn't exist yet.
+	oldPver := btcwire.CFilterVersion - 1
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, oldPver)
+	if err == nil {
+		t.Errorf("encode of MsgCFilter succeeded when it should " +
+			"have failed")
+	}
+	var readmsg btcwire.MsgCFilter
+	err = readmsg.BtcDecode(bytes.NewBuffer(baseCFilterEncoded), oldPver)
+	if err == nil {
+		t.Errorf("decode of MsgCFilter succeeded when it should " +
+			"have failed")
+	}
+}
+
+// TestCFilterWire tests the MsgCFilter wire encode and decode.
+func TestCFilterWire(t *testing.T) {
+	// Filter message for a block with an empty filter.
+	emptyCFilter := &btcwire.MsgCFilter{
+		FilterType: btcwire.GCSFilterRegular,
+		BlockHash:  baseCFilter.BlockHash,
+		Data:       []byte{},
+	}
+	emptyCFilterEncoded := joinBytes(baseCFilterEncoded[:33], []byte{
+		0x00, // Varint for size of filter
+	})
+
+	tests := []struct {
+		in   *btcwire.MsgCFilter // Message to encode
+		out  *btcwire.MsgCFilter // Expected decoded message
+		buf  []byte              // Wire encoding
+		pver uint32              // Protocol version for wire encoding
+	}{
+		// Protocol version CFilterVersion.
+		{
+			baseCFilter,
+			baseCFilter,
+			baseCFilterEncoded,
+			btcwire.CFilterVersion,
+		},
+
+		// Protocol version CFilterVersion with an empty filter.
+		{
+			emptyCFilter,
+			emptyCFilter,
+			emptyCFilterEncoded,
+			btcwire.CFilterVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg btcwire.MsgCFilter
+		rbuf := bytes.NewBuffer(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestCFilterWireErrors performs negative tests against wire encode and
+// decode of MsgCFilter to confirm error paths work correctly.
+func TestCFilterWireErrors(t *testing.T) {
+	pver := btcwire.CFilterVersion
+	btcwireErr := &btcwire.MessageError{}
+
+	// Message with a filter which exceeds the max allowed size.  The
+	// encoded form only contains the varint for the filter size
+	// (MaxCFilterDataSize + 1) since decoding must fail before the filter
+	// itself is read.
+	exceedFilterSize := &btcwire.MsgCFilter{
+		Data: make([]byte, btcwire.MaxCFilterDataSize+1),
+	}
+	exceedFilterSizeEncoded := joinBytes(make([]byte, 33), []byte{
+		0xfe, 0x01, 0x00, 0x04, 0x00, // Varint for size of filter
+	})
+
+	tests := []struct {
+		in       *btcwire.MsgCFilter // Value to encode
+		buf      []byte              // Wire encoding
+		pver     uint32              // Protocol version for wire encoding
+		max      int                 // Max size of fixed buffer to induce errors
+		writeErr error               // Expected write error
+		readErr  error               // Expected read error
+	}{
+		// Force error in filter type.
+		{baseCFilter, baseCFilterEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in block hash.
+		{baseCFilter, baseCFilterEncoded, pver, 1, io.ErrShortWrite, io.EOF},
+		// Force error in filter size.
+		{baseCFilter, baseCFilterEncoded, pver, 33, io.ErrShortWrite, io.EOF},
+		// Force error in filter.
+		{baseCFilter, baseCFilterEncoded, pver, 34, io.ErrShortWrite, io.EOF},
+		// Force error due to filter too large.
+		{exceedFilterSize, exceedFilterSizeEncoded, pver,
+			len(exceedFilterSizeEncoded), btcwireErr, btcwireErr},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg btcwire.MsgCFilter
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
+
+// baseCFilter is used in the various tests as a baseline MsgCFilter.
+var baseCFilter = &btcwire.MsgCFilter{
+	FilterType: btcwire.GCSFilterRegular,
+	BlockHash:  blockOne.Header.PrevBlock,
+	Data:       []byte{0x01, 0x44, 0x2b, 0x40},
+}
+
+// baseCFilterEncoded is the wire encoded bytes for baseCFilter using protocol
+// version CFilterVersion and is used in the various tests.
+var baseCFilterEncoded = joinBytes(
+	[]byte{
+		0x00, // Filter type
+	},
+	blockOneBytes[4:36], // Block hash
+	[]byte{
+		0x04,                   // Varint for size of filter
+		0x01, 0x44, 0x2b, 0x40, // Filter
+	},
+)
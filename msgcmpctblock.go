@@ -0,0 +1,353 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// shortTxIDSize is the number of bytes a short transaction id is encoded with.
+const shortTxIDSize = 6
+
+// PrefilledTx defines a transaction which is sent in full as part of a compact
+// block (MsgCmpctBlock) since the receiver is unlikely to already have it, such
+// as the coinbase.
+//
+// Index is the position of the transaction within the block.  It is
+// differentially encoded on the wire as the number of transactions since the
+// previous prefilled transaction, but is always the absolute position here.
+type PrefilledTx struct {
+	Index uint32
+	Tx    *MsgTx
+}
+
+// MsgCmpctBlock implements the Message interface and represents a bitcoin
+// cmpctblock message as defined by BIP0152.  It relays a block as its header
+// along with the short transaction ids (see ShortTxID) of the transactions the
+// receiver most likely already has and the full transactions it likely does
+// not.  The receiver reconstructs the block from its memory pool and requests
+// any transactions it is still missing.
+//
+// Like MsgMerkleBlock, the header is encoded without the number of
+// transactions, so Header.TxnCount is not encoded.  The number of
+// transactions in the block is the number of short ids plus the number of
+// prefilled transactions.  See TxCount.
+//
+// This message was not added until protocol version SendCmpctVersion.
+type MsgCmpctBlock struct {
+	Header       BlockHeader
+	Nonce        uint64
+	ShortIDs     []uint64
+	PrefilledTxs []*PrefilledTx
+}
+
+// TxCount returns the number of transactions in the block the message relays.
+func (msg *MsgCmpctBlock) TxCount() int {
+	return len(msg.ShortIDs) + len(msg.PrefilledTxs)
+}
+
+// ShortID returns the short transaction id of the transaction with the passed
+// witness hash for the header and nonce of the message.
+func (msg *MsgCmpctBlock) ShortID(wtxid *ShaHash) uint64 {
+	return ShortTxID(&msg.Header, msg.Nonce, wtxid)
+}
+
+// AddShortID adds a new short transaction id to the message.
+func (msg *MsgCmpctBlock) AddShortID(id uint64) error {
+	if id > ShortTxIDMask {
+		str := fmt.Sprintf("short transaction id %x is larger than %d "+
+			"bytes", id, shortTxIDSize)
+		return messageError("MsgCmpctBlock.AddShortID", ErrMalformed, str)
+	}
+	if msg.TxCount()+1 > maxTxPerBlock {
+		str := fmt.Sprintf("too many transactions for message [max %v]",
+			maxTxPerBlock)
+		return messageError("MsgCmpctBlock.AddShortID", ErrTooManyItems, str)
+	}
+
+	msg.ShortIDs = append(msg.ShortIDs, id)
+	return nil
+}
+
+// AddPrefilledTx adds a new prefilled transaction to the message.  Prefilled
+// transactions must be added in order of increasing index.
+func (msg *MsgCmpctBlock) AddPrefilledTx(ptx *PrefilledTx) error {
+	if n := len(msg.PrefilledTxs); n > 0 &&
+		ptx.Index <= msg.PrefilledTxs[n-1].Index {
+
+		str := fmt.Sprintf("prefilled transaction index %d does not "+
+			"follow index %d", ptx.Index, msg.PrefilledTxs[n-1].Index)
+		return messageError("MsgCmpctBlock.AddPrefilledTx", ErrMalformed, str)
+	}
+	if msg.TxCount()+1 > maxTxPerBlock {
+		str := fmt.Sprintf("too many transactions for message [max %v]",
+			maxTxPerBlock)
+		return messageError("MsgCmpctBlock.AddPrefilledTx", ErrTooManyItems, str)
+	}
+
+	msg.PrefilledTxs = append(msg.PrefilledTxs, ptx)
+	return nil
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < SendCmpctVersion {
+		str := fmt.Sprintf("cmpctblock message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCmpctBlock.BtcDecode", ErrProtocolVersion, str)
+	}
+
+	err := readBlockHeaderFields(r, pver, &msg.Header)
+	if err != nil {
+		return err
+	}
+
+	err = readElement(r, &msg.Nonce)
+	if err != nil {
+		return err
+	}
+
+	// Read num short ids and limit to max.
+	count, err := readVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > maxTxPerBlock {
+		str := fmt.Sprintf("too many short ids for message "+
+			"[count %v, max %v]", count, maxTxPerBlock)
+		return messageError("MsgCmpctBlock.BtcDecode", ErrTooManyItems, str)
+	}
+
+	msg.ShortIDs = make([]uint64, 0, preallocCount(count, 8))
+	var b [8]byte
+	for i := uint64(0); i < count; i++ {
+		_, err := io.ReadFull(r, b[:shortTxIDSize])
+		if err != nil {
+			return err
+		}
+		msg.ShortIDs = append(msg.ShortIDs, binary.LittleEndian.Uint64(b[:]))
+	}
+
+	// Read num prefilled transactions and limit the total number of
+	// transactions to max.
+	prefilledCount, err := readVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	total := count + prefilledCount
+	if prefilledCount > maxTxPerBlock || total > maxTxPerBlock {
+		str := fmt.Sprintf("too many transactions for message "+
+			"[count %v, max %v]", count+prefilledCount, maxTxPerBlock)
+		return messageError("MsgCmpctBlock.BtcDecode", ErrTooManyItems, str)
+	}
+
+	// The indexes are differentially encoded, so each one is the number of
+	// transactions since the previous prefilled transaction.  Ensure each
+	// absolute index is within the block.
+	msg.PrefilledTxs = make([]*PrefilledTx, 0, preallocCount(prefilledCount,
+		unsafe.Sizeof((*PrefilledTx)(nil))))
+	next := uint64(0)
+	for i := uint64(0); i < prefilledCount; i++ {
+		diff, err := readVarInt(r, pver)
+		if err != nil {
+			return err
+		}
+		if diff >= total || next+diff >= total {
+			str := fmt.Sprintf("prefilled transaction index is out "+
+				"of range for block of %v transactions", total)
+			return messageError("MsgCmpctBlock.BtcDecode", ErrMalformed, str)
+		}
+		index := next + diff
+		next = index + 1
+
+		tx := MsgTx{}
+		err = tx.btcDecodeBlockTx(r, pver)
+		if err != nil {
+			return err
+		}
+		ptx := PrefilledTx{Index: uint32(index), Tx: &tx}
+		msg.PrefilledTxs = append(msg.PrefilledTxs, &ptx)
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < SendCmpctVersion {
+		str := fmt.Sprintf("cmpctblock message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgCmpctBlock.BtcEncode", ErrProtocolVersion, str)
+	}
+
+	// Limit to max transactions per block.
+	total := msg.TxCount()
+	if total > maxTxPerBlock {
+		str := fmt.Sprintf("too many transactions for message "+
+			"[count %v, max %v]", total, maxTxPerBlock)
+		return messageError("MsgCmpctBlock.BtcEncode", ErrTooManyItems, str)
+	}
+
+	err := writeBlockHeaderFields(w, pver, &msg.Header)
+	if err != nil {
+		return err
+	}
+
+	err = writeElement(w, msg.Nonce)
+	if err != nil {
+		return err
+	}
+
+	err = writeVarInt(w, pver, uint64(len(msg.ShortIDs)))
+	if err != nil {
+		return err
+	}
+	var b [8]byte
+	for _, id := range msg.ShortIDs {
+		if id > ShortTxIDMask {
+			str := fmt.Sprintf("short transaction id %x is larger "+
+				"than %d bytes", id, shortTxIDSize)
+			return messageError("MsgCmpctBlock.BtcEncode", ErrMalformed, str)
+		}
+		binary.LittleEndian.PutUint64(b[:], id)
+		_, err = w.Write(b[:shortTxIDSize])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeVarInt(w, pver, uint64(len(msg.PrefilledTxs)))
+	if err != nil {
+		return err
+	}
+	next := uint64(0)
+	for _, ptx := range msg.PrefilledTxs {
+		index := uint64(ptx.Index)
+		if index < next || index >= uint64(total) {
+			str := fmt.Sprintf("prefilled transaction index %d is "+
+				"out of order or out of range for block of %v "+
+				"transactions", index, total)
+			return messageError("MsgCmpctBlock.BtcEncode", ErrMalformed, str)
+		}
+
+		err = writeVarInt(w, pver, index-next)
+		if err != nil {
+			return err
+		}
+		next = index + 1
+
+		err = ptx.Tx.BtcEncode(w, pver)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgCmpctBlock) Command() string {
+	return cmdCmpctBlock
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgCmpctBlock) String() string {
+	hash, _ := msg.Header.BlockSha(ProtocolVersion)
+	return fmt.Sprintf("%s hash=%v shortids=%d prefilled=%d", msg.Command(),
+		hash, len(msg.ShortIDs), len(msg.PrefilledTxs))
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) MaxPayloadLength(pver uint32) uint32 {
+	// A compact block is never larger than the block it relays since each
+	// short id is smaller than the transaction it replaces.
+	return MaxBlockWeight
+}
+
+// NewMsgCmpctBlock returns a new bitcoin cmpctblock message that conforms to
+// the Message interface using the passed block header and nonce.  See
+// MsgCmpctBlock for details.
+func NewMsgCmpctBlock(bh *BlockHeader, nonce uint64) *MsgCmpctBlock {
+	return &MsgCmpctBlock{
+		Header:       *bh,
+		Nonce:        nonce,
+		ShortIDs:     make([]uint64, 0),
+		PrefilledTxs: make([]*PrefilledTx, 0),
+	}
+}
+
+// NewMsgCmpctBlockFromBlock returns a new bitcoin cmpctblock message which
+// relays the passed block using the passed nonce.  The coinbase is prefilled,
+// since the receiver can't already have it, and every other transaction is
+// replaced with its short id.  The nonce should be random to make collisions
+// between short ids unpredictable.
+func NewMsgCmpctBlockFromBlock(block *MsgBlock, nonce uint64) (*MsgCmpctBlock, error) {
+	header := block.Header
+	header.TxnCount = 0
+	msg := NewMsgCmpctBlock(&header, nonce)
+	for i, tx := range block.Transactions {
+		if i == 0 {
+			ptx := PrefilledTx{Index: 0, Tx: tx}
+			err := msg.AddPrefilledTx(&ptx)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		wtxid, err := tx.WTxSha()
+		if err != nil {
+			return nil, err
+		}
+		err = msg.AddShortID(msg.ShortID(&wtxid))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return msg, nil
+}
+
+// ShouldAnnounceCompact returns whether or not new blocks should be announced
+// to a peer directly with cmpctblock messages.  This is the case when the peer
+// negotiated high-bandwidth mode by sending a sendcmpct message (MsgSendCmpct)
+// with AnnounceUsingCmpctBlock set and a supported version, as indicated by
+// negotiated, and it is a full node, as indicated by SFNodeNetwork in the
+// passed services.  Peers which are not full nodes don't relay blocks, so there
+// is no latency to save by announcing blocks to them compactly.
+func ShouldAnnounceCompact(services ServiceFlag, negotiated bool) bool {
+	return negotiated && services&SFNodeNetwork == SFNodeNetwork
+}
+
+// AnnounceBlock returns the message used to announce the passed block to a
+// peer with the passed services and compact block negotiation state.  See
+// ShouldAnnounceCompact.  The block is announced with a cmpctblock message
+// built using the passed nonce when it should be announced compactly and with
+// an inv message (MsgInv) otherwise.
+func AnnounceBlock(block *MsgBlock, nonce uint64, services ServiceFlag,
+	negotiated bool) (Message, error) {
+
+	if ShouldAnnounceCompact(services, negotiated) {
+		return NewMsgCmpctBlockFromBlock(block, nonce)
+	}
+
+	hash, err := block.BlockSha(ProtocolVersion)
+	if err != nil {
+		return nil, err
+	}
+	msg := NewMsgInv()
+	err = msg.AddInvVect(NewInvVect(InvVect_Block, &hash))
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
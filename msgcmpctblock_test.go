@@ -0,0 +1,599 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestCmpctBlock tests the MsgCmpctBlock API.
+func TestCmpctBlock(t *testing.T) {
+	pver := btcwire.SendCmpctVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "cmpctblock"
+	msg := btcwire.NewMsgCmpctBlock(&cmpctBlockOne.Header, cmpctBlockOne.Nonce)
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgCmpctBlock: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	wantPayload := uint32(4000000)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure short ids are added properly.
+	err := msg.AddShortID(0x010203040506)
+	if err != nil {
+		t.Errorf("AddShortID: %v", err)
+	}
+	if msg.ShortIDs[0] != 0x010203040506 {
+		t.Errorf("AddShortID: wrong short id added - got %x, want %x",
+			msg.ShortIDs[0], uint64(0x010203040506))
+	}
+
+	// Ensure short ids larger than 6 bytes are rejected.
+	err = msg.AddShortID(btcwire.ShortTxIDMask + 1)
+	if _, ok := err.(*btcwire.MessageError); !ok {
+		t.Errorf("AddShortID: wrong error for too large short id - "+
+			"got %v <%T>", err, err)
+	}
+
+	// Ensure prefilled transactions are added properly.
+	ptx := &btcwire.PrefilledTx{Index: 1, Tx: blockOne.Transactions[0]}
+	err = msg.AddPrefilledTx(ptx)
+	if err != nil {
+		t.Errorf("AddPrefilledTx: %v", err)
+	}
+	if msg.PrefilledTxs[0] != ptx {
+		t.Errorf("AddPrefilledTx: wrong prefilled tx added - got %v, "+
+			"want %v", spew.Sprint(msg.PrefilledTxs[0]),
+			spew.Sprint(ptx))
+	}
+	if msg.TxCount() != 2 {
+		t.Errorf("TxCount: wrong number of transactions - got %v, "+
+			"want %v", msg.TxCount(), 2)
+	}
+
+	// Ensure prefilled transactions which are out of order are rejected.
+	err = msg.AddPrefilledTx(ptx)
+	if _, ok := err.(*btcwire.MessageError); !ok {
+		t.Errorf("AddPrefilledTx: wrong error for out of order index "+
+			"- got %v <%T>", err, err)
+	}
+
+	// Ensure adding more than the max allowed transactions per message
+	// returns an error.
+	for i := 0; i < btcwire.MaxBlockPayload/10+1; i++ {
+		err = msg.AddShortID(0)
+	}
+	if err == nil {
+		t.Errorf("AddShortID: expected error on too many transactions " +
+			"not received")
+	}
+
+	// Ensure encoding too many transactions returns an error.
+	msg.ShortIDs = append(msg.ShortIDs, 0)
+	var buf bytes.Buffer
+	err = msg.BtcEncode(&buf, pver)
+	if _, ok := err.(*btcwire.MessageError); !ok {
+		t.Errorf("BtcEncode: wrong error for too many transactions - "+
+			"got %v <%T>", err, err)
+	}
+
+	// Older protocol versions should fail encode and decode since the
+	// message didn't exist yet.
+	oldPver := btcwire.SendCmpctVersion - 1
+	buf.Reset()
+	err = cmpctBlockOne.BtcEncode(&buf, oldPver)
+	if err == nil {
+		t.Errorf("encode of MsgCmpctBlock succeeded when it should " +
+			"have failed")
+	}
+	var readmsg btcwire.MsgCmpctBlock
+	err = readmsg.BtcDecode(bytes.NewBuffer(cmpctBlockOneBytes), oldPver)
+	if err == nil {
+		t.Errorf("decode of MsgCmpctBlock succeeded when it should " +
+			"have failed")
+	}
+}
+
+// TestCmpctBlockFromBlock tests that compact blocks built from a block
+// prefill the coinbase and use the short ids of the other transactions.
+func TestCmpctBlockFromBlock(t *testing.T) {
+	block := btcwire.NewMsgBlock(&blockOne.Header)
+	block.AddTransaction(blockOne.Transactions[0])
+	block.AddTransaction(multiTx)
+	block.AddTransaction(witnessTx)
+
+	nonce := uint64(0x0807060504030201)
+	msg, err := btcwire.NewMsgCmpctBlockFromBlock(block, nonce)
+	if err != nil {
+		t.Errorf("NewMsgCmpctBlockFromBlock: %v", err)
+		return
+	}
+	if msg.TxCount() != len(block.Transactions) {
+		t.Errorf("NewMsgCmpctBlockFromBlock: wrong number of "+
+			"transactions - got %v, want %v", msg.TxCount(),
+			len(block.Transactions))
+	}
+
+	// Ensure the coinbase is the only prefilled transaction.
+	wantPrefilled := []*btcwire.PrefilledTx{
+		{Index: 0, Tx: blockOne.Transactions[0]},
+	}
+	if !reflect.DeepEqual(msg.PrefilledTxs, wantPrefilled) {
+		t.Errorf("NewMsgCmpctBlockFromBlock: wrong prefilled "+
+			"transactions\n got: %s want: %s",
+			spew.Sdump(msg.PrefilledTxs), spew.Sdump(wantPrefilled))
+	}
+
+	// Ensure the other transactions are replaced with their short ids
+	// computed from their witness hashes.
+	for i, tx := range block.Transactions[1:] {
+		wtxid, err := tx.WTxSha()
+		if err != nil {
+			t.Errorf("WTxSha #%d: %v", i, err)
+			continue
+		}
+		want := btcwire.ShortTxID(&msg.Header, nonce, &wtxid)
+		if msg.ShortIDs[i] != want {
+			t.Errorf("NewMsgCmpctBlockFromBlock #%d: wrong short id "+
+				"- got %x, want %x", i, msg.ShortIDs[i], want)
+		}
+	}
+
+	// Ensure the compact block survives a round trip.
+	var buf bytes.Buffer
+	err = msg.BtcEncode(&buf, btcwire.SendCmpctVersion)
+	if err != nil {
+		t.Errorf("BtcEncode: %v", err)
+		return
+	}
+	var readmsg btcwire.MsgCmpctBlock
+	err = readmsg.BtcDecode(&buf, btcwire.SendCmpctVersion)
+	if err != nil {
+		t.Errorf("BtcDecode: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(&readmsg, msg) {
+		t.Errorf("BtcDecode\n got: %s want: %s", spew.Sdump(&readmsg),
+			spew.Sdump(msg))
+	}
+}
+
+// TestAnnounceBlock tests that blocks are only announced with compact blocks
+// to full nodes which negotiated high-bandwidth mode.
+func TestAnnounceBlock(t *testing.T) {
+	blockHash, err := blockOne.BlockSha(btcwire.ProtocolVersion)
+	if err != nil {
+		t.Errorf("BlockSha: %v", err)
+		return
+	}
+
+	tests := []struct {
+		services   btcwire.ServiceFlag // Services of the peer
+		negotiated bool                // Whether high-bandwidth mode was negotiated
+		compact    bool                // Expected announce mode
+	}{
+		{btcwire.SFNodeNetwork, true, true},
+		{btcwire.SFNodeNetwork | btcwire.SFNodeGetUTXO, true, true},
+		{btcwire.SFNodeNetwork, false, false},
+		{btcwire.SFNodeGetUTXO, true, false},
+		{0, true, false},
+		{0, false, false},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		compact := btcwire.ShouldAnnounceCompact(test.services,
+			test.negotiated)
+		if compact != test.compact {
+			t.Errorf("ShouldAnnounceCompact #%d: got %v, want %v",
+				i, compact, test.compact)
+			continue
+		}
+
+		msg, err := btcwire.AnnounceBlock(&blockOne, 0, test.services,
+			test.negotiated)
+		if err != nil {
+			t.Errorf("AnnounceBlock #%d: %v", i, err)
+			continue
+		}
+
+		// Ensure the announcement survives a round trip through
+		// WriteMessage and ReadMessage at the latest protocol version.
+		var buf bytes.Buffer
+		err = btcwire.WriteMessage(&buf, msg, btcwire.ProtocolVersion,
+			btcwire.MainNet)
+		if err != nil {
+			t.Errorf("WriteMessage #%d: %v", i, err)
+			continue
+		}
+		rmsg, _, err := btcwire.ReadMessage(&buf,
+			btcwire.ProtocolVersion, btcwire.MainNet)
+		if err != nil {
+			t.Errorf("ReadMessage #%d: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(rmsg, msg) {
+			t.Errorf("ReadMessage #%d: wrong message\n got: %s "+
+				"want: %s", i, spew.Sdump(rmsg), spew.Sdump(msg))
+		}
+		if test.compact {
+			cmpct, ok := msg.(*btcwire.MsgCmpctBlock)
+			if !ok {
+				t.Errorf("AnnounceBlock #%d: wrong message - got "+
+					"%T, want *btcwire.MsgCmpctBlock", i, msg)
+				continue
+			}
+			if cmpct.TxCount() != len(blockOne.Transactions) {
+				t.Errorf("AnnounceBlock #%d: wrong number of "+
+					"transactions - got %v, want %v", i,
+					cmpct.TxCount(), len(blockOne.Transactions))
+			}
+			continue
+		}
+
+		inv, ok := msg.(*btcwire.MsgInv)
+		if !ok {
+			t.Errorf("AnnounceBlock #%d: wrong message - got %T, "+
+				"want *btcwire.MsgInv", i, msg)
+			continue
+		}
+		want := []*btcwire.InvVect{
+			btcwire.NewInvVect(btcwire.InvVect_Block, &blockHash),
+		}
+		if !reflect.DeepEqual(inv.InvList, want) {
+			t.Errorf("AnnounceBlock #%d: wrong inventory\n got: %s "+
+				"want: %s", i, spew.Sdump(inv.InvList),
+				spew.Sdump(want))
+		}
+	}
+}
+
+// TestCmpctBlockWire tests the MsgCmpctBlock wire encode and decode.
+func TestCmpctBlockWire(t *testing.T) {
+	tests := []struct {
+		in   *btcwire.MsgCmpctBlock // Message to encode
+		out  *btcwire.MsgCmpctBlock // Expected decoded message
+		buf  []byte                 // Wire encoding
+		pver uint32                 // Protocol version for wire encoding
+	}{
+		// Protocol version SendCmpctVersion with only a coinbase.
+		{
+			&cmpctBlockOne,
+			&cmpctBlockOne,
+			cmpctBlockOneBytes,
+			btcwire.SendCmpctVersion,
+		},
+
+		// Protocol version SendCmpctVersion with short ids and
+		// differentially encoded prefilled transaction indexes.
+		{
+			&cmpctBlockMulti,
+			&cmpctBlockMulti,
+			cmpctBlockMultiBytes,
+			btcwire.SendCmpctVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg btcwire.MsgCmpctBlock
+		rbuf := bytes.NewBuffer(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestCmpctBlockWireErrors performs negative tests against wire encode and
+// decode of MsgCmpctBlock to confirm error paths work correctly.
+func TestCmpctBlockWireErrors(t *testing.T) {
+	pver := btcwire.SendCmpctVersion
+
+	tests := []struct {
+		in       *btcwire.MsgCmpctBlock // Value to encode
+		buf      []byte                 // Wire encoding
+		pver     uint32                 // Protocol version for wire encoding
+		max      int                    // Max size of fixed buffer to induce errors
+		writeErr error                  // Expected write error
+		readErr  error                  // Expected read error
+	}{
+		// Force error in block header.
+		{&cmpctBlockMulti, cmpctBlockMultiBytes, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in nonce.
+		{&cmpctBlockMulti, cmpctBlockMultiBytes, pver, 80, io.ErrShortWrite, io.EOF},
+		// Force error in num short ids.
+		{&cmpctBlockMulti, cmpctBlockMultiBytes, pver, 88, io.ErrShortWrite, io.EOF},
+		// Force error in short ids.
+		{&cmpctBlockMulti, cmpctBlockMultiBytes, pver, 89, io.ErrShortWrite, io.EOF},
+		// Force error in num prefilled transactions.
+		{&cmpctBlockMulti, cmpctBlockMultiBytes, pver, 101, io.ErrShortWrite, io.EOF},
+		// Force error in prefilled transaction index.
+		{&cmpctBlockMulti, cmpctBlockMultiBytes, pver, 102, io.ErrShortWrite, io.EOF},
+		// Force error in prefilled transaction.
+		{&cmpctBlockMulti, cmpctBlockMultiBytes, pver, 103, io.ErrShortWrite, io.EOF},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if err != test.writeErr {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// Decode from wire format.
+		var msg btcwire.MsgCmpctBlock
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if err != test.readErr {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+	}
+}
+
+// TestCmpctBlockBounds ensures compact blocks with more transactions than fit
+// in a block or prefilled transaction indexes outside of the block are
+// rejected.
+func TestCmpctBlockBounds(t *testing.T) {
+	pver := btcwire.SendCmpctVersion
+	prefix := cmpctBlockOneBytes[:88]
+	coinbase := blockOneBytes[81:]
+
+	tests := []struct {
+		name string // Short description of the test
+		buf  []byte // Wire encoding
+		code btcwire.ErrorCode
+		ok   bool // Whether the message is valid
+	}{
+		{
+			"coinbase only",
+			cmpctBlockOneBytes,
+			0,
+			true,
+		},
+		{
+			// The short ids are not provided since they must not
+			// be read.
+			"more short ids than fit in a block",
+			joinBytes(prefix, []byte{
+				0xfe, 0x9b, 0x99, 0x01, 0x00, // Varint for number of short ids
+			}),
+			btcwire.ErrTooManyItems,
+			false,
+		},
+		{
+			"more transactions than fit in a block",
+			joinBytes(prefix, []byte{
+				0x01,                               // Varint for number of short ids
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Short id
+				0xfe, 0x9a, 0x99, 0x01, 0x00, // Varint for number of prefilled txs
+			}),
+			btcwire.ErrTooManyItems,
+			false,
+		},
+		{
+			"prefilled index past the end of the block",
+			joinBytes(prefix, []byte{
+				0x01,                               // Varint for number of short ids
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Short id
+				0x01, // Varint for number of prefilled txs
+				0x02, // Varint for prefilled tx index
+			}, coinbase),
+			btcwire.ErrMalformed,
+			false,
+		},
+		{
+			"second prefilled index past the end of the block",
+			joinBytes(prefix, []byte{
+				0x00, // Varint for number of short ids
+				0x02, // Varint for number of prefilled txs
+				0x01, // Varint for prefilled tx index
+			}, coinbase, []byte{
+				0x00, // Varint for prefilled tx index
+			}, coinbase),
+			btcwire.ErrMalformed,
+			false,
+		},
+		{
+			"prefilled index which would overflow",
+			joinBytes(prefix, []byte{
+				0x00, // Varint for number of short ids
+				0x02, // Varint for number of prefilled txs
+				0x00, // Varint for prefilled tx index
+			}, coinbase, []byte{
+				0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+				0xff, // Varint for prefilled tx index
+			}, coinbase),
+			btcwire.ErrMalformed,
+			false,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		var msg btcwire.MsgCmpctBlock
+		err := msg.BtcDecode(bytes.NewReader(test.buf), pver)
+		if test.ok {
+			if err != nil {
+				t.Errorf("BtcDecode #%d (%s) unexpected error: %v",
+					i, test.name, err)
+			}
+			continue
+		}
+		msgErr, ok := err.(*btcwire.MessageError)
+		if !ok {
+			t.Errorf("BtcDecode #%d (%s) wrong error got: %v <%T>, "+
+				"want: <*btcwire.MessageError>", i, test.name,
+				err, err)
+			continue
+		}
+		if msgErr.Code != test.code {
+			t.Errorf("BtcDecode #%d (%s) wrong error code got: %v, "+
+				"want: %v", i, test.name, msgErr.Code, test.code)
+			continue
+		}
+	}
+
+	// Ensure prefilled transactions which can't be encoded are rejected.
+	coinbaseTx := blockOne.Transactions[0]
+	encodeTests := []struct {
+		name string                 // Short description of the test
+		in   *btcwire.MsgCmpctBlock // Value to encode
+	}{
+		{
+			"prefilled index past the end of the block",
+			&btcwire.MsgCmpctBlock{
+				PrefilledTxs: []*btcwire.PrefilledTx{
+					{Index: 1, Tx: coinbaseTx},
+				},
+			},
+		},
+		{
+			"prefilled indexes out of order",
+			&btcwire.MsgCmpctBlock{
+				ShortIDs: []uint64{0},
+				PrefilledTxs: []*btcwire.PrefilledTx{
+					{Index: 2, Tx: coinbaseTx},
+					{Index: 0, Tx: coinbaseTx},
+				},
+			},
+		},
+		{
+			"duplicate prefilled indexes",
+			&btcwire.MsgCmpctBlock{
+				PrefilledTxs: []*btcwire.PrefilledTx{
+					{Index: 0, Tx: coinbaseTx},
+					{Index: 0, Tx: coinbaseTx},
+				},
+			},
+		},
+		{
+			"short id larger than 6 bytes",
+			&btcwire.MsgCmpctBlock{
+				ShortIDs: []uint64{btcwire.ShortTxIDMask + 1},
+			},
+		},
+	}
+
+	t.Logf("Running %d tests", len(encodeTests))
+	for i, test := range encodeTests {
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, pver)
+		msgErr, ok := err.(*btcwire.MessageError)
+		if !ok {
+			t.Errorf("BtcEncode #%d (%s) wrong error got: %v <%T>, "+
+				"want: <*btcwire.MessageError>", i, test.name,
+				err, err)
+			continue
+		}
+		if msgErr.Code != btcwire.ErrMalformed {
+			t.Errorf("BtcEncode #%d (%s) wrong error code got: %v, "+
+				"want: %v", i, test.name, msgErr.Code,
+				btcwire.ErrMalformed)
+			continue
+		}
+	}
+}
+
+// cmpctBlockOne is a compact block created from block one of the block chain
+// which only prefills the coinbase.
+var cmpctBlockOne = btcwire.MsgCmpctBlock{
+	Header: btcwire.BlockHeader{
+		Version:    blockOne.Header.Version,
+		PrevBlock:  blockOne.Header.PrevBlock,
+		MerkleRoot: blockOne.Header.MerkleRoot,
+		Timestamp:  blockOne.Header.Timestamp,
+		Bits:       blockOne.Header.Bits,
+		Nonce:      blockOne.Header.Nonce,
+	},
+	Nonce:    0x0807060504030201,
+	ShortIDs: []uint64{},
+	PrefilledTxs: []*btcwire.PrefilledTx{
+		{Index: 0, Tx: blockOne.Transactions[0]},
+	},
+}
+
+// cmpctBlockOneBytes is the serialized bytes for cmpctBlockOne.
+var cmpctBlockOneBytes = joinBytes(
+	blockOneBytes[:80], // Block header without the transaction count
+	[]byte{
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, // Nonce
+		0x00, // Varint for number of short ids
+		0x01, // Varint for number of prefilled txs
+		0x00, // Varint for prefilled tx index
+	},
+	blockOneBytes[81:], // Coinbase
+)
+
+// cmpctBlockMulti is a compact block with the header of block one of the block
+// chain which holds short ids and prefilled transactions at the first and
+// last indexes.
+var cmpctBlockMulti = btcwire.MsgCmpctBlock{
+	Header:   cmpctBlockOne.Header,
+	Nonce:    0x0807060504030201,
+	ShortIDs: []uint64{0x060504030201, 0xffffffffffff},
+	PrefilledTxs: []*btcwire.PrefilledTx{
+		{Index: 0, Tx: blockOne.Transactions[0]},
+		{Index: 3, Tx: blockOne.Transactions[0]},
+	},
+}
+
+// cmpctBlockMultiBytes is the serialized bytes for cmpctBlockMulti.
+var cmpctBlockMultiBytes = joinBytes(
+	blockOneBytes[:80], // Block header without the transaction count
+	[]byte{
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, // Nonce
+		0x02,                               // Varint for number of short ids
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, // Short id
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, // Short id
+		0x02, // Varint for number of prefilled txs
+		0x00, // Varint for prefilled tx index
+	},
+	blockOneBytes[81:], // Coinbase
+	[]byte{
+		0x02, // Varint for prefilled tx index
+	},
+	blockOneBytes[81:], // Coinbase
+)
@@ -0,0 +1,72 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"fmt"
+	"io"
+)
+
+// MsgFeeFilter implements the Message interface and represents a bitcoin
+// feefilter message.  It is used to request the peer not announce any
+// transactions with a fee rate below the passed one as defined by BIP0133.
+//
+// This message was not added until protocol version FeeFilterVersion.
+type MsgFeeFilter struct {
+	// MinFee is the minimum fee rate in satoshis per kilobyte of the
+	// transactions to announce.
+	MinFee int64
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgFeeFilter) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < FeeFilterVersion {
+		str := fmt.Sprintf("feefilter message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgFeeFilter.BtcDecode", ErrProtocolVersion, str)
+	}
+
+	return readElement(r, &msg.MinFee)
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgFeeFilter) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < FeeFilterVersion {
+		str := fmt.Sprintf("feefilter message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgFeeFilter.BtcEncode", ErrProtocolVersion, str)
+	}
+
+	return writeElement(w, &msg.MinFee)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgFeeFilter) Command() string {
+	return cmdFeeFilter
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgFeeFilter) String() string {
+	return fmt.Sprintf("%s minfee=%d", msg.Command(), msg.MinFee)
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgFeeFilter) MaxPayloadLength(pver uint32) uint32 {
+	// Minimum fee rate 8 bytes.
+	return 8
+}
+
+// NewMsgFeeFilter returns a new bitcoin feefilter message that conforms to the
+// Message interface using the passed minimum fee rate.  See MsgFeeFilter for
+// details.
+func NewMsgFeeFilter(minFee int64) *MsgFeeFilter {
+	return &MsgFeeFilter{
+		MinFee: minFee,
+	}
+}
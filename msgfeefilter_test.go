@@ -0,0 +1,161 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestFeeFilter tests the MsgFeeFilter API against the protocol versions
+// before and after it was added.
+func TestFeeFilter(t *testing.T) {
+	pver := btcwire.FeeFilterVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "feefilter"
+	msg := btcwire.NewMsgFeeFilter(feeFilter.MinFee)
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgFeeFilter: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value.
+	// Minimum fee rate 8 bytes.
+	wantPayload := uint32(8)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Older protocol versions should fail encode and decode since the
+	// message didn't exist yet.
+	oldPver := btcwire.FeeFilterVersion - 1
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, oldPver)
+	if err == nil {
+		t.Errorf("encode of MsgFeeFilter succeeded when it should " +
+			"have failed")
+	}
+	var readmsg btcwire.MsgFeeFilter
+	err = readmsg.BtcDecode(bytes.NewBuffer(feeFilterEncoded), oldPver)
+	if err == nil {
+		t.Errorf("decode of MsgFeeFilter succeeded when it should " +
+			"have failed")
+	}
+}
+
+// TestFeeFilterWire tests the MsgFeeFilter wire encode and decode.
+func TestFeeFilterWire(t *testing.T) {
+	tests := []struct {
+		in   *btcwire.MsgFeeFilter // Message to encode
+		out  *btcwire.MsgFeeFilter // Expected decoded message
+		buf  []byte                // Wire encoding
+		pver uint32                // Protocol version for wire encoding
+	}{
+		// Protocol version FeeFilterVersion.
+		{
+			feeFilter,
+			feeFilter,
+			feeFilterEncoded,
+			btcwire.FeeFilterVersion,
+		},
+
+		// Latest protocol version with a zero fee rate.
+		{
+			btcwire.NewMsgFeeFilter(0),
+			btcwire.NewMsgFeeFilter(0),
+			[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			btcwire.CFilterVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg btcwire.MsgFeeFilter
+		rbuf := bytes.NewBuffer(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestFeeFilterWireErrors performs negative tests against wire encode and
+// decode of MsgFeeFilter to confirm error paths work correctly.
+func TestFeeFilterWireErrors(t *testing.T) {
+	pver := btcwire.FeeFilterVersion
+
+	tests := []struct {
+		in       *btcwire.MsgFeeFilter // Value to encode
+		buf      []byte                // Wire encoding
+		pver     uint32                // Protocol version for wire encoding
+		max      int                   // Max size of fixed buffer to induce errors
+		writeErr error                 // Expected write error
+		readErr  error                 // Expected read error
+	}{
+		// Force error in minimum fee rate.
+		{feeFilter, feeFilterEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		{feeFilter, feeFilterEncoded, pver, 4, io.ErrShortWrite,
+			io.ErrUnexpectedEOF},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if err != test.writeErr {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// Decode from wire format.
+		var msg btcwire.MsgFeeFilter
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if err != test.readErr {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+	}
+}
+
+// feeFilter is a feefilter message asking for transactions paying at least
+// 1000 satoshis per kilobyte.
+var feeFilter = btcwire.NewMsgFeeFilter(1000)
+
+// feeFilterEncoded is the wire encoded bytes for feeFilter.
+var feeFilterEncoded = []byte{
+	0xe8, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // MinFee
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestFilterAdd tests the MsgFilterAdd API.
+func TestFilterAdd(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "filteradd"
+	msg := btcwire.NewMsgFilterAdd(baseFilterAdd.Data)
+	if !reflect.DeepEqual(msg, baseFilterAdd) {
+		t.Errorf("NewMsgFilterAdd: wrong message - got %v, want %v",
+			spew.Sdump(msg), spew.Sdump(baseFilterAdd))
+	}
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgFilterAdd: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Num data bytes (varInt) + max data size.
+	wantPayload := uint32(529)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Older protocol versions should fail encode and decode since the
+	// message didn't exist yet.
+	oldPver := btcwire.BIP0037Version - 1
+	var buf bytes.Buffer
+	err := baseFilterAdd.BtcEncode(&buf, oldPver)
+	if err == nil {
+		t.Errorf("encode of MsgFilterAdd succeeded when it should " +
+			"have failed")
+	}
+	var readmsg btcwire.MsgFilterAdd
+	err = readmsg.BtcDecode(bytes.NewBuffer(baseFilterAddEncoded), oldPver)
+	if err == nil {
+		t.Errorf("decode of MsgFilterAdd succeeded when it should " +
+			"have failed")
+	}
+}
+
+// TestFilterAddWire tests the MsgFilterAdd wire encode and decode for various
+// protocol versions and data sizes.
+func TestFilterAddWire(t *testing.T) {
+	// Filter add message with no data.
+	emptyFilterAdd := &btcwire.MsgFilterAdd{Data: []byte{}}
+	emptyFilterAddEncoded := []byte{
+		0x00, // Varint for size of data
+	}
+
+	// Filter add message with the max allowed data size.
+	maxFilterAdd := &btcwire.MsgFilterAdd{
+		Data: make([]byte, btcwire.MaxFilterAddDataSize),
+	}
+	maxFilterAddEncoded := append([]byte{0xfd, 0x08, 0x02},
+		make([]byte, btcwire.MaxFilterAddDataSize)...)
+
+	tests := []struct {
+		in   *btcwire.MsgFilterAdd // Message to encode
+		out  *btcwire.MsgFilterAdd // Expected decoded message
+		buf  []byte                // Wire encoding
+		pver uint32                // Protocol version for wire encoding
+	}{
+		// Latest protocol version.
+		{
+			baseFilterAdd,
+			baseFilterAdd,
+			baseFilterAddEncoded,
+			btcwire.ProtocolVersion,
+		},
+
+		// Protocol version BIP0037Version.
+		{
+			baseFilterAdd,
+			baseFilterAdd,
+			baseFilterAddEncoded,
+			btcwire.BIP0037Version,
+		},
+
+		// Protocol version BIP0037Version with no data.
+		{
+			emptyFilterAdd,
+			emptyFilterAdd,
+			emptyFilterAddEncoded,
+			btcwire.BIP0037Version,
+		},
+
+		// Protocol version BIP0037Version with the max data size.
+		{
+			maxFilterAdd,
+			maxFilterAdd,
+			maxFilterAddEncoded,
+			btcwire.BIP0037Version,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg btcwire.MsgFilterAdd
+		rbuf := bytes.NewBuffer(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestFilterAddWireErrors performs negative tests against wire encode and
+// decode of MsgFilterAdd to confirm error paths work correctly.
+func TestFilterAddWireErrors(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcwireErr := &btcwire.MessageError{}
+
+	// Message with data which exceeds the max allowed size.  The encoded
+	// form only contains the varint for the data size
+	// (MaxFilterAddDataSize + 1) since decoding must fail before the data
+	// itself is read.
+	exceedDataSize := &btcwire.MsgFilterAdd{
+		Data: make([]byte, btcwire.MaxFilterAddDataSize+1),
+	}
+	exceedDataSizeEncoded := []byte{0xfd, 0x09, 0x02}
+
+	tests := []struct {
+		in       *btcwire.MsgFilterAdd // Value to encode
+		buf      []byte                // Wire encoding
+		pver     uint32                // Protocol version for wire encoding
+		max      int                   // Max size of fixed buffer to induce errors
+		writeErr error                 // Expected write error
+		readErr  error                 // Expected read error
+	}{
+		// Latest protocol version with intentional read/write errors.
+		// Force error in data size.
+		{baseFilterAdd, baseFilterAddEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in data.
+		{baseFilterAdd, baseFilterAddEncoded, pver, 1, io.ErrShortWrite, io.EOF},
+		// Force error due to data too large.
+		{exceedDataSize, exceedDataSizeEncoded, pver,
+			len(exceedDataSizeEncoded), btcwireErr, btcwireErr},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg btcwire.MsgFilterAdd
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
+
+// baseFilterAdd is used in the various tests as a baseline MsgFilterAdd.
+var baseFilterAdd = &btcwire.MsgFilterAdd{
+	Data: []byte{0x01, 0x02, 0x03, 0x04},
+}
+
+// baseFilterAddEncoded is the wire encoded bytes for baseFilterAdd using
+// protocol version BIP0037Version and is used in the various tests.
+var baseFilterAddEncoded = []byte{
+	0x04,                   // Varint for size of data
+	0x01, 0x02, 0x03, 0x04, // Data
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"testing"
+)
+
+// TestFilterClear tests the MsgFilterClear API.
+func TestFilterClear(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "filterclear"
+	msg := btcwire.NewMsgFilterClear()
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgFilterClear: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value.
+	wantPayload := uint32(0)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Test encode with latest protocol version.
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, pver)
+	if err != nil {
+		t.Errorf("encode of MsgFilterClear failed %v err <%v>", msg, err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("encode of MsgFilterClear wrote %d bytes, want 0",
+			buf.Len())
+	}
+
+	// Older protocol versions should fail encode since message didn't
+	// exist yet.
+	oldPver := btcwire.BIP0037Version - 1
+	err = msg.BtcEncode(&buf, oldPver)
+	if err == nil {
+		s := "encode of MsgFilterClear passed for old protocol version %v err <%v>"
+		t.Errorf(s, msg, err)
+	}
+
+	// Test decode with latest protocol version.
+	readmsg := btcwire.NewMsgFilterClear()
+	err = readmsg.BtcDecode(&buf, pver)
+	if err != nil {
+		t.Errorf("decode of MsgFilterClear failed [%v] err <%v>", buf, err)
+	}
+
+	// Older protocol versions should fail decode since message didn't
+	// exist yet.
+	err = readmsg.BtcDecode(&buf, oldPver)
+	if err == nil {
+		s := "decode of MsgFilterClear passed for old protocol version %v err <%v>"
+		t.Errorf(s, msg, err)
+	}
+}
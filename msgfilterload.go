@@ -0,0 +1,248 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// MaxFilterLoadHashFuncs is the maximum number of hash functions to
+	// load into the Bloom filter.
+	MaxFilterLoadHashFuncs = 50
+
+	// MaxFilterLoadFilterSize is the maximum size in bytes a filter may be.
+	MaxFilterLoadFilterSize = 36000
+)
+
+// BloomUpdateType specifies how the filter is updated when a match is found.
+type BloomUpdateType uint8
+
+const (
+	// BloomUpdateNone indicates the filter is not adjusted when a match is
+	// found.
+	BloomUpdateNone BloomUpdateType = 0
+
+	// BloomUpdateAll indicates if the filter matches any data element in a
+	// public key script, the outpoint is serialized and inserted into the
+	// filter.
+	BloomUpdateAll BloomUpdateType = 1
+
+	// BloomUpdateP2PubkeyOnly indicates if the filter matches a data
+	// element in a public key script and the script is of the standard
+	// pay-to-pubkey or multisig, the outpoint is serialized and inserted
+	// into the filter.
+	BloomUpdateP2PubkeyOnly BloomUpdateType = 2
+)
+
+// Map of bloom update types back to their constant names for pretty printing.
+var bloomUpdateTypeStrings = map[BloomUpdateType]string{
+	BloomUpdateNone:         "BLOOM_UPDATE_NONE",
+	BloomUpdateAll:          "BLOOM_UPDATE_ALL",
+	BloomUpdateP2PubkeyOnly: "BLOOM_UPDATE_P2PUBKEY_ONLY",
+}
+
+// String returns the BloomUpdateType in human-readable form.
+func (t BloomUpdateType) String() string {
+	if s, ok := bloomUpdateTypeStrings[t]; ok {
+		return s
+	}
+
+	return fmt.Sprintf("Unknown BloomUpdateType (%d)", uint8(t))
+}
+
+// MsgFilterLoad implements the Message interface and represents a bitcoin
+// filterload message which is used to reset a Bloom filter.
+//
+// This message was not added until protocol version BIP0037Version.
+type MsgFilterLoad struct {
+	Filter    []byte
+	HashFuncs uint32
+	Tweak     uint32
+	Flags     BloomUpdateType
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgFilterLoad) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < BIP0037Version {
+		str := fmt.Sprintf("filterload message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgFilterLoad.BtcDecode", ErrProtocolVersion, str)
+	}
+
+	var err error
+	msg.Filter, err = readVarBytes(r, pver, MaxFilterLoadFilterSize,
+		"filterload filter size")
+	if err != nil {
+		return err
+	}
+
+	err = readElements(r, &msg.HashFuncs, &msg.Tweak, &msg.Flags)
+	if err != nil {
+		return err
+	}
+
+	if msg.HashFuncs > MaxFilterLoadHashFuncs {
+		str := fmt.Sprintf("too many filter hash functions for "+
+			"message [count %v, max %v]", msg.HashFuncs,
+			MaxFilterLoadHashFuncs)
+		return messageError("MsgFilterLoad.BtcDecode", ErrTooManyItems, str)
+	}
+
+	if _, ok := bloomUpdateTypeStrings[msg.Flags]; !ok {
+		str := fmt.Sprintf("filterload flags invalid [%v]", msg.Flags)
+		return messageError("MsgFilterLoad.BtcDecode", ErrMalformed, str)
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgFilterLoad) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < BIP0037Version {
+		str := fmt.Sprintf("filterload message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgFilterLoad.BtcEncode", ErrProtocolVersion, str)
+	}
+
+	size := len(msg.Filter)
+	if size > MaxFilterLoadFilterSize {
+		str := fmt.Sprintf("filterload filter size too large for "+
+			"message [size %v, max %v]", size,
+			MaxFilterLoadFilterSize)
+		return messageError("MsgFilterLoad.BtcEncode", ErrPayloadTooLarge, str)
+	}
+
+	if msg.HashFuncs > MaxFilterLoadHashFuncs {
+		str := fmt.Sprintf("too many filter hash functions for "+
+			"message [count %v, max %v]", msg.HashFuncs,
+			MaxFilterLoadHashFuncs)
+		return messageError("MsgFilterLoad.BtcEncode", ErrTooManyItems, str)
+	}
+
+	err := writeVarBytes(w, pver, msg.Filter)
+	if err != nil {
+		return err
+	}
+
+	err = writeElements(w, msg.HashFuncs, msg.Tweak, msg.Flags)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgFilterLoad) Command() string {
+	return cmdFilterLoad
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgFilterLoad) String() string {
+	return fmt.Sprintf("%s size=%d hashfuncs=%d tweak=%d flags=%v",
+		msg.Command(), len(msg.Filter), msg.HashFuncs, msg.Tweak, msg.Flags)
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgFilterLoad) MaxPayloadLength(pver uint32) uint32 {
+	// Num filter bytes (varInt) + filter + 4 bytes hash funcs +
+	// 4 bytes tweak + 1 byte flags.
+	return maxVarIntPayload + MaxFilterLoadFilterSize + 9
+}
+
+// NewMsgFilterLoad returns a new bitcoin filterload message that conforms to
+// the Message interface.  See MsgFilterLoad for details.
+//
+// An error is returned when the filter is larger than MaxFilterLoadFilterSize,
+// there are more than MaxFilterLoadHashFuncs hash functions, or the flags are
+// not a known BloomUpdateType.
+func NewMsgFilterLoad(filter []byte, hashFuncs uint32, tweak uint32,
+	flags BloomUpdateType) (*MsgFilterLoad, error) {
+
+	if len(filter) > MaxFilterLoadFilterSize {
+		str := fmt.Sprintf("filterload filter size too large for "+
+			"message [size %v, max %v]", len(filter),
+			MaxFilterLoadFilterSize)
+		return nil, messageError("NewMsgFilterLoad", ErrPayloadTooLarge, str)
+	}
+
+	if hashFuncs > MaxFilterLoadHashFuncs {
+		str := fmt.Sprintf("too many filter hash functions for "+
+			"message [count %v, max %v]", hashFuncs,
+			MaxFilterLoadHashFuncs)
+		return nil, messageError("NewMsgFilterLoad", ErrTooManyItems, str)
+	}
+
+	if _, ok := bloomUpdateTypeStrings[flags]; !ok {
+		str := fmt.Sprintf("filterload flags invalid [%v]", flags)
+		return nil, messageError("NewMsgFilterLoad", ErrMalformed, str)
+	}
+
+	return &MsgFilterLoad{
+		Filter:    filter,
+		HashFuncs: hashFuncs,
+		Tweak:     tweak,
+		Flags:     flags,
+	}, nil
+}
+
+// ln2Squared is simply the square of the natural log of 2.
+const ln2Squared = math.Ln2 * math.Ln2
+
+// NewFilter returns a new filterload message with a filter sized for the
+// given number of elements and false positive rate as recommended by BIP0037.
+// The filter data is left empty (all zero bits) so the caller can insert the
+// elements to match before sending it.
+//
+// The filter size in bytes is min(-1/ln(2)^2 * elements * ln(falsePositiveRate),
+// MaxFilterLoadFilterSize*8) / 8 and the number of hash functions is
+// min(size*8/elements * ln(2), MaxFilterLoadHashFuncs).  A false positive rate
+// of zero or less results in the largest allowed filter while a rate of one or
+// more results in an empty filter which matches everything.
+func NewFilter(elements uint32, tweak uint32, falsePositiveRate float64,
+	flags BloomUpdateType) *MsgFilterLoad {
+
+	// A filter for no elements is sized the same as one for a single
+	// element to avoid dividing by zero.
+	n := float64(elements)
+	if elements == 0 {
+		n = 1
+	}
+
+	// Calculate the number of bits in the filter, clamped to the maximum
+	// allowed size.  Rates outside of (0, 1) are handled explicitly since
+	// the logarithm is not finite or negative for them.
+	maxBits := float64(MaxFilterLoadFilterSize * 8)
+	var bits float64
+	switch {
+	case !(falsePositiveRate > 0):
+		bits = maxBits
+	case falsePositiveRate >= 1:
+		bits = 0
+	default:
+		bits = math.Min(-1*n*math.Log(falsePositiveRate)/ln2Squared,
+			maxBits)
+	}
+	dataLen := uint32(bits) / 8
+
+	// Calculate the number of hash functions, clamped to the maximum
+	// allowed.
+	hashFuncs := math.Min(float64(dataLen*8)/n*math.Ln2,
+		MaxFilterLoadHashFuncs)
+
+	return &MsgFilterLoad{
+		Filter:    make([]byte, dataLen),
+		HashFuncs: uint32(hashFuncs),
+		Tweak:     tweak,
+		Flags:     flags,
+	}
+}
@@ -0,0 +1,386 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestBloomUpdateTypeStringer tests the stringized output for bloom update
+// types.
+func TestBloomUpdateTypeStringer(t *testing.T) {
+	tests := []struct {
+		in   btcwire.BloomUpdateType
+		want string
+	}{
+		{btcwire.BloomUpdateNone, "BLOOM_UPDATE_NONE"},
+		{btcwire.BloomUpdateAll, "BLOOM_UPDATE_ALL"},
+		{btcwire.BloomUpdateP2PubkeyOnly, "BLOOM_UPDATE_P2PUBKEY_ONLY"},
+		{0xff, "Unknown BloomUpdateType (255)"},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		result := test.in.String()
+		if result != test.want {
+			t.Errorf("String #%d\n got: %s want: %s", i, result,
+				test.want)
+			continue
+		}
+	}
+}
+
+// TestFilterLoad tests the MsgFilterLoad API.
+func TestFilterLoad(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "filterload"
+	msg, err := btcwire.NewMsgFilterLoad(baseFilterLoad.Filter,
+		baseFilterLoad.HashFuncs, baseFilterLoad.Tweak,
+		baseFilterLoad.Flags)
+	if err != nil {
+		t.Errorf("NewMsgFilterLoad: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(msg, baseFilterLoad) {
+		t.Errorf("NewMsgFilterLoad: wrong message - got %v, want %v",
+			spew.Sdump(msg), spew.Sdump(baseFilterLoad))
+	}
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgFilterLoad: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Num filter bytes (varInt) + max filter size + hash funcs 4 bytes +
+	// tweak 4 bytes + flags 1 byte.
+	wantPayload := uint32(36018)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Older protocol versions should fail encode and decode since the
+	// message didn't exist yet.
+	oldPver := btcwire.BIP0037Version - 1
+	var buf bytes.Buffer
+	err = baseFilterLoad.BtcEncode(&buf, oldPver)
+	if err == nil {
+		t.Errorf("encode of MsgFilterLoad succeeded when it should " +
+			"have failed")
+	}
+	var readmsg btcwire.MsgFilterLoad
+	err = readmsg.BtcDecode(bytes.NewBuffer(baseFilterLoadEncoded), oldPver)
+	if err == nil {
+		t.Errorf("decode of MsgFilterLoad succeeded when it should " +
+			"have failed")
+	}
+}
+
+// TestNewMsgFilterLoadErrors tests that NewMsgFilterLoad rejects messages
+// which exceed the limits imposed by BIP0037.
+func TestNewMsgFilterLoadErrors(t *testing.T) {
+	tests := []struct {
+		filter    []byte                  // Filter data
+		hashFuncs uint32                  // Number of hash functions
+		flags     btcwire.BloomUpdateType // Update flags
+	}{
+		// Filter too large.
+		{
+			make([]byte, btcwire.MaxFilterLoadFilterSize+1),
+			1,
+			btcwire.BloomUpdateNone,
+		},
+		// Too many hash functions.
+		{
+			[]byte{0x01},
+			btcwire.MaxFilterLoadHashFuncs + 1,
+			btcwire.BloomUpdateNone,
+		},
+		// Unknown flags.
+		{[]byte{0x01}, 1, 0x03},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		_, err := btcwire.NewMsgFilterLoad(test.filter, test.hashFuncs,
+			0, test.flags)
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			t.Errorf("NewMsgFilterLoad #%d wrong error got: %v "+
+				"<%T>, want: <*btcwire.MessageError>", i, err,
+				err)
+			continue
+		}
+	}
+
+	// Ensure the limits themselves are allowed.
+	_, err := btcwire.NewMsgFilterLoad(
+		make([]byte, btcwire.MaxFilterLoadFilterSize),
+		btcwire.MaxFilterLoadHashFuncs, 0, btcwire.BloomUpdateAll)
+	if err != nil {
+		t.Errorf("NewMsgFilterLoad: %v", err)
+	}
+}
+
+// TestNewFilter tests that NewFilter sizes filters as recommended by BIP0037.
+func TestNewFilter(t *testing.T) {
+	tests := []struct {
+		elements  uint32  // Number of elements
+		fprate    float64 // False positive rate
+		size      int     // Expected filter size in bytes
+		hashFuncs uint32  // Expected number of hash functions
+	}{
+		// Same parameters as the bitcoind bloom filter tests.
+		{3, 0.01, 3, 5},
+		{2, 0.001, 3, 8},
+		{100, 0.0001, 239, 13},
+		// No elements is treated as a single element.
+		{0, 0.01, 1, 5},
+		// Filter size is clamped to the max allowed size.
+		{1000000, 0.0001, btcwire.MaxFilterLoadFilterSize, 0},
+		{100, 0, btcwire.MaxFilterLoadFilterSize,
+			btcwire.MaxFilterLoadHashFuncs},
+		// False positive rate of one or more matches everything.
+		{100, 1, 0, 0},
+		{100, 2, 0, 0},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		msg := btcwire.NewFilter(test.elements, 0x12345678, test.fprate,
+			btcwire.BloomUpdateAll)
+		if len(msg.Filter) != test.size {
+			t.Errorf("NewFilter #%d: wrong filter size - got %d, "+
+				"want %d", i, len(msg.Filter), test.size)
+			continue
+		}
+		if msg.HashFuncs != test.hashFuncs {
+			t.Errorf("NewFilter #%d: wrong number of hash funcs - "+
+				"got %d, want %d", i, msg.HashFuncs,
+				test.hashFuncs)
+			continue
+		}
+		if msg.Tweak != 0x12345678 || msg.Flags != btcwire.BloomUpdateAll {
+			t.Errorf("NewFilter #%d: wrong tweak or flags - got "+
+				"%v, %v", i, msg.Tweak, msg.Flags)
+			continue
+		}
+
+		// Ensure the filter is ready to send.
+		var buf bytes.Buffer
+		err := msg.BtcEncode(&buf, btcwire.ProtocolVersion)
+		if err != nil {
+			t.Errorf("NewFilter #%d: BtcEncode error %v", i, err)
+			continue
+		}
+	}
+}
+
+// TestFilterLoadWire tests the MsgFilterLoad wire encode and decode for
+// various protocol versions and update flags.
+func TestFilterLoadWire(t *testing.T) {
+	// Filter load message with no filter data which updates the filter
+	// for all matches.
+	emptyFilterLoad := &btcwire.MsgFilterLoad{
+		Filter:    []byte{},
+		HashFuncs: 1,
+		Tweak:     0,
+		Flags:     btcwire.BloomUpdateAll,
+	}
+	emptyFilterLoadEncoded := []byte{
+		0x00,                   // Varint for size of filter
+		0x01, 0x00, 0x00, 0x00, // HashFuncs
+		0x00, 0x00, 0x00, 0x00, // Tweak
+		0x01, // Flags
+	}
+
+	tests := []struct {
+		in   *btcwire.MsgFilterLoad // Message to encode
+		out  *btcwire.MsgFilterLoad // Expected decoded message
+		buf  []byte                 // Wire encoding
+		pver uint32                 // Protocol version for wire encoding
+	}{
+		// Latest protocol version.
+		{
+			baseFilterLoad,
+			baseFilterLoad,
+			baseFilterLoadEncoded,
+			btcwire.ProtocolVersion,
+		},
+
+		// Protocol version BIP0037Version.
+		{
+			baseFilterLoad,
+			baseFilterLoad,
+			baseFilterLoadEncoded,
+			btcwire.BIP0037Version,
+		},
+
+		// Protocol version BIP0037Version with an empty filter.
+		{
+			emptyFilterLoad,
+			emptyFilterLoad,
+			emptyFilterLoadEncoded,
+			btcwire.BIP0037Version,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg btcwire.MsgFilterLoad
+		rbuf := bytes.NewBuffer(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestFilterLoadWireErrors performs negative tests against wire encode and
+// decode of MsgFilterLoad to confirm error paths work correctly.
+func TestFilterLoadWireErrors(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcwireErr := &btcwire.MessageError{}
+
+	// Message with update flags which are not known.
+	badFlagsEncoded := make([]byte, len(baseFilterLoadEncoded))
+	copy(badFlagsEncoded, baseFilterLoadEncoded)
+	badFlagsEncoded[len(badFlagsEncoded)-1] = 0x03
+
+	// Message with a filter which exceeds the max allowed size.  The
+	// encoded form only contains the varint for the filter size
+	// (MaxFilterLoadFilterSize + 1) since decoding must fail before the
+	// filter itself is read.
+	exceedFilterSize := &btcwire.MsgFilterLoad{
+		Filter: make([]byte, btcwire.MaxFilterLoadFilterSize+1),
+	}
+	exceedFilterSizeEncoded := []byte{0xfd, 0xa1, 0x8c}
+
+	// Message with more hash functions than allowed.
+	exceedHashFuncs := &btcwire.MsgFilterLoad{
+		Filter:    baseFilterLoad.Filter,
+		HashFuncs: btcwire.MaxFilterLoadHashFuncs + 1,
+	}
+	exceedHashFuncsEncoded := make([]byte, len(baseFilterLoadEncoded))
+	copy(exceedHashFuncsEncoded, baseFilterLoadEncoded)
+	exceedHashFuncsEncoded[4] = btcwire.MaxFilterLoadHashFuncs + 1
+
+	tests := []struct {
+		in       *btcwire.MsgFilterLoad // Value to encode
+		buf      []byte                 // Wire encoding
+		pver     uint32                 // Protocol version for wire encoding
+		max      int                    // Max size of fixed buffer to induce errors
+		writeErr error                  // Expected write error
+		readErr  error                  // Expected read error
+	}{
+		// Latest protocol version with intentional read/write errors.
+		// Force error in filter size.
+		{baseFilterLoad, baseFilterLoadEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in filter.
+		{baseFilterLoad, baseFilterLoadEncoded, pver, 1, io.ErrShortWrite, io.EOF},
+		// Force error in hash funcs.
+		{baseFilterLoad, baseFilterLoadEncoded, pver, 4, io.ErrShortWrite, io.EOF},
+		// Force error in tweak.
+		{baseFilterLoad, baseFilterLoadEncoded, pver, 8, io.ErrShortWrite, io.EOF},
+		// Force error in flags.
+		{baseFilterLoad, baseFilterLoadEncoded, pver, 12, io.ErrShortWrite, io.EOF},
+		// Force error due to unknown flags.
+		{baseFilterLoad, badFlagsEncoded, pver, len(badFlagsEncoded), nil, btcwireErr},
+		// Force error due to filter too large.
+		{exceedFilterSize, exceedFilterSizeEncoded, pver,
+			len(exceedFilterSizeEncoded), btcwireErr, btcwireErr},
+		// Force error due to too many hash functions.
+		{exceedHashFuncs, exceedHashFuncsEncoded, pver,
+			len(exceedHashFuncsEncoded), btcwireErr, btcwireErr},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg btcwire.MsgFilterLoad
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
+
+// baseFilterLoad is used in the various tests as a baseline MsgFilterLoad.
+var baseFilterLoad = &btcwire.MsgFilterLoad{
+	Filter:    []byte{0xb5, 0x0f, 0x01},
+	HashFuncs: 11,
+	Tweak:     0x5d6f4b0d,
+	Flags:     btcwire.BloomUpdateP2PubkeyOnly,
+}
+
+// baseFilterLoadEncoded is the wire encoded bytes for baseFilterLoad using
+// protocol version BIP0037Version and is used in the various tests.
+var baseFilterLoadEncoded = []byte{
+	0x03,             // Varint for size of filter
+	0xb5, 0x0f, 0x01, // Filter
+	0x0b, 0x00, 0x00, 0x00, // HashFuncs
+	0x0d, 0x4b, 0x6f, 0x5d, // Tweak
+	0x02, // Flags
+}
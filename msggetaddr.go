@@ -11,7 +11,9 @@ import (
 // MsgGetAddr implements the Message interface and represents a bitcoin
 // getaddr message.  It is used to request a list of known active peers on the
 // network from a peer to help identify potential nodes.  The list is returned
-// via one or more addr messages (MsgAddr).
+// via one or more addr messages (MsgAddr).  Each response is limited to
+// MaxAddrPerMsg addresses, and BuildAddrResponse may be used to build one from
+// a random sample of the known addresses.
 //
 // This message has no payload.
 type MsgGetAddr struct{}
@@ -34,6 +36,11 @@ func (msg *MsgGetAddr) Command() string {
 	return cmdGetAddr
 }
 
+// String returns the message in human-readable form.
+func (msg *MsgGetAddr) String() string {
+	return msg.Command()
+}
+
 // MaxPayloadLength returns the maximum length the payload can be for the
 // receiver.  This is part of the Message interface implementation.
 func (msg *MsgGetAddr) MaxPayloadLength(pver uint32) uint32 {
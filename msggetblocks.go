@@ -34,12 +34,22 @@ type MsgGetBlocks struct {
 	HashStop           ShaHash
 }
 
+// StopsAt returns the hash at which the reply to the message stops and whether
+// or not one is set.  A zero HashStop is not set and asks for as many blocks as
+// the reply can hold.
+func (msg *MsgGetBlocks) StopsAt() (*ShaHash, bool) {
+	if msg.HashStop == (ShaHash{}) {
+		return nil, false
+	}
+	return &msg.HashStop, true
+}
+
 // AddBlockLocatorHash adds a new block locator hash to the message.
 func (msg *MsgGetBlocks) AddBlockLocatorHash(hash *ShaHash) error {
 	if len(msg.BlockLocatorHashes)+1 > MaxBlockLocatorsPerMsg {
 		str := fmt.Sprintf("too many block locator hashes for message [max %v]",
 			MaxBlockLocatorsPerMsg)
-		return messageError("MsgGetBlocks.AddBlockLocatorHash", str)
+		return messageError("MsgGetBlocks.AddBlockLocatorHash", ErrTooManyItems, str)
 	}
 
 	msg.BlockLocatorHashes = append(msg.BlockLocatorHashes, hash)
@@ -62,7 +72,7 @@ func (msg *MsgGetBlocks) BtcDecode(r io.Reader, pver uint32) error {
 	if count > MaxBlockLocatorsPerMsg {
 		str := fmt.Sprintf("too many block locator hashes for message "+
 			"[count %v, max %v]", count, MaxBlockLocatorsPerMsg)
-		return messageError("MsgGetBlocks.BtcDecode", str)
+		return messageError("MsgGetBlocks.BtcDecode", ErrTooManyItems, str)
 	}
 
 	for i := uint64(0); i < count; i++ {
@@ -89,7 +99,7 @@ func (msg *MsgGetBlocks) BtcEncode(w io.Writer, pver uint32) error {
 	if count > MaxBlockLocatorsPerMsg {
 		str := fmt.Sprintf("too many block locator hashes for message "+
 			"[count %v, max %v]", count, MaxBlockLocatorsPerMsg)
-		return messageError("MsgGetBlocks.BtcEncode", str)
+		return messageError("MsgGetBlocks.BtcEncode", ErrTooManyItems, str)
 	}
 
 	err := writeElement(w, msg.ProtocolVersion)
@@ -123,6 +133,12 @@ func (msg *MsgGetBlocks) Command() string {
 	return cmdGetBlocks
 }
 
+// String returns the message in human-readable form.
+func (msg *MsgGetBlocks) String() string {
+	return fmt.Sprintf("%s pver=%d locators=%d stop=%v", msg.Command(),
+		msg.ProtocolVersion, len(msg.BlockLocatorHashes), msg.HashStop)
+}
+
 // MaxPayloadLength returns the maximum length the payload can be for the
 // receiver.  This is part of the Message interface implementation.
 func (msg *MsgGetBlocks) MaxPayloadLength(pver uint32) uint32 {
@@ -38,6 +38,14 @@ func TestGetBlocks(t *testing.T) {
 			msg.HashStop, hashStop)
 	}
 
+	// Ensure the stop hash is only reported when it is set.
+	if stop, ok := msg.StopsAt(); !ok || !stop.IsEqual(hashStop) {
+		t.Errorf("StopsAt: wrong stop hash - got %v (%v)", stop, ok)
+	}
+	if stop, ok := btcwire.NewMsgGetBlocks(&btcwire.ShaHash{}).StopsAt(); ok {
+		t.Errorf("StopsAt: unexpected stop hash %v", stop)
+	}
+
 	// Ensure the command is expected value.
 	wantCmd := "getblocks"
 	if cmd := msg.Command(); cmd != wantCmd {
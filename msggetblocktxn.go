@@ -0,0 +1,169 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"fmt"
+	"io"
+)
+
+// MsgGetBlockTxn implements the Message interface and represents a bitcoin
+// getblocktxn message as defined by BIP0152.  It is used to request the
+// transactions of a compact block (MsgCmpctBlock) which could not be found in
+// the memory pool of the receiver.  The transactions are returned with a
+// blocktxn message (MsgBlockTxn).
+//
+// Indexes are the positions of the requested transactions within the block.
+// They are differentially encoded on the wire as the number of transactions
+// since the previous requested transaction, but are always absolute positions
+// here and must be in increasing order.
+//
+// This message was not added until protocol version SendCmpctVersion.
+type MsgGetBlockTxn struct {
+	BlockHash ShaHash
+	Indexes   []uint32
+}
+
+// AddIndex adds a new transaction index to the message.  Indexes must be added
+// in increasing order.
+func (msg *MsgGetBlockTxn) AddIndex(index uint32) error {
+	if n := len(msg.Indexes); n > 0 && index <= msg.Indexes[n-1] {
+		str := fmt.Sprintf("transaction index %d does not follow "+
+			"index %d", index, msg.Indexes[n-1])
+		return messageError("MsgGetBlockTxn.AddIndex", ErrMalformed, str)
+	}
+	if index >= maxTxPerBlock {
+		str := fmt.Sprintf("transaction index %d is out of range for "+
+			"a block [max %v]", index, maxTxPerBlock)
+		return messageError("MsgGetBlockTxn.AddIndex", ErrMalformed, str)
+	}
+
+	msg.Indexes = append(msg.Indexes, index)
+	return nil
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetBlockTxn) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < SendCmpctVersion {
+		str := fmt.Sprintf("getblocktxn message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetBlockTxn.BtcDecode", ErrProtocolVersion, str)
+	}
+
+	err := readElement(r, &msg.BlockHash)
+	if err != nil {
+		return err
+	}
+
+	// Read num indexes and limit to max.
+	count, err := readVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > maxTxPerBlock {
+		str := fmt.Sprintf("too many transaction indexes for message "+
+			"[count %v, max %v]", count, maxTxPerBlock)
+		return messageError("MsgGetBlockTxn.BtcDecode", ErrTooManyItems, str)
+	}
+
+	// The indexes are differentially encoded, so each one is the number of
+	// transactions since the previous requested transaction.  Ensure each
+	// absolute index is within the largest possible block.
+	msg.Indexes = make([]uint32, 0, preallocCount(count, 4))
+	next := uint64(0)
+	for i := uint64(0); i < count; i++ {
+		diff, err := readVarInt(r, pver)
+		if err != nil {
+			return err
+		}
+		if diff >= maxTxPerBlock || next+diff >= maxTxPerBlock {
+			str := fmt.Sprintf("transaction index is out of range "+
+				"for a block [max %v]", maxTxPerBlock)
+			return messageError("MsgGetBlockTxn.BtcDecode", ErrMalformed, str)
+		}
+		index := next + diff
+		next = index + 1
+
+		msg.Indexes = append(msg.Indexes, uint32(index))
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetBlockTxn) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < SendCmpctVersion {
+		str := fmt.Sprintf("getblocktxn message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetBlockTxn.BtcEncode", ErrProtocolVersion, str)
+	}
+
+	// Limit to max transactions per block.
+	count := len(msg.Indexes)
+	if count > maxTxPerBlock {
+		str := fmt.Sprintf("too many transaction indexes for message "+
+			"[count %v, max %v]", count, maxTxPerBlock)
+		return messageError("MsgGetBlockTxn.BtcEncode", ErrTooManyItems, str)
+	}
+
+	err := writeElement(w, msg.BlockHash)
+	if err != nil {
+		return err
+	}
+
+	err = writeVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+	next := uint64(0)
+	for _, index := range msg.Indexes {
+		if uint64(index) < next || index >= maxTxPerBlock {
+			str := fmt.Sprintf("transaction index %d is out of "+
+				"order or out of range for a block [max %v]",
+				index, maxTxPerBlock)
+			return messageError("MsgGetBlockTxn.BtcEncode", ErrMalformed, str)
+		}
+
+		err = writeVarInt(w, pver, uint64(index)-next)
+		if err != nil {
+			return err
+		}
+		next = uint64(index) + 1
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetBlockTxn) Command() string {
+	return cmdGetBlockTxn
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgGetBlockTxn) String() string {
+	return fmt.Sprintf("%s block=%v indexes=%d", msg.Command(),
+		msg.BlockHash, len(msg.Indexes))
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetBlockTxn) MaxPayloadLength(pver uint32) uint32 {
+	// Block hash + num indexes (varInt) + max allowed indexes (varInt
+	// each).
+	return HashSize + maxVarIntPayload + (maxTxPerBlock * maxVarIntPayload)
+}
+
+// NewMsgGetBlockTxn returns a new bitcoin getblocktxn message that conforms to
+// the Message interface using the passed block hash.  See MsgGetBlockTxn for
+// details.
+func NewMsgGetBlockTxn(blockHash *ShaHash) *MsgGetBlockTxn {
+	return &MsgGetBlockTxn{
+		BlockHash: *blockHash,
+		Indexes:   make([]uint32, 0),
+	}
+}
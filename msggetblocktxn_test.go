@@ -0,0 +1,267 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestGetBlockTxn tests the MsgGetBlockTxn API.
+func TestGetBlockTxn(t *testing.T) {
+	pver := btcwire.SendCmpctVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "getblocktxn"
+	msg := btcwire.NewMsgGetBlockTxn(&getBlockTxnOne.BlockHash)
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgGetBlockTxn: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Block hash 32 bytes + num indexes (varInt) + max allowed indexes
+	// (varInt each).
+	wantPayload := uint32(943763)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure indexes are added properly.
+	for _, index := range getBlockTxnOne.Indexes {
+		err := msg.AddIndex(index)
+		if err != nil {
+			t.Errorf("AddIndex: %v", err)
+		}
+	}
+	if !reflect.DeepEqual(msg, &getBlockTxnOne) {
+		t.Errorf("AddIndex: wrong message - got %v, want %v",
+			spew.Sdump(msg), spew.Sdump(&getBlockTxnOne))
+	}
+
+	// Ensure indexes which are out of order or past the end of the largest
+	// possible block are rejected.
+	err := msg.AddIndex(getBlockTxnOne.Indexes[0])
+	if _, ok := err.(*btcwire.MessageError); !ok {
+		t.Errorf("AddIndex: wrong error for out of order index - "+
+			"got %v <%T>", err, err)
+	}
+	err = msg.AddIndex(btcwire.MaxBlockPayload/10 + 1)
+	if _, ok := err.(*btcwire.MessageError); !ok {
+		t.Errorf("AddIndex: wrong error for out of range index - "+
+			"got %v <%T>", err, err)
+	}
+
+	// Older protocol versions should fail encode and decode since the
+	// message didn't exist yet.
+	oldPver := btcwire.SendCmpctVersion - 1
+	var buf bytes.Buffer
+	err = getBlockTxnOne.BtcEncode(&buf, oldPver)
+	if err == nil {
+		t.Errorf("encode of MsgGetBlockTxn succeeded when it should " +
+			"have failed")
+	}
+	var readmsg btcwire.MsgGetBlockTxn
+	err = readmsg.BtcDecode(bytes.NewBuffer(getBlockTxnOneBytes), oldPver)
+	if err == nil {
+		t.Errorf("decode of MsgGetBlockTxn succeeded when it should " +
+			"have failed")
+	}
+}
+
+// TestGetBlockTxnWire tests the MsgGetBlockTxn wire encode and decode.
+func TestGetBlockTxnWire(t *testing.T) {
+	// Message which requests no transactions.
+	noIndexes := btcwire.NewMsgGetBlockTxn(&getBlockTxnOne.BlockHash)
+	noIndexesBytes := joinBytes(getBlockTxnOneBytes[:32], []byte{
+		0x00, // Varint for number of indexes
+	})
+
+	tests := []struct {
+		in   *btcwire.MsgGetBlockTxn // Message to encode
+		out  *btcwire.MsgGetBlockTxn // Expected decoded message
+		buf  []byte                  // Wire encoding
+		pver uint32                  // Protocol version for wire encoding
+	}{
+		// Protocol version SendCmpctVersion with no indexes.
+		{
+			noIndexes,
+			noIndexes,
+			noIndexesBytes,
+			btcwire.SendCmpctVersion,
+		},
+
+		// Protocol version SendCmpctVersion with differentially
+		// encoded indexes.
+		{
+			&getBlockTxnOne,
+			&getBlockTxnOne,
+			getBlockTxnOneBytes,
+			btcwire.SendCmpctVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg btcwire.MsgGetBlockTxn
+		rbuf := bytes.NewBuffer(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestGetBlockTxnWireErrors performs negative tests against wire encode and
+// decode of MsgGetBlockTxn to confirm error paths work correctly.
+func TestGetBlockTxnWireErrors(t *testing.T) {
+	pver := btcwire.SendCmpctVersion
+	btcwireErr := &btcwire.MessageError{}
+
+	// Message with more indexes than fit in a block.  The indexes are not
+	// provided since they must not be read.
+	tooManyIndexes := &btcwire.MsgGetBlockTxn{
+		Indexes: make([]uint32, btcwire.MaxBlockPayload/10+2),
+	}
+	tooManyIndexesBytes := joinBytes(make([]byte, 32), []byte{
+		0xfe, 0x9b, 0x99, 0x01, 0x00, // Varint for number of indexes
+	})
+
+	// Message with an index past the end of the largest possible block.
+	indexTooLarge := &btcwire.MsgGetBlockTxn{
+		Indexes: []uint32{btcwire.MaxBlockPayload/10 + 1},
+	}
+	indexTooLargeBytes := joinBytes(make([]byte, 32), []byte{
+		0x01,                         // Varint for number of indexes
+		0xfe, 0x9a, 0x99, 0x01, 0x00, // Varint for index
+	})
+
+	// Message with indexes which are out of order.  The wire encoding
+	// instead holds an index which would overflow when added to the
+	// previous one.
+	indexOutOfOrder := &btcwire.MsgGetBlockTxn{
+		Indexes: []uint32{2, 1},
+	}
+	indexOverflowBytes := joinBytes(make([]byte, 32), []byte{
+		0x02, // Varint for number of indexes
+		0x00, // Varint for index
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0xff, // Varint for index
+	})
+
+	tests := []struct {
+		in       *btcwire.MsgGetBlockTxn // Value to encode
+		buf      []byte                  // Wire encoding
+		pver     uint32                  // Protocol version for wire encoding
+		max      int                     // Max size of fixed buffer to induce errors
+		writeErr error                   // Expected write error
+		readErr  error                   // Expected read error
+	}{
+		// Force error in block hash.
+		{&getBlockTxnOne, getBlockTxnOneBytes, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in num indexes.
+		{&getBlockTxnOne, getBlockTxnOneBytes, pver, 32, io.ErrShortWrite, io.EOF},
+		// Force error in indexes.
+		{&getBlockTxnOne, getBlockTxnOneBytes, pver, 33, io.ErrShortWrite, io.EOF},
+		// Force error with greater than max transactions per block.
+		{tooManyIndexes, tooManyIndexesBytes, pver,
+			len(tooManyIndexesBytes), btcwireErr, btcwireErr},
+		// Force error with an index past the end of the block.
+		{indexTooLarge, indexTooLargeBytes, pver,
+			len(indexTooLargeBytes), btcwireErr, btcwireErr},
+		// Force error with indexes out of order or which overflow.
+		{indexOutOfOrder, indexOverflowBytes, pver,
+			len(indexOverflowBytes), btcwireErr, btcwireErr},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg btcwire.MsgGetBlockTxn
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
+
+// getBlockTxnOne is a getblocktxn message which requests transactions of the
+// genesis block with indexes that are differentially encoded as both single
+// and multi-byte varints.
+var getBlockTxnOne = btcwire.MsgGetBlockTxn{
+	BlockHash: blockOne.Header.PrevBlock,
+	Indexes:   []uint32{0, 2, 3, 300},
+}
+
+// getBlockTxnOneBytes is the serialized bytes for getBlockTxnOne.
+var getBlockTxnOneBytes = joinBytes(
+	blockOneBytes[4:36], // Block hash
+	[]byte{
+		0x04,             // Varint for number of indexes
+		0x00,             // Varint for index 0
+		0x01,             // Varint for index 2
+		0x00,             // Varint for index 3
+		0xfd, 0x28, 0x01, // Varint for index 300
+	},
+)
@@ -0,0 +1,74 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"fmt"
+	"io"
+)
+
+// MsgGetCFCheckpt implements the Message interface and represents a bitcoin
+// getcfcheckpt message as defined by BIP0157.  It is used to request the
+// filter headers at every CFCheckptInterval blocks up to the block with
+// StopHash, which are returned in a cfcheckpt message (MsgCFCheckpt).
+//
+// This message was not added until protocol version CFilterVersion.
+type MsgGetCFCheckpt struct {
+	FilterType FilterType
+	StopHash   ShaHash
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetCFCheckpt) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < CFilterVersion {
+		str := fmt.Sprintf("getcfcheckpt message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetCFCheckpt.BtcDecode", ErrProtocolVersion, str)
+	}
+
+	return readElements(r, &msg.FilterType, &msg.StopHash)
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetCFCheckpt) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < CFilterVersion {
+		str := fmt.Sprintf("getcfcheckpt message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetCFCheckpt.BtcEncode", ErrProtocolVersion, str)
+	}
+
+	return writeElements(w, msg.FilterType, msg.StopHash)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetCFCheckpt) Command() string {
+	return cmdGetCFCheckpt
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgGetCFCheckpt) String() string {
+	return fmt.Sprintf("%s type=%v stop=%v", msg.Command(), msg.FilterType,
+		msg.StopHash)
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetCFCheckpt) MaxPayloadLength(pver uint32) uint32 {
+	// Filter type 1 byte + stop hash.
+	return 1 + HashSize
+}
+
+// NewMsgGetCFCheckpt returns a new bitcoin getcfcheckpt message that conforms
+// to the Message interface using the passed parameters.  See MsgGetCFCheckpt
+// for details.
+func NewMsgGetCFCheckpt(filterType FilterType, stopHash *ShaHash) *MsgGetCFCheckpt {
+	return &MsgGetCFCheckpt{
+		FilterType: filterType,
+		StopHash:   *stopHash,
+	}
+}
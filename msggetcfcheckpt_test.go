@@ -0,0 +1,143 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestGetCFCheckpt tests the MsgGetCFCheckpt API.
+func TestGetCFCheckpt(t *testing.T) {
+	pver := btcwire.CFilterVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "getcfcheckpt"
+	msg := btcwire.NewMsgGetCFCheckpt(btcwire.GCSFilterRegular,
+		&baseGetCFCheckpt.StopHash)
+	if !reflect.DeepEqual(msg, baseGetCFCheckpt) {
+		t.Errorf("NewMsgGetCFCheckpt: wrong message - got %v, want %v",
+			spew.Sdump(msg), spew.Sdump(baseGetCFCheckpt))
+	}
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgGetCFCheckpt: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Filter type 1 byte + stop hash 32 bytes.
+	wantPayload := uint32(33)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Older protocol versions should fail encode and decode since the
+	// message didn't exist yet.
+	oldPver := btcwire.CFilterVersion - 1
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, oldPver)
+	if err == nil {
+		t.Errorf("encode of MsgGetCFCheckpt succeeded when it should " +
+			"have failed")
+	}
+	var readmsg btcwire.MsgGetCFCheckpt
+	err = readmsg.BtcDecode(bytes.NewBuffer(baseGetCFCheckptEncoded), oldPver)
+	if err == nil {
+		t.Errorf("decode of MsgGetCFCheckpt succeeded when it should " +
+			"have failed")
+	}
+}
+
+// TestGetCFCheckptWire tests the MsgGetCFCheckpt wire encode and decode.
+func TestGetCFCheckptWire(t *testing.T) {
+	// Encode the message to wire format.
+	var buf bytes.Buffer
+	err := baseGetCFCheckpt.BtcEncode(&buf, btcwire.CFilterVersion)
+	if err != nil {
+		t.Errorf("BtcEncode error %v", err)
+		return
+	}
+	if !bytes.Equal(buf.Bytes(), baseGetCFCheckptEncoded) {
+		t.Errorf("BtcEncode\n got: %s want: %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(baseGetCFCheckptEncoded))
+	}
+
+	// Decode the message from wire format.
+	var msg btcwire.MsgGetCFCheckpt
+	err = msg.BtcDecode(&buf, btcwire.CFilterVersion)
+	if err != nil {
+		t.Errorf("BtcDecode error %v", err)
+		return
+	}
+	if !reflect.DeepEqual(&msg, baseGetCFCheckpt) {
+		t.Errorf("BtcDecode\n got: %s want: %s", spew.Sdump(&msg),
+			spew.Sdump(baseGetCFCheckpt))
+	}
+}
+
+// TestGetCFCheckptWireErrors performs negative tests against wire encode and
+// decode of MsgGetCFCheckpt to confirm error paths work correctly.
+func TestGetCFCheckptWireErrors(t *testing.T) {
+	pver := btcwire.CFilterVersion
+
+	tests := []struct {
+		in       *btcwire.MsgGetCFCheckpt // Value to encode
+		buf      []byte                   // Wire encoding
+		pver     uint32                   // Protocol version for wire encoding
+		max      int                      // Max size of fixed buffer to induce errors
+		writeErr error                    // Expected write error
+		readErr  error                    // Expected read error
+	}{
+		// Force error in filter type.
+		{baseGetCFCheckpt, baseGetCFCheckptEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in stop hash.
+		{baseGetCFCheckpt, baseGetCFCheckptEncoded, pver, 1, io.ErrShortWrite, io.EOF},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if err != test.writeErr {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// Decode from wire format.
+		var msg btcwire.MsgGetCFCheckpt
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if err != test.readErr {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+	}
+}
+
+// baseGetCFCheckpt is used in the various tests as a baseline
+// MsgGetCFCheckpt.
+var baseGetCFCheckpt = &btcwire.MsgGetCFCheckpt{
+	FilterType: btcwire.GCSFilterRegular,
+	StopHash:   blockOne.Header.PrevBlock,
+}
+
+// baseGetCFCheckptEncoded is the wire encoded bytes for baseGetCFCheckpt
+// using protocol version CFilterVersion and is used in the various tests.
+var baseGetCFCheckptEncoded = joinBytes(
+	[]byte{
+		0x00, // Filter type
+	},
+	blockOneBytes[4:36], // Stop hash
+)
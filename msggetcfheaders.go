@@ -0,0 +1,80 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"fmt"
+	"io"
+)
+
+// MsgGetCFHeaders implements the Message interface and represents a bitcoin
+// getcfheaders message as defined by BIP0157.  It is used to request the
+// compact filter headers of a range of blocks, from the block at StartHeight
+// through the block with StopHash, which are returned in a cfheaders message
+// (MsgCFHeaders).  The range must not include more than MaxCFHeadersPerMsg
+// blocks.
+//
+// This message was not added until protocol version CFilterVersion.
+type MsgGetCFHeaders struct {
+	FilterType  FilterType
+	StartHeight uint32
+	StopHash    ShaHash
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetCFHeaders) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < CFilterVersion {
+		str := fmt.Sprintf("getcfheaders message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetCFHeaders.BtcDecode", ErrProtocolVersion, str)
+	}
+
+	return readElements(r, &msg.FilterType, &msg.StartHeight, &msg.StopHash)
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetCFHeaders) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < CFilterVersion {
+		str := fmt.Sprintf("getcfheaders message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetCFHeaders.BtcEncode", ErrProtocolVersion, str)
+	}
+
+	return writeElements(w, msg.FilterType, msg.StartHeight, msg.StopHash)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetCFHeaders) Command() string {
+	return cmdGetCFHeaders
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgGetCFHeaders) String() string {
+	return fmt.Sprintf("%s type=%v start=%d stop=%v", msg.Command(),
+		msg.FilterType, msg.StartHeight, msg.StopHash)
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetCFHeaders) MaxPayloadLength(pver uint32) uint32 {
+	// Filter type 1 byte + start height 4 bytes + stop hash.
+	return 1 + 4 + HashSize
+}
+
+// NewMsgGetCFHeaders returns a new bitcoin getcfheaders message that conforms
+// to the Message interface using the passed parameters.  See MsgGetCFHeaders
+// for details.
+func NewMsgGetCFHeaders(filterType FilterType, startHeight uint32,
+	stopHash *ShaHash) *MsgGetCFHeaders {
+
+	return &MsgGetCFHeaders{
+		FilterType:  filterType,
+		StartHeight: startHeight,
+		StopHash:    *stopHash,
+	}
+}
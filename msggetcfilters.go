@@ -0,0 +1,84 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"fmt"
+	"io"
+)
+
+// MaxGetCFiltersReqRange is the maximum number of filters that may be
+// requested in a single getcfilters message.
+const MaxGetCFiltersReqRange = 1000
+
+// MsgGetCFilters implements the Message interface and represents a bitcoin
+// getcfilters message as defined by BIP0157.  It is used to request the
+// compact filters of a range of blocks, from the block at StartHeight through
+// the block with StopHash, which are returned in cfilter messages
+// (MsgCFilter).  The range must not include more than MaxGetCFiltersReqRange
+// blocks.
+//
+// This message was not added until protocol version CFilterVersion.
+type MsgGetCFilters struct {
+	FilterType  FilterType
+	StartHeight uint32
+	StopHash    ShaHash
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetCFilters) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < CFilterVersion {
+		str := fmt.Sprintf("getcfilters message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetCFilters.BtcDecode", ErrProtocolVersion, str)
+	}
+
+	return readElements(r, &msg.FilterType, &msg.StartHeight, &msg.StopHash)
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetCFilters) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < CFilterVersion {
+		str := fmt.Sprintf("getcfilters message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgGetCFilters.BtcEncode", ErrProtocolVersion, str)
+	}
+
+	return writeElements(w, msg.FilterType, msg.StartHeight, msg.StopHash)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetCFilters) Command() string {
+	return cmdGetCFilters
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgGetCFilters) String() string {
+	return fmt.Sprintf("%s type=%v start=%d stop=%v", msg.Command(),
+		msg.FilterType, msg.StartHeight, msg.StopHash)
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetCFilters) MaxPayloadLength(pver uint32) uint32 {
+	// Filter type 1 byte + start height 4 bytes + stop hash.
+	return 1 + 4 + HashSize
+}
+
+// NewMsgGetCFilters returns a new bitcoin getcfilters message that conforms to
+// the Message interface using the passed parameters.  See MsgGetCFilters for
+// details.
+func NewMsgGetCFilters(filterType FilterType, startHeight uint32,
+	stopHash *ShaHash) *MsgGetCFilters {
+
+	return &MsgGetCFilters{
+		FilterType:  filterType,
+		StartHeight: startHeight,
+		StopHash:    *stopHash,
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestGetCFilters tests the MsgGetCFilters API.
+func TestGetCFilters(t *testing.T) {
+	pver := btcwire.CFilterVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "getcfilters"
+	msg := btcwire.NewMsgGetCFilters(btcwire.GCSFilterRegular, 1000,
+		&baseGetCFilters.StopHash)
+	if !reflect.DeepEqual(msg, baseGetCFilters) {
+		t.Errorf("NewMsgGetCFilters: wrong message - got %v, want %v",
+			spew.Sdump(msg), spew.Sdump(baseGetCFilters))
+	}
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgGetCFilters: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Filter type 1 byte + start height 4 bytes + stop hash 32 bytes.
+	wantPayload := uint32(37)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Older protocol versions should fail encode and decode since the
+	// message didn't exist yet.
+	oldPver := btcwire.CFilterVersion - 1
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, oldPver)
+	if err == nil {
+		t.Errorf("encode of MsgGetCFilters succeeded when it should " +
+			"have failed")
+	}
+	var readmsg btcwire.MsgGetCFilters
+	err = readmsg.BtcDecode(bytes.NewBuffer(baseGetCFiltersEncoded), oldPver)
+	if err == nil {
+		t.Errorf("decode of MsgGetCFilters succeeded when it should " +
+			"have failed")
+	}
+}
+
+// TestGetCFiltersWire tests the MsgGetCFilters wire encode and decode.
+func TestGetCFiltersWire(t *testing.T) {
+	// Encode the message to wire format.
+	var buf bytes.Buffer
+	err := baseGetCFilters.BtcEncode(&buf, btcwire.CFilterVersion)
+	if err != nil {
+		t.Errorf("BtcEncode error %v", err)
+		return
+	}
+	if !bytes.Equal(buf.Bytes(), baseGetCFiltersEncoded) {
+		t.Errorf("BtcEncode\n got: %s want: %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(baseGetCFiltersEncoded))
+	}
+
+	// Decode the message from wire format.
+	var msg btcwire.MsgGetCFilters
+	err = msg.BtcDecode(&buf, btcwire.CFilterVersion)
+	if err != nil {
+		t.Errorf("BtcDecode error %v", err)
+		return
+	}
+	if !reflect.DeepEqual(&msg, baseGetCFilters) {
+		t.Errorf("BtcDecode\n got: %s want: %s", spew.Sdump(&msg),
+			spew.Sdump(baseGetCFilters))
+	}
+}
+
+// TestGetCFiltersWireErrors performs negative tests against wire encode and
+// decode of MsgGetCFilters to confirm error paths work correctly.
+func TestGetCFiltersWireErrors(t *testing.T) {
+	pver := btcwire.CFilterVersion
+
+	tests := []struct {
+		in       *btcwire.MsgGetCFilters // Value to encode
+		buf      []byte                  // Wire encoding
+		pver     uint32                  // Protocol version for wire encoding
+		max      int                     // Max size of fixed buffer to induce errors
+		writeErr error                   // Expected write error
+		readErr  error                   // Expected read error
+	}{
+		// Force error in filter type.
+		{baseGetCFilters, baseGetCFiltersEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in start height.
+		{baseGetCFilters, baseGetCFiltersEncoded, pver, 1, io.ErrShortWrite, io.EOF},
+		// Force error in stop hash.
+		{baseGetCFilters, baseGetCFiltersEncoded, pver, 5, io.ErrShortWrite, io.EOF},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if err != test.writeErr {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// Decode from wire format.
+		var msg btcwire.MsgGetCFilters
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if err != test.readErr {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+	}
+}
+
+// baseGetCFilters is used in the various tests as a baseline MsgGetCFilters.
+var baseGetCFilters = &btcwire.MsgGetCFilters{
+	FilterType:  btcwire.GCSFilterRegular,
+	StartHeight: 1000,
+	StopHash:    blockOne.Header.PrevBlock,
+}
+
+// baseGetCFiltersEncoded is the wire encoded bytes for baseGetCFilters using
+// protocol version CFilterVersion and is used in the various tests.
+var baseGetCFiltersEncoded = joinBytes(
+	[]byte{
+		0x00,                   // Filter type
+		0xe8, 0x03, 0x00, 0x00, // Start height
+	},
+	blockOneBytes[4:36], // Stop hash
+)
@@ -25,65 +25,42 @@ type MsgGetData struct {
 
 // AddInvVect adds an inventory vector to the message.
 func (msg *MsgGetData) AddInvVect(iv *InvVect) error {
-	if len(msg.InvList)+1 > MaxInvPerMsg {
-		str := fmt.Sprintf("too many invvect in message [max %v]",
-			MaxInvPerMsg)
-		return messageError("MsgGetData.AddInvVect", str)
+	var err error
+	msg.InvList, err = addInvVect(msg.InvList, iv, "MsgGetData.AddInvVect")
+	return err
+}
+
+// UpgradeToWitness rewrites the inventory vectors in the message in place so
+// that transactions and blocks are requested along with their witness data.
+// This is used when requesting data from a peer which supports segregated
+// witness.  Inventory vectors of any other type, including those which already
+// request witness data and filtered blocks, are left untouched.
+func (msg *MsgGetData) UpgradeToWitness() {
+	for _, iv := range msg.InvList {
+		iv.Type = iv.Type.Witness()
 	}
+}
 
-	msg.InvList = append(msg.InvList, iv)
-	return nil
+// Dedup removes all but the first occurrence of any duplicate inventory vectors
+// from the message.  The order of the remaining inventory vectors is preserved.
+// This is useful to avoid requesting or announcing the same data more than
+// once when the message is built from several sources.
+func (msg *MsgGetData) Dedup() {
+	msg.InvList = dedupInvList(msg.InvList)
 }
 
 // BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
 // This is part of the Message interface implementation.
 func (msg *MsgGetData) BtcDecode(r io.Reader, pver uint32) error {
-	count, err := readVarInt(r, pver)
-	if err != nil {
-		return err
-	}
-
-	// Limit to max inventory vectors per message.
-	if count > MaxInvPerMsg {
-		str := fmt.Sprintf("too many invvect in message [%v]", count)
-		return messageError("MsgGetData.BtcDecode", str)
-	}
-
-	for i := uint64(0); i < count; i++ {
-		iv := InvVect{}
-		err := readInvVect(r, pver, &iv)
-		if err != nil {
-			return err
-		}
-		msg.AddInvVect(&iv)
-	}
-
-	return nil
+	var err error
+	msg.InvList, err = readInvList(r, pver, msg.InvList, "MsgGetData.BtcDecode")
+	return err
 }
 
 // BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
 // This is part of the Message interface implementation.
 func (msg *MsgGetData) BtcEncode(w io.Writer, pver uint32) error {
-	// Limit to max inventory vectors per message.
-	count := len(msg.InvList)
-	if count > MaxInvPerMsg {
-		str := fmt.Sprintf("too many invvect in message [%v]", count)
-		return messageError("MsgGetData.BtcEncode", str)
-	}
-
-	err := writeVarInt(w, pver, uint64(count))
-	if err != nil {
-		return err
-	}
-
-	for _, iv := range msg.InvList {
-		err := writeInvVect(w, pver, iv)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return writeInvList(w, pver, msg.InvList, "MsgGetData.BtcEncode")
 }
 
 // Command returns the protocol command string for the message.  This is part
@@ -92,6 +69,11 @@ func (msg *MsgGetData) Command() string {
 	return cmdGetData
 }
 
+// String returns the message in human-readable form.
+func (msg *MsgGetData) String() string {
+	return fmt.Sprintf("%s count=%d", msg.Command(), len(msg.InvList))
+}
+
 // MaxPayloadLength returns the maximum length the payload can be for the
 // receiver.  This is part of the Message interface implementation.
 func (msg *MsgGetData) MaxPayloadLength(pver uint32) uint32 {
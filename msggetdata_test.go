@@ -60,6 +60,103 @@ func TestGetData(t *testing.T) {
 	return
 }
 
+// TestGetDataUpgradeToWitness tests upgrading the inventory vectors in a
+// getdata message to request witness data.
+func TestGetDataUpgradeToWitness(t *testing.T) {
+	hash := btcwire.ShaHash{}
+	tests := []struct {
+		in   btcwire.InvType // Inventory type before the upgrade
+		want btcwire.InvType // Expected inventory type after the upgrade
+	}{
+		{btcwire.InvVect_Error, btcwire.InvVect_Error},
+		{btcwire.InvVect_Tx, btcwire.InvVect_WitnessTx},
+		{btcwire.InvVect_Block, btcwire.InvVect_WitnessBlock},
+		{btcwire.InvVect_FilteredBlock, btcwire.InvVect_FilteredBlock},
+		{btcwire.InvVect_WitnessTx, btcwire.InvVect_WitnessTx},
+		{btcwire.InvVect_WitnessBlock, btcwire.InvVect_WitnessBlock},
+		{btcwire.InvVect_FilteredWitnessBlock,
+			btcwire.InvVect_FilteredWitnessBlock},
+	}
+
+	msg := btcwire.NewMsgGetData()
+	for _, test := range tests {
+		msg.AddInvVect(btcwire.NewInvVect(test.in, &hash))
+	}
+	msg.UpgradeToWitness()
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		if got := msg.InvList[i].Type; got != test.want {
+			t.Errorf("UpgradeToWitness #%d: wrong type - got %v, "+
+				"want %v", i, got, test.want)
+			continue
+		}
+	}
+}
+
+// TestGetDataDedup tests the MsgGetData Dedup function removes duplicate inventory
+// vectors while preserving the order of the remaining ones.
+func TestGetDataDedup(t *testing.T) {
+	hash1 := btcwire.ShaHash{0x01}
+	hash2 := btcwire.ShaHash{0x02}
+	txIV1 := btcwire.NewInvVect(btcwire.InvVect_Tx, &hash1)
+	txIV2 := btcwire.NewInvVect(btcwire.InvVect_Tx, &hash2)
+	blockIV1 := btcwire.NewInvVect(btcwire.InvVect_Block, &hash1)
+
+	tests := []struct {
+		in   []*btcwire.InvVect // Inventory vectors to dedup
+		want []*btcwire.InvVect // Expected inventory vectors
+	}{
+		// No inventory vectors.
+		{nil, nil},
+
+		// No duplicates.
+		{
+			[]*btcwire.InvVect{txIV1, txIV2, blockIV1},
+			[]*btcwire.InvVect{txIV1, txIV2, blockIV1},
+		},
+
+		// Duplicate with the same hash, but a different type is kept.
+		{
+			[]*btcwire.InvVect{txIV1, blockIV1, txIV1},
+			[]*btcwire.InvVect{txIV1, blockIV1},
+		},
+
+		// Equal, but distinct, inventory vectors are duplicates.
+		{
+			[]*btcwire.InvVect{
+				txIV2, txIV1,
+				btcwire.NewInvVect(btcwire.InvVect_Tx, &hash2),
+				txIV1,
+			},
+			[]*btcwire.InvVect{txIV2, txIV1},
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		msg := btcwire.NewMsgGetData()
+		for _, iv := range test.in {
+			msg.AddInvVect(iv)
+		}
+		msg.Dedup()
+
+		if len(msg.InvList) != len(test.want) {
+			t.Errorf("Dedup #%d\n got: %s want: %s", i,
+				spew.Sdump(msg.InvList), spew.Sdump(test.want))
+			continue
+		}
+		for j, iv := range msg.InvList {
+			if iv != test.want[j] {
+				t.Errorf("Dedup #%d\n got: %s want: %s", i,
+					spew.Sdump(msg.InvList),
+					spew.Sdump(test.want))
+				break
+			}
+		}
+	}
+}
+
 // TestGetDataWire tests the MsgGetData wire encode and decode for various
 // numbers of inventory vectors and protocol versions.
 func TestGetDataWire(t *testing.T) {
@@ -31,12 +31,22 @@ type MsgGetHeaders struct {
 	HashStop           ShaHash
 }
 
+// StopsAt returns the hash at which the reply to the message stops and whether
+// or not one is set.  A zero HashStop is not set and asks for as many headers as
+// the reply can hold.
+func (msg *MsgGetHeaders) StopsAt() (*ShaHash, bool) {
+	if msg.HashStop == (ShaHash{}) {
+		return nil, false
+	}
+	return &msg.HashStop, true
+}
+
 // AddBlockLocatorHash adds a new block locator hash to the message.
 func (msg *MsgGetHeaders) AddBlockLocatorHash(hash *ShaHash) error {
 	if len(msg.BlockLocatorHashes)+1 > MaxBlockLocatorsPerMsg {
 		str := fmt.Sprintf("too many block locator hashes for message [max %v]",
 			MaxBlockLocatorsPerMsg)
-		return messageError("MsgGetHeaders.AddBlockLocatorHash", str)
+		return messageError("MsgGetHeaders.AddBlockLocatorHash", ErrTooManyItems, str)
 	}
 
 	msg.BlockLocatorHashes = append(msg.BlockLocatorHashes, hash)
@@ -59,7 +69,7 @@ func (msg *MsgGetHeaders) BtcDecode(r io.Reader, pver uint32) error {
 	if count > MaxBlockLocatorsPerMsg {
 		str := fmt.Sprintf("too many block locator hashes for message "+
 			"[count %v, max %v]", count, MaxBlockLocatorsPerMsg)
-		return messageError("MsgGetHeaders.BtcDecode", str)
+		return messageError("MsgGetHeaders.BtcDecode", ErrTooManyItems, str)
 	}
 
 	for i := uint64(0); i < count; i++ {
@@ -87,7 +97,7 @@ func (msg *MsgGetHeaders) BtcEncode(w io.Writer, pver uint32) error {
 	if count > MaxBlockLocatorsPerMsg {
 		str := fmt.Sprintf("too many block locator hashes for message "+
 			"[count %v, max %v]", count, MaxBlockLocatorsPerMsg)
-		return messageError("MsgGetHeaders.BtcEncode", str)
+		return messageError("MsgGetHeaders.BtcEncode", ErrTooManyItems, str)
 	}
 
 	err := writeElement(w, msg.ProtocolVersion)
@@ -121,6 +131,12 @@ func (msg *MsgGetHeaders) Command() string {
 	return cmdGetHeaders
 }
 
+// String returns the message in human-readable form.
+func (msg *MsgGetHeaders) String() string {
+	return fmt.Sprintf("%s pver=%d locators=%d stop=%v", msg.Command(),
+		msg.ProtocolVersion, len(msg.BlockLocatorHashes), msg.HashStop)
+}
+
 // MaxPayloadLength returns the maximum length the payload can be for the
 // receiver.  This is part of the Message interface implementation.
 func (msg *MsgGetHeaders) MaxPayloadLength(pver uint32) uint32 {
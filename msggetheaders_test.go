@@ -32,6 +32,15 @@ func TestGetHeaders(t *testing.T) {
 			cmd, wantCmd)
 	}
 
+	// Ensure the stop hash is only reported when it is set.
+	if stop, ok := msg.StopsAt(); ok {
+		t.Errorf("StopsAt: unexpected stop hash %v", stop)
+	}
+	msg.HashStop = *locatorHash
+	if stop, ok := msg.StopsAt(); !ok || !stop.IsEqual(locatorHash) {
+		t.Errorf("StopsAt: wrong stop hash - got %v (%v)", stop, ok)
+	}
+
 	// Ensure max payload is expected value for latest protocol version.
 	// Protocol version 4 bytes + num hashes (varInt) + max block locator
 	// hashes + hash stop.
@@ -0,0 +1,144 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"fmt"
+	"io"
+)
+
+// MaxOutPointsPerGetUTXOs is the maximum number of outpoints that can be
+// queried in a single bitcoin getutxos message (MsgGetUTXOs).
+const MaxOutPointsPerGetUTXOs = 100
+
+// maxOutPointPayload is the maximum payload size for an outpoint.
+// Hash 32 bytes + index 4 bytes.
+const maxOutPointPayload = HashSize + 4
+
+// MsgGetUTXOs implements the Message interface and represents a bitcoin
+// getutxos message as defined by BIP0064.  It is used to query a peer for the
+// state of a list of transaction outputs.  The results are returned via a
+// utxos message (MsgUTXOs).  Each message is limited to a maximum number of
+// outpoints, which is currently 100.
+//
+// This message should only be sent to peers which advertise the SFNodeGetUTXO
+// service flag.
+//
+// Use the AddOutPoint function to build up the list of outpoints to query.
+type MsgGetUTXOs struct {
+	// CheckMemPool indicates whether or not the remote peer should take
+	// transactions in its memory pool into account.
+	CheckMemPool bool
+
+	// OutPoints is the list of outpoints to query.
+	OutPoints []*OutPoint
+}
+
+// AddOutPoint adds an outpoint to the list of outpoints to query.
+func (msg *MsgGetUTXOs) AddOutPoint(op *OutPoint) error {
+	if len(msg.OutPoints)+1 > MaxOutPointsPerGetUTXOs {
+		str := fmt.Sprintf("too many outpoints in message [max %v]",
+			MaxOutPointsPerGetUTXOs)
+		return messageError("MsgGetUTXOs.AddOutPoint", ErrTooManyItems, str)
+	}
+
+	msg.OutPoints = append(msg.OutPoints, op)
+	return nil
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetUTXOs) BtcDecode(r io.Reader, pver uint32) error {
+	err := readElement(r, &msg.CheckMemPool)
+	if err != nil {
+		return err
+	}
+
+	count, err := readVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+
+	// Limit to max outpoints per message.
+	if count > MaxOutPointsPerGetUTXOs {
+		str := fmt.Sprintf("too many outpoints for message "+
+			"[count %v, max %v]", count, MaxOutPointsPerGetUTXOs)
+		return messageError("MsgGetUTXOs.BtcDecode", ErrTooManyItems, str)
+	}
+
+	for i := uint64(0); i < count; i++ {
+		op := OutPoint{}
+		err := readOutPoint(r, pver, 0, &op)
+		if err != nil {
+			return err
+		}
+		err = msg.AddOutPoint(&op)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetUTXOs) BtcEncode(w io.Writer, pver uint32) error {
+	// Limit to max outpoints per message.
+	count := len(msg.OutPoints)
+	if count > MaxOutPointsPerGetUTXOs {
+		str := fmt.Sprintf("too many outpoints for message "+
+			"[count %v, max %v]", count, MaxOutPointsPerGetUTXOs)
+		return messageError("MsgGetUTXOs.BtcEncode", ErrTooManyItems, str)
+	}
+
+	err := writeElement(w, msg.CheckMemPool)
+	if err != nil {
+		return err
+	}
+
+	err = writeVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+
+	for _, op := range msg.OutPoints {
+		err := writeOutPoint(w, pver, 0, op)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetUTXOs) Command() string {
+	return cmdGetUTXOs
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgGetUTXOs) String() string {
+	return fmt.Sprintf("%s mempool=%v outpoints=%d", msg.Command(),
+		msg.CheckMemPool, len(msg.OutPoints))
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetUTXOs) MaxPayloadLength(pver uint32) uint32 {
+	// Check mempool 1 byte + num outpoints (varInt) + max allowed
+	// outpoints.
+	return 1 + maxVarIntPayload + (MaxOutPointsPerGetUTXOs *
+		maxOutPointPayload)
+}
+
+// NewMsgGetUTXOs returns a new bitcoin getutxos message that conforms to the
+// Message interface.  See MsgGetUTXOs for details.
+func NewMsgGetUTXOs(checkMemPool bool) *MsgGetUTXOs {
+	return &MsgGetUTXOs{
+		CheckMemPool: checkMemPool,
+	}
+}
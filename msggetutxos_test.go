@@ -0,0 +1,239 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestGetUTXOs tests the MsgGetUTXOs API.
+func TestGetUTXOs(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "getutxos"
+	msg := btcwire.NewMsgGetUTXOs(true)
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgGetUTXOs: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+	if !msg.CheckMemPool {
+		t.Errorf("NewMsgGetUTXOs: check mempool flag not set")
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Check mempool 1 byte + num outpoints (varInt) + max allowed
+	// outpoints.
+	wantPayload := uint32(3610)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure outpoints are added properly.
+	op := btcwire.NewOutPoint(&btcwire.GenesisMerkleRoot, 0)
+	err := msg.AddOutPoint(op)
+	if err != nil {
+		t.Errorf("AddOutPoint: %v", err)
+	}
+	if msg.OutPoints[0] != op {
+		t.Errorf("AddOutPoint: wrong outpoint added - got %v, want %v",
+			spew.Sprint(msg.OutPoints[0]), spew.Sprint(op))
+	}
+
+	// Ensure adding more than the max allowed outpoints per message returns
+	// an error.
+	for i := 0; i < btcwire.MaxOutPointsPerGetUTXOs; i++ {
+		err = msg.AddOutPoint(op)
+	}
+	if err == nil {
+		t.Errorf("AddOutPoint: expected error on too many outpoints " +
+			"not received")
+	}
+
+	return
+}
+
+// TestGetUTXOsWire tests the MsgGetUTXOs wire encode and decode for various
+// numbers of outpoints.
+func TestGetUTXOsWire(t *testing.T) {
+	// Empty getutxos message.
+	noOutPoints := btcwire.NewMsgGetUTXOs(false)
+	noOutPointsEncoded := []byte{
+		0x00, // Check mempool
+		0x00, // Varint for number of outpoints
+	}
+
+	// getutxos message with multiple outpoints.
+	multiOutPoints := btcwire.NewMsgGetUTXOs(true)
+	multiOutPoints.AddOutPoint(btcwire.NewOutPoint(&btcwire.GenesisMerkleRoot, 0))
+	multiOutPoints.AddOutPoint(btcwire.NewOutPoint(&btcwire.GenesisHash, 1))
+	multiOutPointsEncoded := []byte{
+		0x01, // Check mempool
+		0x02, // Varint for number of outpoints
+		0x3b, 0xa3, 0xed, 0xfd, 0x7a, 0x7b, 0x12, 0xb2,
+		0x7a, 0xc7, 0x2c, 0x3e, 0x67, 0x76, 0x8f, 0x61,
+		0x7f, 0xc8, 0x1b, 0xc3, 0x88, 0x8a, 0x51, 0x32,
+		0x3a, 0x9f, 0xb8, 0xaa, 0x4b, 0x1e, 0x5e, 0x4a, // Hash
+		0x00, 0x00, 0x00, 0x00, // Index
+		0x6f, 0xe2, 0x8c, 0x0a, 0xb6, 0xf1, 0xb3, 0x72,
+		0xc1, 0xa6, 0xa2, 0x46, 0xae, 0x63, 0xf7, 0x4f,
+		0x93, 0x1e, 0x83, 0x65, 0xe1, 0x5a, 0x08, 0x9c,
+		0x68, 0xd6, 0x19, 0x00, 0x00, 0x00, 0x00, 0x00, // Hash
+		0x01, 0x00, 0x00, 0x00, // Index
+	}
+
+	tests := []struct {
+		in   *btcwire.MsgGetUTXOs // Message to encode
+		out  *btcwire.MsgGetUTXOs // Expected decoded message
+		buf  []byte               // Wire encoding
+		pver uint32               // Protocol version for wire encoding
+	}{
+		// Latest protocol version with no outpoints.
+		{
+			noOutPoints,
+			noOutPoints,
+			noOutPointsEncoded,
+			btcwire.ProtocolVersion,
+		},
+
+		// Latest protocol version with multiple outpoints.
+		{
+			multiOutPoints,
+			multiOutPoints,
+			multiOutPointsEncoded,
+			btcwire.ProtocolVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg btcwire.MsgGetUTXOs
+		rbuf := bytes.NewBuffer(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestGetUTXOsWireErrors performs negative tests against wire encode and
+// decode of MsgGetUTXOs to confirm error paths work correctly.
+func TestGetUTXOsWireErrors(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcwireErr := &btcwire.MessageError{}
+
+	op := btcwire.NewOutPoint(&btcwire.GenesisMerkleRoot, 0)
+	baseGetUTXOs := btcwire.NewMsgGetUTXOs(true)
+	baseGetUTXOs.AddOutPoint(op)
+	baseGetUTXOsEncoded := []byte{
+		0x01, // Check mempool
+		0x01, // Varint for number of outpoints
+		0x3b, 0xa3, 0xed, 0xfd, 0x7a, 0x7b, 0x12, 0xb2,
+		0x7a, 0xc7, 0x2c, 0x3e, 0x67, 0x76, 0x8f, 0x61,
+		0x7f, 0xc8, 0x1b, 0xc3, 0x88, 0x8a, 0x51, 0x32,
+		0x3a, 0x9f, 0xb8, 0xaa, 0x4b, 0x1e, 0x5e, 0x4a, // Hash
+		0x00, 0x00, 0x00, 0x00, // Index
+	}
+
+	// Message that forces an error by having more than the max allowed
+	// outpoints.
+	maxGetUTXOs := btcwire.NewMsgGetUTXOs(true)
+	for i := 0; i < btcwire.MaxOutPointsPerGetUTXOs; i++ {
+		maxGetUTXOs.AddOutPoint(op)
+	}
+	maxGetUTXOs.OutPoints = append(maxGetUTXOs.OutPoints, op)
+	maxGetUTXOsEncoded := []byte{
+		0x01, // Check mempool
+		0x65, // Varint for number of outpoints (101)
+	}
+
+	tests := []struct {
+		in       *btcwire.MsgGetUTXOs // Value to encode
+		buf      []byte               // Wire encoding
+		pver     uint32               // Protocol version for wire encoding
+		max      int                  // Max size of fixed buffer to induce errors
+		writeErr error                // Expected write error
+		readErr  error                // Expected read error
+	}{
+		// Force error in check mempool flag.
+		{baseGetUTXOs, baseGetUTXOsEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in outpoint count.
+		{baseGetUTXOs, baseGetUTXOsEncoded, pver, 1, io.ErrShortWrite, io.EOF},
+		// Force error in outpoint.
+		{baseGetUTXOs, baseGetUTXOsEncoded, pver, 2, io.ErrShortWrite, io.EOF},
+		// Force error with greater than max outpoints.
+		{maxGetUTXOs, maxGetUTXOsEncoded, pver, 2, btcwireErr, btcwireErr},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg btcwire.MsgGetUTXOs
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
@@ -27,13 +27,28 @@ func (msg *MsgHeaders) AddBlockHeader(bh *BlockHeader) error {
 	if len(msg.Headers)+1 > MaxBlockHeadersPerMsg {
 		str := fmt.Sprintf("too many block headers in message [max %v]",
 			MaxBlockHeadersPerMsg)
-		return messageError("MsgHeaders.AddBlockHeader", str)
+		return messageError("MsgHeaders.AddBlockHeader", ErrTooManyItems, str)
 	}
 
 	msg.Headers = append(msg.Headers, bh)
 	return nil
 }
 
+// AddBlockHeaders adds all of the provided block headers to the message.  The
+// headers are only added if they all fit within MaxBlockHeadersPerMsg, so
+// the message is left unmodified when an error is returned.
+func (msg *MsgHeaders) AddBlockHeaders(headers ...*BlockHeader) error {
+	if len(msg.Headers)+len(headers) > MaxBlockHeadersPerMsg {
+		str := fmt.Sprintf("too many block headers in message "+
+			"[count %v, max %v]", len(msg.Headers)+len(headers),
+			MaxBlockHeadersPerMsg)
+		return messageError("MsgHeaders.AddBlockHeaders", ErrTooManyItems, str)
+	}
+
+	msg.Headers = append(msg.Headers, headers...)
+	return nil
+}
+
 // BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
 // This is part of the Message interface implementation.
 func (msg *MsgHeaders) BtcDecode(r io.Reader, pver uint32) error {
@@ -46,7 +61,7 @@ func (msg *MsgHeaders) BtcDecode(r io.Reader, pver uint32) error {
 	if count > MaxBlockHeadersPerMsg {
 		str := fmt.Sprintf("too many block headers for message "+
 			"[count %v, max %v]", count, MaxBlockHeadersPerMsg)
-		return messageError("MsgHeaders.BtcDecode", str)
+		return messageError("MsgHeaders.BtcDecode", ErrTooManyItems, str)
 	}
 
 	for i := uint64(0); i < count; i++ {
@@ -56,11 +71,12 @@ func (msg *MsgHeaders) BtcDecode(r io.Reader, pver uint32) error {
 			return err
 		}
 
-		// Ensure the transaction count is zero for headers.
-		if bh.TxnCount > 0 {
+		// Ensure the transaction count is zero for headers unless the
+		// decode options allow it.
+		if bh.TxnCount > 0 && !decodeOptions(r).AllowHeaderTxCount {
 			str := fmt.Sprintf("block headers may not contain "+
 				"transactions [count %v]", bh.TxnCount)
-			return messageError("MsgHeaders.BtcDecode", str)
+			return messageError("MsgHeaders.BtcDecode", ErrMalformed, str)
 		}
 		msg.AddBlockHeader(&bh)
 	}
@@ -76,7 +92,7 @@ func (msg *MsgHeaders) BtcEncode(w io.Writer, pver uint32) error {
 	if count > MaxBlockHeadersPerMsg {
 		str := fmt.Sprintf("too many block headers for message "+
 			"[count %v, max %v]", count, MaxBlockHeadersPerMsg)
-		return messageError("MsgHeaders.BtcEncode", str)
+		return messageError("MsgHeaders.BtcEncode", ErrTooManyItems, str)
 	}
 
 	err := writeVarInt(w, pver, uint64(count))
@@ -89,7 +105,7 @@ func (msg *MsgHeaders) BtcEncode(w io.Writer, pver uint32) error {
 		if bh.TxnCount > 0 {
 			str := fmt.Sprintf("block headers may not contain "+
 				"transactions [count %v]", bh.TxnCount)
-			return messageError("MsgHeaders.BtcEncode", str)
+			return messageError("MsgHeaders.BtcEncode", ErrMalformed, str)
 		}
 
 		err := writeBlockHeader(w, pver, bh)
@@ -107,6 +123,11 @@ func (msg *MsgHeaders) Command() string {
 	return cmdHeaders
 }
 
+// String returns the message in human-readable form.
+func (msg *MsgHeaders) String() string {
+	return fmt.Sprintf("%s count=%d", msg.Command(), len(msg.Headers))
+}
+
 // MaxPayloadLength returns the maximum length the payload can be for the
 // receiver.  This is part of the Message interface implementation.
 func (msg *MsgHeaders) MaxPayloadLength(pver uint32) uint32 {
@@ -114,7 +135,7 @@ func (msg *MsgHeaders) MaxPayloadLength(pver uint32) uint32 {
 	return maxVarIntPayload + (maxBlockHeaderPayload * MaxBlockHeadersPerMsg)
 }
 
-// NewMsgGetHeaders returns a new bitcoin headers message that conforms to the
+// NewMsgHeaders returns a new bitcoin headers message that conforms to the
 // Message interface.  See MsgHeaders for details.
 func NewMsgHeaders() *MsgHeaders {
 	return &MsgHeaders{}
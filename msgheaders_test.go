@@ -56,6 +56,43 @@ func TestHeaders(t *testing.T) {
 			"not received")
 	}
 
+	// Ensure headers are added in bulk properly.
+	msg = btcwire.NewMsgHeaders()
+	headers := make([]*btcwire.BlockHeader, btcwire.MaxBlockHeadersPerMsg)
+	for i := range headers {
+		headers[i] = bh
+	}
+	err = msg.AddBlockHeaders(headers[:2]...)
+	if err != nil {
+		t.Errorf("AddBlockHeaders: %v", err)
+	}
+	if !reflect.DeepEqual(msg.Headers, headers[:2]) {
+		t.Errorf("AddBlockHeaders: wrong headers - got %v, want %v",
+			spew.Sdump(msg.Headers), spew.Sdump(headers[:2]))
+	}
+
+	// Ensure adding headers in bulk which would exceed the max allowed
+	// headers per message returns an error and leaves the message as is.
+	err = msg.AddBlockHeaders(headers...)
+	if _, ok := err.(*btcwire.MessageError); !ok {
+		t.Errorf("AddBlockHeaders: wrong error - got %v <%T>, want "+
+			"<*btcwire.MessageError>", err, err)
+	}
+	if len(msg.Headers) != 2 {
+		t.Errorf("AddBlockHeaders: wrong number of headers after "+
+			"error - got %v, want %v", len(msg.Headers), 2)
+	}
+
+	// Ensure filling the message exactly to the max is allowed.
+	err = msg.AddBlockHeaders(headers[2:]...)
+	if err != nil {
+		t.Errorf("AddBlockHeaders: %v", err)
+	}
+	if len(msg.Headers) != btcwire.MaxBlockHeadersPerMsg {
+		t.Errorf("AddBlockHeaders: wrong number of headers - got %v, "+
+			"want %v", len(msg.Headers), btcwire.MaxBlockHeadersPerMsg)
+	}
+
 	return
 }
 
@@ -24,65 +24,74 @@ type MsgInv struct {
 
 // AddInvVect adds an inventory vector to the message.
 func (msg *MsgInv) AddInvVect(iv *InvVect) error {
-	if len(msg.InvList)+1 > MaxInvPerMsg {
-		str := fmt.Sprintf("too many invvect in message [max %v]",
-			MaxInvPerMsg)
-		return messageError("MsgInv.AddInvVect", str)
-	}
+	var err error
+	msg.InvList, err = addInvVect(msg.InvList, iv, "MsgInv.AddInvVect")
+	return err
+}
 
-	msg.InvList = append(msg.InvList, iv)
-	return nil
+// Dedup removes all but the first occurrence of any duplicate inventory vectors
+// from the message.  The order of the remaining inventory vectors is preserved.
+// This is useful to avoid requesting or announcing the same data more than
+// once when the message is built from several sources.
+func (msg *MsgInv) Dedup() {
+	msg.InvList = dedupInvList(msg.InvList)
 }
 
-// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
-// This is part of the Message interface implementation.
-func (msg *MsgInv) BtcDecode(r io.Reader, pver uint32) error {
-	count, err := readVarInt(r, pver)
-	if err != nil {
-		return err
+// Partition groups the inventory vectors of the message by the kind of data
+// they announce.  Transactions, including witness transactions, are returned
+// in txs.  Blocks, including their witness, filtered, and compact variants,
+// are returned in blocks.  Inventory vectors of any other type, such as
+// InvVect_Error or types unknown to this package, are returned in other.  The
+// order of the inventory vectors within each group is preserved.
+func (msg *MsgInv) Partition() (txs []*InvVect, blocks []*InvVect, other []*InvVect) {
+	for _, iv := range msg.InvList {
+		switch iv.Type {
+		case InvVect_Tx, InvVect_WitnessTx:
+			txs = append(txs, iv)
+		case InvVect_Block, InvVect_WitnessBlock, InvVect_FilteredBlock,
+			InvVect_FilteredWitnessBlock, InvVect_CmpctBlock:
+			blocks = append(blocks, iv)
+		default:
+			other = append(other, iv)
+		}
 	}
+	return txs, blocks, other
+}
 
-	// Limit to max inventory vectors per message.
-	if count > MaxInvPerMsg {
-		str := fmt.Sprintf("too many invvect in message [%v]", count)
-		return messageError("MsgInv.BtcDecode", str)
+// ToGetData returns a new getdata message (MsgGetData) which requests all of
+// the data announced by the message.  Unlike filtering the announced inventory
+// vectors against known data first, it requests everything, which is
+// convenient when all of the announced data is wanted.  The inventory vectors
+// are copied so the returned message may be modified, such as with
+// UpgradeToWitness, without affecting the inv message.  No more than
+// MaxInvPerMsg inventory vectors are copied.
+func (msg *MsgInv) ToGetData() *MsgGetData {
+	n := len(msg.InvList)
+	if n > MaxInvPerMsg {
+		n = MaxInvPerMsg
 	}
 
-	for i := uint64(0); i < count; i++ {
-		iv := InvVect{}
-		err := readInvVect(r, pver, &iv)
-		if err != nil {
-			return err
-		}
-		msg.AddInvVect(&iv)
+	getData := NewMsgGetData()
+	getData.InvList = make([]*InvVect, 0, n)
+	for _, iv := range msg.InvList[:n] {
+		ivCopy := *iv
+		getData.InvList = append(getData.InvList, &ivCopy)
 	}
+	return getData
+}
 
-	return nil
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgInv) BtcDecode(r io.Reader, pver uint32) error {
+	var err error
+	msg.InvList, err = readInvList(r, pver, msg.InvList, "MsgInv.BtcDecode")
+	return err
 }
 
 // BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
 // This is part of the Message interface implementation.
 func (msg *MsgInv) BtcEncode(w io.Writer, pver uint32) error {
-	// Limit to max inventory vectors per message.
-	count := len(msg.InvList)
-	if count > MaxInvPerMsg {
-		str := fmt.Sprintf("too many invvect in message [%v]", count)
-		return messageError("MsgInv.BtcEncode", str)
-	}
-
-	err := writeVarInt(w, pver, uint64(count))
-	if err != nil {
-		return err
-	}
-
-	for _, iv := range msg.InvList {
-		err := writeInvVect(w, pver, iv)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return writeInvList(w, pver, msg.InvList, "MsgInv.BtcEncode")
 }
 
 // Command returns the protocol command string for the message.  This is part
@@ -91,6 +100,11 @@ func (msg *MsgInv) Command() string {
 	return cmdInv
 }
 
+// String returns the message in human-readable form.
+func (msg *MsgInv) String() string {
+	return fmt.Sprintf("%s count=%d", msg.Command(), len(msg.InvList))
+}
+
 // MaxPayloadLength returns the maximum length the payload can be for the
 // receiver.  This is part of the Message interface implementation.
 func (msg *MsgInv) MaxPayloadLength(pver uint32) uint32 {
@@ -103,3 +117,25 @@ func (msg *MsgInv) MaxPayloadLength(pver uint32) uint32 {
 func NewMsgInv() *MsgInv {
 	return &MsgInv{}
 }
+
+// SplitInv splits the passed inventory vectors into as few inv messages as
+// possible without exceeding MaxInvPerMsg inventory vectors per message.  The
+// order of the inventory vectors is preserved.  No messages are returned when
+// there are no inventory vectors.
+func SplitInv(invList []*InvVect) []*MsgInv {
+	var msgs []*MsgInv
+	for len(invList) > 0 {
+		n := len(invList)
+		if n > MaxInvPerMsg {
+			n = MaxInvPerMsg
+		}
+
+		msg := NewMsgInv()
+		msg.InvList = make([]*InvVect, n)
+		copy(msg.InvList, invList[:n])
+		msgs = append(msgs, msg)
+
+		invList = invList[n:]
+	}
+	return msgs
+}
@@ -60,6 +60,219 @@ func TestInv(t *testing.T) {
 	return
 }
 
+// TestInvDedup tests the MsgInv Dedup function removes duplicate inventory
+// vectors while preserving the order of the remaining ones.
+func TestInvDedup(t *testing.T) {
+	hash1 := btcwire.ShaHash{0x01}
+	hash2 := btcwire.ShaHash{0x02}
+	txIV1 := btcwire.NewInvVect(btcwire.InvVect_Tx, &hash1)
+	txIV2 := btcwire.NewInvVect(btcwire.InvVect_Tx, &hash2)
+	blockIV1 := btcwire.NewInvVect(btcwire.InvVect_Block, &hash1)
+
+	tests := []struct {
+		in   []*btcwire.InvVect // Inventory vectors to dedup
+		want []*btcwire.InvVect // Expected inventory vectors
+	}{
+		// No inventory vectors.
+		{nil, nil},
+
+		// No duplicates.
+		{
+			[]*btcwire.InvVect{txIV1, txIV2, blockIV1},
+			[]*btcwire.InvVect{txIV1, txIV2, blockIV1},
+		},
+
+		// Duplicate with the same hash, but a different type is kept.
+		{
+			[]*btcwire.InvVect{txIV1, blockIV1, txIV1},
+			[]*btcwire.InvVect{txIV1, blockIV1},
+		},
+
+		// Equal, but distinct, inventory vectors are duplicates.
+		{
+			[]*btcwire.InvVect{
+				txIV2, txIV1,
+				btcwire.NewInvVect(btcwire.InvVect_Tx, &hash2),
+				txIV1,
+			},
+			[]*btcwire.InvVect{txIV2, txIV1},
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		msg := btcwire.NewMsgInv()
+		for _, iv := range test.in {
+			msg.AddInvVect(iv)
+		}
+		msg.Dedup()
+
+		if len(msg.InvList) != len(test.want) {
+			t.Errorf("Dedup #%d\n got: %s want: %s", i,
+				spew.Sdump(msg.InvList), spew.Sdump(test.want))
+			continue
+		}
+		for j, iv := range msg.InvList {
+			if iv != test.want[j] {
+				t.Errorf("Dedup #%d\n got: %s want: %s", i,
+					spew.Sdump(msg.InvList),
+					spew.Sdump(test.want))
+				break
+			}
+		}
+	}
+}
+
+// TestInvPartition tests the MsgInv Partition function groups inventory
+// vectors by type while preserving their order.
+func TestInvPartition(t *testing.T) {
+	hash := btcwire.ShaHash{0x01}
+	txIV := btcwire.NewInvVect(btcwire.InvVect_Tx, &hash)
+	witnessTxIV := btcwire.NewInvVect(btcwire.InvVect_WitnessTx, &hash)
+	blockIV := btcwire.NewInvVect(btcwire.InvVect_Block, &hash)
+	witnessBlockIV := btcwire.NewInvVect(btcwire.InvVect_WitnessBlock, &hash)
+	filteredIV := btcwire.NewInvVect(btcwire.InvVect_FilteredBlock, &hash)
+	filteredWitnessIV := btcwire.NewInvVect(
+		btcwire.InvVect_FilteredWitnessBlock, &hash)
+	cmpctIV := btcwire.NewInvVect(btcwire.InvVect_CmpctBlock, &hash)
+	errorIV := btcwire.NewInvVect(btcwire.InvVect_Error, &hash)
+	unknownIV := btcwire.NewInvVect(0xff, &hash)
+
+	tests := []struct {
+		in     []*btcwire.InvVect // Inventory vectors to partition
+		txs    []*btcwire.InvVect // Expected transaction inventory vectors
+		blocks []*btcwire.InvVect // Expected block inventory vectors
+		other  []*btcwire.InvVect // Expected other inventory vectors
+	}{
+		// No inventory vectors.
+		{nil, nil, nil, nil},
+
+		// Only transactions.
+		{
+			[]*btcwire.InvVect{txIV, witnessTxIV},
+			[]*btcwire.InvVect{txIV, witnessTxIV},
+			nil,
+			nil,
+		},
+
+		// Mixed types in an interleaved order.
+		{
+			[]*btcwire.InvVect{
+				blockIV, txIV, errorIV, witnessBlockIV,
+				witnessTxIV, filteredIV, unknownIV,
+				filteredWitnessIV, cmpctIV,
+			},
+			[]*btcwire.InvVect{txIV, witnessTxIV},
+			[]*btcwire.InvVect{
+				blockIV, witnessBlockIV, filteredIV,
+				filteredWitnessIV, cmpctIV,
+			},
+			[]*btcwire.InvVect{errorIV, unknownIV},
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		msg := btcwire.NewMsgInv()
+		for _, iv := range test.in {
+			msg.AddInvVect(iv)
+		}
+		txs, blocks, other := msg.Partition()
+
+		if !reflect.DeepEqual(txs, test.txs) {
+			t.Errorf("Partition #%d wrong txs\n got: %s want: %s",
+				i, spew.Sdump(txs), spew.Sdump(test.txs))
+			continue
+		}
+		if !reflect.DeepEqual(blocks, test.blocks) {
+			t.Errorf("Partition #%d wrong blocks\n got: %s want: %s",
+				i, spew.Sdump(blocks), spew.Sdump(test.blocks))
+			continue
+		}
+		if !reflect.DeepEqual(other, test.other) {
+			t.Errorf("Partition #%d wrong other\n got: %s want: %s",
+				i, spew.Sdump(other), spew.Sdump(test.other))
+			continue
+		}
+
+		// Ensure the message is left unmodified.
+		if len(msg.InvList) != len(test.in) {
+			t.Errorf("Partition #%d modified the message", i)
+			continue
+		}
+	}
+}
+
+// TestInvToGetData tests the MsgInv ToGetData function requests every
+// announced inventory vector without sharing them with the inv message.
+func TestInvToGetData(t *testing.T) {
+	hash := btcwire.ShaHash{0x01}
+	txIV := btcwire.NewInvVect(btcwire.InvVect_Tx, &hash)
+	blockIV := btcwire.NewInvVect(btcwire.InvVect_Block, &hash)
+	errorIV := btcwire.NewInvVect(btcwire.InvVect_Error, &hash)
+
+	tests := []struct {
+		in []*btcwire.InvVect // Inventory vectors to convert
+	}{
+		// No inventory vectors.
+		{nil},
+		// Single inventory vector.
+		{[]*btcwire.InvVect{txIV}},
+		// Multiple inventory vectors of various types.
+		{[]*btcwire.InvVect{blockIV, txIV, errorIV}},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		msg := btcwire.NewMsgInv()
+		for _, iv := range test.in {
+			msg.AddInvVect(iv)
+		}
+		getData := msg.ToGetData()
+
+		if len(getData.InvList) != len(test.in) {
+			t.Errorf("ToGetData #%d wrong number of inventory vectors "+
+				"- got %d, want %d", i, len(getData.InvList),
+				len(test.in))
+			continue
+		}
+		for j, iv := range getData.InvList {
+			if !reflect.DeepEqual(iv, test.in[j]) {
+				t.Errorf("ToGetData #%d wrong inventory vector %d\n"+
+					"got: %s want: %s", i, j, spew.Sdump(iv),
+					spew.Sdump(test.in[j]))
+				continue
+			}
+			if iv == test.in[j] {
+				t.Errorf("ToGetData #%d inventory vector %d is "+
+					"shared with the inv message", i, j)
+				continue
+			}
+		}
+
+		// Ensure upgrading the request leaves the inv message untouched.
+		getData.UpgradeToWitness()
+		if !reflect.DeepEqual(msg.InvList, test.in) {
+			t.Errorf("ToGetData #%d modified the message\n got: %s "+
+				"want: %s", i, spew.Sdump(msg.InvList),
+				spew.Sdump(test.in))
+			continue
+		}
+	}
+
+	// Ensure no more than the max allowed inventory vectors are requested.
+	msg := btcwire.NewMsgInv()
+	msg.InvList = make([]*btcwire.InvVect, btcwire.MaxInvPerMsg+1)
+	for i := range msg.InvList {
+		msg.InvList[i] = txIV
+	}
+	getData := msg.ToGetData()
+	if len(getData.InvList) != btcwire.MaxInvPerMsg {
+		t.Errorf("ToGetData: wrong number of inventory vectors - got %d, "+
+			"want %d", len(getData.InvList), btcwire.MaxInvPerMsg)
+	}
+}
+
 // TestInvWire tests the MsgInv wire encode and decode for various numbers
 // of inventory vectors and protocol versions.
 func TestInvWire(t *testing.T) {
@@ -320,3 +533,65 @@ func TestInvWireErrors(t *testing.T) {
 
 	}
 }
+
+// TestSplitInv tests splitting inventory vectors into multiple inv messages.
+func TestSplitInv(t *testing.T) {
+	hash := btcwire.ShaHash{}
+	makeInvList := func(n int) []*btcwire.InvVect {
+		invList := make([]*btcwire.InvVect, n)
+		for i := range invList {
+			invList[i] = btcwire.NewInvVect(btcwire.InvVect_Tx, &hash)
+		}
+		return invList
+	}
+
+	tests := []struct {
+		count int   // Number of inventory vectors to split
+		want  []int // Expected number of inventory vectors per message
+	}{
+		{0, nil},
+		{1, []int{1}},
+		{btcwire.MaxInvPerMsg, []int{btcwire.MaxInvPerMsg}},
+		{btcwire.MaxInvPerMsg + 1, []int{btcwire.MaxInvPerMsg, 1}},
+		{btcwire.MaxInvPerMsg*2 + 5, []int{btcwire.MaxInvPerMsg,
+			btcwire.MaxInvPerMsg, 5}},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		invList := makeInvList(test.count)
+		msgs := btcwire.SplitInv(invList)
+		if len(msgs) != len(test.want) {
+			t.Errorf("SplitInv #%d: wrong number of messages - "+
+				"got %d, want %d", i, len(msgs), len(test.want))
+			continue
+		}
+
+		// Ensure each message has the expected number of inventory
+		// vectors, in order, and can be encoded.
+		offset := 0
+		for j, msg := range msgs {
+			if len(msg.InvList) != test.want[j] {
+				t.Errorf("SplitInv #%d: wrong number of "+
+					"invvects in message %d - got %d, "+
+					"want %d", i, j, len(msg.InvList),
+					test.want[j])
+				break
+			}
+			if msg.InvList[0] != invList[offset] {
+				t.Errorf("SplitInv #%d: wrong first invvect "+
+					"in message %d", i, j)
+				break
+			}
+			offset += len(msg.InvList)
+
+			var buf bytes.Buffer
+			err := msg.BtcEncode(&buf, btcwire.ProtocolVersion)
+			if err != nil {
+				t.Errorf("SplitInv #%d: BtcEncode of message "+
+					"%d error %v", i, j, err)
+				break
+			}
+		}
+	}
+}
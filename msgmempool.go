@@ -23,7 +23,7 @@ func (msg *MsgMemPool) BtcDecode(r io.Reader, pver uint32) error {
 	if pver < BIP0035Version {
 		str := fmt.Sprintf("mempool message invalid for protocol "+
 			"version %d", pver)
-		return messageError("MsgMemPool.BtcDecode", str)
+		return messageError("MsgMemPool.BtcDecode", ErrProtocolVersion, str)
 	}
 
 	return nil
@@ -35,7 +35,7 @@ func (msg *MsgMemPool) BtcEncode(w io.Writer, pver uint32) error {
 	if pver < BIP0035Version {
 		str := fmt.Sprintf("mempool message invalid for protocol "+
 			"version %d", pver)
-		return messageError("MsgMemPool.BtcEncode", str)
+		return messageError("MsgMemPool.BtcEncode", ErrProtocolVersion, str)
 	}
 
 	return nil
@@ -47,14 +47,31 @@ func (msg *MsgMemPool) Command() string {
 	return cmdMemPool
 }
 
+// String returns the message in human-readable form.
+func (msg *MsgMemPool) String() string {
+	return msg.Command()
+}
+
 // MaxPayloadLength returns the maximum length the payload can be for the
 // receiver.  This is part of the Message interface implementation.
 func (msg *MsgMemPool) MaxPayloadLength(pver uint32) uint32 {
 	return 0
 }
 
-// NewMsgPong returns a new bitcoin pong message that conforms to the Message
-// interface.  See MsgPong for details.
+// NewMsgMemPool returns a new bitcoin mempool message that conforms to the
+// Message interface.  See MsgMemPool for details.
 func NewMsgMemPool() *MsgMemPool {
 	return &MsgMemPool{}
 }
+
+// MemPoolInvResponse returns the inv messages (MsgInv) to send in response to a
+// mempool message given the inventory vectors of every transaction in the
+// memory pool.
+//
+// A memory pool can easily contain more transactions than the MaxInvPerMsg
+// inventory vectors allowed in a single inv message, so the response is split
+// across as many messages as needed.  The messages must be sent in the
+// returned order.  See SplitInv.
+func MemPoolInvResponse(invList []*InvVect) []*MsgInv {
+	return SplitInv(invList)
+}
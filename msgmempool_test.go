@@ -63,3 +63,33 @@ func TestMemPool(t *testing.T) {
 
 	return
 }
+
+// TestMemPoolInvResponse tests building the inv messages sent in response to
+// a mempool message.
+func TestMemPoolInvResponse(t *testing.T) {
+	hash := btcwire.ShaHash{}
+	invList := make([]*btcwire.InvVect, btcwire.MaxInvPerMsg+1)
+	for i := range invList {
+		invList[i] = btcwire.NewInvVect(btcwire.InvVect_Tx, &hash)
+	}
+
+	msgs := btcwire.MemPoolInvResponse(invList)
+	if len(msgs) != 2 {
+		t.Errorf("MemPoolInvResponse: wrong number of messages - "+
+			"got %d, want %d", len(msgs), 2)
+		return
+	}
+	if len(msgs[0].InvList) != btcwire.MaxInvPerMsg ||
+		len(msgs[1].InvList) != 1 {
+
+		t.Errorf("MemPoolInvResponse: wrong number of invvects - "+
+			"got %d and %d, want %d and %d", len(msgs[0].InvList),
+			len(msgs[1].InvList), btcwire.MaxInvPerMsg, 1)
+	}
+
+	// Ensure an empty mempool results in no messages.
+	if msgs := btcwire.MemPoolInvResponse(nil); len(msgs) != 0 {
+		t.Errorf("MemPoolInvResponse: wrong number of messages for "+
+			"empty mempool - got %d, want %d", len(msgs), 0)
+	}
+}
@@ -0,0 +1,436 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// ErrBadPartialMerkleTree indicates a merkleblock whose hashes and flag bits
+// are not a valid depth-first traversal of the merkle tree of a block with its
+// number of transactions.  The errors returned by MsgMerkleBlock.ExtractMatches
+// wrap it, so it may be matched with errors.Is.
+var ErrBadPartialMerkleTree = errors.New("partial merkle tree is malformed")
+
+// maxMerkleBlockFlags returns the maximum number of flag bytes of a partial
+// merkle tree for a block with the passed number of transactions.  The tree is
+// traversed depth-first consuming one flag bit per visited node, so there can
+// be at most one bit for every node of the full merkle tree.  Each level of the
+// tree has half as many nodes as the level below it, rounded up, until there
+// is a single root.
+func maxMerkleBlockFlags(numTx uint32) uint64 {
+	if numTx == 0 {
+		return 0
+	}
+
+	nodes := uint64(numTx)
+	for width := uint64(numTx); width > 1; {
+		width = (width + 1) / 2
+		nodes += width
+	}
+	return (nodes + 7) / 8
+}
+
+// MsgMerkleBlock implements the Message interface and represents a bitcoin
+// merkleblock message.  It is sent in response to a getdata message for an
+// InvVect_FilteredBlock inventory vector and holds the header of the block
+// along with a partial merkle tree which proves the inclusion of the
+// transactions matching the loaded filter.
+//
+// Unlike MsgBlock, the header is followed by the total number of transactions
+// in the block as a 4-byte integer, so Header.TxnCount is not encoded and the
+// number of transactions is held by the Transactions field instead.
+//
+// This message was not added until protocol version BIP0037Version.
+type MsgMerkleBlock struct {
+	Header       BlockHeader
+	Transactions uint32
+	Hashes       []*ShaHash
+	Flags        []byte
+}
+
+// AddTxHash adds a new transaction hash to the message.
+func (msg *MsgMerkleBlock) AddTxHash(hash *ShaHash) error {
+	if len(msg.Hashes)+1 > maxTxPerBlock {
+		str := fmt.Sprintf("too many tx hashes for message [max %v]",
+			maxTxPerBlock)
+		return messageError("MsgMerkleBlock.AddTxHash", ErrTooManyItems, str)
+	}
+
+	msg.Hashes = append(msg.Hashes, hash)
+	return nil
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+//
+// The partial merkle tree is checked against the bounds implied by the total
+// number of transactions before any hashes are allocated.  A tree of that many
+// transactions can not contain more hashes than transactions nor more flag
+// bits than it has nodes, and every hash consumes a flag bit.  This prevents a
+// peer from forcing a large allocation by claiming a small tree while sending
+// many hashes.
+func (msg *MsgMerkleBlock) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < BIP0037Version {
+		str := fmt.Sprintf("merkleblock message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgMerkleBlock.BtcDecode", ErrProtocolVersion, str)
+	}
+
+	err := readBlockHeaderFields(r, pver, &msg.Header)
+	if err != nil {
+		return err
+	}
+
+	err = readElement(r, &msg.Transactions)
+	if err != nil {
+		return err
+	}
+	if msg.Transactions == 0 {
+		str := "merkleblock does not contain any transactions"
+		return messageError("MsgMerkleBlock.BtcDecode", ErrMalformed, str)
+	}
+	if msg.Transactions > maxTxPerBlock {
+		str := fmt.Sprintf("too many transactions for merkleblock "+
+			"[count %v, max %v]", msg.Transactions, maxTxPerBlock)
+		return messageError("MsgMerkleBlock.BtcDecode", ErrTooManyItems, str)
+	}
+
+	// Read num transaction hashes and limit to the number of
+	// transactions in the tree.
+	count, err := readVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > uint64(msg.Transactions) {
+		str := fmt.Sprintf("too many transaction hashes for merkleblock "+
+			"of %v transactions [count %v]", msg.Transactions, count)
+		return messageError("MsgMerkleBlock.BtcDecode", ErrTooManyItems, str)
+	}
+
+	msg.Hashes = make([]*ShaHash, 0, preallocCount(count,
+		unsafe.Sizeof((*ShaHash)(nil))))
+	for i := uint64(0); i < count; i++ {
+		var sha ShaHash
+		err := readElement(r, &sha)
+		if err != nil {
+			return err
+		}
+		msg.AddTxHash(&sha)
+	}
+
+	maxFlags := maxMerkleBlockFlags(msg.Transactions)
+	msg.Flags, err = readVarBytes(r, pver, uint32(maxFlags),
+		"merkleblock flags size")
+	if err != nil {
+		return err
+	}
+	if uint64(len(msg.Flags))*8 < count {
+		str := fmt.Sprintf("too few flag bits for merkleblock with %v "+
+			"transaction hashes [flag bytes %v]", count,
+			len(msg.Flags))
+		return messageError("MsgMerkleBlock.BtcDecode", ErrMalformed, str)
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgMerkleBlock) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < BIP0037Version {
+		str := fmt.Sprintf("merkleblock message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgMerkleBlock.BtcEncode", ErrProtocolVersion, str)
+	}
+
+	// Limit the number of transaction hashes and flag bytes to the most
+	// that could be in any merkle block.
+	numHashes := len(msg.Hashes)
+	if numHashes > maxTxPerBlock {
+		str := fmt.Sprintf("too many transaction hashes for message "+
+			"[count %v, max %v]", numHashes, maxTxPerBlock)
+		return messageError("MsgMerkleBlock.BtcEncode", ErrTooManyItems, str)
+	}
+	numFlagBytes := len(msg.Flags)
+	maxFlags := maxMerkleBlockFlags(maxTxPerBlock)
+	if uint64(numFlagBytes) > maxFlags {
+		str := fmt.Sprintf("too many flag bytes for message [count %v, "+
+			"max %v]", numFlagBytes, maxFlags)
+		return messageError("MsgMerkleBlock.BtcEncode", ErrPayloadTooLarge, str)
+	}
+
+	err := writeBlockHeaderFields(w, pver, &msg.Header)
+	if err != nil {
+		return err
+	}
+
+	err = writeElement(w, msg.Transactions)
+	if err != nil {
+		return err
+	}
+
+	err = writeVarInt(w, pver, uint64(numHashes))
+	if err != nil {
+		return err
+	}
+	for _, hash := range msg.Hashes {
+		err = writeElement(w, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = writeVarBytes(w, pver, msg.Flags)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgMerkleBlock) Command() string {
+	return cmdMerkleBlock
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgMerkleBlock) String() string {
+	hash, _ := msg.Header.BlockSha(ProtocolVersion)
+	return fmt.Sprintf("%s hash=%v txns=%d hashes=%d", msg.Command(), hash,
+		msg.Transactions, len(msg.Hashes))
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgMerkleBlock) MaxPayloadLength(pver uint32) uint32 {
+	// Block header 80 bytes + transactions 4 bytes + num hashes (varInt) +
+	// max transaction hashes + num flag bytes (varInt) + max flag bytes.
+	return 84 + maxVarIntPayload + (maxTxPerBlock * HashSize) +
+		maxVarIntPayload + uint32(maxMerkleBlockFlags(maxTxPerBlock))
+}
+
+// NewMsgMerkleBlock returns a new bitcoin merkleblock message that conforms to
+// the Message interface.  See MsgMerkleBlock for details.
+func NewMsgMerkleBlock(bh *BlockHeader) *MsgMerkleBlock {
+	return &MsgMerkleBlock{
+		Header:       *bh,
+		Transactions: 0,
+		Hashes:       make([]*ShaHash, 0),
+		Flags:        make([]byte, 0),
+	}
+}
+
+// merkleTreeWidth returns the number of nodes at the passed height of the merkle
+// tree of a block with numTx transactions.  The leaves are at height 0.
+func merkleTreeWidth(numTx uint32, height uint) uint64 {
+	return (uint64(numTx) + (1 << height) - 1) >> height
+}
+
+// merkleTreeHeight returns the height of the root of the merkle tree of a block
+// with numTx transactions.
+func merkleTreeHeight(numTx uint32) uint {
+	var height uint
+	for merkleTreeWidth(numTx, height) > 1 {
+		height++
+	}
+	return height
+}
+
+// partialMerkleTree houses the state of building or traversing the partial
+// merkle tree of a merkleblock.  The tree is traversed depth-first, with one
+// flag bit for every visited node telling whether it is the ancestor of a
+// matched transaction, or the matched transaction itself.  The traversal
+// descends into such nodes, while the hash of every other visited node and of
+// every visited leaf is included in the tree.
+type partialMerkleTree struct {
+	numTx    uint32
+	txHashes []ShaHash // Hashes of all transactions, only when building
+	matches  []bool    // Matched transactions, only when building
+
+	hashes   []*ShaHash
+	flags    []byte
+	bitsUsed int
+	bad      bool // Set when traversal finds the tree malformed
+
+	matchedHashes  []*ShaHash
+	matchedIndexes []uint32
+}
+
+// calcHash returns the hash of the node at the passed height and position of
+// the full merkle tree.
+func (t *partialMerkleTree) calcHash(height uint, pos uint64) ShaHash {
+	if height == 0 {
+		return t.txHashes[pos]
+	}
+
+	left := t.calcHash(height-1, pos*2)
+	right := left
+	if pos*2+1 < merkleTreeWidth(t.numTx, height-1) {
+		right = t.calcHash(height-1, pos*2+1)
+	}
+	return hashMerkleBranches(&left, &right)
+}
+
+// build appends the flag bits and hashes of the node at the passed height and
+// position and of the nodes below it which are visited.
+func (t *partialMerkleTree) build(height uint, pos uint64) {
+	// Determine whether the node is the ancestor of a matched transaction
+	// or a matched transaction itself.
+	parentOfMatch := false
+	end := (pos + 1) << height
+	if end > uint64(t.numTx) {
+		end = uint64(t.numTx)
+	}
+	for i := pos << height; i < end && !parentOfMatch; i++ {
+		parentOfMatch = t.matches[i]
+	}
+
+	if parentOfMatch {
+		t.flags[t.bitsUsed/8] |= 1 << uint(t.bitsUsed%8)
+	}
+	t.bitsUsed++
+
+	if height == 0 || !parentOfMatch {
+		hash := t.calcHash(height, pos)
+		t.hashes = append(t.hashes, &hash)
+		return
+	}
+
+	t.build(height-1, pos*2)
+	if pos*2+1 < merkleTreeWidth(t.numTx, height-1) {
+		t.build(height-1, pos*2+1)
+	}
+}
+
+// extract consumes the flag bits and hashes of the node at the passed height
+// and position and of the nodes below it which are visited, recording the
+// matched transactions, and returns the hash of the node.
+func (t *partialMerkleTree) extract(height uint, pos uint64) ShaHash {
+	if t.bitsUsed >= len(t.flags)*8 {
+		t.bad = true
+		return ShaHash{}
+	}
+	parentOfMatch := t.flags[t.bitsUsed/8]&(1<<uint(t.bitsUsed%8)) != 0
+	t.bitsUsed++
+
+	if height == 0 || !parentOfMatch {
+		if len(t.hashes) == 0 || t.hashes[0] == nil {
+			t.bad = true
+			return ShaHash{}
+		}
+		hash := t.hashes[0]
+		t.hashes = t.hashes[1:]
+
+		if height == 0 && parentOfMatch {
+			t.matchedHashes = append(t.matchedHashes, hash)
+			t.matchedIndexes = append(t.matchedIndexes, uint32(pos))
+		}
+		return *hash
+	}
+
+	left := t.extract(height-1, pos*2)
+	right := left
+	if pos*2+1 < merkleTreeWidth(t.numTx, height-1) {
+		right = t.extract(height-1, pos*2+1)
+
+		// Both children of a node being the same hash would allow the
+		// same tree to be described with duplicated transactions
+		// (CVE-2012-2459), so it is rejected like bitcoind does.
+		if right.IsEqual(&left) {
+			t.bad = true
+		}
+	}
+	return hashMerkleBranches(&left, &right)
+}
+
+// ExtractMatches traverses the partial merkle tree of the merkleblock and
+// returns the hashes of the matched transactions along with their positions in
+// the block, in the order they appear in the block.  An error wrapping
+// ErrBadPartialMerkleTree is returned when the hashes and flag bits are not a
+// valid traversal of the tree of a block with the number of transactions of
+// the message, including when any of them are left over, and an error wrapping
+// ErrBadMerkleRoot when the root of the tree does not match the merkle root in
+// the header.
+func (msg *MsgMerkleBlock) ExtractMatches() ([]*ShaHash, []uint32, error) {
+	// A tree needs at least one transaction, can't have more hashes than
+	// transactions, and needs a flag bit for every hash.
+	if msg.Transactions == 0 {
+		return nil, nil, fmt.Errorf("ExtractMatches: no transactions: "+
+			"%w", ErrBadPartialMerkleTree)
+	}
+	if uint64(len(msg.Hashes)) > uint64(msg.Transactions) {
+		return nil, nil, fmt.Errorf("ExtractMatches: %d hashes for %d "+
+			"transactions: %w", len(msg.Hashes), msg.Transactions,
+			ErrBadPartialMerkleTree)
+	}
+	if len(msg.Flags)*8 < len(msg.Hashes) {
+		return nil, nil, fmt.Errorf("ExtractMatches: %d flag bytes for "+
+			"%d hashes: %w", len(msg.Flags), len(msg.Hashes),
+			ErrBadPartialMerkleTree)
+	}
+
+	t := partialMerkleTree{
+		numTx:  msg.Transactions,
+		hashes: msg.Hashes,
+		flags:  msg.Flags,
+	}
+	root := t.extract(merkleTreeHeight(msg.Transactions), 0)
+	if t.bad {
+		return nil, nil, fmt.Errorf("ExtractMatches: invalid traversal: "+
+			"%w", ErrBadPartialMerkleTree)
+	}
+
+	// Every hash and every flag byte must have been used.
+	if len(t.hashes) != 0 || (t.bitsUsed+7)/8 != len(msg.Flags) {
+		return nil, nil, fmt.Errorf("ExtractMatches: %d unused hashes "+
+			"and %d unused flag bytes: %w", len(t.hashes),
+			len(msg.Flags)-(t.bitsUsed+7)/8, ErrBadPartialMerkleTree)
+	}
+
+	if !root.IsEqual(&msg.Header.MerkleRoot) {
+		return nil, nil, fmt.Errorf("ExtractMatches: header merkle root "+
+			"%v does not match calculated merkle root %v: %w",
+			msg.Header.MerkleRoot, root, ErrBadMerkleRoot)
+	}
+
+	return t.matchedHashes, t.matchedIndexes, nil
+}
+
+// NewMsgMerkleBlockForBlock returns a new bitcoin merkleblock message for the
+// passed block with a partial merkle tree which proves the inclusion of the
+// transactions for which match returns true.  See MsgMerkleBlock for details
+// and ExtractMatches to retrieve the matched transactions.
+func NewMsgMerkleBlockForBlock(block *MsgBlock,
+	match func(tx *MsgTx) bool) *MsgMerkleBlock {
+
+	msg := NewMsgMerkleBlock(&block.Header)
+	msg.Header.TxnCount = 0
+	msg.Transactions = uint32(len(block.Transactions))
+	if msg.Transactions == 0 {
+		return msg
+	}
+
+	matches := make([]bool, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		matches[i] = match(tx)
+	}
+
+	t := partialMerkleTree{
+		numTx:    msg.Transactions,
+		txHashes: block.TxHashes(),
+		matches:  matches,
+		flags:    make([]byte, maxMerkleBlockFlags(msg.Transactions)),
+	}
+	t.build(merkleTreeHeight(msg.Transactions), 0)
+
+	msg.Hashes = t.hashes
+	msg.Flags = t.flags[:(t.bitsUsed+7)/8]
+	return msg
+}
@@ -0,0 +1,523 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestMerkleBlock tests the MsgMerkleBlock API.
+func TestMerkleBlock(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "merkleblock"
+	msg := btcwire.NewMsgMerkleBlock(&merkleBlockOne.Header)
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgMerkleBlock: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Block header 80 bytes + transactions 4 bytes + num hashes (varInt) +
+	// max transaction hashes + num flag bytes (varInt) + max flag bytes.
+	wantPayload := uint32(3381774)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure transaction hashes are added properly.
+	hash := &merkleBlockOne.Header.MerkleRoot
+	err := msg.AddTxHash(hash)
+	if err != nil {
+		t.Errorf("AddTxHash: %v", err)
+	}
+	if msg.Hashes[0] != hash {
+		t.Errorf("AddTxHash: wrong hash added - got %v, want %v",
+			spew.Sprint(msg.Hashes[0]), spew.Sprint(hash))
+	}
+
+	// Ensure adding more than the max allowed transaction hashes per
+	// message returns an error.
+	for i := 0; i < btcwire.MaxBlockPayload/10+1; i++ {
+		err = msg.AddTxHash(hash)
+	}
+	if err == nil {
+		t.Errorf("AddTxHash: expected error on too many transaction " +
+			"hashes not received")
+	}
+
+	// Ensure encoding too many transaction hashes returns an error.
+	msg.Hashes = append(msg.Hashes, hash)
+	var buf bytes.Buffer
+	err = msg.BtcEncode(&buf, pver)
+	if _, ok := err.(*btcwire.MessageError); !ok {
+		t.Errorf("BtcEncode: wrong error for too many transaction "+
+			"hashes - got %v <%T>", err, err)
+	}
+
+	// Ensure encoding too many flag bytes returns an error.
+	msg = btcwire.NewMsgMerkleBlock(&merkleBlockOne.Header)
+	msg.Flags = make([]byte, btcwire.MaxBlockPayload)
+	buf.Reset()
+	err = msg.BtcEncode(&buf, pver)
+	if _, ok := err.(*btcwire.MessageError); !ok {
+		t.Errorf("BtcEncode: wrong error for too many flag bytes - "+
+			"got %v <%T>", err, err)
+	}
+
+	// Older protocol versions should fail encode and decode since the
+	// message didn't exist yet.
+	oldPver := btcwire.BIP0037Version - 1
+	buf.Reset()
+	err = merkleBlockOne.BtcEncode(&buf, oldPver)
+	if err == nil {
+		t.Errorf("encode of MsgMerkleBlock succeeded when it should " +
+			"have failed")
+	}
+	var readmsg btcwire.MsgMerkleBlock
+	err = readmsg.BtcDecode(bytes.NewBuffer(merkleBlockOneBytes), oldPver)
+	if err == nil {
+		t.Errorf("decode of MsgMerkleBlock succeeded when it should " +
+			"have failed")
+	}
+}
+
+// TestMerkleBlockWire tests the MsgMerkleBlock wire encode and decode for
+// various protocol versions.
+func TestMerkleBlockWire(t *testing.T) {
+	tests := []struct {
+		in   *btcwire.MsgMerkleBlock // Message to encode
+		out  *btcwire.MsgMerkleBlock // Expected decoded message
+		buf  []byte                  // Wire encoding
+		pver uint32                  // Protocol version for wire encoding
+	}{
+		// Latest protocol version.
+		{
+			&merkleBlockOne,
+			&merkleBlockOne,
+			merkleBlockOneBytes,
+			btcwire.ProtocolVersion,
+		},
+
+		// Protocol version BIP0037Version.
+		{
+			&merkleBlockOne,
+			&merkleBlockOne,
+			merkleBlockOneBytes,
+			btcwire.BIP0037Version,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg btcwire.MsgMerkleBlock
+		rbuf := bytes.NewBuffer(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestMerkleBlockWireErrors performs negative tests against wire encode and
+// decode of MsgMerkleBlock to confirm error paths work correctly.
+func TestMerkleBlockWireErrors(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	tests := []struct {
+		in       *btcwire.MsgMerkleBlock // Value to encode
+		buf      []byte                  // Wire encoding
+		pver     uint32                  // Protocol version for wire encoding
+		max      int                     // Max size of fixed buffer to induce errors
+		writeErr error                   // Expected write error
+		readErr  error                   // Expected read error
+	}{
+		// Force error in version.
+		{&merkleBlockOne, merkleBlockOneBytes, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in prev block hash.
+		{&merkleBlockOne, merkleBlockOneBytes, pver, 4, io.ErrShortWrite, io.EOF},
+		// Force error in merkle root.
+		{&merkleBlockOne, merkleBlockOneBytes, pver, 36, io.ErrShortWrite, io.EOF},
+		// Force error in timestamp.
+		{&merkleBlockOne, merkleBlockOneBytes, pver, 68, io.ErrShortWrite, io.EOF},
+		// Force error in difficulty bits.
+		{&merkleBlockOne, merkleBlockOneBytes, pver, 72, io.ErrShortWrite, io.EOF},
+		// Force error in header nonce.
+		{&merkleBlockOne, merkleBlockOneBytes, pver, 76, io.ErrShortWrite, io.EOF},
+		// Force error in transaction count.
+		{&merkleBlockOne, merkleBlockOneBytes, pver, 80, io.ErrShortWrite, io.EOF},
+		// Force error in num hashes.
+		{&merkleBlockOne, merkleBlockOneBytes, pver, 84, io.ErrShortWrite, io.EOF},
+		// Force error in hashes.
+		{&merkleBlockOne, merkleBlockOneBytes, pver, 85, io.ErrShortWrite, io.EOF},
+		// Force error in num flag bytes.
+		{&merkleBlockOne, merkleBlockOneBytes, pver, 117, io.ErrShortWrite, io.EOF},
+		// Force error in flag bytes.
+		{&merkleBlockOne, merkleBlockOneBytes, pver, 118, io.ErrShortWrite, io.EOF},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if err != test.writeErr {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// Decode from wire format.
+		var msg btcwire.MsgMerkleBlock
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if err != test.readErr {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+	}
+}
+
+// TestMerkleBlockBounds ensures partial merkle trees which could not belong to
+// a block with the claimed number of transactions are rejected before the
+// hashes are read.
+func TestMerkleBlockBounds(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	header := merkleBlockOneBytes[:80]
+
+	tests := []struct {
+		name string // Short description of the test
+		buf  []byte // Wire encoding
+		code btcwire.ErrorCode
+		ok   bool // Whether the message is valid
+	}{
+		{
+			"single transaction",
+			merkleBlockOneBytes,
+			0,
+			true,
+		},
+		{
+			"no transactions",
+			joinBytes(header, []byte{
+				0x00, 0x00, 0x00, 0x00, // Transactions
+				0x00, // Varint for number of hashes
+				0x00, // Varint for number of flag bytes
+			}),
+			btcwire.ErrMalformed,
+			false,
+		},
+		{
+			"more transactions than fit in a block",
+			joinBytes(header, []byte{
+				0xff, 0xff, 0xff, 0xff, // Transactions
+				0x00, // Varint for number of hashes
+				0x00, // Varint for number of flag bytes
+			}),
+			btcwire.ErrTooManyItems,
+			false,
+		},
+		{
+			// The hashes are not provided since they must not be
+			// read.
+			"more hashes than transactions",
+			joinBytes(header, []byte{
+				0x02, 0x00, 0x00, 0x00, // Transactions
+				0xfe, 0x00, 0x00, 0x01, 0x00, // Varint for number of hashes
+			}),
+			btcwire.ErrTooManyItems,
+			false,
+		},
+		{
+			// A tree of 3 transactions has 3 + 2 + 1 = 6 nodes, so
+			// it uses at most 1 flag byte.
+			"more flag bytes than tree nodes",
+			joinBytes(header, []byte{
+				0x03, 0x00, 0x00, 0x00, // Transactions
+				0x00,             // Varint for number of hashes
+				0x02, 0x00, 0x00, // Flag bytes
+			}),
+			btcwire.ErrPayloadTooLarge,
+			false,
+		},
+		{
+			"fewer flag bits than hashes",
+			joinBytes(header, []byte{
+				0x09, 0x00, 0x00, 0x00, // Transactions
+				0x09, // Varint for number of hashes
+			}, make([]byte, 9*32), []byte{
+				0x01, 0xff, // Flag bytes
+			}),
+			btcwire.ErrMalformed,
+			false,
+		},
+		{
+			"flag bits for every hash",
+			joinBytes(header, []byte{
+				0x09, 0x00, 0x00, 0x00, // Transactions
+				0x09, // Varint for number of hashes
+			}, make([]byte, 9*32), []byte{
+				0x03, 0xff, 0xff, 0xff, // Flag bytes
+			}),
+			0,
+			true,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		var msg btcwire.MsgMerkleBlock
+		err := msg.BtcDecode(bytes.NewReader(test.buf), pver)
+		if test.ok {
+			if err != nil {
+				t.Errorf("BtcDecode #%d (%s) unexpected error: %v",
+					i, test.name, err)
+			}
+			continue
+		}
+		msgErr, ok := err.(*btcwire.MessageError)
+		if !ok {
+			t.Errorf("BtcDecode #%d (%s) wrong error got: %v <%T>, "+
+				"want: <*btcwire.MessageError>", i, test.name,
+				err, err)
+			continue
+		}
+		if msgErr.Code != test.code {
+			t.Errorf("BtcDecode #%d (%s) wrong error code got: %v, "+
+				"want: %v", i, test.name, msgErr.Code, test.code)
+			continue
+		}
+	}
+}
+
+// TestMerkleBlockPartialTree tests building partial merkle trees with
+// NewMsgMerkleBlockForBlock and extracting the matched transactions from them
+// with ExtractMatches.
+func TestMerkleBlockPartialTree(t *testing.T) {
+	// Ensure the matched transaction of the block one merkle block is
+	// extracted.
+	hashes, indexes, err := merkleBlockOne.ExtractMatches()
+	if err != nil {
+		t.Fatalf("ExtractMatches: %v", err)
+	}
+	wantHashes := []*btcwire.ShaHash{&blockOne.Header.MerkleRoot}
+	if !reflect.DeepEqual(hashes, wantHashes) ||
+		!reflect.DeepEqual(indexes, []uint32{0}) {
+		t.Errorf("ExtractMatches: wrong matches for block one - got "+
+			"%v %v, want %v %v", spew.Sdump(hashes), indexes,
+			spew.Sdump(wantHashes), []uint32{0})
+	}
+
+	tests := []struct {
+		numTx   int    // Number of transactions in the block
+		matches []bool // Matched transactions
+	}{
+		{1, []bool{false}},
+		{2, []bool{false, true}},
+		{3, []bool{false, false, true}},
+		{3, []bool{true, true, true}},
+		{7, []bool{false, false, false, false, false, false, false}},
+		{7, []bool{true, false, false, true, false, false, true}},
+		{16, []bool{false, false, false, false, false, true, false,
+			false, false, false, false, false, false, false, true,
+			false}},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		block := btcwire.NewMsgBlock(&blockOne.Header)
+		for j := 0; j < test.numTx; j++ {
+			tx := multiTx.Copy()
+			tx.LockTime = uint32(j)
+			block.AddTransaction(tx)
+		}
+		block.Header.MerkleRoot = block.CalcMerkleRoot()
+		txHashes := block.TxHashes()
+
+		var wantHashes []*btcwire.ShaHash
+		var wantIndexes []uint32
+		for j, match := range test.matches {
+			if match {
+				wantHashes = append(wantHashes, &txHashes[j])
+				wantIndexes = append(wantIndexes, uint32(j))
+			}
+		}
+
+		matched := make(map[*btcwire.MsgTx]bool)
+		for j, tx := range block.Transactions {
+			matched[tx] = test.matches[j]
+		}
+		msg := btcwire.NewMsgMerkleBlockForBlock(block,
+			func(tx *btcwire.MsgTx) bool {
+				return matched[tx]
+			})
+		if msg.Transactions != uint32(test.numTx) {
+			t.Errorf("NewMsgMerkleBlockForBlock #%d: wrong number "+
+				"of transactions - got %v, want %v", i,
+				msg.Transactions, test.numTx)
+			continue
+		}
+
+		// Ensure the partial merkle tree survives a round trip through
+		// the wire encoding.
+		var buf bytes.Buffer
+		err := msg.BtcEncode(&buf, btcwire.ProtocolVersion)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		var readMsg btcwire.MsgMerkleBlock
+		err = readMsg.BtcDecode(&buf, btcwire.ProtocolVersion)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+
+		hashes, indexes, err := readMsg.ExtractMatches()
+		if err != nil {
+			t.Errorf("ExtractMatches #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(hashes, wantHashes) {
+			t.Errorf("ExtractMatches #%d: wrong hashes - got %v, "+
+				"want %v", i, spew.Sdump(hashes),
+				spew.Sdump(wantHashes))
+		}
+		if !reflect.DeepEqual(indexes, wantIndexes) {
+			t.Errorf("ExtractMatches #%d: wrong indexes - got %v, "+
+				"want %v", i, indexes, wantIndexes)
+		}
+	}
+}
+
+// TestMerkleBlockPartialTreeErrors tests ExtractMatches rejects malformed
+// partial merkle trees.
+func TestMerkleBlockPartialTreeErrors(t *testing.T) {
+	hash := &blockOne.Header.MerkleRoot
+	otherHash := &btcwire.ShaHash{0x01}
+
+	// Block with two identical transactions, which has the same merkle
+	// root as the block with just one of them.
+	dupHeader := blockOne.Header
+	dupBlock := btcwire.NewMsgBlock(&dupHeader)
+	dupBlock.AddTransaction(blockOne.Transactions[0])
+	dupBlock.AddTransaction(blockOne.Transactions[0])
+	dupHeader.MerkleRoot = dupBlock.CalcMerkleRoot()
+
+	tests := []struct {
+		header  *btcwire.BlockHeader
+		numTx   uint32
+		hashes  []*btcwire.ShaHash
+		flags   []byte
+		wantErr error
+	}{
+		// Merkle root which doesn't match the header.
+		{&blockOne.Header, 1, []*btcwire.ShaHash{otherHash}, []byte{0x01},
+			btcwire.ErrBadMerkleRoot},
+		// No transactions.
+		{&blockOne.Header, 0, nil, []byte{0x00},
+			btcwire.ErrBadPartialMerkleTree},
+		// More hashes than transactions.
+		{&blockOne.Header, 1, []*btcwire.ShaHash{hash, hash}, []byte{0x01},
+			btcwire.ErrBadPartialMerkleTree},
+		// Unused hash.
+		{&blockOne.Header, 2, []*btcwire.ShaHash{hash, hash}, []byte{0x00},
+			btcwire.ErrBadPartialMerkleTree},
+		// Missing hash.
+		{&blockOne.Header, 2, []*btcwire.ShaHash{hash}, []byte{0x07},
+			btcwire.ErrBadPartialMerkleTree},
+		// Nil hash.
+		{&blockOne.Header, 1, []*btcwire.ShaHash{nil}, []byte{0x01},
+			btcwire.ErrBadPartialMerkleTree},
+		// Unused flag byte.
+		{&blockOne.Header, 1, []*btcwire.ShaHash{hash}, []byte{0x01, 0x00},
+			btcwire.ErrBadPartialMerkleTree},
+		// Missing flag bits.
+		{&blockOne.Header, 2, []*btcwire.ShaHash{hash}, nil,
+			btcwire.ErrBadPartialMerkleTree},
+		// Duplicated right child.
+		{&dupHeader, 2, []*btcwire.ShaHash{hash, hash}, []byte{0x07},
+			btcwire.ErrBadPartialMerkleTree},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		msg := btcwire.NewMsgMerkleBlock(test.header)
+		msg.Transactions = test.numTx
+		msg.Hashes = test.hashes
+		msg.Flags = test.flags
+
+		_, _, err := msg.ExtractMatches()
+		if !errors.Is(err, test.wantErr) {
+			t.Errorf("ExtractMatches #%d wrong error got: %v, "+
+				"want: %v", i, err, test.wantErr)
+			continue
+		}
+	}
+}
+
+// merkleBlockOne is a merkle block created from block one of the block chain
+// where the only transaction matched.
+var merkleBlockOne = btcwire.MsgMerkleBlock{
+	Header: btcwire.BlockHeader{
+		Version:    blockOne.Header.Version,
+		PrevBlock:  blockOne.Header.PrevBlock,
+		MerkleRoot: blockOne.Header.MerkleRoot,
+		Timestamp:  blockOne.Header.Timestamp,
+		Bits:       blockOne.Header.Bits,
+		Nonce:      blockOne.Header.Nonce,
+	},
+	Transactions: 1,
+	Hashes: []*btcwire.ShaHash{
+		&blockOne.Header.MerkleRoot,
+	},
+	Flags: []byte{0x01},
+}
+
+// merkleBlockOneBytes is the serialized bytes for a merkle block created from
+// block one of the block chain where the only transaction matched.
+var merkleBlockOneBytes = joinBytes(
+	blockOneBytes[:80], // Block header without the transaction count
+	[]byte{
+		0x01, 0x00, 0x00, 0x00, // Transactions
+		0x01, // Varint for number of hashes
+	},
+	blockOne.Header.MerkleRoot[:], // Hash
+	[]byte{
+		0x01, // Varint for number of flag bytes
+		0x01, // Flag bytes
+	},
+)
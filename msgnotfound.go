@@ -22,65 +22,23 @@ type MsgNotFound struct {
 
 // AddInvVect adds an inventory vector to the message.
 func (msg *MsgNotFound) AddInvVect(iv *InvVect) error {
-	if len(msg.InvList)+1 > MaxInvPerMsg {
-		str := fmt.Sprintf("too many invvect in message [max %v]",
-			MaxInvPerMsg)
-		return messageError("MsgNotFound.AddInvVect", str)
-	}
-
-	msg.InvList = append(msg.InvList, iv)
-	return nil
+	var err error
+	msg.InvList, err = addInvVect(msg.InvList, iv, "MsgNotFound.AddInvVect")
+	return err
 }
 
 // BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
 // This is part of the Message interface implementation.
 func (msg *MsgNotFound) BtcDecode(r io.Reader, pver uint32) error {
-	count, err := readVarInt(r, pver)
-	if err != nil {
-		return err
-	}
-
-	// Limit to max inventory vectors per message.
-	if count > MaxInvPerMsg {
-		str := fmt.Sprintf("too many invvect in message [%v]", count)
-		return messageError("MsgNotFound.BtcDecode", str)
-	}
-
-	for i := uint64(0); i < count; i++ {
-		iv := InvVect{}
-		err := readInvVect(r, pver, &iv)
-		if err != nil {
-			return err
-		}
-		msg.AddInvVect(&iv)
-	}
-
-	return nil
+	var err error
+	msg.InvList, err = readInvList(r, pver, msg.InvList, "MsgNotFound.BtcDecode")
+	return err
 }
 
 // BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
 // This is part of the Message interface implementation.
 func (msg *MsgNotFound) BtcEncode(w io.Writer, pver uint32) error {
-	// Limit to max inventory vectors per message.
-	count := len(msg.InvList)
-	if count > MaxInvPerMsg {
-		str := fmt.Sprintf("too many invvect in message [%v]", count)
-		return messageError("MsgNotFound.BtcEncode", str)
-	}
-
-	err := writeVarInt(w, pver, uint64(count))
-	if err != nil {
-		return err
-	}
-
-	for _, iv := range msg.InvList {
-		err := writeInvVect(w, pver, iv)
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return writeInvList(w, pver, msg.InvList, "MsgNotFound.BtcEncode")
 }
 
 // Command returns the protocol command string for the message.  This is part
@@ -89,6 +47,11 @@ func (msg *MsgNotFound) Command() string {
 	return cmdNotFound
 }
 
+// String returns the message in human-readable form.
+func (msg *MsgNotFound) String() string {
+	return fmt.Sprintf("%s count=%d", msg.Command(), len(msg.InvList))
+}
+
 // MaxPayloadLength returns the maximum length the payload can be for the
 // receiver.  This is part of the Message interface implementation.
 func (msg *MsgNotFound) MaxPayloadLength(pver uint32) uint32 {
@@ -222,6 +222,80 @@ func TestNotFoundWire(t *testing.T) {
 	}
 }
 
+// TestNotFoundWitnessRoundTrip ensures inventory vectors with witness types,
+// as well as unknown types with high bits set, survive being requested with a
+// getdata message and returned in a notfound message unchanged.
+func TestNotFoundWitnessRoundTrip(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	hash := btcwire.ShaHash{0x01, 0x02, 0x03}
+
+	types := []btcwire.InvType{
+		btcwire.InvVect_WitnessTx,
+		btcwire.InvVect_WitnessBlock,
+		btcwire.InvVect_FilteredWitnessBlock,
+		btcwire.InvType(0x80000002),
+		btcwire.InvType(0xffffffff),
+	}
+
+	getData := btcwire.NewMsgGetData()
+	for _, typ := range types {
+		getData.AddInvVect(btcwire.NewInvVect(typ, &hash))
+	}
+
+	// Send the getdata and decode it as the remote peer would.
+	var buf bytes.Buffer
+	err := getData.BtcEncode(&buf, pver)
+	if err != nil {
+		t.Fatalf("MsgGetData.BtcEncode: %v", err)
+	}
+	var gotGetData btcwire.MsgGetData
+	err = gotGetData.BtcDecode(&buf, pver)
+	if err != nil {
+		t.Fatalf("MsgGetData.BtcDecode: %v", err)
+	}
+	if !reflect.DeepEqual(&gotGetData, getData) {
+		t.Fatalf("MsgGetData.BtcDecode\n got: %s want: %s",
+			spew.Sdump(&gotGetData), spew.Sdump(getData))
+	}
+
+	// Reply with all of the requested inventory vectors as not found.
+	notFound := btcwire.NewMsgNotFound()
+	for _, iv := range gotGetData.InvList {
+		notFound.AddInvVect(iv)
+	}
+	buf.Reset()
+	err = notFound.BtcEncode(&buf, pver)
+	if err != nil {
+		t.Fatalf("MsgNotFound.BtcEncode: %v", err)
+	}
+	var gotNotFound btcwire.MsgNotFound
+	err = gotNotFound.BtcDecode(&buf, pver)
+	if err != nil {
+		t.Fatalf("MsgNotFound.BtcDecode: %v", err)
+	}
+
+	t.Logf("Running %d tests", len(types))
+	if len(gotNotFound.InvList) != len(types) {
+		t.Fatalf("MsgNotFound.BtcDecode: wrong number of inventory "+
+			"vectors - got %d, want %d", len(gotNotFound.InvList),
+			len(types))
+	}
+	for i, typ := range types {
+		iv := gotNotFound.InvList[i]
+		if iv.Type != typ || !iv.Hash.IsEqual(&hash) {
+			t.Errorf("MsgNotFound.BtcDecode #%d\n got: %s want: %s",
+				i, spew.Sdump(iv),
+				spew.Sdump(btcwire.NewInvVect(typ, &hash)))
+			continue
+		}
+		if iv.Type&btcwire.InvWitnessFlag != typ&btcwire.InvWitnessFlag {
+			t.Errorf("MsgNotFound.BtcDecode #%d witness flag not "+
+				"preserved", i)
+			continue
+		}
+	}
+}
+
 // TestNotFoundWireErrors performs negative tests against wire encode and decode
 // of MsgNotFound to confirm error paths work correctly.
 func TestNotFoundWireErrors(t *testing.T) {
@@ -5,6 +5,7 @@
 package btcwire
 
 import (
+	"fmt"
 	"io"
 )
 
@@ -63,6 +64,11 @@ func (msg *MsgPing) Command() string {
 	return cmdPing
 }
 
+// String returns the message in human-readable form.
+func (msg *MsgPing) String() string {
+	return fmt.Sprintf("%s nonce=%d", msg.Command(), msg.Nonce)
+}
+
 // MaxPayloadLength returns the maximum length the payload can be for the
 // receiver.  This is part of the Message interface implementation.
 func (msg *MsgPing) MaxPayloadLength(pver uint32) uint32 {
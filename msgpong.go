@@ -11,7 +11,8 @@ import (
 
 // MsgPong implements the Message interface and represents a bitcoin pong
 // message which is used primarily to confirm that a connection is still valid
-// in response to a bitcoin ping message (MsgPing).
+// in response to a bitcoin ping message (MsgPing).  See PingTracker for
+// matching pongs to the pings they answer to measure round-trip times.
 //
 // This message was not added until protocol versions AFTER BIP0031Version.
 type MsgPong struct {
@@ -28,7 +29,7 @@ func (msg *MsgPong) BtcDecode(r io.Reader, pver uint32) error {
 	if pver <= BIP0031Version {
 		str := fmt.Sprintf("pong message invalid for protocol "+
 			"version %d", pver)
-		return messageError("MsgPong.BtcDecode", str)
+		return messageError("MsgPong.BtcDecode", ErrProtocolVersion, str)
 	}
 
 	err := readElement(r, &msg.Nonce)
@@ -47,7 +48,7 @@ func (msg *MsgPong) BtcEncode(w io.Writer, pver uint32) error {
 	if pver <= BIP0031Version {
 		str := fmt.Sprintf("pong message invalid for protocol "+
 			"version %d", pver)
-		return messageError("MsgPong.BtcEncode", str)
+		return messageError("MsgPong.BtcEncode", ErrProtocolVersion, str)
 	}
 
 	err := writeElement(w, msg.Nonce)
@@ -64,6 +65,11 @@ func (msg *MsgPong) Command() string {
 	return cmdPong
 }
 
+// String returns the message in human-readable form.
+func (msg *MsgPong) String() string {
+	return fmt.Sprintf("%s nonce=%d", msg.Command(), msg.Nonce)
+}
+
 // MaxPayloadLength returns the maximum length the payload can be for the
 // receiver.  This is part of the Message interface implementation.
 func (msg *MsgPong) MaxPayloadLength(pver uint32) uint32 {
@@ -0,0 +1,256 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// MaxRejectReasonLen is the maximum length of the reason string in a reject
+// message (MsgReject).  Longer reasons are rejected on encode and decode, and
+// truncated by the reject helpers in this package.  It matches the limit used
+// by bitcoind.
+const MaxRejectReasonLen = 111
+
+// RejectCode represents a numeric value by which a remote peer indicates
+// why a message was rejected.
+type RejectCode uint8
+
+// These constants define the various supported reject codes.
+const (
+	RejectMalformed       RejectCode = 0x01
+	RejectInvalid         RejectCode = 0x10
+	RejectObsolete        RejectCode = 0x11
+	RejectDuplicate       RejectCode = 0x12
+	RejectNonstandard     RejectCode = 0x40
+	RejectDust            RejectCode = 0x41
+	RejectInsufficientFee RejectCode = 0x42
+	RejectCheckpoint      RejectCode = 0x43
+)
+
+// Map of reject codes back to their constant names for pretty printing.
+var rejectCodeStrings = map[RejectCode]string{
+	RejectMalformed:       "REJECT_MALFORMED",
+	RejectInvalid:         "REJECT_INVALID",
+	RejectObsolete:        "REJECT_OBSOLETE",
+	RejectDuplicate:       "REJECT_DUPLICATE",
+	RejectNonstandard:     "REJECT_NONSTANDARD",
+	RejectDust:            "REJECT_DUST",
+	RejectInsufficientFee: "REJECT_INSUFFICIENTFEE",
+	RejectCheckpoint:      "REJECT_CHECKPOINT",
+}
+
+// String returns the RejectCode in human-readable form.
+func (code RejectCode) String() string {
+	if s, ok := rejectCodeStrings[code]; ok {
+		return s
+	}
+
+	return fmt.Sprintf("Unknown RejectCode (%d)", uint8(code))
+}
+
+// MsgReject implements the Message interface and represents a bitcoin reject
+// message.  It is used to inform a peer that a message it sent was rejected
+// along with the reason why.
+//
+// This message was not added until protocol version RejectVersion.
+type MsgReject struct {
+	// Cmd is the command for the message which was rejected such as
+	// cmdBlock or cmdTx.  This can be obtained from the Command function
+	// of a Message.  Like any command, it is limited to 12 bytes.
+	Cmd string
+
+	// Code is a code indicating why the command was rejected.  It is
+	// encoded as a uint8 on the wire.
+	Code RejectCode
+
+	// Reason is a human-readable string with specific details (over and
+	// above the reject code) about why the command was rejected.
+	Reason string
+
+	// Hash identifies a specific block or transaction that was rejected
+	// and therefore only applies to the MsgBlock and MsgTx messages.  It
+	// is only encoded on the wire when Cmd is cmdBlock or cmdTx.
+	Hash ShaHash
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgReject) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < RejectVersion {
+		str := fmt.Sprintf("reject message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgReject.BtcDecode", ErrProtocolVersion, str)
+	}
+
+	// Command that was rejected.  Commands never exceed the size of the
+	// command field of the message header.
+	cmd, err := readLimitedVarString(r, pver, commandSize, "reject command")
+	if err != nil {
+		return err
+	}
+	msg.Cmd = cmd
+
+	// Code indicating why the command was rejected.
+	err = readElement(r, &msg.Code)
+	if err != nil {
+		return err
+	}
+
+	// Human readable string with specific details (over and above the
+	// reject code above) about why the command was rejected.
+	reason, err := readTextVarString(r, pver, MaxRejectReasonLen,
+		"reject reason")
+	if err != nil {
+		return err
+	}
+	msg.Reason = reason
+
+	// cmdBlock and cmdTx messages have an additional hash field that
+	// identifies the specific block or transaction.
+	if msg.Cmd == cmdBlock || msg.Cmd == cmdTx {
+		err := readElement(r, &msg.Hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgReject) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < RejectVersion {
+		str := fmt.Sprintf("reject message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgReject.BtcEncode", ErrProtocolVersion, str)
+	}
+
+	// Command that was rejected.
+	if len(msg.Cmd) > commandSize {
+		str := fmt.Sprintf("reject command is too long [len %v, max %v]",
+			len(msg.Cmd), commandSize)
+		return messageError("MsgReject.BtcEncode", ErrPayloadTooLarge, str)
+	}
+	err := writeVarString(w, pver, msg.Cmd)
+	if err != nil {
+		return err
+	}
+
+	// Code indicating why the command was rejected.
+	err = writeElement(w, msg.Code)
+	if err != nil {
+		return err
+	}
+
+	// Human readable string with specific details (over and above the
+	// reject code above) about why the command was rejected.
+	if len(msg.Reason) > MaxRejectReasonLen {
+		str := fmt.Sprintf("reject reason is too long [len %v, max %v]",
+			len(msg.Reason), MaxRejectReasonLen)
+		return messageError("MsgReject.BtcEncode", ErrPayloadTooLarge, str)
+	}
+	err = writeVarString(w, pver, msg.Reason)
+	if err != nil {
+		return err
+	}
+
+	// cmdBlock and cmdTx messages have an additional hash field that
+	// identifies the specific block or transaction.
+	if msg.Cmd == cmdBlock || msg.Cmd == cmdTx {
+		err := writeElement(w, &msg.Hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgReject) Command() string {
+	return cmdReject
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgReject) String() string {
+	str := fmt.Sprintf("%s cmd=%s code=%v reason=%q", msg.Command(),
+		msg.Cmd, msg.Code, msg.Reason)
+	if msg.Cmd == cmdBlock || msg.Cmd == cmdTx {
+		str += " hash=" + msg.Hash.String()
+	}
+	return str
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgReject) MaxPayloadLength(pver uint32) uint32 {
+	plen := uint32(0)
+	// The reject message did not exist before protocol version
+	// RejectVersion.
+	if pver >= RejectVersion {
+		// Length of the command (varInt) + max command size + code 1
+		// byte + length of the reason (varInt) + max reason length +
+		// hash of the rejected block or transaction.
+		plen = maxVarIntPayload + commandSize + 1 + maxVarIntPayload +
+			MaxRejectReasonLen + HashSize
+	}
+
+	return plen
+}
+
+// NewMsgReject returns a new bitcoin reject message that conforms to the
+// Message interface.  See MsgReject for details.
+func NewMsgReject(command string, code RejectCode, reason string) *MsgReject {
+	return &MsgReject{
+		Cmd:    command,
+		Code:   code,
+		Reason: reason,
+	}
+}
+
+// truncateRejectReason limits reason to MaxRejectReasonLen bytes.  It is cut
+// at a rune boundary so a valid UTF-8 reason remains valid UTF-8.
+func truncateRejectReason(reason string) string {
+	if len(reason) <= MaxRejectReasonLen {
+		return reason
+	}
+
+	n := MaxRejectReasonLen
+	for n > 0 && !utf8.RuneStart(reason[n]) {
+		n--
+	}
+	return reason[:n]
+}
+
+// NewMsgRejectForTx returns a new bitcoin reject message for the passed
+// transaction with the command, code, reason, and hash fields filled in.  The
+// reason is truncated to MaxRejectReasonLen bytes if needed.
+func NewMsgRejectForTx(tx *MsgTx, code RejectCode, reason string) *MsgReject {
+	// Ignore the error since TxSha can't currently fail.
+	hash, _ := tx.TxSha(ProtocolVersion)
+
+	msg := NewMsgReject(cmdTx, code, truncateRejectReason(reason))
+	msg.Hash = hash
+	return msg
+}
+
+// NewMsgRejectForBlock returns a new bitcoin reject message for the passed
+// block with the command, code, reason, and hash fields filled in.  The
+// reason is truncated to MaxRejectReasonLen bytes if needed.
+func NewMsgRejectForBlock(block *MsgBlock, code RejectCode,
+	reason string) *MsgReject {
+
+	// Ignore the error since BlockSha can't currently fail.
+	hash, _ := block.BlockSha(ProtocolVersion)
+
+	msg := NewMsgReject(cmdBlock, code, truncateRejectReason(reason))
+	msg.Hash = hash
+	return msg
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"fmt"
+	"io"
+)
+
+// MsgSendAddrV2 implements the Message interface and represents a bitcoin
+// sendaddrv2 message.  It is used during the version handshake, prior to the
+// verack message (MsgVerAck), to signal that a peer supports receiving
+// addresses in addrv2 messages (MsgAddrV2) as defined by BIP0155.  Peers which
+// did not send it must only be sent addr messages (MsgAddr).  See
+// FilterAddrsForPeer.
+//
+// This message has no payload and was not added until protocol versions
+// starting with SendAddrV2Version.
+type MsgSendAddrV2 struct{}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgSendAddrV2) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < SendAddrV2Version {
+		str := fmt.Sprintf("sendaddrv2 message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgSendAddrV2.BtcDecode", ErrProtocolVersion, str)
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgSendAddrV2) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < SendAddrV2Version {
+		str := fmt.Sprintf("sendaddrv2 message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgSendAddrV2.BtcEncode", ErrProtocolVersion, str)
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgSendAddrV2) Command() string {
+	return cmdSendAddrV2
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgSendAddrV2) String() string {
+	return msg.Command()
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgSendAddrV2) MaxPayloadLength(pver uint32) uint32 {
+	return 0
+}
+
+// NewMsgSendAddrV2 returns a new bitcoin sendaddrv2 message that conforms to
+// the Message interface.  See MsgSendAddrV2 for details.
+func NewMsgSendAddrV2() *MsgSendAddrV2 {
+	return &MsgSendAddrV2{}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"testing"
+)
+
+// TestSendAddrV2 tests the MsgSendAddrV2 API against the protocol versions
+// before and after it was added.
+func TestSendAddrV2(t *testing.T) {
+	pver := btcwire.SendAddrV2Version
+
+	// Ensure the command is expected value.
+	wantCmd := "sendaddrv2"
+	msg := btcwire.NewMsgSendAddrV2()
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgSendAddrV2: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value.
+	wantPayload := uint32(0)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Test encode with the protocol version which added the message.
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, pver)
+	if err != nil {
+		t.Errorf("encode of MsgSendAddrV2 failed %v err <%v>", msg, err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("encode of MsgSendAddrV2 produced a payload of %d "+
+			"bytes", buf.Len())
+	}
+
+	// Older protocol versions should fail encode since message didn't
+	// exist yet.
+	oldPver := btcwire.SendAddrV2Version - 1
+	err = msg.BtcEncode(&buf, oldPver)
+	if err == nil {
+		s := "encode of MsgSendAddrV2 passed for old protocol version %v err <%v>"
+		t.Errorf(s, msg, err)
+	}
+
+	// Test decode with the protocol version which added the message.
+	readmsg := btcwire.NewMsgSendAddrV2()
+	err = readmsg.BtcDecode(&buf, pver)
+	if err != nil {
+		t.Errorf("decode of MsgSendAddrV2 failed [%v] err <%v>", buf, err)
+	}
+
+	// Older protocol versions should fail decode since message didn't
+	// exist yet.
+	err = readmsg.BtcDecode(&buf, oldPver)
+	if err == nil {
+		s := "decode of MsgSendAddrV2 passed for old protocol version %v err <%v>"
+		t.Errorf(s, msg, err)
+	}
+
+	return
+}
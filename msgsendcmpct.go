@@ -0,0 +1,87 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"fmt"
+	"io"
+)
+
+// CmpctBlockVersion is the version of compact blocks implemented by this
+// package as defined by BIP0152.  Version 2 compact blocks compute the short
+// transaction ids from the witness hashes of the transactions and include the
+// witness data of prefilled transactions.
+const CmpctBlockVersion uint64 = 2
+
+// MsgSendCmpct implements the Message interface and represents a bitcoin
+// sendcmpct message.  It is used to signal that a peer supports compact block
+// relay (MsgCmpctBlock) of the given version as defined by BIP0152.
+//
+// When AnnounceUsingCmpctBlock is set, the sender asks to be announced new
+// blocks directly with cmpctblock messages, which is known as high-bandwidth
+// mode.  Otherwise new blocks are announced with inv or headers messages and
+// compact blocks are only sent in response to a getdata message for an
+// InvVect_CmpctBlock inventory vector.  See ShouldAnnounceCompact.
+//
+// This message was not added until protocol version SendCmpctVersion.
+type MsgSendCmpct struct {
+	AnnounceUsingCmpctBlock bool
+	CmpctBlockVersion       uint64
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgSendCmpct) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < SendCmpctVersion {
+		str := fmt.Sprintf("sendcmpct message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgSendCmpct.BtcDecode", ErrProtocolVersion, str)
+	}
+
+	return readElements(r, &msg.AnnounceUsingCmpctBlock,
+		&msg.CmpctBlockVersion)
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgSendCmpct) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < SendCmpctVersion {
+		str := fmt.Sprintf("sendcmpct message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgSendCmpct.BtcEncode", ErrProtocolVersion, str)
+	}
+
+	return writeElements(w, msg.AnnounceUsingCmpctBlock,
+		msg.CmpctBlockVersion)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgSendCmpct) Command() string {
+	return cmdSendCmpct
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgSendCmpct) String() string {
+	return fmt.Sprintf("%s announce=%v version=%d", msg.Command(),
+		msg.AnnounceUsingCmpctBlock, msg.CmpctBlockVersion)
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgSendCmpct) MaxPayloadLength(pver uint32) uint32 {
+	// Announce flag 1 byte + version 8 bytes.
+	return 9
+}
+
+// NewMsgSendCmpct returns a new bitcoin sendcmpct message that conforms to the
+// Message interface using the passed parameters.  See MsgSendCmpct for
+// details.
+func NewMsgSendCmpct(announce bool, version uint64) *MsgSendCmpct {
+	return &MsgSendCmpct{
+		AnnounceUsingCmpctBlock: announce,
+		CmpctBlockVersion:       version,
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestSendCmpct tests the MsgSendCmpct API against the protocol versions
+// before and after it was added.
+func TestSendCmpct(t *testing.T) {
+	pver := btcwire.SendCmpctVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "sendcmpct"
+	msg := btcwire.NewMsgSendCmpct(true, btcwire.CmpctBlockVersion)
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgSendCmpct: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value.
+	// Announce flag 1 byte + version 8 bytes.
+	wantPayload := uint32(9)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Older protocol versions should fail encode and decode since the
+	// message didn't exist yet.
+	oldPver := btcwire.SendCmpctVersion - 1
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, oldPver)
+	if err == nil {
+		t.Errorf("encode of MsgSendCmpct succeeded when it should " +
+			"have failed")
+	}
+	var readmsg btcwire.MsgSendCmpct
+	err = readmsg.BtcDecode(bytes.NewBuffer(sendCmpctEncoded), oldPver)
+	if err == nil {
+		t.Errorf("decode of MsgSendCmpct succeeded when it should " +
+			"have failed")
+	}
+
+	// Ensure the message survives a round trip through WriteMessage and
+	// ReadMessage at the latest protocol version.
+	buf.Reset()
+	err = btcwire.WriteMessage(&buf, msg, btcwire.ProtocolVersion,
+		btcwire.MainNet)
+	if err != nil {
+		t.Errorf("WriteMessage: %v", err)
+		return
+	}
+	rmsg, _, err := btcwire.ReadMessage(&buf, btcwire.ProtocolVersion,
+		btcwire.MainNet)
+	if err != nil {
+		t.Errorf("ReadMessage: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(rmsg, msg) {
+		t.Errorf("ReadMessage: wrong message\n got: %s want: %s",
+			spew.Sdump(rmsg), spew.Sdump(msg))
+	}
+}
+
+// TestSendCmpctWire tests the MsgSendCmpct wire encode and decode.
+func TestSendCmpctWire(t *testing.T) {
+	tests := []struct {
+		in   *btcwire.MsgSendCmpct // Message to encode
+		out  *btcwire.MsgSendCmpct // Expected decoded message
+		buf  []byte                // Wire encoding
+		pver uint32                // Protocol version for wire encoding
+	}{
+		// Protocol version SendCmpctVersion with announce set.
+		{
+			sendCmpct,
+			sendCmpct,
+			sendCmpctEncoded,
+			btcwire.SendCmpctVersion,
+		},
+
+		// Protocol version SendCmpctVersion with announce unset.
+		{
+			btcwire.NewMsgSendCmpct(false, 1),
+			btcwire.NewMsgSendCmpct(false, 1),
+			[]byte{
+				0x00,                                           // Announce
+				0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Version
+			},
+			btcwire.SendCmpctVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg btcwire.MsgSendCmpct
+		rbuf := bytes.NewBuffer(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestSendCmpctWireErrors performs negative tests against wire encode and
+// decode of MsgSendCmpct to confirm error paths work correctly.
+func TestSendCmpctWireErrors(t *testing.T) {
+	pver := btcwire.SendCmpctVersion
+
+	tests := []struct {
+		in       *btcwire.MsgSendCmpct // Value to encode
+		buf      []byte                // Wire encoding
+		pver     uint32                // Protocol version for wire encoding
+		max      int                   // Max size of fixed buffer to induce errors
+		writeErr error                 // Expected write error
+		readErr  error                 // Expected read error
+	}{
+		// Force error in announce flag.
+		{sendCmpct, sendCmpctEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in version.
+		{sendCmpct, sendCmpctEncoded, pver, 1, io.ErrShortWrite, io.EOF},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if err != test.writeErr {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// Decode from wire format.
+		var msg btcwire.MsgSendCmpct
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if err != test.readErr {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+	}
+}
+
+// sendCmpct is a sendcmpct message asking for version 2 compact blocks in
+// high-bandwidth mode.
+var sendCmpct = btcwire.NewMsgSendCmpct(true, btcwire.CmpctBlockVersion)
+
+// sendCmpctEncoded is the wire encoded bytes for sendCmpct.
+var sendCmpctEncoded = []byte{
+	0x01,                                           // Announce
+	0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Version
+}
@@ -6,13 +6,148 @@ package btcwire
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"strconv"
+	"unsafe"
+)
+
+// Errors returned by CheckSanity.  The errors it returns wrap these so they
+// may be matched with errors.Is.
+var (
+	// ErrNoTxInputs indicates a transaction which does not have any
+	// inputs.
+	ErrNoTxInputs = errors.New("transaction has no inputs")
+
+	// ErrNoTxOutputs indicates a transaction which does not have any
+	// outputs.
+	ErrNoTxOutputs = errors.New("transaction has no outputs")
+
+	// ErrTxTooBig indicates a transaction which is too big to fit into a
+	// block.
+	ErrTxTooBig = errors.New("transaction is too big")
 )
 
 // MaxTxInSequenceNum is the maximum sequence number the sequence field
 // of a transaction input can be.
 const MaxTxInSequenceNum uint32 = 0xffffffff
 
+// CoinbaseMaturity is the number of blocks required before the outputs of a
+// coinbase transaction can be spent.  See IsCoinbaseMature.
+const CoinbaseMaturity = 100
+
+const (
+	// witnessMarker is the byte which follows the version of a transaction
+	// to mark it as using the witness serialization defined by BIP0144.
+	// It is encoded where the number of inputs is for legacy transactions.
+	witnessMarker byte = 0x00
+
+	// witnessFlag is the byte which follows witnessMarker in the witness
+	// serialization.
+	witnessFlag byte = 0x01
+
+	// minTxPayload is the minimum payload size for a transaction.  It is
+	// 4 bytes version + 1 byte varint input count + 1 byte varint output
+	// count + 4 bytes lock time.  Valid transactions are larger since
+	// they have at least one input and output, but it is used to bound the
+	// number of transactions a block is able to contain.
+	minTxPayload = 10
+
+	// minTxInPayload is the minimum payload size for a transaction input.
+	// It is 32 bytes previous output hash + 4 bytes previous output index
+	// + 1 byte varint signature script length + 4 bytes sequence.
+	minTxInPayload = 9 + HashSize
+
+	// maxTxInPerMessage is the maximum number of transaction inputs that
+	// could possibly fit into a message.
+	maxTxInPerMessage = (maxMessagePayload / minTxInPayload) + 1
+
+	// minTxOutPayload is the minimum payload size for a transaction
+	// output.  It is 8 bytes value + 1 byte varint public key script
+	// length.
+	minTxOutPayload = 9
+
+	// maxTxOutPerMessage is the maximum number of transaction outputs that
+	// could possibly fit into a message.
+	maxTxOutPerMessage = (maxMessagePayload / minTxOutPayload) + 1
+)
+
+const (
+	// MaxWitnessItemsPerInput is the maximum number of witness items
+	// allowed for a single input.  Each item takes at least one byte to
+	// encode its length, so this is the most which could fit in a block.
+	MaxWitnessItemsPerInput = MaxBlockPayload
+
+	// MaxWitnessItemSize is the size of the largest witness items which
+	// are relayed as standard.  Larger witness items of loose transactions
+	// are rejected before any memory is allocated for them when the
+	// StandardWitnessItems decode option is set.  See
+	// MaxConsensusWitnessItemSize.
+	MaxWitnessItemSize = 11000
+
+	// MaxConsensusWitnessItemSize is the maximum size of a single witness
+	// item allowed by consensus, which is only bounded by the block
+	// weight.  Witness items up to this size are decoded by default.
+	MaxConsensusWitnessItemSize = MaxBlockWeight
+)
+
+// TxWitness defines the witness for a transaction input as defined by
+// BIP0141.  It is a stack of items which are each an arbitrary byte slice.
+type TxWitness [][]byte
+
+// SerializeSize returns the number of bytes it would take to serialize the
+// witness as part of the witness serialization of a transaction.  An empty
+// witness still takes 1 byte for its zero item count.
+func (t TxWitness) SerializeSize() int {
+	n := VarIntSerializeSize(uint64(len(t)))
+	for _, item := range t {
+		n += VarIntSerializeSize(uint64(len(item))) + len(item)
+	}
+	return n
+}
+
+// These constants define the meaning of the bits in the sequence number of a
+// transaction input when it is interpreted as a relative lock-time as defined
+// by BIP0068.  Relative lock-times are only enforced for transactions with a
+// version of at least TxVersionSequenceLock.
+const (
+	// SequenceLockTimeDisabled is a flag that if set on a transaction
+	// input's sequence number, the sequence number will not be interpreted
+	// as a relative lock-time.
+	SequenceLockTimeDisabled uint32 = 1 << 31
+
+	// SequenceLockTimeIsSeconds is a flag that if set on a transaction
+	// input's sequence number, the relative lock-time has units of 512
+	// seconds.  Otherwise it is a number of blocks.
+	SequenceLockTimeIsSeconds uint32 = 1 << 22
+
+	// SequenceLockTimeMask is a mask that extracts the relative lock-time
+	// when masked against the transaction input sequence number.
+	SequenceLockTimeMask uint32 = 0x0000ffff
+
+	// SequenceLockTimeGranularity is the defined time based granularity
+	// for seconds-based relative lock-time.  The relative lock-time is
+	// shifted left by this amount to convert it to seconds, which makes
+	// each unit 512 seconds.
+	SequenceLockTimeGranularity = 9
+)
+
+// These constants define the transaction versions which have a specific
+// meaning.  The version is only interpreted by the consensus rules, so
+// transactions with versions other than these are still decoded and encoded
+// byte for byte.
+const (
+	// TxVersionInitial is the original transaction version, which is
+	// TxVersion.
+	TxVersionInitial uint32 = TxVersion
+
+	// TxVersionSequenceLock is the transaction version which enables
+	// relative lock-times via the input sequence numbers as defined by
+	// BIP0068.
+	TxVersionSequenceLock uint32 = 2
+)
+
 // Outpoint defines a bitcoin data type that is used to track previous
 // transaction outputs.
 type OutPoint struct {
@@ -29,24 +164,74 @@ func NewOutPoint(hash *ShaHash, index uint32) *OutPoint {
 	}
 }
 
+// String returns the outpoint in the human-readable form hash:index.
+func (o OutPoint) String() string {
+	return o.Hash.String() + ":" + strconv.FormatUint(uint64(o.Index), 10)
+}
+
 // TxIn defines a bitcoin transaction input.
 type TxIn struct {
 	PreviousOutpoint OutPoint
 	SignatureScript  []byte
+	Witness          TxWitness
 	Sequence         uint32
 }
 
+// copyScript returns a copy of the passed script.  A nil script is returned
+// as nil so the copy is otherwise indistinguishable from the original.
+func copyScript(script []byte) []byte {
+	if script == nil {
+		return nil
+	}
+	newScript := make([]byte, len(script))
+	copy(newScript, script)
+	return newScript
+}
+
 // NewTxIn returns a new bitcoin transaction input with the provided
 // previous outpoint point and signature script with a default sequence of
-// MaxTxInSequenceNum.
+// MaxTxInSequenceNum, which disables both the lock time of the transaction
+// and relative lock-times for the input unless changed.  The signature script
+// is copied, so the caller is free to modify the passed slice afterwards.
 func NewTxIn(prevOut *OutPoint, signatureScript []byte) *TxIn {
 	return &TxIn{
 		PreviousOutpoint: *prevOut,
-		SignatureScript:  signatureScript,
+		SignatureScript:  copyScript(signatureScript),
 		Sequence:         MaxTxInSequenceNum,
 	}
 }
 
+// RelativeLockTime interprets the sequence number of the input as a relative
+// lock-time as defined by BIP0068.  The returned value is a number of 512
+// second intervals when isSeconds is true and a number of blocks otherwise.
+// Shift the value left by SequenceLockTimeGranularity to obtain seconds.
+//
+// The enabled flag is false when the SequenceLockTimeDisabled bit is set, in
+// which case the sequence number does not encode a relative lock-time and the
+// other return values are zero.  Note that relative lock-times are also not
+// enforced for transactions with a version less than TxVersionSequenceLock,
+// which the caller must check separately.
+func (ti *TxIn) RelativeLockTime() (value uint32, isSeconds bool, enabled bool) {
+	if ti.Sequence&SequenceLockTimeDisabled != 0 {
+		return 0, false, false
+	}
+
+	value = ti.Sequence & SequenceLockTimeMask
+	isSeconds = ti.Sequence&SequenceLockTimeIsSeconds != 0
+	return value, isSeconds, true
+}
+
+// SerializeSize returns the number of bytes it would take to serialize the
+// transaction input.  The witness is serialized separately from the input and
+// is not included, see TxWitness.SerializeSize.
+func (ti *TxIn) SerializeSize() int {
+	// Outpoint hash 32 bytes + outpoint index 4 bytes + sequence 4 bytes +
+	// serialized varint size for the length of the signature script +
+	// signature script bytes.
+	return 40 + VarIntSerializeSize(uint64(len(ti.SignatureScript))) +
+		len(ti.SignatureScript)
+}
+
 // TxOut defines a bitcoin transaction output.
 type TxOut struct {
 	Value    int64
@@ -54,14 +239,55 @@ type TxOut struct {
 }
 
 // NewTxOut returns a new bitcoin transaction output with the provided
-// transaction value and public key script.
+// transaction value and public key script.  The public key script is copied,
+// so the caller is free to modify the passed slice afterwards.
 func NewTxOut(value int64, pkScript []byte) *TxOut {
 	return &TxOut{
 		Value:    value,
-		PkScript: pkScript,
+		PkScript: copyScript(pkScript),
 	}
 }
 
+// SerializeSize returns the number of bytes it would take to serialize the
+// transaction output.
+func (to *TxOut) SerializeSize() int {
+	// Value 8 bytes + serialized varint size for the length of the public
+	// key script + public key script bytes.
+	return 8 + VarIntSerializeSize(uint64(len(to.PkScript))) +
+		len(to.PkScript)
+}
+
+// IsWitnessProgram returns the version and program of the witness program in
+// the public key script of the output as defined by BIP0141 and whether or not
+// the script is one.  A witness program script is a push of a version from
+// OP_0 to OP_16 followed by a single direct push of the 2 to 40 byte program.
+// Only the shape of the script is checked, so the program is not validated
+// against any rules of its version.  The returned program shares the memory of
+// the script.
+func (to *TxOut) IsWitnessProgram() (version byte, program []byte, ok bool) {
+	script := to.PkScript
+	if len(script) < 4 || len(script) > 42 {
+		return 0, nil, false
+	}
+
+	// The version is OP_0 (0x00) or one of OP_1 (0x51) through OP_16
+	// (0x60), and the program is pushed with a single data push opcode
+	// whose value is its length.
+	switch {
+	case script[0] == 0x00:
+		version = 0
+	case script[0] >= 0x51 && script[0] <= 0x60:
+		version = script[0] - 0x50
+	default:
+		return 0, nil, false
+	}
+	if int(script[1]) != len(script)-2 {
+		return 0, nil, false
+	}
+
+	return version, script[2:], true
+}
+
 // MsgTx implements the Message interface and represents a bitcoin tx message.
 // It is used to deliver transaction information in response to a getdata
 // message (MsgGetData) for a given transaction.
@@ -75,28 +301,118 @@ type MsgTx struct {
 	LockTime uint32
 }
 
-// AddTxIn adds a transaction input to the message.
+// AddTxIn adds a transaction input to the message.  The input is added as is,
+// so its signature script is not copied and later modifications to it are
+// reflected in the message.  Use Copy to obtain an independent transaction.
 func (msg *MsgTx) AddTxIn(ti *TxIn) {
 	msg.TxIn = append(msg.TxIn, ti)
 }
 
-// AddTxOut adds a transaction output to the message.
+// AddTxOut adds a transaction output to the message.  The output is added as
+// is, so its public key script is not copied and later modifications to it are
+// reflected in the message.  Use Copy to obtain an independent transaction.
 func (msg *MsgTx) AddTxOut(to *TxOut) {
 	msg.TxOut = append(msg.TxOut, to)
 }
 
-// TxSha generates the ShaHash name for the transaction.
+// RemoveTxIn removes the transaction input at index idx from the message while
+// preserving the order of the remaining inputs.  An error is returned if the
+// index is out of range.
+func (msg *MsgTx) RemoveTxIn(idx int) error {
+	if idx < 0 || idx >= len(msg.TxIn) {
+		return fmt.Errorf("RemoveTxIn: input index %d out of range "+
+			"[inputs %d]", idx, len(msg.TxIn))
+	}
+
+	copy(msg.TxIn[idx:], msg.TxIn[idx+1:])
+	msg.TxIn[len(msg.TxIn)-1] = nil
+	msg.TxIn = msg.TxIn[:len(msg.TxIn)-1]
+	return nil
+}
+
+// RemoveTxOut removes the transaction output at index idx from the message
+// while preserving the order of the remaining outputs.  An error is returned if
+// the index is out of range.
+func (msg *MsgTx) RemoveTxOut(idx int) error {
+	if idx < 0 || idx >= len(msg.TxOut) {
+		return fmt.Errorf("RemoveTxOut: output index %d out of range "+
+			"[outputs %d]", idx, len(msg.TxOut))
+	}
+
+	copy(msg.TxOut[idx:], msg.TxOut[idx+1:])
+	msg.TxOut[len(msg.TxOut)-1] = nil
+	msg.TxOut = msg.TxOut[:len(msg.TxOut)-1]
+	return nil
+}
+
+// IsCoinBase determines whether or not the transaction is a coinbase.  A
+// coinbase is a special transaction created by miners that has no inputs.
+// This is represented in the block chain by a transaction with a single input
+// that has a previous output transaction index set to the maximum value along
+// with a zero hash.
+func (msg *MsgTx) IsCoinBase() bool {
+	if len(msg.TxIn) != 1 {
+		return false
+	}
+
+	prevOut := &msg.TxIn[0].PreviousOutpoint
+	if prevOut.Index != 0xffffffff || prevOut.Hash != (ShaHash{}) {
+		return false
+	}
+
+	return true
+}
+
+// CheckSanity performs cheap structural checks of the transaction which any
+// valid transaction passes: it must have at least one input and one output
+// and its serialization without witness data must not be larger than
+// MaxBlockPayload, so it fits into a block.  No scripts or signatures are
+// checked.  The returned error wraps ErrNoTxInputs, ErrNoTxOutputs, or
+// ErrTxTooBig to describe the problem.
+func (msg *MsgTx) CheckSanity() error {
+	if len(msg.TxIn) == 0 {
+		return fmt.Errorf("CheckSanity: %w", ErrNoTxInputs)
+	}
+	if len(msg.TxOut) == 0 {
+		return fmt.Errorf("CheckSanity: %w", ErrNoTxOutputs)
+	}
+	if size := msg.SerializeSizeStripped(); size > MaxBlockPayload {
+		return fmt.Errorf("CheckSanity: serialized size %d is larger "+
+			"than max %d: %w", size, MaxBlockPayload, ErrTxTooBig)
+	}
+	return nil
+}
+
+// IsCoinbaseMature returns whether or not the outputs of a coinbase
+// transaction included in a block at coinbaseHeight can be spent by a
+// transaction included in a block at spendHeight.  That is the case once the
+// spending block is at least CoinbaseMaturity blocks after the block containing
+// the coinbase, so a coinbase at height 0 is first spendable at height 100.
+// Spends at or below the height of the coinbase are never mature.
+func IsCoinbaseMature(coinbaseHeight, spendHeight int32) bool {
+	if spendHeight <= coinbaseHeight {
+		return false
+	}
+	return int64(spendHeight)-int64(coinbaseHeight) >= CoinbaseMaturity
+}
+
+// TxSha generates the ShaHash name for the transaction.  It is the double
+// sha256 of the legacy serialization (see SerializeNoWitness), so it never
+// commits to witness data and the transaction id is unaffected by changes to
+// the witnesses as required by BIP0141.  Use WTxSha for the witness hash.
 func (tx *MsgTx) TxSha(pver uint32) (ShaHash, error) {
-	// Encode the transaction and calculate double sha256 on the result.
+	// Encode the transaction directly into the hasher to calculate double
+	// sha256 on the result.
 	// Ignore the error returns since the only way the encode could fail
 	// is being out of memory or due to nil pointers, both of which would
-	// cause a run-time panic.  Also, SetBytes can't fail here due to the
-	// fact DoubleSha256 always returns a []byte of the right size
-	// regardless of input.
-	var buf bytes.Buffer
-	var sha ShaHash
-	_ = tx.BtcEncode(&buf, pver)
-	_ = sha.SetBytes(DoubleSha256(buf.Bytes()))
+	// cause a run-time panic.
+	//
+	// The transaction hash never commits to witness data, so always use
+	// the legacy serialization.
+	h := borrowShaHasher()
+	_ = tx.btcEncode(h, pver, false)
+	sha := h.doubleSum()
+	returnShaHasher(h)
 
 	// Even though this function can't currently fail, it still returns
 	// a potential error to help future proof the API should a failure
@@ -104,8 +420,27 @@ func (tx *MsgTx) TxSha(pver uint32) (ShaHash, error) {
 	return sha, nil
 }
 
+// WTxSha generates the witness hash (wtxid) of the transaction as defined by
+// BIP0141.  It is the double sha256 of the witness serialization used by
+// Serialize, so it is the same as the hash returned by TxSha for transactions
+// without witness data.
+func (tx *MsgTx) WTxSha() (ShaHash, error) {
+	// Ignore the error returns since the only way the encode could fail
+	// is being out of memory or due to nil pointers, both of which would
+	// cause a run-time panic.
+	h := borrowShaHasher()
+	_ = tx.Serialize(h)
+	sha := h.doubleSum()
+	returnShaHasher(h)
+
+	// Even though this function can't currently fail, it still returns
+	// a potential error for consistency with TxSha.
+	return sha, nil
+}
+
 // Copy creates a deep copy of a transaction so that the original does not get
-// modified when the copy is manipulated.
+// modified when the copy is manipulated.  Nil and empty scripts and witnesses
+// are kept as they are, so the copy is identical to the original.
 func (tx *MsgTx) Copy() *MsgTx {
 	// Create new tx and start by copying primitive values.
 	newTx := MsgTx{
@@ -118,16 +453,19 @@ func (tx *MsgTx) Copy() *MsgTx {
 		// Deep copy the old previous outpoint.
 		oldOutPoint := oldTxIn.PreviousOutpoint
 		newOutPoint := OutPoint{}
-		newOutPoint.Hash.SetBytes(oldOutPoint.Hash[:])
+		newOutPoint.Hash = oldOutPoint.Hash
 		newOutPoint.Index = oldOutPoint.Index
 
 		// Deep copy the old signature script.
-		var newScript []byte
-		oldScript := oldTxIn.SignatureScript
-		oldScriptLen := len(oldScript)
-		if oldScriptLen > 0 {
-			newScript = make([]byte, oldScriptLen, oldScriptLen)
-			copy(newScript, oldScript[:oldScriptLen])
+		newScript := copyScript(oldTxIn.SignatureScript)
+
+		// Deep copy the old witness.
+		var newWitness TxWitness
+		if oldTxIn.Witness != nil {
+			newWitness = make(TxWitness, len(oldTxIn.Witness))
+			for i, oldItem := range oldTxIn.Witness {
+				newWitness[i] = copyScript(oldItem)
+			}
 		}
 
 		// Create new txIn with the deep copied data and append it to
@@ -135,6 +473,7 @@ func (tx *MsgTx) Copy() *MsgTx {
 		newTxIn := TxIn{
 			PreviousOutpoint: newOutPoint,
 			SignatureScript:  newScript,
+			Witness:          newWitness,
 			Sequence:         oldTxIn.Sequence,
 		}
 		newTx.TxIn = append(newTx.TxIn, &newTxIn)
@@ -143,13 +482,7 @@ func (tx *MsgTx) Copy() *MsgTx {
 	// Deep copy the old TxOut data.
 	for _, oldTxOut := range tx.TxOut {
 		// Deep copy the old PkScript
-		var newScript []byte
-		oldScript := oldTxOut.PkScript
-		oldScriptLen := len(oldScript)
-		if oldScriptLen > 0 {
-			newScript = make([]byte, oldScriptLen, oldScriptLen)
-			copy(newScript, oldScript[:oldScriptLen])
-		}
+		newScript := copyScript(oldTxOut.PkScript)
 
 		// Create new txOut with the deep copied data and append it to
 		// new Tx.
@@ -163,9 +496,60 @@ func (tx *MsgTx) Copy() *MsgTx {
 	return &newTx
 }
 
+// StripWitness removes the witness data from all inputs of the transaction in
+// place, so HasWitness returns false and the transaction serializes with the
+// legacy serialization afterwards.  This is useful when relaying to peers which
+// do not support witness data.  See WithoutWitness to keep the original
+// intact.
+func (tx *MsgTx) StripWitness() {
+	for _, ti := range tx.TxIn {
+		ti.Witness = nil
+	}
+}
+
+// WithoutWitness returns a deep copy of the transaction with the witness data
+// removed from all inputs.  Unlike StripWitness, the original transaction keeps
+// its witness data.
+func (tx *MsgTx) WithoutWitness() *MsgTx {
+	newTx := tx.Copy()
+	newTx.StripWitness()
+	return newTx
+}
+
 // BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
 // This is part of the Message interface implementation.
+//
+// The transaction version is decoded first and passed along to the decoding
+// of the remaining fields so any version specific handling can be applied.
+// Unknown versions are decoded exactly as the current version.
+//
+// Both the legacy serialization and the witness serialization defined by
+// BIP0144 are accepted.  See Serialize for details of the latter.  The witness
+// serialization is rejected with ErrMalformed when none of the inputs have
+// witness data.
 func (msg *MsgTx) BtcDecode(r io.Reader, pver uint32) error {
+	dr, pooled := borrowDecodeReader(r, &defaultDecodeOptions)
+	if pooled {
+		defer returnDecodeReader(dr)
+	}
+	return msg.btcDecode(dr, pver, true)
+}
+
+// btcDecodeBlockTx decodes r like BtcDecode for a transaction which is part of
+// a block, so witness items up to MaxConsensusWitnessItemSize are accepted
+// regardless of the StandardWitnessItems decode option.
+func (msg *MsgTx) btcDecodeBlockTx(r io.Reader, pver uint32) error {
+	dr, pooled := borrowDecodeReader(r, &defaultDecodeOptions)
+	if pooled {
+		defer returnDecodeReader(dr)
+	}
+	return msg.btcDecode(dr, pver, false)
+}
+
+// btcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// The decode context r provides the decode options and scratch buffer.  The
+// StandardWitnessItems decode option is only applied when standard is set.
+func (msg *MsgTx) btcDecode(r *decodeReader, pver uint32, standard bool) error {
 	err := readElement(r, &msg.Version)
 	if err != nil {
 		return err
@@ -176,6 +560,54 @@ func (msg *MsgTx) BtcDecode(r io.Reader, pver uint32) error {
 		return err
 	}
 
+	// A count of zero could either be a legacy transaction with no inputs
+	// or the marker of the witness serialization.  The byte which follows
+	// the marker of a witness transaction is the flag which must be
+	// witnessFlag and is followed by the rest of the transaction, whereas
+	// for a legacy transaction it begins the number of outputs.  Note that
+	// a legacy transaction with no inputs and exactly one output is
+	// indistinguishable from a witness transaction and is decoded as the
+	// latter, matching the reference implementation.
+	var hasWitness bool
+	if count == 0 {
+		var flag uint8
+		err = readElement(r, &flag)
+		if err != nil {
+			return err
+		}
+
+		// The flag byte of a legacy transaction without inputs is the
+		// first byte of the number of outputs, so finish decoding the
+		// outputs and lock time from it.
+		if flag != witnessFlag || !hasRemaining(r) {
+			count, err = readVarIntPayload(r, pver, flag)
+			if err != nil {
+				return err
+			}
+			err = msg.readTxOuts(r, pver, count)
+			if err != nil {
+				return err
+			}
+			return readElement(r, &msg.LockTime)
+		}
+
+		hasWitness = true
+		count, err = readVarInt(r, pver)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Prevent more input transactions than could possibly fit into a
+	// message.  It would be possible to cause memory exhaustion and panics
+	// without a sane upper bound on this count.
+	if count > maxTxInPerMessage {
+		str := fmt.Sprintf("too many input transactions to fit into "+
+			"max message size [count %d, max %d]", count,
+			maxTxInPerMessage)
+		return messageError("MsgTx.BtcDecode", ErrTooManyItems, str)
+	}
+
 	for i := uint64(0); i < count; i++ {
 		ti := TxIn{}
 		err = readTxIn(r, pver, msg.Version, &ti)
@@ -189,14 +621,34 @@ func (msg *MsgTx) BtcDecode(r io.Reader, pver uint32) error {
 	if err != nil {
 		return err
 	}
+	err = msg.readTxOuts(r, pver, count)
+	if err != nil {
+		return err
+	}
 
-	for i := uint64(0); i < count; i++ {
-		to := TxOut{}
-		err = readTxOut(r, pver, msg.Version, &to)
-		if err != nil {
-			return err
+	// The witness serialization has the witness for every input between
+	// the outputs and the lock time.
+	if hasWitness {
+		maxItemSize := uint32(MaxConsensusWitnessItemSize)
+		if standard && r.opts.StandardWitnessItems {
+			maxItemSize = MaxWitnessItemSize
+		}
+		for _, ti := range msg.TxIn {
+			ti.Witness, err = readTxWitness(r, pver, maxItemSize)
+			if err != nil {
+				return err
+			}
+		}
+
+		// BIP0144 only allows the witness serialization when at least
+		// one input has witness data.  Otherwise the same transaction
+		// could be framed in two ways, so reject it like the reference
+		// implementation does.
+		if !msg.HasWitness() {
+			str := "witness serialization used for transaction " +
+				"without witness data"
+			return messageError("MsgTx.BtcDecode", ErrMalformed, str)
 		}
-		msg.TxOut = append(msg.TxOut, &to)
 	}
 
 	err = readElement(r, &msg.LockTime)
@@ -207,15 +659,63 @@ func (msg *MsgTx) BtcDecode(r io.Reader, pver uint32) error {
 	return nil
 }
 
+// readTxOuts reads count transaction outputs from r and appends them to the
+// transaction.
+func (msg *MsgTx) readTxOuts(r io.Reader, pver uint32, count uint64) error {
+	// Prevent more output transactions than could possibly fit into a
+	// message.  It would be possible to cause memory exhaustion and panics
+	// without a sane upper bound on this count.
+	if count > maxTxOutPerMessage {
+		str := fmt.Sprintf("too many output transactions to fit into "+
+			"max message size [count %d, max %d]", count,
+			maxTxOutPerMessage)
+		return messageError("MsgTx.BtcDecode", ErrTooManyItems, str)
+	}
+
+	for i := uint64(0); i < count; i++ {
+		to := TxOut{}
+		err := readTxOut(r, pver, msg.Version, &to)
+		if err != nil {
+			return err
+		}
+		msg.TxOut = append(msg.TxOut, &to)
+	}
+
+	return nil
+}
+
 // BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
 // This is part of the Message interface implementation.
+//
+// The witness serialization defined by BIP0144 is used when any of the inputs
+// have witness data.  See Serialize for details.
 func (msg *MsgTx) BtcEncode(w io.Writer, pver uint32) error {
-	err := writeElement(w, msg.Version)
+	return msg.btcEncode(w, pver, msg.HasWitness())
+}
+
+// btcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// The witness serialization is used when witness is true and the legacy
+// serialization, which omits any witness data, is used otherwise.
+func (msg *MsgTx) btcEncode(w io.Writer, pver uint32, witness bool) error {
+	err := writeElement(w, &msg.Version)
 	if err != nil {
 		return err
 	}
 
+	if witness {
+		err = writeElements(w, witnessMarker, witnessFlag)
+		if err != nil {
+			return err
+		}
+	}
+
 	count := uint64(len(msg.TxIn))
+	if count > maxTxInPerMessage {
+		str := fmt.Sprintf("too many input transactions to fit into "+
+			"max message size [count %d, max %d]", count,
+			maxTxInPerMessage)
+		return messageError("MsgTx.BtcEncode", ErrTooManyItems, str)
+	}
 	err = writeVarInt(w, pver, count)
 	if err != nil {
 		return err
@@ -229,6 +729,12 @@ func (msg *MsgTx) BtcEncode(w io.Writer, pver uint32) error {
 	}
 
 	count = uint64(len(msg.TxOut))
+	if count > maxTxOutPerMessage {
+		str := fmt.Sprintf("too many output transactions to fit into "+
+			"max message size [count %d, max %d]", count,
+			maxTxOutPerMessage)
+		return messageError("MsgTx.BtcEncode", ErrTooManyItems, str)
+	}
 	err = writeVarInt(w, pver, count)
 	if err != nil {
 		return err
@@ -241,7 +747,16 @@ func (msg *MsgTx) BtcEncode(w io.Writer, pver uint32) error {
 		}
 	}
 
-	err = writeElement(w, msg.LockTime)
+	if witness {
+		for _, ti := range msg.TxIn {
+			err = writeTxWitness(w, pver, ti.Witness)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = writeElement(w, &msg.LockTime)
 	if err != nil {
 		return err
 	}
@@ -249,12 +764,170 @@ func (msg *MsgTx) BtcEncode(w io.Writer, pver uint32) error {
 	return nil
 }
 
+// Serialize encodes the transaction to w using the canonical format used for
+// long-term storage such as a database, as opposed to the wire encoding used
+// by BtcEncode which may depend on the protocol version.
+//
+// Serialize always uses the witness serialization defined by BIP0144 when any
+// of the inputs have witness data.  That is the version, a zero marker byte, a
+// one flag byte, the inputs, the outputs, the witness of each input, and the
+// lock time.  Transactions without any witness data use the legacy
+// serialization which is identical except it omits the marker, flag, and
+// witnesses.
+func (msg *MsgTx) Serialize(w io.Writer) error {
+	return msg.btcEncode(w, storageVersion, msg.HasWitness())
+}
+
+// Deserialize decodes a transaction from r into the receiver using the
+// canonical format produced by Serialize.  Both the legacy serialization and
+// the witness serialization are accepted.
+//
+// Since transactions in long-term storage have already been validated, any
+// witness item allowed by consensus is accepted regardless of the decode
+// options carried by r.
+func (msg *MsgTx) Deserialize(r io.Reader) error {
+	return msg.btcDecodeBlockTx(r, storageVersion)
+}
+
+// Bytes returns the transaction serialized with Serialize.  The buffer is
+// sized with SerializeSize up front to avoid reallocating it.
+func (msg *MsgTx) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(msg.SerializeSize())
+	err := msg.Serialize(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SerializeNoWitness encodes the transaction to w using the legacy
+// serialization regardless of whether or not any of the inputs have witness
+// data.  That is the serialization the transaction hash (see TxSha) is computed
+// over and the one understood by peers which predate BIP0144.
+func (msg *MsgTx) SerializeNoWitness(w io.Writer) error {
+	return msg.btcEncode(w, storageVersion, false)
+}
+
+// BytesNoWitness returns the transaction serialized with SerializeNoWitness.
+// The buffer is sized with SerializeSizeStripped up front to avoid
+// reallocating it.
+func (msg *MsgTx) BytesNoWitness() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(msg.SerializeSizeStripped())
+	err := msg.SerializeNoWitness(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SerializeEquals returns whether or not serializing the transaction with
+// Serialize produces exactly the passed raw bytes.  This is useful to ensure a
+// decoded transaction re-encodes to the bytes it was decoded from, since any
+// difference, such as a non-canonical variable length integer which is
+// normalized when encoding, changes the hash of the transaction.
+//
+// On mismatch, the offset of the first byte which differs is returned as well.
+// When one encoding is a prefix of the other, that is the length of the
+// shorter one.  The offset is -1 when the bytes are equal.
+func (msg *MsgTx) SerializeEquals(raw []byte) (bool, int, error) {
+	var buf bytes.Buffer
+	buf.Grow(msg.SerializeSize())
+	err := msg.Serialize(&buf)
+	if err != nil {
+		return false, -1, err
+	}
+
+	offset := firstDiff(buf.Bytes(), raw)
+	return offset == -1, offset, nil
+}
+
+// HasWitness returns whether or not any of the inputs of the transaction have
+// witness data.
+func (msg *MsgTx) HasWitness() bool {
+	for _, ti := range msg.TxIn {
+		if len(ti.Witness) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SerializeSize returns the number of bytes it would take to serialize the
+// transaction with Serialize, including any witness data.  The marker, flag,
+// and witnesses are only counted when HasWitness is true, in which case every
+// input is counted with its witness, including inputs with an empty one.
+func (msg *MsgTx) SerializeSize() int {
+	return msg.serializeSize(msg.HasWitness())
+}
+
+// SerializeSizeStripped returns the number of bytes it would take to serialize
+// the transaction with the legacy serialization which omits any witness data.
+func (msg *MsgTx) SerializeSizeStripped() int {
+	return msg.serializeSize(false)
+}
+
+// Weight returns the weight of the transaction as defined by BIP0141.  That is
+// the serialized size without witness data scaled by WitnessScaleFactor-1 plus
+// the serialized size with witness data.
+func (msg *MsgTx) Weight() int64 {
+	stripped := int64(msg.SerializeSizeStripped())
+	return stripped*(WitnessScaleFactor-1) + int64(msg.SerializeSize())
+}
+
+// VirtualSize returns the virtual size of the transaction as defined by
+// BIP0141, which is its weight divided by WitnessScaleFactor rounded up.  It is
+// the size used when computing fee rates.  The virtual size of a transaction
+// without witness data is the same as its serialized size.
+func (msg *MsgTx) VirtualSize() int64 {
+	return (msg.Weight() + WitnessScaleFactor - 1) / WitnessScaleFactor
+}
+
+// serializeSize returns the number of bytes it would take to serialize the
+// transaction with the witness serialization when witness is true and the
+// legacy serialization otherwise.
+func (msg *MsgTx) serializeSize(witness bool) int {
+	// Version 4 bytes + LockTime 4 bytes + serialized varint size for the
+	// number of transaction inputs and outputs.
+	n := 8 + VarIntSerializeSize(uint64(len(msg.TxIn))) +
+		VarIntSerializeSize(uint64(len(msg.TxOut)))
+
+	for _, ti := range msg.TxIn {
+		n += ti.SerializeSize()
+	}
+
+	for _, to := range msg.TxOut {
+		n += to.SerializeSize()
+	}
+
+	if witness {
+		// Marker 1 byte + flag 1 byte + witness of each input.  Inputs
+		// without witness data are still encoded with an empty witness,
+		// which takes 1 byte for the zero item count.
+		n += 2
+		for _, ti := range msg.TxIn {
+			n += ti.Witness.SerializeSize()
+		}
+	}
+
+	return n
+}
+
 // Command returns the protocol command string for the message.  This is part
 // of the Message interface implementation.
 func (msg *MsgTx) Command() string {
 	return cmdTx
 }
 
+// String returns the message in human-readable form.
+func (msg *MsgTx) String() string {
+	hash, _ := msg.TxSha(ProtocolVersion)
+	return fmt.Sprintf("%s hash=%v in=%d out=%d locktime=%d", msg.Command(),
+		hash, len(msg.TxIn), len(msg.TxOut), msg.LockTime)
+}
+
 // MaxPayloadLength returns the maximum length the payload can be for the
 // receiver.  This is part of the Message interface implementation.
 func (msg *MsgTx) MaxPayloadLength(pver uint32) uint32 {
@@ -270,42 +943,61 @@ func NewMsgTx() *MsgTx {
 	return &MsgTx{Version: TxVersion}
 }
 
-// readOutPoint reads the next sequence of bytes from r as an OutPoint.
-func readOutPoint(r io.Reader, pver uint32, version uint32, op *OutPoint) error {
-	err := readElements(r, &op.Hash, &op.Index)
+// NewMsgTxWithVersion returns a new bitcoin tx message that conforms to the
+// Message interface with the provided version, such as TxVersionSequenceLock
+// for transactions which use BIP0068 relative lock-times.  Like NewMsgTx, the
+// lock time is set to zero and there are no transaction inputs or outputs,
+// although empty lists are allocated for them.
+func NewMsgTxWithVersion(version uint32) *MsgTx {
+	return &MsgTx{
+		Version: version,
+		TxIn:    make([]*TxIn, 0),
+		TxOut:   make([]*TxOut, 0),
+	}
+}
+
+// NewMsgTxFromBytes returns a new bitcoin tx message decoded from the passed
+// bytes which must be in the format produced by MsgTx.Serialize.  The bytes
+// must contain exactly one transaction, so any bytes left over after the
+// transaction are rejected with ErrMalformed to catch corrupt or concatenated
+// data.
+func NewMsgTxFromBytes(serializedTx []byte) (*MsgTx, error) {
+	var msg MsgTx
+	r := bytes.NewReader(serializedTx)
+	err := msg.Deserialize(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	if r.Len() != 0 {
+		str := fmt.Sprintf("%d trailing bytes after serialized "+
+			"transaction", r.Len())
+		return nil, messageError("NewMsgTxFromBytes", ErrMalformed, str)
+	}
+	return &msg, nil
+}
+
+// readOutPoint reads the next sequence of bytes from r as an OutPoint.
+func readOutPoint(r io.Reader, pver uint32, version uint32, op *OutPoint) error {
+	return readElement(r, op)
 }
 
 // writeOutPoint encodes op to the bitcoin protocol encoding for an OutPoint
 // to w.
 func writeOutPoint(w io.Writer, pver uint32, version uint32, op *OutPoint) error {
-	err := writeElements(w, op.Hash, op.Index)
-	if err != nil {
-		return err
-	}
-	return nil
+	return writeElement(w, op)
 }
 
 // readTxIn reads the next sequence of bytes from r as a transaction input
 // (TxIn).
 func readTxIn(r io.Reader, pver uint32, version uint32, ti *TxIn) error {
-	op := OutPoint{}
-	err := readOutPoint(r, pver, version, &op)
-	if err != nil {
-		return err
-	}
-	ti.PreviousOutpoint = op
-
-	count, err := readVarInt(r, pver)
+	err := readOutPoint(r, pver, version, &ti.PreviousOutpoint)
 	if err != nil {
 		return err
 	}
 
-	b := make([]byte, count)
-	err = readElement(r, b)
+	// Prevent signature scripts larger than the max message size.
+	b, err := readVarBytes(r, pver, maxMessagePayload,
+		"transaction input signature script")
 	if err != nil {
 		return err
 	}
@@ -355,13 +1047,9 @@ func readTxOut(r io.Reader, pver uint32, version uint32, to *TxOut) error {
 		return err
 	}
 
-	slen, err := readVarInt(r, pver)
-	if err != nil {
-		return err
-	}
-
-	b := make([]byte, slen)
-	err = readElement(r, b)
+	// Prevent public key scripts larger than the max message size.
+	b, err := readVarBytes(r, pver, maxMessagePayload,
+		"transaction output public key script")
 	if err != nil {
 		return err
 	}
@@ -373,7 +1061,7 @@ func readTxOut(r io.Reader, pver uint32, version uint32, to *TxOut) error {
 // writeTxOut encodes to into the bitcoin protocol encoding for a transaction
 // output (TxOut) to w.
 func writeTxOut(w io.Writer, pver uint32, to *TxOut) error {
-	err := writeElement(w, to.Value)
+	err := writeElement(w, &to.Value)
 	if err != nil {
 		return err
 	}
@@ -384,10 +1072,61 @@ func writeTxOut(w io.Writer, pver uint32, to *TxOut) error {
 		return err
 	}
 
-	err = writeElement(w, to.PkScript)
+	_, err = w.Write(to.PkScript)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// readTxWitness reads the next sequence of bytes from r as the witness of a
+// transaction input (TxWitness).  Each witness item is limited to maxItemSize
+// bytes.
+func readTxWitness(r io.Reader, pver uint32, maxItemSize uint32) (TxWitness, error) {
+	count, err := readVarInt(r, pver)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prevent a witness with more items than could possibly fit in a
+	// block.  It would be possible to cause memory exhaustion and panics
+	// without a sane upper bound on this count.
+	if count > MaxWitnessItemsPerInput {
+		str := fmt.Sprintf("too many witness items to fit into max "+
+			"message size [count %d, max %d]", count,
+			MaxWitnessItemsPerInput)
+		return nil, messageError("readTxWitness", ErrTooManyItems, str)
+	}
+
+	witness := make(TxWitness, 0, preallocCount(count,
+		unsafe.Sizeof([]byte(nil))))
+	for i := uint64(0); i < count; i++ {
+		item, err := readVarBytes(r, pver, maxItemSize,
+			"witness item size")
+		if err != nil {
+			return nil, err
+		}
+		witness = append(witness, item)
+	}
+
+	return witness, nil
+}
+
+// writeTxWitness encodes the witness of a transaction input (TxWitness) to
+// the bitcoin protocol encoding to w.
+func writeTxWitness(w io.Writer, pver uint32, witness TxWitness) error {
+	err := writeVarInt(w, pver, uint64(len(witness)))
+	if err != nil {
+		return err
+	}
+
+	for _, item := range witness {
+		err = writeVarBytes(w, pver, item)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
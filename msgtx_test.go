@@ -6,9 +6,12 @@ package btcwire_test
 
 import (
 	"bytes"
+	"encoding/hex"
+	"errors"
 	"github.com/conformal/btcwire"
 	"github.com/davecgh/go-spew/spew"
 	"io"
+	"math/rand"
 	"reflect"
 	"testing"
 )
@@ -53,6 +56,11 @@ func TestTx(t *testing.T) {
 		t.Errorf("NewOutPoint: wrong index - got %v, want %v",
 			prevOut.Index, prevOutIndex)
 	}
+	prevOutStr := hash.String() + ":1"
+	if s := prevOut.String(); s != prevOutStr {
+		t.Errorf("OutPoint.String: wrong string - got %v, want %v", s,
+			prevOutStr)
+	}
 
 	// Ensure we get the same transaction input back out.
 	sigScript := []byte{0x04, 0x31, 0xdc, 0x00, 0x1b, 0x01, 0x62}
@@ -67,6 +75,17 @@ func TestTx(t *testing.T) {
 			spew.Sdump(txIn.SignatureScript),
 			spew.Sdump(sigScript))
 	}
+	if txIn.Sequence != btcwire.MaxTxInSequenceNum {
+		t.Errorf("NewTxIn: wrong sequence - got %v, want %v",
+			txIn.Sequence, btcwire.MaxTxInSequenceNum)
+	}
+
+	// Ensure the signature script was copied.
+	sigScript[0] ^= 0xff
+	if txIn.SignatureScript[0] == sigScript[0] {
+		t.Errorf("NewTxIn: signature script was not copied")
+	}
+	sigScript[0] ^= 0xff
 
 	// Ensure we get the same transaction output back out.
 	txValue := int64(5000000000)
@@ -95,6 +114,23 @@ func TestTx(t *testing.T) {
 			spew.Sdump(pkScript))
 	}
 
+	// Ensure the public key script was copied.
+	pkScript[0] ^= 0xff
+	if txOut.PkScript[0] == pkScript[0] {
+		t.Errorf("NewTxOut: pk script was not copied")
+	}
+	pkScript[0] ^= 0xff
+
+	// Ensure nil scripts are left nil.
+	if txIn := btcwire.NewTxIn(prevOut, nil); txIn.SignatureScript != nil {
+		t.Errorf("NewTxIn: unexpected signature script for nil - got %v",
+			spew.Sdump(txIn.SignatureScript))
+	}
+	if txOut := btcwire.NewTxOut(0, nil); txOut.PkScript != nil {
+		t.Errorf("NewTxOut: unexpected pk script for nil - got %v",
+			spew.Sdump(txOut.PkScript))
+	}
+
 	// Ensure transaction inputs are added properly.
 	msg.AddTxIn(txIn)
 	if !reflect.DeepEqual(msg.TxIn[0], txIn) {
@@ -119,6 +155,46 @@ func TestTx(t *testing.T) {
 	return
 }
 
+// TestNewMsgTxWithVersion ensures transactions created with a specific version
+// have the expected defaults.
+func TestNewMsgTxWithVersion(t *testing.T) {
+	msg := btcwire.NewMsgTxWithVersion(btcwire.TxVersionSequenceLock)
+	if msg.Version != btcwire.TxVersionSequenceLock {
+		t.Errorf("NewMsgTxWithVersion: wrong version - got %v, want %v",
+			msg.Version, btcwire.TxVersionSequenceLock)
+	}
+	if msg.LockTime != 0 {
+		t.Errorf("NewMsgTxWithVersion: wrong lock time - got %v, "+
+			"want %v", msg.LockTime, 0)
+	}
+	if msg.TxIn == nil || len(msg.TxIn) != 0 {
+		t.Errorf("NewMsgTxWithVersion: wrong inputs - got %v, want "+
+			"empty list", spew.Sdump(msg.TxIn))
+	}
+	if msg.TxOut == nil || len(msg.TxOut) != 0 {
+		t.Errorf("NewMsgTxWithVersion: wrong outputs - got %v, want "+
+			"empty list", spew.Sdump(msg.TxOut))
+	}
+
+	// Ensure the transaction serializes the same as one created with
+	// NewMsgTx and the version set afterwards.
+	want := btcwire.NewMsgTx()
+	want.Version = btcwire.TxVersionSequenceLock
+	var got, wantBuf bytes.Buffer
+	if err := msg.Serialize(&got); err != nil {
+		t.Errorf("Serialize: %v", err)
+		return
+	}
+	if err := want.Serialize(&wantBuf); err != nil {
+		t.Errorf("Serialize: %v", err)
+		return
+	}
+	if !bytes.Equal(got.Bytes(), wantBuf.Bytes()) {
+		t.Errorf("Serialize\n got: %s want: %s", spew.Sdump(got.Bytes()),
+			spew.Sdump(wantBuf.Bytes()))
+	}
+}
+
 func TestTxSha(t *testing.T) {
 	pver := btcwire.ProtocolVersion
 
@@ -171,6 +247,362 @@ func TestTxSha(t *testing.T) {
 	}
 }
 
+// TestTxWTxSha tests the ability to generate the witness hash of a transaction
+// accurately.
+func TestTxWTxSha(t *testing.T) {
+	// Ensure the witness hash of a transaction without witness data is the
+	// same as its hash.
+	txHash, err := multiTx.TxSha(btcwire.ProtocolVersion)
+	if err != nil {
+		t.Errorf("TxSha: %v", err)
+	}
+	wtxHash, err := multiTx.WTxSha()
+	if err != nil {
+		t.Errorf("WTxSha: %v", err)
+	}
+	if wtxHash != txHash {
+		t.Errorf("WTxSha: wrong hash - got %v, want %v", wtxHash,
+			txHash)
+	}
+
+	// Ensure the witness hash of a witness transaction commits to the
+	// witness serialization.
+	wantHash := btcwire.DoubleSha256SH(witnessTxEncoded)
+	wtxHash, err = witnessTx.WTxSha()
+	if err != nil {
+		t.Errorf("WTxSha: %v", err)
+	}
+	if wtxHash != wantHash {
+		t.Errorf("WTxSha: wrong hash - got %v, want %v", wtxHash,
+			wantHash)
+	}
+	txHash, err = witnessTx.TxSha(btcwire.ProtocolVersion)
+	if err != nil {
+		t.Errorf("TxSha: %v", err)
+	}
+	if wtxHash == txHash {
+		t.Errorf("WTxSha: witness hash %v is the same as the hash",
+			wtxHash)
+	}
+}
+
+// TestTxShaWitnessStability ensures the hash of a witness transaction is
+// computed over the legacy serialization and so is unaffected by its witness
+// data, while its witness hash commits to it.
+func TestTxShaWitnessStability(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	// Known transaction id and witness hash of witnessTx.
+	wantTxHash, err := btcwire.NewShaHashFromStr("752beedbdabdad8195e2aac6" +
+		"81ec71eecf04485bc788047d7eb6dd2d994282fb")
+	if err != nil {
+		t.Fatalf("NewShaHashFromStr: %v", err)
+	}
+	wantWTxHash, err := btcwire.NewShaHashFromStr("61c7b2cd609f978e78afd22d" +
+		"e90f8190d76720cfc2c8df2f77a408184a6eacf5")
+	if err != nil {
+		t.Fatalf("NewShaHashFromStr: %v", err)
+	}
+
+	// Ensure the hash is the double sha256 of the legacy serialization.
+	noWitness, err := witnessTx.BytesNoWitness()
+	if err != nil {
+		t.Fatalf("BytesNoWitness: %v", err)
+	}
+	txHash, _ := witnessTx.TxSha(pver)
+	if txHash != btcwire.DoubleSha256SH(noWitness) {
+		t.Errorf("TxSha: hash %v is not over the legacy serialization",
+			txHash)
+	}
+	if txHash != *wantTxHash {
+		t.Errorf("TxSha: wrong hash - got %v, want %v", txHash,
+			wantTxHash)
+	}
+	wtxHash, _ := witnessTx.WTxSha()
+	if wtxHash != *wantWTxHash {
+		t.Errorf("WTxSha: wrong hash - got %v, want %v", wtxHash,
+			wantWTxHash)
+	}
+
+	// Ensure mutating the witness data changes the witness hash but not
+	// the hash.
+	mutations := []func(tx *btcwire.MsgTx){
+		// Change a byte of a witness item.
+		func(tx *btcwire.MsgTx) { tx.TxIn[0].Witness[0][0] ^= 0xff },
+		// Add a witness item.
+		func(tx *btcwire.MsgTx) {
+			tx.TxIn[0].Witness = append(tx.TxIn[0].Witness, []byte{0x01})
+		},
+		// Remove a witness item.
+		func(tx *btcwire.MsgTx) {
+			tx.TxIn[0].Witness = tx.TxIn[0].Witness[:1]
+		},
+	}
+
+	t.Logf("Running %d tests", len(mutations))
+	for i, mutate := range mutations {
+		tx := witnessTx.Copy()
+		mutate(tx)
+
+		gotHash, _ := tx.TxSha(pver)
+		if gotHash != txHash {
+			t.Errorf("TxSha #%d: hash changed with the witness data "+
+				"- got %v, want %v", i, gotHash, txHash)
+			continue
+		}
+		gotWTxHash, _ := tx.WTxSha()
+		if gotWTxHash == wtxHash {
+			t.Errorf("WTxSha #%d: witness hash did not change with "+
+				"the witness data", i)
+			continue
+		}
+	}
+}
+
+// TestTxIsCoinBase tests the MsgTx IsCoinBase function for various
+// transactions.
+func TestTxIsCoinBase(t *testing.T) {
+	coinbasePrevOut := btcwire.NewOutPoint(&btcwire.ShaHash{}, 0xffffffff)
+	coinbase := btcwire.NewMsgTx()
+	coinbase.AddTxIn(btcwire.NewTxIn(coinbasePrevOut, nil))
+
+	// Non-zero previous output hash.
+	badHash := btcwire.NewMsgTx()
+	badHash.AddTxIn(btcwire.NewTxIn(btcwire.NewOutPoint(
+		&btcwire.ShaHash{0x01}, 0xffffffff), nil))
+
+	// Previous output index not set to the maximum value.
+	badIndex := btcwire.NewMsgTx()
+	badIndex.AddTxIn(btcwire.NewTxIn(btcwire.NewOutPoint(
+		&btcwire.ShaHash{}, 0), nil))
+
+	// Multiple inputs.
+	multiIn := btcwire.NewMsgTx()
+	multiIn.AddTxIn(btcwire.NewTxIn(coinbasePrevOut, nil))
+	multiIn.AddTxIn(btcwire.NewTxIn(coinbasePrevOut, nil))
+
+	tests := []struct {
+		tx   *btcwire.MsgTx // Transaction to test
+		want bool           // Expected result
+	}{
+		{coinbase, true},
+		{blockOne.Transactions[0], true},
+		{btcwire.NewMsgTx(), false},
+		{badHash, false},
+		{badIndex, false},
+		{multiIn, false},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		if got := test.tx.IsCoinBase(); got != test.want {
+			t.Errorf("IsCoinBase #%d: got %v, want %v", i, got,
+				test.want)
+			continue
+		}
+	}
+}
+
+// TestTxCheckSanity tests the MsgTx CheckSanity function detects
+// structurally invalid transactions.
+func TestTxCheckSanity(t *testing.T) {
+	noInputs := btcwire.NewMsgTx()
+	noInputs.AddTxOut(btcwire.NewTxOut(0, []byte{0x51}))
+
+	noOutputs := btcwire.NewMsgTx()
+	noOutputs.AddTxIn(btcwire.NewTxIn(&btcwire.OutPoint{}, nil))
+
+	// Transaction with a signature script which makes it just too big to
+	// fit into a block.
+	tooBig := multiTx.Copy()
+	tooBig.TxIn[0].SignatureScript = make([]byte,
+		btcwire.MaxBlockPayload-multiTx.SerializeSizeStripped()+
+			len(multiTx.TxIn[0].SignatureScript)+1)
+
+	tests := []struct {
+		tx  *btcwire.MsgTx // Transaction to check
+		err error          // Expected error
+	}{
+		{multiTx, nil},
+		{witnessTx, nil},
+		{btcwire.NewMsgTx(), btcwire.ErrNoTxInputs},
+		{noInputs, btcwire.ErrNoTxInputs},
+		{noOutputs, btcwire.ErrNoTxOutputs},
+		{tooBig, btcwire.ErrTxTooBig},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		err := test.tx.CheckSanity()
+		if test.err == nil {
+			if err != nil {
+				t.Errorf("CheckSanity #%d unexpected error: %v", i,
+					err)
+			}
+			continue
+		}
+		if !errors.Is(err, test.err) {
+			t.Errorf("CheckSanity #%d wrong error - got %v, want %v",
+				i, err, test.err)
+			continue
+		}
+	}
+}
+
+// TestIsCoinbaseMature tests the IsCoinbaseMature function at and around the
+// maturity boundary.
+func TestIsCoinbaseMature(t *testing.T) {
+	tests := []struct {
+		coinbaseHeight int32 // Height of the block containing the coinbase
+		spendHeight    int32 // Height of the spending block
+		want           bool  // Expected result
+	}{
+		{0, 99, false},
+		{0, 100, true},
+		{1000, 1099, false},
+		{1000, 1100, true},
+		{1000, 5000, true},
+		// Spends at or before the coinbase.
+		{1000, 1000, false},
+		{1000, 0, false},
+		// Extreme heights.
+		{-0x80000000, 0x7fffffff, true},
+		{0x7fffffff, -0x80000000, false},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		got := btcwire.IsCoinbaseMature(test.coinbaseHeight,
+			test.spendHeight)
+		if got != test.want {
+			t.Errorf("IsCoinbaseMature #%d (%d, %d): got %v, want %v",
+				i, test.coinbaseHeight, test.spendHeight, got,
+				test.want)
+			continue
+		}
+	}
+}
+
+// TestTxRemove tests the MsgTx RemoveTxIn and RemoveTxOut functions.
+func TestTxRemove(t *testing.T) {
+	txIns := []*btcwire.TxIn{
+		btcwire.NewTxIn(btcwire.NewOutPoint(&btcwire.ShaHash{0x01}, 0), nil),
+		btcwire.NewTxIn(btcwire.NewOutPoint(&btcwire.ShaHash{0x02}, 1), nil),
+		btcwire.NewTxIn(btcwire.NewOutPoint(&btcwire.ShaHash{0x03}, 2), nil),
+	}
+	txOuts := []*btcwire.TxOut{
+		btcwire.NewTxOut(1000, []byte{0x51}),
+		btcwire.NewTxOut(2000, []byte{0x52}),
+		btcwire.NewTxOut(3000, []byte{0x53}),
+	}
+
+	tests := []struct {
+		idx       int              // Index to remove
+		wantIns   []*btcwire.TxIn  // Expected inputs after removal
+		wantOuts  []*btcwire.TxOut // Expected outputs after removal
+		wantError bool             // Whether an error is expected
+	}{
+		{0, txIns[1:], txOuts[1:], false},
+		{1, []*btcwire.TxIn{txIns[0], txIns[2]},
+			[]*btcwire.TxOut{txOuts[0], txOuts[2]}, false},
+		{2, txIns[:2], txOuts[:2], false},
+		{-1, txIns, txOuts, true},
+		{3, txIns, txOuts, true},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		msg := btcwire.NewMsgTx()
+		for j := range txIns {
+			msg.AddTxIn(txIns[j])
+			msg.AddTxOut(txOuts[j])
+		}
+
+		err := msg.RemoveTxIn(test.idx)
+		if (err != nil) != test.wantError {
+			t.Errorf("RemoveTxIn #%d: unexpected error - got %v, "+
+				"want error %v", i, err, test.wantError)
+			continue
+		}
+		if !reflect.DeepEqual(msg.TxIn, test.wantIns) {
+			t.Errorf("RemoveTxIn #%d: wrong inputs - got %v, want %v",
+				i, spew.Sdump(msg.TxIn), spew.Sdump(test.wantIns))
+			continue
+		}
+
+		err = msg.RemoveTxOut(test.idx)
+		if (err != nil) != test.wantError {
+			t.Errorf("RemoveTxOut #%d: unexpected error - got %v, "+
+				"want error %v", i, err, test.wantError)
+			continue
+		}
+		if !reflect.DeepEqual(msg.TxOut, test.wantOuts) {
+			t.Errorf("RemoveTxOut #%d: wrong outputs - got %v, want %v",
+				i, spew.Sdump(msg.TxOut), spew.Sdump(test.wantOuts))
+			continue
+		}
+	}
+
+	// Ensure removing from an empty transaction returns an error.
+	msg := btcwire.NewMsgTx()
+	if err := msg.RemoveTxIn(0); err == nil {
+		t.Errorf("RemoveTxIn: expected error on empty transaction")
+	}
+	if err := msg.RemoveTxOut(0); err == nil {
+		t.Errorf("RemoveTxOut: expected error on empty transaction")
+	}
+}
+
+// TestTxInRelativeLockTime tests the TxIn RelativeLockTime function for
+// various sequence numbers.
+func TestTxInRelativeLockTime(t *testing.T) {
+	tests := []struct {
+		sequence  uint32 // Sequence number of the input
+		value     uint32 // Expected relative lock-time
+		isSeconds bool   // Expected seconds flag
+		enabled   bool   // Expected enabled flag
+	}{
+		// Default sequence number disables relative lock-time.
+		{btcwire.MaxTxInSequenceNum, 0, false, false},
+		// Disable flag wins even with a lock-time set.
+		{btcwire.SequenceLockTimeDisabled | 10, 0, false, false},
+		{0, 0, false, true},
+		// 10 blocks.
+		{10, 10, false, true},
+		// Maximum number of blocks.
+		{0xffff, 0xffff, false, true},
+		// 10 512-second intervals.
+		{btcwire.SequenceLockTimeIsSeconds | 10, 10, true, true},
+		// Bits outside of the mask and flags are ignored.
+		{0x7f000000 | btcwire.SequenceLockTimeIsSeconds | 0x0123, 0x0123,
+			true, true},
+		{0x00010000 | 0x0040, 0x0040, false, true},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		ti := btcwire.TxIn{Sequence: test.sequence}
+		value, isSeconds, enabled := ti.RelativeLockTime()
+		if value != test.value || isSeconds != test.isSeconds ||
+			enabled != test.enabled {
+
+			t.Errorf("RelativeLockTime #%d (sequence 0x%08x): got "+
+				"(%d, %v, %v), want (%d, %v, %v)", i,
+				test.sequence, value, isSeconds, enabled,
+				test.value, test.isSeconds, test.enabled)
+			continue
+		}
+	}
+
+	// Ensure the granularity converts intervals to seconds.
+	ti := btcwire.TxIn{Sequence: btcwire.SequenceLockTimeIsSeconds | 2}
+	value, _, _ := ti.RelativeLockTime()
+	if secs := value << btcwire.SequenceLockTimeGranularity; secs != 1024 {
+		t.Errorf("RelativeLockTime: wrong number of seconds - got %d, "+
+			"want %d", secs, 1024)
+	}
+}
+
 // TestTxWire tests the MsgTx wire encode and decode for various numbers
 // of transaction inputs and outputs and protocol versions.
 func TestTxWire(t *testing.T) {
@@ -302,78 +734,1092 @@ func TestTxWire(t *testing.T) {
 	}
 }
 
-// TestTxWireErrors performs negative tests against wire encode and decode
-// of MsgTx to confirm error paths work correctly.
-func TestTxWireErrors(t *testing.T) {
-	// Use protocol version 60002 specifically here instead of the latest
-	// because the test data is using bytes encoded with that protocol
-	// version.
-	pver := uint32(60002)
+// TestTxVersions ensures transactions with known and unknown versions are
+// encoded and decoded byte for byte.
+func TestTxVersions(t *testing.T) {
+	pver := btcwire.ProtocolVersion
 
 	tests := []struct {
-		in       *btcwire.MsgTx // Value to encode
-		buf      []byte         // Wire encoding
-		pver     uint32         // Protocol version for wire encoding
-		max      int            // Max size of fixed buffer to induce errors
-		writeErr error          // Expected write error
-		readErr  error          // Expected read error
+		version uint32 // Transaction version
+		buf     []byte // Wire encoding
 	}{
-		// Force error in version.
-		{multiTx, multiTxEncoded, pver, 0, io.ErrShortWrite, io.EOF},
-		// Force error in number of transaction inputs.
-		{multiTx, multiTxEncoded, pver, 4, io.ErrShortWrite, io.EOF},
-		// Force error in transaction input previous block hash.
-		{multiTx, multiTxEncoded, pver, 5, io.ErrShortWrite, io.EOF},
-		// Force error in transaction input previous block hash.
-		{multiTx, multiTxEncoded, pver, 5, io.ErrShortWrite, io.EOF},
-		// Force error in transaction input previous block output index.
-		{multiTx, multiTxEncoded, pver, 37, io.ErrShortWrite, io.EOF},
-		// Force error in transaction input signature script length.
-		{multiTx, multiTxEncoded, pver, 41, io.ErrShortWrite, io.EOF},
-		// Force error in transaction input signature script.
-		{multiTx, multiTxEncoded, pver, 42, io.ErrShortWrite, io.EOF},
-		// Force error in transaction input sequence.
-		{multiTx, multiTxEncoded, pver, 49, io.ErrShortWrite, io.EOF},
-		// Force error in number of transaction outputs.
-		{multiTx, multiTxEncoded, pver, 53, io.ErrShortWrite, io.EOF},
-		// Force error in transaction output value.
-		{multiTx, multiTxEncoded, pver, 54, io.ErrShortWrite, io.EOF},
-		// Force error in transaction output pk script length.
-		{multiTx, multiTxEncoded, pver, 62, io.ErrShortWrite, io.EOF},
-		// Force error in transaction output pk script.
-		{multiTx, multiTxEncoded, pver, 63, io.ErrShortWrite, io.EOF},
-		// Force error in transaction output lock time.
-		{multiTx, multiTxEncoded, pver, 130, io.ErrShortWrite, io.EOF},
+		// Version 1.
+		{
+			btcwire.TxVersionInitial,
+			[]byte{
+				0x01, 0x00, 0x00, 0x00, // Version
+				0x00,                   // Varint for number of inputs
+				0x00,                   // Varint for number of outputs
+				0x00, 0x00, 0x00, 0x00, // Lock time
+			},
+		},
+
+		// Version 2 (BIP0068).
+		{
+			btcwire.TxVersionSequenceLock,
+			[]byte{
+				0x02, 0x00, 0x00, 0x00, // Version
+				0x00,                   // Varint for number of inputs
+				0x00,                   // Varint for number of outputs
+				0x00, 0x00, 0x00, 0x00, // Lock time
+			},
+		},
+
+		// Unknown version 0.
+		{
+			0,
+			[]byte{
+				0x00, 0x00, 0x00, 0x00, // Version
+				0x00,                   // Varint for number of inputs
+				0x00,                   // Varint for number of outputs
+				0x00, 0x00, 0x00, 0x00, // Lock time
+			},
+		},
+
+		// Unknown max version.
+		{
+			0xffffffff,
+			[]byte{
+				0xff, 0xff, 0xff, 0xff, // Version
+				0x00,                   // Varint for number of inputs
+				0x00,                   // Varint for number of outputs
+				0x00, 0x00, 0x00, 0x00, // Lock time
+			},
+		},
 	}
 
 	t.Logf("Running %d tests", len(tests))
 	for i, test := range tests {
-		// Encode to wire format.
-		w := newFixedWriter(test.max)
-		err := test.in.BtcEncode(w, test.pver)
-		if err != test.writeErr {
-			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
-				i, err, test.writeErr)
+		// Decode the message from wire format.
+		var msg btcwire.MsgTx
+		rbuf := bytes.NewBuffer(test.buf)
+		err := msg.BtcDecode(rbuf, pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if msg.Version != test.version {
+			t.Errorf("BtcDecode #%d wrong version - got %v, want %v",
+				i, msg.Version, test.version)
 			continue
 		}
 
-		// Decode from wire format.
+		// Ensure the re-encoded message is identical.
+		var buf bytes.Buffer
+		err = msg.BtcEncode(&buf, pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+	}
+}
+
+// TestTxWireGenerated tests the MsgTx wire encode and decode round trip for a
+// large number of reproducible pseudo-random transactions.
+func TestTxWireGenerated(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	rng := rand.New(rand.NewSource(0x6274637769726531))
+
+	numTests := 2000
+	t.Logf("Running %d tests", numTests)
+	for i := 0; i < numTests; i++ {
+		tx := GenTx(rng)
+
+		// Encode the transaction to wire format.
+		var buf bytes.Buffer
+		err := tx.BtcEncode(&buf, pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		encoded := buf.Bytes()
+
+		// Decode the transaction from wire format.
 		var msg btcwire.MsgTx
-		r := newFixedReader(test.max, test.buf)
-		err = msg.BtcDecode(r, test.pver)
-		if err != test.readErr {
-			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
-				i, err, test.readErr)
+		rbuf := bytes.NewBuffer(encoded)
+		err = msg.BtcDecode(rbuf, pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if rbuf.Len() != 0 {
+			t.Errorf("BtcDecode #%d left %d bytes unread", i,
+				rbuf.Len())
+			continue
+		}
+		if !reflect.DeepEqual(&msg, tx) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(tx))
+			continue
+		}
+
+		// Ensure the hash is unchanged by the round trip.
+		wantHash, _ := tx.TxSha(pver)
+		gotHash, _ := msg.TxSha(pver)
+		if !gotHash.IsEqual(&wantHash) {
+			t.Errorf("TxSha #%d: wrong hash - got %v, want %v", i,
+				gotHash, wantHash)
 			continue
 		}
 	}
 }
 
-// multiTx is a MsgTx with an input and output and used in various tests.
-var multiTx *btcwire.MsgTx = &btcwire.MsgTx{
-	Version: 1,
-	TxIn: []*btcwire.TxIn{
-		&btcwire.TxIn{
+// TestTxSerialize tests the MsgTx Serialize and Deserialize functions for both
+// legacy and witness transactions.
+func TestTxSerialize(t *testing.T) {
+	noTx := btcwire.NewMsgTx()
+	noTx.Version = 1
+	noTxEncoded := []byte{
+		0x01, 0x00, 0x00, 0x00, // Version
+		0x00,                   // Varint for number of input transactions
+		0x00,                   // Varint for number of output transactions
+		0x00, 0x00, 0x00, 0x00, // Lock time
+	}
+
+	tests := []struct {
+		in       *btcwire.MsgTx // Transaction to serialize
+		out      *btcwire.MsgTx // Expected deserialized transaction
+		buf      []byte         // Serialized data
+		stripped int            // Expected size without witness data
+	}{
+		// No transactions.
+		{noTx, noTx, noTxEncoded, len(noTxEncoded)},
+
+		// Legacy transaction.
+		{multiTx, multiTx, multiTxEncoded, len(multiTxEncoded)},
+
+		// Witness transaction.  The marker, flag, and witness take 9
+		// bytes.
+		{witnessTx, witnessTx, witnessTxEncoded, len(witnessTxEncoded) - 9},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Serialize the transaction.
+		var buf bytes.Buffer
+		err := test.in.Serialize(&buf)
+		if err != nil {
+			t.Errorf("Serialize #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("Serialize #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		serialized, err := test.in.Bytes()
+		if err != nil {
+			t.Errorf("Bytes #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(serialized, test.buf) {
+			t.Errorf("Bytes #%d\n got: %s want: %s", i,
+				spew.Sdump(serialized), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Ensure the serialized sizes are calculated correctly.
+		if size := test.in.SerializeSize(); size != len(test.buf) {
+			t.Errorf("SerializeSize #%d: wrong size - got %d, "+
+				"want %d", i, size, len(test.buf))
+			continue
+		}
+		size := test.in.SerializeSizeStripped()
+		if size != test.stripped {
+			t.Errorf("SerializeSizeStripped #%d: wrong size - got "+
+				"%d, want %d", i, size, test.stripped)
+			continue
+		}
+
+		// Ensure BtcEncode produces the same bytes.
+		buf.Reset()
+		err = test.in.BtcEncode(&buf, btcwire.ProtocolVersion)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Deserialize the transaction.
+		var tx btcwire.MsgTx
+		rbuf := bytes.NewReader(test.buf)
+		err = tx.Deserialize(rbuf)
+		if err != nil {
+			t.Errorf("Deserialize #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&tx, test.out) {
+			t.Errorf("Deserialize #%d\n got: %s want: %s", i,
+				spew.Sdump(&tx), spew.Sdump(test.out))
+			continue
+		}
+
+		newTx, err := btcwire.NewMsgTxFromBytes(test.buf)
+		if err != nil {
+			t.Errorf("NewMsgTxFromBytes #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(newTx, test.out) {
+			t.Errorf("NewMsgTxFromBytes #%d\n got: %s want: %s", i,
+				spew.Sdump(newTx), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestTxSerializeNoWitness tests the MsgTx SerializeNoWitness and
+// BytesNoWitness functions always produce the legacy serialization.
+func TestTxSerializeNoWitness(t *testing.T) {
+	// The legacy serialization of witnessTx omits the marker, flag, and
+	// witness.
+	n := len(witnessTxEncoded)
+	witnessTxLegacy := joinBytes(witnessTxEncoded[:4],
+		witnessTxEncoded[6:59], witnessTxEncoded[n-4:])
+
+	tests := []struct {
+		in  *btcwire.MsgTx // Transaction to serialize
+		buf []byte         // Expected legacy serialization
+	}{
+		{multiTx, multiTxEncoded},
+		{witnessTx, witnessTxLegacy},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		var buf bytes.Buffer
+		err := test.in.SerializeNoWitness(&buf)
+		if err != nil {
+			t.Errorf("SerializeNoWitness #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("SerializeNoWitness #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		serialized, err := test.in.BytesNoWitness()
+		if err != nil {
+			t.Errorf("BytesNoWitness #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(serialized, test.buf) {
+			t.Errorf("BytesNoWitness #%d\n got: %s want: %s", i,
+				spew.Sdump(serialized), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Ensure the legacy serialization is what the transaction hash
+		// commits to.
+		hash, err := test.in.TxSha(btcwire.ProtocolVersion)
+		if err != nil {
+			t.Errorf("TxSha #%d error %v", i, err)
+			continue
+		}
+		if want := btcwire.DoubleSha256SH(test.buf); hash != want {
+			t.Errorf("TxSha #%d wrong hash - got %v, want %v", i,
+				hash, want)
+			continue
+		}
+	}
+}
+
+// TestTxStripWitness tests the MsgTx StripWitness and WithoutWitness functions
+// remove witness data as expected.
+func TestTxStripWitness(t *testing.T) {
+	wantBytes, err := witnessTx.BytesNoWitness()
+	if err != nil {
+		t.Errorf("BytesNoWitness: %v", err)
+		return
+	}
+
+	// Ensure the copy is stripped while the original is left intact.
+	stripped := witnessTx.WithoutWitness()
+	if stripped.HasWitness() {
+		t.Errorf("WithoutWitness: copy still has witness data")
+	}
+	if !witnessTx.HasWitness() || len(witnessTx.TxIn[0].Witness) != 2 {
+		t.Errorf("WithoutWitness: original witness data was modified - "+
+			"got %v", spew.Sdump(witnessTx.TxIn[0].Witness))
+	}
+	serialized, err := stripped.Bytes()
+	if err != nil {
+		t.Errorf("Bytes: %v", err)
+		return
+	}
+	if !bytes.Equal(serialized, wantBytes) {
+		t.Errorf("WithoutWitness\n got: %s want: %s",
+			spew.Sdump(serialized), spew.Sdump(wantBytes))
+	}
+
+	// Ensure stripping in place removes the witness data and leaves the
+	// transaction hash unchanged.
+	tx := witnessTx.Copy()
+	tx.StripWitness()
+	if tx.HasWitness() {
+		t.Errorf("StripWitness: transaction still has witness data")
+	}
+	if !reflect.DeepEqual(tx, stripped) {
+		t.Errorf("StripWitness\n got: %s want: %s", spew.Sdump(tx),
+			spew.Sdump(stripped))
+	}
+	hash, _ := tx.TxSha(btcwire.ProtocolVersion)
+	wantHash, _ := witnessTx.TxSha(btcwire.ProtocolVersion)
+	if hash != wantHash {
+		t.Errorf("StripWitness: wrong hash - got %v, want %v", hash,
+			wantHash)
+	}
+	wtxid, _ := tx.WTxSha()
+	if wtxid != wantHash {
+		t.Errorf("StripWitness: wrong witness hash - got %v, want %v",
+			wtxid, wantHash)
+	}
+}
+
+// TestFromBytesErrors performs negative tests against NewMsgTxFromBytes and
+// NewMsgBlockFromBytes to confirm truncated data and data with trailing bytes
+// are rejected.
+func TestFromBytesErrors(t *testing.T) {
+	withTrailing := func(b []byte) []byte {
+		return append(append([]byte{}, b...), 0x00)
+	}
+
+	tests := []struct {
+		name    string                            // Parser under test
+		parse   func([]byte) (interface{}, error) // Parser to run
+		buf     []byte                            // Serialized data
+		readErr error                             // Expected read error
+	}{
+		{"tx", parseTx, multiTxEncoded[:4], io.EOF},
+		{"tx", parseTx, multiTxEncoded[:10], io.ErrUnexpectedEOF},
+		{"tx", parseTx, withTrailing(multiTxEncoded), nil},
+		{"tx", parseTx, withTrailing(witnessTxEncoded), nil},
+		{"block", parseBlock, blockOneBytes[:80], io.EOF},
+		{"block", parseBlock, withTrailing(blockOneBytes), nil},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		_, err := test.parse(test.buf)
+		if test.readErr != nil {
+			if err != test.readErr {
+				t.Errorf("%s #%d wrong error got: %v, want: %v",
+					test.name, i, err, test.readErr)
+			}
+			continue
+		}
+
+		// Trailing bytes are reported as malformed.
+		if msgErr, ok := err.(*btcwire.MessageError); !ok ||
+			msgErr.Code != btcwire.ErrMalformed {
+
+			t.Errorf("%s #%d wrong error got: %v, want: %v",
+				test.name, i, err, btcwire.ErrMalformed)
+			continue
+		}
+	}
+}
+
+// parseTx and parseBlock adapt the FromBytes parsers to a common signature.
+func parseTx(b []byte) (interface{}, error)    { return btcwire.NewMsgTxFromBytes(b) }
+func parseBlock(b []byte) (interface{}, error) { return btcwire.NewMsgBlockFromBytes(b) }
+
+// TestTxSerializeSize ensures the serialized sizes of transactions exactly
+// match the number of bytes written by the encoder, including transactions
+// which mix inputs with and without witness data.
+func TestTxSerializeSize(t *testing.T) {
+	// newTxIn returns a transaction input with the passed signature script
+	// and witness.
+	newTxIn := func(sigScript []byte, witness btcwire.TxWitness) *btcwire.TxIn {
+		return &btcwire.TxIn{
+			PreviousOutpoint: btcwire.OutPoint{Index: 1},
+			SignatureScript:  sigScript,
+			Witness:          witness,
+			Sequence:         btcwire.MaxTxInSequenceNum,
+		}
+	}
+	txOut := btcwire.NewTxOut(1000, []byte{0x51})
+
+	// mixedTx spends a legacy input between two witness inputs, one of
+	// which has an empty witness item and one of which has an item large
+	// enough to need a 3 byte varint for its length.
+	mixedTx := btcwire.NewMsgTx()
+	mixedTx.AddTxIn(newTxIn(nil, btcwire.TxWitness{{}, {0x01}}))
+	mixedTx.AddTxIn(newTxIn(make([]byte, 107), nil))
+	mixedTx.AddTxIn(newTxIn(nil, btcwire.TxWitness{make([]byte, 253)}))
+	mixedTx.AddTxOut(txOut)
+
+	// emptyWitnessTx only has inputs with empty witnesses, so it uses the
+	// legacy serialization.
+	emptyWitnessTx := btcwire.NewMsgTx()
+	emptyWitnessTx.AddTxIn(newTxIn([]byte{0x00}, btcwire.TxWitness{}))
+	emptyWitnessTx.AddTxIn(newTxIn([]byte{0x00}, nil))
+	emptyWitnessTx.AddTxOut(txOut)
+
+	tests := []struct {
+		in       *btcwire.MsgTx // Transaction to size
+		size     int            // Expected serialized size
+		stripped int            // Expected serialized size without witnesses
+	}{
+		// No inputs or outputs.
+		{btcwire.NewMsgTx(), 10, 10},
+
+		// Legacy transaction.
+		{multiTx, len(multiTxEncoded), len(multiTxEncoded)},
+
+		// Witness transaction.
+		{witnessTx, len(witnessTxEncoded), len(witnessTxEncoded) - 9},
+
+		// Mixed transaction.  The marker and flag take 2 bytes and the
+		// witnesses take 1 + (1 + 0) + (1 + 1) bytes for the first
+		// input, 1 byte for the legacy input, and 1 + (3 + 253) bytes
+		// for the last input.
+		{mixedTx, 514, 250},
+
+		// Transaction with only empty witnesses.
+		{emptyWitnessTx, 104, 104},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		if size := test.in.SerializeSize(); size != test.size {
+			t.Errorf("SerializeSize #%d: wrong size - got %d, "+
+				"want %d", i, size, test.size)
+			continue
+		}
+		size := test.in.SerializeSizeStripped()
+		if size != test.stripped {
+			t.Errorf("SerializeSizeStripped #%d: wrong size - got "+
+				"%d, want %d", i, size, test.stripped)
+			continue
+		}
+
+		// Ensure the sizes of the individual inputs, outputs, and
+		// witnesses add up to the size of the transaction.
+		numIn, numOut := len(test.in.TxIn), len(test.in.TxOut)
+		parts := 8 + btcwire.VarIntSerializeSize(uint64(numIn)) +
+			btcwire.VarIntSerializeSize(uint64(numOut))
+		witnesses := 0
+		for _, ti := range test.in.TxIn {
+			parts += ti.SerializeSize()
+			witnesses += ti.Witness.SerializeSize()
+		}
+		for _, to := range test.in.TxOut {
+			parts += to.SerializeSize()
+		}
+		if parts != test.stripped {
+			t.Errorf("SerializeSize #%d: inputs and outputs take %d "+
+				"bytes, want %d", i, parts, test.stripped)
+			continue
+		}
+		if test.in.HasWitness() && parts+2+witnesses != test.size {
+			t.Errorf("SerializeSize #%d: witnesses take %d bytes, "+
+				"want %d", i, witnesses, test.size-parts-2)
+			continue
+		}
+
+		// Ensure the sizes match the number of bytes actually written
+		// by the encoder with and without the witnesses.
+		var w countingWriter
+		err := test.in.Serialize(&w)
+		if err != nil {
+			t.Errorf("Serialize #%d error %v", i, err)
+			continue
+		}
+		if w.n != test.size {
+			t.Errorf("Serialize #%d: wrote %d bytes, want %d", i,
+				w.n, test.size)
+			continue
+		}
+		w = countingWriter{}
+		err = test.in.BtcEncode(&w, btcwire.ProtocolVersion)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if w.n != test.size {
+			t.Errorf("BtcEncode #%d: wrote %d bytes, want %d", i,
+				w.n, test.size)
+			continue
+		}
+
+		stripped := test.in.Copy()
+		for _, ti := range stripped.TxIn {
+			ti.Witness = nil
+		}
+		w = countingWriter{}
+		err = stripped.Serialize(&w)
+		if err != nil {
+			t.Errorf("Serialize #%d error %v", i, err)
+			continue
+		}
+		if w.n != test.stripped {
+			t.Errorf("Serialize #%d: wrote %d stripped bytes, want "+
+				"%d", i, w.n, test.stripped)
+			continue
+		}
+	}
+}
+
+// TestTxWeight tests the MsgTx Weight and VirtualSize functions for both
+// legacy and witness transactions.
+func TestTxWeight(t *testing.T) {
+	// Signed native pay-to-witness-pubkey-hash example transaction from
+	// BIP0143 which spends a legacy input and a witness input.
+	p2wpkhSpend, _ := hex.DecodeString("01000000000102fff7f7881a8099afa6" +
+		"940d42d1e7f6362bec38171ea3edf433541db4e4ad969f0000000049483045" +
+		"0221008b9d1dc26ba6a9cb62127b02742fa9d754cd3bebf337f7a55d114c8e" +
+		"5cdd30be022040529b194ba3f9281a99f2b1c0a19c0489bc22ede944ccf4ec" +
+		"bab4cc618ef3ed01eeffffffef51e1b804cc89d182d279655c3aa89e815b1b" +
+		"309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb20600000000" +
+		"1976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d00" +
+		"0000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac0002" +
+		"47304402203609e17b84f6a7d30c80bfa610b5b4542f32a8a0d5447a12fb13" +
+		"66d7f01cc44a0220573a954c4518331561406f90300e8f3358f51928d43c21" +
+		"2a8caed02de67eebee0121025476c2e83188368da1ff3e292e7acafcdb3566" +
+		"bb0ad253f62fc70f07aeee635711000000")
+	var p2wpkhTx btcwire.MsgTx
+	err := p2wpkhTx.Deserialize(bytes.NewReader(p2wpkhSpend))
+	if err != nil {
+		t.Errorf("Deserialize error %v", err)
+		return
+	}
+
+	tests := []struct {
+		tx     *btcwire.MsgTx // Transaction to weigh
+		weight int64          // Expected weight
+		vsize  int64          // Expected virtual size
+	}{
+		// Witness transaction with a 343 byte serialization of which 233
+		// bytes are not witness data.  The virtual size is rounded up.
+		{&p2wpkhTx, 1042, 261},
+
+		// Legacy transactions have a virtual size equal to their
+		// serialized size.
+		{multiTx, int64(4 * len(multiTxEncoded)), int64(len(multiTxEncoded))},
+		{blockOne.Transactions[0], 4 * 134, 134},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		if weight := test.tx.Weight(); weight != test.weight {
+			t.Errorf("Weight #%d: got %d, want %d", i, weight,
+				test.weight)
+			continue
+		}
+		if vsize := test.tx.VirtualSize(); vsize != test.vsize {
+			t.Errorf("VirtualSize #%d: got %d, want %d", i, vsize,
+				test.vsize)
+			continue
+		}
+	}
+}
+
+// TestTxSerializeEquals tests the MsgTx SerializeEquals function for both
+// matching and mismatched bytes.
+func TestTxSerializeEquals(t *testing.T) {
+	// Transaction encoded with a non-canonical varint for the number of
+	// inputs.
+	nonCanonical := append([]byte{}, multiTxEncoded[:4]...)
+	nonCanonical = append(nonCanonical, 0xfd, 0x01, 0x00)
+	nonCanonical = append(nonCanonical, multiTxEncoded[5:]...)
+
+	// Transaction with trailing data.
+	trailing := append([]byte{}, multiTxEncoded...)
+	trailing = append(trailing, 0x00)
+
+	tests := []struct {
+		tx     *btcwire.MsgTx // Transaction to serialize
+		raw    []byte         // Bytes to compare against
+		want   bool           // Expected result
+		offset int            // Expected offset of first difference
+	}{
+		{multiTx, multiTxEncoded, true, -1},
+		{witnessTx, witnessTxEncoded, true, -1},
+		{multiTx, nonCanonical, false, 4},
+		{multiTx, multiTxEncoded[:20], false, 20},
+		{multiTx, trailing, false, len(multiTxEncoded)},
+		{witnessTx, multiTxEncoded, false, 4},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		got, offset, err := test.tx.SerializeEquals(test.raw)
+		if err != nil {
+			t.Errorf("SerializeEquals #%d error %v", i, err)
+			continue
+		}
+		if got != test.want || offset != test.offset {
+			t.Errorf("SerializeEquals #%d: got %v (offset %d), want "+
+				"%v (offset %d)", i, got, offset, test.want,
+				test.offset)
+			continue
+		}
+	}
+
+	// Ensure a transaction decoded from the non-canonical encoding does
+	// not re-encode to the same bytes.
+	var tx btcwire.MsgTx
+	err := tx.Deserialize(bytes.NewReader(nonCanonical))
+	if err != nil {
+		t.Errorf("Deserialize error %v", err)
+		return
+	}
+	if got, offset, _ := tx.SerializeEquals(nonCanonical); got || offset != 4 {
+		t.Errorf("SerializeEquals: got %v (offset %d), want false "+
+			"(offset 4)", got, offset)
+	}
+}
+
+// TestTxZeroInputAmbiguity tests decoding of legacy transactions without any
+// inputs, whose zero input count is the same byte as the witness marker, along
+// with a witness transaction.
+func TestTxZeroInputAmbiguity(t *testing.T) {
+	// Legacy transaction with no inputs and no outputs.
+	noInOutTx := btcwire.NewMsgTx()
+	noInOutTx.Version = 1
+	noInOutTxEncoded := []byte{
+		0x01, 0x00, 0x00, 0x00, // Version
+		0x00,                   // Varint for number of input transactions
+		0x00,                   // Varint for number of output transactions
+		0x00, 0x00, 0x00, 0x00, // Lock time
+	}
+
+	// Legacy transaction with no inputs and two outputs.  The number of
+	// outputs differs from the witness flag.
+	noInTx := btcwire.NewMsgTx()
+	noInTx.Version = 1
+	noInTx.AddTxOut(btcwire.NewTxOut(1000, []byte{0x51}))
+	noInTx.AddTxOut(btcwire.NewTxOut(2000, []byte{0x52}))
+	noInTxEncoded := []byte{
+		0x01, 0x00, 0x00, 0x00, // Version
+		0x00,                                           // Varint for number of input transactions
+		0x02,                                           // Varint for number of output transactions
+		0xe8, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Transaction amount
+		0x01,                                           // Varint for length of pk script
+		0x51,                                           // OP_TRUE
+		0xd0, 0x07, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Transaction amount
+		0x01,                   // Varint for length of pk script
+		0x52,                   // OP_2
+		0x00, 0x00, 0x00, 0x00, // Lock time
+	}
+
+	tests := []struct {
+		name string         // Description of the test
+		out  *btcwire.MsgTx // Expected decoded transaction
+		buf  []byte         // Serialized data
+	}{
+		{"no inputs or outputs", noInOutTx, noInOutTxEncoded},
+		{"no inputs", noInTx, noInTxEncoded},
+		{"witness", witnessTx, witnessTxEncoded},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		var tx btcwire.MsgTx
+		err := tx.Deserialize(bytes.NewReader(test.buf))
+		if err != nil {
+			t.Errorf("Deserialize #%d (%s) error %v", i, test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(&tx, test.out) {
+			t.Errorf("Deserialize #%d (%s)\n got: %s want: %s", i,
+				test.name, spew.Sdump(&tx), spew.Sdump(test.out))
+			continue
+		}
+		if tx.HasWitness() != (test.out == witnessTx) {
+			t.Errorf("Deserialize #%d (%s) wrong witness detection",
+				i, test.name)
+			continue
+		}
+
+		// Ensure the transaction serializes back to the same bytes.
+		var buf bytes.Buffer
+		err = tx.Serialize(&buf)
+		if err != nil {
+			t.Errorf("Serialize #%d (%s) error %v", i, test.name, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("Serialize #%d (%s)\n got: %s want: %s", i,
+				test.name, spew.Sdump(buf.Bytes()),
+				spew.Sdump(test.buf))
+			continue
+		}
+	}
+
+	// Ensure a marker and flag without any further data is not treated as
+	// a witness transaction, but still fails to decode since it is not a
+	// complete legacy transaction either.
+	truncated := []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x01}
+	var tx btcwire.MsgTx
+	err := tx.Deserialize(bytes.NewReader(truncated))
+	if err != io.EOF {
+		t.Errorf("Deserialize: wrong error for truncated transaction "+
+			"- got %v, want %v", err, io.EOF)
+	}
+}
+
+// TestTxWitness tests the handling of witness data by the MsgTx API.
+func TestTxWitness(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	if multiTx.HasWitness() {
+		t.Errorf("HasWitness: legacy transaction reports witness data")
+	}
+	if !witnessTx.HasWitness() {
+		t.Errorf("HasWitness: witness transaction reports no witness " +
+			"data")
+	}
+
+	// Ensure the transaction hash does not commit to the witness data by
+	// comparing it against the hash of the same transaction without it.
+	stripped := witnessTx.Copy()
+	for _, txIn := range stripped.TxIn {
+		txIn.Witness = nil
+	}
+	wantHash, _ := stripped.TxSha(pver)
+	gotHash, _ := witnessTx.TxSha(pver)
+	if !gotHash.IsEqual(&wantHash) {
+		t.Errorf("TxSha: wrong hash for witness transaction - got %v, "+
+			"want %v", gotHash, wantHash)
+	}
+
+	// Ensure a copy of the transaction has a deep copy of the witness.
+	txCopy := witnessTx.Copy()
+	if !reflect.DeepEqual(txCopy.TxIn[0].Witness, witnessTx.TxIn[0].Witness) {
+		t.Errorf("Copy: wrong witness - got %v, want %v",
+			spew.Sdump(txCopy.TxIn[0].Witness),
+			spew.Sdump(witnessTx.TxIn[0].Witness))
+	}
+	txCopy.TxIn[0].Witness[0][0] ^= 0xff
+	if txCopy.TxIn[0].Witness[0][0] == witnessTx.TxIn[0].Witness[0][0] {
+		t.Errorf("Copy: witness items are shared with the original")
+	}
+}
+
+// TestTxEmptyWitnessFlag ensures a transaction which uses the witness
+// serialization even though none of its inputs have witness data is rejected
+// as defined by BIP0144.
+func TestTxEmptyWitnessFlag(t *testing.T) {
+	// The witness transaction with an empty witness for its only input.
+	buf := joinBytes(witnessTxEncoded[:59], []byte{
+		0x00,                   // Varint for number of witness items
+		0x00, 0x00, 0x00, 0x00, // Lock time
+	})
+
+	var tx btcwire.MsgTx
+	err := tx.BtcDecode(bytes.NewReader(buf), btcwire.ProtocolVersion)
+	msgErr, ok := err.(*btcwire.MessageError)
+	if !ok || msgErr.Code != btcwire.ErrMalformed {
+		t.Errorf("BtcDecode: wrong error got: %v, want: %v", err,
+			btcwire.ErrMalformed)
+	}
+
+	_, err = btcwire.NewMsgTxFromBytes(buf)
+	msgErr, ok = err.(*btcwire.MessageError)
+	if !ok || msgErr.Code != btcwire.ErrMalformed {
+		t.Errorf("NewMsgTxFromBytes: wrong error got: %v, want: %v",
+			err, btcwire.ErrMalformed)
+	}
+}
+
+// TestTxWitnessLimits ensures witnesses with more items or larger items than
+// allowed are rejected before they are allocated, and that the standard limit
+// for the size of witness items can be enabled for loose transactions only.
+func TestTxWitnessLimits(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	standard := &btcwire.DecodeOptions{StandardWitnessItems: true}
+
+	// The witness transaction up to the witness of its only input.
+	prefix := witnessTxEncoded[:59]
+	lockTime := []byte{0x00, 0x00, 0x00, 0x00}
+
+	// makeTx returns a witness transaction with a single witness item of
+	// the passed size.
+	makeTx := func(size int) []byte {
+		var buf bytes.Buffer
+		buf.Write(prefix)
+		buf.WriteByte(0x01) // Varint for number of witness items
+		buf.Write([]byte{0xfe, byte(size), byte(size >> 8),
+			byte(size >> 16), byte(size >> 24)}) // Varint for item size
+		buf.Write(make([]byte, size))
+		buf.Write(lockTime)
+		return buf.Bytes()
+	}
+
+	// makeClaim returns a witness transaction which claims a single
+	// witness item of the passed size without providing it.
+	makeClaim := func(size int) []byte {
+		return joinBytes(prefix, []byte{
+			0x01, // Varint for number of witness items
+			0xfe, byte(size), byte(size >> 8), byte(size >> 16),
+			byte(size >> 24), // Varint for item size
+		})
+	}
+
+	tests := []struct {
+		name string                 // Short description of the test
+		buf  []byte                 // Wire encoding
+		opts *btcwire.DecodeOptions // Decode options to apply
+		code btcwire.ErrorCode
+		ok   bool // Whether the transaction is valid
+	}{
+		{
+			"largest standard item with standard limits",
+			makeTx(btcwire.MaxWitnessItemSize),
+			standard, 0, true,
+		},
+		{
+			"item larger than standard with standard limits",
+			makeClaim(btcwire.MaxWitnessItemSize + 1),
+			standard, btcwire.ErrPayloadTooLarge, false,
+		},
+		{
+			"item larger than standard",
+			makeTx(btcwire.MaxWitnessItemSize + 1),
+			nil, 0, true,
+		},
+		{
+			"item larger than consensus",
+			makeClaim(btcwire.MaxConsensusWitnessItemSize + 1),
+			nil, btcwire.ErrPayloadTooLarge, false,
+		},
+		{
+			// The items are not provided since they must not be
+			// allocated.
+			"too many items",
+			joinBytes(prefix, []byte{
+				0xfe, 0x01, 0x00, 0x10, 0x00, // Varint for number of witness items
+			}),
+			nil, btcwire.ErrTooManyItems, false,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		var tx btcwire.MsgTx
+		r := btcwire.NewDecodeReader(bytes.NewReader(test.buf), test.opts)
+		err := tx.BtcDecode(r, pver)
+		if test.ok {
+			if err != nil {
+				t.Errorf("BtcDecode #%d (%s) unexpected error: %v",
+					i, test.name, err)
+			}
+			continue
+		}
+		msgErr, ok := err.(*btcwire.MessageError)
+		if !ok {
+			t.Errorf("BtcDecode #%d (%s) wrong error got: %v <%T>, "+
+				"want: <*btcwire.MessageError>", i, test.name,
+				err, err)
+			continue
+		}
+		if msgErr.Code != test.code {
+			t.Errorf("BtcDecode #%d (%s) wrong error code got: %v, "+
+				"want: %v", i, test.name, msgErr.Code, test.code)
+			continue
+		}
+	}
+
+	// Ensure Deserialize accepts items up to the consensus limit since
+	// stored transactions have already been validated.
+	bigTx := makeTx(20000)
+	var tx btcwire.MsgTx
+	err := tx.Deserialize(btcwire.NewDecodeReader(bytes.NewReader(bigTx),
+		standard))
+	if err != nil {
+		t.Errorf("Deserialize: unexpected error %v", err)
+	}
+
+	// Ensure a transaction and a block with a witness item larger than
+	// standard survive a round trip through WriteMessage and ReadMessage,
+	// and that the block is accepted even with the standard limits.
+	block := btcwire.NewMsgBlock(&blockOne.Header)
+	block.AddTransaction(&tx)
+	msgTests := []struct {
+		msg        btcwire.Message
+		standardOK bool // Whether it is accepted with standard limits
+	}{
+		{&tx, false},
+		{block, true},
+	}
+	for i, test := range msgTests {
+		msg := test.msg
+		var buf bytes.Buffer
+		err := btcwire.WriteMessage(&buf, msg, pver, btcwire.MainNet)
+		if err != nil {
+			t.Errorf("WriteMessage #%d error %v", i, err)
+			continue
+		}
+		encoded := buf.Bytes()
+
+		readMsg, _, err := btcwire.ReadMessage(bytes.NewReader(encoded),
+			pver, btcwire.MainNet)
+		if err != nil {
+			t.Errorf("ReadMessage #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(readMsg, msg) {
+			t.Errorf("ReadMessage #%d\n got: %s want: %s", i,
+				spew.Sdump(readMsg), spew.Sdump(msg))
+		}
+
+		opts := &btcwire.MessageOptions{Decode: standard}
+		_, _, err = btcwire.ReadMessageWithOptions(
+			bytes.NewReader(encoded), pver, btcwire.MainNet, opts)
+		if test.standardOK {
+			if err != nil {
+				t.Errorf("ReadMessageWithOptions #%d error %v", i,
+					err)
+			}
+			continue
+		}
+		msgErr, ok := err.(*btcwire.MessageError)
+		if !ok || msgErr.Code != btcwire.ErrPayloadTooLarge {
+			t.Errorf("ReadMessageWithOptions #%d wrong error got: %v, "+
+				"want: %v", i, err, btcwire.ErrPayloadTooLarge)
+		}
+	}
+}
+
+// TestTxOutIsWitnessProgram tests the TxOut IsWitnessProgram function
+// recognizes exactly the shape of witness program scripts.
+func TestTxOutIsWitnessProgram(t *testing.T) {
+	program20 := bytes.Repeat([]byte{0x01}, 20)
+	program32 := bytes.Repeat([]byte{0x02}, 32)
+	program40 := bytes.Repeat([]byte{0x03}, 40)
+
+	tests := []struct {
+		name    string // Short description of the test
+		script  []byte // Public key script of the output
+		version byte   // Expected witness version
+		program []byte // Expected witness program
+		ok      bool   // Expected result
+	}{
+		{"v0 key hash", joinBytes([]byte{0x00, 0x14}, program20), 0,
+			program20, true},
+		{"v0 script hash", joinBytes([]byte{0x00, 0x20}, program32), 0,
+			program32, true},
+		{"v1 taproot", joinBytes([]byte{0x51, 0x20}, program32), 1,
+			program32, true},
+		{"v16 shortest", []byte{0x60, 0x02, 0x01, 0x02}, 16,
+			[]byte{0x01, 0x02}, true},
+		{"v16 longest", joinBytes([]byte{0x60, 0x28}, program40), 16,
+			program40, true},
+		{"empty", nil, 0, nil, false},
+		{"program too short", []byte{0x00, 0x01, 0x01}, 0, nil, false},
+		{"program too long", joinBytes([]byte{0x00, 0x29}, program40,
+			[]byte{0x00}), 0, nil, false},
+		{"OP_1NEGATE version", joinBytes([]byte{0x4f, 0x14}, program20),
+			0, nil, false},
+		{"push as version", joinBytes([]byte{0x01, 0x14}, program20),
+			0, nil, false},
+		{"push length mismatch", joinBytes([]byte{0x00, 0x14}, program20,
+			[]byte{0x51}), 0, nil, false},
+		{"PUSHDATA1 program", joinBytes([]byte{0x00, 0x4c, 0x14},
+			program20), 0, nil, false},
+		{"pay to pubkey hash", joinBytes([]byte{0x76, 0xa9, 0x14},
+			program20, []byte{0x88, 0xac}), 0, nil, false},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		to := btcwire.NewTxOut(0, test.script)
+		version, program, ok := to.IsWitnessProgram()
+		if ok != test.ok || version != test.version ||
+			!bytes.Equal(program, test.program) {
+
+			t.Errorf("IsWitnessProgram #%d (%s): got %d %x %v, want "+
+				"%d %x %v", i, test.name, version, program, ok,
+				test.version, test.program, test.ok)
+			continue
+		}
+	}
+}
+
+// TestTxWireErrors performs negative tests against wire encode and decode
+// of MsgTx to confirm error paths work correctly.
+func TestTxWireErrors(t *testing.T) {
+	// Use protocol version 60002 specifically here instead of the latest
+	// because the test data is using bytes encoded with that protocol
+	// version.
+	pver := uint32(60002)
+
+	tests := []struct {
+		in       *btcwire.MsgTx // Value to encode
+		buf      []byte         // Wire encoding
+		pver     uint32         // Protocol version for wire encoding
+		max      int            // Max size of fixed buffer to induce errors
+		writeErr error          // Expected write error
+		readErr  error          // Expected read error
+	}{
+		// Force error in version.
+		{multiTx, multiTxEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in number of transaction inputs.
+		{multiTx, multiTxEncoded, pver, 4, io.ErrShortWrite, io.EOF},
+		// Force error in transaction input previous block hash.
+		{multiTx, multiTxEncoded, pver, 5, io.ErrShortWrite, io.EOF},
+		// Force error in transaction input previous block hash.
+		{multiTx, multiTxEncoded, pver, 5, io.ErrShortWrite, io.EOF},
+		// Force error in transaction input previous block output index.
+		{multiTx, multiTxEncoded, pver, 37, io.ErrShortWrite, io.EOF},
+		// Force error in transaction input signature script length.
+		{multiTx, multiTxEncoded, pver, 41, io.ErrShortWrite, io.EOF},
+		// Force error in transaction input signature script.
+		{multiTx, multiTxEncoded, pver, 42, io.ErrShortWrite, io.EOF},
+		// Force error in transaction input sequence.
+		{multiTx, multiTxEncoded, pver, 49, io.ErrShortWrite, io.EOF},
+		// Force error in number of transaction outputs.
+		{multiTx, multiTxEncoded, pver, 53, io.ErrShortWrite, io.EOF},
+		// Force error in transaction output value.
+		{multiTx, multiTxEncoded, pver, 54, io.ErrShortWrite, io.EOF},
+		// Force error in transaction output pk script length.
+		{multiTx, multiTxEncoded, pver, 62, io.ErrShortWrite, io.EOF},
+		// Force error in transaction output pk script.
+		{multiTx, multiTxEncoded, pver, 63, io.ErrShortWrite, io.EOF},
+		// Force error in transaction output lock time.
+		{multiTx, multiTxEncoded, pver, 130, io.ErrShortWrite, io.EOF},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if err != test.writeErr {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// Decode from wire format.
+		var msg btcwire.MsgTx
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if err != test.readErr {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+	}
+}
+
+// multiTx is a MsgTx with an input and output and used in various tests.
+var multiTx *btcwire.MsgTx = &btcwire.MsgTx{
+	Version: 1,
+	TxIn: []*btcwire.TxIn{
+		&btcwire.TxIn{
 			PreviousOutpoint: btcwire.OutPoint{
 				Hash:  btcwire.ShaHash{},
 				Index: 0xffffffff,
@@ -434,3 +1880,54 @@ var multiTxEncoded []byte = []byte{
 	0xac,                   // OP_CHECKSIG
 	0x00, 0x00, 0x00, 0x00, // Lock time
 }
+
+// witnessTx is a MsgTx with an input that has witness data and is used in
+// various tests.
+var witnessTx = &btcwire.MsgTx{
+	Version: 1,
+	TxIn: []*btcwire.TxIn{
+		{
+			PreviousOutpoint: btcwire.OutPoint{
+				Hash:  btcwire.ShaHash{0x01},
+				Index: 0,
+			},
+			SignatureScript: []byte{},
+			Witness: btcwire.TxWitness{
+				{0x30, 0x44},
+				{0x02, 0x03},
+			},
+			Sequence: 0xffffffff,
+		},
+	},
+	TxOut: []*btcwire.TxOut{
+		{
+			Value:    1000,
+			PkScript: []byte{0x51}, // OP_TRUE
+		},
+	},
+	LockTime: 0,
+}
+
+// witnessTxEncoded is the serialized bytes for witnessTx using the witness
+// serialization.
+var witnessTxEncoded = []byte{
+	0x01, 0x00, 0x00, 0x00, // Version
+	0x00, // Marker
+	0x01, // Flag
+	0x01, // Varint for number of input transactions
+	0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Previous output hash
+	0x00, 0x00, 0x00, 0x00, // Previous output index
+	0x00,                   // Varint for length of signature script
+	0xff, 0xff, 0xff, 0xff, // Sequence
+	0x01,                                           // Varint for number of output transactions
+	0xe8, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Transaction amount
+	0x01,             // Varint for length of pk script
+	0x51,             // OP_TRUE
+	0x02,             // Varint for number of witness items
+	0x02, 0x30, 0x44, // Witness item
+	0x02, 0x02, 0x03, // Witness item
+	0x00, 0x00, 0x00, 0x00, // Lock time
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"fmt"
+	"io"
+)
+
+// MemPoolHeight is the height reported in a UTXO result for outputs which are
+// only in the memory pool of the responding peer.
+const MemPoolHeight uint32 = 0x7fffffff
+
+// maxHitsBitmapLen is the maximum number of bytes in the hits bitmap of a
+// utxos message (MsgUTXOs).  There is one bit per queried outpoint.
+const maxHitsBitmapLen = (MaxOutPointsPerGetUTXOs + 7) / 8
+
+// UTXO defines an unspent transaction output result within a bitcoin utxos
+// message (MsgUTXOs).
+type UTXO struct {
+	// TxVersion is the version of the transaction which contains the
+	// output.
+	TxVersion uint32
+
+	// Height is the height of the block which contains the transaction or
+	// MemPoolHeight when the transaction is only in the memory pool.
+	Height uint32
+
+	// TxOut is the unspent transaction output itself.
+	TxOut TxOut
+}
+
+// NewUTXO returns a new UTXO result using the provided transaction version,
+// height, and transaction output.
+func NewUTXO(txVersion uint32, height uint32, txOut *TxOut) *UTXO {
+	return &UTXO{
+		TxVersion: txVersion,
+		Height:    height,
+		TxOut:     *txOut,
+	}
+}
+
+// MsgUTXOs implements the Message interface and represents a bitcoin utxos
+// message as defined by BIP0064.  It is sent in response to a getutxos message
+// (MsgGetUTXOs) and reports which of the queried outpoints are unspent.
+//
+// The HitsBitmap contains one bit per queried outpoint, in the same order the
+// outpoints were queried, with the least significant bit of the first byte
+// representing the first outpoint.  UTXOs contains a result for each set bit,
+// in the same order.
+//
+// Use the SetHit and AddUTXO functions to build up the message.
+type MsgUTXOs struct {
+	// ChainHeight is the height of the chain at the moment the result
+	// was calculated.
+	ChainHeight int32
+
+	// ChainTipHash is the hash of the block at the tip of the chain at the
+	// moment the result was calculated.
+	ChainTipHash ShaHash
+
+	// HitsBitmap has a bit set for each queried outpoint that was found to
+	// be unspent.
+	HitsBitmap []byte
+
+	// UTXOs contains the results for each outpoint that was found.
+	UTXOs []*UTXO
+}
+
+// SetHit marks the outpoint at the provided index of the associated getutxos
+// message as found in the hits bitmap.
+func (msg *MsgUTXOs) SetHit(index int) error {
+	if index < 0 || index >= MaxOutPointsPerGetUTXOs {
+		str := fmt.Sprintf("outpoint index %v out of range [max %v]",
+			index, MaxOutPointsPerGetUTXOs-1)
+		return messageError("MsgUTXOs.SetHit", ErrMalformed, str)
+	}
+
+	for len(msg.HitsBitmap) <= index/8 {
+		msg.HitsBitmap = append(msg.HitsBitmap, 0)
+	}
+	msg.HitsBitmap[index/8] |= 1 << uint(index%8)
+	return nil
+}
+
+// IsHit returns whether the outpoint at the provided index of the associated
+// getutxos message was found according to the hits bitmap.
+func (msg *MsgUTXOs) IsHit(index int) bool {
+	if index < 0 || index/8 >= len(msg.HitsBitmap) {
+		return false
+	}
+	return msg.HitsBitmap[index/8]&(1<<uint(index%8)) != 0
+}
+
+// AddUTXO adds a result to the message.  Results must be added in the same
+// order as their associated bits in the hits bitmap.
+func (msg *MsgUTXOs) AddUTXO(utxo *UTXO) error {
+	if len(msg.UTXOs)+1 > MaxOutPointsPerGetUTXOs {
+		str := fmt.Sprintf("too many utxos in message [max %v]",
+			MaxOutPointsPerGetUTXOs)
+		return messageError("MsgUTXOs.AddUTXO", ErrTooManyItems, str)
+	}
+
+	msg.UTXOs = append(msg.UTXOs, utxo)
+	return nil
+}
+
+// numHits returns the number of bits set in the hits bitmap.
+func (msg *MsgUTXOs) numHits() int {
+	hits := 0
+	for _, b := range msg.HitsBitmap {
+		for ; b != 0; b &= b - 1 {
+			hits++
+		}
+	}
+	return hits
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgUTXOs) BtcDecode(r io.Reader, pver uint32) error {
+	err := readElements(r, &msg.ChainHeight, &msg.ChainTipHash)
+	if err != nil {
+		return err
+	}
+
+	// Read the hits bitmap and limit it to the max number of outpoints
+	// which can be queried.
+	bitmapLen, err := readVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if bitmapLen > maxHitsBitmapLen {
+		str := fmt.Sprintf("hits bitmap too long for message "+
+			"[len %v, max %v]", bitmapLen, maxHitsBitmapLen)
+		return messageError("MsgUTXOs.BtcDecode", ErrPayloadTooLarge, str)
+	}
+	msg.HitsBitmap = make([]byte, bitmapLen)
+	_, err = io.ReadFull(r, msg.HitsBitmap)
+	if err != nil {
+		return err
+	}
+
+	// There must be exactly one result per set bit in the hits bitmap.
+	count, err := readVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if hits := msg.numHits(); count != uint64(hits) {
+		str := fmt.Sprintf("number of utxos does not match hits "+
+			"bitmap [count %v, hits %v]", count, hits)
+		return messageError("MsgUTXOs.BtcDecode", ErrMalformed, str)
+	}
+
+	for i := uint64(0); i < count; i++ {
+		utxo := UTXO{}
+		err := readElements(r, &utxo.TxVersion, &utxo.Height)
+		if err != nil {
+			return err
+		}
+		err = readTxOut(r, pver, utxo.TxVersion, &utxo.TxOut)
+		if err != nil {
+			return err
+		}
+		err = msg.AddUTXO(&utxo)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgUTXOs) BtcEncode(w io.Writer, pver uint32) error {
+	bitmapLen := len(msg.HitsBitmap)
+	if bitmapLen > maxHitsBitmapLen {
+		str := fmt.Sprintf("hits bitmap too long for message "+
+			"[len %v, max %v]", bitmapLen, maxHitsBitmapLen)
+		return messageError("MsgUTXOs.BtcEncode", ErrPayloadTooLarge, str)
+	}
+	count := len(msg.UTXOs)
+	if hits := msg.numHits(); count != hits {
+		str := fmt.Sprintf("number of utxos does not match hits "+
+			"bitmap [count %v, hits %v]", count, hits)
+		return messageError("MsgUTXOs.BtcEncode", ErrMalformed, str)
+	}
+
+	err := writeElements(w, msg.ChainHeight, msg.ChainTipHash)
+	if err != nil {
+		return err
+	}
+
+	err = writeVarInt(w, pver, uint64(bitmapLen))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg.HitsBitmap)
+	if err != nil {
+		return err
+	}
+
+	err = writeVarInt(w, pver, uint64(count))
+	if err != nil {
+		return err
+	}
+
+	for _, utxo := range msg.UTXOs {
+		err := writeElements(w, utxo.TxVersion, utxo.Height)
+		if err != nil {
+			return err
+		}
+		err = writeTxOut(w, pver, &utxo.TxOut)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgUTXOs) Command() string {
+	return cmdUTXOs
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgUTXOs) String() string {
+	return fmt.Sprintf("%s height=%d tip=%v utxos=%d", msg.Command(),
+		msg.ChainHeight, msg.ChainTipHash, len(msg.UTXOs))
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgUTXOs) MaxPayloadLength(pver uint32) uint32 {
+	// Since the public key scripts of the results can vary in size, make
+	// it the max size allowed.
+	return maxMessagePayload
+}
+
+// NewMsgUTXOs returns a new bitcoin utxos message that conforms to the Message
+// interface using the passed chain height and tip hash.  See MsgUTXOs for
+// details.
+func NewMsgUTXOs(chainHeight int32, chainTipHash *ShaHash) *MsgUTXOs {
+	return &MsgUTXOs{
+		ChainHeight:  chainHeight,
+		ChainTipHash: *chainTipHash,
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// TestUTXOs tests the MsgUTXOs API.
+func TestUTXOs(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	// Ensure the command is expected value.
+	wantCmd := "utxos"
+	msg := btcwire.NewMsgUTXOs(100, &btcwire.GenesisHash)
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgUTXOs: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	wantPayload := btcwire.MaxMessagePayload
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+
+	// Ensure hits are set and reported properly.
+	for _, index := range []int{0, 9} {
+		err := msg.SetHit(index)
+		if err != nil {
+			t.Errorf("SetHit: %v", err)
+		}
+	}
+	for i := -1; i < 17; i++ {
+		want := i == 0 || i == 9
+		if hit := msg.IsHit(i); hit != want {
+			t.Errorf("IsHit #%d: wrong hit - got %v, want %v", i,
+				hit, want)
+		}
+	}
+	wantBitmap := []byte{0x01, 0x02}
+	if !bytes.Equal(msg.HitsBitmap, wantBitmap) {
+		t.Errorf("SetHit: wrong bitmap - got %v, want %v",
+			msg.HitsBitmap, wantBitmap)
+	}
+
+	// Ensure out of range hit indexes return an error.
+	if err := msg.SetHit(btcwire.MaxOutPointsPerGetUTXOs); err == nil {
+		t.Errorf("SetHit: expected error on out of range index " +
+			"not received")
+	}
+
+	// Ensure results are added properly.
+	utxo := btcwire.NewUTXO(1, 0, btcwire.NewTxOut(5000000000, nil))
+	err := msg.AddUTXO(utxo)
+	if err != nil {
+		t.Errorf("AddUTXO: %v", err)
+	}
+	if msg.UTXOs[0] != utxo {
+		t.Errorf("AddUTXO: wrong utxo added - got %v, want %v",
+			spew.Sprint(msg.UTXOs[0]), spew.Sprint(utxo))
+	}
+
+	// Ensure adding more than the max allowed results per message returns
+	// an error.
+	for i := 0; i < btcwire.MaxOutPointsPerGetUTXOs; i++ {
+		err = msg.AddUTXO(utxo)
+	}
+	if err == nil {
+		t.Errorf("AddUTXO: expected error on too many utxos not " +
+			"received")
+	}
+
+	return
+}
+
+// TestUTXOsWire tests the MsgUTXOs wire encode and decode for various numbers
+// of results.
+func TestUTXOsWire(t *testing.T) {
+	// utxos message with no results.
+	noUTXOs := btcwire.NewMsgUTXOs(0, &btcwire.GenesisHash)
+	noUTXOs.HitsBitmap = []byte{}
+	noUTXOsEncoded := []byte{
+		0x00, 0x00, 0x00, 0x00, // Chain height
+		0x6f, 0xe2, 0x8c, 0x0a, 0xb6, 0xf1, 0xb3, 0x72,
+		0xc1, 0xa6, 0xa2, 0x46, 0xae, 0x63, 0xf7, 0x4f,
+		0x93, 0x1e, 0x83, 0x65, 0xe1, 0x5a, 0x08, 0x9c,
+		0x68, 0xd6, 0x19, 0x00, 0x00, 0x00, 0x00, 0x00, // Chain tip hash
+		0x00, // Varint for hits bitmap length
+		0x00, // Varint for number of results
+	}
+
+	// utxos message where the first and third queried outpoints were
+	// found.  The first is in a block and the third is in the mempool.
+	multiUTXOs := btcwire.NewMsgUTXOs(1, &btcwire.GenesisHash)
+	multiUTXOs.SetHit(0)
+	multiUTXOs.SetHit(2)
+	multiUTXOs.AddUTXO(btcwire.NewUTXO(1, 1,
+		btcwire.NewTxOut(5000000000, []byte{0x51})))
+	multiUTXOs.AddUTXO(btcwire.NewUTXO(1, btcwire.MemPoolHeight,
+		btcwire.NewTxOut(1000, []byte{0x6a, 0x00})))
+	multiUTXOsEncoded := []byte{
+		0x01, 0x00, 0x00, 0x00, // Chain height
+		0x6f, 0xe2, 0x8c, 0x0a, 0xb6, 0xf1, 0xb3, 0x72,
+		0xc1, 0xa6, 0xa2, 0x46, 0xae, 0x63, 0xf7, 0x4f,
+		0x93, 0x1e, 0x83, 0x65, 0xe1, 0x5a, 0x08, 0x9c,
+		0x68, 0xd6, 0x19, 0x00, 0x00, 0x00, 0x00, 0x00, // Chain tip hash
+		0x01,                   // Varint for hits bitmap length
+		0x05,                   // Hits bitmap
+		0x02,                   // Varint for number of results
+		0x01, 0x00, 0x00, 0x00, // Tx version
+		0x01, 0x00, 0x00, 0x00, // Height
+		0x00, 0xf2, 0x05, 0x2a, 0x01, 0x00, 0x00, 0x00, // Value
+		0x01, 0x51, // Public key script
+		0x01, 0x00, 0x00, 0x00, // Tx version
+		0xff, 0xff, 0xff, 0x7f, // Height (MemPoolHeight)
+		0xe8, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Value
+		0x02, 0x6a, 0x00, // Public key script
+	}
+
+	tests := []struct {
+		in   *btcwire.MsgUTXOs // Message to encode
+		out  *btcwire.MsgUTXOs // Expected decoded message
+		buf  []byte            // Wire encoding
+		pver uint32            // Protocol version for wire encoding
+	}{
+		// Latest protocol version with no results.
+		{
+			noUTXOs,
+			noUTXOs,
+			noUTXOsEncoded,
+			btcwire.ProtocolVersion,
+		},
+
+		// Latest protocol version with multiple results.
+		{
+			multiUTXOs,
+			multiUTXOs,
+			multiUTXOsEncoded,
+			btcwire.ProtocolVersion,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format.
+		var buf bytes.Buffer
+		err := test.in.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var msg btcwire.MsgUTXOs
+		rbuf := bytes.NewBuffer(test.buf)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(&msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+}
+
+// TestUTXOsWireErrors performs negative tests against wire encode and decode
+// of MsgUTXOs to confirm error paths work correctly.
+func TestUTXOsWireErrors(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcwireErr := &btcwire.MessageError{}
+
+	baseUTXOs := btcwire.NewMsgUTXOs(1, &btcwire.GenesisHash)
+	baseUTXOs.SetHit(0)
+	baseUTXOs.AddUTXO(btcwire.NewUTXO(1, 1,
+		btcwire.NewTxOut(5000000000, []byte{0x51})))
+	baseUTXOsEncoded := []byte{
+		0x01, 0x00, 0x00, 0x00, // Chain height
+		0x6f, 0xe2, 0x8c, 0x0a, 0xb6, 0xf1, 0xb3, 0x72,
+		0xc1, 0xa6, 0xa2, 0x46, 0xae, 0x63, 0xf7, 0x4f,
+		0x93, 0x1e, 0x83, 0x65, 0xe1, 0x5a, 0x08, 0x9c,
+		0x68, 0xd6, 0x19, 0x00, 0x00, 0x00, 0x00, 0x00, // Chain tip hash
+		0x01,                   // Varint for hits bitmap length
+		0x01,                   // Hits bitmap
+		0x01,                   // Varint for number of results
+		0x01, 0x00, 0x00, 0x00, // Tx version
+		0x01, 0x00, 0x00, 0x00, // Height
+		0x00, 0xf2, 0x05, 0x2a, 0x01, 0x00, 0x00, 0x00, // Value
+		0x01, 0x51, // Public key script
+	}
+
+	// Message that forces an error by having a result count which doesn't
+	// match the hits bitmap.
+	mismatchUTXOs := btcwire.NewMsgUTXOs(1, &btcwire.GenesisHash)
+	mismatchUTXOs.SetHit(0)
+	mismatchUTXOs.SetHit(1)
+	mismatchUTXOs.AddUTXO(btcwire.NewUTXO(1, 1,
+		btcwire.NewTxOut(5000000000, []byte{0x51})))
+	mismatchUTXOsEncoded := make([]byte, 39)
+	copy(mismatchUTXOsEncoded, baseUTXOsEncoded)
+	mismatchUTXOsEncoded[37] = 0x03
+
+	// Message that forces an error by having a hits bitmap which is longer
+	// than the max allowed.
+	longBitmapUTXOs := btcwire.NewMsgUTXOs(1, &btcwire.GenesisHash)
+	longBitmapUTXOs.HitsBitmap = make([]byte, 14)
+	longBitmapUTXOsEncoded := make([]byte, 37)
+	copy(longBitmapUTXOsEncoded, baseUTXOsEncoded)
+	longBitmapUTXOsEncoded[36] = 0x0e
+
+	tests := []struct {
+		in       *btcwire.MsgUTXOs // Value to encode
+		buf      []byte            // Wire encoding
+		pver     uint32            // Protocol version for wire encoding
+		max      int               // Max size of fixed buffer to induce errors
+		writeErr error             // Expected write error
+		readErr  error             // Expected read error
+	}{
+		// Force error in chain height.
+		{baseUTXOs, baseUTXOsEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in chain tip hash.
+		{baseUTXOs, baseUTXOsEncoded, pver, 4, io.ErrShortWrite, io.EOF},
+		// Force error in hits bitmap length.
+		{baseUTXOs, baseUTXOsEncoded, pver, 36, io.ErrShortWrite, io.EOF},
+		// Force error in hits bitmap.
+		{baseUTXOs, baseUTXOsEncoded, pver, 37, io.ErrShortWrite, io.EOF},
+		// Force error in result count.
+		{baseUTXOs, baseUTXOsEncoded, pver, 38, io.ErrShortWrite, io.EOF},
+		// Force error in result tx version.
+		{baseUTXOs, baseUTXOsEncoded, pver, 39, io.ErrShortWrite, io.EOF},
+		// Force error in result output.
+		{baseUTXOs, baseUTXOsEncoded, pver, 47, io.ErrShortWrite, io.EOF},
+		// Force error with result count not matching the hits bitmap.
+		{mismatchUTXOs, mismatchUTXOsEncoded, pver, 39, btcwireErr, btcwireErr},
+		// Force error with greater than max hits bitmap length.
+		{longBitmapUTXOs, longBitmapUTXOsEncoded, pver, 37, btcwireErr, btcwireErr},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg btcwire.MsgUTXOs
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
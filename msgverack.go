@@ -33,6 +33,11 @@ func (msg *MsgVerAck) Command() string {
 	return cmdVerAck
 }
 
+// String returns the message in human-readable form.
+func (msg *MsgVerAck) String() string {
+	return msg.Command()
+}
+
 // MaxPayloadLength returns the maximum length the payload can be for the
 // receiver.  This is part of the Message interface implementation.
 func (msg *MsgVerAck) MaxPayloadLength(pver uint32) uint32 {
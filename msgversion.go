@@ -8,12 +8,15 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"path"
+	"strings"
 	"time"
 )
 
 // MaxUserAgentLen is the maximum allowed length for the user agent field in a
-// version message (MsgVersion).
-const MaxUserAgentLen = 2000
+// version message (MsgVersion).  It matches the MAX_SUBVERSION_LENGTH of the
+// reference implementation.
+const MaxUserAgentLen = 256
 
 // MsgVersion implements the Message interface and represents a bitcoin version
 // message.  It is used for a peer to advertise itself as soon as an outbound
@@ -48,62 +51,207 @@ type MsgVersion struct {
 
 	// Last block seen by the generator of the version message.
 	LastBlock int32
+
+	// Don't announce transactions to peer.  This is encoded on the wire as
+	// the inverse relay flag and is only present for protocol versions
+	// >= BIP0037Version.
+	DisableRelayTx bool
 }
 
 // HasService returns whether the specified service is supported by the peer
-// that generated the message.
+// that generated the message.  When service contains multiple flags, all of
+// them must be set.
 func (msg *MsgVersion) HasService(service ServiceFlag) bool {
-	if msg.Services&service == service {
-		return true
-	}
-	return false
+	return msg.Services.HasFlag(service)
 }
 
 // AddService adds service as a supported service by the peer generating the
 // message.
 func (msg *MsgVersion) AddService(service ServiceFlag) {
-	msg.Services |= service
+	msg.Services.AddFlag(service)
+}
+
+// SetServices adds all of the passed services as supported services by the
+// peer generating the message in one call.  Services which are already set are
+// left as is.
+func (msg *MsgVersion) SetServices(services ...ServiceFlag) {
+	for _, service := range services {
+		msg.Services |= service
+	}
+}
+
+// ClearService removes service as a supported service by the peer generating
+// the message.  When service contains multiple flags, all of them are removed.
+func (msg *MsgVersion) ClearService(service ServiceFlag) {
+	msg.Services &^= service
+}
+
+// IsCompatible returns whether or not the protocol version advertised by the
+// message is at least the passed minimum, such as
+// MinAcceptableProtocolVersion.  A negative protocol version is never
+// compatible.
+func (msg *MsgVersion) IsCompatible(minVersion uint32) bool {
+	return msg.ProtocolVersion >= 0 &&
+		uint32(msg.ProtocolVersion) >= minVersion
+}
+
+// userAgentReserved holds the characters which delimit the components of a
+// BIP0014 user agent and so may not appear in their names, versions or
+// comments.
+const userAgentReserved = "/:();"
+
+// AddUserAgent appends a component for the passed name, version and optional
+// comments to the user agent of the message in the format defined by BIP0014,
+// such as "/btcwire:0.1.0(comment1; comment2)/".  An error is returned, and
+// the user agent left unchanged, when the name or version is empty, any of
+// them contain one of the characters which delimit the components, or the
+// resulting user agent would exceed MaxUserAgentLen.
+func (msg *MsgVersion) AddUserAgent(name string, version string,
+	comments ...string) error {
+
+	if name == "" || version == "" {
+		str := fmt.Sprintf("user agent name %q and version %q must "+
+			"not be empty", name, version)
+		return messageError("MsgVersion.AddUserAgent", ErrMalformed, str)
+	}
+	for _, field := range append([]string{name, version}, comments...) {
+		if strings.ContainsAny(field, userAgentReserved) {
+			str := fmt.Sprintf("user agent field %q contains one "+
+				"of the reserved characters %q", field,
+				userAgentReserved)
+			return messageError("MsgVersion.AddUserAgent",
+				ErrMalformed, str)
+		}
+	}
+
+	component := name + ":" + version
+	if len(comments) != 0 {
+		component += "(" + strings.Join(comments, "; ") + ")"
+	}
+	userAgent := msg.UserAgent
+	if !strings.HasSuffix(userAgent, "/") {
+		userAgent += "/"
+	}
+	userAgent += component + "/"
+
+	if len(userAgent) > MaxUserAgentLen {
+		str := fmt.Sprintf("user agent too long [len %v, max %v]",
+			len(userAgent), MaxUserAgentLen)
+		return messageError("MsgVersion.AddUserAgent",
+			ErrPayloadTooLarge, str)
+	}
+
+	msg.UserAgent = userAgent
+	return nil
+}
+
+// UserAgentMatches returns whether or not the user agent of the message matches
+// any of the passed patterns.  Matching is case-insensitive.
+//
+// A pattern without any of the glob metacharacters '*', '?', or '[' matches
+// when it is contained anywhere in the user agent.  Otherwise, it is a glob as
+// described by path.Match which must match either the whole user agent or
+// one of the slash-delimited components of the BIP0014 format, such as
+// "Satoshi:0.8.*" for the user agent "/Satoshi:0.8.1/".  Since '*' and '?' do
+// not match a slash, each component of a pattern which spans several components
+// must be given.  Malformed patterns never match.
+func (msg *MsgVersion) UserAgentMatches(patterns []string) bool {
+	userAgent := strings.ToLower(msg.UserAgent)
+	components := strings.Split(strings.Trim(userAgent, "/"), "/")
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if !strings.ContainsAny(pattern, "*?[") {
+			if strings.Contains(userAgent, pattern) {
+				return true
+			}
+			continue
+		}
+
+		if matched, _ := path.Match(pattern, userAgent); matched {
+			return true
+		}
+		for _, component := range components {
+			if matched, _ := path.Match(pattern, component); matched {
+				return true
+			}
+		}
+	}
+
+	return false
 }
 
 // BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
 // This is part of the Message interface implementation.
+//
+// When r is able to report how many bytes remain, such as the bytes.Buffer used
+// by ReadMessage, the optional fields which follow the remote address are only
+// decoded when data is present so that the truncated version messages sent by
+// very old peers are accepted.
 func (msg *MsgVersion) BtcDecode(r io.Reader, pver uint32) error {
 	var sec int64
 	err := readElements(r, &msg.ProtocolVersion, &msg.Services, &sec)
 	if err != nil {
 		return err
 	}
-	msg.Timestamp = time.Unix(sec, 0)
-
-	err = readNetAddress(r, pver, &msg.AddrYou, false)
+	err = checkServices(r, msg.Services, "MsgVersion.BtcDecode")
 	if err != nil {
 		return err
 	}
+	msg.Timestamp = time.Unix(sec, 0)
 
-	err = readNetAddress(r, pver, &msg.AddrMe, false)
+	err = readNetAddress(r, pver, &msg.AddrYou, false)
 	if err != nil {
 		return err
 	}
 
-	err = readElement(r, &msg.Nonce)
-	if err != nil {
-		return err
+	// Protocol versions >= 106 added a from address, nonce, and user agent
+	// field and they are only considered present if there are bytes
+	// remaining in the message.  Very old peers send a version message
+	// which ends after the remote address, so leave the remaining fields
+	// zero-valued in that case.
+	if hasRemaining(r) {
+		err = readNetAddress(r, pver, &msg.AddrMe, false)
+		if err != nil {
+			return err
+		}
 	}
-
-	userAgent, err := readVarString(r, pver)
-	if err != nil {
-		return err
+	if hasRemaining(r) {
+		err = readElement(r, &msg.Nonce)
+		if err != nil {
+			return err
+		}
 	}
-	if len(userAgent) > MaxUserAgentLen {
-		str := fmt.Sprintf("user agent too long [len %v, max %v]",
-			len(userAgent), MaxUserAgentLen)
-		return messageError("MsgVersion.BtcDecode", str)
+	if hasRemaining(r) {
+		// Limit the user agent to the max allowed length before it is
+		// allocated.
+		userAgent, err := readTextVarString(r, pver, MaxUserAgentLen,
+			"user agent")
+		if err != nil {
+			return err
+		}
+		msg.UserAgent = userAgent
 	}
-	msg.UserAgent = userAgent
 
-	err = readElement(r, &msg.LastBlock)
-	if err != nil {
-		return err
+	// Protocol versions >= 209 added a last known block field.  It is only
+	// considered present if there are bytes remaining in the message.
+	if hasRemaining(r) {
+		err = readElement(r, &msg.LastBlock)
+		if err != nil {
+			return err
+		}
+	}
+
+	// There was no relay transactions field before BIP0037Version, but
+	// the default behavior prior to the addition of the field was to
+	// always relay transactions.  It is also only considered present if
+	// there are bytes remaining in the message.
+	if pver >= BIP0037Version && hasRemaining(r) {
+		var relayTx bool
+		err = readElement(r, &relayTx)
+		if err != nil {
+			return err
+		}
+		msg.DisableRelayTx = !relayTx
 	}
 
 	return nil
@@ -111,11 +259,15 @@ func (msg *MsgVersion) BtcDecode(r io.Reader, pver uint32) error {
 
 // BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
 // This is part of the Message interface implementation.
+//
+// The fields are written in the same order as bitcoind: protocol version,
+// services, timestamp, remote address, local address, nonce, user agent, last
+// block, and, for protocol versions >= BIP0037Version, the relay flag.
 func (msg *MsgVersion) BtcEncode(w io.Writer, pver uint32) error {
 	if len(msg.UserAgent) > MaxUserAgentLen {
 		str := fmt.Sprintf("user agent too long [len %v, max %v]",
 			len(msg.UserAgent), MaxUserAgentLen)
-		return messageError("MsgVersion.BtcEncode", str)
+		return messageError("MsgVersion.BtcEncode", ErrPayloadTooLarge, str)
 	}
 
 	err := writeElements(w, msg.ProtocolVersion, msg.Services,
@@ -149,6 +301,16 @@ func (msg *MsgVersion) BtcEncode(w io.Writer, pver uint32) error {
 		return err
 	}
 
+	// There was no relay transactions field before BIP0037Version.  Also,
+	// the wire encoding for the field is true when transactions should be
+	// relayed, so reverse it from the DisableRelayTx field.
+	if pver >= BIP0037Version {
+		err = writeElement(w, !msg.DisableRelayTx)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -158,16 +320,55 @@ func (msg *MsgVersion) Command() string {
 	return cmdVersion
 }
 
+// String returns the message in human-readable form.
+func (msg *MsgVersion) String() string {
+	return fmt.Sprintf("%s pver=%d services=%v ua=%q height=%d "+
+		"me=%v you=%v norelay=%v", msg.Command(), msg.ProtocolVersion,
+		msg.Services, msg.UserAgent, msg.LastBlock, &msg.AddrMe,
+		&msg.AddrYou, msg.DisableRelayTx)
+}
+
 // MaxPayloadLength returns the maximum length the payload can be for the
 // receiver.  This is part of the Message interface implementation.
 func (msg *MsgVersion) MaxPayloadLength(pver uint32) uint32 {
 	// XXX: <= 106 different
-	// XXX: >= 70001 different
 
 	// Protocol version 4 bytes + services 8 bytes + timestamp 8 bytes + remote
 	// and local net addresses + nonce 8 bytes + length of user agent (varInt) +
-	// max allowed useragent length + last block 4 bytes.
-	return 32 + (maxNetAddressPayload(pver) * 2) + maxVarIntPayload + MaxUserAgentLen
+	// max allowed useragent length + last block 4 bytes.  The net addresses
+	// in the version message never have a timestamp.
+	plen := 32 + (maxNetAddressPayload(pver, false) * 2) + maxVarIntPayload +
+		MaxUserAgentLen
+
+	// BIP0037Version added a relay transactions flag of 1 byte.
+	if pver >= BIP0037Version {
+		plen++
+	}
+
+	return plen
+}
+
+// NegotiateProtocolVersion returns the protocol version to use with a peer
+// given the version message sent to it and the one it sent, which is the lower
+// of the two protocol versions.  An error is returned when either protocol
+// version is not compatible with the passed minimum, such as
+// MinAcceptableProtocolVersion.  See MsgVersion.IsCompatible.
+func NegotiateProtocolVersion(local *MsgVersion, remote *MsgVersion,
+	minVersion uint32) (uint32, error) {
+
+	for _, msg := range []*MsgVersion{local, remote} {
+		if !msg.IsCompatible(minVersion) {
+			str := fmt.Sprintf("protocol version %d is lower than "+
+				"the minimum %d", msg.ProtocolVersion, minVersion)
+			return 0, messageError("NegotiateProtocolVersion",
+				ErrProtocolVersion, str)
+		}
+	}
+
+	if local.ProtocolVersion < remote.ProtocolVersion {
+		return uint32(local.ProtocolVersion), nil
+	}
+	return uint32(remote.ProtocolVersion), nil
 }
 
 // NewMsgVersion returns a new bitcoin version message that conforms to the
@@ -85,8 +85,10 @@ func TestVersion(t *testing.T) {
 	// Ensure max payload is expected value.
 	// Protocol version 4 bytes + services 8 bytes + timestamp 8 bytes +
 	// remote and local net addresses + nonce 8 bytes + length of user agent
-	// (varInt) + max allowed user agent length + last block 4 bytes.
-	wantPayload := uint32(2101)
+	// (varInt) + max allowed user agent length + last block 4 bytes +
+	// relay transactions flag 1 byte.  The net addresses do not have a
+	// timestamp.
+	wantPayload := uint32(350)
 	maxPayload := msg.MaxPayloadLength(pver)
 	if maxPayload != wantPayload {
 		t.Errorf("MaxPayloadLength: wrong max payload length for "+
@@ -104,6 +106,46 @@ func TestVersion(t *testing.T) {
 		t.Errorf("HasService: SFNodeNetwork service not set")
 	}
 
+	// Ensure checking for multiple services requires all of them to be set.
+	combined := btcwire.SFNodeNetwork | btcwire.SFNodeGetUTXO
+	if msg.HasService(combined) {
+		t.Errorf("HasService: SFNodeNetwork|SFNodeGetUTXO services " +
+			"are set")
+	}
+	msg.AddService(btcwire.SFNodeGetUTXO)
+	if msg.Services != combined {
+		t.Errorf("AddService: wrong services - got %v, want %v",
+			msg.Services, combined)
+	}
+	if !msg.HasService(combined) {
+		t.Errorf("HasService: SFNodeNetwork|SFNodeGetUTXO services " +
+			"not set")
+	}
+
+	// Ensure clearing a service only removes that service.
+	msg.ClearService(btcwire.SFNodeNetwork)
+	if msg.Services != btcwire.SFNodeGetUTXO {
+		t.Errorf("ClearService: wrong services - got %v, want %v",
+			msg.Services, btcwire.SFNodeGetUTXO)
+	}
+	msg.ClearService(combined)
+	if msg.Services != 0 {
+		t.Errorf("ClearService: wrong services - got %v, want 0",
+			msg.Services)
+	}
+
+	// Ensure setting multiple services at once works.
+	msg.SetServices(btcwire.SFNodeNetwork, btcwire.SFNodeGetUTXO)
+	if msg.Services != combined {
+		t.Errorf("SetServices: wrong services - got %v, want %v",
+			msg.Services, combined)
+	}
+	msg.SetServices()
+	if msg.Services != combined {
+		t.Errorf("SetServices: wrong services - got %v, want %v",
+			msg.Services, combined)
+	}
+
 	// Use a fake connection.
 	conn := &fakeConn{localAddr: tcpAddrMe, remoteAddr: tcpAddrYou}
 	msg, err = btcwire.NewMsgVersionFromConn(conn, nonce, userAgent, lastBlock)
@@ -146,9 +188,206 @@ func TestVersion(t *testing.T) {
 	return
 }
 
+// TestVersionUserAgentMatches tests the MsgVersion UserAgentMatches function
+// for various user agents and patterns.
+func TestVersionUserAgentMatches(t *testing.T) {
+	tests := []struct {
+		userAgent string   // User agent of the message
+		patterns  []string // Patterns to match against
+		want      bool     // Expected result
+	}{
+		// Substring matches.
+		{"/Satoshi:0.8.1/", []string{"satoshi"}, true},
+		{"/Satoshi:0.8.1/", []string{"SATOSHI:0.8"}, true},
+		{"/Satoshi:0.8.1/btcd:0.1/", []string{"/btcd:"}, true},
+		{"/Satoshi:0.8.1/", []string{"btcd"}, false},
+
+		// Glob matches against a single component.
+		{"/Satoshi:0.8.1/", []string{"satoshi:0.8.*"}, true},
+		{"/Satoshi:0.8.1/btcd:0.1/", []string{"BTCD:*"}, true},
+		{"/Satoshi:0.9.1/", []string{"satoshi:0.8.*"}, false},
+		{"/Satoshi:0.8.1/", []string{"satoshi:0.8.?"}, true},
+		{"/Satoshi:0.8.1/", []string{"satoshi:0.[0-7].*"}, false},
+
+		// Glob matches against the whole user agent.
+		{"/Satoshi:0.8.1/", []string{"/satoshi:*/"}, true},
+		{"/Satoshi:0.8.1/btcd:0.1/", []string{"/*/btcd:*/"}, true},
+		{"/Satoshi:0.8.1/btcd:0.1/", []string{"/satoshi:*/"}, false},
+
+		// Multiple patterns of which only one matches.
+		{"/Satoshi:0.8.1/", []string{"btcd", "*:0.8.1"}, true},
+
+		// Malformed pattern and no patterns.
+		{"/Satoshi:0.8.1/", []string{"satoshi[*"}, false},
+		{"/Satoshi:0.8.1/", nil, false},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		msg := btcwire.MsgVersion{UserAgent: test.userAgent}
+		got := msg.UserAgentMatches(test.patterns)
+		if got != test.want {
+			t.Errorf("UserAgentMatches #%d (%q, %v): got %v, want %v",
+				i, test.userAgent, test.patterns, got, test.want)
+			continue
+		}
+	}
+}
+
+// TestVersionIsCompatible tests the MsgVersion IsCompatible function against
+// various protocol versions and minimums.
+func TestVersionIsCompatible(t *testing.T) {
+	minVersion := btcwire.MinAcceptableProtocolVersion
+
+	tests := []struct {
+		pver int32  // Protocol version advertised by the peer
+		min  uint32 // Minimum acceptable protocol version
+		want bool   // Expected result
+	}{
+		{int32(minVersion), minVersion, true},
+		{int32(minVersion) - 1, minVersion, false},
+		{int32(btcwire.ProtocolVersion), minVersion, true},
+		{int32(btcwire.BIP0037Version) - 1, btcwire.BIP0037Version, false},
+		{int32(btcwire.BIP0037Version), btcwire.BIP0037Version, true},
+		{0, 0, true},
+		{-1, 0, false},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		msg := btcwire.MsgVersion{ProtocolVersion: test.pver}
+		if got := msg.IsCompatible(test.min); got != test.want {
+			t.Errorf("IsCompatible #%d (%d, %d): got %v, want %v", i,
+				test.pver, test.min, got, test.want)
+			continue
+		}
+	}
+}
+
+// TestVersionAddUserAgent tests the MsgVersion AddUserAgent function builds
+// BIP0014 user agents and rejects invalid components.
+func TestVersionAddUserAgent(t *testing.T) {
+	tests := []struct {
+		userAgent string   // Initial user agent
+		name      string   // Name of the added component
+		version   string   // Version of the added component
+		comments  []string // Comments of the added component
+		want      string   // Expected user agent
+		code      btcwire.ErrorCode
+		wantErr   bool
+	}{
+		{"", "btcwire", "0.1.0", nil, "/btcwire:0.1.0/", 0, false},
+		{"/Satoshi:0.8.1/", "btcwire", "0.1.0", nil,
+			"/Satoshi:0.8.1/btcwire:0.1.0/", 0, false},
+		{"/Satoshi:0.8.1", "btcwire", "0.1.0", nil,
+			"/Satoshi:0.8.1/btcwire:0.1.0/", 0, false},
+		{"", "btcwire", "0.1.0", []string{"linux", "amd64"},
+			"/btcwire:0.1.0(linux; amd64)/", 0, false},
+		{"/a:1/", "", "0.1.0", nil, "/a:1/", btcwire.ErrMalformed, true},
+		{"/a:1/", "btcwire", "", nil, "/a:1/", btcwire.ErrMalformed, true},
+		{"/a:1/", "btc/wire", "0.1.0", nil, "/a:1/",
+			btcwire.ErrMalformed, true},
+		{"/a:1/", "btcwire", "0:1", nil, "/a:1/",
+			btcwire.ErrMalformed, true},
+		{"/a:1/", "btcwire", "0.1.0", []string{"a;b"}, "/a:1/",
+			btcwire.ErrMalformed, true},
+		{"/a:1/", "btcwire", "0.1.0", []string{"(a)"}, "/a:1/",
+			btcwire.ErrMalformed, true},
+		{"/a:1/", "btcwire", "0.1.0",
+			[]string{strings.Repeat("x", btcwire.MaxUserAgentLen)},
+			"/a:1/", btcwire.ErrPayloadTooLarge, true},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		msg := btcwire.MsgVersion{UserAgent: test.userAgent}
+		err := msg.AddUserAgent(test.name, test.version,
+			test.comments...)
+		if msg.UserAgent != test.want {
+			t.Errorf("AddUserAgent #%d: wrong user agent - got %q, "+
+				"want %q", i, msg.UserAgent, test.want)
+		}
+		if !test.wantErr {
+			if err != nil {
+				t.Errorf("AddUserAgent #%d error %v", i, err)
+			}
+			continue
+		}
+
+		msgErr, ok := err.(*btcwire.MessageError)
+		if !ok {
+			t.Errorf("AddUserAgent #%d wrong error got: %v <%T>, "+
+				"want: <*btcwire.MessageError>", i, err, err)
+			continue
+		}
+		if msgErr.Code != test.code {
+			t.Errorf("AddUserAgent #%d wrong error code got: %v, "+
+				"want: %v", i, msgErr.Code, test.code)
+			continue
+		}
+	}
+}
+
+// TestNegotiateProtocolVersion tests NegotiateProtocolVersion picks the lower
+// protocol version and rejects incompatible ones.
+func TestNegotiateProtocolVersion(t *testing.T) {
+	minVersion := btcwire.MinAcceptableProtocolVersion
+	pver := int32(btcwire.ProtocolVersion)
+
+	tests := []struct {
+		local   int32  // Protocol version sent to the peer
+		remote  int32  // Protocol version sent by the peer
+		want    uint32 // Expected negotiated protocol version
+		wantErr bool
+	}{
+		{pver, pver, uint32(pver), false},
+		{pver, int32(btcwire.BIP0037Version), btcwire.BIP0037Version,
+			false},
+		{int32(minVersion), pver, minVersion, false},
+		{pver, int32(minVersion) - 1, 0, true},
+		{int32(minVersion) - 1, pver, 0, true},
+		{pver, -1, 0, true},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		local := btcwire.MsgVersion{ProtocolVersion: test.local}
+		remote := btcwire.MsgVersion{ProtocolVersion: test.remote}
+		got, err := btcwire.NegotiateProtocolVersion(&local, &remote,
+			minVersion)
+		if test.wantErr {
+			msgErr, ok := err.(*btcwire.MessageError)
+			if !ok || msgErr.Code != btcwire.ErrProtocolVersion {
+				t.Errorf("NegotiateProtocolVersion #%d wrong "+
+					"error got: %v, want code: %v", i, err,
+					btcwire.ErrProtocolVersion)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NegotiateProtocolVersion #%d error %v", i, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("NegotiateProtocolVersion #%d: got %d, want %d",
+				i, got, test.want)
+			continue
+		}
+	}
+}
+
 // TestAlertWire tests the MsgAlert wire encode and decode for various protocol
 // versions.
 func TestVersionWire(t *testing.T) {
+	// noRelayTxVersion is a copy of baseVersion with relay
+	// transactions disabled.
+	bvc := *baseVersion
+	noRelayTxVersion := &bvc
+	noRelayTxVersion.DisableRelayTx = true
+	noRelayTxVersionEncoded := make([]byte, len(baseVersionBIP0037Encoded))
+	copy(noRelayTxVersionEncoded, baseVersionBIP0037Encoded)
+	noRelayTxVersionEncoded[101] = 0x00
+
 	tests := []struct {
 		in   *btcwire.MsgVersion // Message to encode
 		out  *btcwire.MsgVersion // Expected decoded message
@@ -159,10 +398,29 @@ func TestVersionWire(t *testing.T) {
 		{
 			baseVersion,
 			baseVersion,
-			baseVersionEncoded,
+			baseVersionBIP0037Encoded,
 			btcwire.ProtocolVersion,
 		},
 
+		// Protocol version BIP0037Version with relay transactions
+		// disabled.
+		{
+			noRelayTxVersion,
+			noRelayTxVersion,
+			noRelayTxVersionEncoded,
+			btcwire.BIP0037Version,
+		},
+
+		// Protocol version BIP0037Version - 1 with relay transactions
+		// disabled.  The relay flag is not encoded, so it decodes as
+		// enabled.
+		{
+			noRelayTxVersion,
+			baseVersion,
+			baseVersionEncoded,
+			btcwire.BIP0037Version - 1,
+		},
+
 		// Protocol version BIP0035Version.
 		{
 			baseVersion,
@@ -236,10 +494,14 @@ func TestVersionWireErrors(t *testing.T) {
 	pver := uint32(60002)
 	btcwireErr := &btcwire.MessageError{}
 
-	// Copy the base version and change the user agent to exceed max limits.
+	// Copy the base version and change the user agent to exceed max limits
+	// by one byte, so it is 257 bytes long.
 	bvc := *baseVersion
 	exceedUAVer := &bvc
 	newUA := "/" + strings.Repeat("t", btcwire.MaxUserAgentLen-8+1) + ":0.0.1/"
+	if len(newUA) != 257 {
+		t.Fatalf("exceeding user agent is %d bytes, want 257", len(newUA))
+	}
 	exceedUAVer.UserAgent = newUA
 
 	// Encode the new UA length as a varint.
@@ -260,6 +522,12 @@ func TestVersionWireErrors(t *testing.T) {
 	copy(exceedUAVerEncoded[83:], []byte(newUA))
 	copy(exceedUAVerEncoded[83+len(newUA):], baseVersionEncoded[97:100])
 
+	// Copy the base version up to the user agent and claim it is larger
+	// than the max allowed without including it.  The length must be
+	// rejected before the user agent is allocated or read.
+	hugeUAVerEncoded := append([]byte{}, baseVersionEncoded[0:80]...)
+	hugeUAVerEncoded = append(hugeUAVerEncoded, 0xfe, 0x00, 0x00, 0x00, 0x02)
+
 	tests := []struct {
 		in       *btcwire.MsgVersion // Value to encode
 		buf      []byte              // Wire encoding
@@ -286,8 +554,14 @@ func TestVersionWireErrors(t *testing.T) {
 		{baseVersion, baseVersionEncoded, pver, 81, io.ErrShortWrite, io.EOF},
 		// Force error in last block.
 		{baseVersion, baseVersionEncoded, pver, 97, io.ErrShortWrite, io.EOF},
+		// Force error in relay transactions flag.
+		{baseVersion, baseVersionBIP0037Encoded,
+			btcwire.BIP0037Version, 101, io.ErrShortWrite, io.EOF},
 		// Force error due to user agent too big.
 		{exceedUAVer, exceedUAVerEncoded, pver, newLen, btcwireErr, btcwireErr},
+		// Force error due to user agent length prefix too big.
+		{baseVersion, hugeUAVerEncoded, pver, len(hugeUAVerEncoded),
+			io.ErrShortWrite, btcwireErr},
 	}
 
 	t.Logf("Running %d tests", len(tests))
@@ -333,6 +607,261 @@ func TestVersionWireErrors(t *testing.T) {
 	}
 }
 
+// TestVersionNetAddressTimestamp ensures the addresses in the version message
+// never include a timestamp on the wire regardless of the protocol version,
+// even when the addresses have one set.
+func TestVersionNetAddressTimestamp(t *testing.T) {
+	// Copy of baseVersion with timestamps set on its addresses.
+	tsVersion := *baseVersion
+	tsVersion.AddrYou.Timestamp = time.Unix(0x495fab29, 0)
+	tsVersion.AddrMe.Timestamp = time.Unix(0x495fab29, 0)
+
+	tests := []struct {
+		pver uint32 // Protocol version for wire encoding
+	}{
+		// Protocol version before the timestamp was added.
+		{btcwire.NetAddressTimeVersion - 1},
+
+		// Protocol version which added the timestamp.
+		{btcwire.NetAddressTimeVersion},
+
+		// Protocol version after the timestamp was added.
+		{btcwire.BIP0037Version - 1},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode the message to wire format and ensure the addresses
+		// do not include the timestamps.
+		var buf bytes.Buffer
+		err := tsVersion.BtcEncode(&buf, test.pver)
+		if err != nil {
+			t.Errorf("BtcEncode #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), baseVersionEncoded) {
+			t.Errorf("BtcEncode #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()),
+				spew.Sdump(baseVersionEncoded))
+			continue
+		}
+
+		// Decode the message from wire format and ensure the addresses
+		// are decoded without timestamps.
+		var msg btcwire.MsgVersion
+		rbuf := bytes.NewBuffer(baseVersionEncoded)
+		err = msg.BtcDecode(rbuf, test.pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, baseVersion) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(msg), spew.Sdump(baseVersion))
+			continue
+		}
+	}
+}
+
+// TestVersionWireTruncated tests that the MsgVersion wire decode accepts the
+// truncated version messages sent by very old peers and leaves the missing
+// fields zero-valued.
+func TestVersionWireTruncated(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	// Version message with only the fields required by all protocol
+	// versions: protocol version, services, timestamp, and remote address.
+	minVersion := &btcwire.MsgVersion{
+		ProtocolVersion: 60002,
+		Services:        btcwire.SFNodeNetwork,
+		Timestamp:       time.Unix(0x495fab29, 0),
+		AddrYou:         baseVersion.AddrYou,
+	}
+	minVersionEncoded := baseVersionEncoded[:46]
+
+	// Version message which additionally includes the local address, nonce,
+	// and user agent, but not the last block.
+	noLastBlock := *baseVersion
+	noLastBlockVersion := &noLastBlock
+	noLastBlockVersion.LastBlock = 0
+	noLastBlockEncoded := baseVersionEncoded[:97]
+
+	tests := []struct {
+		out *btcwire.MsgVersion // Expected decoded message
+		buf []byte              // Wire encoding
+	}{
+		{minVersion, minVersionEncoded},
+		{noLastBlockVersion, noLastBlockEncoded},
+		{baseVersion, baseVersionEncoded},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		var msg btcwire.MsgVersion
+		rbuf := bytes.NewBuffer(test.buf)
+		err := msg.BtcDecode(rbuf, pver)
+		if err != nil {
+			t.Errorf("BtcDecode #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(&msg, test.out) {
+			t.Errorf("BtcDecode #%d\n got: %s want: %s", i,
+				spew.Sdump(msg), spew.Sdump(test.out))
+			continue
+		}
+	}
+
+	// A truncated version message must still be rejected when it does not
+	// contain the remote address.
+	var msg btcwire.MsgVersion
+	err := msg.BtcDecode(bytes.NewBuffer(baseVersionEncoded[:30]), pver)
+	if err == nil {
+		t.Errorf("BtcDecode: did not receive expected error for " +
+			"version message without remote address")
+	}
+}
+
+// TestVersionWireGolden tests the MsgVersion wire encode and decode against
+// a hand-constructed golden vector laid out like the version messages sent by
+// bitcoind to ensure the fields are encoded in the same order and with the same
+// gating.
+func TestVersionWireGolden(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	// Version message modeled on one sent by a /Satoshi:0.9.3/ node.  The
+	// local address is the 203.0.113.0/24 documentation range (RFC 5737).
+	goldenVersion := &btcwire.MsgVersion{
+		ProtocolVersion: 70002,
+		Services:        btcwire.SFNodeNetwork,
+		Timestamp:       time.Unix(1415483324, 0),
+		AddrYou: btcwire.NetAddress{
+			Services: btcwire.SFNodeNetwork,
+			IP:       net.ParseIP("198.27.100.9"),
+			Port:     8333,
+		},
+		AddrMe: btcwire.NetAddress{
+			Services: btcwire.SFNodeNetwork,
+			IP:       net.ParseIP("203.0.113.192"),
+			Port:     8333,
+		},
+		Nonce:     0xf85379c9cb358012,
+		UserAgent: "/Satoshi:0.9.3/",
+		LastBlock: 329167,
+	}
+	goldenVersionEncoded := []byte{
+		0x72, 0x11, 0x01, 0x00, // Protocol version 70002
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // SFNodeNetwork
+		0xbc, 0x8f, 0x5e, 0x54, 0x00, 0x00, 0x00, 0x00, // 64-bit Timestamp
+		// AddrYou -- No timestamp for NetAddress in version message
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // SFNodeNetwork
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0xff, 0xff, 0xc6, 0x1b, 0x64, 0x09, // IP 198.27.100.9
+		0x20, 0x8d, // Port 8333 in big-endian
+		// AddrMe -- No timestamp for NetAddress in version message
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // SFNodeNetwork
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0xff, 0xff, 0xcb, 0x00, 0x71, 0xc0, // IP 203.0.113.192
+		0x20, 0x8d, // Port 8333 in big-endian
+		0x12, 0x80, 0x35, 0xcb, 0xc9, 0x79, 0x53, 0xf8, // Nonce
+		0x0f, // Varint for user agent length
+		0x2f, 0x53, 0x61, 0x74, 0x6f, 0x73, 0x68, 0x69,
+		0x3a, 0x30, 0x2e, 0x39, 0x2e, 0x33, 0x2f, // User agent
+		0xcf, 0x05, 0x05, 0x00, // Last block
+		0x01, // Relay transactions
+	}
+
+	// Ensure the message encodes to the golden bytes.
+	var buf bytes.Buffer
+	err := goldenVersion.BtcEncode(&buf, pver)
+	if err != nil {
+		t.Errorf("BtcEncode error %v", err)
+		return
+	}
+	if !bytes.Equal(buf.Bytes(), goldenVersionEncoded) {
+		t.Errorf("BtcEncode\n got: %s want: %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(goldenVersionEncoded))
+	}
+
+	// Ensure the golden bytes decode to the message.
+	var msg btcwire.MsgVersion
+	rbuf := bytes.NewBuffer(goldenVersionEncoded)
+	err = msg.BtcDecode(rbuf, pver)
+	if err != nil {
+		t.Errorf("BtcDecode error %v", err)
+		return
+	}
+	if !reflect.DeepEqual(&msg, goldenVersion) {
+		t.Errorf("BtcDecode\n got: %s want: %s", spew.Sdump(msg),
+			spew.Sdump(goldenVersion))
+	}
+}
+
+// TestVersionUnknownServices ensures service bits which are not known to this
+// package survive decoding and re-encoding a version message unchanged.
+func TestVersionUnknownServices(t *testing.T) {
+	pver := uint32(60002)
+
+	// SFNodeNetwork along with a bit in each of the upper bytes that has
+	// no meaning yet.
+	unknown := btcwire.ServiceFlag(1<<40 | 1<<63)
+	services := btcwire.SFNodeNetwork | unknown
+	msg := *baseVersion
+	msg.Services = services
+	msg.AddrYou.Services = services
+	encoded := append([]byte{}, baseVersionEncoded...)
+	servicesBytes := []byte{0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x80}
+	copy(encoded[4:], servicesBytes)
+	copy(encoded[20:], servicesBytes)
+
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, pver)
+	if err != nil {
+		t.Errorf("BtcEncode error %v", err)
+		return
+	}
+	if !bytes.Equal(buf.Bytes(), encoded) {
+		t.Errorf("BtcEncode\n got: %s want: %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(encoded))
+	}
+
+	var readmsg btcwire.MsgVersion
+	err = readmsg.BtcDecode(bytes.NewReader(encoded), pver)
+	if err != nil {
+		t.Errorf("BtcDecode error %v", err)
+		return
+	}
+	if !reflect.DeepEqual(&readmsg, &msg) {
+		t.Errorf("BtcDecode\n got: %s want: %s", spew.Sdump(readmsg),
+			spew.Sdump(msg))
+	}
+
+	// Ensure re-encoding the decoded message reproduces the bytes.
+	buf.Reset()
+	err = readmsg.BtcEncode(&buf, pver)
+	if err != nil {
+		t.Errorf("BtcEncode error %v", err)
+		return
+	}
+	if !bytes.Equal(buf.Bytes(), encoded) {
+		t.Errorf("BtcEncode\n got: %s want: %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(encoded))
+	}
+
+	// Ensure only the bits which are set are reported.
+	if !readmsg.HasService(btcwire.SFNodeNetwork) ||
+		!readmsg.HasService(unknown) || !readmsg.HasService(1<<63) {
+
+		t.Errorf("HasService: set services not reported - got %v",
+			readmsg.Services)
+	}
+	if readmsg.HasService(btcwire.SFNodeGetUTXO) ||
+		readmsg.HasService(1<<62) {
+
+		t.Errorf("HasService: unset services reported - got %v",
+			readmsg.Services)
+	}
+}
+
 // baseVersion is used in the various tests as a baseline MsgVersion.
 var baseVersion *btcwire.MsgVersion = &btcwire.MsgVersion{
 	ProtocolVersion: 60002,
@@ -377,3 +906,9 @@ var baseVersionEncoded []byte = []byte{
 	0x74, 0x3a, 0x30, 0x2e, 0x30, 0x2e, 0x31, 0x2f, // User agent
 	0xfa, 0x92, 0x03, 0x00, // Last block
 }
+
+// baseVersionBIP0037Encoded is the wire encoded bytes for baseVersion using
+// protocol version BIP0037Version and is used in the various tests.
+var baseVersionBIP0037Encoded = append(append([]byte{}, baseVersionEncoded...),
+	0x01, // Relay transactions
+)
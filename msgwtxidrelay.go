@@ -0,0 +1,67 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"fmt"
+	"io"
+)
+
+// MsgWTxIDRelay implements the Message interface and represents a bitcoin
+// wtxidrelay message.  It is used during the version handshake, prior to the
+// verack message (MsgVerAck), to signal that a peer supports announcing and
+// requesting transactions by their witness transaction id as defined by
+// BIP0339.
+//
+// This message has no payload and was not added until protocol versions
+// starting with WTxIDRelayVersion.
+type MsgWTxIDRelay struct{}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgWTxIDRelay) BtcDecode(r io.Reader, pver uint32) error {
+	if pver < WTxIDRelayVersion {
+		str := fmt.Sprintf("wtxidrelay message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgWTxIDRelay.BtcDecode", ErrProtocolVersion, str)
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgWTxIDRelay) BtcEncode(w io.Writer, pver uint32) error {
+	if pver < WTxIDRelayVersion {
+		str := fmt.Sprintf("wtxidrelay message invalid for protocol "+
+			"version %d", pver)
+		return messageError("MsgWTxIDRelay.BtcEncode", ErrProtocolVersion, str)
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgWTxIDRelay) Command() string {
+	return cmdWTxIDRelay
+}
+
+// String returns the message in human-readable form.
+func (msg *MsgWTxIDRelay) String() string {
+	return msg.Command()
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgWTxIDRelay) MaxPayloadLength(pver uint32) uint32 {
+	return 0
+}
+
+// NewMsgWTxIDRelay returns a new bitcoin wtxidrelay message that conforms to
+// the Message interface.  See MsgWTxIDRelay for details.
+func NewMsgWTxIDRelay() *MsgWTxIDRelay {
+	return &MsgWTxIDRelay{}
+}
@@ -5,10 +5,10 @@
 package btcwire
 
 import (
-	"encoding/binary"
 	"errors"
 	"io"
 	"net"
+	"strconv"
 	"time"
 )
 
@@ -16,14 +16,37 @@ import (
 // a TCP address as required.
 var ErrInvalidNetAddr = errors.New("provided net.Addr is not a net.TCPAddr")
 
+const (
+	// minNetAddressTimestamp is the earliest timestamp an address may have
+	// before it is considered bogus by ClampTimestamp.  It matches the
+	// reference implementation.
+	minNetAddressTimestamp = 100000000
+
+	// bogusNetAddressAge is how far in the past ClampTimestamp sets the
+	// timestamp of an address with a bogus timestamp.  It matches the
+	// reference implementation.
+	bogusNetAddressAge = 5 * 24 * time.Hour
+)
+
+// netAddressHasTimestamp returns whether or not an encoded NetAddress includes
+// the timestamp for the passed protocol version and whether or not the context
+// includes it per ts.  The timestamp is only included in contexts such as the
+// addr message (MsgAddr), never in the version message (MsgVersion), and only
+// for protocol versions >= NetAddressTimeVersion.  This is the single source of
+// truth for the decision used by both the encoding and decoding.
+func netAddressHasTimestamp(pver uint32, ts bool) bool {
+	return ts && pver >= NetAddressTimeVersion
+}
+
 // maxNetAddressPayload returns the max payload size for a bitcoin NetAddress
-// based on the protocol version.
-func maxNetAddressPayload(pver uint32) uint32 {
+// based on the protocol version and whether or not the timestamp is encoded.
+// See readNetAddress for the meaning of ts.
+func maxNetAddressPayload(pver uint32, ts bool) uint32 {
 	// Services 8 bytes + ip 16 bytes + port 2 bytes.
 	plen := uint32(26)
 
 	// NetAddressTimeVersion added a timestamp field.
-	if pver >= NetAddressTimeVersion {
+	if netAddressHasTimestamp(pver, ts) {
 		// Timestamp 4 bytes.
 		plen += 4
 	}
@@ -72,6 +95,49 @@ func (na *NetAddress) SetAddress(ip net.IP, port uint16) {
 	na.Port = port
 }
 
+// Key returns a string of the form ip:port which uniquely identifies the
+// address and is suitable for use as a map key.  IPv4 addresses are normalized
+// so the same key is returned regardless of whether the IP is stored in its
+// 4-byte or IPv4-mapped IPv6 16-byte form.
+func (na *NetAddress) Key() string {
+	port := strconv.FormatUint(uint64(na.Port), 10)
+	return net.JoinHostPort(na.IP.To16().String(), port)
+}
+
+// String returns the IP address and port of the address in the human-readable
+// form ip:port.
+func (na *NetAddress) String() string {
+	port := strconv.FormatUint(uint64(na.Port), 10)
+	return net.JoinHostPort(na.IP.String(), port)
+}
+
+// Equal returns whether na and other refer to the same IP address and port.
+// The timestamp and services are not considered.
+func (na *NetAddress) Equal(other *NetAddress) bool {
+	return na.Port == other.Port && na.IP.Equal(other.IP)
+}
+
+// IsRecent returns whether the address was last seen no more than maxAge before
+// now.  Addresses with a timestamp after now are considered recent, so
+// ClampTimestamp should be used first when the timestamp came from a peer.
+func (na *NetAddress) IsRecent(now time.Time, maxAge time.Duration) bool {
+	return !na.Timestamp.Before(now.Add(-maxAge))
+}
+
+// ClampTimestamp normalizes the timestamp of an address received from a peer
+// so it can't be used to poison an address book.  A timestamp after now is
+// capped to now, and an absurdly old one, as the reference implementation
+// defines it, is set to five days before now so the address is treated as
+// old but not discarded.
+func (na *NetAddress) ClampTimestamp(now time.Time) {
+	switch {
+	case na.Timestamp.After(now):
+		na.Timestamp = now
+	case na.Timestamp.Unix() <= minNetAddressTimestamp:
+		na.Timestamp = now.Add(-bogusNetAddressAge)
+	}
+}
+
 // NewNetAddress returns a new NetAddress using the provided TCP address and
 // supported services with defaults for the remaining fields.
 //
@@ -104,7 +170,7 @@ func readNetAddress(r io.Reader, pver uint32, na *NetAddress, ts bool) error {
 	// NOTE: The bitcoin protocol uses a uint32 for the timestamp so it will
 	// stop working somewhere around 2106.  Also timestamp wasn't added until
 	// protocol version >= NetAddressTimeVersion
-	if ts && pver >= NetAddressTimeVersion {
+	if netAddressHasTimestamp(pver, ts) {
 		var stamp uint32
 		err := readElement(r, &stamp)
 		if err != nil {
@@ -117,8 +183,12 @@ func readNetAddress(r io.Reader, pver uint32, na *NetAddress, ts bool) error {
 	if err != nil {
 		return err
 	}
+	err = checkServices(r, services, "readNetAddress")
+	if err != nil {
+		return err
+	}
 	// Sigh.  Bitcoin protocol mixes little and big endian.
-	err = binary.Read(r, binary.BigEndian, &port)
+	port, err = readPort(r)
 	if err != nil {
 		return err
 	}
@@ -136,7 +206,7 @@ func writeNetAddress(w io.Writer, pver uint32, na *NetAddress, ts bool) error {
 	// NOTE: The bitcoin protocol uses a uint32 for the timestamp so it will
 	// stop working somewhere around 2106.  Also timestamp wasn't added until
 	// until protocol version >= NetAddressTimeVersion.
-	if ts && pver >= NetAddressTimeVersion {
+	if netAddressHasTimestamp(pver, ts) {
 		err := writeElement(w, uint32(na.Timestamp.Unix()))
 		if err != nil {
 			return err
@@ -154,10 +224,25 @@ func writeNetAddress(w io.Writer, pver uint32, na *NetAddress, ts bool) error {
 	}
 
 	// Sigh.  Bitcoin protocol mixes little and big endian.
-	err = binary.Write(w, binary.BigEndian, na.Port)
+	err = writePort(w, na.Port)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// readPort reads the big endian port of a network address from r.
+func readPort(r io.Reader) (uint16, error) {
+	rv, err := readUint(r, 2)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(rv)>>8 | uint16(rv)<<8, nil
+}
+
+// writePort writes port to w in the big endian byte order used for the port
+// of a network address.
+func writePort(w io.Writer, port uint16) error {
+	return writeUint(w, uint64(port>>8|port<<8), 2)
+}
@@ -86,6 +86,175 @@ func TestNetAddress(t *testing.T) {
 	}
 }
 
+// TestNetAddressKey tests the NetAddress Key and Equal functions.
+func TestNetAddressKey(t *testing.T) {
+	ipv4 := &btcwire.NetAddress{
+		Timestamp: time.Unix(0x495fab29, 0),
+		Services:  btcwire.SFNodeNetwork,
+		IP:        net.IPv4(127, 0, 0, 1).To4(),
+		Port:      8333,
+	}
+	ipv4Mapped := &btcwire.NetAddress{
+		IP:   net.ParseIP("::ffff:127.0.0.1"),
+		Port: 8333,
+	}
+	otherPort := &btcwire.NetAddress{
+		IP:   net.ParseIP("127.0.0.1"),
+		Port: 18333,
+	}
+	ipv6 := &btcwire.NetAddress{
+		IP:   net.ParseIP("2001:db8::1"),
+		Port: 8333,
+	}
+
+	tests := []struct {
+		na    *btcwire.NetAddress // Address to test
+		key   string              // Expected key
+		other *btcwire.NetAddress // Address to compare against
+		equal bool                // Expected equality
+	}{
+		{ipv4, "127.0.0.1:8333", ipv4Mapped, true},
+		{ipv4Mapped, "127.0.0.1:8333", ipv4, true},
+		{otherPort, "127.0.0.1:18333", ipv4, false},
+		{ipv6, "[2001:db8::1]:8333", ipv4, false},
+		{ipv6, "[2001:db8::1]:8333", ipv6, true},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		if key := test.na.Key(); key != test.key {
+			t.Errorf("Key #%d: wrong key - got %v, want %v", i, key,
+				test.key)
+			continue
+		}
+		if equal := test.na.Equal(test.other); equal != test.equal {
+			t.Errorf("Equal #%d: got %v, want %v", i, equal,
+				test.equal)
+			continue
+		}
+	}
+}
+
+// TestNetAddressStringer tests the stringized output for network addresses.
+func TestNetAddressStringer(t *testing.T) {
+	tests := []struct {
+		in   *btcwire.NetAddress
+		want string
+	}{
+		{&btcwire.NetAddress{IP: net.IPv4(127, 0, 0, 1), Port: 8333},
+			"127.0.0.1:8333"},
+		{&btcwire.NetAddress{IP: net.ParseIP("2001:db8::1"), Port: 18333},
+			"[2001:db8::1]:18333"},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		result := test.in.String()
+		if result != test.want {
+			t.Errorf("String #%d\n got: %s want: %s", i, result,
+				test.want)
+			continue
+		}
+	}
+}
+
+// TestNetAddressRecency tests the NetAddress IsRecent and ClampTimestamp
+// functions.
+func TestNetAddressRecency(t *testing.T) {
+	now := time.Unix(0x5f5e1000, 0)
+	maxAge := 3 * time.Hour
+	bogus := now.Add(-5 * 24 * time.Hour)
+
+	tests := []struct {
+		timestamp time.Time // Timestamp of the address
+		recent    bool      // Expected recency before clamping
+		clamped   time.Time // Expected timestamp after clamping
+	}{
+		// Seen now.
+		{now, true, now},
+		// Seen exactly the max age ago.
+		{now.Add(-maxAge), true, now.Add(-maxAge)},
+		// Seen just over the max age ago.
+		{now.Add(-maxAge - time.Second), false, now.Add(-maxAge - time.Second)},
+		// Timestamp in the future is capped to now.
+		{now.Add(time.Hour), true, now},
+		// Absurdly old timestamps are set to five days ago.
+		{time.Unix(100000000, 0), false, bogus},
+		{time.Unix(0, 0), false, bogus},
+		// Old but plausible timestamps are left untouched.
+		{time.Unix(100000001, 0), false, time.Unix(100000001, 0)},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		na := btcwire.NetAddress{Timestamp: test.timestamp}
+		if recent := na.IsRecent(now, maxAge); recent != test.recent {
+			t.Errorf("IsRecent #%d: got %v, want %v", i, recent,
+				test.recent)
+			continue
+		}
+
+		na.ClampTimestamp(now)
+		if !na.Timestamp.Equal(test.clamped) {
+			t.Errorf("ClampTimestamp #%d: got %v, want %v", i,
+				na.Timestamp, test.clamped)
+			continue
+		}
+	}
+}
+
+// TestNetAddressPortByteOrder ensures the port of an address is encoded in
+// big endian identically in both the addr message, where the address has a
+// timestamp, and the version message, where it does not.
+func TestNetAddressPortByteOrder(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	na := &btcwire.NetAddress{
+		Timestamp: time.Unix(0x495fab29, 0),
+		Services:  btcwire.SFNodeNetwork,
+		IP:        net.ParseIP("127.0.0.1"),
+		Port:      0x208d, // 8333
+	}
+
+	// Address without the timestamp as golden bytes.
+	wantAddr := []byte{
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // SFNodeNetwork
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0xff, 0xff, 0x7f, 0x00, 0x00, 0x01, // IP 127.0.0.1
+		0x20, 0x8d, // Port 8333 in big-endian
+	}
+
+	// The address in an addr message follows the count and timestamp.
+	addrMsg := btcwire.NewMsgAddr()
+	addrMsg.AddAddress(na)
+	var addrBuf bytes.Buffer
+	err := addrMsg.BtcEncode(&addrBuf, pver)
+	if err != nil {
+		t.Fatalf("MsgAddr.BtcEncode: %v", err)
+	}
+	gotAddr := addrBuf.Bytes()[5 : 5+len(wantAddr)]
+	if !bytes.Equal(gotAddr, wantAddr) {
+		t.Errorf("MsgAddr.BtcEncode: wrong address encoding\n got: %s "+
+			"want: %s", spew.Sdump(gotAddr), spew.Sdump(wantAddr))
+	}
+
+	// The remote and local addresses in a version message follow the
+	// protocol version, services, and timestamp.
+	verMsg := btcwire.NewMsgVersion(na, na, 0, "", 0)
+	var verBuf bytes.Buffer
+	err = verMsg.BtcEncode(&verBuf, pver)
+	if err != nil {
+		t.Fatalf("MsgVersion.BtcEncode: %v", err)
+	}
+	for i, offset := range []int{20, 20 + len(wantAddr)} {
+		gotAddr := verBuf.Bytes()[offset : offset+len(wantAddr)]
+		if !bytes.Equal(gotAddr, wantAddr) {
+			t.Errorf("MsgVersion.BtcEncode #%d: wrong address "+
+				"encoding\n got: %s want: %s", i,
+				spew.Sdump(gotAddr), spew.Sdump(wantAddr))
+		}
+	}
+}
+
 // TestNetAddressWire tests the NetAddress wire encode and decode for various
 // protocol versions and timestamp flag combinations.
 func TestNetAddressWire(t *testing.T) {
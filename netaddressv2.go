@@ -0,0 +1,263 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// maxNetAddressV2AddrSize is the maximum number of bytes an address of any
+// network may have in a NetAddressV2 as defined by BIP0155.
+const maxNetAddressV2AddrSize = 512
+
+// maxNetAddressV2Payload is the max payload size for a bitcoin NetAddressV2.
+// Timestamp 4 bytes + services (varInt) + network id 1 byte + address length
+// (varInt) + max address + port 2 bytes.
+const maxNetAddressV2Payload = 4 + maxVarIntPayload + 1 + 3 +
+	maxNetAddressV2AddrSize + 2
+
+// AddrNetworkID identifies the network of an address in a NetAddressV2 as
+// defined by BIP0155.
+type AddrNetworkID uint8
+
+// Constants used to indicate the network of a NetAddressV2.
+const (
+	AddrNetIPv4  AddrNetworkID = 1
+	AddrNetIPv6  AddrNetworkID = 2
+	AddrNetTorV2 AddrNetworkID = 3
+	AddrNetTorV3 AddrNetworkID = 4
+	AddrNetI2P   AddrNetworkID = 5
+	AddrNetCJDNS AddrNetworkID = 6
+)
+
+// Map of address network ids back to their names for pretty printing.
+var anStrings = map[AddrNetworkID]string{
+	AddrNetIPv4:  "IPV4",
+	AddrNetIPv6:  "IPV6",
+	AddrNetTorV2: "TORV2",
+	AddrNetTorV3: "TORV3",
+	AddrNetI2P:   "I2P",
+	AddrNetCJDNS: "CJDNS",
+}
+
+// Map of address network ids to the required length of their addresses.
+var anAddrSizes = map[AddrNetworkID]int{
+	AddrNetIPv4:  4,
+	AddrNetIPv6:  16,
+	AddrNetTorV2: 10,
+	AddrNetTorV3: 32,
+	AddrNetI2P:   32,
+	AddrNetCJDNS: 16,
+}
+
+// String returns the AddrNetworkID in human-readable form.
+func (id AddrNetworkID) String() string {
+	if s, ok := anStrings[id]; ok {
+		return s
+	}
+
+	return fmt.Sprintf("Unknown AddrNetworkID (%d)", uint8(id))
+}
+
+// onionCatPrefix is the IPv6 prefix used to encode Tor v2 addresses as IPv6
+// addresses in the legacy address format.
+var onionCatPrefix = []byte{0xfd, 0x87, 0xd8, 0x7e, 0xeb, 0x43}
+
+// NetAddressV2 defines information about a peer on the network including the
+// time it was last seen, the services it supports, its address, and port, as
+// defined by BIP0155.  Unlike NetAddress, the address may be of a network such
+// as Tor v3 or I2P which can't be represented as an IP address.
+type NetAddressV2 struct {
+	// Last time the address was seen.  This is, unfortunately, encoded as a
+	// uint32 on the wire and therefore is limited to 2106.
+	Timestamp time.Time
+
+	// Bitfield which identifies the services supported by the address.
+	Services ServiceFlag
+
+	// Network of the address.
+	NetworkID AddrNetworkID
+
+	// Address of the peer encoded as defined by BIP0155 for the network.
+	Addr []byte
+
+	// Port the peer is using.  This is encoded in big endian on the wire
+	// which differs from most everything else.
+	Port uint16
+}
+
+// HasService returns whether the specified service is supported by the address.
+func (na *NetAddressV2) HasService(service ServiceFlag) bool {
+	return na.Services&service == service
+}
+
+// AddService adds service as a supported service by the peer generating the
+// message.
+func (na *NetAddressV2) AddService(service ServiceFlag) {
+	na.Services |= service
+}
+
+// ToLegacy converts the address to a NetAddress which can be sent in a legacy
+// addr message (MsgAddr).  IPv4 and IPv6 addresses are converted directly and
+// Tor v2 addresses use the OnionCat IPv6 encoding.  It returns false for all
+// other networks since their addresses can't be represented.
+func (na *NetAddressV2) ToLegacy() (*NetAddress, bool) {
+	if len(na.Addr) != anAddrSizes[na.NetworkID] {
+		return nil, false
+	}
+
+	var ip net.IP
+	switch na.NetworkID {
+	case AddrNetIPv4, AddrNetIPv6:
+		ip = net.IP(na.Addr).To16()
+
+	case AddrNetTorV2:
+		ip = make(net.IP, 0, net.IPv6len)
+		ip = append(ip, onionCatPrefix...)
+		ip = append(ip, na.Addr...)
+
+	default:
+		return nil, false
+	}
+
+	return &NetAddress{
+		Timestamp: na.Timestamp,
+		Services:  na.Services,
+		IP:        ip,
+		Port:      na.Port,
+	}, true
+}
+
+// NewNetAddressV2FromNetAddress returns a new NetAddressV2 for the passed
+// legacy NetAddress.  IPv4-mapped IPv6 addresses are converted to IPv4 and
+// OnionCat encoded IPv6 addresses are converted to Tor v2 as required by
+// BIP0155.
+func NewNetAddressV2FromNetAddress(na *NetAddress) *NetAddressV2 {
+	nav2 := NetAddressV2{
+		Timestamp: na.Timestamp,
+		Services:  na.Services,
+		Port:      na.Port,
+	}
+
+	ip := na.IP.To16()
+	switch {
+	case na.IP.To4() != nil:
+		nav2.NetworkID = AddrNetIPv4
+		nav2.Addr = []byte(na.IP.To4())
+
+	case ip != nil && bytes.HasPrefix(ip, onionCatPrefix):
+		nav2.NetworkID = AddrNetTorV2
+		nav2.Addr = append([]byte{}, ip[len(onionCatPrefix):]...)
+
+	default:
+		// Ensure to always use 16 bytes even if the ip is nil.
+		nav2.NetworkID = AddrNetIPv6
+		nav2.Addr = make([]byte, net.IPv6len)
+		copy(nav2.Addr, ip)
+	}
+
+	return &nav2
+}
+
+// readNetAddressV2 reads an encoded NetAddressV2 from r depending on the
+// protocol version.  Addresses of unknown networks are read as is so they can
+// be ignored by the caller as required by BIP0155.
+func readNetAddressV2(r io.Reader, pver uint32, na *NetAddressV2) error {
+	// NOTE: The bitcoin protocol uses a uint32 for the timestamp so it will
+	// stop working somewhere around 2106.
+	var stamp uint32
+	err := readElement(r, &stamp)
+	if err != nil {
+		return err
+	}
+
+	services, err := readVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	err = checkServices(r, ServiceFlag(services), "readNetAddressV2")
+	if err != nil {
+		return err
+	}
+
+	var networkID AddrNetworkID
+	err = readElement(r, &networkID)
+	if err != nil {
+		return err
+	}
+
+	addr, err := readVarBytes(r, pver, maxNetAddressV2AddrSize,
+		"network address")
+	if err != nil {
+		return err
+	}
+	if size, ok := anAddrSizes[networkID]; ok && len(addr) != size {
+		str := fmt.Sprintf("invalid address length for network %v "+
+			"[len %v, want %v]", networkID, len(addr), size)
+		return messageError("readNetAddressV2", ErrMalformed, str)
+	}
+
+	// Sigh.  Bitcoin protocol mixes little and big endian.
+	port, err := readPort(r)
+	if err != nil {
+		return err
+	}
+
+	na.Timestamp = time.Unix(int64(stamp), 0)
+	na.Services = ServiceFlag(services)
+	na.NetworkID = networkID
+	na.Addr = addr
+	na.Port = port
+	return nil
+}
+
+// writeNetAddressV2 serializes a NetAddressV2 to w depending on the protocol
+// version.
+func writeNetAddressV2(w io.Writer, pver uint32, na *NetAddressV2) error {
+	if len(na.Addr) > maxNetAddressV2AddrSize {
+		str := fmt.Sprintf("network address too long [len %v, max %v]",
+			len(na.Addr), maxNetAddressV2AddrSize)
+		return messageError("writeNetAddressV2", ErrPayloadTooLarge, str)
+	}
+	if size, ok := anAddrSizes[na.NetworkID]; ok && len(na.Addr) != size {
+		str := fmt.Sprintf("invalid address length for network %v "+
+			"[len %v, want %v]", na.NetworkID, len(na.Addr), size)
+		return messageError("writeNetAddressV2", ErrMalformed, str)
+	}
+
+	// NOTE: The bitcoin protocol uses a uint32 for the timestamp so it will
+	// stop working somewhere around 2106.
+	err := writeElement(w, uint32(na.Timestamp.Unix()))
+	if err != nil {
+		return err
+	}
+
+	err = writeVarInt(w, pver, uint64(na.Services))
+	if err != nil {
+		return err
+	}
+
+	err = writeElement(w, na.NetworkID)
+	if err != nil {
+		return err
+	}
+
+	err = writeVarBytes(w, pver, na.Addr)
+	if err != nil {
+		return err
+	}
+
+	// Sigh.  Bitcoin protocol mixes little and big endian.
+	err = writePort(w, na.Port)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
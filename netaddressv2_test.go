@@ -0,0 +1,340 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestAddrNetworkIDStringer tests the stringized output for address network
+// ids.
+func TestAddrNetworkIDStringer(t *testing.T) {
+	tests := []struct {
+		in   btcwire.AddrNetworkID
+		want string
+	}{
+		{btcwire.AddrNetIPv4, "IPV4"},
+		{btcwire.AddrNetIPv6, "IPV6"},
+		{btcwire.AddrNetTorV2, "TORV2"},
+		{btcwire.AddrNetTorV3, "TORV3"},
+		{btcwire.AddrNetI2P, "I2P"},
+		{btcwire.AddrNetCJDNS, "CJDNS"},
+		{0xff, "Unknown AddrNetworkID (255)"},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		result := test.in.String()
+		if result != test.want {
+			t.Errorf("String #%d\n got: %s want: %s", i, result,
+				test.want)
+			continue
+		}
+	}
+}
+
+// TestNetAddressV2Wire tests the NetAddressV2 wire encode and decode for
+// addresses of various networks.
+func TestNetAddressV2Wire(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	tests := []struct {
+		in  btcwire.NetAddressV2 // NetAddressV2 to encode
+		buf []byte               // Wire encoding
+	}{
+		// IPv4 address.
+		{
+			baseNetAddrV2,
+			baseNetAddrV2Encoded,
+		},
+
+		// Tor v3 address.
+		{
+			torV3NetAddrV2,
+			torV3NetAddrV2Encoded,
+		},
+
+		// Address of an unknown network is preserved as is.
+		{
+			btcwire.NetAddressV2{
+				Timestamp: time.Unix(0x495fab29, 0),
+				Services:  0,
+				NetworkID: 0x99,
+				Addr:      []byte{0x01, 0x02, 0x03},
+				Port:      1,
+			},
+			[]byte{
+				0x29, 0xab, 0x5f, 0x49, // Timestamp
+				0x00,             // Services
+				0x99,             // Unknown network id
+				0x03,             // Address length
+				0x01, 0x02, 0x03, // Address
+				0x00, 0x01, // Port 1 in big-endian
+			},
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		var buf bytes.Buffer
+		err := btcwire.TstWriteNetAddressV2(&buf, pver, &test.in)
+		if err != nil {
+			t.Errorf("writeNetAddressV2 #%d error %v", i, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), test.buf) {
+			t.Errorf("writeNetAddressV2 #%d\n got: %s want: %s", i,
+				spew.Sdump(buf.Bytes()), spew.Sdump(test.buf))
+			continue
+		}
+
+		// Decode the message from wire format.
+		var na btcwire.NetAddressV2
+		rbuf := bytes.NewReader(test.buf)
+		err = btcwire.TstReadNetAddressV2(rbuf, pver, &na)
+		if err != nil {
+			t.Errorf("readNetAddressV2 #%d error %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(na, test.in) {
+			t.Errorf("readNetAddressV2 #%d\n got: %s want: %s", i,
+				spew.Sdump(na), spew.Sdump(test.in))
+			continue
+		}
+	}
+}
+
+// TestNetAddressV2WireErrors performs negative tests against wire encode and
+// decode of NetAddressV2 to confirm error paths work correctly.
+func TestNetAddressV2WireErrors(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+
+	// Tor v3 address with a short address.
+	badLenNetAddr := torV3NetAddrV2
+	badLenNetAddr.Addr = badLenNetAddr.Addr[:31]
+	badLenEncoded := append([]byte{}, torV3NetAddrV2Encoded[:6]...)
+	badLenEncoded = append(badLenEncoded, 0x1f)
+	badLenEncoded = append(badLenEncoded, torV3NetAddrV2Encoded[7:]...)
+
+	// Address which exceeds the maximum allowed size.
+	tooLongNetAddr := baseNetAddrV2
+	tooLongNetAddr.NetworkID = 0x99
+	tooLongNetAddr.Addr = make([]byte, 513)
+	tooLongEncoded := []byte{
+		0x29, 0xab, 0x5f, 0x49, // Timestamp
+		0x01,             // Services
+		0x99,             // Unknown network id
+		0xfd, 0x01, 0x02, // Address length 513
+	}
+
+	tests := []struct {
+		in       *btcwire.NetAddressV2 // Value to encode
+		buf      []byte                // Wire encoding
+		max      int                   // Max size of fixed buffer to induce errors
+		writeErr error                 // Expected write error
+		readErr  error                 // Expected read error
+	}{
+		// Force errors on timestamp.
+		{&baseNetAddrV2, baseNetAddrV2Encoded, 0, io.ErrShortWrite, io.EOF},
+		// Force errors on services.
+		{&baseNetAddrV2, baseNetAddrV2Encoded, 4, io.ErrShortWrite, io.EOF},
+		// Force errors on network id.
+		{&baseNetAddrV2, baseNetAddrV2Encoded, 5, io.ErrShortWrite, io.EOF},
+		// Force errors on address length.
+		{&baseNetAddrV2, baseNetAddrV2Encoded, 6, io.ErrShortWrite, io.EOF},
+		// Force errors on address.
+		{&baseNetAddrV2, baseNetAddrV2Encoded, 7, io.ErrShortWrite, io.EOF},
+		// Force errors on port.
+		{&baseNetAddrV2, baseNetAddrV2Encoded, 11, io.ErrShortWrite, io.EOF},
+		// Address length which does not match the network.
+		{
+			&badLenNetAddr, badLenEncoded, len(badLenEncoded),
+			&btcwire.MessageError{}, &btcwire.MessageError{},
+		},
+		// Address which exceeds the max allowed size.
+		{
+			&tooLongNetAddr, tooLongEncoded, len(tooLongEncoded),
+			&btcwire.MessageError{}, &btcwire.MessageError{},
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := btcwire.TstWriteNetAddressV2(w, pver, test.in)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("writeNetAddressV2 #%d wrong error got: %v, "+
+				"want: %v", i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("writeNetAddressV2 #%d wrong error got: "+
+					"%v, want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var na btcwire.NetAddressV2
+		r := newFixedReader(test.max, test.buf)
+		err = btcwire.TstReadNetAddressV2(r, pver, &na)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("readNetAddressV2 #%d wrong error got: %v, "+
+				"want: %v", i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type btcwire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*btcwire.MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("readNetAddressV2 #%d wrong error got: "+
+					"%v, want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
+
+// TestNetAddressV2Legacy tests the conversion between NetAddressV2 and the
+// legacy NetAddress.
+func TestNetAddressV2Legacy(t *testing.T) {
+	ts := time.Unix(0x495fab29, 0)
+	onionCatIP := net.ParseIP("fd87:d87e:eb43:102:304:506:708:90a")
+	torV2Addr := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x09, 0x0a}
+
+	tests := []struct {
+		name   string
+		legacy *btcwire.NetAddress   // Legacy address
+		v2     *btcwire.NetAddressV2 // Equivalent NetAddressV2
+	}{
+		{
+			"ipv4",
+			&btcwire.NetAddress{Timestamp: ts, Services: 1,
+				IP: net.ParseIP("127.0.0.1"), Port: 8333},
+			&btcwire.NetAddressV2{Timestamp: ts, Services: 1,
+				NetworkID: btcwire.AddrNetIPv4,
+				Addr:      []byte{0x7f, 0x00, 0x00, 0x01}, Port: 8333},
+		},
+		{
+			"ipv6",
+			&btcwire.NetAddress{Timestamp: ts, Services: 1,
+				IP: net.ParseIP("2001:db8::1"), Port: 8333},
+			&btcwire.NetAddressV2{Timestamp: ts, Services: 1,
+				NetworkID: btcwire.AddrNetIPv6,
+				Addr:      []byte(net.ParseIP("2001:db8::1")),
+				Port:      8333},
+		},
+		{
+			"tor v2",
+			&btcwire.NetAddress{Timestamp: ts, Services: 1,
+				IP: onionCatIP, Port: 8333},
+			&btcwire.NetAddressV2{Timestamp: ts, Services: 1,
+				NetworkID: btcwire.AddrNetTorV2, Addr: torV2Addr,
+				Port: 8333},
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		v2 := btcwire.NewNetAddressV2FromNetAddress(test.legacy)
+		if !reflect.DeepEqual(v2, test.v2) {
+			t.Errorf("NewNetAddressV2FromNetAddress #%d (%s)\n got: %s "+
+				"want: %s", i, test.name, spew.Sdump(v2),
+				spew.Sdump(test.v2))
+			continue
+		}
+
+		legacy, ok := test.v2.ToLegacy()
+		if !ok {
+			t.Errorf("ToLegacy #%d (%s) unexpected failure", i,
+				test.name)
+			continue
+		}
+		if !legacy.Equal(test.legacy) || legacy.Services != 1 ||
+			!legacy.Timestamp.Equal(ts) {
+
+			t.Errorf("ToLegacy #%d (%s)\n got: %s want: %s", i,
+				test.name, spew.Sdump(legacy),
+				spew.Sdump(test.legacy))
+			continue
+		}
+	}
+
+	// Ensure addresses which can't be represented are not converted.
+	unrepresentable := []btcwire.NetAddressV2{
+		torV3NetAddrV2,
+		{NetworkID: btcwire.AddrNetI2P, Addr: make([]byte, 32)},
+		{NetworkID: btcwire.AddrNetCJDNS, Addr: make([]byte, 16)},
+		{NetworkID: 0x99, Addr: make([]byte, 4)},
+		{NetworkID: btcwire.AddrNetIPv4, Addr: make([]byte, 3)},
+	}
+	for i, na := range unrepresentable {
+		if legacy, ok := na.ToLegacy(); ok {
+			t.Errorf("ToLegacy #%d unexpected conversion of %v "+
+				"address to %v", i, na.NetworkID, legacy.IP)
+		}
+	}
+}
+
+// baseNetAddrV2 is used in the various tests as a baseline NetAddressV2.
+var baseNetAddrV2 = btcwire.NetAddressV2{
+	Timestamp: time.Unix(0x495fab29, 0), // 2009-01-03 12:15:05 -0600 CST
+	Services:  btcwire.SFNodeNetwork,
+	NetworkID: btcwire.AddrNetIPv4,
+	Addr:      []byte{0x7f, 0x00, 0x00, 0x01},
+	Port:      8333,
+}
+
+// baseNetAddrV2Encoded is the wire encoded bytes of baseNetAddrV2.
+var baseNetAddrV2Encoded = []byte{
+	0x29, 0xab, 0x5f, 0x49, // Timestamp
+	0x01,                   // Varint for SFNodeNetwork
+	0x01,                   // Network id IPv4
+	0x04,                   // Address length
+	0x7f, 0x00, 0x00, 0x01, // IP 127.0.0.1
+	0x20, 0x8d, // Port 8333 in big-endian
+}
+
+// torV3NetAddrV2 is a NetAddressV2 with a Tor v3 address.
+var torV3NetAddrV2 = btcwire.NetAddressV2{
+	Timestamp: time.Unix(0x495fab29, 0), // 2009-01-03 12:15:05 -0600 CST
+	Services:  btcwire.SFNodeNetwork | btcwire.SFNodeGetUTXO,
+	NetworkID: btcwire.AddrNetTorV3,
+	Addr: []byte{
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+		0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10,
+		0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18,
+		0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20,
+	},
+	Port: 9050,
+}
+
+// torV3NetAddrV2Encoded is the wire encoded bytes of torV3NetAddrV2.
+var torV3NetAddrV2Encoded = []byte{
+	0x29, 0xab, 0x5f, 0x49, // Timestamp
+	0x03, // Varint for SFNodeNetwork|SFNodeGetUTXO
+	0x04, // Network id Tor v3
+	0x20, // Address length
+	0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+	0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10,
+	0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18,
+	0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20, // Tor v3 address
+	0x23, 0x5a, // Port 9050 in big-endian
+}
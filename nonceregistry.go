@@ -0,0 +1,99 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"sync"
+	"time"
+)
+
+// NonceRegistry keeps track of the nonces of the version messages (MsgVersion)
+// sent by a node so self-connections can be detected.  A node has connected to
+// itself when it receives a version message with the same nonce as one it sent
+// for which the handshake hasn't completed yet.
+//
+// Each nonce expires after the duration passed to NewNonceRegistry so the
+// registry doesn't grow without bound when nonces are never removed.  The
+// expiry should be longer than the time allowed for a handshake.
+//
+// A NonceRegistry is safe for concurrent use by multiple goroutines.
+type NonceRegistry struct {
+	mtx    sync.Mutex
+	expiry time.Duration
+	nonces map[uint64]time.Time // Nonce to expiration time
+	now    func() time.Time
+}
+
+// Generate returns a new random nonce for use in a version message and records
+// it in the registry.
+func (r *NonceRegistry) Generate() (uint64, error) {
+	nonce, err := RandomUint64()
+	if err != nil {
+		return 0, err
+	}
+
+	r.Add(nonce)
+	return nonce, nil
+}
+
+// Add records the passed nonce in the registry.  The expiration of a nonce
+// which is already recorded is extended.
+func (r *NonceRegistry) Add(nonce uint64) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	now := r.now()
+	r.prune(now)
+	r.nonces[nonce] = now.Add(r.expiry)
+}
+
+// Remove removes the passed nonce from the registry.  It should be called once
+// the handshake the nonce was generated for has completed.
+func (r *NonceRegistry) Remove(nonce uint64) {
+	r.mtx.Lock()
+	delete(r.nonces, nonce)
+	r.mtx.Unlock()
+}
+
+// IsSelf returns whether or not the passed nonce, which is typically the nonce
+// of a received version message, is one of the unexpired nonces recorded in the
+// registry, meaning the node is connected to itself.
+func (r *NonceRegistry) IsSelf(nonce uint64) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	expiration, ok := r.nonces[nonce]
+	return ok && r.now().Before(expiration)
+}
+
+// Len returns the number of nonces recorded in the registry, including any
+// which have expired but have not been pruned yet.
+func (r *NonceRegistry) Len() int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return len(r.nonces)
+}
+
+// prune removes all nonces which have expired as of the passed time.
+//
+// This function MUST be called with the registry lock held.
+func (r *NonceRegistry) prune(now time.Time) {
+	for nonce, expiration := range r.nonces {
+		if !now.Before(expiration) {
+			delete(r.nonces, nonce)
+		}
+	}
+}
+
+// NewNonceRegistry returns a new empty NonceRegistry in which each nonce
+// expires after the passed duration.
+func NewNonceRegistry(expiry time.Duration) *NonceRegistry {
+	return &NonceRegistry{
+		expiry: expiry,
+		nonces: make(map[uint64]time.Time),
+		now:    time.Now,
+	}
+}
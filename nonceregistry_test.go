@@ -0,0 +1,99 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"github.com/conformal/btcwire"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestNonceRegistry tests the NonceRegistry API.
+func TestNonceRegistry(t *testing.T) {
+	now := time.Unix(0x495fab29, 0)
+	registry := btcwire.NewNonceRegistry(time.Minute)
+	btcwire.TstSetNonceRegistryClock(registry, func() time.Time {
+		return now
+	})
+
+	// Ensure generated nonces are recorded.
+	nonce, err := registry.Generate()
+	if err != nil {
+		t.Errorf("Generate: %v", err)
+	}
+	if !registry.IsSelf(nonce) {
+		t.Errorf("IsSelf: generated nonce %d not detected", nonce)
+	}
+
+	// Ensure unknown nonces are not detected.
+	if registry.IsSelf(nonce + 1) {
+		t.Errorf("IsSelf: unknown nonce %d detected", nonce+1)
+	}
+
+	// Ensure added nonces are recorded and removed nonces are not.
+	registry.Add(123123)
+	if !registry.IsSelf(123123) {
+		t.Errorf("IsSelf: added nonce not detected")
+	}
+	registry.Remove(123123)
+	if registry.IsSelf(123123) {
+		t.Errorf("IsSelf: removed nonce detected")
+	}
+
+	// Ensure nonces expire.
+	registry.Add(123123)
+	now = now.Add(time.Minute)
+	if registry.IsSelf(nonce) || registry.IsSelf(123123) {
+		t.Errorf("IsSelf: expired nonce detected")
+	}
+
+	// Ensure expired nonces are pruned when adding another.
+	registry.Add(456456)
+	if n := registry.Len(); n != 1 {
+		t.Errorf("Len: wrong number of nonces after prune - got %d, "+
+			"want %d", n, 1)
+	}
+
+	// Ensure adding a nonce again extends its expiration.
+	now = now.Add(time.Second * 30)
+	registry.Add(456456)
+	now = now.Add(time.Second * 45)
+	if !registry.IsSelf(456456) {
+		t.Errorf("IsSelf: nonce with extended expiration not detected")
+	}
+}
+
+// TestNonceRegistryConcurrent ensures the NonceRegistry can be used from
+// multiple goroutines at once.  It is most useful with the race detector.
+func TestNonceRegistryConcurrent(t *testing.T) {
+	registry := btcwire.NewNonceRegistry(time.Minute)
+
+	const numGoroutines = 10
+	var wg sync.WaitGroup
+	wg.Add(numGoroutines)
+	for i := 0; i < numGoroutines; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				nonce, err := registry.Generate()
+				if err != nil {
+					t.Errorf("Generate: %v", err)
+					return
+				}
+				if !registry.IsSelf(nonce) {
+					t.Errorf("IsSelf: generated nonce %d "+
+						"not detected", nonce)
+				}
+				registry.Remove(nonce)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := registry.Len(); n != 0 {
+		t.Errorf("Len: wrong number of nonces - got %d, want %d", n, 0)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"sync"
+	"time"
+)
+
+// PingTracker keeps track of the nonces of the ping messages (MsgPing) sent to
+// a peer so the round-trip time can be measured when the peer echoes a nonce
+// back in a pong message (MsgPong).  The package itself doesn't keep any
+// connection state, so a PingTracker is typically created for each peer.
+//
+// Each ping expires after the timeout passed to NewPingTracker so pings which
+// are never answered don't accumulate.  A pong for an expired ping does not
+// match.
+//
+// Note that pong messages only contain a nonce for protocol versions after
+// BIP0031Version, so a tracker is only useful with peers which support it.
+//
+// A PingTracker is safe for concurrent use by multiple goroutines.
+type PingTracker struct {
+	mtx     sync.Mutex
+	timeout time.Duration
+	pings   map[uint64]time.Time // Nonce to time the ping was sent
+	now     func() time.Time
+}
+
+// NewPing returns a new ping message with a random nonce and records it in the
+// tracker as sent.  The message should be sent immediately after since the
+// round-trip time is measured from when it is recorded.
+func (pt *PingTracker) NewPing() (*MsgPing, error) {
+	nonce, err := RandomUint64()
+	if err != nil {
+		return nil, err
+	}
+
+	msg := NewMsgPing(nonce)
+	pt.Add(msg)
+	return msg, nil
+}
+
+// Add records the passed ping message as sent now.  Recording a ping with the
+// nonce of a ping which is still pending restarts its round-trip time.
+func (pt *PingTracker) Add(ping *MsgPing) {
+	pt.mtx.Lock()
+	defer pt.mtx.Unlock()
+
+	now := pt.now()
+	pt.prune(now)
+	pt.pings[ping.Nonce] = now
+}
+
+// Match returns the round-trip time of the pending ping message with the same
+// nonce as the passed pong message and removes the ping from the tracker.  The
+// ok flag is false when there is no such pending ping, which includes pings
+// which have expired.
+func (pt *PingTracker) Match(pong *MsgPong) (rtt time.Duration, ok bool) {
+	pt.mtx.Lock()
+	defer pt.mtx.Unlock()
+
+	sent, ok := pt.pings[pong.Nonce]
+	if !ok {
+		return 0, false
+	}
+	delete(pt.pings, pong.Nonce)
+
+	rtt = pt.now().Sub(sent)
+	if rtt >= pt.timeout {
+		return 0, false
+	}
+	return rtt, true
+}
+
+// Len returns the number of pending pings recorded in the tracker, including
+// any which have expired but have not been pruned yet.
+func (pt *PingTracker) Len() int {
+	pt.mtx.Lock()
+	defer pt.mtx.Unlock()
+
+	return len(pt.pings)
+}
+
+// prune removes all pings which have expired as of the passed time.
+//
+// This function MUST be called with the tracker lock held.
+func (pt *PingTracker) prune(now time.Time) {
+	for nonce, sent := range pt.pings {
+		if now.Sub(sent) >= pt.timeout {
+			delete(pt.pings, nonce)
+		}
+	}
+}
+
+// NewPingTracker returns a new empty PingTracker in which each ping expires
+// after the passed timeout.
+func NewPingTracker(timeout time.Duration) *PingTracker {
+	return &PingTracker{
+		timeout: timeout,
+		pings:   make(map[uint64]time.Time),
+		now:     time.Now,
+	}
+}
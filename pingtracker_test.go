@@ -0,0 +1,106 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"github.com/conformal/btcwire"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestPingTracker tests the PingTracker API.
+func TestPingTracker(t *testing.T) {
+	now := time.Unix(0x495fab29, 0)
+	tracker := btcwire.NewPingTracker(time.Minute)
+	btcwire.TstSetPingTrackerClock(tracker, func() time.Time {
+		return now
+	})
+
+	// Ensure a pong echoing a generated ping matches with the elapsed
+	// round-trip time.
+	ping, err := tracker.NewPing()
+	if err != nil {
+		t.Errorf("NewPing: %v", err)
+		return
+	}
+	now = now.Add(time.Millisecond * 250)
+	rtt, ok := tracker.Match(btcwire.NewMsgPong(ping.Nonce))
+	if !ok || rtt != time.Millisecond*250 {
+		t.Errorf("Match: got %v (%v), want %v (true)", rtt, ok,
+			time.Millisecond*250)
+	}
+
+	// Ensure a ping only matches once.
+	if _, ok := tracker.Match(btcwire.NewMsgPong(ping.Nonce)); ok {
+		t.Errorf("Match: ping matched a second time")
+	}
+
+	// Ensure a pong with an unknown nonce does not match.
+	tracker.Add(btcwire.NewMsgPing(123123))
+	if _, ok := tracker.Match(btcwire.NewMsgPong(456456)); ok {
+		t.Errorf("Match: unknown nonce matched")
+	}
+	if n := tracker.Len(); n != 1 {
+		t.Errorf("Len: wrong number of pings - got %d, want %d", n, 1)
+	}
+
+	// Ensure expired pings do not match.
+	now = now.Add(time.Minute)
+	if _, ok := tracker.Match(btcwire.NewMsgPong(123123)); ok {
+		t.Errorf("Match: expired ping matched")
+	}
+
+	// Ensure expired pings are pruned when adding another.
+	tracker.Add(btcwire.NewMsgPing(123123))
+	now = now.Add(time.Minute)
+	tracker.Add(btcwire.NewMsgPing(456456))
+	if n := tracker.Len(); n != 1 {
+		t.Errorf("Len: wrong number of pings after prune - got %d, "+
+			"want %d", n, 1)
+	}
+
+	// Ensure adding a ping again restarts its round-trip time.
+	now = now.Add(time.Second * 30)
+	tracker.Add(btcwire.NewMsgPing(456456))
+	now = now.Add(time.Second * 45)
+	rtt, ok = tracker.Match(btcwire.NewMsgPong(456456))
+	if !ok || rtt != time.Second*45 {
+		t.Errorf("Match: got %v (%v), want %v (true)", rtt, ok,
+			time.Second*45)
+	}
+}
+
+// TestPingTrackerConcurrent ensures the PingTracker can be used from multiple
+// goroutines at once.  It is most useful with the race detector.
+func TestPingTrackerConcurrent(t *testing.T) {
+	tracker := btcwire.NewPingTracker(time.Minute)
+
+	const numGoroutines = 10
+	var wg sync.WaitGroup
+	wg.Add(numGoroutines)
+	for i := 0; i < numGoroutines; i++ {
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ping, err := tracker.NewPing()
+				if err != nil {
+					t.Errorf("NewPing: %v", err)
+					return
+				}
+				pong := btcwire.NewMsgPong(ping.Nonce)
+				if _, ok := tracker.Match(pong); !ok {
+					t.Errorf("Match: pong for nonce %d did "+
+						"not match", ping.Nonce)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := tracker.Len(); n != 0 {
+		t.Errorf("Len: wrong number of pings - got %d, want %d", n, 0)
+	}
+}
@@ -5,15 +5,23 @@
 package btcwire
 
 import (
+	"errors"
+	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 const (
-	MainPort               = "8333"
-	TestNetPort            = "18333"
-	ProtocolVersion uint32 = 70001
-	TxVersion              = 1
+	MainPort    = "8333"
+	TestNetPort = "18333"
+
+	// ProtocolVersion is the latest protocol version this package
+	// supports.  It is at least every protocol version below which gates
+	// a message or field, so every message can be written with it.
+	ProtocolVersion uint32 = 70016
+
+	TxVersion = 1
 
 	// MultipleAddressVersion is the protocol version which added multiple
 	// addresses per message (pver >= MultipleAddressVersion).
@@ -35,18 +43,95 @@ const (
 	// bloom filtering related messages and extended the version message
 	// with a relay flag (pver >= BIP0037Version).
 	BIP0037Version uint32 = 70001
+
+	// RejectVersion is the protocol version which added a new reject
+	// message as defined by BIP0061 (pver >= RejectVersion).
+	RejectVersion uint32 = 70002
+
+	// SendHeadersVersion is the protocol version which added a new
+	// sendheaders message as defined by BIP0130 (pver >=
+	// SendHeadersVersion).
+	SendHeadersVersion uint32 = 70012
+
+	// FeeFilterVersion is the protocol version which added a new feefilter
+	// message as defined by BIP0133 (pver >= FeeFilterVersion).
+	FeeFilterVersion uint32 = 70013
+
+	// SendCmpctVersion is the protocol version which added compact block
+	// relay via the sendcmpct and cmpctblock messages as defined by BIP0152
+	// (pver >= SendCmpctVersion).
+	SendCmpctVersion uint32 = 70014
+
+	// WTxIDRelayVersion is the protocol version which added the wtxidrelay
+	// message as defined by BIP0339 (pver >= WTxIDRelayVersion).
+	WTxIDRelayVersion uint32 = 70016
+
+	// SendAddrV2Version is the protocol version which added the sendaddrv2
+	// message used to negotiate addrv2 messages as defined by BIP0155
+	// (pver >= SendAddrV2Version).
+	SendAddrV2Version uint32 = 70016
+
+	// CFilterVersion is the lowest protocol version compact block filter
+	// messages as defined by BIP0157 are sent with (pver >=
+	// CFilterVersion).  BIP0157 does not specify one, so it is the
+	// protocol version of the first reference implementation release
+	// which served them.
+	CFilterVersion uint32 = 70016
+
+	// MinAcceptableProtocolVersion is the lowest protocol version of a
+	// peer which is considered compatible by default.  It is
+	// MultipleAddressVersion since earlier versions are only able to send
+	// a single address per addr message.  See MsgVersion.IsCompatible.
+	MinAcceptableProtocolVersion = MultipleAddressVersion
 )
 
+// storageVersion is the protocol version the storage serialization used by the
+// Serialize and Deserialize methods is encoded with.  It is fixed, rather than
+// ProtocolVersion, so data in long-term storage remains readable when the
+// protocol version is bumped and the wire encoding of the protocol changes.
+const storageVersion uint32 = 0
+
 // ServiceFlag identifies services supported by a bitcoin peer.
 type ServiceFlag uint64
 
 const (
+	// SFNodeNetwork is a flag used to indicate a peer is a full node.
 	SFNodeNetwork ServiceFlag = 1 << iota
+
+	// SFNodeGetUTXO is a flag used to indicate a peer supports the
+	// getutxos and utxos commands (BIP0064).
+	SFNodeGetUTXO
+
+	// SFNodeBloom is a flag used to indicate a peer supports bloom
+	// filtering (BIP0037/BIP0111).
+	SFNodeBloom
+
+	// SFNodeWitness is a flag used to indicate a peer supports blocks and
+	// transactions with witness data (BIP0144).
+	SFNodeWitness
+
+	// SFNodeCF is a flag used to indicate a peer supports committed
+	// filters (CFs) as defined by BIP0157 and BIP0158.
+	SFNodeCF ServiceFlag = 1 << 6
 )
 
 // Map of service flags back to their constant names for pretty printing.
 var sfStrings = map[ServiceFlag]string{
 	SFNodeNetwork: "SFNodeNetwork",
+	SFNodeGetUTXO: "SFNodeGetUTXO",
+	SFNodeBloom:   "SFNodeBloom",
+	SFNodeWitness: "SFNodeWitness",
+	SFNodeCF:      "SFNodeCF",
+}
+
+// orderedSFStrings is an ordered list of service flags from lowest to
+// highest.
+var orderedSFStrings = []ServiceFlag{
+	SFNodeNetwork,
+	SFNodeGetUTXO,
+	SFNodeBloom,
+	SFNodeWitness,
+	SFNodeCF,
 }
 
 // String returns the ServiceFlag in human-readable form.
@@ -58,9 +143,9 @@ func (f ServiceFlag) String() string {
 
 	// Add individual bit flags.
 	s := ""
-	for flag, name := range sfStrings {
+	for _, flag := range orderedSFStrings {
 		if f&flag == flag {
-			s += name + "|"
+			s += sfStrings[flag] + "|"
 			f -= flag
 		}
 	}
@@ -74,6 +159,17 @@ func (f ServiceFlag) String() string {
 	return s
 }
 
+// HasFlag returns whether the passed flag is set.  When flag contains multiple
+// flags, all of them must be set.
+func (f ServiceFlag) HasFlag(flag ServiceFlag) bool {
+	return f&flag == flag
+}
+
+// AddFlag sets the passed flag.
+func (f *ServiceFlag) AddFlag(flag ServiceFlag) {
+	*f |= flag
+}
+
 // BitcoinNet represents which bitcoin network a message belongs to.
 type BitcoinNet uint32
 
@@ -82,7 +178,133 @@ type BitcoinNet uint32
 // this package does not provide that functionality since it's generally a
 // better idea to simply disconnect clients that are misbehaving over TCP.
 const (
-	MainNet  BitcoinNet = 0xd9b4bef9
+	MainNet BitcoinNet = 0xd9b4bef9
+
+	// TestNet is the regression test network used by bitcoind in regtest
+	// mode.  See RegTest.
 	TestNet  BitcoinNet = 0xdab5bffa
 	TestNet3 BitcoinNet = 0x0709110b
+
+	// RegTest is the regression test network used by bitcoind in regtest
+	// mode.  It is the same network as TestNet under the name bitcoind
+	// uses.  Its message start bytes are fa bf b5 da on the wire.
+	RegTest BitcoinNet = TestNet
+
+	// SigNet is the network of the default signet challenge.  Signets
+	// using a custom challenge have a different network magic.
+	SigNet BitcoinNet = 0x40cf030a
+
+	// SimNet is the simulation test network used by btcd in simnet mode.
+	SimNet BitcoinNet = 0x12141c16
 )
+
+// Map of bitcoin networks back to their constant names for pretty printing.
+var bnStrings = map[BitcoinNet]string{
+	MainNet:  "MainNet",
+	RegTest:  "RegTest",
+	TestNet3: "TestNet3",
+	SigNet:   "SigNet",
+	SimNet:   "SimNet",
+}
+
+// String returns the BitcoinNet in human-readable form.  Networks registered
+// with RegisterNet are returned by the name they were registered with.
+func (n BitcoinNet) String() string {
+	if s, ok := bnStrings[n]; ok {
+		return s
+	}
+
+	registeredNets.RLock()
+	rn, ok := registeredNets.nets[n]
+	registeredNets.RUnlock()
+	if ok {
+		return rn.name
+	}
+
+	return "Unknown BitcoinNet (" + strconv.FormatUint(uint64(n), 10) + ")"
+}
+
+// Map of bitcoin networks to the port conventionally used for peer-to-peer
+// connections on them.
+var bnDefaultPorts = map[BitcoinNet]uint16{
+	MainNet:  8333,
+	TestNet:  18444,
+	TestNet3: 18333,
+	SigNet:   38333,
+	SimNet:   18555,
+}
+
+// DefaultPort returns the port conventionally used for peer-to-peer
+// connections on the bitcoin network along with true.  It returns 0 and false
+// for unknown networks and registered networks without a default port.
+func (n BitcoinNet) DefaultPort() (uint16, bool) {
+	if port, ok := bnDefaultPorts[n]; ok {
+		return port, true
+	}
+
+	registeredNets.RLock()
+	rn := registeredNets.nets[n]
+	registeredNets.RUnlock()
+	return rn.port, rn.port != 0
+}
+
+// ErrNetRegistered is returned by RegisterNet for a network which is already
+// defined by this package or was already registered.  It is wrapped, so use
+// errors.Is to test for it.
+var ErrNetRegistered = errors.New("bitcoin network is already registered")
+
+// registeredNet houses the details of a network registered with RegisterNet.
+type registeredNet struct {
+	name string
+	port uint16
+}
+
+// registeredNets houses the networks registered with RegisterNet.
+var registeredNets = struct {
+	sync.RWMutex
+	nets map[BitcoinNet]registeredNet
+}{nets: make(map[BitcoinNet]registeredNet)}
+
+// RegisterNet registers the passed network magic under the passed name, so
+// private chains and other networks this package does not define are printed
+// by name and report their default port like the built-in ones.  A default
+// port of 0 means the network has none.
+//
+// Registration is not needed to read and write messages on a network since
+// ReadMessage, WriteMessage and the other functions accept any BitcoinNet, so
+// for example BitcoinNet(0xfeb4bef9) can be used directly.
+//
+// The name may not be empty and the network may not be one defined by this
+// package or one which was already registered.  It is safe to call RegisterNet
+// concurrently with using networks, although it is usually called during
+// initialization.
+func RegisterNet(net BitcoinNet, name string, defaultPort uint16) error {
+	if name == "" {
+		return fmt.Errorf("RegisterNet: empty name for network %#08x",
+			uint32(net))
+	}
+
+	registeredNets.Lock()
+	defer registeredNets.Unlock()
+
+	_, builtin := bnStrings[net]
+	_, registered := registeredNets.nets[net]
+	if builtin || registered {
+		return fmt.Errorf("RegisterNet: network %#08x: %w", uint32(net),
+			ErrNetRegistered)
+	}
+	registeredNets.nets[net] = registeredNet{name: name, port: defaultPort}
+	return nil
+}
+
+// UnregisterNet removes the passed network registered with RegisterNet and
+// returns whether or not it was registered.  Networks defined by this package
+// can't be unregistered.
+func UnregisterNet(net BitcoinNet) bool {
+	registeredNets.Lock()
+	defer registeredNets.Unlock()
+
+	_, ok := registeredNets.nets[net]
+	delete(registeredNets.nets, net)
+	return ok
+}
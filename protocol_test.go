@@ -5,7 +5,11 @@
 package btcwire_test
 
 import (
+	"bytes"
+	"errors"
 	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"reflect"
 	"testing"
 )
 
@@ -17,7 +21,12 @@ func TestServiceFlagStringer(t *testing.T) {
 	}{
 		{0, "0x0"},
 		{btcwire.SFNodeNetwork, "SFNodeNetwork"},
-		{0xffffffff, "SFNodeNetwork|0xfffffffe"},
+		{btcwire.SFNodeGetUTXO, "SFNodeGetUTXO"},
+		{btcwire.SFNodeBloom, "SFNodeBloom"},
+		{btcwire.SFNodeWitness, "SFNodeWitness"},
+		{btcwire.SFNodeCF, "SFNodeCF"},
+		{0xffffffff, "SFNodeNetwork|SFNodeGetUTXO|SFNodeBloom|" +
+			"SFNodeWitness|SFNodeCF|0xffffffb0"},
 	}
 
 	t.Logf("Running %d tests", len(tests))
@@ -30,3 +39,262 @@ func TestServiceFlagStringer(t *testing.T) {
 		}
 	}
 }
+
+// TestProtocolVersionLatest ensures ProtocolVersion is at least every protocol
+// version which gates a message, and that the gated messages can be written and
+// read with it.
+func TestProtocolVersionLatest(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	versions := []uint32{
+		btcwire.MultipleAddressVersion,
+		btcwire.NetAddressTimeVersion,
+		btcwire.BIP0031Version,
+		btcwire.BIP0035Version,
+		btcwire.BIP0037Version,
+		btcwire.RejectVersion,
+		btcwire.SendHeadersVersion,
+		btcwire.FeeFilterVersion,
+		btcwire.SendCmpctVersion,
+		btcwire.WTxIDRelayVersion,
+		btcwire.SendAddrV2Version,
+		btcwire.CFilterVersion,
+	}
+	for i, version := range versions {
+		if version > pver {
+			t.Errorf("ProtocolVersion #%d: protocol version %d is "+
+				"higher than ProtocolVersion %d", i, version, pver)
+		}
+	}
+
+	msgs := []btcwire.Message{
+		btcwire.NewMsgSendHeaders(),
+		btcwire.NewMsgFeeFilter(1000),
+		btcwire.NewMsgWTxIDRelay(),
+		btcwire.NewMsgSendAddrV2(),
+	}
+
+	t.Logf("Running %d tests", len(msgs))
+	for i, msg := range msgs {
+		var buf bytes.Buffer
+		err := btcwire.WriteMessage(&buf, msg, pver, btcwire.MainNet)
+		if err != nil {
+			t.Errorf("WriteMessage #%d (%s) error %v", i,
+				msg.Command(), err)
+			continue
+		}
+		readMsg, _, err := btcwire.ReadMessage(&buf, pver, btcwire.MainNet)
+		if err != nil {
+			t.Errorf("ReadMessage #%d (%s) error %v", i,
+				msg.Command(), err)
+			continue
+		}
+		if !reflect.DeepEqual(readMsg, msg) {
+			t.Errorf("ReadMessage #%d\n got: %s want: %s", i,
+				spew.Sdump(readMsg), spew.Sdump(msg))
+			continue
+		}
+	}
+}
+
+// TestServiceFlagHasAdd tests the ServiceFlag HasFlag and AddFlag functions.
+func TestServiceFlagHasAdd(t *testing.T) {
+	var services btcwire.ServiceFlag
+	if services.HasFlag(btcwire.SFNodeNetwork) {
+		t.Errorf("HasFlag: zero services has SFNodeNetwork")
+	}
+
+	services.AddFlag(btcwire.SFNodeNetwork)
+	services.AddFlag(btcwire.SFNodeWitness)
+	services.AddFlag(btcwire.SFNodeNetwork)
+	if want := btcwire.SFNodeNetwork | btcwire.SFNodeWitness; services != want {
+		t.Errorf("AddFlag: wrong services - got %v, want %v", services,
+			want)
+	}
+
+	tests := []struct {
+		flag btcwire.ServiceFlag
+		want bool
+	}{
+		{0, true},
+		{btcwire.SFNodeNetwork, true},
+		{btcwire.SFNodeWitness, true},
+		{btcwire.SFNodeNetwork | btcwire.SFNodeWitness, true},
+		{btcwire.SFNodeBloom, false},
+		{btcwire.SFNodeNetwork | btcwire.SFNodeBloom, false},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		if got := services.HasFlag(test.flag); got != test.want {
+			t.Errorf("HasFlag #%d (%v): got %v, want %v", i,
+				test.flag, got, test.want)
+			continue
+		}
+	}
+}
+
+// TestBitcoinNetStringer tests the stringized output for bitcoin networks.
+func TestBitcoinNetStringer(t *testing.T) {
+	tests := []struct {
+		in   btcwire.BitcoinNet
+		want string
+	}{
+		{btcwire.MainNet, "MainNet"},
+		{btcwire.TestNet, "RegTest"},
+		{btcwire.RegTest, "RegTest"},
+		{btcwire.TestNet3, "TestNet3"},
+		{btcwire.SigNet, "SigNet"},
+		{btcwire.SimNet, "SimNet"},
+		{0xffffffff, "Unknown BitcoinNet (4294967295)"},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		result := test.in.String()
+		if result != test.want {
+			t.Errorf("String #%d\n got: %s want: %s", i, result,
+				test.want)
+			continue
+		}
+	}
+}
+
+// TestBitcoinNetDefaultPort tests the default port lookup for bitcoin networks.
+func TestBitcoinNetDefaultPort(t *testing.T) {
+	tests := []struct {
+		in     btcwire.BitcoinNet
+		want   uint16
+		wantOk bool
+	}{
+		{btcwire.MainNet, 8333, true},
+		{btcwire.TestNet, 18444, true},
+		{btcwire.RegTest, 18444, true},
+		{btcwire.TestNet3, 18333, true},
+		{btcwire.SigNet, 38333, true},
+		{btcwire.SimNet, 18555, true},
+		{0xffffffff, 0, false},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		port, ok := test.in.DefaultPort()
+		if port != test.want || ok != test.wantOk {
+			t.Errorf("DefaultPort #%d\n got: %d, %v want: %d, %v",
+				i, port, ok, test.want, test.wantOk)
+			continue
+		}
+	}
+}
+
+// TestRegisterNet tests that networks registered with RegisterNet are printed
+// by name and report their default port until they are unregistered, and that
+// messages can be read and written on them.
+func TestRegisterNet(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcnet := btcwire.BitcoinNet(0xfeb4bef9)
+
+	err := btcwire.RegisterNet(btcnet, "PrivNet", 28333)
+	if err != nil {
+		t.Errorf("RegisterNet: %v", err)
+		return
+	}
+	defer btcwire.UnregisterNet(btcnet)
+
+	// Ensure the registered network is printed by name and reports its
+	// default port.
+	if s := btcnet.String(); s != "PrivNet" {
+		t.Errorf("String: wrong name - got %s, want %s", s, "PrivNet")
+	}
+	if port, ok := btcnet.DefaultPort(); port != 28333 || !ok {
+		t.Errorf("DefaultPort: wrong port - got %d, %v want %d, %v",
+			port, ok, 28333, true)
+	}
+
+	// Ensure messages round trip on the registered network and are rejected
+	// on other networks.
+	msg := btcwire.NewMsgPing(1)
+	var buf bytes.Buffer
+	err = btcwire.WriteMessage(&buf, msg, pver, btcnet)
+	if err != nil {
+		t.Errorf("WriteMessage: %v", err)
+		return
+	}
+	raw := append([]byte{}, buf.Bytes()...)
+	readMsg, _, err := btcwire.ReadMessage(&buf, pver, btcnet)
+	if err != nil {
+		t.Errorf("ReadMessage: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(readMsg, msg) {
+		t.Errorf("ReadMessage: wrong message - got %v, want %v",
+			spew.Sdump(readMsg), spew.Sdump(msg))
+	}
+	_, _, err = btcwire.ReadMessage(bytes.NewReader(raw), pver,
+		btcwire.MainNet)
+	if !errors.Is(err, btcwire.ErrWrongNetwork) {
+		t.Errorf("ReadMessage: wrong error for other network - "+
+			"got %v, want %v", err, btcwire.ErrWrongNetwork)
+	}
+
+	// Ensure networks can't be registered twice and only registered
+	// networks can be unregistered.
+	err = btcwire.RegisterNet(btcnet, "OtherNet", 0)
+	if !errors.Is(err, btcwire.ErrNetRegistered) {
+		t.Errorf("RegisterNet: wrong error for registered network - "+
+			"got %v, want %v", err, btcwire.ErrNetRegistered)
+	}
+	if !btcwire.UnregisterNet(btcnet) {
+		t.Errorf("UnregisterNet: registered network not removed")
+	}
+	if btcwire.UnregisterNet(btcnet) {
+		t.Errorf("UnregisterNet: removed network removed again")
+	}
+	if s := btcnet.String(); s != "Unknown BitcoinNet (4273258233)" {
+		t.Errorf("String: wrong name for unregistered network - got %s",
+			s)
+	}
+	if btcwire.UnregisterNet(btcwire.MainNet) {
+		t.Errorf("UnregisterNet: built-in network removed")
+	}
+
+	// Ensure a network registered without a default port reports none.
+	err = btcwire.RegisterNet(btcnet, "PortlessNet", 0)
+	if err != nil {
+		t.Errorf("RegisterNet: %v", err)
+		return
+	}
+	if port, ok := btcnet.DefaultPort(); port != 0 || ok {
+		t.Errorf("DefaultPort: wrong port - got %d, %v want %d, %v",
+			port, ok, 0, false)
+	}
+}
+
+// TestRegisterNetErrors tests that RegisterNet rejects empty names and
+// networks which are already known.
+func TestRegisterNetErrors(t *testing.T) {
+	tests := []struct {
+		net  btcwire.BitcoinNet // Network to register
+		name string             // Name to register it with
+		err  error              // Expected wrapped error, if any
+	}{
+		{0xfeb4bef9, "", nil},
+		{btcwire.MainNet, "MyMainNet", btcwire.ErrNetRegistered},
+		{btcwire.TestNet, "MyTestNet", btcwire.ErrNetRegistered},
+		{btcwire.SimNet, "MySimNet", btcwire.ErrNetRegistered},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		err := btcwire.RegisterNet(test.net, test.name, 0)
+		if err == nil {
+			btcwire.UnregisterNet(test.net)
+			t.Errorf("RegisterNet #%d: no error for network %v",
+				i, test.net)
+			continue
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("RegisterNet #%d: wrong error - got %v, "+
+				"want %v", i, err, test.err)
+		}
+	}
+}
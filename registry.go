@@ -0,0 +1,105 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Errors returned by RegisterMessage.  They are wrapped, so use errors.Is to
+// test for them.
+var (
+	// ErrInvalidCommand indicates a command which can't be sent in a
+	// message header because it is empty, longer than 12 bytes, or holds
+	// characters which are not printable ASCII, or a factory which creates
+	// messages for a different command.
+	ErrInvalidCommand = errors.New("invalid command")
+
+	// ErrCommandRegistered indicates a command which is already handled by
+	// this package or was already registered.
+	ErrCommandRegistered = errors.New("command is already registered")
+)
+
+// registeredMakers houses the functions registered with RegisterMessage which
+// return a new empty message for commands which are not built into this
+// package.
+var registeredMakers = struct {
+	sync.RWMutex
+	makers map[string]func() Message
+}{makers: make(map[string]func() Message)}
+
+// RegisterMessage registers the passed factory which returns a new empty
+// message for the passed command, so ReadMessage and the other read functions
+// decode messages with the command instead of rejecting them with
+// ErrUnknownCommand.  This allows forks for other networks and experimental
+// protocol extensions to add their own messages without modifying this
+// package.
+//
+// The command must be a valid message header command and may not be one of the
+// commands built into this package (see KnownCommands) or one which was already
+// registered.  The messages created by factory must report the same command,
+// so they are written with it as well.  The payload of registered messages is
+// limited by their MaxPayloadLength like any other message and never exceeds
+// the maximum payload of 32MB allowed for all messages.
+//
+// It is safe to call RegisterMessage concurrently with reading messages,
+// although it is usually called during initialization.
+func RegisterMessage(command string, factory func() Message) error {
+	if command == "" || len(command) > commandSize ||
+		!isValidCommand(command) {
+
+		return fmt.Errorf("RegisterMessage: command %q: %w", command,
+			ErrInvalidCommand)
+	}
+	if factory == nil {
+		return fmt.Errorf("RegisterMessage: nil factory for command %q",
+			command)
+	}
+	if cmd := factory().Command(); cmd != command {
+		return fmt.Errorf("RegisterMessage: factory for command %q "+
+			"creates messages for command %q: %w", command, cmd,
+			ErrInvalidCommand)
+	}
+
+	registeredMakers.Lock()
+	defer registeredMakers.Unlock()
+
+	_, builtin := messageMakers[command]
+	_, registered := registeredMakers.makers[command]
+	if builtin || registered {
+		return fmt.Errorf("RegisterMessage: command %q: %w", command,
+			ErrCommandRegistered)
+	}
+	registeredMakers.makers[command] = factory
+	return nil
+}
+
+// UnregisterMessage removes the factory for the passed command registered with
+// RegisterMessage and returns whether or not there was one.  Commands built into
+// this package can't be unregistered.
+func UnregisterMessage(command string) bool {
+	registeredMakers.Lock()
+	defer registeredMakers.Unlock()
+
+	_, ok := registeredMakers.makers[command]
+	delete(registeredMakers.makers, command)
+	return ok
+}
+
+// LookupMessage returns the function which returns a new empty message for the
+// passed command and whether or not the command is known.  This includes the
+// commands built into this package and those registered with RegisterMessage.
+func LookupMessage(command string) (func() Message, bool) {
+	if makeMsg, ok := messageMakers[command]; ok {
+		return makeMsg, true
+	}
+
+	registeredMakers.RLock()
+	makeMsg, ok := registeredMakers.makers[command]
+	registeredMakers.RUnlock()
+	return makeMsg, ok
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// extMessage implements the btcwire.Message interface and is used to test
+// messages registered with btcwire.RegisterMessage.
+type extMessage struct {
+	command string
+	Data    []byte
+}
+
+// BtcDecode reads the rest of r into the data of the message.
+func (msg *extMessage) BtcDecode(r io.Reader, pver uint32) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	msg.Data = data
+	return nil
+}
+
+// BtcEncode writes the data of the message to w.
+func (msg *extMessage) BtcEncode(w io.Writer, pver uint32) error {
+	_, err := w.Write(msg.Data)
+	return err
+}
+
+// Command returns the command of the message.
+func (msg *extMessage) Command() string {
+	return msg.command
+}
+
+// MaxPayloadLength returns a small max payload for the message.
+func (msg *extMessage) MaxPayloadLength(pver uint32) uint32 {
+	return 16
+}
+
+// TestRegisterMessage tests that messages registered with RegisterMessage are
+// read and written like built-in messages until they are unregistered.
+func TestRegisterMessage(t *testing.T) {
+	pver := btcwire.ProtocolVersion
+	btcnet := btcwire.MainNet
+	factory := func() btcwire.Message {
+		return &extMessage{command: "extping"}
+	}
+
+	err := btcwire.RegisterMessage("extping", factory)
+	if err != nil {
+		t.Errorf("RegisterMessage: %v", err)
+		return
+	}
+	defer btcwire.UnregisterMessage("extping")
+
+	// Ensure the registered command is known.
+	if _, ok := btcwire.LookupMessage("extping"); !ok {
+		t.Errorf("LookupMessage: registered command not found")
+	}
+	commands := btcwire.KnownCommands()
+	if i := sort.SearchStrings(commands, "extping"); i == len(commands) ||
+		commands[i] != "extping" {
+
+		t.Errorf("KnownCommands: registered command not included - "+
+			"got %v", commands)
+	}
+
+	// Ensure the registered message round trips.
+	msg := &extMessage{command: "extping", Data: []byte{0x01, 0x02}}
+	var buf bytes.Buffer
+	err = btcwire.WriteMessage(&buf, msg, pver, btcnet)
+	if err != nil {
+		t.Errorf("WriteMessage: %v", err)
+		return
+	}
+	raw := append([]byte{}, buf.Bytes()...)
+	readMsg, _, err := btcwire.ReadMessage(&buf, pver, btcnet)
+	if err != nil {
+		t.Errorf("ReadMessage: %v", err)
+		return
+	}
+	if !reflect.DeepEqual(readMsg, msg) {
+		t.Errorf("ReadMessage: wrong message - got %v, want %v",
+			spew.Sdump(readMsg), spew.Sdump(msg))
+	}
+
+	// Ensure the max payload of the registered message is enforced.
+	bigMsg := &extMessage{command: "extping", Data: make([]byte, 17)}
+	var bigBuf bytes.Buffer
+	err = btcwire.WriteMessage(&bigBuf, bigMsg, pver, btcnet)
+	if _, ok := err.(*btcwire.MessageError); !ok {
+		t.Errorf("WriteMessage: wrong error for too large payload - "+
+			"got %v <%T>", err, err)
+	}
+
+	// Ensure unregistered commands are rejected again and only registered
+	// commands can be unregistered.
+	if !btcwire.UnregisterMessage("extping") {
+		t.Errorf("UnregisterMessage: registered command not removed")
+	}
+	if btcwire.UnregisterMessage("extping") {
+		t.Errorf("UnregisterMessage: removed command removed again")
+	}
+	_, _, err = btcwire.ReadMessage(bytes.NewReader(raw), pver, btcnet)
+	if !errors.Is(err, btcwire.ErrUnknownMessage) {
+		t.Errorf("ReadMessage: wrong error for unregistered command - "+
+			"got %v, want %v", err, btcwire.ErrUnknownMessage)
+	}
+	if btcwire.UnregisterMessage("tx") {
+		t.Errorf("UnregisterMessage: built-in command removed")
+	}
+	if _, ok := btcwire.LookupMessage("tx"); !ok {
+		t.Errorf("LookupMessage: built-in command not found")
+	}
+}
+
+// TestRegisterMessageErrors tests that RegisterMessage rejects invalid
+// commands and commands which are already known.
+func TestRegisterMessageErrors(t *testing.T) {
+	newFactory := func(command string) func() btcwire.Message {
+		return func() btcwire.Message {
+			return &extMessage{command: command}
+		}
+	}
+
+	err := btcwire.RegisterMessage("extdup", newFactory("extdup"))
+	if err != nil {
+		t.Errorf("RegisterMessage: %v", err)
+		return
+	}
+	defer btcwire.UnregisterMessage("extdup")
+
+	tests := []struct {
+		name    string                 // Short description of the test
+		command string                 // Command to register
+		factory func() btcwire.Message // Factory to register
+		want    error                  // Expected wrapped error
+	}{
+		{"empty command", "", newFactory(""), btcwire.ErrInvalidCommand},
+		{"command too long", "waytoolongcommand",
+			newFactory("waytoolongcommand"), btcwire.ErrInvalidCommand},
+		{"unprintable command", "ext\x00", newFactory("ext\x00"),
+			btcwire.ErrInvalidCommand},
+		{"mismatched factory", "extone", newFactory("exttwo"),
+			btcwire.ErrInvalidCommand},
+		{"built-in command", "tx", newFactory("tx"),
+			btcwire.ErrCommandRegistered},
+		{"registered command", "extdup", newFactory("extdup"),
+			btcwire.ErrCommandRegistered},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		err := btcwire.RegisterMessage(test.command, test.factory)
+		if !errors.Is(err, test.want) {
+			t.Errorf("RegisterMessage #%d (%s) wrong error got: %v, "+
+				"want: %v", i, test.name, err, test.want)
+			continue
+		}
+		if test.want == btcwire.ErrInvalidCommand {
+			if _, ok := btcwire.LookupMessage(test.command); ok {
+				t.Errorf("RegisterMessage #%d (%s) registered "+
+					"invalid command", i, test.name)
+				continue
+			}
+		}
+	}
+
+	// Ensure a nil factory is rejected.
+	err = btcwire.RegisterMessage("extnil", nil)
+	if err == nil {
+		t.Errorf("RegisterMessage: nil factory registered")
+		btcwire.UnregisterMessage("extnil")
+	}
+}
@@ -16,21 +16,54 @@ const MaxHashStringSize = HashSize * 2
 
 var ErrHashStrSize = fmt.Errorf("Max hash length is %v chars", MaxHashStringSize)
 
+// ErrHashStrLen is returned by NewShaHashFromInternalStr for hash strings which
+// are shorter than MaxHashStringSize.
+var ErrHashStrLen = fmt.Errorf("Hash length must be %v chars", MaxHashStringSize)
+
 // ShaHash is used in several of the bitcoin messages and common structures.  It
 // typically represents the double sha256 of data.
 type ShaHash [HashSize]byte
 
-// String returns the ShaHash in the standard bitcoin big-endian form.
+// String returns the ShaHash as the hexadecimal string of the bytes in the
+// standard bitcoin big-endian form.  See ReverseBytes.
 func (hash ShaHash) String() string {
-	hashstr := ""
-	for i := range hash {
-		hashstr += fmt.Sprintf("%02x", hash[HashSize-1-i])
+	return hex.EncodeToString(hash.ReverseBytes())
+}
+
+// MarshalText returns the hash in the same form as String.  This is part of
+// the encoding.TextMarshaler interface implementation, so hashes are encoded
+// as strings in JSON.
+func (hash ShaHash) MarshalText() ([]byte, error) {
+	return []byte(hash.String()), nil
+}
+
+// UnmarshalText sets the hash from a hash string in the form returned by
+// String as NewShaHashFromStr does.  This is part of the
+// encoding.TextUnmarshaler interface implementation.
+func (hash *ShaHash) UnmarshalText(text []byte) error {
+	newHash, err := NewShaHashFromStr(string(text))
+	if err != nil {
+		return err
+	}
+	*hash = *newHash
+	return nil
+}
+
+// ReverseBytes returns a newly allocated copy of the bytes which represent the
+// hash in reverse order.  The hash is stored in the little-endian order used on
+// the wire, whereas block explorers and other display code use the reversed,
+// big-endian, order.
+func (hash *ShaHash) ReverseBytes() []byte {
+	reversed := make([]byte, HashSize)
+	for i, b := range hash {
+		reversed[HashSize-1-i] = b
 	}
 
-	return hashstr
+	return reversed
 }
 
-// Bytes returns the bytes which represent the hash as a byte slice.
+// Bytes returns the bytes which represent the hash as a byte slice.  The
+// returned slice is a copy, so modifying it does not modify the hash.
 func (hash *ShaHash) Bytes() []byte {
 	newHash := make([]byte, HashSize)
 	copy(newHash, hash[:])
@@ -38,8 +71,18 @@ func (hash *ShaHash) Bytes() []byte {
 	return newHash
 }
 
+// CloneBytes returns a newly allocated copy of the bytes which represent the
+// hash.  It is equivalent to Bytes, but makes it explicit at the call site that
+// the caller owns the returned slice and the hash can't be modified through
+// it, unlike a slice of the hash such as hash[:].
+func (hash *ShaHash) CloneBytes() []byte {
+	return hash.Bytes()
+}
+
 // SetBytes sets the bytes which represent the hash.  An error is returned if
-// the number of bytes passed in is not HashSize.
+// the number of bytes passed in is not HashSize, in which case the hash is left
+// unmodified.  The bytes are copied, so the passed slice does not alias the
+// hash.
 func (hash *ShaHash) SetBytes(newHash []byte) error {
 	nhlen := len(newHash)
 	if nhlen != HashSize {
@@ -68,7 +111,13 @@ func NewShaHash(newHash []byte) (*ShaHash, error) {
 }
 
 // NewShaHashFromStr converts a hash string in the standard bitcoin big-endian
-// form to a ShaHash (which is little-endian).
+// form to a ShaHash (which is little-endian).  This is the display order used
+// by block explorers and by ShaHash.String, so the genesis block hash is
+// parsed from
+// "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f".  Hash
+// strings longer than MaxHashStringSize are rejected with ErrHashStrSize while
+// shorter ones are treated as having their leading zeros stripped.  See
+// NewShaHashFromInternalStr for hash strings in the internal byte order.
 func NewShaHashFromStr(hash string) (*ShaHash, error) {
 	// Return error if hash string is too long.
 	if len(hash) > MaxHashStringSize {
@@ -104,3 +153,27 @@ func NewShaHashFromStr(hash string) (*ShaHash, error) {
 	// Create the sha hash using the byte slice and return it.
 	return NewShaHash(pbuf)
 }
+
+// NewShaHashFromInternalStr converts a hash string in the internal little-endian
+// byte order, which is the order the hash is stored in and encoded on the wire,
+// to a ShaHash without reversing it.  This is the order of hex encoding the
+// bytes of a hash directly, so the genesis block hash is parsed from
+// "6fe28c0ab6f1b372c1a6a246ae63f74f931e8365e15a089c68d6190000000000".  Unlike
+// NewShaHashFromStr, the hash string must be exactly MaxHashStringSize
+// characters since leading zeros can't be inferred, so ErrHashStrSize is
+// returned for longer strings and ErrHashStrLen for shorter ones.
+func NewShaHashFromInternalStr(hash string) (*ShaHash, error) {
+	if len(hash) > MaxHashStringSize {
+		return nil, ErrHashStrSize
+	}
+	if len(hash) != MaxHashStringSize {
+		return nil, ErrHashStrLen
+	}
+
+	buf, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewShaHash(buf)
+}
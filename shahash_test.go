@@ -67,6 +67,41 @@ func TestShaHash(t *testing.T) {
 	if err == nil {
 		t.Errorf("SetBytes: failed to received expected err - got: nil")
 	}
+	err = hash.SetBytes(make([]byte, btcwire.HashSize+1))
+	if err == nil {
+		t.Errorf("SetBytes: failed to received expected err - got: nil")
+	}
+
+	// Ensure the hash is unmodified after the failed SetBytes calls.
+	if !hash.IsEqual(blockHash) {
+		t.Errorf("SetBytes: hash modified on error - got: %v, want: %v",
+			hash, blockHash)
+	}
+
+	// Ensure the hash can't be modified through the cloned bytes.
+	cloned := hash.CloneBytes()
+	if !bytes.Equal(cloned, blockHash[:]) {
+		t.Errorf("CloneBytes: contents mismatch - got: %v, want: %v",
+			cloned, blockHash[:])
+	}
+	cloned[0] ^= 0xff
+	if !hash.IsEqual(blockHash) {
+		t.Errorf("CloneBytes: hash modified through clone - got: %v, "+
+			"want: %v", hash, blockHash)
+	}
+
+	// Ensure the hash can't be modified through the slice passed to
+	// SetBytes.
+	setBuf := blockHash.CloneBytes()
+	err = hash.SetBytes(setBuf)
+	if err != nil {
+		t.Errorf("SetBytes: %v", err)
+	}
+	setBuf[0] ^= 0xff
+	if !hash.IsEqual(blockHash) {
+		t.Errorf("SetBytes: hash aliases passed slice - got: %v, "+
+			"want: %v", hash, blockHash)
+	}
 
 	// Invalid size for NewShaHash.
 	invalidHash := make([]byte, btcwire.HashSize+1)
@@ -92,6 +127,53 @@ func TestShaHashString(t *testing.T) {
 		t.Errorf("String: wrong hash string - got %v, want %v",
 			hashStr, wantStr)
 	}
+
+	// Ensure the reversed bytes are the big-endian form of the hash.
+	wantBytes, _ := hex.DecodeString(wantStr)
+	reversed := hash.ReverseBytes()
+	if !bytes.Equal(reversed, wantBytes) {
+		t.Errorf("ReverseBytes: wrong bytes - got %x, want %x",
+			reversed, wantBytes)
+	}
+
+	// Ensure the hash can't be modified through the reversed bytes.
+	reversed[btcwire.HashSize-1] ^= 0xff
+	if hash[0] != 0x06 {
+		t.Errorf("ReverseBytes: hash modified through reversed bytes")
+	}
+}
+
+// TestShaHashMarshalText tests that hashes are marshalled to and unmarshalled
+// from text in the form returned by String.
+func TestShaHashMarshalText(t *testing.T) {
+	wantStr := "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+
+	text, err := btcwire.GenesisHash.MarshalText()
+	if err != nil {
+		t.Errorf("MarshalText: %v", err)
+		return
+	}
+	if string(text) != wantStr {
+		t.Errorf("MarshalText: wrong text - got %s, want %s", text,
+			wantStr)
+	}
+
+	var hash btcwire.ShaHash
+	err = hash.UnmarshalText(text)
+	if err != nil {
+		t.Errorf("UnmarshalText: %v", err)
+		return
+	}
+	if !hash.IsEqual(&btcwire.GenesisHash) {
+		t.Errorf("UnmarshalText: wrong hash - got %v, want %v", hash,
+			btcwire.GenesisHash)
+	}
+
+	// Ensure invalid hash strings are rejected.
+	err = hash.UnmarshalText([]byte("banana"))
+	if err == nil {
+		t.Errorf("UnmarshalText: no error for invalid hash string")
+	}
 }
 
 // TestNewShaHashFromStr executes tests against the NewShaHashFromStr function.
@@ -172,3 +254,71 @@ func TestNewShaHashFromStr(t *testing.T) {
 		}
 	}
 }
+
+// TestNewShaHashFromInternalStr executes tests against the
+// NewShaHashFromInternalStr function.
+func TestNewShaHashFromInternalStr(t *testing.T) {
+	tests := []struct {
+		in   string
+		want btcwire.ShaHash
+		err  error
+	}{
+		// Genesis hash.
+		{
+			"6fe28c0ab6f1b372c1a6a246ae63f74f931e8365e15a089c68d6190000000000",
+			btcwire.GenesisHash,
+			nil,
+		},
+
+		// Genesis hash in display order is not reversed.
+		{
+			"000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+			btcwire.ShaHash{
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x19, 0xd6, 0x68,
+				0x9c, 0x08, 0x5a, 0xe1, 0x65, 0x83, 0x1e, 0x93,
+				0x4f, 0xf7, 0x63, 0xae, 0x46, 0xa2, 0xa6, 0xc1,
+				0x72, 0xb3, 0xf1, 0xb6, 0x0a, 0x8c, 0xe2, 0x6f,
+			},
+			nil,
+		},
+
+		// Genesis hash with stripped trailing zeros.
+		{
+			"6fe28c0ab6f1b372c1a6a246ae63f74f931e8365e15a089c68d619",
+			btcwire.ShaHash{},
+			btcwire.ErrHashStrLen,
+		},
+
+		// Hash string that is too long.
+		{
+			"01234567890123456789012345678901234567890123456789012345678912345",
+			btcwire.ShaHash{},
+			btcwire.ErrHashStrSize,
+		},
+
+		// Hash string that is contains non-hex chars.
+		{
+			"g000000000000000000000000000000000000000000000000000000000000000",
+			btcwire.ShaHash{},
+			hex.InvalidByteError('g'),
+		},
+	}
+
+	unexpectedErrStr := "NewShaHashFromInternalStr #%d failed to detect expected error - got: %v want: %v"
+	unexpectedResultStr := "NewShaHashFromInternalStr #%d got: %v want: %v"
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		result, err := btcwire.NewShaHashFromInternalStr(test.in)
+		if err != test.err {
+			t.Errorf(unexpectedErrStr, i, err, test.err)
+			continue
+		} else if err != nil {
+			// Got expected error. Move on to the next test.
+			continue
+		}
+		if !test.want.IsEqual(result) {
+			t.Errorf(unexpectedResultStr, i, result, &test.want)
+			continue
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"container/list"
+)
+
+// ShaHashSet is a set of hashes, such as the hashes of inventory vectors
+// (InvVect) which were recently announced, for efficient membership tests.
+// The zero value is not usable, so use NewShaHashSet or make to create one.
+// Use BoundedShaHashSet when the set must not grow without bound.
+//
+// A ShaHashSet is not safe for concurrent use by multiple goroutines.
+type ShaHashSet map[ShaHash]struct{}
+
+// Add adds the passed hash to the set.
+func (s ShaHashSet) Add(hash ShaHash) {
+	s[hash] = struct{}{}
+}
+
+// Contains returns whether or not the passed hash is in the set.
+func (s ShaHashSet) Contains(hash ShaHash) bool {
+	_, ok := s[hash]
+	return ok
+}
+
+// Remove removes the passed hash from the set.  It does nothing when the hash
+// is not in the set.
+func (s ShaHashSet) Remove(hash ShaHash) {
+	delete(s, hash)
+}
+
+// Len returns the number of hashes in the set.
+func (s ShaHashSet) Len() int {
+	return len(s)
+}
+
+// NewShaHashSet returns a new empty ShaHashSet.
+func NewShaHashSet() ShaHashSet {
+	return make(ShaHashSet)
+}
+
+// BoundedShaHashSet is a set of hashes like ShaHashSet which holds no more
+// than a fixed number of hashes.  Adding a hash to a full set evicts the hash
+// which was added the longest time ago, so it is suitable for remembering
+// recently announced inventory without growing without bound.
+//
+// A BoundedShaHashSet is not safe for concurrent use by multiple goroutines.
+type BoundedShaHashSet struct {
+	capacity int
+	hashes   map[ShaHash]*list.Element
+	order    *list.List // Hashes in insertion order, oldest first
+}
+
+// Add adds the passed hash to the set, evicting the oldest hash when the set
+// is full.  Adding a hash which is already in the set does not change when it
+// will be evicted.
+func (s *BoundedShaHashSet) Add(hash ShaHash) {
+	if _, ok := s.hashes[hash]; ok {
+		return
+	}
+
+	if s.order.Len() >= s.capacity {
+		oldest := s.order.Front()
+		delete(s.hashes, oldest.Value.(ShaHash))
+		s.order.Remove(oldest)
+	}
+	s.hashes[hash] = s.order.PushBack(hash)
+}
+
+// Contains returns whether or not the passed hash is in the set.
+func (s *BoundedShaHashSet) Contains(hash ShaHash) bool {
+	_, ok := s.hashes[hash]
+	return ok
+}
+
+// Remove removes the passed hash from the set.  It does nothing when the hash
+// is not in the set.
+func (s *BoundedShaHashSet) Remove(hash ShaHash) {
+	if elem, ok := s.hashes[hash]; ok {
+		delete(s.hashes, hash)
+		s.order.Remove(elem)
+	}
+}
+
+// Len returns the number of hashes in the set.
+func (s *BoundedShaHashSet) Len() int {
+	return len(s.hashes)
+}
+
+// NewBoundedShaHashSet returns a new empty BoundedShaHashSet which holds no
+// more than the passed number of hashes.  A capacity less than one is treated
+// as one.
+func NewBoundedShaHashSet(capacity int) *BoundedShaHashSet {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &BoundedShaHashSet{
+		capacity: capacity,
+		hashes:   make(map[ShaHash]*list.Element, capacity),
+		order:    list.New(),
+	}
+}
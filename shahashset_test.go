@@ -0,0 +1,96 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"github.com/conformal/btcwire"
+	"testing"
+)
+
+// TestShaHashSet tests the ShaHashSet API.
+func TestShaHashSet(t *testing.T) {
+	hash1 := btcwire.ShaHash{0x01}
+	hash2 := btcwire.ShaHash{0x02}
+
+	set := btcwire.NewShaHashSet()
+	if set.Len() != 0 || set.Contains(hash1) {
+		t.Errorf("NewShaHashSet: set is not empty")
+	}
+
+	// Ensure added hashes are contained and adding one again doesn't
+	// change the set.
+	set.Add(hash1)
+	set.Add(hash2)
+	set.Add(hash1)
+	if !set.Contains(hash1) || !set.Contains(hash2) {
+		t.Errorf("Contains: added hash not contained")
+	}
+	if n := set.Len(); n != 2 {
+		t.Errorf("Len: wrong number of hashes - got %d, want %d", n, 2)
+	}
+
+	// Ensure removed hashes are not contained and removing an unknown
+	// hash does nothing.
+	set.Remove(hash1)
+	set.Remove(btcwire.ShaHash{0x03})
+	if set.Contains(hash1) || !set.Contains(hash2) {
+		t.Errorf("Contains: wrong membership after remove")
+	}
+	if n := set.Len(); n != 1 {
+		t.Errorf("Len: wrong number of hashes - got %d, want %d", n, 1)
+	}
+}
+
+// TestBoundedShaHashSet tests the BoundedShaHashSet API evicts hashes in
+// insertion order.
+func TestBoundedShaHashSet(t *testing.T) {
+	hashes := make([]btcwire.ShaHash, 5)
+	for i := range hashes {
+		hashes[i][0] = byte(i + 1)
+	}
+
+	set := btcwire.NewBoundedShaHashSet(3)
+	for _, hash := range hashes[:3] {
+		set.Add(hash)
+	}
+
+	// Ensure adding a hash which is already contained does not refresh it
+	// and adding a new hash to the full set evicts the oldest.
+	set.Add(hashes[0])
+	set.Add(hashes[3])
+	if set.Contains(hashes[0]) {
+		t.Errorf("Add: oldest hash was not evicted")
+	}
+	for _, hash := range hashes[1:4] {
+		if !set.Contains(hash) {
+			t.Errorf("Contains: hash %v not contained", hash)
+		}
+	}
+	if n := set.Len(); n != 3 {
+		t.Errorf("Len: wrong number of hashes - got %d, want %d", n, 3)
+	}
+
+	// Ensure removing a hash makes room without evicting another.
+	set.Remove(hashes[2])
+	set.Remove(hashes[0])
+	set.Add(hashes[4])
+	for _, hash := range []btcwire.ShaHash{hashes[1], hashes[3], hashes[4]} {
+		if !set.Contains(hash) {
+			t.Errorf("Contains: hash %v not contained after remove",
+				hash)
+		}
+	}
+	if n := set.Len(); n != 3 {
+		t.Errorf("Len: wrong number of hashes - got %d, want %d", n, 3)
+	}
+
+	// Ensure a capacity less than one is treated as one.
+	set = btcwire.NewBoundedShaHashSet(0)
+	set.Add(hashes[0])
+	set.Add(hashes[1])
+	if set.Contains(hashes[0]) || !set.Contains(hashes[1]) {
+		t.Errorf("Add: wrong membership for minimum capacity")
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// SigHashType represents the hash type bits at the end of a signature which
+// determine which parts of a transaction the signature commits to.
+type SigHashType uint32
+
+// Constants used to indicate the signature hash type.
+const (
+	SigHashOld          SigHashType = 0x0
+	SigHashAll          SigHashType = 0x1
+	SigHashNone         SigHashType = 0x2
+	SigHashSingle       SigHashType = 0x3
+	SigHashAnyOneCanPay SigHashType = 0x80
+
+	// sigHashMask defines the number of bits of the hash type which are
+	// used to identify which outputs are signed.
+	sigHashMask = 0x1f
+)
+
+// sigHashSingleBugHash is the value used in place of a signature hash for
+// SigHashSingle when there is no output at the same index as the input being
+// signed.  It is the number one encoded as a little endian 256-bit integer.
+var sigHashSingleBugHash = ShaHash{0x01}
+
+// SignatureHashPreimage returns the bytes which are double sha256 hashed to
+// produce the legacy (pre-segwit) signature hash for the input at index idx
+// using subScript as the script of that input and the passed hash type.
+//
+// The preimage is the legacy serialization of a modified copy of the
+// transaction followed by the hash type as a 4-byte little endian integer.  In
+// the copy, the script of every input other than idx is empty and the script
+// of input idx is subScript, which must already have any OP_CODESEPARATOR
+// opcodes removed.  Further modifications depend on the hash type:
+//
+//   - SigHashNone removes all outputs and sets the sequence of all other
+//     inputs to 0
+//   - SigHashSingle keeps only the outputs up to and including idx, blanks all
+//     but the last of them to a value of -1 with an empty script, and sets the
+//     sequence of all other inputs to 0
+//   - SigHashAnyOneCanPay additionally removes all inputs other than idx
+//
+// Due to a long-standing bug in the reference implementation, signing with
+// SigHashSingle for an input which does not have an output at the same index
+// signs the number one instead of a hash.  In that case there is no preimage,
+// so the 32-byte special hash itself is returned and must be signed as is.
+// Use SignatureHash to avoid handling this case separately.
+func (msg *MsgTx) SignatureHashPreimage(idx int, subScript []byte,
+	hashType SigHashType) ([]byte, error) {
+
+	if idx < 0 || idx >= len(msg.TxIn) {
+		return nil, fmt.Errorf("SignatureHashPreimage: input index %d "+
+			"out of range [inputs %d]", idx, len(msg.TxIn))
+	}
+
+	if hashType&sigHashMask == SigHashSingle && idx >= len(msg.TxOut) {
+		return sigHashSingleBugHash.Bytes(), nil
+	}
+
+	// Make a shallow copy of the transaction with new inputs and outputs
+	// so the modifications don't affect the original.
+	txCopy := MsgTx{
+		Version:  msg.Version,
+		TxIn:     make([]*TxIn, 0, len(msg.TxIn)),
+		TxOut:    make([]*TxOut, 0, len(msg.TxOut)),
+		LockTime: msg.LockTime,
+	}
+	for i, txIn := range msg.TxIn {
+		var script []byte
+		if i == idx {
+			script = subScript
+		}
+		txCopy.TxIn = append(txCopy.TxIn, &TxIn{
+			PreviousOutpoint: txIn.PreviousOutpoint,
+			SignatureScript:  script,
+			Sequence:         txIn.Sequence,
+		})
+	}
+	txCopy.TxOut = append(txCopy.TxOut, msg.TxOut...)
+
+	switch hashType & sigHashMask {
+	case SigHashNone:
+		txCopy.TxOut = txCopy.TxOut[:0]
+		for i, txIn := range txCopy.TxIn {
+			if i != idx {
+				txIn.Sequence = 0
+			}
+		}
+
+	case SigHashSingle:
+		txCopy.TxOut = txCopy.TxOut[:idx+1]
+		for i := 0; i < idx; i++ {
+			txCopy.TxOut[i] = &TxOut{Value: -1}
+		}
+		for i, txIn := range txCopy.TxIn {
+			if i != idx {
+				txIn.Sequence = 0
+			}
+		}
+
+	default:
+		// SigHashAll and any unknown hash types sign all outputs.
+	}
+
+	if hashType&SigHashAnyOneCanPay != 0 {
+		txCopy.TxIn = txCopy.TxIn[idx : idx+1]
+	}
+
+	// Ignore the error returns since the only way the encode could fail
+	// is being out of memory or due to nil pointers, both of which would
+	// cause a run-time panic.
+	var buf bytes.Buffer
+	_ = txCopy.btcEncode(&buf, ProtocolVersion, false)
+	_ = writeElement(&buf, uint32(hashType))
+	return buf.Bytes(), nil
+}
+
+// SignatureHash returns the legacy (pre-segwit) signature hash for the input
+// at index idx using subScript as the script of that input and the passed hash
+// type.  It is the double sha256 of the bytes returned by
+// SignatureHashPreimage, or the special hash of the number one in the case of
+// the SigHashSingle bug.  See SignatureHashPreimage for details.
+func (msg *MsgTx) SignatureHash(idx int, subScript []byte,
+	hashType SigHashType) (ShaHash, error) {
+
+	preimage, err := msg.SignatureHashPreimage(idx, subScript, hashType)
+	if err != nil {
+		return ShaHash{}, err
+	}
+
+	if hashType&sigHashMask == SigHashSingle && idx >= len(msg.TxOut) {
+		return sigHashSingleBugHash, nil
+	}
+	return DoubleSha256SH(preimage), nil
+}
+
+// TxSigHashes houses the partial hashes of a transaction which are shared by
+// the BIP0143 signature hashes of all of its inputs.  Computing them once with
+// NewTxSigHashes avoids the quadratic hashing of the legacy signature hash
+// algorithm when signing or verifying every input of a transaction.
+type TxSigHashes struct {
+	HashPrevouts ShaHash // Double sha256 of all previous outpoints
+	HashSequence ShaHash // Double sha256 of all input sequence numbers
+	HashOutputs  ShaHash // Double sha256 of all outputs
+}
+
+// NewTxSigHashes computes and returns the partial BIP0143 signature hashes of
+// the passed transaction.
+func NewTxSigHashes(tx *MsgTx) *TxSigHashes {
+	// Ignore the error returns since the only way the encode could fail
+	// is being out of memory or due to nil pointers, both of which would
+	// cause a run-time panic.
+	var prevouts, sequences, outputs bytes.Buffer
+	for _, txIn := range tx.TxIn {
+		_ = writeOutPoint(&prevouts, ProtocolVersion, tx.Version,
+			&txIn.PreviousOutpoint)
+		_ = writeElement(&sequences, txIn.Sequence)
+	}
+	for _, txOut := range tx.TxOut {
+		_ = writeTxOut(&outputs, ProtocolVersion, txOut)
+	}
+
+	return &TxSigHashes{
+		HashPrevouts: DoubleSha256SH(prevouts.Bytes()),
+		HashSequence: DoubleSha256SH(sequences.Bytes()),
+		HashOutputs:  DoubleSha256SH(outputs.Bytes()),
+	}
+}
+
+// WitnessSignatureHashPreimage returns the bytes which are double sha256
+// hashed to produce the BIP0143 signature hash for the segregated witness
+// input at index idx.  The subScript is the script code of the input, which
+// for a pay-to-witness-pubkey-hash input is the equivalent pay-to-pubkey-hash
+// script, and amount is the value of the output it spends.  The partial hashes
+// in sigHashes must have been computed from the same transaction with
+// NewTxSigHashes.
+//
+// The preimage consists of the version, the hash of all previous outpoints,
+// the hash of all input sequence numbers, the outpoint, script code, amount,
+// and sequence number of the input, the hash of the outputs, the lock time, and
+// the hash type.  The hashes of the previous outpoints and sequence numbers,
+// and the hash of the outputs are replaced by zero depending on the hash type
+// as described by BIP0143.
+func (msg *MsgTx) WitnessSignatureHashPreimage(sigHashes *TxSigHashes, idx int,
+	subScript []byte, amount int64, hashType SigHashType) ([]byte, error) {
+
+	if idx < 0 || idx >= len(msg.TxIn) {
+		return nil, fmt.Errorf("WitnessSignatureHashPreimage: input "+
+			"index %d out of range [inputs %d]", idx, len(msg.TxIn))
+	}
+
+	anyoneCanPay := hashType&SigHashAnyOneCanPay != 0
+	baseType := hashType & sigHashMask
+
+	var hashPrevouts, hashSequence, hashOutputs ShaHash
+	if !anyoneCanPay {
+		hashPrevouts = sigHashes.HashPrevouts
+	}
+	if !anyoneCanPay && baseType != SigHashSingle && baseType != SigHashNone {
+		hashSequence = sigHashes.HashSequence
+	}
+	switch {
+	case baseType != SigHashSingle && baseType != SigHashNone:
+		hashOutputs = sigHashes.HashOutputs
+
+	case baseType == SigHashSingle && idx < len(msg.TxOut):
+		var buf bytes.Buffer
+		_ = writeTxOut(&buf, ProtocolVersion, msg.TxOut[idx])
+		hashOutputs = DoubleSha256SH(buf.Bytes())
+	}
+
+	// Ignore the error returns since the only way the encode could fail
+	// is being out of memory or due to nil pointers, both of which would
+	// cause a run-time panic.
+	txIn := msg.TxIn[idx]
+	var buf bytes.Buffer
+	_ = writeElements(&buf, msg.Version, hashPrevouts, hashSequence)
+	_ = writeOutPoint(&buf, ProtocolVersion, msg.Version,
+		&txIn.PreviousOutpoint)
+	_ = writeVarBytes(&buf, ProtocolVersion, subScript)
+	_ = writeElements(&buf, amount, txIn.Sequence, hashOutputs,
+		msg.LockTime, uint32(hashType))
+	return buf.Bytes(), nil
+}
+
+// WitnessSignatureHashWithCache returns the BIP0143 signature hash for the
+// segregated witness input at index idx using the previously computed partial
+// hashes in sigHashes.  This should be preferred over WitnessSignatureHash when
+// computing the signature hashes of several inputs of the same transaction.
+// See WitnessSignatureHashPreimage for details of the other parameters.
+func (msg *MsgTx) WitnessSignatureHashWithCache(sigHashes *TxSigHashes, idx int,
+	subScript []byte, amount int64, hashType SigHashType) ([]byte, error) {
+
+	preimage, err := msg.WitnessSignatureHashPreimage(sigHashes, idx,
+		subScript, amount, hashType)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := DoubleSha256SH(preimage)
+	return hash[:], nil
+}
+
+// WitnessSignatureHash returns the BIP0143 signature hash for the segregated
+// witness input at index idx.  See WitnessSignatureHashPreimage for details of
+// the parameters.
+func (msg *MsgTx) WitnessSignatureHash(idx int, subScript []byte, amount int64,
+	hashType SigHashType) ([]byte, error) {
+
+	return msg.WitnessSignatureHashWithCache(NewTxSigHashes(msg), idx,
+		subScript, amount, hashType)
+}
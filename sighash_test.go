@@ -0,0 +1,380 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/conformal/btcwire"
+	"github.com/davecgh/go-spew/spew"
+	"reflect"
+	"testing"
+)
+
+// sigHashTx is a transaction with two inputs and two outputs which is used in
+// the signature hash tests.
+var sigHashTx = &btcwire.MsgTx{
+	Version: 1,
+	TxIn: []*btcwire.TxIn{
+		{
+			PreviousOutpoint: btcwire.OutPoint{
+				Hash:  btcwire.ShaHash{0x01},
+				Index: 0,
+			},
+			SignatureScript: []byte{0xaa},
+			Sequence:        0xffffffff,
+		},
+		{
+			PreviousOutpoint: btcwire.OutPoint{
+				Hash:  btcwire.ShaHash{0x02},
+				Index: 1,
+			},
+			SignatureScript: []byte{0xbb},
+			Sequence:        0xfffffffe,
+		},
+	},
+	TxOut: []*btcwire.TxOut{
+		{Value: 0x10, PkScript: []byte{0x51}},
+		{Value: 0x20, PkScript: []byte{0x52}},
+	},
+	LockTime: 0,
+}
+
+// joinBytes concatenates all of the passed byte slices.
+func joinBytes(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// TestSignatureHashPreimage tests the legacy signature hash preimage for the
+// various signature hash types.
+func TestSignatureHashPreimage(t *testing.T) {
+	subScript := []byte{0x76, 0xa9}
+
+	// Serialized pieces of the modified transactions.
+	version := []byte{0x01, 0x00, 0x00, 0x00}
+	prevOut0 := joinBytes([]byte{0x01}, make([]byte, 31),
+		[]byte{0x00, 0x00, 0x00, 0x00})
+	prevOut1 := joinBytes([]byte{0x02}, make([]byte, 31),
+		[]byte{0x01, 0x00, 0x00, 0x00})
+	emptyScript := []byte{0x00}
+	signedScript := []byte{0x02, 0x76, 0xa9}
+	seqFinal := []byte{0xff, 0xff, 0xff, 0xff}
+	seq1 := []byte{0xfe, 0xff, 0xff, 0xff}
+	seqZero := []byte{0x00, 0x00, 0x00, 0x00}
+	out0 := []byte{0x10, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x51}
+	out1 := []byte{0x20, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x52}
+	blankOut := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00}
+	lockTime := []byte{0x00, 0x00, 0x00, 0x00}
+
+	// Transaction with a single output to exercise the SigHashSingle bug.
+	singleOutTx := sigHashTx.Copy()
+	singleOutTx.TxOut = singleOutTx.TxOut[:1]
+	bugHash := joinBytes([]byte{0x01}, make([]byte, 31))
+
+	tests := []struct {
+		name     string
+		tx       *btcwire.MsgTx
+		idx      int
+		hashType btcwire.SigHashType
+		want     []byte
+	}{
+		{
+			"all second input",
+			sigHashTx, 1, btcwire.SigHashAll,
+			joinBytes(version, []byte{0x02},
+				prevOut0, emptyScript, seqFinal,
+				prevOut1, signedScript, seq1,
+				[]byte{0x02}, out0, out1, lockTime,
+				[]byte{0x01, 0x00, 0x00, 0x00}),
+		},
+		{
+			"all first input",
+			sigHashTx, 0, btcwire.SigHashAll,
+			joinBytes(version, []byte{0x02},
+				prevOut0, signedScript, seqFinal,
+				prevOut1, emptyScript, seq1,
+				[]byte{0x02}, out0, out1, lockTime,
+				[]byte{0x01, 0x00, 0x00, 0x00}),
+		},
+		{
+			"none second input",
+			sigHashTx, 1, btcwire.SigHashNone,
+			joinBytes(version, []byte{0x02},
+				prevOut0, emptyScript, seqZero,
+				prevOut1, signedScript, seq1,
+				[]byte{0x00}, lockTime,
+				[]byte{0x02, 0x00, 0x00, 0x00}),
+		},
+		{
+			"single second input",
+			sigHashTx, 1, btcwire.SigHashSingle,
+			joinBytes(version, []byte{0x02},
+				prevOut0, emptyScript, seqZero,
+				prevOut1, signedScript, seq1,
+				[]byte{0x02}, blankOut, out1, lockTime,
+				[]byte{0x03, 0x00, 0x00, 0x00}),
+		},
+		{
+			"single first input",
+			sigHashTx, 0, btcwire.SigHashSingle,
+			joinBytes(version, []byte{0x02},
+				prevOut0, signedScript, seqFinal,
+				prevOut1, emptyScript, seqZero,
+				[]byte{0x01}, out0, lockTime,
+				[]byte{0x03, 0x00, 0x00, 0x00}),
+		},
+		{
+			"all anyonecanpay first input",
+			sigHashTx, 0, btcwire.SigHashAll | btcwire.SigHashAnyOneCanPay,
+			joinBytes(version, []byte{0x01},
+				prevOut0, signedScript, seqFinal,
+				[]byte{0x02}, out0, out1, lockTime,
+				[]byte{0x81, 0x00, 0x00, 0x00}),
+		},
+		{
+			"none anyonecanpay second input",
+			sigHashTx, 1, btcwire.SigHashNone | btcwire.SigHashAnyOneCanPay,
+			joinBytes(version, []byte{0x01},
+				prevOut1, signedScript, seq1,
+				[]byte{0x00}, lockTime,
+				[]byte{0x82, 0x00, 0x00, 0x00}),
+		},
+		{
+			"single bug",
+			singleOutTx, 1, btcwire.SigHashSingle,
+			bugHash,
+		},
+		{
+			"single anyonecanpay bug",
+			singleOutTx, 1, btcwire.SigHashSingle | btcwire.SigHashAnyOneCanPay,
+			bugHash,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		orig := test.tx.Copy()
+		preimage, err := test.tx.SignatureHashPreimage(test.idx,
+			subScript, test.hashType)
+		if err != nil {
+			t.Errorf("SignatureHashPreimage #%d (%s) error %v", i,
+				test.name, err)
+			continue
+		}
+		if !bytes.Equal(preimage, test.want) {
+			t.Errorf("SignatureHashPreimage #%d (%s)\n got: %s "+
+				"want: %s", i, test.name, spew.Sdump(preimage),
+				spew.Sdump(test.want))
+			continue
+		}
+
+		// Ensure the transaction was not modified.
+		if !reflect.DeepEqual(test.tx.Copy(), orig) {
+			t.Errorf("SignatureHashPreimage #%d (%s) modified the "+
+				"transaction", i, test.name)
+			continue
+		}
+
+		// Ensure the signature hash is the hash of the preimage or
+		// the special hash in the case of the SigHashSingle bug.
+		hash, err := test.tx.SignatureHash(test.idx, subScript,
+			test.hashType)
+		if err != nil {
+			t.Errorf("SignatureHash #%d (%s) error %v", i,
+				test.name, err)
+			continue
+		}
+		want := btcwire.DoubleSha256SH(preimage)
+		if bytes.Equal(test.want, bugHash) {
+			want.SetBytes(bugHash)
+		}
+		if !hash.IsEqual(&want) {
+			t.Errorf("SignatureHash #%d (%s) wrong hash - got %v, "+
+				"want %v", i, test.name, hash, want)
+			continue
+		}
+	}
+}
+
+// TestSignatureHashPreimageErrors ensures the signature hash functions return
+// an error for input indices which are out of range.
+func TestSignatureHashPreimageErrors(t *testing.T) {
+	tests := []int{-1, 2, 100}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, idx := range tests {
+		_, err := sigHashTx.SignatureHashPreimage(idx, nil,
+			btcwire.SigHashAll)
+		if err == nil {
+			t.Errorf("SignatureHashPreimage #%d did not return an "+
+				"error for index %d", i, idx)
+		}
+
+		_, err = sigHashTx.SignatureHash(idx, nil, btcwire.SigHashAll)
+		if err == nil {
+			t.Errorf("SignatureHash #%d did not return an error "+
+				"for index %d", i, idx)
+		}
+	}
+}
+
+// TestWitnessSignatureHash tests the BIP0143 signature hash against the
+// native pay-to-witness-pubkey-hash example from BIP0143.
+func TestWitnessSignatureHash(t *testing.T) {
+	txBytes, _ := hex.DecodeString("0100000002fff7f7881a8099afa6940d42d1e" +
+		"7f6362bec38171ea3edf433541db4e4ad969f0000000000eeffffffef51e1b8" +
+		"04cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000" +
+		"000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a" +
+		"783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b" +
+		"2d50ce2f0167faa815988ac11000000")
+	var tx btcwire.MsgTx
+	err := tx.Deserialize(bytes.NewReader(txBytes))
+	if err != nil {
+		t.Fatalf("Deserialize: %v", err)
+	}
+
+	// Ensure the partial hashes are the expected values.
+	sigHashes := btcwire.NewTxSigHashes(&tx)
+	wantSigHashes := &btcwire.TxSigHashes{
+		HashPrevouts: hexToShaHash("96b827c8483d4e9b96712b6713a7b68d6e80" +
+			"03a781feba36c31143470b4efd37"),
+		HashSequence: hexToShaHash("52b0a642eea2fb7ae638c36f6252b6750293" +
+			"dbe574a806984b8e4d8548339a3b"),
+		HashOutputs: hexToShaHash("863ef3e1a92afbfdb97f31ad0fc7683ee943" +
+			"e9abcf2501590ff8f6551f47e5e5"),
+	}
+	if !reflect.DeepEqual(sigHashes, wantSigHashes) {
+		t.Errorf("NewTxSigHashes: wrong hashes\n got: %s want: %s",
+			spew.Sdump(sigHashes), spew.Sdump(wantSigHashes))
+	}
+
+	// The script code of the second input and the amount it spends.
+	subScript, _ := hex.DecodeString("76a9141d0f172a0ecb48aee1be1f2687d29" +
+		"63ae33f71a188ac")
+	amount := int64(600000000)
+	want, _ := hex.DecodeString("c37af31116d1b27caf68aae9e3ac82f1477929" +
+		"014d5b917657d0eb49478cb670")
+
+	hash, err := tx.WitnessSignatureHash(1, subScript, amount,
+		btcwire.SigHashAll)
+	if err != nil {
+		t.Fatalf("WitnessSignatureHash: %v", err)
+	}
+	if !bytes.Equal(hash, want) {
+		t.Errorf("WitnessSignatureHash: wrong hash - got %x, want %x",
+			hash, want)
+	}
+
+	hash, err = tx.WitnessSignatureHashWithCache(sigHashes, 1, subScript,
+		amount, btcwire.SigHashAll)
+	if err != nil {
+		t.Fatalf("WitnessSignatureHashWithCache: %v", err)
+	}
+	if !bytes.Equal(hash, want) {
+		t.Errorf("WitnessSignatureHashWithCache: wrong hash - got %x, "+
+			"want %x", hash, want)
+	}
+
+	// Ensure an out of range input index returns an error.
+	_, err = tx.WitnessSignatureHash(2, subScript, amount,
+		btcwire.SigHashAll)
+	if err == nil {
+		t.Errorf("WitnessSignatureHash: did not return an error for " +
+			"out of range index")
+	}
+}
+
+// TestWitnessSignatureHashPreimage tests the BIP0143 signature hash preimage
+// omits the partial hashes as required by the various signature hash types.
+func TestWitnessSignatureHashPreimage(t *testing.T) {
+	subScript := []byte{0x76, 0xa9}
+	amount := int64(0x0102)
+	sigHashes := btcwire.NewTxSigHashes(sigHashTx)
+
+	// Serialized pieces of the preimage for the second input.
+	version := []byte{0x01, 0x00, 0x00, 0x00}
+	zeroHash := make([]byte, 32)
+	outPoint := joinBytes([]byte{0x02}, make([]byte, 31),
+		[]byte{0x01, 0x00, 0x00, 0x00})
+	scriptCode := []byte{0x02, 0x76, 0xa9}
+	amountBytes := []byte{0x02, 0x01, 0, 0, 0, 0, 0, 0}
+	sequence := []byte{0xfe, 0xff, 0xff, 0xff}
+	lockTime := []byte{0x00, 0x00, 0x00, 0x00}
+	singleOutput := btcwire.DoubleSha256([]byte{
+		0x20, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x52})
+
+	tests := []struct {
+		name         string
+		hashType     btcwire.SigHashType
+		hashPrevouts []byte
+		hashSequence []byte
+		hashOutputs  []byte
+	}{
+		{
+			"all", btcwire.SigHashAll,
+			sigHashes.HashPrevouts[:], sigHashes.HashSequence[:],
+			sigHashes.HashOutputs[:],
+		},
+		{
+			"none", btcwire.SigHashNone,
+			sigHashes.HashPrevouts[:], zeroHash, zeroHash,
+		},
+		{
+			"single", btcwire.SigHashSingle,
+			sigHashes.HashPrevouts[:], zeroHash, singleOutput,
+		},
+		{
+			"all anyonecanpay",
+			btcwire.SigHashAll | btcwire.SigHashAnyOneCanPay,
+			zeroHash, zeroHash, sigHashes.HashOutputs[:],
+		},
+		{
+			"single anyonecanpay",
+			btcwire.SigHashSingle | btcwire.SigHashAnyOneCanPay,
+			zeroHash, zeroHash, singleOutput,
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		preimage, err := sigHashTx.WitnessSignatureHashPreimage(sigHashes,
+			1, subScript, amount, test.hashType)
+		if err != nil {
+			t.Errorf("WitnessSignatureHashPreimage #%d (%s) error %v",
+				i, test.name, err)
+			continue
+		}
+
+		var hashType [4]byte
+		binary.LittleEndian.PutUint32(hashType[:], uint32(test.hashType))
+		want := joinBytes(version, test.hashPrevouts, test.hashSequence,
+			outPoint, scriptCode, amountBytes, sequence,
+			test.hashOutputs, lockTime, hashType[:])
+		if !bytes.Equal(preimage, want) {
+			t.Errorf("WitnessSignatureHashPreimage #%d (%s)\n got: %s "+
+				"want: %s", i, test.name, spew.Sdump(preimage),
+				spew.Sdump(want))
+			continue
+		}
+	}
+}
+
+// hexToShaHash converts the passed hex string, which is in wire byte order as
+// opposed to the reversed order used by NewShaHashFromStr, into a ShaHash.  It
+// panics on an error since it must only be called with hard-coded, and
+// therefore known good, hashes.
+func hexToShaHash(s string) btcwire.ShaHash {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic("invalid hex in source file: " + s)
+	}
+	var hash btcwire.ShaHash
+	err = hash.SetBytes(b)
+	if err != nil {
+		panic("invalid hash in source file: " + s)
+	}
+	return hash
+}
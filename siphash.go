@@ -0,0 +1,100 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// ShortTxIDMask is the mask applied to the SipHash-2-4 of a transaction to
+// produce a BIP0152 short transaction id, which is only 6 bytes.
+const ShortTxIDMask = 0xffffffffffff
+
+// sipRound performs a single SipRound on the passed state.
+func sipRound(v0, v1, v2, v3 uint64) (uint64, uint64, uint64, uint64) {
+	v0 += v1
+	v1 = v1<<13 | v1>>(64-13)
+	v1 ^= v0
+	v0 = v0<<32 | v0>>(64-32)
+	v2 += v3
+	v3 = v3<<16 | v3>>(64-16)
+	v3 ^= v2
+	v0 += v3
+	v3 = v3<<21 | v3>>(64-21)
+	v3 ^= v0
+	v2 += v1
+	v1 = v1<<17 | v1>>(64-17)
+	v1 ^= v2
+	v2 = v2<<32 | v2>>(64-32)
+	return v0, v1, v2, v3
+}
+
+// SipHash24 returns the SipHash-2-4 of data using the 128-bit key formed by k0
+// and k1, where k0 holds the first 8 bytes of the key interpreted as a little
+// endian integer and k1 holds the last 8 bytes.
+func SipHash24(k0, k1 uint64, data []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	// Compress each full 8 byte block of the data.
+	length := len(data)
+	for len(data) >= 8 {
+		m := binary.LittleEndian.Uint64(data)
+		v3 ^= m
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0 ^= m
+		data = data[8:]
+	}
+
+	// The final block contains the remaining bytes with the low byte of
+	// the total length in its most significant byte.
+	m := uint64(length) << 56
+	for i, b := range data {
+		m |= uint64(b) << (8 * uint(i))
+	}
+	v3 ^= m
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0 ^= m
+
+	// Finalization.
+	v2 ^= 0xff
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	return v0 ^ v1 ^ v2 ^ v3
+}
+
+// shortTxIDKeys returns the SipHash-2-4 keys used to compute the BIP0152 short
+// transaction ids for a compact block with the passed header and nonce.  The
+// keys are the first two little endian integers of the single sha256 of the
+// serialized block header followed by the nonce as a little endian integer.
+func shortTxIDKeys(header *BlockHeader, nonce uint64) (uint64, uint64) {
+	// Ignore the error returns since there is no way the encode could
+	// fail except being out of memory which would cause a run-time panic.
+	var buf bytes.Buffer
+	_ = writeBlockHeader(&buf, ProtocolVersion, header)
+	var b [blockHashLen + 8]byte
+	copy(b[:], buf.Bytes()[0:blockHashLen])
+	binary.LittleEndian.PutUint64(b[blockHashLen:], nonce)
+
+	sum := sha256.Sum256(b[:])
+	return binary.LittleEndian.Uint64(sum[0:8]),
+		binary.LittleEndian.Uint64(sum[8:16])
+}
+
+// ShortTxID returns the 6-byte BIP0152 short transaction id of the transaction
+// with the passed witness hash for a compact block with the passed header and
+// nonce.  The id is returned in the low 48 bits of the result.
+func ShortTxID(header *BlockHeader, nonce uint64, wtxid *ShaHash) uint64 {
+	k0, k1 := shortTxIDKeys(header, nonce)
+	return SipHash24(k0, k1, wtxid[:]) & ShortTxIDMask
+}
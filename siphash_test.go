@@ -0,0 +1,88 @@
+// Copyright (c) 2013 Conformal Systems LLC.
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package btcwire_test
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/conformal/btcwire"
+	"testing"
+)
+
+// TestSipHash24 tests the SipHash-2-4 implementation against the test vectors
+// from the reference implementation which use the key 00 01 02 ... 0f and the
+// messages of increasing length 00, 00 01, 00 01 02, and so on.
+func TestSipHash24(t *testing.T) {
+	tests := []uint64{
+		0x726fdb47dd0e0e31, // Empty message
+		0x74f839c593dc67fd,
+		0x0d6c8009d9a94f5a,
+		0x85676696d7fb7e2d,
+		0xcf2794e0277187b7,
+		0x18765564cd99a68d,
+		0xcbc9466e58fee3ce,
+		0xab0200f58b01d137,
+		0x93f5f5799a932462, // Exactly one block
+		0x9e0082df0ba9e4b0,
+		0x7a5dbbc594ddb9f3,
+		0xf4b32f46226bada7,
+		0x751e8fbc860ee5fb,
+		0x14ea5627c0843d90,
+		0xf723ca908e7af2ee,
+		0xa129ca6149be45e5,
+		0x3f2acc7f57c29bdb, // Exactly two blocks
+	}
+
+	k0 := uint64(0x0706050403020100)
+	k1 := uint64(0x0f0e0d0c0b0a0908)
+	data := make([]byte, len(tests))
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, want := range tests {
+		got := btcwire.SipHash24(k0, k1, data[:i])
+		if got != want {
+			t.Errorf("SipHash24 #%d wrong hash - got %016x, want %016x",
+				i, got, want)
+			continue
+		}
+	}
+}
+
+// TestShortTxID ensures BIP0152 short transaction ids are derived from the
+// header and nonce as expected.
+func TestShortTxID(t *testing.T) {
+	nonce := uint64(0x0123456789abcdef)
+	wtxid, err := witnessTx.WTxSha()
+	if err != nil {
+		t.Errorf("WTxSha: %v", err)
+		return
+	}
+
+	// The keys are the first two little endian integers of the single
+	// sha256 of the 80-byte header followed by the little endian nonce.
+	var nonceBytes [8]byte
+	binary.LittleEndian.PutUint64(nonceBytes[:], nonce)
+	sum := sha256.Sum256(joinBytes(blockOneBytes[0:80], nonceBytes[:]))
+	k0 := binary.LittleEndian.Uint64(sum[0:8])
+	k1 := binary.LittleEndian.Uint64(sum[8:16])
+	want := btcwire.SipHash24(k0, k1, wtxid[:]) & 0xffffffffffff
+
+	got := btcwire.ShortTxID(&blockOne.Header, nonce, &wtxid)
+	if got != want {
+		t.Errorf("ShortTxID: wrong id - got %012x, want %012x", got,
+			want)
+	}
+	if got>>48 != 0 {
+		t.Errorf("ShortTxID: id %x is larger than 6 bytes", got)
+	}
+
+	// Ensure a different nonce results in a different id.
+	if other := btcwire.ShortTxID(&blockOne.Header, nonce+1, &wtxid); other == got {
+		t.Errorf("ShortTxID: same id %012x for different nonces", got)
+	}
+}